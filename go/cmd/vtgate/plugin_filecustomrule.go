@@ -0,0 +1,19 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// Imports and register the file custom rule source
+
+import (
+	"github.com/youtube/vitess/go/vt/queryrules/filecustomrule"
+	"github.com/youtube/vitess/go/vt/servenv"
+	"github.com/youtube/vitess/go/vt/vtgate"
+)
+
+func init() {
+	servenv.OnRun(func() {
+		filecustomrule.ActivateFileCustomRules(vtgate.QueryRuleSink)
+	})
+}
@@ -7,5 +7,12 @@ package main
 // Imports and register the file custom rule source
 
 import (
-	_ "github.com/youtube/vitess/go/vt/tabletserver/customrule/filecustomrule"
+	"github.com/youtube/vitess/go/vt/queryrules/filecustomrule"
+	"github.com/youtube/vitess/go/vt/tabletserver"
 )
+
+func init() {
+	tabletserver.QueryServiceControlRegisterFunctions = append(tabletserver.QueryServiceControlRegisterFunctions, func(qsc tabletserver.QueryServiceControl) {
+		filecustomrule.ActivateFileCustomRules(tabletserver.NewQueryRuleSink(qsc))
+	})
+}
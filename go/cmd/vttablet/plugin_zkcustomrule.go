@@ -7,5 +7,12 @@ package main
 // Imports and register the zookeeper custom rule source
 
 import (
-	_ "github.com/youtube/vitess/go/vt/tabletserver/customrule/zkcustomrule"
+	"github.com/youtube/vitess/go/vt/queryrules/zkcustomrule"
+	"github.com/youtube/vitess/go/vt/tabletserver"
 )
+
+func init() {
+	tabletserver.QueryServiceControlRegisterFunctions = append(tabletserver.QueryServiceControlRegisterFunctions, func(qsc tabletserver.QueryServiceControl) {
+		zkcustomrule.ActivateZkCustomRules(tabletserver.NewQueryRuleSink(qsc))
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlconn
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/youtube/vitess/go/jscfg"
+)
+
+// AuthServer authenticates the users of a Listener
+// with the mysql_native_password method.
+type AuthServer interface {
+	// ValidateHash returns nil if authResponse is the scrambled
+	// password of user, for the salt sent in the handshake.
+	// It's called concurrently for multiple connections.
+	ValidateHash(salt []byte, user string, authResponse []byte) error
+}
+
+// AuthServerNone accepts any user, with any password.
+type AuthServerNone struct{}
+
+// ValidateHash is part of the AuthServer interface.
+func (a AuthServerNone) ValidateHash(salt []byte, user string, authResponse []byte) error {
+	return nil
+}
+
+// AuthServerStatic authenticates the users of a static list.
+// Each user can have multiple passwords, so that they can be
+// rotated without downtime.
+type AuthServerStatic struct {
+	Entries map[string][]string
+}
+
+// NewAuthServerStatic reads the users and their passwords from a
+// JSON file, in the same format as the db-credentials-file:
+//   {"user": ["password1", "password2"]}
+func NewAuthServerStatic(file string) (*AuthServerStatic, error) {
+	a := &AuthServerStatic{}
+	if err := jscfg.ReadJSON(file, &a.Entries); err != nil {
+		return nil, fmt.Errorf("NewAuthServerStatic: %v", err)
+	}
+	return a, nil
+}
+
+// ValidateHash is part of the AuthServer interface.
+func (a *AuthServerStatic) ValidateHash(salt []byte, user string, authResponse []byte) error {
+	for _, password := range a.Entries[user] {
+		if subtle.ConstantTimeCompare(ScramblePassword(salt, []byte(password)), authResponse) == 1 {
+			return nil
+		}
+	}
+	return NewSQLError(ERAccessDeniedError, SSAccessDeniedError, "Access denied for user '%v'", user)
+}
+
+// ScramblePassword computes the mysql_native_password hash that a
+// client sends for password:
+//   SHA1(password) XOR SHA1(salt + SHA1(SHA1(password)))
+// An empty password is sent as an empty hash.
+func ScramblePassword(salt, password []byte) []byte {
+	if len(password) == 0 {
+		return nil
+	}
+	crypt := sha1.New()
+	crypt.Write(password)
+	stage1 := crypt.Sum(nil)
+
+	crypt.Reset()
+	crypt.Write(stage1)
+	stage2 := crypt.Sum(nil)
+
+	crypt.Reset()
+	crypt.Write(salt)
+	crypt.Write(stage2)
+	scramble := crypt.Sum(nil)
+	for i := range scramble {
+		scramble[i] ^= stage1[i]
+	}
+	return scramble
+}
+
+// newSalt returns the 20 random bytes of the auth-plugin-data.
+// Like MySQL, it only uses 7-bit characters other than NUL and '$'.
+func newSalt() ([]byte, error) {
+	salt := make([]byte, 20)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	for i := range salt {
+		salt[i] &= 0x7f
+		if salt[i] == 0 || salt[i] == '$' {
+			salt[i]++
+		}
+	}
+	return salt, nil
+}
@@ -0,0 +1,243 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlconn
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+)
+
+const connBufferSize = 16 * 1024
+
+// maxHandshakePacketSize is the longest payload read before the
+// client is authenticated. Handshake responses are small, and
+// unauthenticated clients mustn't make the server allocate more.
+const maxHandshakePacketSize = 64 * 1024
+
+// Conn is a connection between a client and the server. It reads
+// and writes packets, keeping track of their sequence numbers.
+// It's not thread safe: it's used by the go routine that serves
+// the connection, and by the Handler methods it calls.
+type Conn struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	writer   *bufio.Writer
+	sequence uint8
+
+	// ConnectionID is the id of the connection, unique to the Listener.
+	ConnectionID uint32
+
+	// Capabilities are the capabilities of the client.
+	Capabilities uint32
+
+	// CharacterSet is the character set requested by the client.
+	CharacterSet uint8
+
+	// User is the authenticated user.
+	User string
+
+	// SchemaName is the database requested by the client,
+	// through the handshake or COM_INIT_DB.
+	SchemaName string
+
+	// StatusFlags are the status flags sent back to the client.
+	// The Handler keeps ServerStatusInTrans up to date.
+	StatusFlags uint16
+
+	// ClientData is reserved for the Handler.
+	ClientData interface{}
+}
+
+func newConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn:        conn,
+		reader:      bufio.NewReaderSize(conn, connBufferSize),
+		writer:      bufio.NewWriterSize(conn, connBufferSize),
+		StatusFlags: ServerStatusAutocommit,
+	}
+}
+
+// RemoteAddr returns the address of the client.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the connection.
+func (c *Conn) Close() {
+	c.conn.Close()
+}
+
+// readPacket reads a packet from the client. Payloads longer than
+// MaxPacketSize span multiple packets, which are concatenated.
+func (c *Conn) readPacket() ([]byte, error) {
+	return c.readPacketMax(0)
+}
+
+// readPacketMax reads a packet like readPacket, but fails before
+// reading it if its payload is longer than max. 0 means no limit.
+func (c *Conn) readPacketMax(max int) ([]byte, error) {
+	var data []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+			return nil, err
+		}
+		if header[3] != c.sequence {
+			return nil, fmt.Errorf("invalid sequence, expected %v got %v", c.sequence, header[3])
+		}
+		c.sequence++
+		length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		if max > 0 && len(data)+length > max {
+			return nil, NewSQLError(ERNetPacketTooLarge, SSNetPacketTooLarge, "Got a packet bigger than %v bytes", max)
+		}
+		chunk := make([]byte, length)
+		if _, err := io.ReadFull(c.reader, chunk); err != nil {
+			return nil, err
+		}
+		if data == nil {
+			data = chunk
+		} else {
+			data = append(data, chunk...)
+		}
+		if length < MaxPacketSize {
+			return data, nil
+		}
+	}
+}
+
+// writePacket writes a packet to the client, splitting payloads
+// longer than MaxPacketSize. The packet is buffered until flush.
+func (c *Conn) writePacket(data []byte) error {
+	for {
+		length := len(data)
+		if length > MaxPacketSize {
+			length = MaxPacketSize
+		}
+		header := []byte{byte(length), byte(length >> 8), byte(length >> 16), c.sequence}
+		if _, err := c.writer.Write(header); err != nil {
+			return err
+		}
+		if _, err := c.writer.Write(data[:length]); err != nil {
+			return err
+		}
+		c.sequence++
+		data = data[length:]
+		// A payload that's a multiple of MaxPacketSize
+		// is terminated by an empty packet.
+		if length < MaxPacketSize {
+			return nil
+		}
+	}
+}
+
+func (c *Conn) flush() error {
+	return c.writer.Flush()
+}
+
+// writeOKPacket writes an OK packet.
+func (c *Conn) writeOKPacket(affectedRows, lastInsertID uint64, warnings uint16) error {
+	data := []byte{OKPacket}
+	data = appendLenEncInt(data, affectedRows)
+	data = appendLenEncInt(data, lastInsertID)
+	data = appendUint16(data, c.StatusFlags)
+	data = appendUint16(data, warnings)
+	return c.writePacket(data)
+}
+
+// writeEOFPacket writes an EOF packet.
+func (c *Conn) writeEOFPacket(warnings uint16) error {
+	data := []byte{EOFPacket}
+	data = appendUint16(data, warnings)
+	data = appendUint16(data, c.StatusFlags)
+	return c.writePacket(data)
+}
+
+// writeErrorPacket writes an error packet.
+func (c *Conn) writeErrorPacket(num uint16, state, format string, args ...interface{}) error {
+	data := []byte{ErrPacket}
+	data = appendUint16(data, num)
+	data = append(data, '#')
+	data = append(data, state...)
+	data = append(data, fmt.Sprintf(format, args...)...)
+	return c.writePacket(data)
+}
+
+// writeErrorPacketFromError writes err as an error packet. Errors
+// that aren't a *SQLError are sent as ERUnknownError.
+func (c *Conn) writeErrorPacketFromError(err error) error {
+	if se, ok := err.(*SQLError); ok {
+		return c.writeErrorPacket(uint16(se.Num), se.State, "%v", se.Message)
+	}
+	return c.writeErrorPacket(ERUnknownError, SSUnknownSQLState, "%v", err)
+}
+
+// writeFields writes the column count and the column definitions
+// of a result set, followed by an EOF packet.
+func (c *Conn) writeFields(fields []mproto.Field) error {
+	if err := c.writePacket(appendLenEncInt(nil, uint64(len(fields)))); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if err := c.writeColumnDefinition(field); err != nil {
+			return err
+		}
+	}
+	return c.writeEOFPacket(0)
+}
+
+// writeColumnDefinition writes a Protocol::ColumnDefinition41.
+// The schema and table names are not known, and are left empty.
+func (c *Conn) writeColumnDefinition(field mproto.Field) error {
+	charset := uint16(CharacterSetUtf8)
+	if field.Flags&mproto.VT_BINARY_FLAG != 0 || isNumeric(field.Type) {
+		charset = CharacterSetBinary
+	}
+	data := appendLenEncString(nil, []byte("def"))
+	data = appendLenEncString(data, nil)
+	data = appendLenEncString(data, nil)
+	data = appendLenEncString(data, nil)
+	data = appendLenEncString(data, []byte(field.Name))
+	data = appendLenEncString(data, []byte(field.Name))
+	data = appendLenEncInt(data, 0x0c)
+	data = appendUint16(data, charset)
+	data = appendUint32(data, 0)
+	data = appendUint8(data, uint8(field.Type))
+	data = appendUint16(data, uint16(field.Flags))
+	data = appendUint8(data, 0)
+	data = appendUint16(data, 0)
+	return c.writePacket(data)
+}
+
+// writeRows writes rows as text result set rows.
+func (c *Conn) writeRows(qr *mproto.QueryResult) error {
+	for _, row := range qr.Rows {
+		var data []byte
+		for _, value := range row {
+			if value.IsNull() {
+				data = append(data, NullValue)
+				continue
+			}
+			data = appendLenEncString(data, value.Raw())
+		}
+		if err := c.writePacket(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isNumeric(typ int64) bool {
+	switch typ {
+	case mproto.VT_DECIMAL, mproto.VT_TINY, mproto.VT_SHORT, mproto.VT_LONG,
+		mproto.VT_FLOAT, mproto.VT_DOUBLE, mproto.VT_LONGLONG, mproto.VT_INT24,
+		mproto.VT_YEAR, mproto.VT_NEWDECIMAL:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,144 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlconn
+
+const (
+	// MaxPacketSize is the maximum payload length of a packet
+	// the server supports.
+	MaxPacketSize = (1 << 24) - 1
+
+	// protocolVersion is the current version of the protocol.
+	// Always 10.
+	protocolVersion = 10
+
+	// mysqlNativePassword is the only supported auth method.
+	mysqlNativePassword = "mysql_native_password"
+)
+
+// Capability flags.
+// Originally found in include/mysql/mysql_com.h
+const (
+	// CapabilityClientLongPassword is CLIENT_LONG_PASSWORD.
+	CapabilityClientLongPassword = 1
+
+	// CapabilityClientLongFlag is CLIENT_LONG_FLAG.
+	CapabilityClientLongFlag = 1 << 2
+
+	// CapabilityClientConnectWithDB is CLIENT_CONNECT_WITH_DB.
+	CapabilityClientConnectWithDB = 1 << 3
+
+	// CapabilityClientProtocol41 is CLIENT_PROTOCOL_41.
+	CapabilityClientProtocol41 = 1 << 9
+
+	// CapabilityClientSSL is CLIENT_SSL.
+	CapabilityClientSSL = 1 << 11
+
+	// CapabilityClientTransactions is CLIENT_TRANSACTIONS.
+	CapabilityClientTransactions = 1 << 13
+
+	// CapabilityClientSecureConnection is CLIENT_SECURE_CONNECTION.
+	CapabilityClientSecureConnection = 1 << 15
+
+	// CapabilityClientPluginAuth is CLIENT_PLUGIN_AUTH.
+	CapabilityClientPluginAuth = 1 << 19
+
+	// CapabilityClientPluginAuthLenencClientData is
+	// CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA.
+	CapabilityClientPluginAuthLenencClientData = 1 << 21
+
+	// serverCapabilities are the capabilities the server advertises.
+	serverCapabilities = CapabilityClientLongPassword |
+		CapabilityClientLongFlag |
+		CapabilityClientConnectWithDB |
+		CapabilityClientProtocol41 |
+		CapabilityClientTransactions |
+		CapabilityClientSecureConnection |
+		CapabilityClientPluginAuth |
+		CapabilityClientPluginAuthLenencClientData
+)
+
+// Status flags. They are returned by the server in a few cases.
+// Originally found in include/mysql/mysql_com.h
+const (
+	// ServerStatusInTrans is SERVER_STATUS_IN_TRANS.
+	ServerStatusInTrans = 0x0001
+
+	// ServerStatusAutocommit is SERVER_STATUS_AUTOCOMMIT.
+	ServerStatusAutocommit = 0x0002
+)
+
+// Packet types.
+const (
+	// OKPacket is the header of the OK packet.
+	OKPacket = 0x00
+
+	// EOFPacket is the header of the EOF packet.
+	EOFPacket = 0xfe
+
+	// ErrPacket is the header of the error packet.
+	ErrPacket = 0xff
+
+	// NullValue is the encoded value of NULL.
+	NullValue = 0xfb
+)
+
+// Commands supported by the server.
+// Originally found in include/mysql/mysql_com.h
+const (
+	// ComQuit is COM_QUIT.
+	ComQuit = 0x01
+
+	// ComInitDB is COM_INIT_DB.
+	ComInitDB = 0x02
+
+	// ComQuery is COM_QUERY.
+	ComQuery = 0x03
+
+	// ComPing is COM_PING.
+	ComPing = 0x0e
+)
+
+// Error codes and SQL states returned to the client.
+// Originally found in include/mysql/mysqld_error.h
+const (
+	// ERAccessDeniedError is ER_ACCESS_DENIED_ERROR.
+	ERAccessDeniedError = 1045
+
+	// ERUnknownComError is ER_UNKNOWN_COM_ERROR.
+	ERUnknownComError = 1047
+
+	// ERBadDb is ER_BAD_DB_ERROR.
+	ERBadDb = 1049
+
+	// ERNetPacketTooLarge is ER_NET_PACKET_TOO_LARGE.
+	ERNetPacketTooLarge = 1153
+
+	// ERUnknownError is ER_UNKNOWN_ERROR.
+	ERUnknownError = 1105
+
+	// SSUnknownSQLState is the SQL state for unknown errors.
+	SSUnknownSQLState = "HY000"
+
+	// SSAccessDeniedError is the SQL state for ERAccessDeniedError.
+	SSAccessDeniedError = "28000"
+
+	// SSUnknownComError is the SQL state for ERUnknownComError.
+	SSUnknownComError = "08S01"
+
+	// SSBadDb is the SQL state for ERBadDb.
+	SSBadDb = "42000"
+
+	// SSNetPacketTooLarge is the SQL state for ERNetPacketTooLarge.
+	SSNetPacketTooLarge = "08S01"
+)
+
+// Character sets.
+const (
+	// CharacterSetUtf8 is utf8_general_ci.
+	CharacterSetUtf8 = 33
+
+	// CharacterSetBinary is binary.
+	CharacterSetBinary = 63
+)
@@ -0,0 +1,119 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlconn
+
+// This file contains the helpers to encode and decode the basic
+// data types of the protocol. The append functions append the
+// encoded value to data and return the result. The read functions
+// decode the value at pos, and return it along with the position
+// after it. They return ok=false if data is too short.
+
+func appendUint8(data []byte, value uint8) []byte {
+	return append(data, value)
+}
+
+func appendUint16(data []byte, value uint16) []byte {
+	return append(data, byte(value), byte(value>>8))
+}
+
+func appendUint32(data []byte, value uint32) []byte {
+	return append(data, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
+
+func appendUint64(data []byte, value uint64) []byte {
+	return append(data, byte(value), byte(value>>8), byte(value>>16), byte(value>>24),
+		byte(value>>32), byte(value>>40), byte(value>>48), byte(value>>56))
+}
+
+func appendLenEncInt(data []byte, value uint64) []byte {
+	switch {
+	case value < 251:
+		return append(data, byte(value))
+	case value < 1<<16:
+		return appendUint16(append(data, 0xfc), uint16(value))
+	case value < 1<<24:
+		return append(data, 0xfd, byte(value), byte(value>>8), byte(value>>16))
+	}
+	return appendUint64(append(data, 0xfe), value)
+}
+
+func appendLenEncString(data []byte, value []byte) []byte {
+	data = appendLenEncInt(data, uint64(len(value)))
+	return append(data, value...)
+}
+
+func appendNullString(data []byte, value string) []byte {
+	data = append(data, value...)
+	return append(data, 0)
+}
+
+func readUint8(data []byte, pos int) (uint8, int, bool) {
+	if pos+1 > len(data) {
+		return 0, 0, false
+	}
+	return data[pos], pos + 1, true
+}
+
+func readUint16(data []byte, pos int) (uint16, int, bool) {
+	if pos+2 > len(data) {
+		return 0, 0, false
+	}
+	return uint16(data[pos]) | uint16(data[pos+1])<<8, pos + 2, true
+}
+
+func readUint32(data []byte, pos int) (uint32, int, bool) {
+	if pos+4 > len(data) {
+		return 0, 0, false
+	}
+	return uint32(data[pos]) | uint32(data[pos+1])<<8 | uint32(data[pos+2])<<16 | uint32(data[pos+3])<<24, pos + 4, true
+}
+
+func readLenEncInt(data []byte, pos int) (uint64, int, bool) {
+	if pos >= len(data) {
+		return 0, 0, false
+	}
+	switch data[pos] {
+	case 0xfc:
+		v, pos, ok := readUint16(data, pos+1)
+		return uint64(v), pos, ok
+	case 0xfd:
+		if pos+4 > len(data) {
+			return 0, 0, false
+		}
+		return uint64(data[pos+1]) | uint64(data[pos+2])<<8 | uint64(data[pos+3])<<16, pos + 4, true
+	case 0xfe:
+		lo, next, ok := readUint32(data, pos+1)
+		if !ok {
+			return 0, 0, false
+		}
+		hi, next, ok := readUint32(data, next)
+		return uint64(lo) | uint64(hi)<<32, next, ok
+	}
+	return uint64(data[pos]), pos + 1, true
+}
+
+func readBytes(data []byte, pos, size int) ([]byte, int, bool) {
+	if size < 0 || pos+size > len(data) {
+		return nil, 0, false
+	}
+	return data[pos : pos+size], pos + size, true
+}
+
+func readLenEncString(data []byte, pos int) ([]byte, int, bool) {
+	size, pos, ok := readLenEncInt(data, pos)
+	if !ok {
+		return nil, 0, false
+	}
+	return readBytes(data, pos, int(size))
+}
+
+func readNullString(data []byte, pos int) (string, int, bool) {
+	for end := pos; end < len(data); end++ {
+		if data[end] == 0 {
+			return string(data[pos:end]), end + 1, true
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,56 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlconn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLenEncInt(t *testing.T) {
+	tests := []struct {
+		value   uint64
+		encoded []byte
+	}{
+		{0x00, []byte{0x00}},
+		{0xfa, []byte{0xfa}},
+		{0xfb, []byte{0xfc, 0xfb, 0x00}},
+		{0x1234, []byte{0xfc, 0x34, 0x12}},
+		{0x123456, []byte{0xfd, 0x56, 0x34, 0x12}},
+		{0x123456789a, []byte{0xfe, 0x9a, 0x78, 0x56, 0x34, 0x12, 0x00, 0x00, 0x00}},
+	}
+	for _, test := range tests {
+		encoded := appendLenEncInt([]byte{0xaa}, test.value)
+		if !reflect.DeepEqual(encoded[1:], test.encoded) {
+			t.Errorf("appendLenEncInt(%x): %x, want %x", test.value, encoded[1:], test.encoded)
+		}
+		value, pos, ok := readLenEncInt(encoded, 1)
+		if !ok || value != test.value || pos != len(encoded) {
+			t.Errorf("readLenEncInt(%x): %x, %v, %v, want %x, %v, true", encoded, value, pos, ok, test.value, len(encoded))
+		}
+		if _, _, ok := readLenEncInt(encoded[:len(encoded)-1], 1); ok {
+			t.Errorf("readLenEncInt(%x): ok, want not ok", encoded[:len(encoded)-1])
+		}
+	}
+}
+
+func TestStrings(t *testing.T) {
+	data := appendNullString(nil, "abc")
+	data = appendLenEncString(data, []byte("defg"))
+	s, pos, ok := readNullString(data, 0)
+	if !ok || s != "abc" {
+		t.Errorf("readNullString: %v, %v, want abc, true", s, ok)
+	}
+	b, pos, ok := readLenEncString(data, pos)
+	if !ok || string(b) != "defg" || pos != len(data) {
+		t.Errorf("readLenEncString: %s, %v, %v, want defg, %v, true", b, pos, ok, len(data))
+	}
+	if _, _, ok := readNullString([]byte("abc"), 0); ok {
+		t.Errorf("readNullString: ok, want not ok")
+	}
+	if _, _, ok := readLenEncString([]byte{0x05, 'a'}, 0); ok {
+		t.Errorf("readLenEncString: ok, want not ok")
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlconn
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	log "github.com/golang/glog"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sync2"
+)
+
+// DefaultServerVersion is the server version sent in the handshake.
+// Clients use it to decide which features they can use.
+const DefaultServerVersion = "5.5.10-Vitess"
+
+// Handler executes the commands of the connections of a Listener.
+// Its methods are called from the go routine that serves the
+// connection, so they're called concurrently for different
+// connections, but never for the same one.
+type Handler interface {
+	// NewConnection is called once the connection is authenticated.
+	NewConnection(c *Conn)
+
+	// ConnectionClosed is called when the connection is closed.
+	ConnectionClosed(c *Conn)
+
+	// ComInitDB is called for COM_INIT_DB, and for the database
+	// of the handshake. c.SchemaName is set if it succeeds.
+	ComInitDB(c *Conn, schemaName string) error
+
+	// ComQuery executes query, and calls callback with its results.
+	// For statements that return rows, the first result must have
+	// the Fields, and the next ones only add rows. For the other
+	// statements, callback is called once, with a result that has
+	// no Fields: its RowsAffected and InsertId are sent to the
+	// client. An error returned by callback must be returned.
+	ComQuery(c *Conn, query string, callback func(*mproto.QueryResult) error) error
+}
+
+// Listener accepts MySQL protocol connections, authenticates
+// them, and serves their commands with a Handler.
+type Listener struct {
+	// ServerVersion is the version sent in the handshake.
+	ServerVersion string
+
+	listener     net.Listener
+	authServer   AuthServer
+	handler      Handler
+	connectionID sync2.AtomicUint32
+}
+
+// NewListener creates a Listener on the address. Call Accept
+// to start serving connections.
+func NewListener(protocol, address string, authServer AuthServer, handler Handler) (*Listener, error) {
+	listener, err := net.Listen(protocol, address)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{
+		ServerVersion: DefaultServerVersion,
+		listener:      listener,
+		authServer:    authServer,
+		handler:       handler,
+	}, nil
+}
+
+// Addr returns the address the Listener listens on.
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+// Accept accepts connections and serves each of them in its own
+// go routine. It returns when the Listener is closed.
+func (l *Listener) Accept() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			return
+		}
+		go l.handle(conn, l.connectionID.Add(1))
+	}
+}
+
+// Close stops accepting connections. The existing
+// connections are not closed.
+func (l *Listener) Close() {
+	l.listener.Close()
+}
+
+// handle serves a connection until it's closed.
+func (l *Listener) handle(conn net.Conn, connectionID uint32) {
+	c := newConn(conn)
+	c.ConnectionID = connectionID
+	defer c.Close()
+	defer func() {
+		if x := recover(); x != nil {
+			log.Errorf("mysqlconn: panic serving connection %v from %v: %v", connectionID, c.RemoteAddr(), x)
+		}
+	}()
+
+	schemaName, err := l.authenticate(c)
+	if err != nil {
+		log.Infof("mysqlconn: cannot authenticate connection %v from %v: %v", connectionID, c.RemoteAddr(), err)
+		c.writeErrorPacketFromError(err)
+		c.flush()
+		return
+	}
+
+	l.handler.NewConnection(c)
+	defer l.handler.ConnectionClosed(c)
+
+	if schemaName != "" {
+		if err := l.handler.ComInitDB(c, schemaName); err != nil {
+			c.writeErrorPacketFromError(err)
+			c.flush()
+			return
+		}
+		c.SchemaName = schemaName
+	}
+	if err := c.writeOKPacket(0, 0, 0); err != nil {
+		return
+	}
+	if err := c.flush(); err != nil {
+		return
+	}
+
+	for {
+		c.sequence = 0
+		data, err := c.readPacket()
+		if err != nil {
+			if err != io.EOF {
+				log.Infof("mysqlconn: cannot read command from connection %v: %v", connectionID, err)
+			}
+			return
+		}
+		if len(data) == 0 {
+			return
+		}
+		switch data[0] {
+		case ComQuit:
+			return
+		case ComInitDB:
+			schemaName := string(data[1:])
+			if err := l.handler.ComInitDB(c, schemaName); err != nil {
+				err = c.writeErrorPacketFromError(err)
+			} else {
+				c.SchemaName = schemaName
+				err = c.writeOKPacket(0, 0, 0)
+			}
+		case ComQuery:
+			err = l.execQuery(c, string(data[1:]))
+		case ComPing:
+			err = c.writeOKPacket(0, 0, 0)
+		default:
+			err = c.writeErrorPacket(ERUnknownComError, SSUnknownComError, "command handling not implemented yet: %v", data[0])
+		}
+		if err == nil {
+			err = c.flush()
+		}
+		if err != nil {
+			log.Infof("mysqlconn: cannot write to connection %v: %v", connectionID, err)
+			return
+		}
+	}
+}
+
+// execQuery executes a COM_QUERY and sends back its results.
+// It only returns an error if the results can't be written.
+func (l *Listener) execQuery(c *Conn, query string) error {
+	fieldsSent := false
+	okSent := false
+	var writeErr error
+	err := l.handler.ComQuery(c, query, func(qr *mproto.QueryResult) error {
+		switch {
+		case okSent:
+			writeErr = fmt.Errorf("unexpected result after a statement that returns no rows")
+		case fieldsSent:
+			writeErr = c.writeRows(qr)
+		case len(qr.Fields) == 0:
+			okSent = true
+			writeErr = c.writeOKPacket(qr.RowsAffected, qr.InsertId, 0)
+		default:
+			fieldsSent = true
+			if writeErr = c.writeFields(qr.Fields); writeErr == nil {
+				writeErr = c.writeRows(qr)
+			}
+		}
+		return writeErr
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	switch {
+	case err != nil:
+		// An error packet can also end a result set.
+		return c.writeErrorPacketFromError(err)
+	case fieldsSent:
+		return c.writeEOFPacket(0)
+	case !okSent:
+		return c.writeOKPacket(0, 0, 0)
+	}
+	return nil
+}
+
+// authenticate sends the handshake, reads the response, and
+// validates the credentials. It returns the requested database.
+func (l *Listener) authenticate(c *Conn) (string, error) {
+	salt, err := newSalt()
+	if err != nil {
+		return "", err
+	}
+	if err := c.writeHandshakeV10(l.ServerVersion, salt); err != nil {
+		return "", err
+	}
+	response, err := c.readPacketMax(maxHandshakePacketSize)
+	if err != nil {
+		return "", err
+	}
+	user, authMethod, authResponse, schemaName, err := c.parseHandshakeResponse(response)
+	if err != nil {
+		return "", err
+	}
+	if authMethod != "" && authMethod != mysqlNativePassword {
+		// Ask the client to switch to mysql_native_password.
+		data := []byte{EOFPacket}
+		data = appendNullString(data, mysqlNativePassword)
+		data = append(data, salt...)
+		data = append(data, 0)
+		if err := c.writePacket(data); err != nil {
+			return "", err
+		}
+		if err := c.flush(); err != nil {
+			return "", err
+		}
+		if authResponse, err = c.readPacketMax(maxHandshakePacketSize); err != nil {
+			return "", err
+		}
+	}
+	if err := l.authServer.ValidateHash(salt, user, authResponse); err != nil {
+		return "", err
+	}
+	c.User = user
+	return schemaName, nil
+}
+
+// writeHandshakeV10 writes the initial handshake packet.
+func (c *Conn) writeHandshakeV10(serverVersion string, salt []byte) error {
+	data := []byte{protocolVersion}
+	data = appendNullString(data, serverVersion)
+	data = appendUint32(data, c.ConnectionID)
+	data = append(data, salt[:8]...)
+	data = append(data, 0)
+	data = appendUint16(data, uint16(serverCapabilities&0xffff))
+	data = appendUint8(data, CharacterSetUtf8)
+	data = appendUint16(data, c.StatusFlags)
+	data = appendUint16(data, uint16(serverCapabilities>>16))
+	data = appendUint8(data, uint8(len(salt)+1))
+	data = append(data, make([]byte, 10)...)
+	data = append(data, salt[8:]...)
+	data = append(data, 0)
+	data = appendNullString(data, mysqlNativePassword)
+	if err := c.writePacket(data); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+// parseHandshakeResponse parses a Protocol::HandshakeResponse41.
+func (c *Conn) parseHandshakeResponse(data []byte) (user, authMethod string, authResponse []byte, schemaName string, err error) {
+	malformed := NewSQLError(ERUnknownComError, SSUnknownComError, "malformed handshake response")
+	clientFlags, pos, ok := readUint32(data, 0)
+	if !ok {
+		return "", "", nil, "", malformed
+	}
+	if clientFlags&CapabilityClientProtocol41 == 0 {
+		return "", "", nil, "", NewSQLError(ERUnknownComError, SSUnknownComError, "client must support protocol 4.1")
+	}
+	// Skip the max packet size.
+	if _, pos, ok = readUint32(data, pos); !ok {
+		return "", "", nil, "", malformed
+	}
+	if c.CharacterSet, pos, ok = readUint8(data, pos); !ok {
+		return "", "", nil, "", malformed
+	}
+	// Skip the filler.
+	pos += 23
+	if clientFlags&CapabilityClientSSL != 0 && pos == len(data) {
+		return "", "", nil, "", NewSQLError(ERUnknownComError, SSUnknownComError, "SSL is not supported")
+	}
+	c.Capabilities = clientFlags & serverCapabilities
+
+	if user, pos, ok = readNullString(data, pos); !ok {
+		return "", "", nil, "", malformed
+	}
+	switch {
+	case clientFlags&CapabilityClientPluginAuthLenencClientData != 0:
+		authResponse, pos, ok = readLenEncString(data, pos)
+	case clientFlags&CapabilityClientSecureConnection != 0:
+		var size uint8
+		if size, pos, ok = readUint8(data, pos); ok {
+			authResponse, pos, ok = readBytes(data, pos, int(size))
+		}
+	default:
+		var password string
+		password, pos, ok = readNullString(data, pos)
+		authResponse = []byte(password)
+	}
+	if !ok {
+		return "", "", nil, "", malformed
+	}
+	if clientFlags&CapabilityClientConnectWithDB != 0 && pos < len(data) {
+		if schemaName, pos, ok = readNullString(data, pos); !ok {
+			return "", "", nil, "", malformed
+		}
+	}
+	if clientFlags&CapabilityClientPluginAuth != 0 && pos < len(data) {
+		if authMethod, _, ok = readNullString(data, pos); !ok {
+			return "", "", nil, "", malformed
+		}
+	}
+	return user, authMethod, authResponse, schemaName, nil
+}
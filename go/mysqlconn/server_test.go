@@ -0,0 +1,461 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlconn
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+)
+
+// testHandler answers queries based on their text.
+type testHandler struct {
+	closed chan *Conn
+}
+
+func (th *testHandler) NewConnection(c *Conn) {
+	c.ClientData = "data"
+}
+
+func (th *testHandler) ConnectionClosed(c *Conn) {
+	th.closed <- c
+}
+
+func (th *testHandler) ComInitDB(c *Conn, schemaName string) error {
+	if schemaName == "bad" {
+		return NewSQLError(ERBadDb, SSBadDb, "unknown database: %v", schemaName)
+	}
+	return nil
+}
+
+var testFields = []mproto.Field{
+	{Name: "id", Type: mproto.VT_LONGLONG},
+	{Name: "name", Type: mproto.VT_VAR_STRING},
+}
+
+func (th *testHandler) ComQuery(c *Conn, query string, callback func(*mproto.QueryResult) error) error {
+	switch query {
+	case "select rows":
+		if err := callback(&mproto.QueryResult{
+			Fields: testFields,
+			Rows:   [][]sqltypes.Value{{sqltypes.MakeString([]byte("1")), sqltypes.MakeString([]byte("a"))}},
+		}); err != nil {
+			return err
+		}
+		return callback(&mproto.QueryResult{
+			Rows: [][]sqltypes.Value{{sqltypes.MakeString([]byte("2")), {}}},
+		})
+	case "select error":
+		if err := callback(&mproto.QueryResult{Fields: testFields}); err != nil {
+			return err
+		}
+		return fmt.Errorf("stream failed")
+	case "insert":
+		return callback(&mproto.QueryResult{RowsAffected: 2, InsertId: 3})
+	case "schema":
+		return callback(&mproto.QueryResult{
+			Fields: []mproto.Field{{Name: "schema", Type: mproto.VT_VAR_STRING}},
+			Rows:   [][]sqltypes.Value{{sqltypes.MakeString([]byte(c.SchemaName))}},
+		})
+	case "nothing":
+		return nil
+	}
+	return NewSQLError(1064, "42000", "syntax error: %v", query)
+}
+
+// testClient is a minimal client that reuses Conn for the
+// packet handling.
+type testClient struct {
+	*Conn
+}
+
+func newTestServer(t *testing.T) (*Listener, *testHandler) {
+	th := &testHandler{closed: make(chan *Conn, 10)}
+	l, err := NewListener("tcp", "127.0.0.1:0", &AuthServerStatic{
+		Entries: map[string][]string{
+			"user1": {"password1", "password2"},
+			"user2": {""},
+		},
+	}, th)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go l.Accept()
+	return l, th
+}
+
+// connect performs the handshake. It returns the error sent
+// by the server if authentication fails.
+func connect(t *testing.T, l *Listener, user, password, schemaName, authMethod string) (*testClient, error) {
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{newConn(conn)}
+	data, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] != protocolVersion {
+		t.Fatalf("protocol version: %v, want %v", data[0], protocolVersion)
+	}
+	serverVersion, pos, _ := readNullString(data, 1)
+	if serverVersion != DefaultServerVersion {
+		t.Errorf("server version: %v, want %v", serverVersion, DefaultServerVersion)
+	}
+	_, pos, _ = readUint32(data, pos)
+	salt1, pos, _ := readBytes(data, pos, 8)
+	// filler, capabilities, charset, status, capabilities, length, reserved
+	pos += 1 + 2 + 1 + 2 + 2 + 1 + 10
+	salt2, pos, _ := readBytes(data, pos, 12)
+	salt := append(append([]byte{}, salt1...), salt2...)
+	if method, _, _ := readNullString(data, pos+1); method != mysqlNativePassword {
+		t.Errorf("auth method: %v, want %v", method, mysqlNativePassword)
+	}
+
+	flags := uint32(CapabilityClientProtocol41 | CapabilityClientSecureConnection | CapabilityClientPluginAuth)
+	if schemaName != "" {
+		flags |= CapabilityClientConnectWithDB
+	}
+	scramble := ScramblePassword(salt, []byte(password))
+	if authMethod != mysqlNativePassword {
+		scramble = []byte(password)
+	}
+	response := appendUint32(nil, flags)
+	response = appendUint32(response, MaxPacketSize)
+	response = appendUint8(response, CharacterSetUtf8)
+	response = append(response, make([]byte, 23)...)
+	response = appendNullString(response, user)
+	response = appendUint8(response, uint8(len(scramble)))
+	response = append(response, scramble...)
+	if schemaName != "" {
+		response = appendNullString(response, schemaName)
+	}
+	response = appendNullString(response, authMethod)
+	if err := c.writePacket(response); err != nil {
+		t.Fatal(err)
+	}
+	c.flush()
+
+	data, err = c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[0] == EOFPacket {
+		// Switch to mysql_native_password.
+		method, pos, _ := readNullString(data, 1)
+		if method != mysqlNativePassword {
+			t.Errorf("auth switch method: %v, want %v", method, mysqlNativePassword)
+		}
+		salt, _, _ = readBytes(data, pos, 20)
+		c.writePacket(ScramblePassword(salt, []byte(password)))
+		c.flush()
+		if data, err = c.readPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data[0] == ErrPacket {
+		c.Close()
+		return nil, parseErrorPacket(data)
+	}
+	if data[0] != OKPacket {
+		t.Fatalf("unexpected handshake result: %v", data)
+	}
+	return c, nil
+}
+
+func parseErrorPacket(data []byte) error {
+	num, pos, _ := readUint16(data, 1)
+	return NewSQLError(int(num), string(data[pos+1:pos+6]), "%s", data[pos+6:])
+}
+
+// command sends a command, and returns the first packet of the response.
+func (c *testClient) command(t *testing.T, com byte, arg string) []byte {
+	c.sequence = 0
+	if err := c.writePacket(append([]byte{com}, arg...)); err != nil {
+		t.Fatal(err)
+	}
+	c.flush()
+	data, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// query executes a query. Rows are returned as strings, and
+// NULL values as "NULL". Statements that don't return rows
+// return their OK packet as a single row.
+func (c *testClient) query(t *testing.T, query string) ([]mproto.Field, [][]string, error) {
+	data := c.command(t, ComQuery, query)
+	switch data[0] {
+	case ErrPacket:
+		return nil, nil, parseErrorPacket(data)
+	case OKPacket:
+		affectedRows, pos, _ := readLenEncInt(data, 1)
+		insertID, _, _ := readLenEncInt(data, pos)
+		return nil, [][]string{{fmt.Sprint(affectedRows), fmt.Sprint(insertID)}}, nil
+	}
+	count, _, _ := readLenEncInt(data, 0)
+	var fields []mproto.Field
+	for i := 0; i < int(count); i++ {
+		data, err := c.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pos := 0
+		var name []byte
+		for j := 0; j < 6; j++ {
+			name, pos, _ = readLenEncString(data, pos)
+		}
+		// 0x0c, charset, column length
+		typ, pos, _ := readUint8(data, pos+1+2+4)
+		flags, _, _ := readUint16(data, pos)
+		fields = append(fields, mproto.Field{Name: string(name), Type: int64(typ), Flags: int64(flags)})
+	}
+	if data, _ := c.readPacket(); data[0] != EOFPacket {
+		t.Fatalf("want EOF after fields, got %v", data)
+	}
+	var rows [][]string
+	for {
+		data, err := c.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case data[0] == ErrPacket:
+			return fields, rows, parseErrorPacket(data)
+		case data[0] == EOFPacket && len(data) < 9:
+			return fields, rows, nil
+		}
+		var row []string
+		for pos := 0; pos < len(data); {
+			if data[pos] == NullValue {
+				row = append(row, "NULL")
+				pos++
+				continue
+			}
+			var val []byte
+			val, pos, _ = readLenEncString(data, pos)
+			row = append(row, string(val))
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	l, th := newTestServer(t)
+	defer l.Close()
+
+	for _, password := range []string{"password1", "password2"} {
+		c, err := connect(t, l, "user1", password, "", mysqlNativePassword)
+		if err != nil {
+			t.Fatalf("connect with %v: %v", password, err)
+		}
+		c.writePacket([]byte{ComQuit})
+		c.flush()
+		closed := <-th.closed
+		if closed.User != "user1" || closed.ClientData != "data" {
+			t.Errorf("closed connection: %v, %v, want user1, data", closed.User, closed.ClientData)
+		}
+	}
+
+	// Empty password.
+	c, err := connect(t, l, "user2", "", "", mysqlNativePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// Auth switch.
+	c, err = connect(t, l, "user1", "password1", "", "mysql_clear_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	want := "Access denied for user 'user1' (errno 1045) (sqlstate 28000)"
+	if _, err = connect(t, l, "user1", "bad", "", mysqlNativePassword); err == nil || err.Error() != want {
+		t.Errorf("connect: %v, want %s", err, want)
+	}
+	want = "Access denied for user 'user3' (errno 1045) (sqlstate 28000)"
+	if _, err = connect(t, l, "user3", "password1", "", mysqlNativePassword); err == nil || err.Error() != want {
+		t.Errorf("connect: %v, want %s", err, want)
+	}
+}
+
+func TestServerHandshakeTooLarge(t *testing.T) {
+	l, _ := newTestServer(t)
+	defer l.Close()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{newConn(conn)}
+	defer c.Close()
+	if _, err := c.readPacket(); err != nil {
+		t.Fatal(err)
+	}
+	// Only the header of the response is sent: the server
+	// fails without waiting for the payload.
+	c.writer.Write([]byte{0xff, 0xff, 0xff, c.sequence})
+	c.flush()
+	c.sequence++
+	data, err := c.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Got a packet bigger than 65536 bytes (errno 1153) (sqlstate 08S01)"
+	if err := parseErrorPacket(data); data[0] != ErrPacket || err.Error() != want {
+		t.Errorf("handshake response: %v, want %s", err, want)
+	}
+}
+
+func TestServerInitDB(t *testing.T) {
+	l, _ := newTestServer(t)
+	defer l.Close()
+
+	c, err := connect(t, l, "user1", "password1", "db1", mysqlNativePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, rows, err := c.query(t, "schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, [][]string{{"db1"}}) {
+		t.Errorf("schema: %v, want db1", rows)
+	}
+
+	if data := c.command(t, ComInitDB, "db2"); data[0] != OKPacket {
+		t.Errorf("COM_INIT_DB: %v, want OK", data)
+	}
+	_, rows, _ = c.query(t, "schema")
+	if !reflect.DeepEqual(rows, [][]string{{"db2"}}) {
+		t.Errorf("schema: %v, want db2", rows)
+	}
+
+	data := c.command(t, ComInitDB, "bad")
+	want := "unknown database: bad (errno 1049) (sqlstate 42000)"
+	if err := parseErrorPacket(data); data[0] != ErrPacket || err.Error() != want {
+		t.Errorf("COM_INIT_DB: %v, want %s", err, want)
+	}
+	_, rows, _ = c.query(t, "schema")
+	if !reflect.DeepEqual(rows, [][]string{{"db2"}}) {
+		t.Errorf("schema: %v, want db2", rows)
+	}
+
+	if _, err := connect(t, l, "user1", "password1", "bad", mysqlNativePassword); err == nil || err.Error() != want {
+		t.Errorf("connect: %v, want %s", err, want)
+	}
+}
+
+func TestServerQuery(t *testing.T) {
+	l, _ := newTestServer(t)
+	defer l.Close()
+	c, err := connect(t, l, "user1", "password1", "", mysqlNativePassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if data := c.command(t, ComPing, ""); data[0] != OKPacket {
+		t.Errorf("COM_PING: %v, want OK", data)
+	}
+
+	fields, rows, err := c.query(t, "select rows")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields, testFields) {
+		t.Errorf("fields: %v, want %v", fields, testFields)
+	}
+	wantRows := [][]string{{"1", "a"}, {"2", "NULL"}}
+	if !reflect.DeepEqual(rows, wantRows) {
+		t.Errorf("rows: %v, want %v", rows, wantRows)
+	}
+
+	_, rows, err = c.query(t, "insert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, [][]string{{"2", "3"}}) {
+		t.Errorf("insert: %v, want 2 rows affected, insert id 3", rows)
+	}
+
+	_, rows, err = c.query(t, "nothing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rows, [][]string{{"0", "0"}}) {
+		t.Errorf("nothing: %v, want OK", rows)
+	}
+
+	_, _, err = c.query(t, "select error")
+	want := "stream failed (errno 1105) (sqlstate HY000)"
+	if err == nil || err.Error() != want {
+		t.Errorf("select error: %v, want %s", err, want)
+	}
+
+	_, _, err = c.query(t, "bad query")
+	want = "syntax error: bad query (errno 1064) (sqlstate 42000)"
+	if err == nil || err.Error() != want {
+		t.Errorf("bad query: %v, want %s", err, want)
+	}
+
+	data := c.command(t, 0x16, "")
+	if err := parseErrorPacket(data); !strings.Contains(err.Error(), "errno 1047") {
+		t.Errorf("unknown command: %v, want errno 1047", err)
+	}
+
+	// The connection is still usable after the errors.
+	if _, _, err = c.query(t, "select rows"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLargePacket(t *testing.T) {
+	client, server := net.Pipe()
+	cc := newConn(client)
+	sc := newConn(server)
+	for _, size := range []int{0, 10, MaxPacketSize, MaxPacketSize + 10} {
+		payload := make([]byte, size)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+		cc.sequence = 0
+		sc.sequence = 0
+		done := make(chan error)
+		go func() {
+			if err := cc.writePacket(payload); err != nil {
+				done <- err
+				return
+			}
+			done <- cc.flush()
+		}()
+		got, err := sc.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != size || size != 0 && !reflect.DeepEqual(got, payload) {
+			t.Errorf("readPacket: %d bytes, want %d", len(got), size)
+		}
+		if sc.sequence != cc.sequence {
+			t.Errorf("sequence: %d, want %d", sc.sequence, cc.sequence)
+		}
+	}
+	client.Close()
+	server.Close()
+}
@@ -0,0 +1,31 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlconn
+
+import (
+	"fmt"
+)
+
+// SQLError is an error that's sent to the client with
+// its own error number and SQL state.
+type SQLError struct {
+	Num     int
+	State   string
+	Message string
+}
+
+// NewSQLError creates a new SQLError.
+func NewSQLError(num int, state, format string, args ...interface{}) *SQLError {
+	return &SQLError{
+		Num:     num,
+		State:   state,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// Error implements the error interface.
+func (se *SQLError) Error() string {
+	return fmt.Sprintf("%v (errno %v) (sqlstate %v)", se.Message, se.Num, se.State)
+}
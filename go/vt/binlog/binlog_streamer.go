@@ -50,6 +50,12 @@ var (
 // reply is of type proto.BinlogTransaction.
 type sendTransactionFunc func(trans *proto.BinlogTransaction) error
 
+// sendRowsFunc is used to send the rows of row-based replication events.
+// tm describes the table the rows belong to. If the rows can't be
+// decoded, e.g. because of a column type that isn't supported, rows
+// is nil and rowsErr tells why: the stream goes on without them.
+type sendRowsFunc func(tm *proto.TableMap, rows []proto.Row, rowsErr error) error
+
 // getStatementCategory returns the proto.BL_* category for a SQL statement.
 func getStatementCategory(sql []byte) int {
 	if i := bytes.IndexByte(sql, byte(' ')); i >= 0 {
@@ -69,6 +75,11 @@ type BinlogStreamer struct {
 	startPos        myproto.ReplicationPosition
 	sendTransaction sendTransactionFunc
 
+	// sendRows, if set, is called with the rows of the row-based
+	// replication events of dbname, before the transaction that
+	// contains them is sent. If it's not set, those events are ignored.
+	sendRows sendRowsFunc
+
 	conn *mysqlctl.SlaveConnection
 }
 
@@ -146,6 +157,9 @@ func (bls *BinlogStreamer) parseEvents(ctx *sync2.ServiceContext, events <-chan
 	var pos = bls.startPos
 	var autocommit = true
 	var err error
+	// tableMaps holds the TABLE_MAP_EVENTs of the current transaction,
+	// which describe the tables of the row-based replication events.
+	tableMaps := make(map[uint64]*proto.TableMap)
 
 	// A begin can be triggered either by a BEGIN query, or by a GTID_EVENT.
 	begin := func() {
@@ -173,6 +187,7 @@ func (bls *BinlogStreamer) parseEvents(ctx *sync2.ServiceContext, events <-chan
 		}
 		statements = nil
 		autocommit = true
+		tableMaps = make(map[uint64]*proto.TableMap)
 		return nil
 	}
 
@@ -265,6 +280,40 @@ func (bls *BinlogStreamer) parseEvents(ctx *sync2.ServiceContext, events <-chan
 				Category: proto.BL_SET,
 				Sql:      []byte(fmt.Sprintf("SET @@RAND_SEED1=%d, @@RAND_SEED2=%d", seed1, seed2)),
 			})
+		case ev.IsTableMap(): // TABLE_MAP_EVENT
+			if bls.sendRows == nil {
+				continue
+			}
+			tm, err := ev.TableMap(format)
+			if err != nil {
+				return pos, fmt.Errorf("can't parse TABLE_MAP_EVENT: %v, event data: %#v", err, ev)
+			}
+			tableMaps[ev.TableID(format)] = tm
+		case ev.IsWriteRows() || ev.IsUpdateRows() || ev.IsDeleteRows(): // WRITE_ROWS_EVENT, UPDATE_ROWS_EVENT, DELETE_ROWS_EVENT
+			if bls.sendRows == nil {
+				continue
+			}
+			tm, ok := tableMaps[ev.TableID(format)]
+			if !ok {
+				return pos, fmt.Errorf("got a rows event without TABLE_MAP_EVENT for table id %v, event data: %#v", ev.TableID(format), ev)
+			}
+			if tm.Database != "" && tm.Database != bls.dbname {
+				// Skip cross-db rows.
+				continue
+			}
+			// Rows that can't be decoded would fail again on
+			// every retry of the stream, so they're not fatal.
+			rows, rowsErr := ev.Rows(format, tm)
+			if rowsErr != nil {
+				binlogStreamerErrors.Add("ParseEvents", 1)
+				log.Errorf("can't parse rows event of table %v.%v: %v, event data: %#v", tm.Database, tm.Name, rowsErr, ev)
+			}
+			if err = bls.sendRows(tm, rows, rowsErr); err != nil {
+				if err == io.EOF {
+					return pos, ErrClientEOF
+				}
+				return pos, fmt.Errorf("send rows error: %v", err)
+			}
 		case ev.IsQuery(): // QUERY_EVENT
 			// Extract the query string and group into transactions.
 			q, err := ev.Query(format)
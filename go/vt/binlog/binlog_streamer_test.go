@@ -31,6 +31,10 @@ func (fakeEvent) IsGTID() bool                    { return false }
 func (fakeEvent) IsRotate() bool                  { return false }
 func (fakeEvent) IsIntVar() bool                  { return false }
 func (fakeEvent) IsRand() bool                    { return false }
+func (fakeEvent) IsTableMap() bool                { return false }
+func (fakeEvent) IsWriteRows() bool               { return false }
+func (fakeEvent) IsUpdateRows() bool              { return false }
+func (fakeEvent) IsDeleteRows() bool              { return false }
 func (fakeEvent) HasGTID(proto.BinlogFormat) bool { return true }
 func (fakeEvent) Timestamp() uint32               { return 1407805592 }
 func (fakeEvent) Format() (proto.BinlogFormat, error) {
@@ -49,6 +53,13 @@ func (fakeEvent) IntVar(proto.BinlogFormat) (string, uint64, error) {
 func (fakeEvent) Rand(proto.BinlogFormat) (uint64, uint64, error) {
 	return 0, 0, errors.New("not a rand")
 }
+func (fakeEvent) TableID(proto.BinlogFormat) uint64 { return 0 }
+func (fakeEvent) TableMap(proto.BinlogFormat) (*proto.TableMap, error) {
+	return nil, errors.New("not a table map")
+}
+func (fakeEvent) Rows(proto.BinlogFormat, *proto.TableMap) ([]proto.Row, error) {
+	return nil, errors.New("not a rows event")
+}
 func (ev fakeEvent) StripChecksum(proto.BinlogFormat) (proto.BinlogEvent, []byte, error) {
 	return ev, nil, nil
 }
@@ -138,6 +149,37 @@ func (ev invalidIntVarEvent) StripChecksum(proto.BinlogFormat) (proto.BinlogEven
 	return ev, nil, nil
 }
 
+type tableMapEvent struct {
+	fakeEvent
+	id       uint64
+	tableMap *proto.TableMap
+}
+
+func (tableMapEvent) IsTableMap() bool                     { return true }
+func (ev tableMapEvent) TableID(proto.BinlogFormat) uint64 { return ev.id }
+func (ev tableMapEvent) TableMap(proto.BinlogFormat) (*proto.TableMap, error) {
+	return ev.tableMap, nil
+}
+func (ev tableMapEvent) StripChecksum(proto.BinlogFormat) (proto.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
+type writeRowsEvent struct {
+	fakeEvent
+	id   uint64
+	rows []proto.Row
+	err  error
+}
+
+func (writeRowsEvent) IsWriteRows() bool                    { return true }
+func (ev writeRowsEvent) TableID(proto.BinlogFormat) uint64 { return ev.id }
+func (ev writeRowsEvent) Rows(proto.BinlogFormat, *proto.TableMap) ([]proto.Row, error) {
+	return ev.rows, ev.err
+}
+func (ev writeRowsEvent) StripChecksum(proto.BinlogFormat) (proto.BinlogEvent, []byte, error) {
+	return ev, nil, nil
+}
+
 // sample MariaDB event data
 var (
 	mariadbRotateEvent         = mysqlctl.NewMariadbBinlogEvent([]byte{0x0, 0x0, 0x0, 0x0, 0x4, 0x88, 0xf3, 0x0, 0x0, 0x33, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x20, 0x0, 0x4, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x76, 0x74, 0x2d, 0x30, 0x30, 0x30, 0x30, 0x30, 0x36, 0x32, 0x33, 0x34, 0x34, 0x2d, 0x62, 0x69, 0x6e, 0x2e, 0x30, 0x30, 0x30, 0x30, 0x30, 0x31})
@@ -246,6 +288,138 @@ func TestBinlogStreamerParseEventsCommit(t *testing.T) {
 	}
 }
 
+func TestBinlogStreamerParseEventsRows(t *testing.T) {
+	tm := &proto.TableMap{Database: "vt_test_keyspace", Name: "vt_a"}
+	otherTM := &proto.TableMap{Database: "other", Name: "vt_a"}
+	rows := []proto.Row{{Data: []interface{}{int64(1), int64(1)}}}
+	input := []proto.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: proto.Query{Database: "vt_test_keyspace", Sql: []byte("BEGIN")}},
+		tableMapEvent{id: 1, tableMap: tm},
+		tableMapEvent{id: 2, tableMap: otherTM},
+		writeRowsEvent{id: 1, rows: rows},
+		writeRowsEvent{id: 2, rows: rows},
+		xidEvent{},
+	}
+
+	events := make(chan proto.BinlogEvent)
+
+	type sentRows struct {
+		tm   *proto.TableMap
+		rows []proto.Row
+	}
+	var gotRows []sentRows
+	var gotTrans []proto.BinlogTransaction
+	bls := NewBinlogStreamer("vt_test_keyspace", nil, nil, myproto.ReplicationPosition{}, func(trans *proto.BinlogTransaction) error {
+		if len(gotRows) != 1 {
+			t.Errorf("rows were not sent before the transaction")
+		}
+		gotTrans = append(gotTrans, *trans)
+		return nil
+	})
+	bls.sendRows = func(tm *proto.TableMap, rows []proto.Row, rowsErr error) error {
+		if rowsErr != nil {
+			t.Errorf("sendRows: unexpected error: %v", rowsErr)
+		}
+		gotRows = append(gotRows, sentRows{tm, rows})
+		return nil
+	}
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	if err := svm.Join(); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// The rows of the other database are skipped.
+	wantRows := []sentRows{{tm, rows}}
+	if !reflect.DeepEqual(gotRows, wantRows) {
+		t.Errorf("sendRows: got %v, want %v", gotRows, wantRows)
+	}
+	if len(gotTrans) != 1 {
+		t.Errorf("got %v transactions, want 1", len(gotTrans))
+	}
+}
+
+func TestBinlogStreamerParseEventsRowsUnsupported(t *testing.T) {
+	tm := &proto.TableMap{Database: "vt_test_keyspace", Name: "vt_a"}
+	rowsErr := fmt.Errorf("can't read column 1: unsupported column type 245")
+	input := []proto.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: proto.Query{Database: "vt_test_keyspace", Sql: []byte("BEGIN")}},
+		tableMapEvent{id: 1, tableMap: tm},
+		writeRowsEvent{id: 1, err: rowsErr},
+		xidEvent{},
+	}
+	events := make(chan proto.BinlogEvent)
+
+	var gotErr error
+	var gotTrans []proto.BinlogTransaction
+	bls := NewBinlogStreamer("vt_test_keyspace", nil, nil, myproto.ReplicationPosition{}, func(trans *proto.BinlogTransaction) error {
+		gotTrans = append(gotTrans, *trans)
+		return nil
+	})
+	bls.sendRows = func(tm *proto.TableMap, rows []proto.Row, rowsErr error) error {
+		if rows != nil {
+			t.Errorf("sendRows: got %v, want nil", rows)
+		}
+		gotErr = rowsErr
+		return nil
+	}
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	// The rows that can't be decoded don't stop the stream.
+	if err := svm.Join(); err != ErrServerEOF {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if gotErr != rowsErr {
+		t.Errorf("sendRows: got error %v, want %v", gotErr, rowsErr)
+	}
+	if len(gotTrans) != 1 {
+		t.Errorf("got %v transactions, want 1", len(gotTrans))
+	}
+}
+
+func TestBinlogStreamerParseEventsRowsWithoutTableMap(t *testing.T) {
+	input := []proto.BinlogEvent{
+		rotateEvent{},
+		formatEvent{},
+		queryEvent{query: proto.Query{Database: "vt_test_keyspace", Sql: []byte("BEGIN")}},
+		writeRowsEvent{id: 1},
+		xidEvent{},
+	}
+	events := make(chan proto.BinlogEvent)
+
+	bls := NewBinlogStreamer("vt_test_keyspace", nil, nil, myproto.ReplicationPosition{}, func(trans *proto.BinlogTransaction) error {
+		return nil
+	})
+	bls.sendRows = func(tm *proto.TableMap, rows []proto.Row, rowsErr error) error {
+		return nil
+	}
+	want := "got a rows event without TABLE_MAP_EVENT for table id 1"
+
+	go sendTestEvents(events, input)
+	svm := &sync2.ServiceManager{}
+	svm.Go(func(ctx *sync2.ServiceContext) error {
+		_, err := bls.parseEvents(ctx, events)
+		return err
+	})
+	if err := svm.Join(); err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("wrong error, got %#v, want %#v", err, want)
+	}
+}
+
 func TestBinlogStreamerStop(t *testing.T) {
 	events := make(chan proto.BinlogEvent)
 
@@ -8,9 +8,11 @@ import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"reflect"
 	"strconv"
 
 	log "github.com/golang/glog"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/vt/binlog/proto"
 	"github.com/youtube/vitess/go/vt/mysqlctl"
@@ -37,13 +39,34 @@ type sendEventFunc func(event *proto.StreamEvent) error
 type EventStreamer struct {
 	bls       *BinlogStreamer
 	sendEvent sendEventFunc
+
+	// pkInfos caches the primary keys of the tables modified by
+	// row-based replication events. It's cleared by DDLs.
+	pkInfos map[string]*pkInfo
+	// rowEvents holds the events built from the row-based
+	// replication events of the current transaction.
+	rowEvents []*proto.StreamEvent
+}
+
+// pkInfo describes the primary key of a table, as needed to
+// extract it from the row images of row-based replication events.
+type pkInfo struct {
+	colNames []string
+	// columns are the indexes of the pk columns in the table.
+	columns []int
+	// unsigned tells for each pk column if it's an unsigned integer.
+	unsigned []bool
+	// columnCount is the number of columns of the table.
+	columnCount int
 }
 
 func NewEventStreamer(dbname string, mysqld mysqlctl.MysqlDaemon, startPos myproto.ReplicationPosition, sendEvent sendEventFunc) *EventStreamer {
 	evs := &EventStreamer{
 		sendEvent: sendEvent,
+		pkInfos:   make(map[string]*pkInfo),
 	}
 	evs.bls = NewBinlogStreamer(dbname, mysqld, nil, startPos, evs.transactionToEvent)
+	evs.bls.sendRows = evs.rowsToEvent
 	return evs
 }
 
@@ -54,6 +77,14 @@ func (evs *EventStreamer) Stream(ctx *sync2.ServiceContext) error {
 func (evs *EventStreamer) transactionToEvent(trans *proto.BinlogTransaction) error {
 	var err error
 	var insertid int64
+	rowEvents := evs.rowEvents
+	evs.rowEvents = nil
+	for _, rowEvent := range rowEvents {
+		rowEvent.Timestamp = trans.Timestamp
+		if err = evs.sendEvent(rowEvent); err != nil {
+			return err
+		}
+	}
 	for _, stmt := range trans.Statements {
 		switch stmt.Category {
 		case proto.BL_SET:
@@ -78,6 +109,8 @@ func (evs *EventStreamer) transactionToEvent(trans *proto.BinlogTransaction) err
 				return err
 			}
 		case proto.BL_DDL:
+			// The DDL may have changed primary keys.
+			evs.pkInfos = make(map[string]*pkInfo)
 			ddlEvent := &proto.StreamEvent{
 				Category:  "DDL",
 				Sql:       string(stmt.Sql),
@@ -143,6 +176,186 @@ func (evs *EventStreamer) buildDMLEvent(sql []byte, insertid int64) (dmlEvent *p
 	return dmlEvent, insertid, nil
 }
 
+// rowsToEvent builds a DML event from the primary keys of the rows
+// of a row-based replication event. The event is sent along with
+// the transaction that contains it. If the primary keys can't be
+// extracted, a DDL event of the table is sent instead: the rowcache
+// invalidates all the rows of a table that was altered. Tables
+// without a primary key are skipped, they can't be in the rowcache.
+func (evs *EventStreamer) rowsToEvent(tm *proto.TableMap, rows []proto.Row, rowsErr error) error {
+	dmlEvent, err := evs.buildRowsEvent(tm, rows, rowsErr)
+	if _, ok := err.(noPKError); ok {
+		log.Infof("Skipping rows event: %v", err)
+		return nil
+	}
+	if err != nil {
+		binlogStreamerErrors.Add("EventStreamer", 1)
+		log.Errorf("%v: %s.%s", err, tm.Database, tm.Name)
+		dmlEvent = &proto.StreamEvent{
+			Category: "DDL",
+			Sql:      fmt.Sprintf("alter table `%s` invalidate", tm.Name),
+		}
+	}
+	evs.rowEvents = append(evs.rowEvents, dmlEvent)
+	return nil
+}
+
+func (evs *EventStreamer) buildRowsEvent(tm *proto.TableMap, rows []proto.Row, rowsErr error) (*proto.StreamEvent, error) {
+	pk, err := evs.getPKInfo(tm.Name)
+	if err != nil {
+		return nil, err
+	}
+	if rowsErr != nil {
+		return nil, rowsErr
+	}
+	if pk.columnCount != len(tm.Types) {
+		return nil, fmt.Errorf("table has %d columns, rows event has %d", pk.columnCount, len(tm.Types))
+	}
+	if err := pk.checkTypes(tm); err != nil {
+		return nil, err
+	}
+
+	dmlEvent := &proto.StreamEvent{
+		Category:   "DML",
+		TableName:  tm.Name,
+		PKColNames: pk.colNames,
+		PKValues:   make([][]interface{}, 0, len(rows)),
+	}
+	for _, row := range rows {
+		var identify []interface{}
+		if row.Identify != nil {
+			if identify, err = pk.values(tm, row.Identify); err != nil {
+				return nil, err
+			}
+			dmlEvent.PKValues = append(dmlEvent.PKValues, identify)
+		}
+		if row.Data != nil {
+			data, err := pk.values(tm, row.Data)
+			if err != nil {
+				return nil, err
+			}
+			// Updates only need the new pk if they changed it.
+			if !reflect.DeepEqual(identify, data) {
+				dmlEvent.PKValues = append(dmlEvent.PKValues, data)
+			}
+		}
+	}
+	return dmlEvent, nil
+}
+
+// getPKInfo returns the primary key of table, loading it from
+// mysqld if it's not cached.
+func (evs *EventStreamer) getPKInfo(table string) (*pkInfo, error) {
+	if pk, ok := evs.pkInfos[table]; ok {
+		return pk, nil
+	}
+	sd, err := evs.bls.mysqld.GetSchema(evs.bls.dbname, []string{table}, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(sd.TableDefinitions) != 1 {
+		return nil, noPKError(fmt.Sprintf("table %s not found", table))
+	}
+	colNames := sd.TableDefinitions[0].PrimaryKeyColumns
+	if len(colNames) == 0 {
+		return nil, noPKError(fmt.Sprintf("table %s has no primary key", table))
+	}
+	// The fields tell the column order, and which integers are unsigned.
+	qr, err := evs.bls.mysqld.FetchSuperQuery(fmt.Sprintf("select * from `%s`.`%s` where 1=0", evs.bls.dbname, table))
+	if err != nil {
+		return nil, err
+	}
+	pk := &pkInfo{
+		colNames:    colNames,
+		columnCount: len(qr.Fields),
+	}
+	for _, name := range pk.colNames {
+		index := -1
+		for i, field := range qr.Fields {
+			if field.Name == name {
+				index = i
+				break
+			}
+		}
+		if index == -1 {
+			return nil, fmt.Errorf("pk column %s not found in table %s", name, table)
+		}
+		pk.columns = append(pk.columns, index)
+		pk.unsigned = append(pk.unsigned, qr.Fields[index].Flags&mproto.VT_UNSIGNED_FLAG != 0)
+	}
+	evs.pkInfos[table] = pk
+	return pk, nil
+}
+
+// noPKError is returned by getPKInfo for the tables that don't
+// exist, or don't have a primary key.
+type noPKError string
+
+func (e noPKError) Error() string {
+	return string(e)
+}
+
+// checkTypes returns an error if a pk column has a type whose binlog
+// encoding isn't the text form the rowcache keys are built from.
+// Only integers and strings are supported.
+func (pk *pkInfo) checkTypes(tm *proto.TableMap) error {
+	for i, col := range pk.columns {
+		switch typ := tm.Types[col]; typ {
+		case mproto.VT_TINY, mproto.VT_SHORT, mproto.VT_INT24, mproto.VT_LONG, mproto.VT_LONGLONG,
+			mproto.VT_VARCHAR, mproto.VT_VAR_STRING,
+			mproto.VT_TINY_BLOB, mproto.VT_MEDIUM_BLOB, mproto.VT_LONG_BLOB, mproto.VT_BLOB:
+			continue
+		case mproto.VT_STRING:
+			// ENUM and SET columns are sent as STRING, with their
+			// real type in the high byte of the metadata.
+			if col >= len(tm.Metadata) {
+				break
+			}
+			if realType := byte(tm.Metadata[col]>>8) | 0x30; realType != mproto.VT_ENUM && realType != mproto.VT_SET {
+				continue
+			}
+		}
+		return fmt.Errorf("pk column %s has unsupported type %v", pk.colNames[i], tm.Types[col])
+	}
+	return nil
+}
+
+// values returns the pk values of a row image.
+func (pk *pkInfo) values(tm *proto.TableMap, image []interface{}) ([]interface{}, error) {
+	values := make([]interface{}, 0, len(pk.columns))
+	for i, col := range pk.columns {
+		switch value := image[col].(type) {
+		case nil:
+			return nil, fmt.Errorf("pk column %s is missing from row image", pk.colNames[i])
+		case int64:
+			if pk.unsigned[i] {
+				values = append(values, unsignedValue(tm.Types[col], value))
+			} else {
+				values = append(values, value)
+			}
+		default:
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// unsignedValue converts an integer that was decoded as signed
+// to the unsigned value of a column of type typ.
+func unsignedValue(typ byte, value int64) uint64 {
+	switch typ {
+	case mproto.VT_TINY:
+		return uint64(uint8(value))
+	case mproto.VT_SHORT:
+		return uint64(uint16(value))
+	case mproto.VT_INT24:
+		return uint64(value) & 0xffffff
+	case mproto.VT_LONG:
+		return uint64(uint32(value))
+	}
+	return uint64(value)
+}
+
 /*
 parseStreamComment parses the tuples of the full stream comment.
 The _stream comment is extracted into an EventNode tree.
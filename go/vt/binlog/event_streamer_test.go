@@ -9,8 +9,11 @@ import (
 	"reflect"
 	"testing"
 
+	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/vt/binlog/proto"
+	"github.com/youtube/vitess/go/vt/mysqlctl"
 	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
+	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
 )
 
 var dmlErrorCases = []string{
@@ -177,3 +180,194 @@ func TestDDLEvent(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func newRowsEventStreamer(sendEvent sendEventFunc) *EventStreamer {
+	mysqld := mysqlctl.NewFakeMysqlDaemon()
+	mysqld.Schema = &myproto.SchemaDefinition{
+		TableDefinitions: []*myproto.TableDefinition{{
+			Name:              "vtocc_e",
+			Columns:           []string{"name", "eid", "id"},
+			PrimaryKeyColumns: []string{"eid", "id"},
+			Type:              myproto.TableBaseTable,
+		}, {
+			Name:    "vtocc_nopk",
+			Columns: []string{"name"},
+			Type:    myproto.TableBaseTable,
+		}},
+	}
+	mysqld.FetchSuperQueryMap = map[string]*mproto.QueryResult{
+		"select * from `vt_test_keyspace`.`vtocc_e` where 1=0": {
+			Fields: []mproto.Field{
+				{Name: "name", Type: mproto.VT_VAR_STRING},
+				{Name: "eid", Type: mproto.VT_LONGLONG},
+				{Name: "id", Type: mproto.VT_LONG, Flags: mproto.VT_UNSIGNED_FLAG},
+			},
+		},
+	}
+	return NewEventStreamer("vt_test_keyspace", mysqld, myproto.ReplicationPosition{}, sendEvent)
+}
+
+func TestRowsEvent(t *testing.T) {
+	var got []string
+	evs := newRowsEventStreamer(func(event *proto.StreamEvent) error {
+		got = append(got, fmt.Sprintf("%v", event))
+		return nil
+	})
+	tm := &proto.TableMap{
+		Database: "vt_test_keyspace",
+		Name:     "vtocc_e",
+		Types:    []byte{mproto.VT_VARCHAR, mproto.VT_LONGLONG, mproto.VT_LONG},
+		Metadata: []uint16{10, 0, 0},
+	}
+	rows := []proto.Row{{
+		// insert
+		Data: []interface{}{[]byte("name"), int64(1), int64(-1)},
+	}, {
+		// update of a non-pk column
+		Identify: []interface{}{[]byte("name"), int64(2), int64(2)},
+		Data:     []interface{}{[]byte("new"), int64(2), int64(2)},
+	}, {
+		// update of the pk
+		Identify: []interface{}{nil, int64(3), int64(3)},
+		Data:     []interface{}{nil, int64(4), int64(3)},
+	}, {
+		// delete
+		Identify: []interface{}{nil, int64(5), int64(5)},
+	}}
+	if err := evs.rowsToEvent(tm, rows, nil); err != nil {
+		t.Fatal(err)
+	}
+	trans := &proto.BinlogTransaction{
+		Timestamp: 1,
+		GTIDField: myproto.GTIDField{Value: myproto.MustParseGTID("MariaDB", "0-41983-20")},
+	}
+	if err := evs.transactionToEvent(trans); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`&{DML vtocc_e [eid id] [[1 4294967295] [2 2] [3 3] [4 3] [5 5]]  1 <nil>}`,
+		`&{POS  [] []  1 0-41983-20}`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got \n%s, want \n%s", got, want)
+	}
+	// The row events were sent with their transaction.
+	if evs.rowEvents != nil {
+		t.Errorf("rowEvents: %v, want nil", evs.rowEvents)
+	}
+}
+
+func TestRowsEventErrors(t *testing.T) {
+	var got []*proto.StreamEvent
+	sendEvent := func(event *proto.StreamEvent) error {
+		if event.Category != "POS" {
+			got = append(got, event)
+		}
+		return nil
+	}
+	testcases := []struct {
+		tm      *proto.TableMap
+		rows    []proto.Row
+		rowsErr error
+	}{{
+		tm: &proto.TableMap{Name: "vtocc_e", Types: []byte{mproto.VT_LONGLONG}},
+	}, {
+		tm:   &proto.TableMap{Name: "vtocc_e", Types: []byte{mproto.VT_VARCHAR, mproto.VT_LONGLONG, mproto.VT_LONG}},
+		rows: []proto.Row{{Identify: []interface{}{nil, int64(1), nil}}},
+	}, {
+		// The rowcache keys are built from the text form of the
+		// pk values, which isn't what the binlog has for them.
+		tm:   &proto.TableMap{Name: "vtocc_e", Types: []byte{mproto.VT_VARCHAR, mproto.VT_DATETIME, mproto.VT_LONG}},
+		rows: []proto.Row{{Data: []interface{}{nil, []byte{0x99, 0x8e, 0x36, 0x30, 0x00, 0x00, 0x00, 0x00}, int64(1)}}},
+	}, {
+		tm:   &proto.TableMap{Name: "vtocc_e", Types: []byte{mproto.VT_VARCHAR, mproto.VT_NEWDECIMAL, mproto.VT_LONG}, Metadata: []uint16{10, 10<<8 | 2, 0}},
+		rows: []proto.Row{{Data: []interface{}{nil, []byte{0x80, 0x00, 0x00, 0x01, 0x02}, int64(1)}}},
+	}, {
+		tm:   &proto.TableMap{Name: "vtocc_e", Types: []byte{mproto.VT_VARCHAR, mproto.VT_STRING, mproto.VT_LONG}, Metadata: []uint16{10, uint16(mproto.VT_ENUM)<<8 | 1, 0}},
+		rows: []proto.Row{{Data: []interface{}{nil, []byte{1}, int64(1)}}},
+	}, {
+		tm:      &proto.TableMap{Name: "vtocc_e", Types: []byte{mproto.VT_VARCHAR, 245, mproto.VT_LONG}},
+		rowsErr: fmt.Errorf("can't read column 1: unsupported column type 245"),
+	}}
+	for _, tcase := range testcases {
+		got = nil
+		evs := newRowsEventStreamer(sendEvent)
+		if err := evs.rowsToEvent(tcase.tm, tcase.rows, tcase.rowsErr); err != nil {
+			t.Fatal(err)
+		}
+		if err := evs.transactionToEvent(&proto.BinlogTransaction{}); err != nil {
+			t.Fatal(err)
+		}
+		// The rowcache invalidates the whole table.
+		want := []*proto.StreamEvent{{
+			Category: "DDL",
+			Sql:      "alter table `vtocc_e` invalidate",
+		}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got: %+v, want: %+v", got, want)
+		}
+		ddlPlan := planbuilder.DDLParse(got[0].Sql)
+		if ddlPlan.Action != "alter" || ddlPlan.NewName != "vtocc_e" {
+			t.Errorf("DDLParse(%s): %+v, want an alter of vtocc_e", got[0].Sql, ddlPlan)
+		}
+	}
+}
+
+func TestRowsEventSkipped(t *testing.T) {
+	var got []*proto.StreamEvent
+	evs := newRowsEventStreamer(func(event *proto.StreamEvent) error {
+		if event.Category != "POS" {
+			got = append(got, event)
+		}
+		return nil
+	})
+	// Tables that don't exist, or don't have a pk, are not in the
+	// rowcache, even if their rows can't be decoded.
+	testcases := []struct {
+		tm      *proto.TableMap
+		rowsErr error
+	}{{
+		tm: &proto.TableMap{Name: "unknown"},
+	}, {
+		tm: &proto.TableMap{Name: "vtocc_nopk", Types: []byte{mproto.VT_VARCHAR}},
+	}, {
+		tm:      &proto.TableMap{Name: "vtocc_nopk", Types: []byte{245}},
+		rowsErr: fmt.Errorf("can't read column 0: unsupported column type 245"),
+	}}
+	for _, tcase := range testcases {
+		if err := evs.rowsToEvent(tcase.tm, nil, tcase.rowsErr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := evs.transactionToEvent(&proto.BinlogTransaction{}); err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Errorf("got: %+v, want no event", got)
+	}
+}
+
+func TestRowsEventPKInfoCache(t *testing.T) {
+	evs := newRowsEventStreamer(func(event *proto.StreamEvent) error {
+		return nil
+	})
+	if _, err := evs.getPKInfo("vtocc_e"); err != nil {
+		t.Fatal(err)
+	}
+	// The cached pk info is used even if mysqld can't be reached.
+	mysqld := evs.bls.mysqld.(*mysqlctl.FakeMysqlDaemon)
+	mysqld.Schema = nil
+	if _, err := evs.getPKInfo("vtocc_e"); err != nil {
+		t.Errorf("getPKInfo: %v", err)
+	}
+	// DDLs clear the cache.
+	trans := &proto.BinlogTransaction{
+		Statements: []proto.Statement{{Category: proto.BL_DDL, Sql: []byte("alter table vtocc_e")}},
+	}
+	if err := evs.transactionToEvent(trans); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := evs.getPKInfo("vtocc_e"); err == nil {
+		t.Errorf("getPKInfo: nil, want error")
+	}
+}
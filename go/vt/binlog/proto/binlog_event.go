@@ -46,6 +46,14 @@ type BinlogEvent interface {
 	IsIntVar() bool
 	// IsRand returns true if this is a RAND_EVENT.
 	IsRand() bool
+	// IsTableMap returns true if this is a TABLE_MAP_EVENT.
+	IsTableMap() bool
+	// IsWriteRows returns true if this is a WRITE_ROWS_EVENT.
+	IsWriteRows() bool
+	// IsUpdateRows returns true if this is an UPDATE_ROWS_EVENT.
+	IsUpdateRows() bool
+	// IsDeleteRows returns true if this is a DELETE_ROWS_EVENT.
+	IsDeleteRows() bool
 	// HasGTID returns true if this event contains a GTID. That could either be
 	// because it's a GTID_EVENT (MariaDB, MySQL 5.6), or because it is some
 	// arbitrary event type that has a GTID in the header (Google MySQL).
@@ -74,6 +82,20 @@ type BinlogEvent interface {
 	// Rand returns the two seed values for a RAND_EVENT.
 	// This is only valid if IsRand() returns true.
 	Rand(BinlogFormat) (uint64, uint64, error)
+	// TableID returns the ID of the table described by a TABLE_MAP_EVENT,
+	// or modified by a WRITE_ROWS, UPDATE_ROWS or DELETE_ROWS event.
+	// This is only valid if one of IsTableMap(), IsWriteRows(),
+	// IsUpdateRows() or IsDeleteRows() returns true.
+	TableID(BinlogFormat) uint64
+	// TableMap returns a TableMap struct representing data from a
+	// TABLE_MAP_EVENT.
+	// This is only valid if IsTableMap() returns true.
+	TableMap(BinlogFormat) (*TableMap, error)
+	// Rows returns the rows of a WRITE_ROWS, UPDATE_ROWS or DELETE_ROWS
+	// event. tm is the TableMap of the table the rows belong to.
+	// This is only valid if one of IsWriteRows(), IsUpdateRows() or
+	// IsDeleteRows() returns true.
+	Rows(f BinlogFormat, tm *TableMap) ([]Row, error)
 
 	// StripChecksum returns the checksum and a modified event with the checksum
 	// stripped off, if any. If there is no checksum, it returns the same event
@@ -112,3 +134,27 @@ func (q Query) String() string {
 	return fmt.Sprintf("{Database: %q, Charset: %v, Sql: %q}",
 		q.Database, q.Charset, string(q.Sql))
 }
+
+// TableMap contains data from a TABLE_MAP_EVENT. It describes the table
+// modified by the row-based replication events that follow it.
+type TableMap struct {
+	Database string
+	Name     string
+	// Types are the MySQL types of the columns (mproto.VT_*).
+	Types []byte
+	// Metadata holds the type-specific metadata of each column,
+	// e.g. the maximum length of a VARCHAR.
+	Metadata []uint16
+}
+
+// Row is a row modified by a WRITE_ROWS, UPDATE_ROWS or DELETE_ROWS event.
+// Identify is the image of the row before the change (UPDATE_ROWS and
+// DELETE_ROWS), and Data is the image after the change (WRITE_ROWS and
+// UPDATE_ROWS). An image has one value per column of the table, which is
+// nil if the value is NULL or if the column is not in the image.
+// Integers are int64 and strings are []byte. Values of other types are
+// left in their binlog encoding, as []byte.
+type Row struct {
+	Identify []interface{}
+	Data     []interface{}
+}
@@ -223,8 +223,11 @@ var result1 = mproto.QueryResult{
 }
 
 var session1 = &proto.Session{
-	InTransaction: true,
-	ShardSessions: []*proto.ShardSession{},
+	InTransaction:  true,
+	ShardSessions:  []*proto.ShardSession{},
+	PreSessions:    []*proto.ShardSession{},
+	PostSessions:   []*proto.ShardSession{},
+	WritePositions: []*proto.WritePosition{},
 }
 
 var session2 = &proto.Session{
@@ -237,4 +240,7 @@ var session2 = &proto.Session{
 			TransactionId: 1,
 		},
 	},
+	PreSessions:    []*proto.ShardSession{},
+	PostSessions:   []*proto.ShardSession{},
+	WritePositions: []*proto.WritePosition{},
 }
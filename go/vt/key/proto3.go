@@ -0,0 +1,25 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package key
+
+import (
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+// KeyRangeToProto3 converts a KeyRange to the proto3 version.
+func KeyRangeToProto3(keyRange KeyRange) *pb.KeyRange {
+	return &pb.KeyRange{
+		Start: []byte(keyRange.Start),
+		End:   []byte(keyRange.End),
+	}
+}
+
+// Proto3ToKeyRange converts a proto3 KeyRange to the internal version.
+func Proto3ToKeyRange(keyRange *pb.KeyRange) KeyRange {
+	return KeyRange{
+		Start: KeyspaceId(keyRange.Start),
+		End:   KeyspaceId(keyRange.End),
+	}
+}
@@ -0,0 +1,407 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlctl
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	blproto "github.com/youtube/vitess/go/vt/binlog/proto"
+)
+
+// These column types were added in MySQL 5.6, and are not in mproto.
+const (
+	typeTimestamp2 = 17
+	typeDatetime2  = 18
+	typeTime2      = 19
+)
+
+// IsTableMap implements BinlogEvent.IsTableMap().
+func (ev binlogEvent) IsTableMap() bool {
+	return ev.Type() == 19
+}
+
+// IsWriteRows implements BinlogEvent.IsWriteRows().
+// We support both version 1 (MariaDB, MySQL 5.5) and version 2 (MySQL 5.6)
+// of the rows events.
+func (ev binlogEvent) IsWriteRows() bool {
+	return ev.Type() == 23 || ev.Type() == 30
+}
+
+// IsUpdateRows implements BinlogEvent.IsUpdateRows().
+func (ev binlogEvent) IsUpdateRows() bool {
+	return ev.Type() == 24 || ev.Type() == 31
+}
+
+// IsDeleteRows implements BinlogEvent.IsDeleteRows().
+func (ev binlogEvent) IsDeleteRows() bool {
+	return ev.Type() == 25 || ev.Type() == 32
+}
+
+// isRowsV2 returns true if this is a version 2 rows event.
+func (ev binlogEvent) isRowsV2() bool {
+	return ev.Type() >= 30 && ev.Type() <= 32
+}
+
+// TableID implements BinlogEvent.TableID().
+//
+// Expected format (L = total length of event data):
+//   # bytes   field
+//   6         table id
+func (ev binlogEvent) TableID(f blproto.BinlogFormat) uint64 {
+	data := ev.Bytes()[f.HeaderLength:]
+	return uint64(binary.LittleEndian.Uint32(data[:4])) |
+		uint64(binary.LittleEndian.Uint16(data[4:6]))<<32
+}
+
+// TableMap implements BinlogEvent.TableMap().
+//
+// Expected format (L = total length of event data):
+//   # bytes   field
+//   6         table id
+//   2         flags
+//   1         length of db_name, not including NULL terminator (X)
+//   X+1       db_name + NULL terminator
+//   1         length of table_name, not including NULL terminator (Y)
+//   Y+1       table_name + NULL terminator
+//   var       number of columns, as a length-encoded integer (N)
+//   N         column types
+//   var       length of metadata block, as a length-encoded integer (M)
+//   M         metadata block
+//   (N+7)/8   bitmap of the nullable columns
+func (ev binlogEvent) TableMap(f blproto.BinlogFormat) (*blproto.TableMap, error) {
+	data := ev.Bytes()[f.HeaderLength:]
+	tm := &blproto.TableMap{}
+
+	pos := 6 + 2
+	var err error
+	if tm.Database, pos, err = readTableMapName(data, pos); err != nil {
+		return nil, fmt.Errorf("can't read database name: %v", err)
+	}
+	if tm.Name, pos, err = readTableMapName(data, pos); err != nil {
+		return nil, fmt.Errorf("can't read table name: %v", err)
+	}
+
+	columnCount, pos, err := readLenEncInt(data, pos)
+	if err != nil {
+		return nil, fmt.Errorf("can't read column count: %v", err)
+	}
+	if pos+int(columnCount) > len(data) {
+		return nil, fmt.Errorf("column types overflow buffer (%v + %v > %v)", pos, columnCount, len(data))
+	}
+	tm.Types = data[pos : pos+int(columnCount)]
+	pos += int(columnCount)
+
+	metaLen, pos, err := readLenEncInt(data, pos)
+	if err != nil {
+		return nil, fmt.Errorf("can't read metadata length: %v", err)
+	}
+	if pos+int(metaLen) > len(data) {
+		return nil, fmt.Errorf("metadata block overflows buffer (%v + %v > %v)", pos, metaLen, len(data))
+	}
+	meta := data[pos : pos+int(metaLen)]
+
+	// The size of the metadata of each column depends on its type.
+	tm.Metadata = make([]uint16, columnCount)
+	metaPos := 0
+	for i, typ := range tm.Types {
+		var size int
+		switch typ {
+		case mproto.VT_FLOAT, mproto.VT_DOUBLE,
+			mproto.VT_TINY_BLOB, mproto.VT_MEDIUM_BLOB, mproto.VT_LONG_BLOB, mproto.VT_BLOB, mproto.VT_GEOMETRY,
+			typeTimestamp2, typeDatetime2, typeTime2:
+			size = 1
+		case mproto.VT_VARCHAR, mproto.VT_VAR_STRING, mproto.VT_BIT,
+			mproto.VT_NEWDECIMAL, mproto.VT_STRING, mproto.VT_ENUM, mproto.VT_SET:
+			size = 2
+		}
+		if metaPos+size > len(meta) {
+			return nil, fmt.Errorf("metadata of column %v overflows metadata block (%v + %v > %v)", i, metaPos, size, len(meta))
+		}
+		switch {
+		case size == 1:
+			tm.Metadata[i] = uint16(meta[metaPos])
+		case typ == mproto.VT_VARCHAR || typ == mproto.VT_VAR_STRING || typ == mproto.VT_BIT:
+			tm.Metadata[i] = binary.LittleEndian.Uint16(meta[metaPos : metaPos+2])
+		case size == 2:
+			// NEWDECIMAL has the precision then the scale, and STRING has
+			// the real type then the length. We store them in that order.
+			tm.Metadata[i] = uint16(meta[metaPos])<<8 | uint16(meta[metaPos+1])
+		}
+		metaPos += size
+	}
+	return tm, nil
+}
+
+// Rows implements BinlogEvent.Rows().
+//
+// Expected format (L = total length of event data):
+//   # bytes   field
+//   6         table id
+//   2         flags
+//   -- version 2 only --
+//   2         length of extra data, including these 2 bytes (X)
+//   X-2       extra data
+//   --
+//   var       number of columns, as a length-encoded integer (N)
+//   (N+7)/8   bitmap of the columns in the first image
+//   (N+7)/8   bitmap of the columns in the second image (UPDATE_ROWS only)
+//   L-...     row images
+//
+// Each row has one image (WRITE_ROWS, DELETE_ROWS) or two images
+// (UPDATE_ROWS). An image is a bitmap of its NULL columns (one bit per
+// column present in the image) followed by the values of its non-NULL
+// columns.
+func (ev binlogEvent) Rows(f blproto.BinlogFormat, tm *blproto.TableMap) ([]blproto.Row, error) {
+	data := ev.Bytes()[f.HeaderLength:]
+
+	pos := 6 + 2
+	if ev.isRowsV2() {
+		if pos+2 > len(data) {
+			return nil, fmt.Errorf("extra data length overflows buffer (%v + 2 > %v)", pos, len(data))
+		}
+		pos += int(binary.LittleEndian.Uint16(data[pos : pos+2]))
+	}
+
+	columnCount, pos, err := readLenEncInt(data, pos)
+	if err != nil {
+		return nil, fmt.Errorf("can't read column count: %v", err)
+	}
+	if int(columnCount) != len(tm.Types) {
+		return nil, fmt.Errorf("column count (%v) doesn't match table map of %v.%v (%v)", columnCount, tm.Database, tm.Name, len(tm.Types))
+	}
+	bitmapLen := (int(columnCount) + 7) / 8
+
+	imageCount := 1
+	if ev.IsUpdateRows() {
+		imageCount = 2
+	}
+	if pos+imageCount*bitmapLen > len(data) {
+		return nil, fmt.Errorf("column bitmaps overflow buffer (%v + %v > %v)", pos, imageCount*bitmapLen, len(data))
+	}
+	columns := make([][]byte, imageCount)
+	for i := range columns {
+		columns[i] = data[pos : pos+bitmapLen]
+		pos += bitmapLen
+	}
+
+	var rows []blproto.Row
+	for pos < len(data) {
+		images := make([][]interface{}, imageCount)
+		for i := range images {
+			if images[i], pos, err = readRowImage(data, pos, tm, columns[i]); err != nil {
+				return nil, fmt.Errorf("can't read row %v: %v", len(rows), err)
+			}
+		}
+		var row blproto.Row
+		switch {
+		case ev.IsWriteRows():
+			row.Data = images[0]
+		case ev.IsDeleteRows():
+			row.Identify = images[0]
+		default:
+			row.Identify = images[0]
+			row.Data = images[1]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// readRowImage reads the image of a row that has the columns set in
+// the columns bitmap, and returns the position after it.
+func readRowImage(data []byte, pos int, tm *blproto.TableMap, columns []byte) ([]interface{}, int, error) {
+	present := 0
+	for i := range tm.Types {
+		if bitSet(columns, i) {
+			present++
+		}
+	}
+	nullsLen := (present + 7) / 8
+	if pos+nullsLen > len(data) {
+		return nil, 0, fmt.Errorf("NULL bitmap overflows buffer (%v + %v > %v)", pos, nullsLen, len(data))
+	}
+	nulls := data[pos : pos+nullsLen]
+	pos += nullsLen
+
+	image := make([]interface{}, len(tm.Types))
+	n := 0
+	for i, typ := range tm.Types {
+		if !bitSet(columns, i) {
+			continue
+		}
+		isNull := bitSet(nulls, n)
+		n++
+		if isNull {
+			continue
+		}
+		value, length, err := cellValue(data, pos, typ, tm.Metadata[i])
+		if err != nil {
+			return nil, 0, fmt.Errorf("can't read column %v: %v", i, err)
+		}
+		image[i] = value
+		pos += length
+	}
+	return image, pos, nil
+}
+
+// cellValue decodes the value of a column of type typ at data[pos:].
+// It returns the value and the number of bytes it takes.
+func cellValue(data []byte, pos int, typ byte, metadata uint16) (interface{}, int, error) {
+	// prefixed returns the value that follows a little-endian length
+	// prefix of prefixLen bytes.
+	prefixed := func(prefixLen int) (interface{}, int, error) {
+		if pos+prefixLen > len(data) {
+			return nil, 0, fmt.Errorf("length prefix overflows buffer (%v + %v > %v)", pos, prefixLen, len(data))
+		}
+		var length int
+		for i := 0; i < prefixLen; i++ {
+			length |= int(data[pos+i]) << (8 * uint(i))
+		}
+		if pos+prefixLen+length > len(data) {
+			return nil, 0, fmt.Errorf("value overflows buffer (%v + %v > %v)", pos+prefixLen, length, len(data))
+		}
+		return data[pos+prefixLen : pos+prefixLen+length], prefixLen + length, nil
+	}
+
+	var length int
+	switch typ {
+	case mproto.VT_NULL:
+		return nil, 0, nil
+	case mproto.VT_TINY, mproto.VT_YEAR:
+		length = 1
+	case mproto.VT_SHORT:
+		length = 2
+	case mproto.VT_INT24, mproto.VT_DATE, mproto.VT_NEWDATE, mproto.VT_TIME:
+		length = 3
+	case mproto.VT_LONG, mproto.VT_FLOAT, mproto.VT_TIMESTAMP:
+		length = 4
+	case mproto.VT_LONGLONG, mproto.VT_DOUBLE, mproto.VT_DATETIME:
+		length = 8
+	case typeTimestamp2:
+		length = 4 + (int(metadata)+1)/2
+	case typeDatetime2:
+		length = 5 + (int(metadata)+1)/2
+	case typeTime2:
+		length = 3 + (int(metadata)+1)/2
+	case mproto.VT_BIT:
+		length = int(metadata>>8) + (int(metadata&0xff)+7)/8
+	case mproto.VT_NEWDECIMAL:
+		length = decimalLength(int(metadata>>8), int(metadata&0xff))
+	case mproto.VT_ENUM, mproto.VT_SET:
+		length = int(metadata & 0xff)
+	case mproto.VT_VARCHAR, mproto.VT_VAR_STRING:
+		if metadata < 256 {
+			return prefixed(1)
+		}
+		return prefixed(2)
+	case mproto.VT_STRING:
+		// The real type (STRING, ENUM or SET) is in the high byte, and the
+		// length in the low byte. For CHAR columns longer than 255 bytes,
+		// the two high bits of the length are stored, inverted, in bits
+		// 4 and 5 of the real type.
+		realType := byte(metadata >> 8)
+		maxLen := int(metadata & 0xff)
+		if realType&0x30 != 0x30 {
+			maxLen |= int((realType&0x30)^0x30) << 4
+			realType |= 0x30
+		}
+		if realType == mproto.VT_ENUM || realType == mproto.VT_SET {
+			length = maxLen
+			break
+		}
+		if maxLen < 256 {
+			return prefixed(1)
+		}
+		return prefixed(2)
+	case mproto.VT_TINY_BLOB, mproto.VT_MEDIUM_BLOB, mproto.VT_LONG_BLOB, mproto.VT_BLOB, mproto.VT_GEOMETRY:
+		return prefixed(int(metadata))
+	default:
+		return nil, 0, fmt.Errorf("unsupported column type %v", typ)
+	}
+
+	if pos+length > len(data) {
+		return nil, 0, fmt.Errorf("value overflows buffer (%v + %v > %v)", pos, length, len(data))
+	}
+	cell := data[pos : pos+length]
+	// TABLE_MAP_EVENT doesn't say whether an integer column is unsigned,
+	// so integers are decoded as signed.
+	switch typ {
+	case mproto.VT_TINY:
+		return int64(int8(cell[0])), length, nil
+	case mproto.VT_SHORT:
+		return int64(int16(binary.LittleEndian.Uint16(cell))), length, nil
+	case mproto.VT_INT24:
+		v := uint32(cell[0]) | uint32(cell[1])<<8 | uint32(cell[2])<<16
+		if v&0x800000 != 0 {
+			v |= 0xff000000
+		}
+		return int64(int32(v)), length, nil
+	case mproto.VT_LONG:
+		return int64(int32(binary.LittleEndian.Uint32(cell))), length, nil
+	case mproto.VT_LONGLONG:
+		return int64(binary.LittleEndian.Uint64(cell)), length, nil
+	}
+	return cell, length, nil
+}
+
+// decimalLength returns the size of a DECIMAL(precision, scale) value.
+// Every 9 digits take 4 bytes, and the leftover digits of the integer
+// and fractional parts take the size in digitsLength.
+func decimalLength(precision, scale int) int {
+	digitsLength := [10]int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+	integer := precision - scale
+	return integer/9*4 + digitsLength[integer%9] + scale/9*4 + digitsLength[scale%9]
+}
+
+// readTableMapName reads a length-prefixed, NULL-terminated name,
+// and returns the position after it.
+func readTableMapName(data []byte, pos int) (string, int, error) {
+	if pos+1 > len(data) {
+		return "", 0, fmt.Errorf("name length overflows buffer (%v + 1 > %v)", pos, len(data))
+	}
+	nameLen := int(data[pos])
+	pos++
+	if pos+nameLen+1 > len(data) {
+		return "", 0, fmt.Errorf("name overflows buffer (%v + %v > %v)", pos, nameLen+1, len(data))
+	}
+	return string(data[pos : pos+nameLen]), pos + nameLen + 1, nil
+}
+
+// readLenEncInt reads a length-encoded integer, and returns the
+// position after it.
+func readLenEncInt(data []byte, pos int) (uint64, int, error) {
+	if pos+1 > len(data) {
+		return 0, 0, fmt.Errorf("integer overflows buffer (%v + 1 > %v)", pos, len(data))
+	}
+	var size int
+	switch data[pos] {
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	case 0xfb, 0xff:
+		return 0, 0, fmt.Errorf("invalid length-encoded integer prefix: %v", data[pos])
+	default:
+		return uint64(data[pos]), pos + 1, nil
+	}
+	pos++
+	if pos+size > len(data) {
+		return 0, 0, fmt.Errorf("integer overflows buffer (%v + %v > %v)", pos, size, len(data))
+	}
+	var value uint64
+	for i := 0; i < size; i++ {
+		value |= uint64(data[pos+i]) << (8 * uint(i))
+	}
+	return value, pos + size, nil
+}
+
+// bitSet returns true if bit i of the little-endian bitmap is set.
+func bitSet(bitmap []byte, i int) bool {
+	return bitmap[i/8]&(1<<uint(i%8)) != 0
+}
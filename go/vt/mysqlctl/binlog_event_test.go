@@ -27,6 +27,12 @@ var (
 	mariadbBeginGTIDEvent      = []byte{0x88, 0x41, 0x9, 0x54, 0xa2, 0x88, 0xf3, 0x0, 0x0, 0x26, 0x0, 0x0, 0x0, 0xb5, 0x9, 0x0, 0x0, 0x8, 0x0, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
 	mariadbInsertEvent         = []byte{0x88, 0x41, 0x9, 0x54, 0x2, 0x88, 0xf3, 0x0, 0x0, 0xa8, 0x0, 0x0, 0x0, 0x79, 0xa, 0x0, 0x0, 0x0, 0x0, 0x27, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x21, 0x0, 0x21, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x20, 0x69, 0x6e, 0x74, 0x6f, 0x20, 0x76, 0x74, 0x5f, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x28, 0x6d, 0x73, 0x67, 0x29, 0x20, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x73, 0x20, 0x28, 0x27, 0x74, 0x65, 0x73, 0x74, 0x20, 0x30, 0x27, 0x29, 0x20, 0x2f, 0x2a, 0x20, 0x5f, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x20, 0x76, 0x74, 0x5f, 0x69, 0x6e, 0x73, 0x65, 0x72, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x20, 0x28, 0x69, 0x64, 0x20, 0x29, 0x20, 0x28, 0x6e, 0x75, 0x6c, 0x6c, 0x20, 0x29, 0x3b, 0x20, 0x2a, 0x2f}
 
+	// row-based replication events of a table t1(id bigint, name varchar(10), msg int)
+	mysql56TableMapEvent   = []byte{0x52, 0x52, 0xe9, 0x53, 0x13, 0x88, 0xf3, 0x0, 0x0, 0x2d, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x66, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x4, 0x74, 0x65, 0x73, 0x74, 0x0, 0x2, 0x74, 0x31, 0x0, 0x3, 0x8, 0xf, 0x3, 0x2, 0xa, 0x0, 0x4}
+	mysql56WriteRowsEvent  = []byte{0x52, 0x52, 0xe9, 0x53, 0x1e, 0x88, 0xf3, 0x0, 0x0, 0x3b, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x66, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x2, 0x0, 0x3, 0x7, 0x4, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x61, 0x62, 0x63, 0x0, 0x2, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x78, 0xff, 0xff, 0xff, 0xff}
+	mysql56UpdateRowsEvent = []byte{0x52, 0x52, 0xe9, 0x53, 0x1f, 0x88, 0xf3, 0x0, 0x0, 0x3e, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x66, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x2, 0x0, 0x3, 0x7, 0x7, 0x4, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x61, 0x62, 0x63, 0x0, 0x1, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x3, 0x61, 0x62, 0x63, 0x5, 0x0, 0x0, 0x0}
+	mariadbDeleteRowsEvent = []byte{0x52, 0x52, 0xe9, 0x53, 0x19, 0x88, 0xf3, 0x0, 0x0, 0x26, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x66, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x3, 0x1, 0x0, 0x2, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}
+
 	mariadbChecksumFormatEvent        = []byte{0x22, 0xe5, 0x3e, 0x54, 0xf, 0x8b, 0xf3, 0x0, 0x0, 0xf4, 0x0, 0x0, 0x0, 0xf8, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x0, 0x31, 0x30, 0x2e, 0x30, 0x2e, 0x31, 0x33, 0x2d, 0x4d, 0x61, 0x72, 0x69, 0x61, 0x44, 0x42, 0x2d, 0x31, 0x7e, 0x70, 0x72, 0x65, 0x63, 0x69, 0x73, 0x65, 0x2d, 0x6c, 0x6f, 0x67, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x13, 0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0xdc, 0x0, 0x4, 0x1a, 0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2, 0x0, 0x0, 0x0, 0xa, 0xa, 0xa, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x4, 0x13, 0x4, 0x1, 0x14, 0x13, 0x32, 0xdc}
 	mariadbChecksumQueryEvent         = []byte{0x22, 0xe5, 0x3e, 0x54, 0x2, 0x8a, 0xf3, 0x0, 0x0, 0xd9, 0x0, 0x0, 0x0, 0x69, 0x2, 0x0, 0x0, 0x0, 0x0, 0x1d, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x8, 0x0, 0x8, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x20, 0x5f, 0x76, 0x74, 0x2e, 0x62, 0x6c, 0x70, 0x5f, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x20, 0x53, 0x45, 0x54, 0x20, 0x70, 0x6f, 0x73, 0x3d, 0x27, 0x4d, 0x61, 0x72, 0x69, 0x61, 0x44, 0x42, 0x2f, 0x30, 0x2d, 0x36, 0x32, 0x33, 0x34, 0x34, 0x2d, 0x31, 0x34, 0x27, 0x2c, 0x20, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x3d, 0x31, 0x34, 0x31, 0x33, 0x34, 0x30, 0x38, 0x30, 0x33, 0x34, 0x2c, 0x20, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x3d, 0x31, 0x34, 0x31, 0x33, 0x34, 0x30, 0x38, 0x30, 0x33, 0x34, 0x20, 0x57, 0x48, 0x45, 0x52, 0x45, 0x20, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x64, 0x5f, 0x75, 0x69, 0x64, 0x3d, 0x30, 0xce, 0x49, 0x7a, 0x53}
 	mariadbChecksumStrippedQueryEvent = []byte{0x22, 0xe5, 0x3e, 0x54, 0x2, 0x8a, 0xf3, 0x0, 0x0, 0xd9, 0x0, 0x0, 0x0, 0x69, 0x2, 0x0, 0x0, 0x0, 0x0, 0x1d, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x10, 0x0, 0x0, 0x1a, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0, 0x20, 0x0, 0x0, 0x0, 0x0, 0x0, 0x6, 0x3, 0x73, 0x74, 0x64, 0x4, 0x8, 0x0, 0x8, 0x0, 0x21, 0x0, 0x76, 0x74, 0x5f, 0x74, 0x65, 0x73, 0x74, 0x5f, 0x6b, 0x65, 0x79, 0x73, 0x70, 0x61, 0x63, 0x65, 0x0, 0x55, 0x50, 0x44, 0x41, 0x54, 0x45, 0x20, 0x5f, 0x76, 0x74, 0x2e, 0x62, 0x6c, 0x70, 0x5f, 0x63, 0x68, 0x65, 0x63, 0x6b, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x20, 0x53, 0x45, 0x54, 0x20, 0x70, 0x6f, 0x73, 0x3d, 0x27, 0x4d, 0x61, 0x72, 0x69, 0x61, 0x44, 0x42, 0x2f, 0x30, 0x2d, 0x36, 0x32, 0x33, 0x34, 0x34, 0x2d, 0x31, 0x34, 0x27, 0x2c, 0x20, 0x74, 0x69, 0x6d, 0x65, 0x5f, 0x75, 0x70, 0x64, 0x61, 0x74, 0x65, 0x64, 0x3d, 0x31, 0x34, 0x31, 0x33, 0x34, 0x30, 0x38, 0x30, 0x33, 0x34, 0x2c, 0x20, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x61, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x3d, 0x31, 0x34, 0x31, 0x33, 0x34, 0x30, 0x38, 0x30, 0x33, 0x34, 0x20, 0x57, 0x48, 0x45, 0x52, 0x45, 0x20, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x73, 0x68, 0x61, 0x72, 0x64, 0x5f, 0x75, 0x69, 0x64, 0x3d, 0x30}
@@ -346,3 +352,157 @@ func TestBinlogEventIntVarBadID(t *testing.T) {
 		t.Errorf("wrong error, got %#v, want %#v", got, want)
 	}
 }
+
+func TestBinlogEventIsTableMap(t *testing.T) {
+	input := binlogEvent(mysql56TableMapEvent)
+	want := true
+	if got := input.IsTableMap(); got != want {
+		t.Errorf("%#v.IsTableMap() = %v, want %v", input, got, want)
+	}
+}
+
+func TestBinlogEventIsRows(t *testing.T) {
+	table := []struct {
+		input                 binlogEvent
+		write, update, delete bool
+	}{
+		{binlogEvent(mysql56WriteRowsEvent), true, false, false},
+		{binlogEvent(mysql56UpdateRowsEvent), false, true, false},
+		{binlogEvent(mariadbDeleteRowsEvent), false, false, true},
+		{binlogEvent(mysql56TableMapEvent), false, false, false},
+	}
+	for _, tcase := range table {
+		if got := tcase.input.IsWriteRows(); got != tcase.write {
+			t.Errorf("%#v.IsWriteRows() = %v, want %v", tcase.input, got, tcase.write)
+		}
+		if got := tcase.input.IsUpdateRows(); got != tcase.update {
+			t.Errorf("%#v.IsUpdateRows() = %v, want %v", tcase.input, got, tcase.update)
+		}
+		if got := tcase.input.IsDeleteRows(); got != tcase.delete {
+			t.Errorf("%#v.IsDeleteRows() = %v, want %v", tcase.input, got, tcase.delete)
+		}
+	}
+}
+
+func TestBinlogEventTableMap(t *testing.T) {
+	f := blproto.BinlogFormat{HeaderLength: 19}
+	input := binlogEvent(mysql56TableMapEvent)
+	if got, want := input.TableID(f), uint64(0x66); got != want {
+		t.Errorf("%#v.TableID() = %v, want %v", input, got, want)
+	}
+	want := &blproto.TableMap{
+		Database: "test",
+		Name:     "t1",
+		Types:    []byte{mproto.VT_LONGLONG, mproto.VT_VARCHAR, mproto.VT_LONG},
+		Metadata: []uint16{0, 10, 0},
+	}
+	got, err := input.TableMap(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("%#v.TableMap() = %#v, want %#v", input, got, want)
+	}
+}
+
+func TestBinlogEventTableMapBadLength(t *testing.T) {
+	f := blproto.BinlogFormat{HeaderLength: 19}
+	buf := make([]byte, len(mysql56TableMapEvent)-3)
+	copy(buf, mysql56TableMapEvent)
+	buf[9] = byte(len(buf))
+	input := binlogEvent(buf)
+	want := "metadata block overflows buffer (23 + 2 > 23)"
+	if _, err := input.TableMap(f); err == nil || err.Error() != want {
+		t.Errorf("wrong error, got %v, want %v", err, want)
+	}
+}
+
+func TestBinlogEventRows(t *testing.T) {
+	f := blproto.BinlogFormat{HeaderLength: 19}
+	tm, err := binlogEvent(mysql56TableMapEvent).TableMap(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	table := []struct {
+		input binlogEvent
+		want  []blproto.Row
+	}{{
+		input: binlogEvent(mysql56WriteRowsEvent),
+		want: []blproto.Row{
+			{Data: []interface{}{int64(1), []byte("abc"), nil}},
+			{Data: []interface{}{int64(2), []byte("x"), int64(-1)}},
+		},
+	}, {
+		input: binlogEvent(mysql56UpdateRowsEvent),
+		want: []blproto.Row{{
+			Identify: []interface{}{int64(1), []byte("abc"), nil},
+			Data:     []interface{}{int64(1), []byte("abc"), int64(5)},
+		}},
+	}, {
+		input: binlogEvent(mariadbDeleteRowsEvent),
+		want: []blproto.Row{
+			{Identify: []interface{}{int64(2), nil, nil}},
+		},
+	}}
+	for _, tcase := range table {
+		if got, want := tcase.input.TableID(f), uint64(0x66); got != want {
+			t.Errorf("%#v.TableID() = %v, want %v", tcase.input, got, want)
+		}
+		got, err := tcase.input.Rows(f, tm)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tcase.want) {
+			t.Errorf("%#v.Rows() = %#v, want %#v", tcase.input, got, tcase.want)
+		}
+	}
+}
+
+func TestBinlogEventRowsBadLength(t *testing.T) {
+	f := blproto.BinlogFormat{HeaderLength: 19}
+	tm, err := binlogEvent(mysql56TableMapEvent).TableMap(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	buf := make([]byte, len(mysql56WriteRowsEvent)-2)
+	copy(buf, mysql56WriteRowsEvent)
+	buf[9] = byte(len(buf))
+	input := binlogEvent(buf)
+	want := "can't read row 1: can't read column 2: value overflows buffer (36 + 4 > 38)"
+	if _, err := input.Rows(f, tm); err == nil || err.Error() != want {
+		t.Errorf("wrong error, got %v, want %v", err, want)
+	}
+}
+
+func TestCellValue(t *testing.T) {
+	table := []struct {
+		data     []byte
+		typ      byte
+		metadata uint16
+		value    interface{}
+		length   int
+	}{
+		{[]byte{0xff}, mproto.VT_TINY, 0, int64(-1), 1},
+		{[]byte{0xfe, 0xff}, mproto.VT_SHORT, 0, int64(-2), 2},
+		{[]byte{0xfd, 0xff, 0xff}, mproto.VT_INT24, 0, int64(-3), 3},
+		{[]byte{0x1, 0x2, 0x3}, mproto.VT_INT24, 0, int64(0x030201), 3},
+		{[]byte{0x2, 0x61, 0x62, 0x63}, mproto.VT_STRING, mproto.VT_STRING<<8 | 10, []byte("ab"), 3},
+		// CHAR(300) stores the high bits of its length in the real type.
+		{[]byte{0x1, 0x0, 0x61}, mproto.VT_STRING, (mproto.VT_STRING&^0x10)<<8 | 0x2c, []byte("a"), 3},
+		{[]byte{0x2, 0x0}, mproto.VT_STRING, mproto.VT_ENUM<<8 | 2, []byte{0x2, 0x0}, 2},
+		{[]byte{0x2, 0x0, 0x61, 0x62}, mproto.VT_BLOB, 2, []byte("ab"), 4},
+		{[]byte{0x80, 0x0, 0x1, 0x0, 0x0}, mproto.VT_NEWDECIMAL, 10<<8 | 2, []byte{0x80, 0x0, 0x1, 0x0, 0x0}, 5},
+		{[]byte{0x1, 0x2, 0x3, 0x4, 0x5, 0x6}, typeDatetime2, 2, []byte{0x1, 0x2, 0x3, 0x4, 0x5, 0x6}, 6},
+	}
+	for _, tcase := range table {
+		value, length, err := cellValue(tcase.data, 0, tcase.typ, tcase.metadata)
+		if err != nil {
+			t.Errorf("cellValue(%v, %v): %v", tcase.data, tcase.typ, err)
+			continue
+		}
+		if !reflect.DeepEqual(value, tcase.value) || length != tcase.length {
+			t.Errorf("cellValue(%v, %v) = %#v, %v, want %#v, %v", tcase.data, tcase.typ, value, length, tcase.value, tcase.length)
+		}
+	}
+}
@@ -86,6 +86,19 @@ func (x ExecuteEntityIdsRequest_EntityId_Type) String() string {
 type Session struct {
 	InTransaction bool                    `protobuf:"varint,1,opt,name=in_transaction" json:"in_transaction,omitempty"`
 	ShardSessions []*Session_ShardSession `protobuf:"bytes,2,rep,name=shard_sessions" json:"shard_sessions,omitempty"`
+	// pre_sessions and post_sessions are committed before and
+	// after shard_sessions.
+	PreSessions  []*Session_ShardSession `protobuf:"bytes,3,rep,name=pre_sessions" json:"pre_sessions,omitempty"`
+	PostSessions []*Session_ShardSession `protobuf:"bytes,4,rep,name=post_sessions" json:"post_sessions,omitempty"`
+	// transaction_mode is single, multi or twopc.
+	// If empty, the default mode of the vtgate is used.
+	TransactionMode string `protobuf:"bytes,5,opt,name=transaction_mode" json:"transaction_mode,omitempty"`
+	// If read_after_write is set, the commits record the replication
+	// position of the masters in write_positions, and the replica and
+	// rdonly reads wait until their tablet has replicated up to the
+	// position of its shard.
+	ReadAfterWrite bool                     `protobuf:"varint,6,opt,name=read_after_write" json:"read_after_write,omitempty"`
+	WritePositions []*Session_WritePosition `protobuf:"bytes,7,rep,name=write_positions" json:"write_positions,omitempty"`
 }
 
 func (m *Session) Reset()         { *m = Session{} }
@@ -99,6 +112,27 @@ func (m *Session) GetShardSessions() []*Session_ShardSession {
 	return nil
 }
 
+func (m *Session) GetPreSessions() []*Session_ShardSession {
+	if m != nil {
+		return m.PreSessions
+	}
+	return nil
+}
+
+func (m *Session) GetPostSessions() []*Session_ShardSession {
+	if m != nil {
+		return m.PostSessions
+	}
+	return nil
+}
+
+func (m *Session) GetWritePositions() []*Session_WritePosition {
+	if m != nil {
+		return m.WritePositions
+	}
+	return nil
+}
+
 type Session_ShardSession struct {
 	Target        *query.Target `protobuf:"bytes,1,opt,name=target" json:"target,omitempty"`
 	TransactionId int64         `protobuf:"varint,2,opt,name=transaction_id" json:"transaction_id,omitempty"`
@@ -115,6 +149,16 @@ func (m *Session_ShardSession) GetTarget() *query.Target {
 	return nil
 }
 
+type Session_WritePosition struct {
+	Keyspace string `protobuf:"bytes,1,opt,name=keyspace" json:"keyspace,omitempty"`
+	Shard    string `protobuf:"bytes,2,opt,name=shard" json:"shard,omitempty"`
+	Position string `protobuf:"bytes,3,opt,name=position" json:"position,omitempty"`
+}
+
+func (m *Session_WritePosition) Reset()         { *m = Session_WritePosition{} }
+func (m *Session_WritePosition) String() string { return proto.CompactTextString(m) }
+func (*Session_WritePosition) ProtoMessage()    {}
+
 // ExecuteRequest is the payload to Execute
 type ExecuteRequest struct {
 	CallerId         *vtrpc.CallerID     `protobuf:"bytes,1,opt,name=caller_id" json:"caller_id,omitempty"`
@@ -829,6 +873,9 @@ func (m *StreamExecuteKeyRangesResponse) GetResult() *query.QueryResult {
 // BeginRequest is the payload to Begin
 type BeginRequest struct {
 	CallerId *vtrpc.CallerID `protobuf:"bytes,1,opt,name=caller_id" json:"caller_id,omitempty"`
+	// session is optional. The transaction keeps its
+	// read-after-write state.
+	Session *Session `protobuf:"bytes,2,opt,name=session" json:"session,omitempty"`
 }
 
 func (m *BeginRequest) Reset()         { *m = BeginRequest{} }
@@ -842,6 +889,13 @@ func (m *BeginRequest) GetCallerId() *vtrpc.CallerID {
 	return nil
 }
 
+func (m *BeginRequest) GetSession() *Session {
+	if m != nil {
+		return m.Session
+	}
+	return nil
+}
+
 // BeginResponse is the returned value from Begin
 type BeginResponse struct {
 	Error   *vtrpc.RPCError `protobuf:"bytes,1,opt,name=error" json:"error,omitempty"`
@@ -893,6 +947,8 @@ func (m *CommitRequest) GetSession() *Session {
 // CommitResponse is the returned value from Commit
 type CommitResponse struct {
 	Error *vtrpc.RPCError `protobuf:"bytes,1,opt,name=error" json:"error,omitempty"`
+	// session is the session without the transaction.
+	Session *Session `protobuf:"bytes,2,opt,name=session" json:"session,omitempty"`
 }
 
 func (m *CommitResponse) Reset()         { *m = CommitResponse{} }
@@ -906,6 +962,13 @@ func (m *CommitResponse) GetError() *vtrpc.RPCError {
 	return nil
 }
 
+func (m *CommitResponse) GetSession() *Session {
+	if m != nil {
+		return m.Session
+	}
+	return nil
+}
+
 // RollbackRequest is the payload to Rollback
 type RollbackRequest struct {
 	CallerId *vtrpc.CallerID `protobuf:"bytes,1,opt,name=caller_id" json:"caller_id,omitempty"`
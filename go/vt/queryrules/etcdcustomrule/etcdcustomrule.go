@@ -0,0 +1,214 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package etcdcustomrule implements dynamic query rules from an etcd
+// key, so they can be changed without restarting vttablet or vtgate.
+package etcdcustomrule
+
+import (
+	"flag"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/flagutil"
+	"github.com/youtube/vitess/go/vt/queryrules"
+	"github.com/youtube/vitess/go/vt/servenv"
+)
+
+var (
+	// Actual EtcdCustomRule object in charge of rule updates,
+	// created when the rules are activated.
+	etcdCustomRule *EtcdCustomRule
+	// Commandline flags to specify the etcd cluster and the rule key in it
+	etcdRuleAddrs flagutil.StringListValue
+	etcdRulePath  = flag.String("etcdcustomrules", "", "etcd based custom rule key")
+)
+
+// InvalidQueryRulesVersion is used to mark invalid query rules
+const InvalidQueryRulesVersion int64 = -1
+
+// EtcdCustomRuleSource is the name of the etcd based custom rule source
+const EtcdCustomRuleSource string = "ETCD_CUSTOM_RULE"
+
+// Error codes returned by etcd, see etcdtopo.
+const (
+	ecodeKeyNotFound       = 100
+	ecodeEventIndexCleared = 401
+)
+
+// sleepDuringEtcdFailure is how long poll waits before it watches
+// the rules again after an error.
+var sleepDuringEtcdFailure = 30 * time.Second
+
+// Client contains the parts of etcd.Client that are needed.
+type Client interface {
+	Get(key string, sort, recursive bool) (*etcd.Response, error)
+	Watch(prefix string, waitIndex uint64, recursive bool,
+		receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error)
+}
+
+// EtcdCustomRule watches the query rules in an etcd key
+// and pushes their changes to a queryrules.Sink.
+type EtcdCustomRule struct {
+	mu                    sync.Mutex
+	path                  string
+	client                Client
+	waitIndex             uint64 // etcd index from which poll watches the changes
+	currentRuleSet        *queryrules.QueryRules
+	currentRuleSetVersion int64 // implemented with etcd modified index
+	stop                  chan bool
+}
+
+// NewEtcdCustomRule creates a new EtcdCustomRule structure
+func NewEtcdCustomRule(client Client) *EtcdCustomRule {
+	return &EtcdCustomRule{
+		client:                client,
+		currentRuleSet:        queryrules.NewQueryRules(nil),
+		currentRuleSetVersion: InvalidQueryRulesVersion,
+		stop:                  make(chan bool),
+	}
+}
+
+// Open gets the initial QueryRules and starts the polling routine.
+// A missing key means no rules. If the rules can't be read, Open
+// returns the error, and the polling routine keeps trying to read them.
+func (ecr *EtcdCustomRule) Open(sink queryrules.Sink, rulePath string) error {
+	ecr.path = rulePath
+	err := ecr.refreshData(sink)
+	go ecr.poll(sink, err != nil)
+	return err
+}
+
+// refreshData gets the query rules from etcd and applies them. The
+// changes after this version are watched by poll.
+func (ecr *EtcdCustomRule) refreshData(sink queryrules.Sink) error {
+	resp, err := ecr.client.Get(ecr.path, false /* sort */, false /* recursive */)
+	if err != nil {
+		if etcdErr, ok := err.(*etcd.EtcdError); ok && etcdErr.ErrorCode == ecodeKeyNotFound {
+			ecr.waitIndex = etcdErr.Index + 1
+			ecr.apply(sink, "", int64(etcdErr.Index))
+			return nil
+		}
+		log.Warningf("Error encountered when trying to get data from etcd: %v", err)
+		return err
+	}
+	if resp.Node == nil {
+		return fmt.Errorf("etcd response for %v is missing its node", ecr.path)
+	}
+	ecr.waitIndex = resp.EtcdIndex + 1
+	ecr.apply(sink, resp.Node.Value, int64(resp.Node.ModifiedIndex))
+	return nil
+}
+
+// apply parses the query rules, and pushes them to sink if they
+// changed. Invalid rules are ignored: the previous ones stay.
+func (ecr *EtcdCustomRule) apply(sink queryrules.Sink, data string, version int64) {
+	qrs := sink.NewQueryRules()
+	if data != "" {
+		if err := qrs.UnmarshalJSON([]byte(data)); err != nil {
+			log.Warningf("Error unmarshaling query rules %v, original data '%s'", err, data)
+			return
+		}
+	}
+	ecr.mu.Lock()
+	defer ecr.mu.Unlock()
+	ecr.currentRuleSetVersion = version
+	if !reflect.DeepEqual(ecr.currentRuleSet, qrs) {
+		if err := sink.SetQueryRules(EtcdCustomRuleSource, qrs.Copy()); err != nil {
+			log.Warningf("Error applying custom rule version %v fetched from etcd: %v", version, err)
+			return
+		}
+		ecr.currentRuleSet = qrs.Copy()
+		log.Infof("Custom rule version %v fetched from etcd and applied", version)
+	}
+}
+
+// poll watches the rule key for changes until Close is called.
+// If refresh is set, it first reads the current rules.
+func (ecr *EtcdCustomRule) poll(sink queryrules.Sink, refresh bool) {
+	for {
+		var err error
+		if refresh {
+			err = ecr.refreshData(sink)
+			refresh = err != nil
+		} else {
+			err = ecr.watch(sink)
+			if etcdErr, ok := err.(*etcd.EtcdError); ok && etcdErr.ErrorCode == ecodeEventIndexCleared {
+				// The changes since waitIndex are gone from the
+				// etcd history: start over from the current rules.
+				refresh = true
+				continue
+			}
+		}
+		select {
+		case <-ecr.stop:
+			return
+		default:
+		}
+		if err != nil {
+			log.Warningf("Watch on %v failed, waiting for %v to retry: %v", ecr.path, sleepDuringEtcdFailure, err)
+			select {
+			case <-ecr.stop:
+				return
+			case <-time.After(sleepDuringEtcdFailure):
+			}
+		}
+	}
+}
+
+// watch waits for the next change of the rule key and applies it.
+// A deleted or expired key removes the rules.
+func (ecr *EtcdCustomRule) watch(sink queryrules.Sink) error {
+	resp, err := ecr.client.Watch(ecr.path, ecr.waitIndex, false /* recursive */, nil, ecr.stop)
+	if err != nil {
+		return err
+	}
+	if resp.Node == nil {
+		return fmt.Errorf("etcd watch response for %v is missing its node", ecr.path)
+	}
+	ecr.waitIndex = resp.Node.ModifiedIndex + 1
+	switch resp.Action {
+	case "delete", "compareAndDelete", "expire":
+		ecr.apply(sink, "", int64(resp.Node.ModifiedIndex))
+	default:
+		ecr.apply(sink, resp.Node.Value, int64(resp.Node.ModifiedIndex))
+	}
+	return nil
+}
+
+// Close signals a termination to the polling routine
+func (ecr *EtcdCustomRule) Close() {
+	close(ecr.stop)
+}
+
+// GetRules retrieves cached rules
+func (ecr *EtcdCustomRule) GetRules() (qrs *queryrules.QueryRules, version int64, err error) {
+	ecr.mu.Lock()
+	defer ecr.mu.Unlock()
+	return ecr.currentRuleSet.Copy(), ecr.currentRuleSetVersion, nil
+}
+
+// ActivateEtcdCustomRules activates etcd dynamic custom rule mechanism
+func ActivateEtcdCustomRules(sink queryrules.Sink) {
+	if *etcdRulePath != "" {
+		sink.RegisterQueryRuleSource(EtcdCustomRuleSource)
+		etcdCustomRule = NewEtcdCustomRule(etcd.NewClient(etcdRuleAddrs))
+		if err := etcdCustomRule.Open(sink, *etcdRulePath); err != nil {
+			log.Errorf("Cannot open etcd custom rules %v, will keep trying: %v", *etcdRulePath, err)
+		}
+	}
+}
+
+func init() {
+	flag.Var(&etcdRuleAddrs, "etcdcustomrules_addrs", "comma-separated list of addresses (http://host:port) of the etcd cluster that holds the custom rules")
+	servenv.OnTerm(func() {
+		if etcdCustomRule != nil {
+			etcdCustomRule.Close()
+		}
+	})
+}
@@ -0,0 +1,255 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package etcdcustomrule
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/youtube/vitess/go/vt/queryrules"
+	"github.com/youtube/vitess/go/vt/vtgate"
+)
+
+var customRule1 = `[
+				{
+					"Name": "r1",
+					"Description": "disallow bindvar 'asdfg'",
+					"BindVarConds":[{
+						"Name": "asdfg",
+						"OnAbsent": false,
+						"Operator": "NOOP"
+					}]
+				}
+			]`
+
+var customRule2 = `[
+				{
+					"Name": "r2",
+					"Description": "disallow insert on table test",
+					"TableNames" : ["test"],
+					"Query" : "(insert)|(INSERT)"
+				}
+			]`
+
+const rulePath = "/vt/customrules/testrules"
+
+// fakeClient is an in-memory etcd that holds a single key.
+type fakeClient struct {
+	mu         sync.Mutex
+	index      uint64
+	node       *etcd.Node
+	events     []*etcd.Response
+	changed    chan struct{}
+	getErr     error  // returned by Get, if set
+	watchIndex uint64 // waitIndex of the last Watch
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{changed: make(chan struct{})}
+}
+
+func (c *fakeClient) Get(key string, sort, recursive bool) (*etcd.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.getErr != nil {
+		return nil, c.getErr
+	}
+	if c.node == nil || key != c.node.Key {
+		return nil, &etcd.EtcdError{ErrorCode: ecodeKeyNotFound, Index: c.index}
+	}
+	node := *c.node
+	return &etcd.Response{Action: "get", Node: &node, EtcdIndex: c.index}, nil
+}
+
+func (c *fakeClient) Watch(prefix string, waitIndex uint64, recursive bool, receiver chan *etcd.Response, stop chan bool) (*etcd.Response, error) {
+	c.mu.Lock()
+	c.watchIndex = waitIndex
+	c.mu.Unlock()
+	for {
+		c.mu.Lock()
+		for _, event := range c.events {
+			if event.Node.Key == prefix && event.Node.ModifiedIndex >= waitIndex {
+				c.mu.Unlock()
+				return event, nil
+			}
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-stop:
+			return nil, etcd.ErrWatchStoppedByUser
+		}
+	}
+}
+
+// update records a change of the key, and wakes up the watches.
+func (c *fakeClient) update(action, value string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.index++
+	node := &etcd.Node{Key: rulePath, Value: value, ModifiedIndex: c.index}
+	if action == "delete" {
+		c.node = nil
+	} else {
+		c.node = node
+	}
+	c.events = append(c.events, &etcd.Response{Action: action, Node: node, EtcdIndex: c.index})
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// setGetErr makes Get fail with err, or succeed again if err is nil.
+func (c *fakeClient) setGetErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.getErr = err
+}
+
+// waitForWatch waits until the key is watched from waitIndex,
+// i.e. the changes before it were handled.
+func (c *fakeClient) waitForWatch(t *testing.T, waitIndex uint64) {
+	for i := 0; i < 100; i++ {
+		c.mu.Lock()
+		watchIndex := c.watchIndex
+		c.mu.Unlock()
+		if watchIndex == waitIndex {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for a watch from index %v", waitIndex)
+}
+
+// waitForVersion waits until ecr applied the version of the key.
+func waitForVersion(t *testing.T, ecr *EtcdCustomRule, version int64) *queryrules.QueryRules {
+	for i := 0; i < 100; i++ {
+		qrs, v, err := ecr.GetRules()
+		if err != nil {
+			t.Fatalf("GetRules of EtcdCustomRule should always return nil error, but we receive %v", err)
+		}
+		if v == version {
+			return qrs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for custom rule version %v", version)
+	return nil
+}
+
+func TestEtcdCustomRule(t *testing.T) {
+	vtgate.QueryRuleSources.RegisterQueryRuleSource(EtcdCustomRuleSource)
+	defer vtgate.QueryRuleSources.UnRegisterQueryRuleSource(EtcdCustomRuleSource)
+
+	client := newFakeClient()
+	client.update("set", customRule1)
+	ecr := NewEtcdCustomRule(client)
+	if err := ecr.Open(vtgate.QueryRuleSink, rulePath); err != nil {
+		t.Fatalf("Cannot open etcd custom rule service, err=%v", err)
+	}
+	defer ecr.Close()
+
+	// Test if we can successfully fetch the original rule (test GetRules)
+	qrs := waitForVersion(t, ecr, 1)
+	if qrs.Find("r1") == nil {
+		t.Fatalf("Expect custom rule r1 to be found, but got nothing, qrs=%v", qrs)
+	}
+
+	// Test updating rules
+	client.update("set", customRule2)
+	qrs = waitForVersion(t, ecr, 2)
+	if qrs.Find("r2") == nil {
+		t.Fatalf("Expect custom rule r2 to be found, but got nothing, qrs=%v", qrs)
+	}
+	if qrs.Find("r1") != nil {
+		t.Fatalf("Custom rule r1 should not be found after r2 is set")
+	}
+
+	// Test invalid rules: the previous ones stay
+	client.update("set", "invalid")
+	client.waitForWatch(t, 4)
+	qrs, version, _ := ecr.GetRules()
+	if version != 2 || qrs.Find("r2") == nil {
+		t.Fatalf("Expect custom rule r2 of version 2 to stay, but got version %v, qrs=%v", version, qrs)
+	}
+	qrs, err := vtgate.QueryRuleSources.GetRules(EtcdCustomRuleSource)
+	if err != nil {
+		t.Fatalf("GetRules: %v", err)
+	}
+	if qrs.Find("r2") == nil {
+		t.Fatalf("Expect custom rule r2 to stay in vtgate, but got nothing, qrs=%v", qrs)
+	}
+
+	// Test rule key removal
+	client.update("delete", "")
+	qrs = waitForVersion(t, ecr, 4)
+	if qrs.Find("r2") != nil {
+		t.Fatalf("Expect empty rule at this point, qrs=%v", qrs)
+	}
+
+	// Test rule key revival
+	client.update("set", customRule1)
+	qrs = waitForVersion(t, ecr, 5)
+	if qrs.Find("r1") == nil {
+		t.Fatalf("Expect custom rule r1 to be found, but got nothing, qrs=%v", qrs)
+	}
+}
+
+func TestEtcdCustomRuleMissingKey(t *testing.T) {
+	vtgate.QueryRuleSources.RegisterQueryRuleSource(EtcdCustomRuleSource)
+	defer vtgate.QueryRuleSources.UnRegisterQueryRuleSource(EtcdCustomRuleSource)
+
+	client := newFakeClient()
+	ecr := NewEtcdCustomRule(client)
+	if err := ecr.Open(vtgate.QueryRuleSink, rulePath); err != nil {
+		t.Fatalf("Cannot open etcd custom rule service, err=%v", err)
+	}
+	defer ecr.Close()
+
+	qrs := waitForVersion(t, ecr, 0)
+	if qrs.Find("r1") != nil {
+		t.Fatalf("Expect empty rule at this point, qrs=%v", qrs)
+	}
+
+	// Test rule key creation
+	client.update("create", customRule1)
+	qrs = waitForVersion(t, ecr, 1)
+	if qrs.Find("r1") == nil {
+		t.Fatalf("Expect custom rule r1 to be found, but got nothing, qrs=%v", qrs)
+	}
+}
+
+func TestEtcdCustomRuleOpenFailure(t *testing.T) {
+	vtgate.QueryRuleSources.RegisterQueryRuleSource(EtcdCustomRuleSource)
+	defer vtgate.QueryRuleSources.UnRegisterQueryRuleSource(EtcdCustomRuleSource)
+	sleepDuringEtcdFailure = 10 * time.Millisecond
+
+	client := newFakeClient()
+	client.update("set", customRule1)
+	client.setGetErr(errors.New("etcd is down"))
+	ecr := NewEtcdCustomRule(client)
+	if err := ecr.Open(vtgate.QueryRuleSink, rulePath); err == nil {
+		t.Fatalf("Open should fail while etcd is down")
+	}
+	defer ecr.Close()
+
+	// The rules are read once etcd is back
+	client.setGetErr(nil)
+	qrs := waitForVersion(t, ecr, 1)
+	if qrs.Find("r1") == nil {
+		t.Fatalf("Expect custom rule r1 to be found, but got nothing, qrs=%v", qrs)
+	}
+
+	// and their changes are watched
+	client.update("set", customRule2)
+	qrs = waitForVersion(t, ecr, 2)
+	if qrs.Find("r2") == nil {
+		t.Fatalf("Expect custom rule r2 to be found, but got nothing, qrs=%v", qrs)
+	}
+}
@@ -3,6 +3,7 @@
 // license that can be found in the LICENSE file.
 
 // Package filecustomrule implements static custom rule from a config file
+// for vttablet or vtgate.
 package filecustomrule
 
 import (
@@ -11,7 +12,7 @@ import (
 	"time"
 
 	log "github.com/golang/glog"
-	"github.com/youtube/vitess/go/vt/tabletserver"
+	"github.com/youtube/vitess/go/vt/queryrules"
 )
 
 var (
@@ -22,11 +23,11 @@ var (
 )
 
 // FileCustomRule is an implementation of CustomRuleManager, it reads custom query
-// rules from local file for once and push it to vttablet
+// rules from local file for once and push it to a queryrules.Sink
 type FileCustomRule struct {
-	path                    string                   // Path to the file containing custom query rules
-	currentRuleSet          *tabletserver.QueryRules // Query rules built from local file
-	currentRuleSetTimestamp int64                    // Unix timestamp when currentRuleSet is built from local file
+	path                    string                 // Path to the file containing custom query rules
+	currentRuleSet          *queryrules.QueryRules // Query rules built from local file
+	currentRuleSetTimestamp int64                  // Unix timestamp when currentRuleSet is built from local file
 }
 
 // FileCustomRuleSource is the name of the file based custom rule source
@@ -36,12 +37,12 @@ const FileCustomRuleSource string = "FILE_CUSTOM_RULE"
 func NewFileCustomRule() (fcr *FileCustomRule) {
 	fcr = new(FileCustomRule)
 	fcr.path = ""
-	fcr.currentRuleSet = tabletserver.NewQueryRules()
+	fcr.currentRuleSet = queryrules.NewQueryRules(nil)
 	return fcr
 }
 
-// Open try to build query rules from local file and push the rules to vttablet
-func (fcr *FileCustomRule) Open(qsc tabletserver.QueryServiceControl, rulePath string) error {
+// Open try to build query rules from local file and push the rules to sink
+func (fcr *FileCustomRule) Open(sink queryrules.Sink, rulePath string) error {
 	fcr.path = rulePath
 	if fcr.path == "" {
 		// Don't go further if path is empty
@@ -53,7 +54,7 @@ func (fcr *FileCustomRule) Open(qsc tabletserver.QueryServiceControl, rulePath s
 		// Don't update any internal cache, just return error
 		return err
 	}
-	qrs := tabletserver.NewQueryRules()
+	qrs := sink.NewQueryRules()
 	err = qrs.UnmarshalJSON(data)
 	if err != nil {
 		log.Warningf("Error unmarshaling query rules %v", err)
@@ -61,25 +62,23 @@ func (fcr *FileCustomRule) Open(qsc tabletserver.QueryServiceControl, rulePath s
 	}
 	fcr.currentRuleSetTimestamp = time.Now().Unix()
 	fcr.currentRuleSet = qrs.Copy()
-	// Push query rules to vttablet
-	qsc.SetQueryRules(FileCustomRuleSource, qrs.Copy())
+	// Push query rules to the sink
+	if err := sink.SetQueryRules(FileCustomRuleSource, qrs.Copy()); err != nil {
+		return err
+	}
 	log.Infof("Custom rule loaded from file: %s", fcr.path)
 	return nil
 }
 
 // GetRules returns query rules built from local file
-func (fcr *FileCustomRule) GetRules() (qrs *tabletserver.QueryRules, version int64, err error) {
+func (fcr *FileCustomRule) GetRules() (qrs *queryrules.QueryRules, version int64, err error) {
 	return fcr.currentRuleSet.Copy(), fcr.currentRuleSetTimestamp, nil
 }
 
 // ActivateFileCustomRules activates this static file based custom rule mechanism
-func ActivateFileCustomRules(qsc tabletserver.QueryServiceControl) {
+func ActivateFileCustomRules(sink queryrules.Sink) {
 	if *fileRulePath != "" {
-		tabletserver.QueryRuleSources.RegisterQueryRuleSource(FileCustomRuleSource)
-		fileCustomRule.Open(qsc, *fileRulePath)
+		sink.RegisterQueryRuleSource(FileCustomRuleSource)
+		fileCustomRule.Open(sink, *fileRulePath)
 	}
 }
-
-func init() {
-	tabletserver.QueryServiceControlRegisterFunctions = append(tabletserver.QueryServiceControlRegisterFunctions, ActivateFileCustomRules)
-}
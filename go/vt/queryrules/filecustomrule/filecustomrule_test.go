@@ -10,7 +10,7 @@ import (
 	"path"
 	"testing"
 
-	"github.com/youtube/vitess/go/vt/tabletserver"
+	"github.com/youtube/vitess/go/vt/vtgate"
 )
 
 var customRule1 = `[
@@ -26,29 +26,36 @@ var customRule1 = `[
 			]`
 
 func TestFileCustomRule(t *testing.T) {
-	tqsc := tabletserver.NewTestQueryServiceControl()
+	vtgate.QueryRuleSources.RegisterQueryRuleSource(FileCustomRuleSource)
+	defer vtgate.QueryRuleSources.UnRegisterQueryRuleSource(FileCustomRuleSource)
 
-	var qrs *tabletserver.QueryRules
 	rulepath := path.Join(os.TempDir(), ".customrule.json")
-	// Set r1 and try to get it back
 	err := ioutil.WriteFile(rulepath, []byte(customRule1), os.FileMode(0644))
 	if err != nil {
 		t.Fatalf("Cannot write r1 to rule file %s, err=%v", rulepath, err)
 	}
+	defer os.Remove(rulepath)
 
 	fcr := NewFileCustomRule()
 	// Let FileCustomRule to build rule from the local file
-	err = fcr.Open(tqsc, rulepath)
+	err = fcr.Open(vtgate.QueryRuleSink, rulepath)
 	if err != nil {
 		t.Fatalf("Cannot open file custom rule service, err=%v", err)
 	}
 	// Fetch query rules we built to verify correctness
-	qrs, _, err = fcr.GetRules()
+	qrs, _, err := fcr.GetRules()
 	if err != nil {
 		t.Fatalf("GetRules returns error: %v", err)
 	}
-	qr := qrs.Find("r1")
-	if qr == nil {
+	if qrs.Find("r1") == nil {
 		t.Fatalf("Expect custom rule r1 to be found, but got nothing, qrs=%v", qrs)
 	}
+	// The rules are pushed to the sink
+	qrs, err = vtgate.QueryRuleSources.GetRules(FileCustomRuleSource)
+	if err != nil {
+		t.Fatalf("QueryRuleSources.GetRules returns error: %v", err)
+	}
+	if qrs.Find("r1") == nil {
+		t.Fatalf("Expect custom rule r1 to be set in vtgate, but got nothing, qrs=%v", qrs)
+	}
 }
@@ -2,34 +2,55 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package tabletserver
+// Package queryrules implements the query rules of vttablet and
+// VTGate. A rule fails or degrades the queries that match all its
+// conditions. The plans of the queries are numbered by each query
+// service, which describes them with a Service.
+package queryrules
 
 import (
 	"encoding/json"
 	"fmt"
 	"regexp"
 	"strconv"
+	"time"
 
+	"github.com/youtube/vitess/go/ratelimiter"
 	"github.com/youtube/vitess/go/vt/key"
-	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
 )
 
 //-----------------------------------------------
 
-// QueryRules is used to store and execute rules for the tabletserver.
+// Service describes the query service that applies the rules:
+// what their JSON representation can contain.
+type Service struct {
+	// PlanByName returns the plan id of a plan name.
+	PlanByName func(name string) (planID int, ok bool)
+
+	// Actions maps the names of the actions the service
+	// supports to their Action.
+	Actions map[string]Action
+
+	// Keyspaces is true if the queries of the
+	// service can be matched on their keyspace.
+	Keyspaces bool
+}
+
+// QueryRules is used to store and execute rules for a query service.
 type QueryRules struct {
+	svc   *Service
 	rules []*QueryRule
 }
 
-// NewQueryRules creates a new QueryRules.
-func NewQueryRules() *QueryRules {
-	return &QueryRules{}
+// NewQueryRules creates a new QueryRules for the service svc.
+func NewQueryRules(svc *Service) *QueryRules {
+	return &QueryRules{svc: svc}
 }
 
 // Copy performs a deep copy of QueryRules.
 // A nil input produces a nil output.
 func (qrs *QueryRules) Copy() (newqrs *QueryRules) {
-	newqrs = NewQueryRules()
+	newqrs = NewQueryRules(qrs.svc)
 	if qrs.rules != nil {
 		newqrs.rules = make([]*QueryRule, 0, len(qrs.rules))
 		for _, qr := range qrs.rules {
@@ -78,14 +99,18 @@ func (qrs *QueryRules) Delete(name string) (qr *QueryRule) {
 	return nil
 }
 
+// UnmarshalJSON builds the rules from their JSON representation.
 func (qrs *QueryRules) UnmarshalJSON(data []byte) (err error) {
+	if qrs.svc == nil {
+		return fmt.Errorf("query rules without a query service")
+	}
 	var rulesInfo []map[string]interface{}
 	err = json.Unmarshal(data, &rulesInfo)
 	if err != nil {
-		return NewTabletError(ErrFail, "%v", err)
+		return err
 	}
 	for _, ruleInfo := range rulesInfo {
-		qr, err := BuildQueryRule(ruleInfo)
+		qr, err := qrs.svc.BuildQueryRule(ruleInfo)
 		if err != nil {
 			return err
 		}
@@ -94,26 +119,58 @@ func (qrs *QueryRules) UnmarshalJSON(data []byte) (err error) {
 	return nil
 }
 
-// filterByPlan creates a new QueryRules by prefiltering on the query and planId. This allows
-// us to create query plan specific QueryRules out of the original QueryRules. In the new rules,
-// query, plans and tableNames predicates are empty.
-func (qrs *QueryRules) filterByPlan(query string, planid planbuilder.PlanType, tableName string) (newqrs *QueryRules) {
+// FilterByPlan creates a new QueryRules by prefiltering on the query, planID, keyspace
+// and tableName. This allows us to create query plan specific QueryRules out of the
+// original QueryRules. In the new rules, query, plans, keyspaces and tableNames
+// predicates are empty.
+func (qrs *QueryRules) FilterByPlan(query string, planID int, keyspace, tableName string) (newqrs *QueryRules) {
 	var newrules []*QueryRule
 	for _, qr := range qrs.rules {
-		if newrule := qr.filterByPlan(query, planid, tableName); newrule != nil {
+		if newrule := qr.filterByPlan(query, planID, keyspace, tableName); newrule != nil {
 			newrules = append(newrules, newrule)
 		}
 	}
-	return &QueryRules{newrules}
+	return &QueryRules{qrs.svc, newrules}
 }
 
-func (qrs *QueryRules) getAction(ip, user string, bindVars map[string]interface{}) (action Action, desc string) {
+// GetAction returns the action of the first failing rule that matches
+// the query, and the rule. A QR_THROTTLE rule only matches if its rate
+// is exceeded. The QR_LIMIT and QR_LOW_PRIORITY rules don't stop the
+// evaluation: the restrictions of all the matching ones are merged
+// into limits. The rules are expected to be filtered by plan.
+func (qrs *QueryRules) GetAction(ip, user string, bindVars map[string]interface{}) (action Action, qr *QueryRule, limits Limits) {
 	for _, qr := range qrs.rules {
-		if act := qr.getAction(ip, user, bindVars); act != QR_CONTINUE {
-			return act, qr.Description
+		switch act := qr.getAction(ip, user, bindVars); act {
+		case QR_CONTINUE:
+		case QR_LIMIT, QR_LOW_PRIORITY:
+			limits.merge(qr)
+		default:
+			return act, qr, Limits{}
 		}
 	}
-	return QR_CONTINUE, ""
+	return QR_CONTINUE, nil, limits
+}
+
+// Limits are the restrictions that the QR_LIMIT and
+// QR_LOW_PRIORITY rules put on the queries they match.
+// Zero values mean no restriction.
+type Limits struct {
+	MaxRows     int64
+	Timeout     time.Duration
+	LowPriority bool
+}
+
+// merge adds the restrictions of qr, keeping the strictest ones.
+func (l *Limits) merge(qr *QueryRule) {
+	if qr.act == QR_LOW_PRIORITY {
+		l.LowPriority = true
+	}
+	if qr.maxRows != 0 && (l.MaxRows == 0 || qr.maxRows < l.MaxRows) {
+		l.MaxRows = qr.maxRows
+	}
+	if qr.timeout != 0 && (l.Timeout == 0 || qr.timeout < l.Timeout) {
+		l.Timeout = qr.timeout
+	}
 }
 
 //-----------------------------------------------
@@ -136,7 +193,10 @@ type QueryRule struct {
 	requestIP, user, query *regexp.Regexp
 
 	// Any matched plan will make this condition true (OR)
-	plans []planbuilder.PlanType
+	plans []int
+
+	// Any matched keyspace will make this condition true (OR)
+	keyspaces []string
 
 	// Any matched tableNames will make this condition true (OR)
 	tableNames []string
@@ -146,11 +206,19 @@ type QueryRule struct {
 
 	// Action to be performed on trigger
 	act Action
+
+	// limiter is the rate of a QR_THROTTLE rule. It's shared
+	// by the copies of the rule.
+	limiter *ratelimiter.RateLimiter
+	maxQPS  int
+
+	// maxRows and timeout are the caps of a QR_LIMIT rule.
+	maxRows int64
+	timeout time.Duration
 }
 
 // NewQueryRule creates a new QueryRule.
 func NewQueryRule(description, name string, act Action) (qr *QueryRule) {
-	// We ignore act because there's only one action right now
 	return &QueryRule{Description: description, Name: name, act: act}
 }
 
@@ -163,11 +231,19 @@ func (qr *QueryRule) Copy() (newqr *QueryRule) {
 		user:        qr.user,
 		query:       qr.query,
 		act:         qr.act,
+		limiter:     qr.limiter,
+		maxQPS:      qr.maxQPS,
+		maxRows:     qr.maxRows,
+		timeout:     qr.timeout,
 	}
 	if qr.plans != nil {
-		newqr.plans = make([]planbuilder.PlanType, len(qr.plans))
+		newqr.plans = make([]int, len(qr.plans))
 		copy(newqr.plans, qr.plans)
 	}
+	if qr.keyspaces != nil {
+		newqr.keyspaces = make([]string, len(qr.keyspaces))
+		copy(newqr.keyspaces, qr.keyspaces)
+	}
 	if qr.tableNames != nil {
 		newqr.tableNames = make([]string, len(qr.tableNames))
 		copy(newqr.tableNames, qr.tableNames)
@@ -196,8 +272,15 @@ func (qr *QueryRule) SetUserCond(pattern string) (err error) {
 // AddPlanCond adds to the list of plans that can be matched for
 // the rule to fire.
 // This function acts as an OR: Any plan id match is considered a match.
-func (qr *QueryRule) AddPlanCond(planType planbuilder.PlanType) {
-	qr.plans = append(qr.plans, planType)
+func (qr *QueryRule) AddPlanCond(planID int) {
+	qr.plans = append(qr.plans, planID)
+}
+
+// AddKeyspaceCond adds to the list of keyspaces that can be matched for
+// the rule to fire.
+// This function acts as an OR: Any keyspace match is considered a match.
+func (qr *QueryRule) AddKeyspaceCond(keyspace string) {
+	qr.keyspaces = append(qr.keyspaces, keyspace)
 }
 
 // AddTableCond adds to the list of tableNames that can be matched for
@@ -213,6 +296,40 @@ func (qr *QueryRule) SetQueryCond(pattern string) (err error) {
 	return
 }
 
+// SetMaxQPS sets the rate of a QR_THROTTLE rule. The matching
+// queries beyond maxQPS fail.
+func (qr *QueryRule) SetMaxQPS(maxQPS int) error {
+	if maxQPS <= 0 {
+		return fmt.Errorf("invalid MaxQPS %v", maxQPS)
+	}
+	qr.maxQPS = maxQPS
+	qr.limiter = ratelimiter.NewRateLimiter(maxQPS, time.Second)
+	return nil
+}
+
+// MaxQPS returns the rate of a QR_THROTTLE rule.
+func (qr *QueryRule) MaxQPS() int {
+	return qr.maxQPS
+}
+
+// SetLimits sets the caps of a QR_LIMIT rule: the matching queries
+// return at most maxRows rows, and are killed after timeout.
+// A zero value leaves the corresponding cap unset.
+func (qr *QueryRule) SetLimits(maxRows int64, timeout time.Duration) error {
+	if maxRows < 0 {
+		return fmt.Errorf("invalid MaxRows %v", maxRows)
+	}
+	if timeout < 0 {
+		return fmt.Errorf("invalid Timeout %v", timeout)
+	}
+	if maxRows == 0 && timeout == 0 {
+		return fmt.Errorf("want MaxRows or Timeout")
+	}
+	qr.maxRows = maxRows
+	qr.timeout = timeout
+	return nil
+}
+
 // makeExact forces a full string match for the regex instead of substring
 func makeExact(pattern string) string {
 	return fmt.Sprintf("^%s$", pattern)
@@ -261,7 +378,7 @@ func (qr *QueryRule) AddBindVarCond(name string, onAbsent, onMismatch bool, op O
 			// Change the value to compiled regexp
 			re, err := regexp.Compile(makeExact(v))
 			if err != nil {
-				return NewTabletError(ErrFail, "processing %s: %v", v, err)
+				return fmt.Errorf("processing %s: %v", v, err)
 			}
 			converted = bvcre{re}
 		} else {
@@ -273,32 +390,36 @@ func (qr *QueryRule) AddBindVarCond(name string, onAbsent, onMismatch bool, op O
 		}
 		converted = bvcKeyRange(v)
 	default:
-		return NewTabletError(ErrFail, "type %T not allowed as condition operand (%v)", value, value)
+		return fmt.Errorf("type %T not allowed as condition operand (%v)", value, value)
 	}
 	qr.bindVarConds = append(qr.bindVarConds, BindVarCond{name, onAbsent, onMismatch, op, converted})
 	return nil
 
 Error:
-	return NewTabletError(ErrFail, "invalid operator %s for type %T (%v)", op, value, value)
+	return fmt.Errorf("invalid operator %v for type %T (%v)", op, value, value)
 }
 
-// filterByPlan returns a new QueryRule if the query and planid match.
-// The new QueryRule will contain all the original constraints other
-// than the plan and query. If the plan and query don't match the QueryRule,
+// filterByPlan returns a new QueryRule if the query, planID, keyspace
+// and tableName match. The new QueryRule will contain all the original
+// constraints other than these ones. If they don't match the QueryRule,
 // then it returns nil.
-func (qr *QueryRule) filterByPlan(query string, planid planbuilder.PlanType, tableName string) (newqr *QueryRule) {
+func (qr *QueryRule) filterByPlan(query string, planID int, keyspace, tableName string) (newqr *QueryRule) {
 	if !reMatch(qr.query, query) {
 		return nil
 	}
-	if !planMatch(qr.plans, planid) {
+	if !planMatch(qr.plans, planID) {
+		return nil
+	}
+	if !stringMatch(qr.keyspaces, keyspace) {
 		return nil
 	}
-	if !tableMatch(qr.tableNames, tableName) {
+	if !stringMatch(qr.tableNames, tableName) {
 		return nil
 	}
 	newqr = qr.Copy()
 	newqr.query = nil
 	newqr.plans = nil
+	newqr.keyspaces = nil
 	newqr.tableNames = nil
 	return newqr
 }
@@ -315,6 +436,9 @@ func (qr *QueryRule) getAction(ip, user string, bindVars map[string]interface{})
 			return QR_CONTINUE
 		}
 	}
+	if qr.act == QR_THROTTLE && qr.limiter.Allow() {
+		return QR_CONTINUE
+	}
 	return qr.act
 }
 
@@ -322,7 +446,7 @@ func reMatch(re *regexp.Regexp, val string) bool {
 	return re == nil || re.MatchString(val)
 }
 
-func planMatch(plans []planbuilder.PlanType, plan planbuilder.PlanType) bool {
+func planMatch(plans []int, plan int) bool {
 	if plans == nil {
 		return true
 	}
@@ -334,12 +458,12 @@ func planMatch(plans []planbuilder.PlanType, plan planbuilder.PlanType) bool {
 	return false
 }
 
-func tableMatch(tableNames []string, tableName string) bool {
-	if tableNames == nil {
+func stringMatch(list []string, val string) bool {
+	if list == nil {
 		return true
 	}
-	for _, t := range tableNames {
-		if t == tableName {
+	for _, s := range list {
+		if s == val {
 			return true
 		}
 	}
@@ -364,10 +488,19 @@ func bvMatch(bvcond BindVarCond, bindVars map[string]interface{}) bool {
 // when a QueryRule is triggered.
 type Action int
 
+// These are the actions of the query rules. A QR_THROTTLE rule
+// fails the matching queries beyond its rate. The QR_LIMIT and
+// QR_LOW_PRIORITY rules degrade the matching queries instead of
+// failing them: QR_LIMIT caps their rows and run time, and
+// QR_LOW_PRIORITY runs them in the low priority connection pool.
+// A query service supports a subset of them, see Service.
 const (
 	QR_CONTINUE = Action(iota)
 	QR_FAIL
 	QR_FAIL_RETRY
+	QR_THROTTLE
+	QR_LIMIT
+	QR_LOW_PRIORITY
 )
 
 // BindVarCond represents a bind var condition.
@@ -382,6 +515,7 @@ type BindVarCond struct {
 // Operator represents the list of operators.
 type Operator int
 
+// These are the operators of the bind var conditions.
 const (
 	QR_NOOP = Operator(iota)
 	QR_EQ
@@ -423,9 +557,9 @@ var opmap = map[string]Operator{
 }
 
 const (
-	QR_OK = iota
-	QR_MISMATCH
-	QR_OUT_OF_RANGE
+	qrOK = iota
+	qrMismatch
+	qrOutOfRange
 )
 
 // bvcValue defines the common interface
@@ -441,44 +575,44 @@ func (uval bvcuint64) eval(bv interface{}, op Operator, onMismatch bool) bool {
 	switch op {
 	case QR_EQ:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num == uint64(uval)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return false
 		}
 	case QR_NE:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num != uint64(uval)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return true
 		}
 	case QR_LT:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num < uint64(uval)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return true
 		}
 	case QR_GE:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num >= uint64(uval)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return false
 		}
 	case QR_GT:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num > uint64(uval)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return false
 		}
 	case QR_LE:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num <= uint64(uval)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return true
 		}
 	default:
@@ -495,44 +629,44 @@ func (ival bvcint64) eval(bv interface{}, op Operator, onMismatch bool) bool {
 	switch op {
 	case QR_EQ:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num == int64(ival)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return false
 		}
 	case QR_NE:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num != int64(ival)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return true
 		}
 	case QR_LT:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num < int64(ival)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return false
 		}
 	case QR_GE:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num >= int64(ival)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return true
 		}
 	case QR_GT:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num > int64(ival)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return true
 		}
 	case QR_LE:
 		switch status {
-		case QR_OK:
+		case qrOK:
 			return num <= int64(ival)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return false
 		}
 	default:
@@ -546,7 +680,7 @@ type bvcstring string
 
 func (sval bvcstring) eval(bv interface{}, op Operator, onMismatch bool) bool {
 	str, status := getstring(bv)
-	if status != QR_OK {
+	if status != qrOK {
 		return onMismatch
 	}
 	switch op {
@@ -572,7 +706,7 @@ type bvcre struct {
 
 func (reval bvcre) eval(bv interface{}, op Operator, onMismatch bool) bool {
 	str, status := getstring(bv)
-	if status != QR_OK {
+	if status != qrOK {
 		return onMismatch
 	}
 	switch op {
@@ -590,28 +724,28 @@ func (krval bvcKeyRange) eval(bv interface{}, op Operator, onMismatch bool) bool
 	switch op {
 	case QR_IN:
 		switch num, status := getuint64(bv); status {
-		case QR_OK:
+		case qrOK:
 			k := key.Uint64Key(num).KeyspaceId()
 			return key.KeyRange(krval).Contains(k)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return false
 		}
 		// Not a number. Check string.
 		switch str, status := getstring(bv); status {
-		case QR_OK:
+		case qrOK:
 			return key.KeyRange(krval).Contains(key.KeyspaceId(str))
 		}
 	case QR_NOTIN:
 		switch num, status := getuint64(bv); status {
-		case QR_OK:
+		case qrOK:
 			k := key.Uint64Key(num).KeyspaceId()
 			return !key.KeyRange(krval).Contains(k)
-		case QR_OUT_OF_RANGE:
+		case qrOutOfRange:
 			return true
 		}
 		// Not a number. Check string.
 		switch str, status := getstring(bv); status {
-		case QR_OK:
+		case qrOK:
 			return !key.KeyRange(krval).Contains(key.KeyspaceId(str))
 		}
 	default:
@@ -620,84 +754,89 @@ func (krval bvcKeyRange) eval(bv interface{}, op Operator, onMismatch bool) bool
 	return onMismatch
 }
 
-// getuint64 returns QR_OUT_OF_RANGE for negative values
+// getuint64 returns qrOutOfRange for negative values
 func getuint64(val interface{}) (uv uint64, status int) {
 	switch v := val.(type) {
 	case int:
 		if v < 0 {
-			return 0, QR_OUT_OF_RANGE
+			return 0, qrOutOfRange
 		}
-		return uint64(v), QR_OK
+		return uint64(v), qrOK
 	case int8:
 		if v < 0 {
-			return 0, QR_OUT_OF_RANGE
+			return 0, qrOutOfRange
 		}
-		return uint64(v), QR_OK
+		return uint64(v), qrOK
 	case int16:
 		if v < 0 {
-			return 0, QR_OUT_OF_RANGE
+			return 0, qrOutOfRange
 		}
-		return uint64(v), QR_OK
+		return uint64(v), qrOK
 	case int32:
 		if v < 0 {
-			return 0, QR_OUT_OF_RANGE
+			return 0, qrOutOfRange
 		}
-		return uint64(v), QR_OK
+		return uint64(v), qrOK
 	case int64:
 		if v < 0 {
-			return 0, QR_OUT_OF_RANGE
+			return 0, qrOutOfRange
 		}
-		return uint64(v), QR_OK
+		return uint64(v), qrOK
 	case uint64:
-		return v, QR_OK
+		return v, qrOK
 	}
-	return 0, QR_MISMATCH
+	return 0, qrMismatch
 }
 
-// getint64 returns QR_OUT_OF_RANGE if a uint64 is too large
+// getint64 returns qrOutOfRange if a uint64 is too large
 func getint64(val interface{}) (iv int64, status int) {
 	switch v := val.(type) {
 	case int:
-		return int64(v), QR_OK
+		return int64(v), qrOK
 	case int8:
-		return int64(v), QR_OK
+		return int64(v), qrOK
 	case int16:
-		return int64(v), QR_OK
+		return int64(v), qrOK
 	case int32:
-		return int64(v), QR_OK
+		return int64(v), qrOK
 	case int64:
-		return int64(v), QR_OK
+		return int64(v), qrOK
 	case uint64:
 		if v > 0x7FFFFFFFFFFFFFFF { // largest int64
-			return 0, QR_OUT_OF_RANGE
+			return 0, qrOutOfRange
 		}
-		return int64(v), QR_OK
+		return int64(v), qrOK
 	}
-	return 0, QR_MISMATCH
+	return 0, qrMismatch
 }
 
 func getstring(val interface{}) (sv string, status int) {
 	switch v := val.(type) {
 	case []byte:
-		return string(v), QR_OK
+		return string(v), qrOK
 	case string:
-		return v, QR_OK
+		return v, qrOK
 	}
-	return "", QR_MISMATCH
+	return "", qrMismatch
 }
 
 //-----------------------------------------------
 // Support functions for JSON
 
+// MapStrOperator maps a string representation to an Operator.
 func MapStrOperator(strop string) (op Operator, err error) {
 	if op, ok := opmap[strop]; ok {
 		return op, nil
 	}
-	return QR_NOOP, NewTabletError(ErrFail, "invalid Operator %s", strop)
+	return QR_NOOP, fmt.Errorf("invalid Operator %s", strop)
 }
 
-func BuildQueryRule(ruleInfo map[string]interface{}) (qr *QueryRule, err error) {
+// BuildQueryRule builds a QueryRule of the service from its JSON representation.
+func (svc *Service) BuildQueryRule(ruleInfo map[string]interface{}) (qr *QueryRule, err error) {
 	qr = NewQueryRule("", "", QR_FAIL)
+	maxQPS := 0
+	var maxRows int64
+	var timeout time.Duration
 	for k, v := range ruleInfo {
 		var sv string
 		var lv []interface{}
@@ -706,15 +845,36 @@ func BuildQueryRule(ruleInfo map[string]interface{}) (qr *QueryRule, err error)
 		case "Name", "Description", "RequestIP", "User", "Query", "Action":
 			sv, ok = v.(string)
 			if !ok {
-				return nil, NewTabletError(ErrFail, "want string for %s", k)
+				return nil, fmt.Errorf("want string for %s", k)
+			}
+		case "Keyspaces":
+			if !svc.Keyspaces {
+				return nil, fmt.Errorf("unrecognized tag %s", k)
 			}
+			fallthrough
 		case "Plans", "BindVarConds", "TableNames":
 			lv, ok = v.([]interface{})
 			if !ok {
-				return nil, NewTabletError(ErrFail, "want list for %s", k)
+				return nil, fmt.Errorf("want list for %s", k)
 			}
+		case "MaxQPS", "MaxRows":
+			fv, ok := v.(float64)
+			if !ok || fv != float64(int(fv)) {
+				return nil, fmt.Errorf("want integer for %s", k)
+			}
+			if k == "MaxQPS" {
+				maxQPS = int(fv)
+			} else {
+				maxRows = int64(fv)
+			}
+		case "Timeout":
+			fv, ok := v.(float64)
+			if !ok {
+				return nil, fmt.Errorf("want number for %s", k)
+			}
+			timeout = time.Duration(fv * 1e9)
 		default:
-			return nil, NewTabletError(ErrFail, "unrecognized tag %s", k)
+			return nil, fmt.Errorf("unrecognized tag %s", k)
 		}
 		switch k {
 		case "Name":
@@ -724,35 +884,43 @@ func BuildQueryRule(ruleInfo map[string]interface{}) (qr *QueryRule, err error)
 		case "RequestIP":
 			err = qr.SetIPCond(sv)
 			if err != nil {
-				return nil, NewTabletError(ErrFail, "could not set IP condition: %v", sv)
+				return nil, fmt.Errorf("could not set IP condition: %v", sv)
 			}
 		case "User":
 			err = qr.SetUserCond(sv)
 			if err != nil {
-				return nil, NewTabletError(ErrFail, "could not set User condition: %v", sv)
+				return nil, fmt.Errorf("could not set User condition: %v", sv)
 			}
 		case "Query":
 			err = qr.SetQueryCond(sv)
 			if err != nil {
-				return nil, NewTabletError(ErrFail, "could not set Query condition: %v", sv)
+				return nil, fmt.Errorf("could not set Query condition: %v", sv)
 			}
 		case "Plans":
 			for _, p := range lv {
 				pv, ok := p.(string)
 				if !ok {
-					return nil, NewTabletError(ErrFail, "want string for Plans")
+					return nil, fmt.Errorf("want string for Plans")
+				}
+				planID, ok := svc.PlanByName(pv)
+				if !ok {
+					return nil, fmt.Errorf("invalid plan name: %s", pv)
 				}
-				pt, ok := planbuilder.PlanByName(pv)
+				qr.AddPlanCond(planID)
+			}
+		case "Keyspaces":
+			for _, ks := range lv {
+				keyspace, ok := ks.(string)
 				if !ok {
-					return nil, NewTabletError(ErrFail, "invalid plan name: %s", pv)
+					return nil, fmt.Errorf("want string for Keyspaces")
 				}
-				qr.AddPlanCond(pt)
+				qr.AddKeyspaceCond(keyspace)
 			}
 		case "TableNames":
 			for _, t := range lv {
 				tableName, ok := t.(string)
 				if !ok {
-					return nil, NewTabletError(ErrFail, "want string for TableNames")
+					return nil, fmt.Errorf("want string for TableNames")
 				}
 				qr.AddTableCond(tableName)
 			}
@@ -769,15 +937,26 @@ func BuildQueryRule(ruleInfo map[string]interface{}) (qr *QueryRule, err error)
 				}
 			}
 		case "Action":
-			switch sv {
-			case "FAIL":
-				qr.act = QR_FAIL
-			case "FAIL_RETRY":
-				qr.act = QR_FAIL_RETRY
-			default:
-				return nil, NewTabletError(ErrFail, "invalid Action %s", sv)
+			act, ok := svc.Actions[sv]
+			if !ok {
+				return nil, fmt.Errorf("invalid Action %s", sv)
 			}
+			qr.act = act
+		}
+	}
+	if qr.act == QR_THROTTLE {
+		if err := qr.SetMaxQPS(maxQPS); err != nil {
+			return nil, fmt.Errorf("THROTTLE rule %s: %v", qr.Name, err)
+		}
+	} else if maxQPS != 0 {
+		return nil, fmt.Errorf("MaxQPS is only valid for the THROTTLE action")
+	}
+	if qr.act == QR_LIMIT {
+		if err := qr.SetLimits(maxRows, timeout); err != nil {
+			return nil, fmt.Errorf("LIMIT rule %s: %v", qr.Name, err)
 		}
+	} else if maxRows != 0 || timeout != 0 {
+		return nil, fmt.Errorf("MaxRows and Timeout are only valid for the LIMIT action")
 	}
 	return qr, nil
 }
@@ -785,41 +964,41 @@ func BuildQueryRule(ruleInfo map[string]interface{}) (qr *QueryRule, err error)
 func buildBindVarCondition(bvc interface{}) (name string, onAbsent, onMismatch bool, op Operator, value interface{}, err error) {
 	bvcinfo, ok := bvc.(map[string]interface{})
 	if !ok {
-		err = NewTabletError(ErrFail, "want json object for bind var conditions")
+		err = fmt.Errorf("want json object for bind var conditions")
 		return
 	}
 
 	var v interface{}
 	v, ok = bvcinfo["Name"]
 	if !ok {
-		err = NewTabletError(ErrFail, "Name missing in BindVarConds")
+		err = fmt.Errorf("Name missing in BindVarConds")
 		return
 	}
 	name, ok = v.(string)
 	if !ok {
-		err = NewTabletError(ErrFail, "want string for Name in BindVarConds")
+		err = fmt.Errorf("want string for Name in BindVarConds")
 		return
 	}
 
 	v, ok = bvcinfo["OnAbsent"]
 	if !ok {
-		err = NewTabletError(ErrFail, "OnAbsent missing in BindVarConds")
+		err = fmt.Errorf("OnAbsent missing in BindVarConds")
 		return
 	}
 	onAbsent, ok = v.(bool)
 	if !ok {
-		err = NewTabletError(ErrFail, "want bool for OnAbsent")
+		err = fmt.Errorf("want bool for OnAbsent")
 		return
 	}
 
 	v, ok = bvcinfo["Operator"]
 	if !ok {
-		err = NewTabletError(ErrFail, "Operator missing in BindVarConds")
+		err = fmt.Errorf("Operator missing in BindVarConds")
 		return
 	}
 	strop, ok := v.(string)
 	if !ok {
-		err = NewTabletError(ErrFail, "want string for Operator")
+		err = fmt.Errorf("want string for Operator")
 		return
 	}
 	op, err = MapStrOperator(strop)
@@ -831,25 +1010,25 @@ func buildBindVarCondition(bvc interface{}) (name string, onAbsent, onMismatch b
 	}
 	v, ok = bvcinfo["Value"]
 	if !ok {
-		err = NewTabletError(ErrFail, "Value missing in BindVarConds")
+		err = fmt.Errorf("Value missing in BindVarConds")
 		return
 	}
 	if op >= QR_EQ && op <= QR_LE {
 		strvalue, ok := v.(string)
 		if !ok {
-			err = NewTabletError(ErrFail, "want string: %v", v)
+			err = fmt.Errorf("want string: %v", v)
 			return
 		}
 		if strop[0] == 'U' {
 			value, err = strconv.ParseUint(strvalue, 0, 64)
 			if err != nil {
-				err = NewTabletError(ErrFail, "want uint64: %s", strvalue)
+				err = fmt.Errorf("want uint64: %s", strvalue)
 				return
 			}
 		} else if strop[0] == 'I' {
 			value, err = strconv.ParseInt(strvalue, 0, 64)
 			if err != nil {
-				err = NewTabletError(ErrFail, "want int64: %s", strvalue)
+				err = fmt.Errorf("want int64: %s", strvalue)
 				return
 			}
 		} else if strop[0] == 'S' {
@@ -860,37 +1039,37 @@ func buildBindVarCondition(bvc interface{}) (name string, onAbsent, onMismatch b
 	} else if op == QR_MATCH || op == QR_NOMATCH {
 		strvalue, ok := v.(string)
 		if !ok {
-			err = NewTabletError(ErrFail, "want string: %v", v)
+			err = fmt.Errorf("want string: %v", v)
 			return
 		}
 		value = strvalue
 	} else if op == QR_IN || op == QR_NOTIN {
 		kr, ok := v.(map[string]interface{})
 		if !ok {
-			err = NewTabletError(ErrFail, "want keyrange for Value")
+			err = fmt.Errorf("want keyrange for Value")
 			return
 		}
 		var keyrange key.KeyRange
 		strstart, ok := kr["Start"]
 		if !ok {
-			err = NewTabletError(ErrFail, "Start missing in KeyRange")
+			err = fmt.Errorf("Start missing in KeyRange")
 			return
 		}
 		start, ok := strstart.(string)
 		if !ok {
-			err = NewTabletError(ErrFail, "want string for Start")
+			err = fmt.Errorf("want string for Start")
 			return
 		}
 		keyrange.Start = key.KeyspaceId(start)
 
 		strend, ok := kr["End"]
 		if !ok {
-			err = NewTabletError(ErrFail, "End missing in KeyRange")
+			err = fmt.Errorf("End missing in KeyRange")
 			return
 		}
 		end, ok := strend.(string)
 		if !ok {
-			err = NewTabletError(ErrFail, "want string for End")
+			err = fmt.Errorf("want string for End")
 			return
 		}
 		keyrange.End = key.KeyspaceId(end)
@@ -899,12 +1078,12 @@ func buildBindVarCondition(bvc interface{}) (name string, onAbsent, onMismatch b
 
 	v, ok = bvcinfo["OnMismatch"]
 	if !ok {
-		err = NewTabletError(ErrFail, "OnMismatch missing in BindVarConds")
+		err = fmt.Errorf("OnMismatch missing in BindVarConds")
 		return
 	}
 	onMismatch, ok = v.(bool)
 	if !ok {
-		err = NewTabletError(ErrFail, "want bool for OnMismatch")
+		err = fmt.Errorf("want bool for OnMismatch")
 		return
 	}
 	return
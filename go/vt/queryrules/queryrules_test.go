@@ -2,20 +2,38 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package tabletserver
+package queryrules
 
 import (
 	"encoding/json"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/youtube/vitess/go/vt/key"
 	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
 )
 
+// testService has the plans of vttablet, all the actions,
+// and the keyspace condition.
+var testService = &Service{
+	PlanByName: func(name string) (int, bool) {
+		planID, ok := planbuilder.PlanByName(name)
+		return int(planID), ok
+	},
+	Actions: map[string]Action{
+		"FAIL":         QR_FAIL,
+		"FAIL_RETRY":   QR_FAIL_RETRY,
+		"THROTTLE":     QR_THROTTLE,
+		"LIMIT":        QR_LIMIT,
+		"LOW_PRIORITY": QR_LOW_PRIORITY,
+	},
+	Keyspaces: true,
+}
+
 func TestQueryRules(t *testing.T) {
-	qrs := NewQueryRules()
+	qrs := NewQueryRules(testService)
 	qr1 := NewQueryRule("rule 1", "r1", QR_FAIL)
 	qr2 := NewQueryRule("rule 2", "r2", QR_FAIL)
 	qrs.Add(qr1)
@@ -61,9 +79,9 @@ func TestQueryRules(t *testing.T) {
 
 // TestCopy tests for deep copy
 func TestCopy(t *testing.T) {
-	qrs := NewQueryRules()
+	qrs := NewQueryRules(testService)
 	qr1 := NewQueryRule("rule 1", "r1", QR_FAIL)
-	qr1.AddPlanCond(planbuilder.PLAN_PASS_SELECT)
+	qr1.AddPlanCond(int(planbuilder.PLAN_PASS_SELECT))
 	qr1.AddTableCond("aa")
 	qr1.AddBindVarCond("a", true, false, QR_NOOP, nil)
 
@@ -81,7 +99,7 @@ func TestCopy(t *testing.T) {
 		t.Errorf("want false, got true")
 	}
 
-	qr1.plans[0] = planbuilder.PLAN_INSERT_PK
+	qr1.plans[0] = int(planbuilder.PLAN_INSERT_PK)
 	if qr1.plans[0] == qrf1.plans[0] {
 		t.Errorf("want false, got true")
 	}
@@ -94,24 +112,24 @@ func TestCopy(t *testing.T) {
 		t.Errorf("want a, got %s", qrf1.bindVarConds[1].name)
 	}
 
-	qrs2 := NewQueryRules()
+	qrs2 := NewQueryRules(testService)
 	if qrs3 := qrs2.Copy(); qrs3.rules != nil {
 		t.Errorf("want nil, got non-nil")
 	}
 }
 
 func TestFilterByPlan(t *testing.T) {
-	qrs := NewQueryRules()
+	qrs := NewQueryRules(testService)
 
 	qr1 := NewQueryRule("rule 1", "r1", QR_FAIL)
 	qr1.SetIPCond("123")
 	qr1.SetQueryCond("select")
-	qr1.AddPlanCond(planbuilder.PLAN_PASS_SELECT)
+	qr1.AddPlanCond(int(planbuilder.PLAN_PASS_SELECT))
 	qr1.AddBindVarCond("a", true, false, QR_NOOP, nil)
 
 	qr2 := NewQueryRule("rule 2", "r2", QR_FAIL)
-	qr2.AddPlanCond(planbuilder.PLAN_PASS_SELECT)
-	qr2.AddPlanCond(planbuilder.PLAN_PK_IN)
+	qr2.AddPlanCond(int(planbuilder.PLAN_PASS_SELECT))
+	qr2.AddPlanCond(int(planbuilder.PLAN_PK_IN))
 	qr2.AddBindVarCond("a", true, false, QR_NOOP, nil)
 
 	qr3 := NewQueryRule("rule 3", "r3", QR_FAIL)
@@ -127,7 +145,7 @@ func TestFilterByPlan(t *testing.T) {
 	qrs.Add(qr3)
 	qrs.Add(qr4)
 
-	qrs1 := qrs.filterByPlan("select", planbuilder.PLAN_PASS_SELECT, "a")
+	qrs1 := qrs.FilterByPlan("select", int(planbuilder.PLAN_PASS_SELECT), "", "a")
 	if l := len(qrs1.rules); l != 3 {
 		t.Errorf("want 3, got %d", l)
 	}
@@ -141,7 +159,7 @@ func TestFilterByPlan(t *testing.T) {
 		t.Errorf("want nil, got non-nil")
 	}
 
-	qrs1 = qrs.filterByPlan("insert", planbuilder.PLAN_PASS_SELECT, "a")
+	qrs1 = qrs.FilterByPlan("insert", int(planbuilder.PLAN_PASS_SELECT), "", "a")
 	if l := len(qrs1.rules); l != 1 {
 		t.Errorf("want 1, got %d", l)
 	}
@@ -149,7 +167,7 @@ func TestFilterByPlan(t *testing.T) {
 		t.Errorf("want r2, got %s", qrs1.rules[0].Name)
 	}
 
-	qrs1 = qrs.filterByPlan("insert", planbuilder.PLAN_PK_IN, "a")
+	qrs1 = qrs.FilterByPlan("insert", int(planbuilder.PLAN_PK_IN), "", "a")
 	if l := len(qrs1.rules); l != 1 {
 		t.Errorf("want 1, got %d", l)
 	}
@@ -157,7 +175,7 @@ func TestFilterByPlan(t *testing.T) {
 		t.Errorf("want r2, got %s", qrs1.rules[0].Name)
 	}
 
-	qrs1 = qrs.filterByPlan("select", planbuilder.PLAN_INSERT_PK, "a")
+	qrs1 = qrs.FilterByPlan("select", int(planbuilder.PLAN_INSERT_PK), "", "a")
 	if l := len(qrs1.rules); l != 1 {
 		t.Errorf("want 1, got %d", l)
 	}
@@ -165,12 +183,12 @@ func TestFilterByPlan(t *testing.T) {
 		t.Errorf("want r3, got %s", qrs1.rules[0].Name)
 	}
 
-	qrs1 = qrs.filterByPlan("sel", planbuilder.PLAN_INSERT_PK, "a")
+	qrs1 = qrs.FilterByPlan("sel", int(planbuilder.PLAN_INSERT_PK), "", "a")
 	if qrs1.rules != nil {
 		t.Errorf("want nil, got non-nil")
 	}
 
-	qrs1 = qrs.filterByPlan("table", planbuilder.PLAN_PASS_DML, "b")
+	qrs1 = qrs.FilterByPlan("table", int(planbuilder.PLAN_PASS_DML), "", "b")
 	if l := len(qrs1.rules); l != 1 {
 		t.Errorf("want 1, got %#v, %#v", qrs1.rules[0], qrs1.rules[1])
 	}
@@ -181,7 +199,7 @@ func TestFilterByPlan(t *testing.T) {
 	qr5 := NewQueryRule("rule 5", "r5", QR_FAIL)
 	qrs.Add(qr5)
 
-	qrs1 = qrs.filterByPlan("sel", planbuilder.PLAN_INSERT_PK, "a")
+	qrs1 = qrs.FilterByPlan("sel", int(planbuilder.PLAN_INSERT_PK), "", "a")
 	if l := len(qrs1.rules); l != 1 {
 		t.Errorf("want 1, got %d", l)
 	}
@@ -189,12 +207,59 @@ func TestFilterByPlan(t *testing.T) {
 		t.Errorf("want r5, got %s", qrs1.rules[0].Name)
 	}
 
-	qrsnil1 := NewQueryRules()
-	if qrsnil2 := qrsnil1.filterByPlan("", planbuilder.PLAN_PASS_SELECT, "a"); qrsnil2.rules != nil {
+	qrsnil1 := NewQueryRules(testService)
+	if qrsnil2 := qrsnil1.FilterByPlan("", int(planbuilder.PLAN_PASS_SELECT), "", "a"); qrsnil2.rules != nil {
+		t.Errorf("want nil, got non-nil")
+	}
+}
+
+func TestFilterByKeyspace(t *testing.T) {
+	qrs := NewQueryRules(testService)
+	qr1 := NewQueryRule("rule 1", "r1", QR_FAIL)
+	qr1.AddKeyspaceCond("user")
+	qr1.AddKeyspaceCond("lookup")
+	qrs.Add(qr1)
+
+	if qrf := qrs.Copy().Find("r1"); len(qrf.keyspaces) != 2 || &qrf.keyspaces[0] == &qr1.keyspaces[0] {
+		t.Errorf("copy of r1 shares its keyspaces: %v", qrf.keyspaces)
+	}
+	qrs1 := qrs.FilterByPlan("select", int(planbuilder.PLAN_PASS_SELECT), "lookup", "a")
+	if l := len(qrs1.rules); l != 1 {
+		t.Fatalf("want 1, got %d", l)
+	}
+	if qrs1.rules[0].keyspaces != nil {
+		t.Errorf("want nil, got non-nil")
+	}
+	if qrs1 = qrs.FilterByPlan("select", int(planbuilder.PLAN_PASS_SELECT), "music", "a"); qrs1.rules != nil {
 		t.Errorf("want nil, got non-nil")
 	}
 }
 
+func TestServiceJSON(t *testing.T) {
+	svc := &Service{
+		PlanByName: testService.PlanByName,
+		Actions:    map[string]Action{"FAIL": QR_FAIL},
+	}
+	invalid := []InvalidJSONCase{
+		{`[{"Keyspaces": ["user"]}]`, "unrecognized tag Keyspaces"},
+		{`[{"Action": "FAIL_RETRY"}]`, "invalid Action FAIL_RETRY"},
+		{`[{"Action": "LIMIT", "MaxRows": 1}]`, "invalid Action LIMIT"},
+	}
+	for _, tcase := range invalid {
+		err := NewQueryRules(svc).UnmarshalJSON([]byte(tcase.input))
+		if err == nil || err.Error() != tcase.err {
+			t.Errorf("invalid json: %s, want '%v', got '%v'", tcase.input, tcase.err, err)
+		}
+	}
+	qrs := NewQueryRules(testService)
+	if err := qrs.UnmarshalJSON([]byte(`[{"Name": "r1", "Keyspaces": ["user"]}]`)); err != nil {
+		t.Fatalf("UnmarshalJSON: %v", err)
+	}
+	if qr := qrs.Find("r1"); len(qr.keyspaces) != 1 || qr.keyspaces[0] != "user" {
+		t.Errorf("r1 keyspaces: %v, want [user]", qr.keyspaces)
+	}
+}
+
 func TestQueryRule(t *testing.T) {
 	qr := NewQueryRule("rule 1", "r1", QR_FAIL)
 	err := qr.SetIPCond("123")
@@ -215,14 +280,14 @@ func TestQueryRule(t *testing.T) {
 		t.Errorf("want error")
 	}
 
-	qr.AddPlanCond(planbuilder.PLAN_PASS_SELECT)
-	qr.AddPlanCond(planbuilder.PLAN_INSERT_PK)
+	qr.AddPlanCond(int(planbuilder.PLAN_PASS_SELECT))
+	qr.AddPlanCond(int(planbuilder.PLAN_INSERT_PK))
 
-	if qr.plans[0] != planbuilder.PLAN_PASS_SELECT {
-		t.Errorf("want PASS_SELECT, got %s", qr.plans[0].String())
+	if qr.plans[0] != int(planbuilder.PLAN_PASS_SELECT) {
+		t.Errorf("want PASS_SELECT, got %s", planbuilder.PlanType(qr.plans[0]).String())
 	}
-	if qr.plans[1] != planbuilder.PLAN_INSERT_PK {
-		t.Errorf("want INSERT_PK, got %s", qr.plans[1].String())
+	if qr.plans[1] != int(planbuilder.PLAN_INSERT_PK) {
+		t.Errorf("want INSERT_PK, got %s", planbuilder.PlanType(qr.plans[1]).String())
 	}
 
 	qr.AddTableCond("a")
@@ -490,7 +555,7 @@ func TestBVConditions(t *testing.T) {
 }
 
 func TestAction(t *testing.T) {
-	qrs := NewQueryRules()
+	qrs := NewQueryRules(testService)
 
 	qr1 := NewQueryRule("rule 1", "r1", QR_FAIL)
 	qr1.SetIPCond("123")
@@ -507,31 +572,31 @@ func TestAction(t *testing.T) {
 
 	bv := make(map[string]interface{})
 	bv["a"] = uint64(0)
-	action, desc := qrs.getAction("123", "user1", bv)
+	action, qr, _ := qrs.GetAction("123", "user1", bv)
 	if action != QR_FAIL {
 		t.Errorf("want fail")
 	}
-	if desc != "rule 1" {
-		t.Errorf("want rule 1, got %s", desc)
+	if qr != qr1 {
+		t.Errorf("want rule 1, got %v", qr)
 	}
-	action, desc = qrs.getAction("1234", "user", bv)
+	action, qr, _ = qrs.GetAction("1234", "user", bv)
 	if action != QR_FAIL_RETRY {
 		t.Errorf("want fail_retry")
 	}
-	if desc != "rule 2" {
-		t.Errorf("want rule 2, got %s", desc)
+	if qr != qr2 {
+		t.Errorf("want rule 2, got %v", qr)
 	}
-	action, desc = qrs.getAction("1234", "user1", bv)
+	action, qr, _ = qrs.GetAction("1234", "user1", bv)
 	if action != QR_CONTINUE {
 		t.Errorf("want continue")
 	}
 	bv["a"] = uint64(1)
-	action, desc = qrs.getAction("1234", "user1", bv)
+	action, qr, _ = qrs.GetAction("1234", "user1", bv)
 	if action != QR_FAIL {
 		t.Errorf("want fail")
 	}
-	if desc != "rule 3" {
-		t.Errorf("want rule 2, got %s", desc)
+	if qr != qr3 {
+		t.Errorf("want rule 3, got %v", qr)
 	}
 }
 
@@ -560,8 +625,97 @@ var jsondata = `[{
 	"Name": "name2"
 }]`
 
+func TestActionDegrade(t *testing.T) {
+	qrs := NewQueryRules(testService)
+
+	qr1 := NewQueryRule("rule 1", "r1", QR_LIMIT)
+	qr1.SetLimits(100, 2*time.Second)
+
+	qr2 := NewQueryRule("rule 2", "r2", QR_LIMIT)
+	qr2.SetUserCond("user")
+	qr2.SetLimits(10, 0)
+
+	qr3 := NewQueryRule("rule 3", "r3", QR_LOW_PRIORITY)
+	qr3.SetIPCond("123")
+
+	qr4 := NewQueryRule("rule 4", "r4", QR_THROTTLE)
+	qr4.SetUserCond("throttled")
+	qr4.SetMaxQPS(1)
+
+	qrs.Add(qr1)
+	qrs.Add(qr2)
+	qrs.Add(qr3)
+	qrs.Add(qr4)
+
+	action, _, limits := qrs.GetAction("1234", "user1", nil)
+	if action != QR_CONTINUE {
+		t.Errorf("want continue")
+	}
+	if want := (Limits{MaxRows: 100, Timeout: 2 * time.Second}); limits != want {
+		t.Errorf("limits: %+v, want %+v", limits, want)
+	}
+	action, _, limits = qrs.GetAction("123", "user", nil)
+	if action != QR_CONTINUE {
+		t.Errorf("want continue")
+	}
+	if want := (Limits{MaxRows: 10, Timeout: 2 * time.Second, LowPriority: true}); limits != want {
+		t.Errorf("limits: %+v, want %+v", limits, want)
+	}
+
+	// the first query of throttled is within the rate, not the second one
+	action, _, _ = qrs.GetAction("1234", "throttled", nil)
+	if action != QR_CONTINUE {
+		t.Errorf("want continue")
+	}
+	action, qr, limits := qrs.GetAction("1234", "throttled", nil)
+	if action != QR_THROTTLE {
+		t.Errorf("want throttle")
+	}
+	if qr != qr4 || qr.MaxQPS() != 1 {
+		t.Errorf("want rule 4, got %v", qr)
+	}
+	if limits != (Limits{}) {
+		t.Errorf("limits: %+v, want none", limits)
+	}
+
+	// the copies of a rule share its rate
+	qrf4 := qrs.Copy().Find("r4")
+	if qrf4.limiter != qr4.limiter || qrf4.maxQPS != 1 {
+		t.Errorf("copy of r4: %v/%v, want %v/1", qrf4.limiter, qrf4.maxQPS, qr4.limiter)
+	}
+}
+
+func TestBuildQueryRuleDegradingActions(t *testing.T) {
+	qrs := NewQueryRules(testService)
+	err := json.Unmarshal([]byte(`[{
+		"Name": "r1",
+		"Action": "THROTTLE",
+		"MaxQPS": 10
+	},{
+		"Name": "r2",
+		"Action": "LIMIT",
+		"MaxRows": 100,
+		"Timeout": 1.5
+	},{
+		"Name": "r3",
+		"Action": "LOW_PRIORITY"
+	}]`), qrs)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if qr := qrs.Find("r1"); qr.act != QR_THROTTLE || qr.maxQPS != 10 || qr.limiter == nil {
+		t.Errorf("r1: %+v", qr)
+	}
+	if qr := qrs.Find("r2"); qr.act != QR_LIMIT || qr.maxRows != 100 || qr.timeout != 1500*time.Millisecond {
+		t.Errorf("r2: %+v", qr)
+	}
+	if qr := qrs.Find("r3"); qr.act != QR_LOW_PRIORITY {
+		t.Errorf("r3: %+v", qr)
+	}
+}
+
 func TestImport(t *testing.T) {
-	var qrs = NewQueryRules()
+	var qrs = NewQueryRules(testService)
 	err := qrs.UnmarshalJSON([]byte(jsondata))
 	if err != nil {
 		t.Errorf("Unexptected: %v", err)
@@ -582,11 +736,11 @@ func TestImport(t *testing.T) {
 	if qrs.rules[0].query == nil {
 		t.Errorf("want non-nil")
 	}
-	if qrs.rules[0].plans[0] != planbuilder.PLAN_PASS_SELECT {
-		t.Errorf("want PASS_SELECT, got %s", qrs.rules[0].plans[0].String())
+	if qrs.rules[0].plans[0] != int(planbuilder.PLAN_PASS_SELECT) {
+		t.Errorf("want PASS_SELECT, got %s", planbuilder.PlanType(qrs.rules[0].plans[0]).String())
 	}
-	if qrs.rules[0].plans[1] != planbuilder.PLAN_INSERT_PK {
-		t.Errorf("want PASS_INSERT_PK, got %s", qrs.rules[0].plans[0].String())
+	if qrs.rules[0].plans[1] != int(planbuilder.PLAN_INSERT_PK) {
+		t.Errorf("want PASS_INSERT_PK, got %s", planbuilder.PlanType(qrs.rules[0].plans[0]).String())
 	}
 	if qrs.rules[0].tableNames[0] != "a" {
 		t.Errorf("want a, got %s", qrs.rules[0].tableNames[0])
@@ -694,7 +848,7 @@ var validjsons = []ValidJSONCase{
 
 func TestValidJSON(t *testing.T) {
 	for i, tcase := range validjsons {
-		qrs := NewQueryRules()
+		qrs := NewQueryRules(testService)
 		err := qrs.UnmarshalJSON([]byte(tcase.input))
 		if err != nil {
 			t.Errorf("Unexpected error for case %d: %v", i, err)
@@ -790,11 +944,18 @@ var invalidjsons = []InvalidJSONCase{
 	{`[{"BindVarConds": [{"Name": "a", "OnAbsent": true, "OnMismatch": true, "Operator": "NOMATCH", "Value": "["}]}]`, "processing [: error parsing regexp: missing closing ]: `[$`"},
 	{`[{"Action": 1 }]`, "want string for Action"},
 	{`[{"Action": "foo" }]`, "invalid Action foo"},
+	{`[{"Action": "THROTTLE" }]`, "THROTTLE rule : invalid MaxQPS 0"},
+	{`[{"Action": "THROTTLE", "MaxQPS": 1.5 }]`, "want integer for MaxQPS"},
+	{`[{"Action": "FAIL", "MaxQPS": 1 }]`, "MaxQPS is only valid for the THROTTLE action"},
+	{`[{"Action": "LIMIT" }]`, "LIMIT rule : want MaxRows or Timeout"},
+	{`[{"Action": "LIMIT", "MaxRows": -1 }]`, "LIMIT rule : invalid MaxRows -1"},
+	{`[{"Action": "LIMIT", "Timeout": "1" }]`, "want number for Timeout"},
+	{`[{"Action": "LOW_PRIORITY", "MaxRows": 1 }]`, "MaxRows and Timeout are only valid for the LIMIT action"},
 }
 
 func TestInvalidJSON(t *testing.T) {
 	for _, tcase := range invalidjsons {
-		qrs := NewQueryRules()
+		qrs := NewQueryRules(testService)
 		err := qrs.UnmarshalJSON([]byte(tcase.input))
 		if err == nil {
 			t.Errorf("want error for case %q", tcase.input)
@@ -805,14 +966,9 @@ func TestInvalidJSON(t *testing.T) {
 			t.Errorf("invalid json: %s, want '%v', got '%v'", tcase.input, tcase.err, recvd)
 		}
 	}
-	qrs := NewQueryRules()
-	err := qrs.UnmarshalJSON([]byte(`{`))
-	terr, ok := err.(*TabletError)
-	if !ok {
-		t.Fatalf("invalid json, should get a tablet error")
-	}
-	if terr.ErrorType != ErrFail {
-		t.Fatalf("should get ErrFail")
+	var qrs QueryRules
+	if err := qrs.UnmarshalJSON([]byte(jsondata)); err == nil {
+		t.Errorf("rules without a query service should fail to unmarshal")
 	}
 }
 
@@ -822,7 +978,7 @@ func TestBuildQueryRuleActionFail(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to unmarshal json, got error: %v", err)
 	}
-	qr, err := BuildQueryRule(ruleInfo)
+	qr, err := testService.BuildQueryRule(ruleInfo)
 	if err != nil {
 		t.Fatalf("build query rule should succeed")
 	}
@@ -835,7 +991,7 @@ func TestBuildQueryRuleFailureModes(t *testing.T) {
 	var err error
 	var errStr string
 
-	_, err = BuildQueryRule(map[string]interface{}{
+	_, err = testService.BuildQueryRule(map[string]interface{}{
 		"BindVarConds": []interface{}{map[string]interface{}{"Name": "a", "OnAbsent": true, "Operator": QR_IN, "Value": key.KeyRange{}}},
 	})
 	if err == nil {
@@ -846,7 +1002,7 @@ func TestBuildQueryRuleFailureModes(t *testing.T) {
 		t.Fatalf("expect to get error: want string for Operator, but got: %v", err)
 	}
 
-	_, err = BuildQueryRule(map[string]interface{}{
+	_, err = testService.BuildQueryRule(map[string]interface{}{
 		"BindVarConds": []interface{}{map[string]interface{}{"Name": "a", "OnAbsent": true, "Operator": "IN", "Value": 1}},
 	})
 	if err == nil {
@@ -857,7 +1013,7 @@ func TestBuildQueryRuleFailureModes(t *testing.T) {
 		t.Fatalf("expect to get error: want keyrange for Value, but got: %v", err)
 	}
 
-	_, err = BuildQueryRule(map[string]interface{}{
+	_, err = testService.BuildQueryRule(map[string]interface{}{
 		"BindVarConds": []interface{}{map[string]interface{}{"Name": "a", "OnAbsent": true, "Operator": "IN", "Value": map[string]interface{}{}}},
 	})
 	if err == nil {
@@ -868,7 +1024,7 @@ func TestBuildQueryRuleFailureModes(t *testing.T) {
 		t.Fatalf("expect to get error: Start missing in KeyRange, but got: %v", err)
 	}
 
-	_, err = BuildQueryRule(map[string]interface{}{
+	_, err = testService.BuildQueryRule(map[string]interface{}{
 		"BindVarConds": []interface{}{map[string]interface{}{"Name": "a", "OnAbsent": true, "Operator": "IN", "Value": map[string]interface{}{"Start": 1}}},
 	})
 	if err == nil {
@@ -879,7 +1035,7 @@ func TestBuildQueryRuleFailureModes(t *testing.T) {
 		t.Fatalf("expect to get error: want string for Start, but got: %v", err)
 	}
 
-	_, err = BuildQueryRule(map[string]interface{}{
+	_, err = testService.BuildQueryRule(map[string]interface{}{
 		"BindVarConds": []interface{}{map[string]interface{}{"Name": "a", "OnAbsent": true, "Operator": "IN", "Value": map[string]interface{}{"Start": "1"}}},
 	})
 	if err == nil {
@@ -890,7 +1046,7 @@ func TestBuildQueryRuleFailureModes(t *testing.T) {
 		t.Fatalf("expect to get error: End missing in KeyRange, but got: %v", err)
 	}
 
-	_, err = BuildQueryRule(map[string]interface{}{
+	_, err = testService.BuildQueryRule(map[string]interface{}{
 		"BindVarConds": []interface{}{map[string]interface{}{"Name": "a", "OnAbsent": true, "Operator": "IN", "Value": map[string]interface{}{"Start": "1", "End": 2}}},
 	})
 	if err == nil {
@@ -901,7 +1057,7 @@ func TestBuildQueryRuleFailureModes(t *testing.T) {
 		t.Fatalf("expect to get error: want string for End, but got: %v", err)
 	}
 
-	_, err = BuildQueryRule(map[string]interface{}{
+	_, err = testService.BuildQueryRule(map[string]interface{}{
 		"BindVarConds": []interface{}{map[string]interface{}{"Name": "a", "OnAbsent": true, "OnMismatch": "invalid", "Operator": "IN", "Value": map[string]interface{}{"Start": "1", "End": "2"}}},
 	})
 	if err == nil {
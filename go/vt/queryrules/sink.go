@@ -0,0 +1,19 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package queryrules
+
+// Sink is where a custom rule source, like a file or a zookeeper
+// node, pushes the query rules it loads. vttablet and vtgate each
+// provide one, so the same custom rule source serves both.
+type Sink interface {
+	// NewQueryRules returns empty query rules for the service of the sink.
+	NewQueryRules() *QueryRules
+
+	// RegisterQueryRuleSource registers a query rule source name.
+	RegisterQueryRuleSource(ruleSource string)
+
+	// SetQueryRules replaces the query rules of ruleSource.
+	SetQueryRules(ruleSource string, qrs *QueryRules) error
+}
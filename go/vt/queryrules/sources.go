@@ -0,0 +1,92 @@
+// Copyright 2014, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package queryrules
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	log "github.com/golang/glog"
+)
+
+// Sources is the maintainer of QueryRules from multiple sources
+type Sources struct {
+	svc *Service
+	// mutex to protect following queryRulesMap
+	mu sync.Mutex
+	// queryRulesMap maps the names of different query rule sources to the actual QueryRules structure
+	queryRulesMap map[string]*QueryRules
+}
+
+// NewSources returns an empty Sources object for the service svc
+func NewSources(svc *Service) *Sources {
+	return &Sources{
+		svc:           svc,
+		queryRulesMap: map[string]*QueryRules{},
+	}
+}
+
+// RegisterQueryRuleSource registers a query rule source name with Sources
+func (s *Sources) RegisterQueryRuleSource(ruleSource string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, existed := s.queryRulesMap[ruleSource]; existed {
+		log.Errorf("Query rule source %s has been registered", ruleSource)
+		panic("Query rule source " + ruleSource + " has been registered")
+	}
+	s.queryRulesMap[ruleSource] = NewQueryRules(s.svc)
+}
+
+// UnRegisterQueryRuleSource removes a registered query rule source name
+func (s *Sources) UnRegisterQueryRuleSource(ruleSource string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.queryRulesMap, ruleSource)
+}
+
+// SetRules takes an external QueryRules structure and overwrite one of the
+// internal QueryRules as designated by ruleSource parameter
+func (s *Sources) SetRules(ruleSource string, newRules *QueryRules) error {
+	if newRules == nil {
+		newRules = NewQueryRules(s.svc)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.queryRulesMap[ruleSource]; ok {
+		s.queryRulesMap[ruleSource] = newRules.Copy()
+		return nil
+	}
+	return errors.New("Rule source identifier " + ruleSource + " is not valid")
+}
+
+// GetRules returns the corresponding QueryRules as designated by ruleSource parameter
+func (s *Sources) GetRules(ruleSource string) (*QueryRules, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ruleset, ok := s.queryRulesMap[ruleSource]; ok {
+		return ruleset.Copy(), nil
+	}
+	return NewQueryRules(s.svc), errors.New("Rule source identifier " + ruleSource + " is not valid")
+}
+
+// FilterByPlan creates a new QueryRules by prefiltering on all query rules that are contained in internal
+// QueryRules structures, in other words, query rules from all predefined sources will be applied.
+// The sources are merged in the order of their names, so that the first matching rule is always
+// the same one.
+func (s *Sources) FilterByPlan(query string, planID int, keyspace, tableName string) (newqrs *QueryRules) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.queryRulesMap))
+	for name := range s.queryRulesMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	newqrs = NewQueryRules(s.svc)
+	for _, name := range names {
+		newqrs.Append(s.queryRulesMap[name].FilterByPlan(query, planID, keyspace, tableName))
+	}
+	return newqrs
+}
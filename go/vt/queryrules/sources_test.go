@@ -2,7 +2,7 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-package tabletserver
+package queryrules
 
 import (
 	"fmt"
@@ -32,7 +32,7 @@ const customQueryRules string = "CUSTOM_QUERY_RULES"
 func setupQueryRules() {
 	var qr *QueryRule
 	// mock keyrange rules
-	keyrangeRules = NewQueryRules()
+	keyrangeRules = NewQueryRules(testService)
 	dmlPlans := []struct {
 		planID   planbuilder.PlanType
 		onAbsent bool
@@ -49,13 +49,13 @@ func setupQueryRules() {
 			fmt.Sprintf("keyspace_id_not_in_range_%v", plan.planID),
 			QR_FAIL,
 		)
-		qr.AddPlanCond(plan.planID)
+		qr.AddPlanCond(int(plan.planID))
 		qr.AddBindVarCond("keyspace_id", plan.onAbsent, true, QR_NOTIN, key.KeyRange{Start: "aa", End: "zz"})
 		keyrangeRules.Add(qr)
 	}
 
 	// mock blacklisted tables
-	blacklistRules = NewQueryRules()
+	blacklistRules = NewQueryRules(testService)
 	blacklistedTables := []string{"bannedtable1", "bannedtable2", "bannedtable3"}
 	qr = NewQueryRule("enforce blacklisted tables", "blacklisted_table", QR_FAIL_RETRY)
 	for _, t := range blacklistedTables {
@@ -64,16 +64,16 @@ func setupQueryRules() {
 	blacklistRules.Add(qr)
 
 	// mock custom rules
-	otherRules = NewQueryRules()
+	otherRules = NewQueryRules(testService)
 	qr = NewQueryRule("sample custom rule", "customrule_ban_bindvar", QR_FAIL)
 	qr.AddTableCond("t_customer")
 	qr.AddBindVarCond("bindvar1", true, false, QR_NOOP, nil)
 	otherRules.Add(qr)
 }
 
-func TestQueryRuleInfoRegisterARegisteredSource(t *testing.T) {
+func TestSourcesRegisterARegisteredSource(t *testing.T) {
 	setupQueryRules()
-	qri := NewQueryRuleInfo()
+	qri := NewSources(testService)
 	qri.RegisterQueryRuleSource(keyrangeQueryRules)
 	defer func() {
 		err := recover()
@@ -84,9 +84,9 @@ func TestQueryRuleInfoRegisterARegisteredSource(t *testing.T) {
 	qri.RegisterQueryRuleSource(keyrangeQueryRules)
 }
 
-func TestQueryRuleInfoSetRulesWithNil(t *testing.T) {
+func TestSourcesSetRulesWithNil(t *testing.T) {
 	setupQueryRules()
-	qri := NewQueryRuleInfo()
+	qri := NewSources(testService)
 
 	qri.RegisterQueryRuleSource(keyrangeQueryRules)
 	err := qri.SetRules(keyrangeQueryRules, keyrangeRules)
@@ -107,14 +107,14 @@ func TestQueryRuleInfoSetRulesWithNil(t *testing.T) {
 	if err != nil {
 		t.Errorf("GetRules failed to retrieve keyrangeQueryRules that has been set: %s", err)
 	}
-	if !reflect.DeepEqual(qrs, NewQueryRules()) {
+	if !reflect.DeepEqual(qrs, NewQueryRules(testService)) {
 		t.Errorf("keyrangeQueryRules retrived is %v, but the expected value should be %v", qrs, keyrangeRules)
 	}
 }
 
-func TestQueryRuleInfoGetSetQueryRules(t *testing.T) {
+func TestSourcesGetSetQueryRules(t *testing.T) {
 	setupQueryRules()
-	qri := NewQueryRuleInfo()
+	qri := NewSources(testService)
 
 	qri.RegisterQueryRuleSource(keyrangeQueryRules)
 	qri.RegisterQueryRuleSource(blacklistQueryRules)
@@ -128,12 +128,12 @@ func TestQueryRuleInfoGetSetQueryRules(t *testing.T) {
 	if qrs == nil {
 		t.Errorf("GetRules should always return empty QueryRules and never nil")
 	}
-	if !reflect.DeepEqual(qrs, NewQueryRules()) {
+	if !reflect.DeepEqual(qrs, NewQueryRules(testService)) {
 		t.Errorf("QueryRuleInfo contains only empty QueryRules at the beginning")
 	}
 
 	// Test if we can set a QueryRules without a predefined rule set name
-	err = qri.SetRules("Foo", NewQueryRules())
+	err = qri.SetRules("Foo", NewQueryRules(testService))
 	if err == nil {
 		t.Errorf("SetRules shouldn't succeed with 'Foo' as the rule set name")
 	}
@@ -178,10 +178,10 @@ func TestQueryRuleInfoGetSetQueryRules(t *testing.T) {
 	}
 }
 
-func TestQueryRuleInfoFilterByPlan(t *testing.T) {
+func TestSourcesFilterByPlan(t *testing.T) {
 	var qrs *QueryRules
 	setupQueryRules()
-	qri := NewQueryRuleInfo()
+	qri := NewSources(testService)
 
 	qri.RegisterQueryRuleSource(keyrangeQueryRules)
 	qri.RegisterQueryRuleSource(blacklistQueryRules)
@@ -192,7 +192,7 @@ func TestQueryRuleInfoFilterByPlan(t *testing.T) {
 	qri.SetRules(customQueryRules, otherRules)
 
 	// Test filter by keyrange rule
-	qrs = qri.filterByPlan("insert into t_test values(123, 456, 'abc')", planbuilder.PLAN_INSERT_PK, "t_test")
+	qrs = qri.FilterByPlan("insert into t_test values(123, 456, 'abc')", int(planbuilder.PLAN_INSERT_PK), "", "t_test")
 	if l := len(qrs.rules); l != 1 {
 		t.Errorf("Insert PK query matches %d rules, but we expect %d", l, 1)
 	}
@@ -201,7 +201,7 @@ func TestQueryRuleInfoFilterByPlan(t *testing.T) {
 	}
 
 	// Test filter by blacklist rule
-	qrs = qri.filterByPlan("select * from bannedtable2", planbuilder.PLAN_PASS_SELECT, "bannedtable2")
+	qrs = qri.FilterByPlan("select * from bannedtable2", int(planbuilder.PLAN_PASS_SELECT), "", "bannedtable2")
 	if l := len(qrs.rules); l != 1 {
 		t.Errorf("Select from bannedtable matches %d rules, but we expect %d", l, 1)
 	}
@@ -210,7 +210,7 @@ func TestQueryRuleInfoFilterByPlan(t *testing.T) {
 	}
 
 	// Test filter by custom rule
-	qrs = qri.filterByPlan("select cid from t_customer limit 10", planbuilder.PLAN_PASS_SELECT, "t_customer")
+	qrs = qri.FilterByPlan("select cid from t_customer limit 10", int(planbuilder.PLAN_PASS_SELECT), "", "t_customer")
 	if l := len(qrs.rules); l != 1 {
 		t.Errorf("Select from t_customer matches %d rules, but we expect %d", l, 1)
 	}
@@ -219,24 +219,19 @@ func TestQueryRuleInfoFilterByPlan(t *testing.T) {
 	}
 
 	// Test match two rules: both keyrange rule and custom rule will be matched
-	otherRules = NewQueryRules()
+	otherRules = NewQueryRules(testService)
 	qr := NewQueryRule("sample custom rule", "customrule_ban_bindvar", QR_FAIL)
 	qr.AddBindVarCond("bindvar1", true, false, QR_NOOP, nil)
 	otherRules.Add(qr)
 	qri.SetRules(customQueryRules, otherRules)
-	qrs = qri.filterByPlan("insert into t_test values (:bindvar1, 123, 'test')", planbuilder.PLAN_INSERT_PK, "t_test")
+	qrs = qri.FilterByPlan("insert into t_test values (:bindvar1, 123, 'test')", int(planbuilder.PLAN_INSERT_PK), "", "t_test")
 	if l := len(qrs.rules); l != 2 {
 		t.Errorf("Insert into t_test matches %d rules: %v, but we expect %d rules to be matched", l, qrs.rules, 2)
 	}
-	if strings.HasPrefix(qrs.rules[0].Name, "keyspace_id_not_in_range") &&
-		strings.HasPrefix(qrs.rules[1].Name, "customrule_ban_bindvar") {
-		return
+	// The sources are merged in the order of their names.
+	if !strings.HasPrefix(qrs.rules[0].Name, "customrule_ban_bindvar") ||
+		!strings.HasPrefix(qrs.rules[1].Name, "keyspace_id_not_in_range") {
+		t.Errorf("Insert into t_test matches rule[0] '%s' and rule[1] '%s', but we expect rule[0] with prefix '%s' and rule[1] with prefix '%s'",
+			qrs.rules[0].Name, qrs.rules[1].Name, "customrule_ban_bindvar", "keyspace_id_not_in_range")
 	}
-	if strings.HasPrefix(qrs.rules[1].Name, "keyspace_id_not_in_range") &&
-		strings.HasPrefix(qrs.rules[0].Name, "customrule_ban_bindvar") {
-		return
-	}
-
-	t.Errorf("Insert into t_test matches rule[0] '%s' and rule[1] '%s', but we expect rule[0] with prefix '%s' and rule[1] with prefix '%s'",
-		qrs.rules[0].Name, qrs.rules[1].Name, "keyspace_id_not_in_range", "customrule_ban_bindvar")
 }
@@ -2,6 +2,8 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Package zkcustomrule implements dynamic custom rules
+// from a zookeeper node, for vttablet or vtgate.
 package zkcustomrule
 
 import (
@@ -11,8 +13,8 @@ import (
 	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/queryrules"
 	"github.com/youtube/vitess/go/vt/servenv"
-	"github.com/youtube/vitess/go/vt/tabletserver"
 	"github.com/youtube/vitess/go/zk"
 	"launchpad.net/gozk/zookeeper"
 )
@@ -36,7 +38,7 @@ type ZkCustomRule struct {
 	path                  string
 	zconn                 zk.Conn
 	watch                 <-chan zookeeper.Event // Zookeeper watch for listenning data change notifications
-	currentRuleSet        *tabletserver.QueryRules
+	currentRuleSet        *queryrules.QueryRules
 	currentRuleSetVersion int64 // implemented with Zookeeper transaction id
 	finish                chan int
 }
@@ -45,23 +47,23 @@ type ZkCustomRule struct {
 func NewZkCustomRule(zkconn zk.Conn) *ZkCustomRule {
 	return &ZkCustomRule{
 		zconn:                 zkconn,
-		currentRuleSet:        tabletserver.NewQueryRules(),
+		currentRuleSet:        queryrules.NewQueryRules(nil),
 		currentRuleSetVersion: InvalidQueryRulesVersion,
 		finish:                make(chan int, 1)}
 }
 
 // Open Registers Zookeeper watch, gets inital QueryRules and starts polling routine
-func (zkcr *ZkCustomRule) Open(qsc tabletserver.QueryServiceControl, rulePath string) (err error) {
+func (zkcr *ZkCustomRule) Open(sink queryrules.Sink, rulePath string) (err error) {
 	zkcr.path = rulePath
 	err = zkcr.refreshWatch()
 	if err != nil {
 		return err
 	}
-	err = zkcr.refreshData(qsc, false)
+	err = zkcr.refreshData(sink, false)
 	if err != nil {
 		return err
 	}
-	go zkcr.poll(qsc)
+	go zkcr.poll(sink)
 	return nil
 }
 
@@ -78,13 +80,13 @@ func (zkcr *ZkCustomRule) refreshWatch() error {
 }
 
 // refreshData gets query rules from Zookeeper and refresh internal QueryRules cache
-// this function will also call SqlQuery.SetQueryRules to propagate rule changes to query service
-func (zkcr *ZkCustomRule) refreshData(qsc tabletserver.QueryServiceControl, nodeRemoval bool) error {
+// this function will also call sink.SetQueryRules to propagate rule changes to query service
+func (zkcr *ZkCustomRule) refreshData(sink queryrules.Sink, nodeRemoval bool) error {
 	data, stat, err := zkcr.zconn.Get(zkcr.path)
 	zkcr.mu.Lock()
 	defer zkcr.mu.Unlock()
 	if err == nil {
-		qrs := tabletserver.NewQueryRules()
+		qrs := sink.NewQueryRules()
 		if !nodeRemoval {
 			err = qrs.UnmarshalJSON([]byte(data))
 			if err != nil {
@@ -94,9 +96,12 @@ func (zkcr *ZkCustomRule) refreshData(qsc tabletserver.QueryServiceControl, node
 		}
 		zkcr.currentRuleSetVersion = stat.Mzxid()
 		if !reflect.DeepEqual(zkcr.currentRuleSet, qrs) {
+			if err := sink.SetQueryRules(ZkCustomRuleSource, qrs.Copy()); err != nil {
+				log.Warningf("Error applying custom rule version %v fetched from Zookeeper: %v", zkcr.currentRuleSetVersion, err)
+				return err
+			}
 			zkcr.currentRuleSet = qrs.Copy()
-			qsc.SetQueryRules(ZkCustomRuleSource, qrs.Copy())
-			log.Infof("Custom rule version %v fetched from Zookeeper and applied to vttablet", zkcr.currentRuleSetVersion)
+			log.Infof("Custom rule version %v fetched from Zookeeper and applied", zkcr.currentRuleSetVersion)
 		}
 		return nil
 	}
@@ -108,7 +113,7 @@ const sleepDuringZkFailure time.Duration = 30
 
 // poll polls the Zookeeper watch channel for data changes and refresh watch channel if watch channel is closed
 // by Zookeeper Go library on error conditions such as connection reset
-func (zkcr *ZkCustomRule) poll(qsc tabletserver.QueryServiceControl) {
+func (zkcr *ZkCustomRule) poll(sink queryrules.Sink) {
 	for {
 		select {
 		case <-zkcr.finish:
@@ -116,7 +121,7 @@ func (zkcr *ZkCustomRule) poll(qsc tabletserver.QueryServiceControl) {
 		case event := <-zkcr.watch:
 			switch event.Type {
 			case zookeeper.EVENT_CREATED, zookeeper.EVENT_CHANGED, zookeeper.EVENT_DELETED:
-				err := zkcr.refreshData(qsc, event.Type == zookeeper.EVENT_DELETED) // refresh rules
+				err := zkcr.refreshData(sink, event.Type == zookeeper.EVENT_DELETED) // refresh rules
 				if err != nil {
 					// Sleep to avoid busy waiting during connection re-establishment
 					<-time.After(time.Second * sleepDuringZkFailure)
@@ -127,7 +132,7 @@ func (zkcr *ZkCustomRule) poll(qsc tabletserver.QueryServiceControl) {
 					// Sleep to avoid busy waiting during connection re-establishment
 					<-time.After(time.Second * sleepDuringZkFailure)
 				}
-				zkcr.refreshData(qsc, false)
+				zkcr.refreshData(sink, false)
 			}
 		}
 	}
@@ -140,21 +145,20 @@ func (zkcr *ZkCustomRule) Close() {
 }
 
 // GetRules retrives cached rules
-func (zkcr *ZkCustomRule) GetRules() (qrs *tabletserver.QueryRules, version int64, err error) {
+func (zkcr *ZkCustomRule) GetRules() (qrs *queryrules.QueryRules, version int64, err error) {
 	zkcr.mu.Lock()
 	defer zkcr.mu.Unlock()
 	return zkcr.currentRuleSet.Copy(), zkcr.currentRuleSetVersion, nil
 }
 
 // ActivateZkCustomRules activates zookeeper dynamic custom rule mechanism
-func ActivateZkCustomRules(qsc tabletserver.QueryServiceControl) {
+func ActivateZkCustomRules(sink queryrules.Sink) {
 	if *zkRulePath != "" {
-		tabletserver.QueryRuleSources.RegisterQueryRuleSource(ZkCustomRuleSource)
-		zkCustomRule.Open(qsc, *zkRulePath)
+		sink.RegisterQueryRuleSource(ZkCustomRuleSource)
+		zkCustomRule.Open(sink, *zkRulePath)
 	}
 }
 
 func init() {
-	tabletserver.QueryServiceControlRegisterFunctions = append(tabletserver.QueryServiceControlRegisterFunctions, ActivateZkCustomRules)
 	servenv.OnTerm(zkCustomRule.Close)
 }
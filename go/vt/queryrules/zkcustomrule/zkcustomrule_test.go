@@ -9,7 +9,8 @@ import (
 	"testing"
 	"time"
 
-	"github.com/youtube/vitess/go/vt/tabletserver"
+	"github.com/youtube/vitess/go/vt/queryrules"
+	"github.com/youtube/vitess/go/vt/vtgate"
 	"github.com/youtube/vitess/go/zk"
 	"github.com/youtube/vitess/go/zk/fakezk"
 	"launchpad.net/gozk/zookeeper"
@@ -37,6 +38,17 @@ var customRule2 = `[
 			]`
 var conn zk.Conn
 
+// checkVTGateRule checks that vtgate has the rule name from zookeeper.
+func checkVTGateRule(t *testing.T, name string) {
+	qrs, err := vtgate.QueryRuleSources.GetRules(ZkCustomRuleSource)
+	if err != nil {
+		t.Fatalf("QueryRuleSources.GetRules returns error: %v", err)
+	}
+	if qrs.Find(name) == nil {
+		t.Fatalf("Expect custom rule %v to be set in vtgate, but got nothing, qrs=%v", name, qrs)
+	}
+}
+
 func setUpFakeZk(t *testing.T) {
 	conn = fakezk.NewConn()
 	conn.Create("/zk", "", 0, zookeeper.WorldACL(zookeeper.PERM_ALL))
@@ -47,16 +59,17 @@ func setUpFakeZk(t *testing.T) {
 }
 
 func TestZkCustomRule(t *testing.T) {
-	tqsc := tabletserver.NewTestQueryServiceControl()
+	vtgate.QueryRuleSources.RegisterQueryRuleSource(ZkCustomRuleSource)
+	defer vtgate.QueryRuleSources.UnRegisterQueryRuleSource(ZkCustomRuleSource)
 
 	setUpFakeZk(t)
 	zkcr := NewZkCustomRule(conn)
-	err := zkcr.Open(tqsc, "/zk/fake/customrules/testrules")
+	err := zkcr.Open(vtgate.QueryRuleSink, "/zk/fake/customrules/testrules")
 	if err != nil {
 		t.Fatalf("Cannot open zookeeper custom rule service, err=%v", err)
 	}
 
-	var qrs *tabletserver.QueryRules
+	var qrs *queryrules.QueryRules
 	// Test if we can successfully fetch the original rule (test GetRules)
 	qrs, _, err = zkcr.GetRules()
 	if err != nil {
@@ -66,6 +79,7 @@ func TestZkCustomRule(t *testing.T) {
 	if qr == nil {
 		t.Fatalf("Expect custom rule r1 to be found, but got nothing, qrs=%v", qrs)
 	}
+	checkVTGateRule(t, "r1")
 
 	// Test updating rules
 	conn.Set("/zk/fake/customrules/testrules", customRule2, -1)
@@ -82,6 +96,7 @@ func TestZkCustomRule(t *testing.T) {
 	if qr != nil {
 		t.Fatalf("Custom rule r1 should not be found after r2 is set")
 	}
+	checkVTGateRule(t, "r2")
 
 	// Test rule path removal
 	conn.Delete("/zk/fake/customrules/testrules", -1)
@@ -90,7 +105,7 @@ func TestZkCustomRule(t *testing.T) {
 	if err != nil {
 		t.Fatalf("GetRules of ZkCustomRule should always return nil error, but we receive %v", err)
 	}
-	if reflect.DeepEqual(qrs, tabletserver.NewQueryRules()) {
+	if reflect.DeepEqual(qrs, vtgate.NewQueryRules()) {
 		t.Fatalf("Expect empty rule at this point")
 	}
 
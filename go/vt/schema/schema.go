@@ -27,6 +27,12 @@ const (
 	CACHE_W    = 2
 )
 
+// Table types
+const (
+	NO_TYPE  = 0
+	SEQUENCE = 1
+)
+
 type TableColumn struct {
 	Name     string
 	Category int
@@ -40,6 +46,7 @@ type Table struct {
 	Indexes   []*Index
 	PKColumns []int
 	CacheType int
+	Type      int
 }
 
 func NewTable(name string) *Table {
@@ -18,6 +18,7 @@ import (
 	"github.com/youtube/vitess/go/trace"
 	"github.com/youtube/vitess/go/vt/binlog"
 	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/queryrules"
 	"github.com/youtube/vitess/go/vt/tabletserver"
 	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
 	"github.com/youtube/vitess/go/vt/topo"
@@ -89,13 +90,13 @@ func (agent *ActionAgent) loadKeyspaceAndBlacklistRules(tablet *topo.Tablet, bla
 			{planbuilder.PLAN_DML_SUBQUERY, false},
 		}
 		for _, plan := range dmlPlans {
-			qr := tabletserver.NewQueryRule(
+			qr := queryrules.NewQueryRule(
 				fmt.Sprintf("enforce keyspace_id range for %v", plan.planID),
 				fmt.Sprintf("keyspace_id_not_in_range_%v", plan.planID),
-				tabletserver.QR_FAIL,
+				queryrules.QR_FAIL,
 			)
-			qr.AddPlanCond(plan.planID)
-			err := qr.AddBindVarCond("keyspace_id", plan.onAbsent, true, tabletserver.QR_NOTIN, tablet.KeyRange)
+			qr.AddPlanCond(int(plan.planID))
+			err := qr.AddBindVarCond("keyspace_id", plan.onAbsent, true, queryrules.QR_NOTIN, tablet.KeyRange)
 			if err != nil {
 				return fmt.Errorf("Unable to add keyspace rule: %v", err)
 			}
@@ -112,7 +113,7 @@ func (agent *ActionAgent) loadKeyspaceAndBlacklistRules(tablet *topo.Tablet, bla
 			return err
 		}
 		log.Infof("Blacklisting tables %v", strings.Join(tables, ", "))
-		qr := tabletserver.NewQueryRule("enforce blacklisted tables", "blacklisted_table", tabletserver.QR_FAIL_RETRY)
+		qr := queryrules.NewQueryRule("enforce blacklisted tables", "blacklisted_table", queryrules.QR_FAIL_RETRY)
 		for _, t := range tables {
 			qr.AddTableCond(t)
 		}
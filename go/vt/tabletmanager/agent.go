@@ -186,6 +186,7 @@ func NewActionAgent(
 
 	// register the RPC services from the agent
 	agent.registerQueryService()
+	agent.registerTxParticipantResolver()
 
 	// two cases then:
 	// - restoreFromBackup is set: we restore, then initHealthCheck, all
@@ -0,0 +1,63 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletmanager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/tabletserver"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// txParticipantTimeout is the timeout for resolving
+// a participant of a distributed transaction.
+const txParticipantTimeout = 30 * time.Second
+
+// registerTxParticipantResolver lets the query service resolve
+// the participants of abandoned distributed transactions by
+// sending them to the master of their shard.
+func (agent *ActionAgent) registerTxParticipantResolver() {
+	tabletserver.TxParticipantResolver = agent.resolveTxParticipant
+}
+
+// resolveTxParticipant commits or rolls back the prepared transaction
+// dtid on the master of the participant's shard.
+func (agent *ActionAgent) resolveTxParticipant(ctx context.Context, participant tproto.TxParticipant, dtid string, commit bool) error {
+	ctx, cancel := context.WithTimeout(ctx, txParticipantTimeout)
+	defer cancel()
+	si, err := topo.GetShard(ctx, agent.TopoServer, participant.Keyspace, participant.Shard)
+	if err != nil {
+		return fmt.Errorf("resolveTxParticipant: %v", err)
+	}
+	if si.MasterAlias.IsZero() {
+		return fmt.Errorf("resolveTxParticipant: shard %s/%s has no master", participant.Keyspace, participant.Shard)
+	}
+	tablet, err := agent.TopoServer.GetTablet(ctx, si.MasterAlias)
+	if err != nil {
+		return fmt.Errorf("resolveTxParticipant: %v", err)
+	}
+	endPoint, err := tablet.EndPoint()
+	if err != nil {
+		return fmt.Errorf("resolveTxParticipant: %v", err)
+	}
+	conn, err := tabletconn.GetDialer()(ctx, *endPoint, participant.Keyspace, participant.Shard, txParticipantTimeout)
+	if err != nil {
+		return fmt.Errorf("resolveTxParticipant: %v", err)
+	}
+	defer conn.Close()
+	if commit {
+		err = conn.CommitPrepared(ctx, dtid)
+	} else {
+		err = conn.RollbackPrepared(ctx, dtid, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("resolveTxParticipant: %v", err)
+	}
+	return nil
+}
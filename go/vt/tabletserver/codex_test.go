@@ -26,7 +26,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 	pkValues := []interface{}{pk1Val}
 	// want [[1]]
 	want := [][]sqltypes.Value{[]sqltypes.Value{pk1Val}}
-	got, _ := buildValueList(&tableInfo, pkValues, bindVars)
+	got, _ := buildValueList(tableInfo, pkValues, bindVars)
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("got %v, want %v", got, want)
 	}
@@ -36,7 +36,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 	pkValues = []interface{}{":pk1"}
 	// want [[1]]
 	want = [][]sqltypes.Value{[]sqltypes.Value{pk1Val}}
-	got, _ = buildValueList(&tableInfo, pkValues, bindVars)
+	got, _ = buildValueList(tableInfo, pkValues, bindVars)
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("got %v, want %v", got, want)
 	}
@@ -46,7 +46,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 	pkValues = []interface{}{":pk1"}
 	// want [[1]]
 	want = [][]sqltypes.Value{[]sqltypes.Value{sqltypes.Value{}}}
-	got, _ = buildValueList(&tableInfo, pkValues, bindVars)
+	got, _ = buildValueList(tableInfo, pkValues, bindVars)
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("got %v, want %v", got, want)
 	}
@@ -56,7 +56,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 	pkValues = []interface{}{":pk1"}
 	wantErr := "error: unsupported bind variable type struct {}: {}"
 
-	got, err := buildValueList(&tableInfo, pkValues, bindVars)
+	got, err := buildValueList(tableInfo, pkValues, bindVars)
 
 	if err == nil || !strings.Contains(err.Error(), wantErr) {
 		t.Fatalf("got %v, want %v", err, wantErr)
@@ -67,7 +67,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 	pkValues = []interface{}{":pk1"}
 	wantErr = "error: type mismatch, expecting numeric type for str"
 
-	got, err = buildValueList(&tableInfo, pkValues, bindVars)
+	got, err = buildValueList(tableInfo, pkValues, bindVars)
 	if err == nil || !strings.Contains(err.Error(), wantErr) {
 		t.Fatalf("got %v, want %v", err, wantErr)
 	}
@@ -78,7 +78,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 	pkValues = []interface{}{":pk1", ":pk2"}
 	wantErr = "error: type mismatch, expecting string type for 1"
 
-	got, err = buildValueList(&tableInfo, pkValues, bindVars)
+	got, err = buildValueList(tableInfo, pkValues, bindVars)
 	if err == nil || !strings.Contains(err.Error(), wantErr) {
 		t.Fatalf("got %v, want %v", err, wantErr)
 	}
@@ -88,7 +88,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 	pkValues = []interface{}{pk1Val, pk2Val}
 	// want [[1 abc]]
 	want = [][]sqltypes.Value{[]sqltypes.Value{pk1Val, pk2Val}}
-	got, _ = buildValueList(&tableInfo, pkValues, bindVars)
+	got, _ = buildValueList(tableInfo, pkValues, bindVars)
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("got %v, want %v", got, want)
 	}
@@ -105,7 +105,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 	want = [][]sqltypes.Value{
 		[]sqltypes.Value{pk1Val, pk2Val},
 		[]sqltypes.Value{pk1Val2, pk2Val2}}
-	got, _ = buildValueList(&tableInfo, pkValues, bindVars)
+	got, _ = buildValueList(tableInfo, pkValues, bindVars)
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("got %v, want %v", got, want)
 	}
@@ -122,7 +122,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 		[]sqltypes.Value{pk1Val, pk2Val2},
 	}
 
-	got, _ = buildValueList(&tableInfo, pkValues, bindVars)
+	got, _ = buildValueList(tableInfo, pkValues, bindVars)
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("got %v, want %v", got, want)
 	}
@@ -161,7 +161,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 		[]sqltypes.Value{pk1Val, pk2Val},
 	}
 
-	got, _ = buildValueList(&tableInfo, pkValues, bindVars)
+	got, _ = buildValueList(tableInfo, pkValues, bindVars)
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("got %v, want %v", got, want)
 	}
@@ -176,7 +176,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 	}
 	wantErr = "error: empty list supplied for list"
 
-	got, err = buildValueList(&tableInfo, pkValues, bindVars)
+	got, err = buildValueList(tableInfo, pkValues, bindVars)
 	if err == nil || !strings.Contains(err.Error(), wantErr) {
 		t.Fatalf("got %v, want %v", err, wantErr)
 	}
@@ -191,7 +191,7 @@ func TestCodexBuildValuesList(t *testing.T) {
 	}
 	wantErr = "error: unexpected arg type []interface {} for key list"
 
-	got, err = buildValueList(&tableInfo, pkValues, bindVars)
+	got, err = buildValueList(tableInfo, pkValues, bindVars)
 	if err == nil || !strings.Contains(err.Error(), wantErr) {
 		t.Fatalf("got %v, want %v", err, wantErr)
 	}
@@ -211,13 +211,13 @@ func TestCodexResolvePKValues(t *testing.T) {
 	pkValues = append(pkValues, []interface{}{":" + key})
 	// resolvePKValues fail because type mismatch. pk column 0 has int type but
 	// list variables are strings.
-	_, _, err := resolvePKValues(&tableInfo, pkValues, bindVariables)
+	_, _, err := resolvePKValues(tableInfo, pkValues, bindVariables)
 	testUtils.checkTabletError(t, err, ErrFail, "type mismatch")
 	// pkValues is a list of sqltypes.Value and bypasses bind variables.
 	// But, the type mismatches, pk column 0 is int but variable is string.
 	pkValues = make([]interface{}, 0, 10)
 	pkValues = append(pkValues, sqltypes.MakeString([]byte("type_mismatch")))
-	_, _, err = resolvePKValues(&tableInfo, pkValues, nil)
+	_, _, err = resolvePKValues(tableInfo, pkValues, nil)
 	testUtils.checkTabletError(t, err, ErrFail, "type mismatch")
 	// pkValues with different length
 	bindVariables = make(map[string]interface{})
@@ -231,7 +231,7 @@ func TestCodexResolvePKValues(t *testing.T) {
 	pkValues = append(pkValues, []interface{}{":" + key2, ":" + key3})
 	func() {
 		defer testUtils.checkTabletErrorWithRecover(t, ErrFail, "mismatched lengths")
-		_, _, err = resolvePKValues(&tableInfo, pkValues, bindVariables)
+		_, _, err = resolvePKValues(tableInfo, pkValues, bindVariables)
 	}()
 }
 
@@ -274,19 +274,19 @@ func TestCodexBuildSecondaryList(t *testing.T) {
 	pk1Val, _ := sqltypes.BuildValue(1)
 	pk2Val, _ := sqltypes.BuildValue("abc")
 	pkValues := []interface{}{pk1Val, pk2Val}
-	pkList, _ := buildValueList(&tableInfo, pkValues, bindVars)
+	pkList, _ := buildValueList(tableInfo, pkValues, bindVars)
 	pk2SecVal, _ := sqltypes.BuildValue("xyz")
 	secondaryPKValues := []interface{}{nil, pk2SecVal}
 	// want [[1 xyz]]
 	want := [][]sqltypes.Value{
 		[]sqltypes.Value{pk1Val, pk2SecVal}}
-	got, _ := buildSecondaryList(&tableInfo, pkList, secondaryPKValues, bindVars)
+	got, _ := buildSecondaryList(tableInfo, pkList, secondaryPKValues, bindVars)
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("case 1 failed, got %v, want %v", got, want)
 	}
 
 	secondaryPKValues = []interface{}{"invalid_type", 1}
-	_, err := buildSecondaryList(&tableInfo, pkList, secondaryPKValues, bindVars)
+	_, err := buildSecondaryList(tableInfo, pkList, secondaryPKValues, bindVars)
 	if err == nil {
 		t.Fatalf("should get an error, column 0 is int type, but secondary list provides a string")
 	}
@@ -305,12 +305,12 @@ func TestCodexBuildStreamComment(t *testing.T) {
 	pk1Val, _ := sqltypes.BuildValue(1)
 	pk2Val, _ := sqltypes.BuildValue("abc")
 	pkValues := []interface{}{pk1Val, pk2Val}
-	pkList, _ := buildValueList(&tableInfo, pkValues, bindVars)
+	pkList, _ := buildValueList(tableInfo, pkValues, bindVars)
 	pk2SecVal, _ := sqltypes.BuildValue("xyz")
 	secondaryPKValues := []interface{}{nil, pk2SecVal}
-	secondaryList, _ := buildSecondaryList(&tableInfo, pkList, secondaryPKValues, bindVars)
+	secondaryList, _ := buildSecondaryList(tableInfo, pkList, secondaryPKValues, bindVars)
 	want := []byte(" /* _stream Table (pk1 pk2 ) (1 'YWJj' ) (1 'eHl6' ); */")
-	got := buildStreamComment(&tableInfo, pkList, secondaryList)
+	got := buildStreamComment(tableInfo, pkList, secondaryList)
 	if !reflect.DeepEqual(got, want) {
 		t.Fatalf("case 1 failed, got %v, want %v", got, want)
 	}
@@ -336,10 +336,10 @@ func TestCodexValidateRow(t *testing.T) {
 		[]string{"int", "varbinary(128)", "int"},
 		[]string{"pk1", "pk2"})
 	// #columns and #rows do not match
-	err := validateRow(&tableInfo, []int{1}, []sqltypes.Value{})
+	err := validateRow(tableInfo, []int{1}, []sqltypes.Value{})
 	testUtils.checkTabletError(t, err, ErrFail, "data inconsistency")
 	// column 0 is int type but row is in string type
-	err = validateRow(&tableInfo, []int{0}, []sqltypes.Value{sqltypes.MakeString([]byte("str"))})
+	err = validateRow(tableInfo, []int{0}, []sqltypes.Value{sqltypes.MakeString([]byte("str"))})
 	testUtils.checkTabletError(t, err, ErrFail, "type mismatch")
 }
 
@@ -415,7 +415,7 @@ func TestCodexApplyFilterWithPKDefaults(t *testing.T) {
 		[]string{"pk1", "pk2", "col1"},
 		[]string{"int", "varbinary(128)", "int"},
 		[]string{"pk1", "pk2"})
-	output := applyFilterWithPKDefaults(&tableInfo, []int{-1}, []sqltypes.Value{})
+	output := applyFilterWithPKDefaults(tableInfo, []int{-1}, []sqltypes.Value{})
 	if len(output) != 1 {
 		t.Fatalf("expect to only one output but got: %v", output)
 	}
@@ -434,29 +434,29 @@ func TestCodexValidateKey(t *testing.T) {
 		[]string{"int", "varbinary(128)", "int"},
 		[]string{"pk1", "pk2"})
 	// validate empty key
-	newKey := validateKey(&tableInfo, "", queryServiceStats)
+	newKey := validateKey(tableInfo, "", queryServiceStats)
 	testUtils.checkEqual(t, "", newKey)
 	// validate keys that do not match number of pk columns
-	newKey = validateKey(&tableInfo, "1", queryServiceStats)
+	newKey = validateKey(tableInfo, "1", queryServiceStats)
 	testUtils.checkEqual(t, "", newKey)
-	newKey = validateKey(&tableInfo, "1.2.3", queryServiceStats)
+	newKey = validateKey(tableInfo, "1.2.3", queryServiceStats)
 	testUtils.checkEqual(t, "", newKey)
 	// validate keys with null
-	newKey = validateKey(&tableInfo, "'MQ=='.null", queryServiceStats)
+	newKey = validateKey(tableInfo, "'MQ=='.null", queryServiceStats)
 	testUtils.checkEqual(t, "", newKey)
 	// validate keys with invalid base64 encoded string
-	newKey = validateKey(&tableInfo, "'MQ==<'.2", queryServiceStats)
+	newKey = validateKey(tableInfo, "'MQ==<'.2", queryServiceStats)
 	testUtils.checkEqual(t, "", newKey)
 	// validate keys with invalid value
 	mismatchCounterBefore := queryServiceStats.InternalErrors.Counts()["Mismatch"]
-	newKey = validateKey(&tableInfo, "not_a_number.2", queryServiceStats)
+	newKey = validateKey(tableInfo, "not_a_number.2", queryServiceStats)
 	mismatchCounterAfter := queryServiceStats.InternalErrors.Counts()["Mismatch"]
 	if mismatchCounterAfter-mismatchCounterBefore != 1 {
 		t.Fatalf("Mismatch counter should increase by one. Mismatch counter before: %d, after: %d, diff: %d", mismatchCounterBefore, mismatchCounterAfter, mismatchCounterAfter-mismatchCounterBefore)
 	}
 	testUtils.checkEqual(t, "", newKey)
 	// validate valid keys
-	newKey = validateKey(&tableInfo, "1.2", queryServiceStats)
+	newKey = validateKey(tableInfo, "1.2", queryServiceStats)
 	testUtils.checkEqual(t, "1.2", newKey)
 
 }
@@ -472,7 +472,7 @@ func TestCodexUnicoded(t *testing.T) {
 }
 
 func createTableInfo(
-	name string, colNames []string, colTypes []string, pKeys []string) TableInfo {
+	name string, colNames []string, colTypes []string, pKeys []string) *TableInfo {
 	table := schema.NewTable(name)
 	for i, colName := range colNames {
 		colType := colTypes[i]
@@ -484,7 +484,7 @@ func createTableInfo(
 		}
 		table.AddColumn(colName, colType, defaultVal, "")
 	}
-	tableInfo := TableInfo{Table: table}
+	tableInfo := &TableInfo{Table: table}
 	tableInfo.SetPK(pKeys)
 	return tableInfo
 }
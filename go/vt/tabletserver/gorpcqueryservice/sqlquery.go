@@ -107,6 +107,117 @@ func (sq *SqlQuery) Rollback2(ctx context.Context, rollbackRequest *proto.Rollba
 	return tErr
 }
 
+// Prepare is exposing tabletserver.SqlQuery.Prepare
+func (sq *SqlQuery) Prepare(ctx context.Context, req *proto.DistributedTxRequest, reply *proto.DistributedTxResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	tErr := sq.server.Prepare(callinfo.RPCWrapCallInfo(ctx), req)
+	tabletserver.AddTabletErrorToDistributedTxResponse(tErr, reply)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// CommitPrepared is exposing tabletserver.SqlQuery.CommitPrepared
+func (sq *SqlQuery) CommitPrepared(ctx context.Context, req *proto.DistributedTxRequest, reply *proto.DistributedTxResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	tErr := sq.server.CommitPrepared(callinfo.RPCWrapCallInfo(ctx), req)
+	tabletserver.AddTabletErrorToDistributedTxResponse(tErr, reply)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// RollbackPrepared is exposing tabletserver.SqlQuery.RollbackPrepared
+func (sq *SqlQuery) RollbackPrepared(ctx context.Context, req *proto.DistributedTxRequest, reply *proto.DistributedTxResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	tErr := sq.server.RollbackPrepared(callinfo.RPCWrapCallInfo(ctx), req)
+	tabletserver.AddTabletErrorToDistributedTxResponse(tErr, reply)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// CreateTransaction is exposing tabletserver.SqlQuery.CreateTransaction
+func (sq *SqlQuery) CreateTransaction(ctx context.Context, req *proto.DistributedTxRequest, reply *proto.DistributedTxResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	tErr := sq.server.CreateTransaction(callinfo.RPCWrapCallInfo(ctx), req)
+	tabletserver.AddTabletErrorToDistributedTxResponse(tErr, reply)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// StartCommit is exposing tabletserver.SqlQuery.StartCommit
+func (sq *SqlQuery) StartCommit(ctx context.Context, req *proto.DistributedTxRequest, reply *proto.DistributedTxResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	tErr := sq.server.StartCommit(callinfo.RPCWrapCallInfo(ctx), req)
+	tabletserver.AddTabletErrorToDistributedTxResponse(tErr, reply)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// SetRollback is exposing tabletserver.SqlQuery.SetRollback
+func (sq *SqlQuery) SetRollback(ctx context.Context, req *proto.DistributedTxRequest, reply *proto.DistributedTxResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	tErr := sq.server.SetRollback(callinfo.RPCWrapCallInfo(ctx), req)
+	tabletserver.AddTabletErrorToDistributedTxResponse(tErr, reply)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// ConcludeTransaction is exposing tabletserver.SqlQuery.ConcludeTransaction
+func (sq *SqlQuery) ConcludeTransaction(ctx context.Context, req *proto.DistributedTxRequest, reply *proto.DistributedTxResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	tErr := sq.server.ConcludeTransaction(callinfo.RPCWrapCallInfo(ctx), req)
+	tabletserver.AddTabletErrorToDistributedTxResponse(tErr, reply)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// ReadTransaction is exposing tabletserver.SqlQuery.ReadTransaction
+func (sq *SqlQuery) ReadTransaction(ctx context.Context, req *proto.DistributedTxRequest, reply *proto.DistributedTxResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	reply.Metadata = new(proto.TransactionMetadata)
+	tErr := sq.server.ReadTransaction(callinfo.RPCWrapCallInfo(ctx), req, reply.Metadata)
+	tabletserver.AddTabletErrorToDistributedTxResponse(tErr, reply)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// MasterPosition is exposing tabletserver.SqlQuery.MasterPosition
+func (sq *SqlQuery) MasterPosition(ctx context.Context, req *proto.PositionRequest, reply *proto.PositionResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	tErr := sq.server.MasterPosition(callinfo.RPCWrapCallInfo(ctx), req, &reply.Position)
+	tabletserver.AddTabletErrorToPositionResponse(tErr, reply)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
+// WaitForPosition is exposing tabletserver.SqlQuery.WaitForPosition
+func (sq *SqlQuery) WaitForPosition(ctx context.Context, req *proto.PositionRequest, reply *proto.PositionResponse) (err error) {
+	defer sq.server.HandlePanic(&err)
+	tErr := sq.server.WaitForPosition(callinfo.RPCWrapCallInfo(ctx), req)
+	tabletserver.AddTabletErrorToPositionResponse(tErr, reply)
+	if *tabletserver.RPCErrorOnlyInReply {
+		return nil
+	}
+	return tErr
+}
+
 // Execute is exposing tabletserver.SqlQuery.Execute
 func (sq *SqlQuery) Execute(ctx context.Context, query *proto.Query, reply *mproto.QueryResult) (err error) {
 	defer sq.server.HandlePanic(&err)
@@ -404,6 +404,135 @@ func (conn *TabletBson) Rollback2(ctx context.Context, transactionID int64) erro
 	return tabletError(err)
 }
 
+// Prepare is the stub for SqlQuery.Prepare RPC
+func (conn *TabletBson) Prepare(ctx context.Context, transactionID int64, dtid string) error {
+	return conn.callDistributedTx(ctx, "SqlQuery.Prepare", &tproto.DistributedTxRequest{
+		TransactionId: transactionID,
+		Dtid:          dtid,
+	}, nil)
+}
+
+// CommitPrepared is the stub for SqlQuery.CommitPrepared RPC
+func (conn *TabletBson) CommitPrepared(ctx context.Context, dtid string) error {
+	return conn.callDistributedTx(ctx, "SqlQuery.CommitPrepared", &tproto.DistributedTxRequest{
+		Dtid: dtid,
+	}, nil)
+}
+
+// RollbackPrepared is the stub for SqlQuery.RollbackPrepared RPC
+func (conn *TabletBson) RollbackPrepared(ctx context.Context, dtid string, originalID int64) error {
+	return conn.callDistributedTx(ctx, "SqlQuery.RollbackPrepared", &tproto.DistributedTxRequest{
+		TransactionId: originalID,
+		Dtid:          dtid,
+	}, nil)
+}
+
+// CreateTransaction is the stub for SqlQuery.CreateTransaction RPC
+func (conn *TabletBson) CreateTransaction(ctx context.Context, dtid string, participants []tproto.TxParticipant) error {
+	return conn.callDistributedTx(ctx, "SqlQuery.CreateTransaction", &tproto.DistributedTxRequest{
+		Dtid:         dtid,
+		Participants: participants,
+	}, nil)
+}
+
+// StartCommit is the stub for SqlQuery.StartCommit RPC
+func (conn *TabletBson) StartCommit(ctx context.Context, transactionID int64, dtid string) error {
+	return conn.callDistributedTx(ctx, "SqlQuery.StartCommit", &tproto.DistributedTxRequest{
+		TransactionId: transactionID,
+		Dtid:          dtid,
+	}, nil)
+}
+
+// SetRollback is the stub for SqlQuery.SetRollback RPC
+func (conn *TabletBson) SetRollback(ctx context.Context, dtid string, transactionID int64) error {
+	return conn.callDistributedTx(ctx, "SqlQuery.SetRollback", &tproto.DistributedTxRequest{
+		TransactionId: transactionID,
+		Dtid:          dtid,
+	}, nil)
+}
+
+// ConcludeTransaction is the stub for SqlQuery.ConcludeTransaction RPC
+func (conn *TabletBson) ConcludeTransaction(ctx context.Context, dtid string) error {
+	return conn.callDistributedTx(ctx, "SqlQuery.ConcludeTransaction", &tproto.DistributedTxRequest{
+		Dtid: dtid,
+	}, nil)
+}
+
+// ReadTransaction is the stub for SqlQuery.ReadTransaction RPC
+func (conn *TabletBson) ReadTransaction(ctx context.Context, dtid string) (*tproto.TransactionMetadata, error) {
+	reply := new(tproto.DistributedTxResponse)
+	err := conn.callDistributedTx(ctx, "SqlQuery.ReadTransaction", &tproto.DistributedTxRequest{
+		Dtid: dtid,
+	}, reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Metadata, nil
+}
+
+// callDistributedTx sends a two-phase commit call. reply can be nil
+// if the caller doesn't need the response.
+func (conn *TabletBson) callDistributedTx(ctx context.Context, method string, req *tproto.DistributedTxRequest, reply *tproto.DistributedTxResponse) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.ConnClosed
+	}
+
+	req.SessionId = conn.sessionID
+	if reply == nil {
+		reply = new(tproto.DistributedTxResponse)
+	}
+	action := func() error {
+		err := conn.rpcClient.Call(ctx, method, req, reply)
+		if err != nil {
+			return err
+		}
+		// The call might return an application error inside the DistributedTxResponse
+		return vterrors.FromRPCError(reply.Err)
+	}
+	err := conn.withTimeout(ctx, action)
+	return tabletError(err)
+}
+
+// MasterPosition is the stub for SqlQuery.MasterPosition RPC
+func (conn *TabletBson) MasterPosition(ctx context.Context) (string, error) {
+	reply := new(tproto.PositionResponse)
+	if err := conn.callPosition(ctx, "SqlQuery.MasterPosition", &tproto.PositionRequest{}, reply); err != nil {
+		return "", err
+	}
+	return reply.Position, nil
+}
+
+// WaitForPosition is the stub for SqlQuery.WaitForPosition RPC
+func (conn *TabletBson) WaitForPosition(ctx context.Context, position string, timeout time.Duration) error {
+	return conn.callPosition(ctx, "SqlQuery.WaitForPosition", &tproto.PositionRequest{
+		Position: position,
+		Timeout:  timeout,
+	}, new(tproto.PositionResponse))
+}
+
+// callPosition sends a replication position call.
+func (conn *TabletBson) callPosition(ctx context.Context, method string, req *tproto.PositionRequest, reply *tproto.PositionResponse) error {
+	conn.mu.RLock()
+	defer conn.mu.RUnlock()
+	if conn.rpcClient == nil {
+		return tabletconn.ConnClosed
+	}
+
+	req.SessionId = conn.sessionID
+	action := func() error {
+		err := conn.rpcClient.Call(ctx, method, req, reply)
+		if err != nil {
+			return err
+		}
+		// The call might return an application error inside the PositionResponse
+		return vterrors.FromRPCError(reply.Err)
+	}
+	err := conn.withTimeout(ctx, action)
+	return tabletError(err)
+}
+
 // SplitQuery is the stub for SqlQuery.SplitQuery RPC
 func (conn *TabletBson) SplitQuery(ctx context.Context, query tproto.BoundQuery, splitCount int) (queries []tproto.QuerySplit, err error) {
 	conn.mu.RLock()
@@ -60,6 +60,8 @@ func testGoRPCTabletConn(t *testing.T, rpcOnlyInReply bool) {
 
 	// run the test suite
 	tabletconntest.TestSuite(t, client, service)
+	tabletconntest.TestTwoPCSuite(t, client, service)
+	tabletconntest.TestReadAfterWriteSuite(t, client, service)
 
 	// and clean up
 	client.Close()
@@ -26,6 +26,7 @@ import (
 
 func init() {
 	tabletconn.RegisterDialer("grpc", DialTablet)
+	tabletconn.RegisterNoTwoPC("grpc")
 }
 
 // gRPCQueryClient implements a gRPC implementation for TabletConn
@@ -241,6 +242,71 @@ func (conn *gRPCQueryClient) Rollback2(ctx context.Context, transactionID int64)
 	return conn.Rollback(ctx, transactionID)
 }
 
+// errTwoPCNotSupported is returned by the two-phase commit calls,
+// which are not part of the gRPC query service yet. vtgate and vttablet
+// refuse to enable two-phase commit with this protocol, see RegisterNoTwoPC.
+var errTwoPCNotSupported = &tabletconn.ServerError{
+	Code: tabletconn.ERR_NORMAL,
+	Err:  "vttablet: two-phase commit is not supported by the gRPC protocol",
+}
+
+// Prepare is not supported by gRPC.
+func (conn *gRPCQueryClient) Prepare(ctx context.Context, transactionID int64, dtid string) error {
+	return errTwoPCNotSupported
+}
+
+// CommitPrepared is not supported by gRPC.
+func (conn *gRPCQueryClient) CommitPrepared(ctx context.Context, dtid string) error {
+	return errTwoPCNotSupported
+}
+
+// RollbackPrepared is not supported by gRPC.
+func (conn *gRPCQueryClient) RollbackPrepared(ctx context.Context, dtid string, originalID int64) error {
+	return errTwoPCNotSupported
+}
+
+// CreateTransaction is not supported by gRPC.
+func (conn *gRPCQueryClient) CreateTransaction(ctx context.Context, dtid string, participants []tproto.TxParticipant) error {
+	return errTwoPCNotSupported
+}
+
+// StartCommit is not supported by gRPC.
+func (conn *gRPCQueryClient) StartCommit(ctx context.Context, transactionID int64, dtid string) error {
+	return errTwoPCNotSupported
+}
+
+// SetRollback is not supported by gRPC.
+func (conn *gRPCQueryClient) SetRollback(ctx context.Context, dtid string, transactionID int64) error {
+	return errTwoPCNotSupported
+}
+
+// ConcludeTransaction is not supported by gRPC.
+func (conn *gRPCQueryClient) ConcludeTransaction(ctx context.Context, dtid string) error {
+	return errTwoPCNotSupported
+}
+
+// ReadTransaction is not supported by gRPC.
+func (conn *gRPCQueryClient) ReadTransaction(ctx context.Context, dtid string) (*tproto.TransactionMetadata, error) {
+	return nil, errTwoPCNotSupported
+}
+
+// errReadAfterWriteNotSupported is returned by the replication
+// position calls, which are not part of the gRPC query service yet.
+var errReadAfterWriteNotSupported = &tabletconn.ServerError{
+	Code: tabletconn.ERR_NORMAL,
+	Err:  "vttablet: read-after-write is not supported by the gRPC protocol",
+}
+
+// MasterPosition is not supported by gRPC.
+func (conn *gRPCQueryClient) MasterPosition(ctx context.Context) (string, error) {
+	return "", errReadAfterWriteNotSupported
+}
+
+// WaitForPosition is not supported by gRPC.
+func (conn *gRPCQueryClient) WaitForPosition(ctx context.Context, position string, timeout time.Duration) error {
+	return errReadAfterWriteNotSupported
+}
+
 // SplitQuery is the stub for SqlQuery.SplitQuery RPC
 func (conn *gRPCQueryClient) SplitQuery(ctx context.Context, query tproto.BoundQuery, splitCount int) (queries []tproto.QuerySplit, err error) {
 	conn.mu.RLock()
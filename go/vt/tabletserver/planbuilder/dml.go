@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 
 	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/vt/schema"
@@ -169,6 +170,21 @@ func analyzeSelect(sel *sqlparser.Select, getTable TableGetter) (plan *ExecPlan,
 		return nil, err
 	}
 
+	// Sequences
+	if tableInfo.Type == schema.SEQUENCE {
+		count, err := analyzeNextval(sel)
+		if err != nil {
+			return nil, err
+		}
+		if count != nil {
+			return &ExecPlan{
+				PlanId:    PLAN_NEXTVAL,
+				TableName: tableInfo.Name,
+				NextCount: count,
+			}, nil
+		}
+	}
+
 	// There are bind variables in the SELECT list
 	if plan.FieldQuery == nil {
 		plan.Reason = REASON_SELECT_LIST
@@ -276,6 +292,44 @@ func analyzeSelect(sel *sqlparser.Select, getTable TableGetter) (plan *ExecPlan,
 	return plan, nil
 }
 
+// analyzeNextval returns the number of values to reserve if sel is of
+// the form "select nextval(N) from seq", where N is a number or a bind
+// variable. It returns nil if sel is any other kind of select.
+func analyzeNextval(sel *sqlparser.Select) (count interface{}, err error) {
+	if len(sel.SelectExprs) != 1 || sel.Where != nil || sel.GroupBy != nil || sel.Having != nil || sel.OrderBy != nil || sel.Limit != nil || sel.Lock != "" || sel.Distinct != "" {
+		return nil, nil
+	}
+	expr, ok := sel.SelectExprs[0].(*sqlparser.NonStarExpr)
+	if !ok {
+		return nil, nil
+	}
+	fexpr, ok := expr.Expr.(*sqlparser.FuncExpr)
+	if !ok || !strings.EqualFold(string(fexpr.Name), "nextval") {
+		return nil, nil
+	}
+	if len(fexpr.Exprs) != 1 || fexpr.Distinct {
+		return nil, errors.New("nextval expects a single argument")
+	}
+	arg, ok := fexpr.Exprs[0].(*sqlparser.NonStarExpr)
+	if !ok {
+		return nil, errors.New("nextval expects a single argument")
+	}
+	switch v := arg.Expr.(type) {
+	case sqlparser.NumVal:
+		n, err := strconv.ParseInt(string(v), 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n < 1 {
+			return nil, fmt.Errorf("nextval: invalid number of values: %d", n)
+		}
+		return n, nil
+	case sqlparser.ValArg:
+		return string(v), nil
+	}
+	return nil, fmt.Errorf("nextval: unexpected argument: %s", sqlparser.String(arg))
+}
+
 func analyzeSelectExprs(exprs sqlparser.SelectExprs, table *schema.Table) (selects []int, err error) {
 	selects = make([]int, 0, len(exprs))
 	for _, expr := range exprs {
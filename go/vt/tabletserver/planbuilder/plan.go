@@ -54,6 +54,8 @@ const (
 	PLAN_SELECT_STREAM
 	// PLAN_OTHER is for SHOW, DESCRIBE & EXPLAIN statements
 	PLAN_OTHER
+	// PLAN_NEXTVAL is for selecting the next values of a sequence
+	PLAN_NEXTVAL
 	// NumPlans stores the total number of plans
 	NumPlans
 )
@@ -73,6 +75,7 @@ var planName = []string{
 	"DDL",
 	"SELECT_STREAM",
 	"OTHER",
+	"NEXTVAL",
 }
 
 func (pt PlanType) String() string {
@@ -120,6 +123,7 @@ var tableAclRoles = map[PlanType]tableacl.Role{
 	PLAN_DDL:             tableacl.ADMIN,
 	PLAN_SELECT_STREAM:   tableacl.READER,
 	PLAN_OTHER:           tableacl.ADMIN,
+	PLAN_NEXTVAL:         tableacl.WRITER,
 }
 
 // ReasonType indicates why a query plan fails to build
@@ -217,6 +221,10 @@ type ExecPlan struct {
 	// PLAN_SET
 	SetKey   string
 	SetValue interface{}
+
+	// PLAN_NEXTVAL: number of values to reserve. It's an int64
+	// or a string containing a bind variable name.
+	NextCount interface{} `json:",omitempty"`
 }
 
 func (node *ExecPlan) setTableInfo(tableName string, getTable TableGetter) (*schema.Table, error) {
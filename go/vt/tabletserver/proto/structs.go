@@ -6,6 +6,7 @@ package proto
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/youtube/vitess/go/bytes2"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
@@ -109,7 +110,7 @@ type TransactionInfo struct {
 
 // SplitQueryRequest represents a request to split a Query into queries that
 // each return a subset of the original query.
-// SplitColumn: preferred column to split. Server will pick a random PK column
+// SplitColumn: preferred column to split. Server will split on the PK columns
 //              if this field is empty or returns an error if this field is not
 //              empty but not found in schema info or not be indexed.
 type SplitQueryRequest struct {
@@ -229,3 +230,65 @@ type StreamExecuteResponse struct {
 	// consistent with other BSON structs.
 	Err *mproto.RPCError
 }
+
+// TxParticipant is a shard that participates in a distributed transaction.
+type TxParticipant struct {
+	Keyspace string
+	Shard    string
+}
+
+// These are the states of a distributed transaction, as
+// recorded in TransactionMetadata.
+const (
+	// DTStatePrepare means that the participants are being prepared.
+	DTStatePrepare = int64(iota + 1)
+	// DTStateCommit means that the transaction was committed on the
+	// metadata manager, and the participants must commit.
+	DTStateCommit
+	// DTStateRollback means that the participants must roll back.
+	DTStateRollback
+)
+
+// TransactionMetadata is the metadata of a distributed transaction,
+// as recorded by its metadata manager.
+type TransactionMetadata struct {
+	Dtid         string
+	State        int64
+	TimeCreated  int64
+	Participants []TxParticipant
+}
+
+// DistributedTxRequest is the payload to the two-phase commit calls.
+// Each call uses only the fields it needs: Prepare and StartCommit
+// use TransactionId and Dtid, RollbackPrepared uses TransactionId
+// as the id of the original transaction, and CreateTransaction
+// uses Dtid and Participants.
+type DistributedTxRequest struct {
+	SessionId     int64
+	TransactionId int64
+	Dtid          string
+	Participants  []TxParticipant
+}
+
+// DistributedTxResponse is returned by the two-phase commit calls.
+// Metadata is only set by ReadTransaction.
+type DistributedTxResponse struct {
+	Metadata *TransactionMetadata
+	Err      *mproto.RPCError
+}
+
+// PositionRequest is the payload to MasterPosition and WaitForPosition.
+// Position and Timeout are only used by WaitForPosition: the tablet
+// waits for at most Timeout until it has replicated up to Position.
+type PositionRequest struct {
+	SessionId int64
+	Position  string
+	Timeout   time.Duration
+}
+
+// PositionResponse is returned by MasterPosition and WaitForPosition.
+// Position is only set by MasterPosition.
+type PositionResponse struct {
+	Position string
+	Err      *mproto.RPCError
+}
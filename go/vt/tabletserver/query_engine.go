@@ -44,13 +44,16 @@ type QueryEngine struct {
 	dbconfigs  *dbconfigs.DBConfigs
 
 	// Pools
-	cachePool      *CachePool
-	connPool       *ConnPool
-	streamConnPool *ConnPool
+	cachePool           *CachePool
+	connPool            *ConnPool
+	streamConnPool      *ConnPool
+	lowPriorityConnPool *ConnPool
 
 	// Services
 	txPool       *TxPool
+	twoPC        *TwoPC
 	consolidator *sync2.Consolidator
+	txSerializer *TxSerializer
 	invalidator  *RowcacheInvalidator
 	streamQList  *QueryList
 	tasks        sync.WaitGroup
@@ -64,6 +67,9 @@ type QueryEngine struct {
 	streamBufferSize sync2.AtomicInt64
 	strictTableAcl   bool
 	enableAutoCommit bool
+	// enableHotRowProtection makes the DMLs by primary key
+	// of transactions go through txSerializer.
+	enableHotRowProtection bool
 
 	// Loggers
 	accessCheckerLogger *logutil.ThrottledLogger
@@ -141,6 +147,13 @@ func NewQueryEngine(config Config) *QueryEngine {
 		config.EnablePublishStats,
 		qe.queryServiceStats,
 	)
+	qe.lowPriorityConnPool = NewConnPool(
+		config.PoolNamePrefix+"LowPriorityConnPool",
+		config.LowPriorityPoolSize,
+		time.Duration(config.IdleTimeout*1e9),
+		config.EnablePublishStats,
+		qe.queryServiceStats,
+	)
 
 	// Services
 	qe.txPool = NewTxPool(
@@ -153,8 +166,11 @@ func NewQueryEngine(config Config) *QueryEngine {
 		config.EnablePublishStats,
 		qe.queryServiceStats,
 	)
+	qe.twoPC = NewTwoPC(qe, config.TwoPCEnable, time.Duration(config.TwoPCAbandonAge*1e9))
 	qe.consolidator = sync2.NewConsolidator()
 	http.Handle(config.DebugURLPrefix+"/consolidations", qe.consolidator)
+	qe.txSerializer = NewTxSerializer(config.HotRowProtectionMaxQueueSize, config.HotRowProtectionConcurrentTransactions, config.StatsPrefix, config.EnablePublishStats)
+	http.Handle(config.DebugURLPrefix+"/hotrows", qe.txSerializer)
 	qe.invalidator = NewRowcacheInvalidator(config.StatsPrefix, qe, config.EnablePublishStats)
 	qe.streamQList = NewQueryList()
 
@@ -165,6 +181,7 @@ func NewQueryEngine(config Config) *QueryEngine {
 		qe.strictMode.Set(1)
 	}
 	qe.strictTableAcl = config.StrictTableAcl
+	qe.enableHotRowProtection = config.EnableHotRowProtection
 	qe.maxResultSize = sync2.AtomicInt64(config.MaxResultSize)
 	qe.maxDMLRows = sync2.AtomicInt64(config.MaxDMLRows)
 	qe.streamBufferSize = sync2.AtomicInt64(config.StreamBufferSize)
@@ -228,7 +245,9 @@ func (qe *QueryEngine) Open(dbconfigs *dbconfigs.DBConfigs, schemaOverrides []Sc
 	}
 	qe.connPool.Open(&appParams, &dbaParams)
 	qe.streamConnPool.Open(&appParams, &dbaParams)
+	qe.lowPriorityConnPool.Open(&appParams, &dbaParams)
 	qe.txPool.Open(&appParams, &dbaParams)
+	qe.twoPC.Open(&dbaParams)
 }
 
 // Launch launches the specified function inside a goroutine.
@@ -277,7 +296,9 @@ func (qe *QueryEngine) WaitForTxEmpty() {
 func (qe *QueryEngine) Close() {
 	qe.tasks.Wait()
 	// Close in reverse order of Open.
+	qe.twoPC.Close()
 	qe.txPool.Close()
+	qe.lowPriorityConnPool.Close()
 	qe.streamConnPool.Close()
 	qe.connPool.Close()
 	qe.invalidator.Close()
@@ -289,6 +310,15 @@ func (qe *QueryEngine) Close() {
 // Commit commits the specified transaction.
 func (qe *QueryEngine) Commit(ctx context.Context, logStats *SQLQueryStats, transactionID int64) {
 	dirtyTables, err := qe.txPool.SafeCommit(ctx, transactionID)
+	qe.invalidateRows(logStats, dirtyTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// invalidateRows deletes the rowcache entries of the
+// rows changed by a committed transaction.
+func (qe *QueryEngine) invalidateRows(logStats *SQLQueryStats, dirtyTables map[string]DirtyKeys) {
 	for tableName, invalidList := range dirtyTables {
 		tableInfo := qe.schemaInfo.GetTable(tableName)
 		if tableInfo == nil {
@@ -304,7 +334,4 @@ func (qe *QueryEngine) Commit(ctx context.Context, logStats *SQLQueryStats, tran
 		logStats.CacheInvalidations += invalidations
 		tableInfo.invalidations.Add(invalidations)
 	}
-	if err != nil {
-		panic(err)
-	}
 }
@@ -6,6 +6,8 @@ package tabletserver
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 	"time"
 
 	log "github.com/golang/glog"
@@ -13,6 +15,7 @@ import (
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/vt/callinfo"
+	"github.com/youtube/vitess/go/vt/queryrules"
 	"github.com/youtube/vitess/go/vt/schema"
 	"github.com/youtube/vitess/go/vt/sqlparser"
 	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
@@ -28,6 +31,9 @@ type QueryExecutor struct {
 	ctx           context.Context
 	logStats      *SQLQueryStats
 	qe            *QueryEngine
+
+	// limits are set by the query rules that degrade the query.
+	limits queryrules.Limits
 }
 
 // poolConn is the interface implemented by users of this specialized pool.
@@ -56,36 +62,53 @@ func (qre *QueryExecutor) Execute() (reply *mproto.QueryResult) {
 	}(time.Now())
 
 	qre.checkPermissions()
+	cancel := qre.applyRuleTimeout()
+	defer cancel()
 
 	if qre.plan.PlanId == planbuilder.PLAN_DDL {
 		return qre.execDDL()
 	}
 
+	// Sequences use their own transaction.
+	if qre.plan.PlanId == planbuilder.PLAN_NEXTVAL {
+		return qre.execNextval()
+	}
+
 	if qre.transactionID != 0 {
 		// Need upfront connection for DMLs and transactions
 		conn := qre.qe.txPool.Get(qre.transactionID)
 		defer conn.Recycle()
+		if qre.plan.PlanId == planbuilder.PLAN_DML_PK && qre.qe.enableHotRowProtection {
+			qre.serializeDMLPK(conn)
+		}
 		conn.RecordQuery(qre.query)
 		var invalidator CacheInvalidator
 		if qre.plan.TableInfo != nil && qre.plan.TableInfo.CacheType != schema.CACHE_NONE {
 			invalidator = conn.DirtyKeys(qre.plan.TableName)
 		}
+		// With two-phase commit, the DMLs and the other statements
+		// that may change data are recorded, so they can be replayed
+		// if the transaction gets prepared.
+		var dmlConn poolConn = conn
+		if qre.qe.twoPC.enabled {
+			dmlConn = redoConn{conn}
+		}
 		switch qre.plan.PlanId {
 		case planbuilder.PLAN_PASS_DML:
 			if qre.qe.strictMode.Get() != 0 {
 				panic(NewTabletError(ErrFail, "DML too complex"))
 			}
-			reply = qre.directFetch(conn, qre.plan.FullQuery, qre.bindVars, nil)
+			reply = qre.directFetch(dmlConn, qre.plan.FullQuery, qre.bindVars, nil)
 		case planbuilder.PLAN_INSERT_PK:
-			reply = qre.execInsertPK(conn)
+			reply = qre.execInsertPK(dmlConn)
 		case planbuilder.PLAN_INSERT_SUBQUERY:
-			reply = qre.execInsertSubquery(conn)
+			reply = qre.execInsertSubquery(dmlConn)
 		case planbuilder.PLAN_DML_PK:
-			reply = qre.execDMLPK(conn, invalidator)
+			reply = qre.execDMLPK(dmlConn, invalidator)
 		case planbuilder.PLAN_DML_SUBQUERY:
-			reply = qre.execDMLSubquery(conn, invalidator)
+			reply = qre.execDMLSubquery(dmlConn, invalidator)
 		case planbuilder.PLAN_OTHER:
-			reply = qre.execSQL(conn, qre.query, true)
+			reply = qre.execSQL(dmlConn, qre.query, true)
 		default: // select or set in a transaction, just count as select
 			reply = qre.execDirect(conn)
 		}
@@ -103,7 +126,7 @@ func (qre *QueryExecutor) Execute() (reply *mproto.QueryResult) {
 		case planbuilder.PLAN_SET:
 			reply = qre.execSet()
 		case planbuilder.PLAN_OTHER:
-			conn := qre.getConn(qre.qe.connPool)
+			conn := qre.getConn(qre.connPool())
 			defer conn.Recycle()
 			reply = qre.execSQL(conn, qre.query, true)
 		default:
@@ -113,6 +136,9 @@ func (qre *QueryExecutor) Execute() (reply *mproto.QueryResult) {
 			reply = qre.execDmlAutoCommit()
 		}
 	}
+	if reply != nil {
+		qre.truncate(reply)
+	}
 	return reply
 }
 
@@ -123,6 +149,8 @@ func (qre *QueryExecutor) Stream(sendReply func(*mproto.QueryResult) error) {
 	defer qre.qe.queryServiceStats.QueryStats.Record(qre.plan.PlanId.String(), time.Now())
 
 	qre.checkPermissions()
+	cancel := qre.applyRuleTimeout()
+	defer cancel()
 
 	conn := qre.getConn(qre.qe.streamConnPool)
 	defer conn.Recycle()
@@ -188,13 +216,18 @@ func (qre *QueryExecutor) checkPermissions() {
 		remoteAddr = ci.RemoteAddr()
 		username = ci.Username()
 	}
-	action, desc := qre.plan.Rules.getAction(remoteAddr, username, qre.bindVars)
+	action, qr, limits := qre.plan.Rules.GetAction(remoteAddr, username, qre.bindVars)
 	switch action {
-	case QR_FAIL:
-		panic(NewTabletError(ErrFail, "Query disallowed due to rule: %s", desc))
-	case QR_FAIL_RETRY:
-		panic(NewTabletError(ErrRetry, "Query disallowed due to rule: %s", desc))
+	case queryrules.QR_FAIL:
+		panic(NewTabletError(ErrFail, "Query disallowed due to rule: %s", qr.Description))
+	case queryrules.QR_FAIL_RETRY:
+		panic(NewTabletError(ErrRetry, "Query disallowed due to rule: %s", qr.Description))
+	case queryrules.QR_THROTTLE:
+		// Like an overloaded pool, a throttled query tells the
+		// client to back off rather than that it's disallowed.
+		panic(NewTabletError(ErrTxPoolFull, "Query throttled due to rule: %s", qr.Description))
 	}
+	qre.limits = limits
 
 	// Perform table ACL check if it is enabled
 	if qre.plan.Authorized != nil && !qre.plan.Authorized.IsMember(username) {
@@ -207,6 +240,52 @@ func (qre *QueryExecutor) checkPermissions() {
 	}
 }
 
+// applyRuleTimeout shortens the context of the query to the
+// timeout of its QR_LIMIT rules. The returned function must be
+// called once the query is done.
+func (qre *QueryExecutor) applyRuleTimeout() context.CancelFunc {
+	if qre.limits.Timeout == 0 {
+		return func() {}
+	}
+	var cancel context.CancelFunc
+	qre.ctx, cancel = context.WithTimeout(qre.ctx, qre.limits.Timeout)
+	return cancel
+}
+
+// connPool returns the pool of the queries outside transactions:
+// the low priority one if a QR_LOW_PRIORITY rule matched the query.
+func (qre *QueryExecutor) connPool() *ConnPool {
+	if qre.limits.LowPriority {
+		return qre.qe.lowPriorityConnPool
+	}
+	return qre.qe.connPool
+}
+
+// maxRows returns the number of rows the QR_LIMIT rules of the
+// query truncate its result to, or 0 if they don't cap it below
+// the max result size. Only selects are capped: DMLs and their
+// subqueries must see all the rows they change.
+func (qre *QueryExecutor) maxRows() int64 {
+	if !qre.plan.PlanId.IsSelect() {
+		return 0
+	}
+	if qre.limits.MaxRows != 0 && qre.limits.MaxRows < qre.qe.maxResultSize.Get() {
+		return qre.limits.MaxRows
+	}
+	return 0
+}
+
+// truncate drops the rows of the final result of a select beyond
+// the cap of the QR_LIMIT rules of the query.
+func (qre *QueryExecutor) truncate(result *mproto.QueryResult) {
+	maxRows := qre.maxRows()
+	if maxRows == 0 || int64(len(result.Rows)) <= maxRows {
+		return
+	}
+	result.Rows = result.Rows[:maxRows]
+	result.RowsAffected = uint64(maxRows)
+}
+
 func (qre *QueryExecutor) execDDL() *mproto.QueryResult {
 	ddlPlan := planbuilder.DDLParse(qre.query)
 	if ddlPlan.Action == "" {
@@ -231,6 +310,83 @@ func (qre *QueryExecutor) execDDL() *mproto.QueryResult {
 	return result
 }
 
+var nextvalFields = []mproto.Field{{Name: "nextval", Type: mproto.VT_LONGLONG}}
+
+// execNextval reserves the requested number of values from a sequence,
+// and returns the first one. Values are served from memory. When they
+// run out, a block of values is reserved by advancing next_id in the
+// sequence table, in multiples of its cache column.
+func (qre *QueryExecutor) execNextval() (result *mproto.QueryResult) {
+	inc := getLimit(qre.plan.NextCount, qre.bindVars)
+	if inc < 1 {
+		panic(NewTabletError(ErrFail, "invalid increment for sequence %s: %d", qre.plan.TableName, inc))
+	}
+	t := qre.plan.TableInfo
+	t.Seq.Lock()
+	defer t.Seq.Unlock()
+	if t.NextVal == 0 || t.NextVal+inc > t.LastVal {
+		qre.reserveSequence(t, inc)
+	}
+	ret := t.NextVal
+	t.NextVal += inc
+	return &mproto.QueryResult{
+		Fields:       nextvalFields,
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeNumeric(strconv.AppendInt(nil, ret, 10)),
+		}},
+	}
+}
+
+// reserveSequence reserves enough values from the sequence table
+// for the next inc values. It must be called with t.Seq locked.
+func (qre *QueryExecutor) reserveSequence(t *TableInfo, inc int64) {
+	transactionID := qre.qe.txPool.Begin(qre.ctx)
+	defer func() {
+		if err := recover(); err != nil {
+			qre.qe.txPool.Rollback(qre.ctx, transactionID)
+			panic(err)
+		}
+		qre.qe.Commit(qre.ctx, qre.logStats, transactionID)
+	}()
+	conn := qre.qe.txPool.Get(transactionID)
+	defer conn.Recycle()
+
+	query := fmt.Sprintf("select next_id, cache from `%s` where id = 0 for update", t.Name)
+	qr := qre.execSQL(conn, query, false)
+	if len(qr.Rows) != 1 {
+		panic(NewTabletError(ErrFail, "unexpected rows from reading sequence %s: %d", t.Name, len(qr.Rows)))
+	}
+	nextID, err := qr.Rows[0][0].ParseInt64()
+	if err != nil {
+		panic(NewTabletError(ErrFail, "error loading sequence %s: %v", t.Name, err))
+	}
+	cache, err := qr.Rows[0][1].ParseInt64()
+	if err != nil {
+		panic(NewTabletError(ErrFail, "error loading sequence %s: %v", t.Name, err))
+	}
+	if cache < 1 {
+		panic(NewTabletError(ErrFail, "invalid cache value for sequence %s: %d", t.Name, cache))
+	}
+	// next_id differs from LastVal if the sequence is
+	// being loaded, or if it was changed by someone else.
+	if t.LastVal != nextID {
+		if nextID < t.LastVal {
+			log.Warningf("Sequence %s next_id %d is below the reserved %d, using %d", t.Name, nextID, t.LastVal, t.LastVal)
+			nextID = t.LastVal
+		} else {
+			t.NextVal = nextID
+		}
+	}
+	newLast := nextID + cache
+	for newLast < t.NextVal+inc {
+		newLast += cache
+	}
+	query = fmt.Sprintf("update `%s` set next_id = %d where id = 0", t.Name, newLast)
+	qre.execSQL(conn, query, false)
+	t.LastVal = newLast
+}
+
 func (qre *QueryExecutor) execPKIN() (result *mproto.QueryResult) {
 	pkRows, err := buildValueList(qre.plan.TableInfo, qre.plan.PKValues, qre.bindVars)
 	if err != nil {
@@ -365,7 +521,7 @@ func (qre *QueryExecutor) execSelect() (result *mproto.QueryResult) {
 		result.Fields = qre.plan.Fields
 		return
 	}
-	conn := qre.getConn(qre.qe.connPool)
+	conn := qre.getConn(qre.connPool())
 	defer conn.Recycle()
 	return qre.fullFetch(conn, qre.plan.FullQuery, qre.bindVars, nil)
 }
@@ -379,7 +535,7 @@ func (qre *QueryExecutor) execInsertPK(conn poolConn) (result *mproto.QueryResul
 }
 
 func (qre *QueryExecutor) execInsertSubquery(conn poolConn) (result *mproto.QueryResult) {
-	innerResult := qre.directFetch(conn, qre.plan.Subquery, qre.bindVars, nil)
+	innerResult := qre.directFetch(readConn(conn), qre.plan.Subquery, qre.bindVars, nil)
 	innerRows := innerResult.Rows
 	if len(innerRows) == 0 {
 		return &mproto.QueryResult{RowsAffected: 0}
@@ -418,8 +574,60 @@ func (qre *QueryExecutor) execDMLPK(conn poolConn, invalidator CacheInvalidator)
 	return qre.execDMLPKRows(conn, pkRows, invalidator)
 }
 
+// serializeDMLPK waits until the transaction of conn may update the
+// rows of the DML, if other transactions are already updating them.
+// The rows are held until the transaction ends. The wait can't outlast
+// the transaction timeout. A transaction that didn't execute anything
+// yet doesn't hold its MySQL connection while it waits.
+func (qre *QueryExecutor) serializeDMLPK(conn *TxConnection) {
+	pkRows, err := buildValueList(qre.plan.TableInfo, qre.plan.PKValues, qre.bindVars)
+	if err != nil {
+		panic(err)
+	}
+	keys := make([]string, 0, len(pkRows))
+	for _, pk := range pkRows {
+		if key := buildKey(pk); key != "" {
+			keys = append(keys, qre.plan.TableName+"."+key)
+		}
+	}
+	// Waiting for the rows in a fixed order prevents two
+	// statements that update the same rows from deadlocking.
+	sort.Strings(keys)
+	ctx, cancel := context.WithDeadline(qre.ctx, conn.StartTime.Add(qre.qe.txPool.Timeout()))
+	defer cancel()
+	// If the wait fails after the connection was released,
+	// the transaction ends when conn is recycled.
+	released := false
+	beforeWait := func() error {
+		if released || len(conn.Queries) != 0 {
+			return nil
+		}
+		if err := conn.releaseConn(ctx); err != nil {
+			return err
+		}
+		released = true
+		return nil
+	}
+	for _, key := range keys {
+		if _, ok := conn.serializedRows[key]; ok {
+			continue
+		}
+		done, err := qre.qe.txSerializer.Wait(ctx, conn.TransactionID, qre.plan.TableName, key, beforeWait)
+		if err != nil {
+			panic(err)
+		}
+		if conn.serializedRows == nil {
+			conn.serializedRows = make(map[string]func())
+		}
+		conn.serializedRows[key] = done
+	}
+	if released {
+		conn.reacquireConn(qre.ctx)
+	}
+}
+
 func (qre *QueryExecutor) execDMLSubquery(conn poolConn, invalidator CacheInvalidator) (result *mproto.QueryResult) {
-	innerResult := qre.directFetch(conn, qre.plan.Subquery, qre.bindVars, nil)
+	innerResult := qre.directFetch(readConn(conn), qre.plan.Subquery, qre.bindVars, nil)
 	return qre.execDMLPKRows(conn, innerResult.Rows, invalidator)
 }
 
@@ -469,6 +677,8 @@ func (qre *QueryExecutor) execSet() (result *mproto.QueryResult) {
 		qre.qe.connPool.SetCapacity(int(getInt64(qre.plan.SetValue)))
 	case "vt_stream_pool_size":
 		qre.qe.streamConnPool.SetCapacity(int(getInt64(qre.plan.SetValue)))
+	case "vt_low_priority_pool_size":
+		qre.qe.lowPriorityConnPool.SetCapacity(int(getInt64(qre.plan.SetValue)))
 	case "vt_transaction_cap":
 		qre.qe.txPool.pool.SetCapacity(int(getInt64(qre.plan.SetValue)))
 	case "vt_transaction_timeout":
@@ -501,6 +711,7 @@ func (qre *QueryExecutor) execSet() (result *mproto.QueryResult) {
 		t := getDuration(qre.plan.SetValue)
 		qre.qe.connPool.SetIdleTimeout(t)
 		qre.qe.streamConnPool.SetIdleTimeout(t)
+		qre.qe.lowPriorityConnPool.SetIdleTimeout(t)
 		qre.qe.txPool.pool.SetIdleTimeout(t)
 	case "vt_spot_check_ratio":
 		qre.qe.spotCheckFreq.Set(int64(getFloat64(qre.plan.SetValue) * spotCheckMultiplier))
@@ -510,7 +721,7 @@ func (qre *QueryExecutor) execSet() (result *mproto.QueryResult) {
 		t := getDuration(qre.plan.SetValue)
 		qre.qe.txPool.SetPoolTimeout(t)
 	default:
-		conn := qre.getConn(qre.qe.connPool)
+		conn := qre.getConn(qre.connPool())
 		defer conn.Recycle()
 		return qre.directFetch(conn, qre.plan.FullQuery, qre.bindVars, nil)
 	}
@@ -568,11 +779,16 @@ func (qre *QueryExecutor) getConn(pool *ConnPool) *DBConn {
 
 func (qre *QueryExecutor) qFetch(logStats *SQLQueryStats, parsedQuery *sqlparser.ParsedQuery, bindVars map[string]interface{}) (result *mproto.QueryResult) {
 	sql := qre.generateFinalSql(parsedQuery, bindVars, nil)
-	q, ok := qre.qe.consolidator.Create(string(sql))
+	key := sql
+	if maxRows := qre.maxRows(); maxRows != 0 {
+		// The result of a capped query can't be shared with the others.
+		key = fmt.Sprintf("%s /* maxrows %d */", sql, maxRows)
+	}
+	q, ok := qre.qe.consolidator.Create(key)
 	if ok {
 		defer q.Broadcast()
 		waitingForConnectionStart := time.Now()
-		conn, err := qre.qe.connPool.Get(qre.ctx)
+		conn, err := qre.connPool().Get(qre.ctx)
 		logStats.WaitingForConnection += time.Now().Sub(waitingForConnectionStart)
 		if err != nil {
 			q.Err = NewTabletErrorSql(ErrFatal, err)
@@ -609,7 +825,11 @@ func (qre *QueryExecutor) fullStreamFetch(conn *DBConn, parsedQuery *sqlparser.P
 }
 
 func (qre *QueryExecutor) generateFinalSql(parsedQuery *sqlparser.ParsedQuery, bindVars map[string]interface{}, buildStreamComment []byte) string {
-	bindVars["#maxLimit"] = qre.qe.maxResultSize.Get() + 1
+	if maxRows := qre.maxRows(); maxRows != 0 {
+		bindVars["#maxLimit"] = maxRows
+	} else {
+		bindVars["#maxLimit"] = qre.qe.maxResultSize.Get() + 1
+	}
 	sql, err := parsedQuery.GenerateQuery(bindVars)
 	if err != nil {
 		panic(NewTabletError(ErrFail, "%s", err))
@@ -632,7 +852,11 @@ func (qre *QueryExecutor) execSQL(conn poolConn, sql string, wantfields bool) *m
 
 func (qre *QueryExecutor) execSQLNoPanic(conn poolConn, sql string, wantfields bool) (*mproto.QueryResult, error) {
 	defer qre.logStats.AddRewrittenSql(sql, time.Now())
-	return conn.Exec(qre.ctx, sql, int(qre.qe.maxResultSize.Get()), wantfields)
+	result, err := conn.Exec(qre.ctx, sql, int(qre.qe.maxResultSize.Get()), wantfields)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
 func (qre *QueryExecutor) execStreamSQL(conn *DBConn, sql string, callback func(*mproto.QueryResult) error) {
@@ -14,6 +14,7 @@ import (
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/vt/callinfo"
+	"github.com/youtube/vitess/go/vt/queryrules"
 	"github.com/youtube/vitess/go/vt/tableacl"
 	"github.com/youtube/vitess/go/vt/tableacl/simpleacl"
 	"github.com/youtube/vitess/go/vt/tabletserver/fakecacheservice"
@@ -204,6 +205,119 @@ func TestQueryExecutorPlanDmlPk(t *testing.T) {
 	testUtils.checkEqual(t, expected, qre.Execute())
 }
 
+func TestQueryExecutorPlanDmlPkHotRowProtection(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	testUtils := &testUtils{}
+	query := "update test_table set name = 2 where pk in (1) /* _stream test_table (pk ) (1 ); */"
+	// expected.Rows is always nil, intended
+	expected := &mproto.QueryResult{}
+	db.AddQuery(query, expected)
+
+	qre, sqlQuery := newTestQueryExecutor(
+		query, context.Background(), enableRowCache|enableTx|enableStrict|enableHotRowProtection)
+	defer sqlQuery.disallowQueries()
+	checkPlanID(t, planbuilder.PLAN_DML_PK, qre.plan.PlanId)
+	testUtils.checkEqual(t, expected, qre.Execute())
+	// the row is held until the transaction ends
+	testUtils.checkEqual(t, expected, qre.Execute())
+	want := map[string]int{"test_table.1": 1}
+	if got := sqlQuery.qe.txSerializer.queueSizes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("queueSizes: %v, want %v", got, want)
+	}
+	testCommitHelper(t, sqlQuery, qre)
+	if got := sqlQuery.qe.txSerializer.queueSizes(); len(got) != 0 {
+		t.Errorf("queueSizes after commit: %v, want empty", got)
+	}
+}
+
+func TestQueryExecutorPlanDmlPkHotRowDeadlock(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	update1 := "update test_table set name = 2 where pk in (1) /* _stream test_table (pk ) (1 ); */"
+	update2 := "update test_table set name = 2 where pk in (2) /* _stream test_table (pk ) (2 ); */"
+	db.AddQuery(update1, &mproto.QueryResult{})
+	db.AddQuery(update2, &mproto.QueryResult{})
+
+	qre, sqlQuery := newTestQueryExecutor(
+		update1, context.Background(), enableRowCache|enableTx|enableStrict|enableHotRowProtection)
+	defer sqlQuery.disallowQueries()
+	tx1 := qre.transactionID
+	tx2 := beginTestTransaction(t, sqlQuery)
+	qre.Execute()
+	newTestTxQueryExecutor(sqlQuery, update2, tx2).Execute()
+
+	// tx1 waits for the row of tx2
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		defer func() {
+			if x := recover(); x != nil {
+				t.Errorf("update of tx1: %v", x)
+			}
+		}()
+		newTestTxQueryExecutor(sqlQuery, update2, tx1).Execute()
+	}()
+	for sqlQuery.qe.txSerializer.waits.Counts()["test_table"] != 1 {
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	// tx2 fails right away instead of waiting for the row of tx1
+	func() {
+		defer handleAndVerifyTabletError(t, "update of tx2 should deadlock", ErrFail)
+		newTestTxQueryExecutor(sqlQuery, update1, tx2).Execute()
+	}()
+	session := proto.Session{SessionId: sqlQuery.sessionID, TransactionId: tx2}
+	if err := sqlQuery.Rollback(context.Background(), &session); err != nil {
+		t.Fatalf("failed to roll back transaction: %d, err: %v", tx2, err)
+	}
+	<-waited
+	testCommitHelper(t, sqlQuery, qre)
+	if got := sqlQuery.qe.txSerializer.queueSizes(); len(got) != 0 {
+		t.Errorf("queueSizes after commit: %v, want empty", got)
+	}
+}
+
+func TestQueryExecutorPlanDmlPkHotRowReleaseConn(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	query := "update test_table set name = 2 where pk in (1) /* _stream test_table (pk ) (1 ); */"
+	db.AddQuery(query, &mproto.QueryResult{})
+
+	qre, sqlQuery := newTestQueryExecutor(
+		query, context.Background(), enableRowCache|enableTx|enableStrict|enableHotRowProtection)
+	defer sqlQuery.disallowQueries()
+	qre.Execute()
+	tx2 := beginTestTransaction(t, sqlQuery)
+	available := sqlQuery.qe.txPool.pool.Available()
+
+	// tx2 didn't execute anything: it waits without its connection
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		defer func() {
+			if x := recover(); x != nil {
+				t.Errorf("update of tx2: %v", x)
+			}
+		}()
+		newTestTxQueryExecutor(sqlQuery, query, tx2).Execute()
+	}()
+	for sqlQuery.qe.txPool.pool.Available() != available+1 {
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	// tx2 got a connection back once tx1 committed
+	testCommitHelper(t, sqlQuery, qre)
+	<-waited
+	if got := sqlQuery.qe.txPool.pool.Available(); got != available+1 {
+		t.Errorf("available connections: %d, want %d", got, available+1)
+	}
+	session := proto.Session{SessionId: sqlQuery.sessionID, TransactionId: tx2}
+	if err := sqlQuery.Commit(context.Background(), &session); err != nil {
+		t.Fatalf("failed to commit transaction: %d, err: %v", tx2, err)
+	}
+	if got := sqlQuery.qe.txPool.pool.Available(); got != available+2 {
+		t.Errorf("available connections after commit: %d, want %d", got, available+2)
+	}
+}
+
 func TestQueryExecutorPlanDmlAutoCommit(t *testing.T) {
 	db := setUpQueryExecutorTest()
 	testUtils := &testUtils{}
@@ -683,6 +797,68 @@ func TestQueryExecutorPlanOther(t *testing.T) {
 	testUtils.checkEqual(t, expected, qre.Execute())
 }
 
+func TestQueryExecutorPlanNextval(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	testUtils := &testUtils{}
+	selQuery := "select next_id, cache from `seq` where id = 0 for update"
+	db.AddQuery(selQuery, &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{Name: "next_id", Type: mproto.VT_LONGLONG},
+			{Name: "cache", Type: mproto.VT_LONGLONG},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeNumeric([]byte("1")),
+			sqltypes.MakeNumeric([]byte("3")),
+		}},
+	})
+	updateQuery := "update `seq` set next_id = 4 where id = 0"
+	db.AddQuery(updateQuery, &mproto.QueryResult{})
+	qre, sqlQuery := newTestQueryExecutor("select nextval(:n) from seq", context.Background(), enableStrict)
+	defer sqlQuery.disallowQueries()
+	checkPlanID(t, planbuilder.PLAN_NEXTVAL, qre.plan.PlanId)
+
+	want := func(val string) *mproto.QueryResult {
+		return &mproto.QueryResult{
+			Fields:       []mproto.Field{{Name: "nextval", Type: mproto.VT_LONGLONG}},
+			RowsAffected: 1,
+			Rows:         [][]sqltypes.Value{{sqltypes.MakeNumeric([]byte(val))}},
+		}
+	}
+	qre.bindVars["n"] = 1
+	testUtils.checkEqual(t, want("1"), qre.Execute())
+	if got := db.GetQueryCalledNum(updateQuery); got != 1 {
+		t.Errorf("update was called %d times, want 1", got)
+	}
+
+	// The next two values are served from the cache.
+	qre.bindVars["n"] = 2
+	testUtils.checkEqual(t, want("2"), qre.Execute())
+	if got := db.GetQueryCalledNum(updateQuery); got != 1 {
+		t.Errorf("update was called %d times, want 1", got)
+	}
+
+	// Reserving 5 more values needs two blocks of 3.
+	db.AddQuery(selQuery, &mproto.QueryResult{
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeNumeric([]byte("4")),
+			sqltypes.MakeNumeric([]byte("3")),
+		}},
+	})
+	updateQuery = "update `seq` set next_id = 10 where id = 0"
+	db.AddQuery(updateQuery, &mproto.QueryResult{})
+	qre.bindVars["n"] = 5
+	testUtils.checkEqual(t, want("4"), qre.Execute())
+	if got := db.GetQueryCalledNum(updateQuery); got != 1 {
+		t.Errorf("update was called %d times, want 1", got)
+	}
+
+	qre.bindVars["n"] = 0
+	defer handleAndVerifyTabletError(t, "nextval with increment 0 should fail", ErrFail)
+	qre.Execute()
+}
+
 func TestQueryExecutorTableAcl(t *testing.T) {
 	testUtils := &testUtils{}
 	aclName := fmt.Sprintf("simpleacl-test-%d", rand.Int63())
@@ -753,11 +929,11 @@ func TestQueryExecutorBlacklistQRFail(t *testing.T) {
 	bannedAddr := "127.0.0.1"
 	bannedUser := "u2"
 
-	alterRule := NewQueryRule("disable update", "disable update", QR_FAIL)
+	alterRule := queryrules.NewQueryRule("disable update", "disable update", queryrules.QR_FAIL)
 	alterRule.SetIPCond(bannedAddr)
 	alterRule.SetUserCond(bannedUser)
 	alterRule.SetQueryCond("select.*")
-	alterRule.AddPlanCond(planbuilder.PLAN_SELECT_SUBQUERY)
+	alterRule.AddPlanCond(int(planbuilder.PLAN_SELECT_SUBQUERY))
 	alterRule.AddTableCond("test_table")
 
 	rulesName := "blacklistedRulesQRFail"
@@ -801,11 +977,11 @@ func TestQueryExecutorBlacklistQRRetry(t *testing.T) {
 	bannedAddr := "127.0.0.1"
 	bannedUser := "x"
 
-	alterRule := NewQueryRule("disable update", "disable update", QR_FAIL_RETRY)
+	alterRule := queryrules.NewQueryRule("disable update", "disable update", queryrules.QR_FAIL_RETRY)
 	alterRule.SetIPCond(bannedAddr)
 	alterRule.SetUserCond(bannedUser)
 	alterRule.SetQueryCond("select.*")
-	alterRule.AddPlanCond(planbuilder.PLAN_SELECT_SUBQUERY)
+	alterRule.AddPlanCond(int(planbuilder.PLAN_SELECT_SUBQUERY))
 	alterRule.AddTableCond("test_table")
 
 	rulesName := "blacklistedRulesQRRetry"
@@ -832,6 +1008,167 @@ func TestQueryExecutorBlacklistQRRetry(t *testing.T) {
 	qre.Execute()
 }
 
+func TestQueryExecutorQRThrottle(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	query := "select * from test_table limit 1000"
+	db.AddQuery(query, &mproto.QueryResult{Fields: getTestTableFields()})
+	db.AddQuery("select * from test_table where 1 != 1", &mproto.QueryResult{
+		Fields: getTestTableFields(),
+	})
+
+	throttleRule := queryrules.NewQueryRule("throttle select", "throttle select", queryrules.QR_THROTTLE)
+	throttleRule.AddPlanCond(int(planbuilder.PLAN_PASS_SELECT))
+	if err := throttleRule.SetMaxQPS(1); err != nil {
+		t.Fatalf("SetMaxQPS: %v", err)
+	}
+	setTestQueryRules(t, "throttledRulesQRThrottle", throttleRule)
+	defer QueryRuleSources.UnRegisterQueryRuleSource("throttledRulesQRThrottle")
+
+	ctx := callinfo.NewContext(context.Background(), &fakeCallInfo{remoteAddr: "127.0.0.1", username: "u1"})
+	qre, sqlQuery := newTestQueryExecutor(query, ctx, enableStrict)
+	defer sqlQuery.disallowQueries()
+	checkPlanID(t, planbuilder.PLAN_PASS_SELECT, qre.plan.PlanId)
+	// the first query is within the rate
+	qre.Execute()
+	defer handleAndVerifyTabletError(t, "execute should fail because query has been throttled", ErrTxPoolFull)
+	qre.Execute()
+}
+
+func TestQueryExecutorQRLimit(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	testUtils := &testUtils{}
+	row := []sqltypes.Value{sqltypes.MakeNumeric([]byte("1")), sqltypes.MakeNumeric([]byte("1")), sqltypes.MakeNumeric([]byte("1"))}
+	// the limit of the query is the capped max result size
+	db.AddQuery("select * from test_table limit 2", &mproto.QueryResult{
+		Fields:       getTestTableFields(),
+		Rows:         [][]sqltypes.Value{row, row},
+		RowsAffected: 2,
+	})
+	// a query with its own limit is truncated
+	db.AddQuery("select * from test_table limit 100", &mproto.QueryResult{
+		Fields:       getTestTableFields(),
+		Rows:         [][]sqltypes.Value{row, row, row},
+		RowsAffected: 3,
+	})
+	db.AddQuery("select * from test_table where 1 != 1", &mproto.QueryResult{
+		Fields: getTestTableFields(),
+	})
+
+	limitRule := queryrules.NewQueryRule("limit select", "limit select", queryrules.QR_LIMIT)
+	if err := limitRule.SetLimits(5, 0); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+	strictRule := queryrules.NewQueryRule("limit select more", "limit select more", queryrules.QR_LIMIT)
+	strictRule.AddTableCond("test_table")
+	if err := strictRule.SetLimits(2, 10*time.Second); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+	setTestQueryRules(t, "limitedRulesQRLimit", limitRule, strictRule)
+	defer QueryRuleSources.UnRegisterQueryRuleSource("limitedRulesQRLimit")
+
+	want := &mproto.QueryResult{
+		Fields:       getTestTableFields(),
+		Rows:         [][]sqltypes.Value{row, row},
+		RowsAffected: 2,
+	}
+	ctx := callinfo.NewContext(context.Background(), &fakeCallInfo{remoteAddr: "127.0.0.1", username: "u1"})
+	for _, query := range []string{"select * from test_table", "select * from test_table limit 100"} {
+		qre, sqlQuery := newTestQueryExecutor(query, ctx, enableStrict)
+		checkPlanID(t, planbuilder.PLAN_PASS_SELECT, qre.plan.PlanId)
+		testUtils.checkEqual(t, want, qre.Execute())
+		if _, ok := qre.ctx.Deadline(); !ok {
+			t.Errorf("the context of %q has no deadline", query)
+		}
+		sqlQuery.disallowQueries()
+	}
+}
+
+func TestQueryExecutorQRLimitDmlSubQuery(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	testUtils := &testUtils{}
+	query := "update test_table set addr = 3 where name = 1 limit 1000"
+	// the subquery isn't capped by the rule
+	expandedQuery := "select pk from test_table where name = 1 limit 1000 for update"
+	db.AddQuery(expandedQuery, &mproto.QueryResult{
+		Fields:       []mproto.Field{{Name: "pk", Type: mproto.VT_LONG}},
+		RowsAffected: 3,
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeNumeric([]byte("1"))},
+			{sqltypes.MakeNumeric([]byte("2"))},
+			{sqltypes.MakeNumeric([]byte("3"))},
+		},
+	})
+	updateQuery := "update test_table set addr = 3 where pk in (1, 2, 3) /* _stream test_table (pk ) (1 ) (2 ) (3 ); */"
+	db.AddQuery(updateQuery, &mproto.QueryResult{
+		RowsAffected: 3,
+		Rows:         make([][]sqltypes.Value, 3),
+	})
+	expected := &mproto.QueryResult{RowsAffected: 3}
+
+	limitRule := queryrules.NewQueryRule("limit test_table", "limit test_table", queryrules.QR_LIMIT)
+	limitRule.AddTableCond("test_table")
+	if err := limitRule.SetLimits(2, 0); err != nil {
+		t.Fatalf("SetLimits: %v", err)
+	}
+	setTestQueryRules(t, "limitedRulesQRLimitDmlSubQuery", limitRule)
+	defer QueryRuleSources.UnRegisterQueryRuleSource("limitedRulesQRLimitDmlSubQuery")
+
+	qre, sqlQuery := newTestQueryExecutor(
+		query, context.Background(), enableRowCache|enableTx|enableStrict)
+	defer sqlQuery.disallowQueries()
+	defer testCommitHelper(t, sqlQuery, qre)
+	checkPlanID(t, planbuilder.PLAN_DML_SUBQUERY, qre.plan.PlanId)
+	// all the rows are updated, and reported
+	testUtils.checkEqual(t, expected, qre.Execute())
+}
+
+func TestQueryExecutorQRLowPriority(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	testUtils := &testUtils{}
+	query := "select * from test_table limit 1000"
+	expected := &mproto.QueryResult{
+		Fields: getTestTableFields(),
+		Rows:   [][]sqltypes.Value{},
+	}
+	db.AddQuery(query, expected)
+	db.AddQuery("select * from test_table where 1 != 1", &mproto.QueryResult{
+		Fields: getTestTableFields(),
+	})
+
+	lowPriorityRule := queryrules.NewQueryRule("low priority user", "low priority user", queryrules.QR_LOW_PRIORITY)
+	lowPriorityRule.SetUserCond("batch")
+	setTestQueryRules(t, "lowPriorityRulesQRLowPriority", lowPriorityRule)
+	defer QueryRuleSources.UnRegisterQueryRuleSource("lowPriorityRulesQRLowPriority")
+
+	ctx := callinfo.NewContext(context.Background(), &fakeCallInfo{remoteAddr: "127.0.0.1", username: "u1"})
+	qre, sqlQuery := newTestQueryExecutor(query, ctx, enableStrict)
+	defer sqlQuery.disallowQueries()
+	testUtils.checkEqual(t, expected, qre.Execute())
+	if qre.connPool() != qre.qe.connPool {
+		t.Errorf("the query of u1 should use the regular pool")
+	}
+
+	qre.ctx = callinfo.NewContext(context.Background(), &fakeCallInfo{remoteAddr: "127.0.0.1", username: "batch"})
+	testUtils.checkEqual(t, expected, qre.Execute())
+	if qre.connPool() != qre.qe.lowPriorityConnPool {
+		t.Errorf("the query of batch should use the low priority pool")
+	}
+}
+
+// setTestQueryRules registers a query rule source that holds qrs.
+// The caller must unregister it.
+func setTestQueryRules(t *testing.T, rulesName string, qrs ...*queryrules.QueryRule) {
+	rules := NewQueryRules()
+	for _, qr := range qrs {
+		rules.Add(qr)
+	}
+	QueryRuleSources.UnRegisterQueryRuleSource(rulesName)
+	QueryRuleSources.RegisterQueryRuleSource(rulesName)
+	if err := QueryRuleSources.SetRules(rulesName, rules); err != nil {
+		t.Fatalf("failed to set rule, error: %v", err)
+	}
+}
+
 type executorFlags int64
 
 const (
@@ -841,6 +1178,8 @@ const (
 	enableSchemaOverrides
 	enableStrict
 	enableStrictTableAcl
+	enableHotRowProtection
+	enableTwoPC
 )
 
 // newTestQueryExecutor uses a package level variable testSqlQuery defined in sqlquery_test.go
@@ -872,6 +1211,13 @@ func newTestQueryExecutor(sql string, ctx context.Context, flags executorFlags)
 	} else {
 		config.StrictTableAcl = false
 	}
+	if flags&enableHotRowProtection > 0 {
+		config.EnableHotRowProtection = true
+		config.HotRowProtectionConcurrentTransactions = 1
+	}
+	if flags&enableTwoPC > 0 {
+		config.TwoPCEnable = true
+	}
 	sqlQuery := NewSqlQuery(config)
 	testUtils := newTestUtils()
 
@@ -911,6 +1257,32 @@ func newTestQueryExecutor(sql string, ctx context.Context, flags executorFlags)
 	return qre, sqlQuery
 }
 
+// beginTestTransaction begins another transaction in sqlQuery.
+func beginTestTransaction(t *testing.T, sqlQuery *SqlQuery) int64 {
+	session := proto.Session{SessionId: sqlQuery.sessionID}
+	txInfo := proto.TransactionInfo{}
+	if err := sqlQuery.Begin(context.Background(), &session, &txInfo); err != nil {
+		t.Fatalf("failed to start a transaction: %v", err)
+	}
+	return txInfo.TransactionId
+}
+
+// newTestTxQueryExecutor creates a QueryExecutor for sql
+// in the transaction of sqlQuery identified by transactionID.
+func newTestTxQueryExecutor(sqlQuery *SqlQuery, sql string, transactionID int64) *QueryExecutor {
+	ctx := context.Background()
+	logStats := newSqlQueryStats("TestQueryExecutor", ctx)
+	return &QueryExecutor{
+		query:         sql,
+		bindVars:      make(map[string]interface{}),
+		transactionID: transactionID,
+		plan:          sqlQuery.qe.schemaInfo.GetPlan(ctx, logStats, sql),
+		ctx:           ctx,
+		logStats:      logStats,
+		qe:            sqlQuery.qe,
+	}
+}
+
 func testCommitHelper(t *testing.T, sqlQuery *SqlQuery, queryExecutor *QueryExecutor) {
 	session := proto.Session{
 		SessionId:     sqlQuery.sessionID,
@@ -984,7 +1356,7 @@ func getQueryExecutorSupportedQueries() map[string]*mproto.QueryResult {
 			},
 		},
 		baseShowTables: &mproto.QueryResult{
-			RowsAffected: 1,
+			RowsAffected: 2,
 			Rows: [][]sqltypes.Value{
 				[]sqltypes.Value{
 					sqltypes.MakeString([]byte("test_table")),
@@ -992,6 +1364,12 @@ func getQueryExecutorSupportedQueries() map[string]*mproto.QueryResult {
 					sqltypes.MakeString([]byte("1427325875")),
 					sqltypes.MakeString([]byte("")),
 				},
+				[]sqltypes.Value{
+					sqltypes.MakeString([]byte("seq")),
+					sqltypes.MakeString([]byte("USER TABLE")),
+					sqltypes.MakeString([]byte("1427325875")),
+					sqltypes.MakeString([]byte("vitess_sequence")),
+				},
 			},
 		},
 		"describe `test_table`": &mproto.QueryResult{
@@ -1047,6 +1425,49 @@ func getQueryExecutorSupportedQueries() map[string]*mproto.QueryResult {
 				},
 			},
 		},
+		"describe `seq`": &mproto.QueryResult{
+			RowsAffected: 3,
+			Rows: [][]sqltypes.Value{
+				[]sqltypes.Value{
+					sqltypes.MakeString([]byte("id")),
+					sqltypes.MakeString([]byte("int")),
+					sqltypes.MakeString([]byte{}),
+					sqltypes.MakeString([]byte{}),
+					sqltypes.MakeString([]byte("0")),
+					sqltypes.MakeString([]byte{}),
+				},
+				[]sqltypes.Value{
+					sqltypes.MakeString([]byte("next_id")),
+					sqltypes.MakeString([]byte("bigint")),
+					sqltypes.MakeString([]byte{}),
+					sqltypes.MakeString([]byte{}),
+					sqltypes.MakeString([]byte("0")),
+					sqltypes.MakeString([]byte{}),
+				},
+				[]sqltypes.Value{
+					sqltypes.MakeString([]byte("cache")),
+					sqltypes.MakeString([]byte("bigint")),
+					sqltypes.MakeString([]byte{}),
+					sqltypes.MakeString([]byte{}),
+					sqltypes.MakeString([]byte("0")),
+					sqltypes.MakeString([]byte{}),
+				},
+			},
+		},
+		"show index from `seq`": &mproto.QueryResult{
+			RowsAffected: 1,
+			Rows: [][]sqltypes.Value{
+				[]sqltypes.Value{
+					sqltypes.MakeString([]byte{}),
+					sqltypes.MakeString([]byte{}),
+					sqltypes.MakeString([]byte("PRIMARY")),
+					sqltypes.MakeString([]byte{}),
+					sqltypes.MakeString([]byte("id")),
+					sqltypes.MakeString([]byte{}),
+					sqltypes.MakeString([]byte("1")),
+				},
+			},
+		},
 		"begin":  &mproto.QueryResult{},
 		"commit": &mproto.QueryResult{},
 		baseShowTables + " and table_name = 'test_table'": &mproto.QueryResult{
@@ -5,83 +5,56 @@
 package tabletserver
 
 import (
-	"errors"
-	"sync"
-
-	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/queryrules"
 	"github.com/youtube/vitess/go/vt/tabletserver/planbuilder"
 )
 
-// Global variable to keep track of every registered query rule source
-var QueryRuleSources = NewQueryRuleInfo()
-
-// QueryRuleInfo is the maintainer of QueryRules from multiple sources
-type QueryRuleInfo struct {
-	// mutex to protect following queryRulesMap
-	mu sync.Mutex
-	// queryRulesMap maps the names of different query rule sources to the actual QueryRules structure
-	queryRulesMap map[string]*QueryRules
+// queryRuleService describes the query rules of vttablet:
+// they match the plans of the tabletserver planbuilder.
+var queryRuleService = &queryrules.Service{
+	PlanByName: func(name string) (int, bool) {
+		planID, ok := planbuilder.PlanByName(name)
+		return int(planID), ok
+	},
+	Actions: map[string]queryrules.Action{
+		"FAIL":         queryrules.QR_FAIL,
+		"FAIL_RETRY":   queryrules.QR_FAIL_RETRY,
+		"THROTTLE":     queryrules.QR_THROTTLE,
+		"LIMIT":        queryrules.QR_LIMIT,
+		"LOW_PRIORITY": queryrules.QR_LOW_PRIORITY,
+	},
 }
 
-// NewQueryRuleInfo returns an empty QueryRuleInfo object for use
-func NewQueryRuleInfo() *QueryRuleInfo {
-	qri := &QueryRuleInfo{
-		queryRulesMap: map[string]*QueryRules{},
-	}
-	return qri
+// Global variable to keep track of every registered query rule source
+var QueryRuleSources = queryrules.NewSources(queryRuleService)
+
+// NewQueryRules creates a new QueryRules for vttablet.
+func NewQueryRules() *queryrules.QueryRules {
+	return queryrules.NewQueryRules(queryRuleService)
 }
 
-// RegisterQueryRuleSource registers a query rule source name with QueryRuleInfo
-func (qri *QueryRuleInfo) RegisterQueryRuleSource(ruleSource string) {
-	qri.mu.Lock()
-	defer qri.mu.Unlock()
-	if _, existed := qri.queryRulesMap[ruleSource]; existed {
-		log.Errorf("Query rule source " + ruleSource + " has been registered")
-		panic("Query rule source " + ruleSource + " has been registered")
-	}
-	qri.queryRulesMap[ruleSource] = NewQueryRules()
+// queryRuleSink is the queryrules.Sink of vttablet.
+type queryRuleSink struct {
+	qsc QueryServiceControl
 }
 
-// UnRegisterQueryRuleSource removes a registered query rule source name
-func (qri *QueryRuleInfo) UnRegisterQueryRuleSource(ruleSource string) {
-	qri.mu.Lock()
-	defer qri.mu.Unlock()
-	delete(qri.queryRulesMap, ruleSource)
+// NewQueryRuleSink returns a queryrules.Sink that pushes
+// the rules of custom rule sources to qsc.
+func NewQueryRuleSink(qsc QueryServiceControl) queryrules.Sink {
+	return queryRuleSink{qsc: qsc}
 }
 
-// SetRules takes an external QueryRules structure and overwrite one of the
-// internal QueryRules as designated by ruleSource parameter
-func (qri *QueryRuleInfo) SetRules(ruleSource string, newRules *QueryRules) error {
-	if newRules == nil {
-		newRules = NewQueryRules()
-	}
-	qri.mu.Lock()
-	defer qri.mu.Unlock()
-	if _, ok := qri.queryRulesMap[ruleSource]; ok {
-		qri.queryRulesMap[ruleSource] = newRules.Copy()
-		return nil
-	}
-	return errors.New("Rule source identifier " + ruleSource + " is not valid")
+// NewQueryRules is part of the queryrules.Sink interface.
+func (queryRuleSink) NewQueryRules() *queryrules.QueryRules {
+	return NewQueryRules()
 }
 
-// GetRules returns the corresponding QueryRules as designated by ruleSource parameter
-func (qri *QueryRuleInfo) GetRules(ruleSource string) (*QueryRules, error) {
-	qri.mu.Lock()
-	defer qri.mu.Unlock()
-	if ruleset, ok := qri.queryRulesMap[ruleSource]; ok {
-		return ruleset.Copy(), nil
-	}
-	return NewQueryRules(), errors.New("Rule source identifier " + ruleSource + " is not valid")
+// RegisterQueryRuleSource is part of the queryrules.Sink interface.
+func (queryRuleSink) RegisterQueryRuleSource(ruleSource string) {
+	QueryRuleSources.RegisterQueryRuleSource(ruleSource)
 }
 
-// filterByPlan creates a new QueryRules by prefiltering on all query rules that are contained in internal
-// QueryRules structures, in other words, query rules from all predefined sources will be applied
-func (qri *QueryRuleInfo) filterByPlan(query string, planid planbuilder.PlanType, tableName string) (newqrs *QueryRules) {
-	qri.mu.Lock()
-	defer qri.mu.Unlock()
-	newqrs = NewQueryRules()
-	for _, rules := range qri.queryRulesMap {
-		newqrs.Append(rules.filterByPlan(query, planid, tableName))
-	}
-	return newqrs
+// SetQueryRules is part of the queryrules.Sink interface.
+func (sink queryRuleSink) SetQueryRules(ruleSource string, qrs *queryrules.QueryRules) error {
+	return sink.qsc.SetQueryRules(ruleSource, qrs)
 }
@@ -1,8 +1,10 @@
 package tabletserver
 
 import (
+	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/sqltypes"
@@ -14,8 +16,13 @@ import (
 // QuerySplits are generated by adding primary key range clauses to the
 // original query. Only a limited set of queries are supported, see
 // QuerySplitter.validateQuery() for details. Also, the table must have at least
-// one primary key and the leading primary key must be numeric, see
-// QuerySplitter.splitBoundaries()
+// one primary key.
+// A numeric split column is divided into equal ranges from its min and max
+// values, see QuerySplitter.splitBoundaries(). So is a composite primary key
+// whose leading column is numeric: the ranges are built on that column. The
+// boundaries of a string or binary split column, or of a composite primary
+// key that starts with one, are sampled from the table instead, see
+// QuerySplitter.sampleSQLs().
 type QuerySplitter struct {
 	query       *proto.BoundQuery
 	splitCount  int
@@ -23,7 +30,10 @@ type QuerySplitter struct {
 	sel         *sqlparser.Select
 	tableName   string
 	splitColumn string
-	rowCount    int64
+	// splitColumns are the columns the ranges are built on: the
+	// split column if one was requested, or the primary key.
+	splitColumns []string
+	rowCount     int64
 }
 
 // NewQuerySplitter creates a new QuerySplitter. query is the original query
@@ -83,13 +93,17 @@ func (qs *QuerySplitter) validateQuery() error {
 		for _, index := range tableInfo.Indexes {
 			for _, column := range index.Columns {
 				if qs.splitColumn == column {
+					qs.splitColumns = []string{qs.splitColumn}
 					return nil
 				}
 			}
 		}
 		return fmt.Errorf("split column is not indexed or does not exist in table schema, SplitColumn: %s, TableInfo.Table: %v", qs.splitColumn, tableInfo.Table)
 	}
-	qs.splitColumn = tableInfo.GetPKColumn(0).Name
+	qs.splitColumns = make([]string, len(tableInfo.PKColumns))
+	for i := range tableInfo.PKColumns {
+		qs.splitColumns[i] = tableInfo.GetPKColumn(i).Name
+	}
 	return nil
 }
 
@@ -100,6 +114,91 @@ func (qs *QuerySplitter) split(pkMinMax *mproto.QueryResult) ([]proto.QuerySplit
 	if err != nil {
 		return nil, err
 	}
+	tuples := make([][]sqltypes.Value, 0, len(boundaries))
+	for _, boundary := range boundaries {
+		tuples = append(tuples, []sqltypes.Value{boundary})
+	}
+	return qs.splitOnBoundaries(tuples), nil
+}
+
+// needsSampling returns true if the boundaries of the splits must be
+// sampled from the table, because the leading split column can't be
+// divided into equal ranges from its min and max values.
+func (qs *QuerySplitter) needsSampling(pkMinMax *mproto.QueryResult) bool {
+	if len(pkMinMax.Fields) == 0 {
+		return false
+	}
+	switch pkMinMax.Fields[0].Type {
+	case mproto.VT_VARCHAR, mproto.VT_VAR_STRING, mproto.VT_STRING,
+		mproto.VT_TINY_BLOB, mproto.VT_MEDIUM_BLOB, mproto.VT_LONG_BLOB, mproto.VT_BLOB:
+		return true
+	}
+	return false
+}
+
+// rowCountSQL returns the query that counts the rows of the table.
+func (qs *QuerySplitter) rowCountSQL() string {
+	return fmt.Sprintf("SELECT COUNT(*) FROM %v", qs.tableName)
+}
+
+// sampleSQLs returns the queries that fetch the boundaries of the
+// splits: the values of the split columns at equally spaced offsets
+// of the table, in the order of the split columns.
+func (qs *QuerySplitter) sampleSQLs(rowCount *mproto.QueryResult) ([]string, error) {
+	if len(rowCount.Rows) != 1 || rowCount.Rows[0][0].IsNull() {
+		return nil, nil
+	}
+	count, err := sqltypes.MakeNumeric(rowCount.Rows[0][0].Raw()).ParseInt64()
+	if err != nil {
+		return nil, err
+	}
+	interval := count / int64(qs.splitCount)
+	if interval == 0 {
+		return nil, nil
+	}
+	qs.rowCount = interval
+	columns := strings.Join(qs.splitColumns, ", ")
+	sqls := make([]string, 0, qs.splitCount-1)
+	for i := int64(1); i < int64(qs.splitCount); i++ {
+		sqls = append(sqls, fmt.Sprintf("SELECT %v FROM %v ORDER BY %v LIMIT %d, 1", columns, qs.tableName, columns, interval*i))
+	}
+	return sqls, nil
+}
+
+// splitSamples splits the query on the boundaries fetched by the
+// queries of sampleSQLs(). Samples with a NULL value and repeated
+// samples are skipped, as they don't make a valid range.
+func (qs *QuerySplitter) splitSamples(samples [][]sqltypes.Value) []proto.QuerySplit {
+	boundaries := make([][]sqltypes.Value, 0, len(samples))
+nextSample:
+	for _, sample := range samples {
+		for _, v := range sample {
+			if v.IsNull() {
+				continue nextSample
+			}
+		}
+		if len(boundaries) != 0 && equalTuples(boundaries[len(boundaries)-1], sample) {
+			continue
+		}
+		boundaries = append(boundaries, sample)
+	}
+	return qs.splitOnBoundaries(boundaries)
+}
+
+func equalTuples(a, b []sqltypes.Value) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].Raw(), b[i].Raw()) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitOnBoundaries returns one split per range between boundaries.
+func (qs *QuerySplitter) splitOnBoundaries(boundaries [][]sqltypes.Value) []proto.QuerySplit {
 	splits := []proto.QuerySplit{}
 	// No splits, return the original query as a single split
 	if len(boundaries) == 0 {
@@ -109,13 +208,13 @@ func (qs *QuerySplitter) split(pkMinMax *mproto.QueryResult) ([]proto.QuerySplit
 		splits = append(splits, *split)
 	} else {
 		// Loop through the boundaries and generated modified where clauses
-		start := sqltypes.Value{}
+		var start []sqltypes.Value
 		clauses := []*sqlparser.Where{}
 		for _, end := range boundaries {
 			clauses = append(clauses, qs.getWhereClause(start, end))
-			start.Inner = end.Inner
+			start = end
 		}
-		clauses = append(clauses, qs.getWhereClause(start, sqltypes.Value{}))
+		clauses = append(clauses, qs.getWhereClause(start, nil))
 		// Generate one split per clause
 		for _, clause := range clauses {
 			sel := qs.sel
@@ -131,37 +230,27 @@ func (qs *QuerySplitter) split(pkMinMax *mproto.QueryResult) ([]proto.QuerySplit
 			splits = append(splits, *split)
 		}
 	}
-	return splits, err
+	return splits
 }
 
 // getWhereClause returns a whereClause based on desired upper and lower
-// bounds for primary key.
-func (qs *QuerySplitter) getWhereClause(start, end sqltypes.Value) *sqlparser.Where {
-	var startClause *sqlparser.ComparisonExpr
-	var endClause *sqlparser.ComparisonExpr
+// bounds for the split columns. The bounds may only hold the leading
+// split columns, which are then compared in order.
+func (qs *QuerySplitter) getWhereClause(start, end []sqltypes.Value) *sqlparser.Where {
+	var startClause sqlparser.BoolExpr
+	var endClause sqlparser.BoolExpr
 	var clauses sqlparser.BoolExpr
 	// No upper or lower bound, just return the where clause of original query
-	if start.IsNull() && end.IsNull() {
+	if start == nil && end == nil {
 		return qs.sel.Where
 	}
-	pk := &sqlparser.ColName{
-		Name: []byte(qs.splitColumn),
+	// splitColumns >= start
+	if start != nil {
+		startClause = boundaryClause(qs.splitColumns, start, sqlparser.AST_GT, sqlparser.AST_GE)
 	}
-	// splitColumn >= start
-	if !start.IsNull() {
-		startClause = &sqlparser.ComparisonExpr{
-			Operator: sqlparser.AST_GE,
-			Left:     pk,
-			Right:    sqlparser.NumVal((start).Raw()),
-		}
-	}
-	// splitColumn < end
-	if !end.IsNull() {
-		endClause = &sqlparser.ComparisonExpr{
-			Operator: sqlparser.AST_LT,
-			Left:     pk,
-			Right:    sqlparser.NumVal((end).Raw()),
-		}
+	// splitColumns < end
+	if end != nil {
+		endClause = boundaryClause(qs.splitColumns, end, sqlparser.AST_LT, sqlparser.AST_LT)
 	}
 	if startClause == nil {
 		clauses = endClause
@@ -169,7 +258,7 @@ func (qs *QuerySplitter) getWhereClause(start, end sqltypes.Value) *sqlparser.Wh
 		if endClause == nil {
 			clauses = startClause
 		} else {
-			// splitColumn >= start AND splitColumn < end
+			// splitColumns >= start AND splitColumns < end
 			clauses = &sqlparser.AndExpr{
 				Left:  startClause,
 				Right: endClause,
@@ -188,6 +277,51 @@ func (qs *QuerySplitter) getWhereClause(start, end sqltypes.Value) *sqlparser.Wh
 	}
 }
 
+// boundaryClause compares the leading columns with boundary. For more
+// than one column, (a, b) >= (x, y) is expanded to
+// (a > x or (a = x and b >= y)), because MySQL before 5.7 doesn't range
+// scan the index for tuple comparisons: op compares the leading columns, and lastOp
+// the last one.
+func boundaryClause(columns []string, boundary []sqltypes.Value, op, lastOp string) sqlparser.BoolExpr {
+	last := len(boundary) - 1
+	var clause sqlparser.BoolExpr = &sqlparser.ComparisonExpr{
+		Operator: lastOp,
+		Left:     &sqlparser.ColName{Name: []byte(columns[last])},
+		Right:    valueExpr(boundary[last]),
+	}
+	for i := last - 1; i >= 0; i-- {
+		column := &sqlparser.ColName{Name: []byte(columns[i])}
+		value := valueExpr(boundary[i])
+		clause = &sqlparser.ParenBoolExpr{
+			Expr: &sqlparser.OrExpr{
+				Left: &sqlparser.ComparisonExpr{
+					Operator: op,
+					Left:     column,
+					Right:    value,
+				},
+				Right: &sqlparser.ParenBoolExpr{
+					Expr: &sqlparser.AndExpr{
+						Left: &sqlparser.ComparisonExpr{
+							Operator: sqlparser.AST_EQ,
+							Left:     column,
+							Right:    value,
+						},
+						Right: clause,
+					},
+				},
+			},
+		}
+	}
+	return clause
+}
+
+func valueExpr(v sqltypes.Value) sqlparser.ValExpr {
+	if v.IsNumeric() || v.IsFractional() {
+		return sqlparser.NumVal(v.Raw())
+	}
+	return sqlparser.StrVal(v.Raw())
+}
+
 func (qs *QuerySplitter) splitBoundaries(pkMinMax *mproto.QueryResult) ([]sqltypes.Value, error) {
 	boundaries := []sqltypes.Value{}
 	var err error
@@ -38,6 +38,29 @@ func getSchemaInfo() *SchemaInfo {
 	tableNoPK.PKColumns = []int{}
 	tables["test_table_no_pk"] = &TableInfo{Table: tableNoPK}
 
+	tableCompositePK := &schema.Table{
+		Name: "test_table_composite_pk",
+	}
+	tableCompositePK.AddColumn("user_id", "int", zero, "")
+	tableCompositePK.AddColumn("uuid", "varbinary(16)", zero, "")
+	tableCompositePK.AddColumn("count", "int", zero, "")
+	tableCompositePK.PKColumns = []int{0, 1}
+	compositeIndex := tableCompositePK.AddIndex("PRIMARY")
+	compositeIndex.AddColumn("user_id", 1234)
+	compositeIndex.AddColumn("uuid", 12345)
+	tables["test_table_composite_pk"] = &TableInfo{Table: tableCompositePK}
+
+	tableStringPK := &schema.Table{
+		Name: "test_table_string_pk",
+	}
+	tableStringPK.AddColumn("uuid", "varbinary(16)", zero, "")
+	tableStringPK.AddColumn("seq", "int", zero, "")
+	tableStringPK.PKColumns = []int{0, 1}
+	stringIndex := tableStringPK.AddIndex("PRIMARY")
+	stringIndex.AddColumn("uuid", 12345)
+	stringIndex.AddColumn("seq", 123456)
+	tables["test_table_string_pk"] = &TableInfo{Table: tableStringPK}
+
 	return &SchemaInfo{tables: tables}
 }
 
@@ -133,11 +156,10 @@ func TestGetWhereClause(t *testing.T) {
 	sql := "select * from test_table where count > :count"
 	statement, _ := sqlparser.Parse(sql)
 	splitter.sel, _ = statement.(*sqlparser.Select)
-	splitter.splitColumn = "id"
+	splitter.splitColumns = []string{"id"}
 
 	// no boundary case, start = end = nil, should not change the where clause
-	nilValue := sqltypes.Value{}
-	clause := splitter.getWhereClause(nilValue, nilValue)
+	clause := splitter.getWhereClause(nil, nil)
 	want := " where count > :count"
 	got := sqlparser.String(clause)
 	if !reflect.DeepEqual(got, want) {
@@ -145,8 +167,8 @@ func TestGetWhereClause(t *testing.T) {
 	}
 
 	// Set lower bound, should add the lower bound condition to where clause
-	start, _ := sqltypes.BuildValue(20)
-	clause = splitter.getWhereClause(start, nilValue)
+	start := []sqltypes.Value{buildVal(20)}
+	clause = splitter.getWhereClause(start, nil)
 	want = " where count > :count and id >= 20"
 	got = sqlparser.String(clause)
 	if !reflect.DeepEqual(got, want) {
@@ -154,8 +176,8 @@ func TestGetWhereClause(t *testing.T) {
 	}
 
 	// Set upper bound, should add the upper bound condition to where clause
-	end, _ := sqltypes.BuildValue(40)
-	clause = splitter.getWhereClause(nilValue, end)
+	end := []sqltypes.Value{buildVal(40)}
+	clause = splitter.getWhereClause(nil, end)
 	want = " where count > :count and id < 40"
 	got = sqlparser.String(clause)
 	if !reflect.DeepEqual(got, want) {
@@ -176,7 +198,7 @@ func TestGetWhereClause(t *testing.T) {
 	splitter.sel, _ = statement.(*sqlparser.Select)
 
 	// no boundary case, start = end = nil should return no where clause
-	clause = splitter.getWhereClause(nilValue, nilValue)
+	clause = splitter.getWhereClause(nil, nil)
 	want = ""
 	got = sqlparser.String(clause)
 	if !reflect.DeepEqual(got, want) {
@@ -190,6 +212,44 @@ func TestGetWhereClause(t *testing.T) {
 	if !reflect.DeepEqual(got, want) {
 		t.Errorf("incorrect where clause, got:%v, want:%v", got, want)
 	}
+
+	// String bounds are quoted
+	clause = splitter.getWhereClause([]sqltypes.Value{buildVal("a'b")}, []sqltypes.Value{buildVal("c")})
+	want = " where id >= 'a\\'b' and id < 'c'"
+	got = sqlparser.String(clause)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect where clause, got:%v, want:%v", got, want)
+	}
+
+	// Composite split columns are compared in order
+	splitter.splitColumns = []string{"user_id", "uuid"}
+	clause = splitter.getWhereClause([]sqltypes.Value{buildVal(1), buildVal("a")}, []sqltypes.Value{buildVal(2), buildVal("b")})
+	want = " where (user_id > 1 or (user_id = 1 and uuid >= 'a')) and (user_id < 2 or (user_id = 2 and uuid < 'b'))"
+	got = sqlparser.String(clause)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect where clause, got:%v, want:%v", got, want)
+	}
+
+	splitter.splitColumns = []string{"a", "b", "c"}
+	clause = splitter.getWhereClause([]sqltypes.Value{buildVal(1), buildVal(2), buildVal(3)}, nil)
+	want = " where (a > 1 or (a = 1 and (b > 2 or (b = 2 and c >= 3))))"
+	got = sqlparser.String(clause)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect where clause, got:%v, want:%v", got, want)
+	}
+	splitter.splitColumns = []string{"user_id", "uuid"}
+	got = sqlparser.String(clause)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect where clause, got:%v, want:%v", got, want)
+	}
+
+	// Bounds on the leading split column only compare that column
+	clause = splitter.getWhereClause([]sqltypes.Value{buildVal(1)}, []sqltypes.Value{buildVal(2)})
+	want = " where user_id >= 1 and user_id < 2"
+	got = sqlparser.String(clause)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("incorrect where clause, got:%v, want:%v", got, want)
+	}
 }
 
 func TestSplitBoundaries(t *testing.T) {
@@ -314,3 +374,163 @@ func TestSplitQuery(t *testing.T) {
 		t.Errorf("wrong splits, got: %v, want: %v", got, want)
 	}
 }
+
+func TestSplitQueryStringColumn(t *testing.T) {
+	schemaInfo := getSchemaInfo()
+	query := &proto.BoundQuery{
+		Sql: "select * from test_table_composite_pk where count > :count",
+	}
+	splitter := NewQuerySplitter(query, "uuid", 3, schemaInfo)
+	if err := splitter.validateQuery(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pkMinMax := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			mproto.Field{Name: "min", Type: mproto.VT_VAR_STRING},
+			mproto.Field{Name: "max", Type: mproto.VT_VAR_STRING},
+		},
+		Rows: [][]sqltypes.Value{[]sqltypes.Value{buildVal("a"), buildVal("z")}},
+	}
+	if !splitter.needsSampling(pkMinMax) {
+		t.Errorf("string split column should be sampled")
+	}
+	if got, want := splitter.rowCountSQL(), "SELECT COUNT(*) FROM test_table_composite_pk"; got != want {
+		t.Errorf("wrong row count query, got: %v, want: %v", got, want)
+	}
+	rowCount := &mproto.QueryResult{
+		Rows: [][]sqltypes.Value{[]sqltypes.Value{buildVal(300)}},
+	}
+	got, err := splitter.sampleSQLs(rowCount)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"SELECT uuid FROM test_table_composite_pk ORDER BY uuid LIMIT 100, 1",
+		"SELECT uuid FROM test_table_composite_pk ORDER BY uuid LIMIT 200, 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong sample queries, got: %v, want: %v", got, want)
+	}
+
+	// repeated and NULL samples are skipped
+	splits := splitter.splitSamples([][]sqltypes.Value{
+		[]sqltypes.Value{buildVal("h")},
+		[]sqltypes.Value{buildVal("h")},
+		[]sqltypes.Value{sqltypes.Value{}},
+		[]sqltypes.Value{buildVal("p")},
+	})
+	got = []string{}
+	for _, split := range splits {
+		if split.RowCount != 100 {
+			t.Errorf("wrong RowCount, got: %v, want: %v", split.RowCount, 100)
+		}
+		got = append(got, split.Query.Sql)
+	}
+	want = []string{
+		"select * from test_table_composite_pk where count > :count and uuid < 'h'",
+		"select * from test_table_composite_pk where count > :count and uuid >= 'h' and uuid < 'p'",
+		"select * from test_table_composite_pk where count > :count and uuid >= 'p'",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong splits, got: %v, want: %v", got, want)
+	}
+
+	// a table smaller than the split count is not split
+	rowCount.Rows = [][]sqltypes.Value{[]sqltypes.Value{buildVal(2)}}
+	got, err = splitter.sampleSQLs(rowCount)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("want no sample queries, got: %v", got)
+	}
+	splits = splitter.splitSamples(nil)
+	if len(splits) != 1 || splits[0].Query.Sql != query.Sql {
+		t.Errorf("want the original query, got: %v", splits)
+	}
+}
+
+func TestSplitQueryCompositePK(t *testing.T) {
+	schemaInfo := getSchemaInfo()
+	query := &proto.BoundQuery{
+		Sql: "select * from test_table_composite_pk",
+	}
+	splitter := NewQuerySplitter(query, "", 2, schemaInfo)
+	if err := splitter.validateQuery(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the numeric leading column is divided into ranges
+	pkMinMax := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			mproto.Field{Name: "min", Type: mproto.VT_LONG},
+			mproto.Field{Name: "max", Type: mproto.VT_LONG},
+		},
+		Rows: [][]sqltypes.Value{[]sqltypes.Value{buildVal(0), buildVal(10)}},
+	}
+	if splitter.needsSampling(pkMinMax) {
+		t.Errorf("composite primary key with a numeric leading column should not be sampled")
+	}
+	splits, err := splitter.split(pkMinMax)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := []string{}
+	for _, split := range splits {
+		got = append(got, split.Query.Sql)
+	}
+	want := []string{
+		"select * from test_table_composite_pk where user_id < 5",
+		"select * from test_table_composite_pk where user_id >= 5",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong splits, got: %v, want: %v", got, want)
+	}
+}
+
+func TestSplitQueryCompositePKStringLeadingColumn(t *testing.T) {
+	schemaInfo := getSchemaInfo()
+	query := &proto.BoundQuery{
+		Sql: "select * from test_table_string_pk",
+	}
+	splitter := NewQuerySplitter(query, "", 2, schemaInfo)
+	if err := splitter.validateQuery(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pkMinMax := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			mproto.Field{Name: "min", Type: mproto.VT_VAR_STRING},
+			mproto.Field{Name: "max", Type: mproto.VT_VAR_STRING},
+		},
+		Rows: [][]sqltypes.Value{[]sqltypes.Value{buildVal("a"), buildVal("z")}},
+	}
+	if !splitter.needsSampling(pkMinMax) {
+		t.Errorf("composite primary key with a binary leading column should be sampled")
+	}
+	rowCount := &mproto.QueryResult{
+		Rows: [][]sqltypes.Value{[]sqltypes.Value{buildVal(10)}},
+	}
+	got, err := splitter.sampleSQLs(rowCount)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{
+		"SELECT uuid, seq FROM test_table_string_pk ORDER BY uuid, seq LIMIT 5, 1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong sample queries, got: %v, want: %v", got, want)
+	}
+	splits := splitter.splitSamples([][]sqltypes.Value{
+		[]sqltypes.Value{sqltypes.MakeString([]byte{0x01, 0xff}), buildVal(7)},
+	})
+	got = []string{}
+	for _, split := range splits {
+		got = append(got, split.Query.Sql)
+	}
+	want = []string{
+		"select * from test_table_string_pk where (uuid < '\x01\xff' or (uuid = '\x01\xff' and seq < 7))",
+		"select * from test_table_string_pk where (uuid > '\x01\xff' or (uuid = '\x01\xff' and seq >= 7))",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong splits, got: %v, want: %v", got, want)
+	}
+}
@@ -19,8 +19,10 @@ import (
 	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/vt/dbconfigs"
 	"github.com/youtube/vitess/go/vt/mysqlctl"
+	"github.com/youtube/vitess/go/vt/queryrules"
 	"github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/tabletserver/queryservice"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
 	"golang.org/x/net/context"
 )
 
@@ -34,6 +36,7 @@ var (
 func init() {
 	flag.IntVar(&qsConfig.PoolSize, "queryserver-config-pool-size", DefaultQsConfig.PoolSize, "query server connection pool size, connection pool is used by regular queries (non streaming, not in a transaction)")
 	flag.IntVar(&qsConfig.StreamPoolSize, "queryserver-config-stream-pool-size", DefaultQsConfig.StreamPoolSize, "query server stream pool size, stream pool is used by stream queries: queries that return results to client in a streaming fashion")
+	flag.IntVar(&qsConfig.LowPriorityPoolSize, "queryserver-config-low-priority-pool-size", DefaultQsConfig.LowPriorityPoolSize, "query server low priority pool size, low priority pool is used by the regular queries that match a LOW_PRIORITY query rule")
 	flag.IntVar(&qsConfig.TransactionCap, "queryserver-config-transaction-cap", DefaultQsConfig.TransactionCap, "query server transaction cap is the maximum number of transactions allowed to happen at any given point of a time for a single vttablet. E.g. by setting transaction cap to 100, there are at most 100 transactions will be processed by a vttablet and the 101th transaction will be blocked (and fail if it cannot get connection within specified timeout)")
 	flag.Float64Var(&qsConfig.TransactionTimeout, "queryserver-config-transaction-timeout", DefaultQsConfig.TransactionTimeout, "query server transaction timeout (in seconds), a transaction will be killed if it takes longer than this value")
 	flag.IntVar(&qsConfig.MaxResultSize, "queryserver-config-max-result-size", DefaultQsConfig.MaxResultSize, "query server max result size, maximum number of rows allowed to return from vttablet for non-streaming queries.")
@@ -60,6 +63,11 @@ func init() {
 	flag.StringVar(&qsConfig.DebugURLPrefix, "debug-url-prefix", DefaultQsConfig.DebugURLPrefix, "debug url prefix, vttablet will report various system debug pages and this config controls the prefix of these debug urls")
 	flag.StringVar(&qsConfig.PoolNamePrefix, "pool-name-prefix", DefaultQsConfig.PoolNamePrefix, "pool name prefix, vttablet has several pools and each of them has a name. This config specifies the prefix of these pool names")
 	flag.BoolVar(&qsConfig.EnableAutoCommit, "enable-autocommit", DefaultQsConfig.EnableAutoCommit, "if the flag is on, a DML outsides a transaction will be auto committed.")
+	flag.BoolVar(&qsConfig.TwoPCEnable, "twopc-enable", DefaultQsConfig.TwoPCEnable, "if the flag is on, vttablet creates the two-phase commit tables on startup and accepts distributed transactions.")
+	flag.Float64Var(&qsConfig.TwoPCAbandonAge, "twopc-abandon-age", DefaultQsConfig.TwoPCAbandonAge, "time in seconds after which a distributed transaction that's still unresolved is considered abandoned, and resolved by the tablet that holds its metadata.")
+	flag.BoolVar(&qsConfig.EnableHotRowProtection, "enable-hot-row-protection", DefaultQsConfig.EnableHotRowProtection, "if the flag is on, the transactions that update the same row by primary key are queued, so they don't exhaust the transaction pool while they wait for the row lock.")
+	flag.IntVar(&qsConfig.HotRowProtectionMaxQueueSize, "hot-row-protection-max-queue-size", DefaultQsConfig.HotRowProtectionMaxQueueSize, "maximum number of transactions waiting for or updating the same row. The next ones are rejected with a tx_pool_full error.")
+	flag.IntVar(&qsConfig.HotRowProtectionConcurrentTransactions, "hot-row-protection-concurrent-transactions", DefaultQsConfig.HotRowProtectionConcurrentTransactions, "maximum number of transactions updating the same row at a time. The next ones wait in the queue of the row.")
 }
 
 // RowCacheConfig encapsulates the configuration for RowCache
@@ -99,28 +107,35 @@ func (c *RowCacheConfig) GetSubprocessFlags(socket string) []string {
 
 // Config contains all the configuration for query service
 type Config struct {
-	PoolSize           int
-	StreamPoolSize     int
-	TransactionCap     int
-	TransactionTimeout float64
-	MaxResultSize      int
-	MaxDMLRows         int
-	StreamBufferSize   int
-	QueryCacheSize     int
-	SchemaReloadTime   float64
-	QueryTimeout       float64
-	TxPoolTimeout      float64
-	IdleTimeout        float64
-	RowCache           RowCacheConfig
-	SpotCheckRatio     float64
-	StrictMode         bool
-	StrictTableAcl     bool
-	TerseErrors        bool
-	EnablePublishStats bool
-	EnableAutoCommit   bool
-	StatsPrefix        string
-	DebugURLPrefix     string
-	PoolNamePrefix     string
+	PoolSize            int
+	StreamPoolSize      int
+	LowPriorityPoolSize int
+	TransactionCap      int
+	TransactionTimeout  float64
+	MaxResultSize       int
+	MaxDMLRows          int
+	StreamBufferSize    int
+	QueryCacheSize      int
+	SchemaReloadTime    float64
+	QueryTimeout        float64
+	TxPoolTimeout       float64
+	IdleTimeout         float64
+	RowCache            RowCacheConfig
+	SpotCheckRatio      float64
+	StrictMode          bool
+	StrictTableAcl      bool
+	TerseErrors         bool
+	EnablePublishStats  bool
+	EnableAutoCommit    bool
+	TwoPCEnable         bool
+	TwoPCAbandonAge     float64
+	StatsPrefix         string
+	DebugURLPrefix      string
+	PoolNamePrefix      string
+
+	EnableHotRowProtection                 bool
+	HotRowProtectionMaxQueueSize           int
+	HotRowProtectionConcurrentTransactions int
 }
 
 // DefaultQSConfig is the default value for the query service config.
@@ -132,28 +147,35 @@ type Config struct {
 // great (the overhead makes the final packets on the wire about twice
 // bigger than this).
 var DefaultQsConfig = Config{
-	PoolSize:           16,
-	StreamPoolSize:     750,
-	TransactionCap:     20,
-	TransactionTimeout: 30,
-	MaxResultSize:      10000,
-	MaxDMLRows:         500,
-	QueryCacheSize:     5000,
-	SchemaReloadTime:   30 * 60,
-	QueryTimeout:       0,
-	TxPoolTimeout:      1,
-	IdleTimeout:        30 * 60,
-	StreamBufferSize:   32 * 1024,
-	RowCache:           RowCacheConfig{Memory: -1, Connections: -1, Threads: -1},
-	SpotCheckRatio:     0,
-	StrictMode:         true,
-	StrictTableAcl:     false,
-	TerseErrors:        false,
-	EnablePublishStats: true,
-	EnableAutoCommit:   false,
-	StatsPrefix:        "",
-	DebugURLPrefix:     "/debug",
-	PoolNamePrefix:     "",
+	PoolSize:            16,
+	StreamPoolSize:      750,
+	LowPriorityPoolSize: 4,
+	TransactionCap:      20,
+	TransactionTimeout:  30,
+	MaxResultSize:       10000,
+	MaxDMLRows:          500,
+	QueryCacheSize:      5000,
+	SchemaReloadTime:    30 * 60,
+	QueryTimeout:        0,
+	TxPoolTimeout:       1,
+	IdleTimeout:         30 * 60,
+	StreamBufferSize:    32 * 1024,
+	RowCache:            RowCacheConfig{Memory: -1, Connections: -1, Threads: -1},
+	SpotCheckRatio:      0,
+	StrictMode:          true,
+	StrictTableAcl:      false,
+	TerseErrors:         false,
+	EnablePublishStats:  true,
+	EnableAutoCommit:    false,
+	TwoPCEnable:         false,
+	TwoPCAbandonAge:     60,
+	StatsPrefix:         "",
+	DebugURLPrefix:      "/debug",
+	PoolNamePrefix:      "",
+
+	EnableHotRowProtection:                 false,
+	HotRowProtectionMaxQueueSize:           20,
+	HotRowProtectionConcurrentTransactions: 5,
 }
 
 var qsConfig Config
@@ -183,7 +205,7 @@ type QueryServiceControl interface {
 	ReloadSchema()
 
 	// SetQueryRules sets the query rules for this QueryService
-	SetQueryRules(ruleSource string, qrs *QueryRules) error
+	SetQueryRules(ruleSource string, qrs *queryrules.QueryRules) error
 
 	// QueryService returns the QueryService object used by this
 	// QueryServiceControl
@@ -251,7 +273,7 @@ func (tqsc *TestQueryServiceControl) ReloadSchema() {
 }
 
 // SetQueryRules is part of the QueryServiceControl interface
-func (tqsc *TestQueryServiceControl) SetQueryRules(ruleSource string, qrs *QueryRules) error {
+func (tqsc *TestQueryServiceControl) SetQueryRules(ruleSource string, qrs *queryrules.QueryRules) error {
 	return nil
 }
 
@@ -267,6 +289,11 @@ type realQueryServiceControl struct {
 
 // NewQueryServiceControl returns a real implementation of QueryServiceControl
 func NewQueryServiceControl() QueryServiceControl {
+	if qsConfig.TwoPCEnable {
+		if err := tabletconn.CheckTwoPC(); err != nil {
+			log.Fatalf("cannot use -twopc-enable: %v", err)
+		}
+	}
 	return &realQueryServiceControl{
 		sqlQueryRPCService: NewSqlQuery(qsConfig),
 	}
@@ -345,7 +372,7 @@ func (rqsc *realQueryServiceControl) registerCheckMySQL() {
 }
 
 // SetQueryRules is the tabletserver level API to write current query rules
-func (rqsc *realQueryServiceControl) SetQueryRules(ruleSource string, qrs *QueryRules) error {
+func (rqsc *realQueryServiceControl) SetQueryRules(ruleSource string, qrs *queryrules.QueryRules) error {
 	err := QueryRuleSources.SetRules(ruleSource, qrs)
 	if err != nil {
 		return err
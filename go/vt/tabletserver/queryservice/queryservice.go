@@ -24,6 +24,23 @@ type QueryService interface {
 	Commit(ctx context.Context, session *proto.Session) error
 	Rollback(ctx context.Context, session *proto.Session) error
 
+	// Two-phase commit: the participants use Prepare, CommitPrepared
+	// and RollbackPrepared. The metadata manager uses the rest.
+	Prepare(ctx context.Context, req *proto.DistributedTxRequest) error
+	CommitPrepared(ctx context.Context, req *proto.DistributedTxRequest) error
+	RollbackPrepared(ctx context.Context, req *proto.DistributedTxRequest) error
+	CreateTransaction(ctx context.Context, req *proto.DistributedTxRequest) error
+	StartCommit(ctx context.Context, req *proto.DistributedTxRequest) error
+	SetRollback(ctx context.Context, req *proto.DistributedTxRequest) error
+	ConcludeTransaction(ctx context.Context, req *proto.DistributedTxRequest) error
+	ReadTransaction(ctx context.Context, req *proto.DistributedTxRequest, metadata *proto.TransactionMetadata) error
+
+	// Replication positions, for read-after-write consistency:
+	// MasterPosition is sent to the master after a commit, and
+	// WaitForPosition to a replica before it serves a read.
+	MasterPosition(ctx context.Context, req *proto.PositionRequest, position *string) error
+	WaitForPosition(ctx context.Context, req *proto.PositionRequest) error
+
 	// Query execution
 	Execute(ctx context.Context, query *proto.Query, reply *mproto.QueryResult) error
 	StreamExecute(ctx context.Context, query *proto.Query, sendReply func(*mproto.QueryResult) error) error
@@ -63,6 +80,56 @@ func (e *ErrorQueryService) Rollback(ctx context.Context, session *proto.Session
 	return fmt.Errorf("ErrorQueryService does not implement any method")
 }
 
+// Prepare is part of QueryService interface
+func (e *ErrorQueryService) Prepare(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// CommitPrepared is part of QueryService interface
+func (e *ErrorQueryService) CommitPrepared(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// RollbackPrepared is part of QueryService interface
+func (e *ErrorQueryService) RollbackPrepared(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// CreateTransaction is part of QueryService interface
+func (e *ErrorQueryService) CreateTransaction(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// StartCommit is part of QueryService interface
+func (e *ErrorQueryService) StartCommit(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// SetRollback is part of QueryService interface
+func (e *ErrorQueryService) SetRollback(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// ConcludeTransaction is part of QueryService interface
+func (e *ErrorQueryService) ConcludeTransaction(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// ReadTransaction is part of QueryService interface
+func (e *ErrorQueryService) ReadTransaction(ctx context.Context, req *proto.DistributedTxRequest, metadata *proto.TransactionMetadata) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// MasterPosition is part of QueryService interface
+func (e *ErrorQueryService) MasterPosition(ctx context.Context, req *proto.PositionRequest, position *string) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
+// WaitForPosition is part of QueryService interface
+func (e *ErrorQueryService) WaitForPosition(ctx context.Context, req *proto.PositionRequest) error {
+	return fmt.Errorf("ErrorQueryService does not implement any method")
+}
+
 // Execute is part of QueryService interface
 func (e *ErrorQueryService) Execute(ctx context.Context, query *proto.Query, reply *mproto.QueryResult) error {
 	return fmt.Errorf("ErrorQueryService does not implement any method")
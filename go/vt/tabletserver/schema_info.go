@@ -20,6 +20,7 @@ import (
 	"github.com/youtube/vitess/go/sqldb"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/queryrules"
 	"github.com/youtube/vitess/go/vt/schema"
 	"github.com/youtube/vitess/go/vt/tableacl"
 	tacl "github.com/youtube/vitess/go/vt/tableacl/acl"
@@ -44,7 +45,7 @@ type ExecPlan struct {
 	*planbuilder.ExecPlan
 	TableInfo  *TableInfo
 	Fields     []mproto.Field
-	Rules      *QueryRules
+	Rules      *queryrules.QueryRules
 	Authorized tacl.ACL
 
 	mu         sync.Mutex
@@ -395,7 +396,7 @@ func (si *SchemaInfo) GetPlan(ctx context.Context, logStats *SQLQueryStats, sql
 		panic(NewTabletError(ErrFail, "%s", err))
 	}
 	plan := &ExecPlan{ExecPlan: splan, TableInfo: tableInfo}
-	plan.Rules = QueryRuleSources.filterByPlan(sql, plan.PlanId, plan.TableName)
+	plan.Rules = QueryRuleSources.FilterByPlan(sql, int(plan.PlanId), "", plan.TableName)
 	plan.Authorized = tableacl.Authorized(plan.TableName, plan.PlanId.MinRole())
 	if plan.PlanId.IsSelect() {
 		if plan.FieldQuery == nil {
@@ -437,7 +438,7 @@ func (si *SchemaInfo) GetStreamPlan(sql string) *ExecPlan {
 		panic(NewTabletError(ErrFail, "%s", err))
 	}
 	plan := &ExecPlan{ExecPlan: splan, TableInfo: tableInfo}
-	plan.Rules = QueryRuleSources.filterByPlan(sql, plan.PlanId, plan.TableName)
+	plan.Rules = QueryRuleSources.FilterByPlan(sql, int(plan.PlanId), "", plan.TableName)
 	plan.Authorized = tableacl.Authorized(plan.TableName, plan.PlanId.MinRole())
 	return plan
 }
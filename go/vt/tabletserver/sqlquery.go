@@ -15,11 +15,13 @@ import (
 	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/mysql"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/tb"
 	"github.com/youtube/vitess/go/vt/dbconfigs"
 	"github.com/youtube/vitess/go/vt/dbconnpool"
 	"github.com/youtube/vitess/go/vt/mysqlctl"
+	myproto "github.com/youtube/vitess/go/vt/mysqlctl/proto"
 	"github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"golang.org/x/net/context"
 )
@@ -88,6 +90,7 @@ type SqlQuery struct {
 	qe        *QueryEngine
 	sessionID int64
 	dbconfig  *dbconfigs.DBConfig
+	mysqld    mysqlctl.MysqlDaemon
 }
 
 // NewSqlQuery creates an instance of SqlQuery. Only one instance
@@ -172,6 +175,7 @@ func (sq *SqlQuery) allowQueries(dbconfigs *dbconfigs.DBConfigs, schemaOverrides
 
 	sq.qe.Open(dbconfigs, schemaOverrides, mysqld)
 	sq.dbconfig = &dbconfigs.App
+	sq.mysqld = mysqld
 	sq.sessionID = Rand()
 	log.Infof("Session id: %d", sq.sessionID)
 	return nil
@@ -332,6 +336,147 @@ func (sq *SqlQuery) Rollback(ctx context.Context, session *proto.Session) (err e
 	return nil
 }
 
+// Prepare prepares the specified transaction for a two-phase commit.
+func (sq *SqlQuery) Prepare(ctx context.Context, req *proto.DistributedTxRequest) (err error) {
+	return sq.execTwoPC(ctx, "Prepare", req, true, func(ctx context.Context, logStats *SQLQueryStats) {
+		sq.qe.twoPC.Prepare(ctx, req.TransactionId, req.Dtid)
+	})
+}
+
+// CommitPrepared commits the prepared transaction of a distributed transaction.
+func (sq *SqlQuery) CommitPrepared(ctx context.Context, req *proto.DistributedTxRequest) (err error) {
+	return sq.execTwoPC(ctx, "CommitPrepared", req, true, func(ctx context.Context, logStats *SQLQueryStats) {
+		sq.qe.twoPC.CommitPrepared(ctx, logStats, req.Dtid)
+	})
+}
+
+// RollbackPrepared rolls back the prepared transaction of a distributed
+// transaction. If it was not prepared, the transaction specified by
+// req.TransactionId is rolled back instead.
+func (sq *SqlQuery) RollbackPrepared(ctx context.Context, req *proto.DistributedTxRequest) (err error) {
+	return sq.execTwoPC(ctx, "RollbackPrepared", req, true, func(ctx context.Context, logStats *SQLQueryStats) {
+		sq.qe.twoPC.RollbackPrepared(ctx, req.Dtid, req.TransactionId)
+	})
+}
+
+// CreateTransaction records the metadata of a distributed transaction.
+func (sq *SqlQuery) CreateTransaction(ctx context.Context, req *proto.DistributedTxRequest) (err error) {
+	return sq.execTwoPC(ctx, "CreateTransaction", req, false, func(ctx context.Context, logStats *SQLQueryStats) {
+		sq.qe.twoPC.CreateTransaction(ctx, req.Dtid, req.Participants)
+	})
+}
+
+// StartCommit commits the specified transaction along with the decision
+// to commit the distributed transaction.
+func (sq *SqlQuery) StartCommit(ctx context.Context, req *proto.DistributedTxRequest) (err error) {
+	return sq.execTwoPC(ctx, "StartCommit", req, true, func(ctx context.Context, logStats *SQLQueryStats) {
+		sq.qe.twoPC.StartCommit(ctx, logStats, req.TransactionId, req.Dtid)
+	})
+}
+
+// SetRollback records the decision to roll back the distributed
+// transaction, and rolls back the specified transaction.
+func (sq *SqlQuery) SetRollback(ctx context.Context, req *proto.DistributedTxRequest) (err error) {
+	return sq.execTwoPC(ctx, "SetRollback", req, true, func(ctx context.Context, logStats *SQLQueryStats) {
+		sq.qe.twoPC.SetRollback(ctx, req.Dtid, req.TransactionId)
+	})
+}
+
+// ConcludeTransaction deletes the metadata of a resolved distributed transaction.
+func (sq *SqlQuery) ConcludeTransaction(ctx context.Context, req *proto.DistributedTxRequest) (err error) {
+	return sq.execTwoPC(ctx, "ConcludeTransaction", req, true, func(ctx context.Context, logStats *SQLQueryStats) {
+		sq.qe.twoPC.ConcludeTransaction(ctx, req.Dtid)
+	})
+}
+
+// ReadTransaction returns the metadata of a distributed transaction.
+func (sq *SqlQuery) ReadTransaction(ctx context.Context, req *proto.DistributedTxRequest, metadata *proto.TransactionMetadata) (err error) {
+	return sq.execTwoPC(ctx, "ReadTransaction", req, true, func(ctx context.Context, logStats *SQLQueryStats) {
+		*metadata = *sq.qe.twoPC.ReadTransaction(ctx, req.Dtid)
+	})
+}
+
+// execTwoPC performs the request handling that's common to the
+// two-phase commit calls, and runs f, which can panic with a TabletError.
+func (sq *SqlQuery) execTwoPC(ctx context.Context, name string, req *proto.DistributedTxRequest, allowShutdown bool, f func(ctx context.Context, logStats *SQLQueryStats)) (err error) {
+	logStats := newSqlQueryStats(name, ctx)
+	logStats.OriginalSql = req.Dtid
+	logStats.TransactionID = req.TransactionId
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(req.SessionId, false, allowShutdown); err != nil {
+		return err
+	}
+	ctx, cancel := withTimeout(ctx, sq.qe.queryTimeout.Get())
+	defer func(start time.Time) {
+		sq.qe.queryServiceStats.QueryStats.Record(strings.ToUpper(name), start)
+		cancel()
+		sq.endRequest()
+	}(time.Now())
+
+	f(ctx, logStats)
+	return nil
+}
+
+// MasterPosition returns the current replication position of mysql.
+// It's called on the master after a commit, so that the replicas
+// can wait for the transaction before serving a read.
+func (sq *SqlQuery) MasterPosition(ctx context.Context, req *proto.PositionRequest, position *string) (err error) {
+	logStats := newSqlQueryStats("MasterPosition", ctx)
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(req.SessionId, false, true); err != nil {
+		return err
+	}
+	defer func(start time.Time) {
+		sq.qe.queryServiceStats.QueryStats.Record("MASTER_POSITION", start)
+		sq.endRequest()
+	}(time.Now())
+
+	if sq.mysqld == nil {
+		return NewTabletError(ErrFail, "MasterPosition: mysqld is not available")
+	}
+	pos, err := sq.mysqld.MasterPosition()
+	if err != nil {
+		return NewTabletError(ErrFail, "MasterPosition: %v", err)
+	}
+	*position = myproto.EncodeReplicationPosition(pos)
+	return nil
+}
+
+// WaitForPosition waits until mysql has replicated up to req.Position,
+// for at most req.Timeout, or the query timeout if it's not set.
+func (sq *SqlQuery) WaitForPosition(ctx context.Context, req *proto.PositionRequest) (err error) {
+	logStats := newSqlQueryStats("WaitForPosition", ctx)
+	logStats.OriginalSql = req.Position
+	defer handleError(&err, logStats, sq.qe.queryServiceStats)
+
+	if err = sq.startRequest(req.SessionId, false, false); err != nil {
+		return err
+	}
+	defer func(start time.Time) {
+		sq.qe.queryServiceStats.QueryStats.Record("WAIT_FOR_POSITION", start)
+		sq.endRequest()
+	}(time.Now())
+
+	if sq.mysqld == nil {
+		return NewTabletError(ErrFail, "WaitForPosition: mysqld is not available")
+	}
+	pos, err := myproto.DecodeReplicationPosition(req.Position)
+	if err != nil {
+		return NewTabletError(ErrFail, "WaitForPosition: invalid position %v: %v", req.Position, err)
+	}
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = sq.qe.queryTimeout.Get()
+	}
+	if err := sq.mysqld.WaitMasterPos(pos, timeout); err != nil {
+		// The read can be served by a replica that caught up.
+		return NewTabletError(ErrRetry, "WaitForPosition: %v", err)
+	}
+	return nil
+}
+
 // handleExecError handles panics during query execution and sets
 // the supplied error return value.
 func (sq *SqlQuery) handleExecError(query *proto.Query, err *error, logStats *SQLQueryStats) {
@@ -529,12 +674,30 @@ func (sq *SqlQuery) SplitQuery(ctx context.Context, req *proto.SplitQueryRequest
 	// TODO: For fetching MinMax, include where clauses on the
 	// primary key, if any, in the original query which might give a narrower
 	// range of split column to work with.
-	minMaxSql := fmt.Sprintf("SELECT MIN(%v), MAX(%v) FROM %v", splitter.splitColumn, splitter.splitColumn, splitter.tableName)
+	// Only the leading split column is divided into ranges.
+	minMaxSql := fmt.Sprintf("SELECT MIN(%v), MAX(%v) FROM %v", splitter.splitColumns[0], splitter.splitColumns[0], splitter.tableName)
 	splitColumnMinMax := qre.execSQL(conn, minMaxSql, true)
-	reply.Queries, err = splitter.split(splitColumnMinMax)
+	if !splitter.needsSampling(splitColumnMinMax) {
+		reply.Queries, err = splitter.split(splitColumnMinMax)
+		if err != nil {
+			return NewTabletError(ErrFail, "splitQuery: query split error: %s, request: %#v", err, req)
+		}
+		return nil
+	}
+	// The boundaries of split columns that start with a
+	// string or binary column are sampled from the table.
+	rowCount := qre.execSQL(conn, splitter.rowCountSQL(), true)
+	sampleSqls, err := splitter.sampleSQLs(rowCount)
 	if err != nil {
 		return NewTabletError(ErrFail, "splitQuery: query split error: %s, request: %#v", err, req)
 	}
+	samples := make([][]sqltypes.Value, 0, len(sampleSqls))
+	for _, sampleSql := range sampleSqls {
+		if qr := qre.execSQL(conn, sampleSql, true); len(qr.Rows) == 1 {
+			samples = append(samples, qr.Rows[0])
+		}
+	}
+	reply.Queries = splitter.splitSamples(samples)
 	return nil
 }
 
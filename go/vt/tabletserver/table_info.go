@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 
 	log "github.com/golang/glog"
 	"github.com/youtube/vitess/go/sqltypes"
@@ -21,6 +22,13 @@ type TableInfo struct {
 	Cache *RowCache
 	// stats updated by sqlquery.go
 	hits, absent, misses, invalidations sync2.AtomicInt64
+
+	// Seq must be locked before accessing the sequence vars.
+	// NextVal is the next value to be returned, and LastVal
+	// is the first value that's not reserved from the table.
+	Seq     sync.Mutex
+	NextVal int64
+	LastVal int64
 }
 
 func NewTableInfo(conn *DBConn, tableName string, tableType string, createTime sqltypes.Value, comment string, cachePool *CachePool) (ti *TableInfo, err error) {
@@ -28,6 +36,11 @@ func NewTableInfo(conn *DBConn, tableName string, tableType string, createTime s
 	if err != nil {
 		return nil, err
 	}
+	if strings.Contains(comment, "vitess_sequence") {
+		log.Infof("%s commented as vitess_sequence. Will not be cached.", tableName)
+		ti.Type = schema.SEQUENCE
+		return ti, nil
+	}
 	ti.initRowCache(conn, tableType, createTime, comment, cachePool)
 	return ti, nil
 }
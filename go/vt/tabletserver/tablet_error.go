@@ -310,6 +310,24 @@ func AddTabletErrorToRollbackResponse(err error, reply *proto.RollbackResponse)
 	reply.Err = rpcErrFromTabletError(err)
 }
 
+// AddTabletErrorToDistributedTxResponse will mutate a DistributedTxResponse
+// struct to fill in the Err field with details from the TabletError.
+func AddTabletErrorToDistributedTxResponse(err error, reply *proto.DistributedTxResponse) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromTabletError(err)
+}
+
+// AddTabletErrorToPositionResponse will mutate a PositionResponse
+// struct to fill in the Err field with details from the TabletError.
+func AddTabletErrorToPositionResponse(err error, reply *proto.PositionResponse) {
+	if err == nil {
+		return
+	}
+	reply.Err = rpcErrFromTabletError(err)
+}
+
 // TabletErrorToRPCError transforms the provided error to a RPCError,
 // if any.
 func TabletErrorToRPCError(err error) *vtrpc.RPCError {
@@ -6,6 +6,7 @@ package tabletconn
 
 import (
 	"flag"
+	"fmt"
 	"time"
 
 	log "github.com/golang/glog"
@@ -76,6 +77,25 @@ type TabletConn interface {
 	Commit(context context.Context, transactionId int64) error
 	Rollback(context context.Context, transactionId int64) error
 
+	// Two-phase commit support. Prepare, CommitPrepared and
+	// RollbackPrepared are sent to the participants. The other
+	// calls are sent to the metadata manager.
+	Prepare(context context.Context, transactionId int64, dtid string) error
+	CommitPrepared(context context.Context, dtid string) error
+	RollbackPrepared(context context.Context, dtid string, originalId int64) error
+	CreateTransaction(context context.Context, dtid string, participants []tproto.TxParticipant) error
+	StartCommit(context context.Context, transactionId int64, dtid string) error
+	SetRollback(context context.Context, dtid string, transactionId int64) error
+	ConcludeTransaction(context context.Context, dtid string) error
+	ReadTransaction(context context.Context, dtid string) (*tproto.TransactionMetadata, error)
+
+	// Read-after-write support. MasterPosition returns the
+	// replication position of the master after a commit.
+	// WaitForPosition makes a replica wait for at most timeout
+	// until it has replicated up to position.
+	MasterPosition(context context.Context) (string, error)
+	WaitForPosition(context context.Context, position string, timeout time.Duration) error
+
 	// These should not be used for anything except tests for now; they will eventually
 	// replace the existing methods.
 	Begin2(context context.Context) (transactionId int64, err error)
@@ -98,6 +118,9 @@ type ErrFunc func() error
 
 var dialers = make(map[string]TabletDialer)
 
+// noTwoPC lists the protocols that cannot carry the two-phase commit calls.
+var noTwoPC = make(map[string]bool)
+
 // RegisterDialer is meant to be used by TabletDialer implementations
 // to self register.
 func RegisterDialer(name string, dialer TabletDialer) {
@@ -115,3 +138,18 @@ func GetDialer() TabletDialer {
 	}
 	return td
 }
+
+// RegisterNoTwoPC is meant to be used by TabletDialer implementations
+// whose protocol doesn't support the two-phase commit calls.
+func RegisterNoTwoPC(name string) {
+	noTwoPC[name] = true
+}
+
+// CheckTwoPC returns an error if the tablet protocol described by the
+// command line flag doesn't support two-phase commit.
+func CheckTwoPC() error {
+	if noTwoPC[*tabletProtocol] {
+		return fmt.Errorf("tablet protocol %s doesn't support two-phase commit", *tabletProtocol)
+	}
+	return nil
+}
@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/sqltypes"
@@ -832,6 +833,286 @@ func testSplitQueryPanics(t *testing.T, conn tabletconn.TabletConn) {
 	}
 }
 
+// checkDistributedTx verifies the request of a two-phase commit call.
+func (f *FakeQueryService) checkDistributedTx(name string, req *proto.DistributedTxRequest, transactionID int64) error {
+	if f.hasError {
+		return testTabletError
+	}
+	if f.panics {
+		panic(fmt.Errorf("test-triggered panic"))
+	}
+	if req.SessionId != testSessionID {
+		f.t.Errorf("%s: invalid SessionId: got %v expected %v", name, req.SessionId, testSessionID)
+	}
+	if req.TransactionId != transactionID {
+		f.t.Errorf("%s: invalid TransactionId: got %v expected %v", name, req.TransactionId, transactionID)
+	}
+	if req.Dtid != twoPCDtid {
+		f.t.Errorf("%s: invalid Dtid: got %v expected %v", name, req.Dtid, twoPCDtid)
+	}
+	return nil
+}
+
+// Prepare is part of the queryservice.QueryService interface
+func (f *FakeQueryService) Prepare(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return f.checkDistributedTx("Prepare", req, twoPCTransactionID)
+}
+
+// CommitPrepared is part of the queryservice.QueryService interface
+func (f *FakeQueryService) CommitPrepared(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return f.checkDistributedTx("CommitPrepared", req, 0)
+}
+
+// RollbackPrepared is part of the queryservice.QueryService interface
+func (f *FakeQueryService) RollbackPrepared(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return f.checkDistributedTx("RollbackPrepared", req, twoPCTransactionID)
+}
+
+// CreateTransaction is part of the queryservice.QueryService interface
+func (f *FakeQueryService) CreateTransaction(ctx context.Context, req *proto.DistributedTxRequest) error {
+	if err := f.checkDistributedTx("CreateTransaction", req, 0); err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(req.Participants, twoPCParticipants) {
+		f.t.Errorf("CreateTransaction: invalid Participants: got %v expected %v", req.Participants, twoPCParticipants)
+	}
+	return nil
+}
+
+// StartCommit is part of the queryservice.QueryService interface
+func (f *FakeQueryService) StartCommit(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return f.checkDistributedTx("StartCommit", req, twoPCTransactionID)
+}
+
+// SetRollback is part of the queryservice.QueryService interface
+func (f *FakeQueryService) SetRollback(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return f.checkDistributedTx("SetRollback", req, twoPCTransactionID)
+}
+
+// ConcludeTransaction is part of the queryservice.QueryService interface
+func (f *FakeQueryService) ConcludeTransaction(ctx context.Context, req *proto.DistributedTxRequest) error {
+	return f.checkDistributedTx("ConcludeTransaction", req, 0)
+}
+
+// ReadTransaction is part of the queryservice.QueryService interface
+func (f *FakeQueryService) ReadTransaction(ctx context.Context, req *proto.DistributedTxRequest, metadata *proto.TransactionMetadata) error {
+	if err := f.checkDistributedTx("ReadTransaction", req, 0); err != nil {
+		return err
+	}
+	*metadata = twoPCMetadata
+	return nil
+}
+
+const twoPCDtid = "aa:-80:1234"
+
+const twoPCTransactionID int64 = 1234
+
+var twoPCParticipants = []proto.TxParticipant{
+	{Keyspace: "aa", Shard: "80-"},
+	{Keyspace: "bb", Shard: "0"},
+}
+
+var twoPCMetadata = proto.TransactionMetadata{
+	Dtid:         twoPCDtid,
+	State:        proto.DTStateCommit,
+	TimeCreated:  1,
+	Participants: twoPCParticipants,
+}
+
+// twoPCCalls returns a function for each two-phase commit call
+// of conn, so they can be tested together.
+func twoPCCalls(conn tabletconn.TabletConn) map[string]func(ctx context.Context) error {
+	return map[string]func(ctx context.Context) error{
+		"Prepare": func(ctx context.Context) error {
+			return conn.Prepare(ctx, twoPCTransactionID, twoPCDtid)
+		},
+		"CommitPrepared": func(ctx context.Context) error {
+			return conn.CommitPrepared(ctx, twoPCDtid)
+		},
+		"RollbackPrepared": func(ctx context.Context) error {
+			return conn.RollbackPrepared(ctx, twoPCDtid, twoPCTransactionID)
+		},
+		"CreateTransaction": func(ctx context.Context) error {
+			return conn.CreateTransaction(ctx, twoPCDtid, twoPCParticipants)
+		},
+		"StartCommit": func(ctx context.Context) error {
+			return conn.StartCommit(ctx, twoPCTransactionID, twoPCDtid)
+		},
+		"SetRollback": func(ctx context.Context) error {
+			return conn.SetRollback(ctx, twoPCDtid, twoPCTransactionID)
+		},
+		"ConcludeTransaction": func(ctx context.Context) error {
+			return conn.ConcludeTransaction(ctx, twoPCDtid)
+		},
+		"ReadTransaction": func(ctx context.Context) error {
+			_, err := conn.ReadTransaction(ctx, twoPCDtid)
+			return err
+		},
+	}
+}
+
+func testTwoPC(t *testing.T, conn tabletconn.TabletConn) {
+	t.Log("testTwoPC")
+	ctx := context.Background()
+	for name, call := range twoPCCalls(conn) {
+		if err := call(ctx); err != nil {
+			t.Errorf("%s failed: %v", name, err)
+		}
+	}
+	md, err := conn.ReadTransaction(ctx, twoPCDtid)
+	if err != nil {
+		t.Fatalf("ReadTransaction failed: %v", err)
+	}
+	if !reflect.DeepEqual(*md, twoPCMetadata) {
+		t.Errorf("Unexpected result from ReadTransaction: got %v wanted %v", *md, twoPCMetadata)
+	}
+}
+
+func testTwoPCError(t *testing.T, conn tabletconn.TabletConn) {
+	t.Log("testTwoPCError")
+	ctx := context.Background()
+	for name, call := range twoPCCalls(conn) {
+		err := call(ctx)
+		if err == nil {
+			t.Errorf("%s was expecting an error, didn't get one", name)
+			continue
+		}
+		if !strings.Contains(err.Error(), expectedErrMatch) {
+			t.Errorf("Unexpected error from %s: got %v, wanted err containing %v", name, err, expectedErrMatch)
+		}
+	}
+}
+
+func testTwoPCPanics(t *testing.T, conn tabletconn.TabletConn) {
+	t.Log("testTwoPCPanics")
+	ctx := context.Background()
+	for name, call := range twoPCCalls(conn) {
+		if err := call(ctx); err == nil || !strings.Contains(err.Error(), "caught test panic") {
+			t.Errorf("unexpected panic error from %s: %v", name, err)
+		}
+	}
+}
+
+// TestTwoPCSuite runs the tests of the two-phase commit calls,
+// for the protocols that support them.
+func TestTwoPCSuite(t *testing.T, conn tabletconn.TabletConn, fake *FakeQueryService) {
+	testTwoPC(t, conn)
+
+	fake.hasError = true
+	testTwoPCError(t, conn)
+	fake.hasError = false
+
+	fake.panics = true
+	testTwoPCPanics(t, conn)
+	fake.panics = false
+}
+
+// checkPosition verifies the request of a replication position call.
+func (f *FakeQueryService) checkPosition(name string, req *proto.PositionRequest, position string, timeout time.Duration) error {
+	if f.hasError {
+		return testTabletError
+	}
+	if f.panics {
+		panic(fmt.Errorf("test-triggered panic"))
+	}
+	if req.SessionId != testSessionID {
+		f.t.Errorf("%s: invalid SessionId: got %v expected %v", name, req.SessionId, testSessionID)
+	}
+	if req.Position != position {
+		f.t.Errorf("%s: invalid Position: got %v expected %v", name, req.Position, position)
+	}
+	if req.Timeout != timeout {
+		f.t.Errorf("%s: invalid Timeout: got %v expected %v", name, req.Timeout, timeout)
+	}
+	return nil
+}
+
+// MasterPosition is part of the queryservice.QueryService interface
+func (f *FakeQueryService) MasterPosition(ctx context.Context, req *proto.PositionRequest, position *string) error {
+	if err := f.checkPosition("MasterPosition", req, "", 0); err != nil {
+		return err
+	}
+	*position = testPosition
+	return nil
+}
+
+// WaitForPosition is part of the queryservice.QueryService interface
+func (f *FakeQueryService) WaitForPosition(ctx context.Context, req *proto.PositionRequest) error {
+	return f.checkPosition("WaitForPosition", req, testPosition, testPositionTimeout)
+}
+
+const testPosition = "MySQL56/33333333-3333-3333-3333-333333333333:1-123"
+
+const testPositionTimeout = 2 * time.Second
+
+// positionCalls returns a function for each replication position call
+// of conn.
+func positionCalls(conn tabletconn.TabletConn) map[string]func(ctx context.Context) error {
+	return map[string]func(ctx context.Context) error{
+		"MasterPosition": func(ctx context.Context) error {
+			_, err := conn.MasterPosition(ctx)
+			return err
+		},
+		"WaitForPosition": func(ctx context.Context) error {
+			return conn.WaitForPosition(ctx, testPosition, testPositionTimeout)
+		},
+	}
+}
+
+func testReadAfterWrite(t *testing.T, conn tabletconn.TabletConn) {
+	t.Log("testReadAfterWrite")
+	ctx := context.Background()
+	position, err := conn.MasterPosition(ctx)
+	if err != nil {
+		t.Fatalf("MasterPosition failed: %v", err)
+	}
+	if position != testPosition {
+		t.Errorf("Unexpected result from MasterPosition: got %v wanted %v", position, testPosition)
+	}
+	if err := conn.WaitForPosition(ctx, testPosition, testPositionTimeout); err != nil {
+		t.Errorf("WaitForPosition failed: %v", err)
+	}
+}
+
+func testReadAfterWriteError(t *testing.T, conn tabletconn.TabletConn) {
+	t.Log("testReadAfterWriteError")
+	ctx := context.Background()
+	for name, call := range positionCalls(conn) {
+		err := call(ctx)
+		if err == nil {
+			t.Errorf("%s was expecting an error, didn't get one", name)
+			continue
+		}
+		if !strings.Contains(err.Error(), expectedErrMatch) {
+			t.Errorf("Unexpected error from %s: got %v, wanted err containing %v", name, err, expectedErrMatch)
+		}
+	}
+}
+
+func testReadAfterWritePanics(t *testing.T, conn tabletconn.TabletConn) {
+	t.Log("testReadAfterWritePanics")
+	ctx := context.Background()
+	for name, call := range positionCalls(conn) {
+		if err := call(ctx); err == nil || !strings.Contains(err.Error(), "caught test panic") {
+			t.Errorf("unexpected panic error from %s: %v", name, err)
+		}
+	}
+}
+
+// TestReadAfterWriteSuite runs the tests of the replication position
+// calls, for the protocols that support them.
+func TestReadAfterWriteSuite(t *testing.T, conn tabletconn.TabletConn, fake *FakeQueryService) {
+	testReadAfterWrite(t, conn)
+
+	fake.hasError = true
+	testReadAfterWriteError(t, conn)
+	fake.hasError = false
+
+	fake.panics = true
+	testReadAfterWritePanics(t, conn)
+	fake.panics = false
+}
+
 // CreateFakeServer returns the fake server for the tests
 func CreateFakeServer(t *testing.T) *FakeQueryService {
 	// Make the synchronization channels on init, so there's no state shared between servers
@@ -0,0 +1,487 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqldb"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/timer"
+	"github.com/youtube/vitess/go/vt/dbconnpool"
+	"github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"golang.org/x/net/context"
+)
+
+// These are the states of a redo log.
+const (
+	// RedoStateFailed means that the transaction could not be
+	// prepared again from its redo log after a restart.
+	RedoStateFailed = 0
+	// RedoStatePrepared means that the transaction is prepared.
+	RedoStatePrepared = 1
+)
+
+// CreateTwoPCTables returns the statements required to create the
+// tables used by two-phase commits. redo_state and redo_statement
+// contain the redo logs of the prepared transactions of a participant.
+// dt_state and dt_participant contain the metadata of the distributed
+// transactions, and are only used on their metadata manager.
+func CreateTwoPCTables() []string {
+	return []string{
+		"CREATE DATABASE IF NOT EXISTS _vt",
+		`CREATE TABLE IF NOT EXISTS _vt.redo_state (
+  dtid VARBINARY(512) NOT NULL,
+  state BIGINT NOT NULL,
+  time_created BIGINT NOT NULL,
+  PRIMARY KEY (dtid)) ENGINE=InnoDB`,
+		`CREATE TABLE IF NOT EXISTS _vt.redo_statement (
+  dtid VARBINARY(512) NOT NULL,
+  id BIGINT NOT NULL,
+  statement MEDIUMBLOB NOT NULL,
+  PRIMARY KEY (dtid, id)) ENGINE=InnoDB`,
+		`CREATE TABLE IF NOT EXISTS _vt.dt_state (
+  dtid VARBINARY(512) NOT NULL,
+  state BIGINT NOT NULL,
+  time_created BIGINT NOT NULL,
+  PRIMARY KEY (dtid)) ENGINE=InnoDB`,
+		`CREATE TABLE IF NOT EXISTS _vt.dt_participant (
+  dtid VARBINARY(512) NOT NULL,
+  id BIGINT NOT NULL,
+  keyspace VARCHAR(256) NOT NULL,
+  shard VARCHAR(256) NOT NULL,
+  PRIMARY KEY (dtid, id)) ENGINE=InnoDB`,
+	}
+}
+
+// TxParticipantResolver is used by the metadata manager of a distributed
+// transaction to commit or roll back its participants when the
+// transaction is abandoned. It's set by the tablet manager, which knows
+// how to reach the other shards. Abandoned transactions are left
+// alone if it's not set.
+var TxParticipantResolver func(ctx context.Context, participant proto.TxParticipant, dtid string, commit bool) error
+
+// TwoPC performs the vttablet side of two-phase commits.
+//
+// Participants (resource managers) prepare their transactions by
+// saving their statements in a redo log, and keeping them open
+// in the TxPool until they're told to commit or roll back. If vttablet
+// restarts, the transactions are prepared again from their redo logs.
+//
+// The metadata manager of a distributed transaction records its
+// participants and its state. Its own transaction commits atomically
+// with the transition to the COMMIT state. If a distributed transaction
+// is abandoned, the metadata manager resolves it by itself.
+type TwoPC struct {
+	qe         *QueryEngine
+	enabled    bool
+	abandonAge time.Duration
+	ticks      *timer.Timer
+	// now returns the current time. It's replaced by the tests.
+	now func() time.Time
+
+	// mu protects dbaParams and replayed.
+	mu        sync.Mutex
+	dbaParams *sqldb.ConnParams
+	// replayed is set once the redo logs were replayed,
+	// which happens the first time MySQL is writable.
+	replayed bool
+}
+
+// NewTwoPC creates a TwoPC for qe. It's not operational until it's Open'd.
+func NewTwoPC(qe *QueryEngine, enabled bool, abandonAge time.Duration) *TwoPC {
+	return &TwoPC{
+		qe:         qe,
+		enabled:    enabled,
+		abandonAge: abandonAge,
+		ticks:      timer.NewTimer(abandonAge / 2),
+		now:        time.Now,
+	}
+}
+
+// Open prepares the transactions that were prepared before the last
+// shutdown again, and starts the watchdog that resolves abandoned
+// distributed transactions. It must be called after the TxPool is
+// opened. It does nothing if two-phase commit is disabled. Only the
+// master can have prepared transactions: if MySQL is read-only, the
+// watchdog replays them once it becomes writable, so a replica that
+// gets promoted takes over the prepared transactions of the old master.
+func (tpc *TwoPC) Open(dbaParams *sqldb.ConnParams) {
+	if !tpc.enabled {
+		return
+	}
+	tpc.mu.Lock()
+	tpc.dbaParams = dbaParams
+	tpc.replayed = false
+	tpc.mu.Unlock()
+	tpc.replayRedo(context.Background())
+	tpc.ticks.Start(func() { tpc.watchdog() })
+}
+
+// Close stops the watchdog. The prepared transactions are
+// closed by the TxPool.
+func (tpc *TwoPC) Close() {
+	tpc.ticks.Stop()
+}
+
+// watchdog replays the redo logs if it wasn't done yet, and
+// resolves the abandoned transactions once MySQL is writable.
+func (tpc *TwoPC) watchdog() {
+	defer logError(tpc.qe.queryServiceStats)
+	if !tpc.replayRedo(context.Background()) {
+		return
+	}
+	tpc.resolveAbandoned()
+}
+
+// replayRedo creates the two-phase commit tables if needed, and
+// prepares the transactions of the redo logs again, the first time
+// it's called while MySQL is writable. It returns false while MySQL
+// is read-only.
+func (tpc *TwoPC) replayRedo(ctx context.Context) bool {
+	tpc.mu.Lock()
+	defer tpc.mu.Unlock()
+	if tpc.replayed {
+		return true
+	}
+	conn, err := dbconnpool.NewDBConnection(tpc.dbaParams, tpc.qe.queryServiceStats.MySQLStats)
+	if err != nil {
+		panic(NewTabletErrorSql(ErrFatal, err))
+	}
+	defer conn.Close()
+	qr, err := conn.ExecuteFetch("select @@global.read_only", 1, false)
+	if err != nil {
+		panic(NewTabletErrorSql(ErrFatal, err))
+	}
+	if len(qr.Rows) != 1 || qr.Rows[0][0].String() != "0" {
+		log.Infof("MySQL is read-only, not resolving prepared transactions")
+		return false
+	}
+	for _, sql := range CreateTwoPCTables() {
+		if _, err := conn.ExecuteFetch(sql, 0, false); err != nil {
+			panic(NewTabletErrorSql(ErrFatal, err))
+		}
+	}
+	tpc.prepareFromRedo(ctx)
+	tpc.replayed = true
+	return true
+}
+
+func (tpc *TwoPC) checkEnabled() {
+	if !tpc.enabled {
+		panic(NewTabletError(ErrFail, "two-phase commit is not enabled"))
+	}
+}
+
+// Prepare saves the statements of the transaction in its redo log,
+// and moves it to the prepared transactions of the TxPool.
+// The redo log is saved in a separate transaction, so that the prepared
+// transaction can be replayed if MySQL or vttablet restarts.
+func (tpc *TwoPC) Prepare(ctx context.Context, transactionID int64, dtid string) {
+	tpc.checkEnabled()
+	conn := tpc.qe.txPool.Get(transactionID)
+	prepared := false
+	defer func() {
+		if !prepared {
+			conn.Recycle()
+		}
+	}()
+	stmts := []string{
+		fmt.Sprintf("insert into _vt.redo_state(dtid, state, time_created) values (%s, %d, %d)",
+			encodeString(dtid), RedoStatePrepared, tpc.now().UnixNano()),
+	}
+	for i, stmt := range conn.redoLog {
+		stmts = append(stmts, fmt.Sprintf("insert into _vt.redo_statement(dtid, id, statement) values (%s, %d, %s)",
+			encodeString(dtid), i+1, encodeString(stmt)))
+	}
+	tpc.execInTransaction(ctx, stmts)
+	tpc.qe.txPool.Prepare(conn, dtid)
+	prepared = true
+}
+
+// CommitPrepared deletes the redo log of the prepared transaction
+// as part of it, and commits it. It's a no-op if there's no such
+// transaction, which means that it was already resolved. It fails
+// if the redo log of the transaction could not be replayed: the
+// failed redo log stays until an operator resolves the transaction.
+func (tpc *TwoPC) CommitPrepared(ctx context.Context, logStats *SQLQueryStats, dtid string) {
+	tpc.checkEnabled()
+	conn := tpc.qe.txPool.FetchPrepared(dtid)
+	if conn == nil {
+		qr := tpc.exec(ctx, fmt.Sprintf("select state from _vt.redo_state where dtid = %s", encodeString(dtid)))
+		if len(qr.Rows) == 0 {
+			return
+		}
+		if parseInt64(qr.Rows[0][0]) == RedoStateFailed {
+			panic(NewTabletError(ErrFail, "cannot commit %s: its redo log could not be replayed, the transaction must be resolved manually", dtid))
+		}
+		// The redo logs were not replayed yet.
+		if !tpc.replayRedo(ctx) {
+			panic(NewTabletError(ErrFail, "cannot commit %s: MySQL is read-only", dtid))
+		}
+		if conn = tpc.qe.txPool.FetchPrepared(dtid); conn == nil {
+			panic(NewTabletError(ErrFail, "cannot commit %s: it's not prepared on this tablet", dtid))
+		}
+	}
+	for _, sql := range deleteRedoStatements(dtid) {
+		if _, err := conn.Exec(ctx, sql, 1, false); err != nil {
+			// Put it back, so the commit can be retried.
+			tpc.qe.txPool.Prepare(conn, dtid)
+			panic(err)
+		}
+	}
+	dirtyTables, err := tpc.qe.txPool.LocalCommit(ctx, conn)
+	tpc.qe.invalidateRows(logStats, dirtyTables)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// RollbackPrepared deletes the redo log of the transaction, and rolls
+// back the prepared transaction. If the transaction was not prepared,
+// the original transaction is rolled back instead, if it still exists.
+func (tpc *TwoPC) RollbackPrepared(ctx context.Context, dtid string, originalID int64) {
+	tpc.checkEnabled()
+	tpc.execInTransaction(ctx, deleteRedoStatements(dtid))
+	if conn := tpc.qe.txPool.FetchPrepared(dtid); conn != nil {
+		tpc.qe.txPool.LocalRollback(ctx, conn)
+		return
+	}
+	if originalID == 0 {
+		return
+	}
+	v, err := tpc.qe.txPool.activePool.Get(originalID, "for rollback")
+	if err != nil {
+		return
+	}
+	tpc.qe.txPool.LocalRollback(ctx, v.(*TxConnection))
+}
+
+// CreateTransaction records the metadata of a distributed transaction
+// in the PREPARE state. This must be done before its participants
+// are prepared.
+func (tpc *TwoPC) CreateTransaction(ctx context.Context, dtid string, participants []proto.TxParticipant) {
+	tpc.checkEnabled()
+	stmts := []string{
+		fmt.Sprintf("insert into _vt.dt_state(dtid, state, time_created) values (%s, %d, %d)",
+			encodeString(dtid), proto.DTStatePrepare, tpc.now().UnixNano()),
+	}
+	for i, p := range participants {
+		stmts = append(stmts, fmt.Sprintf("insert into _vt.dt_participant(dtid, id, keyspace, shard) values (%s, %d, %s, %s)",
+			encodeString(dtid), i+1, encodeString(p.Keyspace), encodeString(p.Shard)))
+	}
+	tpc.execInTransaction(ctx, stmts)
+}
+
+// StartCommit transitions the distributed transaction to the COMMIT
+// state as part of the metadata manager's transaction, and commits it.
+// After this, the participants must commit.
+func (tpc *TwoPC) StartCommit(ctx context.Context, logStats *SQLQueryStats, transactionID int64, dtid string) {
+	tpc.checkEnabled()
+	conn := tpc.qe.txPool.Get(transactionID)
+	qr, err := conn.Exec(ctx, transitionQuery(dtid, proto.DTStatePrepare, proto.DTStateCommit), 1, false)
+	conn.Recycle()
+	if err != nil {
+		panic(err)
+	}
+	if qr.RowsAffected != 1 {
+		tpc.qe.txPool.Rollback(ctx, transactionID)
+		panic(NewTabletError(ErrFail, "could not transition to COMMIT: %s", dtid))
+	}
+	tpc.qe.Commit(ctx, logStats, transactionID)
+}
+
+// SetRollback transitions the distributed transaction to the ROLLBACK
+// state, and rolls back the metadata manager's transaction if
+// transactionID is not 0. After this, the participants must roll back.
+func (tpc *TwoPC) SetRollback(ctx context.Context, dtid string, transactionID int64) {
+	tpc.checkEnabled()
+	if transactionID != 0 {
+		if v, err := tpc.qe.txPool.activePool.Get(transactionID, "for rollback"); err == nil {
+			tpc.qe.txPool.LocalRollback(ctx, v.(*TxConnection))
+		}
+	}
+	qr := tpc.exec(ctx, transitionQuery(dtid, proto.DTStatePrepare, proto.DTStateRollback))
+	if qr.RowsAffected == 1 {
+		return
+	}
+	// The transition fails if the transaction is already in the
+	// ROLLBACK state, which is fine, or if it's in the COMMIT state.
+	md := tpc.ReadTransaction(ctx, dtid)
+	if md.State != proto.DTStateRollback {
+		panic(NewTabletError(ErrFail, "could not transition to ROLLBACK: %s", dtid))
+	}
+}
+
+// ConcludeTransaction deletes the metadata of a distributed transaction.
+// It's called once all the participants have been resolved.
+func (tpc *TwoPC) ConcludeTransaction(ctx context.Context, dtid string) {
+	tpc.checkEnabled()
+	tpc.execInTransaction(ctx, []string{
+		fmt.Sprintf("delete from _vt.dt_state where dtid = %s", encodeString(dtid)),
+		fmt.Sprintf("delete from _vt.dt_participant where dtid = %s", encodeString(dtid)),
+	})
+}
+
+// ReadTransaction returns the metadata of a distributed transaction.
+// The returned metadata has an empty Dtid if there's no such transaction.
+func (tpc *TwoPC) ReadTransaction(ctx context.Context, dtid string) *proto.TransactionMetadata {
+	tpc.checkEnabled()
+	md := &proto.TransactionMetadata{Participants: []proto.TxParticipant{}}
+	qr := tpc.exec(ctx, fmt.Sprintf("select dtid, state, time_created from _vt.dt_state where dtid = %s", encodeString(dtid)))
+	if len(qr.Rows) == 0 {
+		return md
+	}
+	md.Dtid = qr.Rows[0][0].String()
+	md.State = parseInt64(qr.Rows[0][1])
+	md.TimeCreated = parseInt64(qr.Rows[0][2])
+	qr = tpc.exec(ctx, fmt.Sprintf("select keyspace, shard from _vt.dt_participant where dtid = %s order by id", encodeString(dtid)))
+	for _, row := range qr.Rows {
+		md.Participants = append(md.Participants, proto.TxParticipant{
+			Keyspace: row[0].String(),
+			Shard:    row[1].String(),
+		})
+	}
+	return md
+}
+
+// prepareFromRedo prepares the transactions of the redo logs
+// again. The redo logs that can't be replayed are marked as failed.
+func (tpc *TwoPC) prepareFromRedo(ctx context.Context) {
+	qr := tpc.exec(ctx, fmt.Sprintf("select dtid from _vt.redo_state where state = %d", RedoStatePrepared))
+	if len(qr.Rows) == 0 {
+		return
+	}
+	stmts := make(map[string][]string)
+	sqr := tpc.exec(ctx, "select dtid, statement from _vt.redo_statement order by dtid, id")
+	for _, row := range sqr.Rows {
+		dtid := row[0].String()
+		stmts[dtid] = append(stmts[dtid], row[1].String())
+	}
+	for _, row := range qr.Rows {
+		dtid := row[0].String()
+		if err := tpc.replay(ctx, dtid, stmts[dtid]); err != nil {
+			log.Errorf("Could not prepare transaction %s from its redo log: %v", dtid, err)
+			tpc.qe.queryServiceStats.InternalErrors.Add("TwoPC", 1)
+			tpc.exec(ctx, fmt.Sprintf("update _vt.redo_state set state = %d where dtid = %s", RedoStateFailed, encodeString(dtid)))
+			continue
+		}
+		log.Infof("Prepared transaction %s from its redo log", dtid)
+	}
+}
+
+// replay executes the statements of a redo log in a new
+// transaction, and prepares it.
+func (tpc *TwoPC) replay(ctx context.Context, dtid string, stmts []string) (err error) {
+	defer handleError(&err, nil, tpc.qe.queryServiceStats)
+	conn := tpc.qe.txPool.Get(tpc.qe.txPool.Begin(ctx))
+	for _, stmt := range stmts {
+		conn.RecordQuery(stmt)
+		conn.RecordRedo(stmt)
+		if _, err := conn.Exec(ctx, stmt, int(tpc.qe.maxResultSize.Get()), false); err != nil {
+			tpc.qe.txPool.LocalRollback(ctx, conn)
+			return err
+		}
+	}
+	tpc.qe.txPool.Prepare(conn, dtid)
+	return nil
+}
+
+// resolveAbandoned resolves the distributed transactions that were
+// created more than abandonAge ago, and whose metadata is still here.
+// The ones in the PREPARE state are rolled back.
+func (tpc *TwoPC) resolveAbandoned() {
+	defer logError(tpc.qe.queryServiceStats)
+	if TxParticipantResolver == nil {
+		return
+	}
+	ctx := context.Background()
+	cutoff := tpc.now().Add(-tpc.abandonAge).UnixNano()
+	qr := tpc.exec(ctx, fmt.Sprintf("select dtid from _vt.dt_state where time_created < %d", cutoff))
+	for _, row := range qr.Rows {
+		dtid := row[0].String()
+		if err := tpc.resolve(ctx, dtid); err != nil {
+			log.Warningf("Could not resolve abandoned transaction %s: %v", dtid, err)
+			continue
+		}
+		log.Infof("Resolved abandoned transaction %s", dtid)
+	}
+}
+
+func (tpc *TwoPC) resolve(ctx context.Context, dtid string) (err error) {
+	defer handleError(&err, nil, tpc.qe.queryServiceStats)
+	md := tpc.ReadTransaction(ctx, dtid)
+	if md.Dtid == "" {
+		return nil
+	}
+	if md.State == proto.DTStatePrepare {
+		tpc.SetRollback(ctx, dtid, 0)
+		md.State = proto.DTStateRollback
+	}
+	for _, p := range md.Participants {
+		if err := TxParticipantResolver(ctx, p, dtid, md.State == proto.DTStateCommit); err != nil {
+			return err
+		}
+	}
+	tpc.ConcludeTransaction(ctx, dtid)
+	return nil
+}
+
+// exec executes a single statement outside of any transaction.
+func (tpc *TwoPC) exec(ctx context.Context, sql string) *mproto.QueryResult {
+	conn := getOrPanic(ctx, tpc.qe.txPool.pool)
+	defer conn.Recycle()
+	qr, err := conn.Exec(ctx, sql, int(tpc.qe.maxResultSize.Get()), false)
+	if err != nil {
+		panic(err)
+	}
+	return qr
+}
+
+// execInTransaction executes the statements in their own transaction.
+func (tpc *TwoPC) execInTransaction(ctx context.Context, stmts []string) {
+	txPool := tpc.qe.txPool
+	conn := txPool.Get(txPool.Begin(ctx))
+	for _, sql := range stmts {
+		conn.RecordQuery(sql)
+		if _, err := conn.Exec(ctx, sql, 1, false); err != nil {
+			txPool.LocalRollback(ctx, conn)
+			panic(err)
+		}
+	}
+	if _, err := txPool.LocalCommit(ctx, conn); err != nil {
+		panic(err)
+	}
+}
+
+func deleteRedoStatements(dtid string) []string {
+	return []string{
+		fmt.Sprintf("delete from _vt.redo_state where dtid = %s", encodeString(dtid)),
+		fmt.Sprintf("delete from _vt.redo_statement where dtid = %s", encodeString(dtid)),
+	}
+}
+
+func transitionQuery(dtid string, from, to int64) string {
+	return fmt.Sprintf("update _vt.dt_state set state = %d where dtid = %s and state = %d", to, encodeString(dtid), from)
+}
+
+func encodeString(in string) string {
+	buf := &bytes.Buffer{}
+	sqltypes.MakeString([]byte(in)).EncodeSql(buf)
+	return buf.String()
+}
+
+func parseInt64(v sqltypes.Value) int64 {
+	n, err := v.ParseInt64()
+	if err != nil {
+		panic(NewTabletError(ErrFail, "%v", err))
+	}
+	return n
+}
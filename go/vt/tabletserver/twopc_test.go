@@ -0,0 +1,431 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vttest/fakesqldb"
+	"golang.org/x/net/context"
+)
+
+// twoPCTestTime is the time returned by the clock of the TwoPC of the tests.
+var twoPCTestTime = time.Unix(0, 1000)
+
+// newTestTwoPC returns the TwoPC of a new SqlQuery with
+// two-phase commit enabled, and a fixed clock.
+func newTestTwoPC(db *fakesqldb.DB) (*TwoPC, *SqlQuery) {
+	addTwoPCQueries(db)
+	_, sqlQuery := newTestQueryExecutor("set autocommit = 1", context.Background(), enableTwoPC)
+	tpc := sqlQuery.qe.twoPC
+	tpc.now = func() time.Time { return twoPCTestTime }
+	return tpc, sqlQuery
+}
+
+// addTwoPCQueries adds the queries executed by TwoPC.Open to db.
+func addTwoPCQueries(db *fakesqldb.DB) {
+	setReadOnly(db, "0")
+	for _, sql := range CreateTwoPCTables() {
+		db.AddQuery(sql, &mproto.QueryResult{})
+	}
+	db.AddQuery("select dtid from _vt.redo_state where state = 1", &mproto.QueryResult{})
+}
+
+// setReadOnly sets the read_only variable of the MySQL of db.
+func setReadOnly(db *fakesqldb.DB, value string) {
+	db.AddQuery("select @@global.read_only", &mproto.QueryResult{
+		RowsAffected: 1,
+		Rows:         [][]sqltypes.Value{{sqltypes.MakeNumeric([]byte(value))}},
+	})
+}
+
+func twoPCRows(rows ...[]interface{}) *mproto.QueryResult {
+	result := &mproto.QueryResult{RowsAffected: uint64(len(rows))}
+	for _, row := range rows {
+		var values []sqltypes.Value
+		for _, v := range row {
+			value, err := sqltypes.BuildValue(v)
+			if err != nil {
+				panic(err)
+			}
+			values = append(values, value)
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	return result
+}
+
+// oneRowAffected is the result of a DML that changed one row.
+// fakesqldb returns as many rows as RowsAffected.
+var oneRowAffected = &mproto.QueryResult{RowsAffected: 1, Rows: [][]sqltypes.Value{nil}}
+
+func checkCalled(t *testing.T, db *fakesqldb.DB, queries ...string) {
+	for _, query := range queries {
+		if n := db.GetQueryCalledNum(query); n != 1 {
+			t.Errorf("%s was called %d times, want 1", query, n)
+		}
+	}
+}
+
+// beginWithRedo begins a transaction that recorded stmts in its
+// redo log, and adds the queries that save it to db.
+func beginWithRedo(db *fakesqldb.DB, tpc *TwoPC, dtid string, stmts ...string) int64 {
+	transactionID := tpc.qe.txPool.Begin(context.Background())
+	conn := tpc.qe.txPool.Get(transactionID)
+	for _, stmt := range stmts {
+		conn.RecordRedo(stmt)
+	}
+	conn.Recycle()
+	db.AddQuery(fmt.Sprintf("insert into _vt.redo_state(dtid, state, time_created) values ('%s', 1, 1000)", dtid), &mproto.QueryResult{})
+	for i, stmt := range stmts {
+		db.AddQuery(fmt.Sprintf("insert into _vt.redo_statement(dtid, id, statement) values ('%s', %d, '%s')", dtid, i+1, stmt), &mproto.QueryResult{})
+	}
+	return transactionID
+}
+
+// prepareTestTransaction prepares a transaction
+// that recorded stmts in its redo log.
+func prepareTestTransaction(db *fakesqldb.DB, tpc *TwoPC, dtid string, stmts ...string) {
+	tpc.Prepare(context.Background(), beginWithRedo(db, tpc, dtid, stmts...), dtid)
+}
+
+func TestTwoPCPrepare(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	tpc, sqlQuery := newTestTwoPC(db)
+	defer sqlQuery.disallowQueries()
+
+	prepareTestTransaction(db, tpc, "aa", "update test_table set name = 2 where pk in (1)")
+	checkCalled(t, db,
+		"insert into _vt.redo_state(dtid, state, time_created) values ('aa', 1, 1000)",
+		"insert into _vt.redo_statement(dtid, id, statement) values ('aa', 1, 'update test_table set name = 2 where pk in (1)')",
+	)
+	conn := tpc.qe.txPool.FetchPrepared("aa")
+	if conn == nil {
+		t.Fatalf("FetchPrepared(aa): nil, want the prepared transaction")
+	}
+	tpc.qe.txPool.LocalRollback(context.Background(), conn)
+
+	// If the redo log can't be saved, the transaction is not prepared.
+	transactionID := beginWithRedo(db, tpc, "bb")
+	db.AddRejectedQuery("insert into _vt.redo_state(dtid, state, time_created) values ('bb', 1, 1000)")
+	func() {
+		defer handleAndVerifyTabletError(t, "Prepare should fail", ErrFail)
+		tpc.Prepare(context.Background(), transactionID, "bb")
+	}()
+	if conn := tpc.qe.txPool.FetchPrepared("bb"); conn != nil {
+		t.Errorf("FetchPrepared(bb): %v, want nil", conn)
+	}
+	tpc.qe.txPool.Rollback(context.Background(), transactionID)
+}
+
+func TestTwoPCRedoLog(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	addTwoPCQueries(db)
+	db.AddQuery("select pk from test_table where name = 1 limit 1000 for update", &mproto.QueryResult{})
+	db.AddQuery("show test_table", &mproto.QueryResult{})
+
+	// The select of a DML subquery doesn't change data: it's not recorded.
+	qre, sqlQuery := newTestQueryExecutor("update test_table set addr = 3 where name = 1 limit 1000", context.Background(), enableTx|enableTwoPC)
+	defer sqlQuery.disallowQueries()
+	defer testCommitHelper(t, sqlQuery, qre)
+	qre.Execute()
+
+	// The other statements are recorded.
+	qre.query = "show test_table"
+	qre.plan = sqlQuery.qe.schemaInfo.GetPlan(qre.ctx, qre.logStats, qre.query)
+	qre.Execute()
+
+	conn := sqlQuery.qe.txPool.Get(qre.transactionID)
+	defer conn.Recycle()
+	want := []string{"show test_table"}
+	if !reflect.DeepEqual(conn.redoLog, want) {
+		t.Errorf("redoLog: %v, want %v", conn.redoLog, want)
+	}
+}
+
+func TestTwoPCCommitPrepared(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	tpc, sqlQuery := newTestTwoPC(db)
+	defer sqlQuery.disallowQueries()
+	ctx := context.Background()
+	logStats := newSqlQueryStats("TestTwoPCCommitPrepared", ctx)
+
+	prepareTestTransaction(db, tpc, "aa")
+	db.AddQuery("delete from _vt.redo_state where dtid = 'aa'", &mproto.QueryResult{})
+	db.AddQuery("delete from _vt.redo_statement where dtid = 'aa'", &mproto.QueryResult{})
+	commits := db.GetQueryCalledNum("commit")
+	tpc.CommitPrepared(ctx, logStats, "aa")
+	checkCalled(t, db,
+		"delete from _vt.redo_state where dtid = 'aa'",
+		"delete from _vt.redo_statement where dtid = 'aa'",
+	)
+	if got := db.GetQueryCalledNum("commit") - commits; got != 1 {
+		t.Errorf("commits: %d, want 1", got)
+	}
+	if conn := tpc.qe.txPool.FetchPrepared("aa"); conn != nil {
+		t.Errorf("FetchPrepared(aa): %v, want nil", conn)
+	}
+
+	// A transaction that's already resolved is a no-op.
+	db.AddQuery("select state from _vt.redo_state where dtid = 'aa'", &mproto.QueryResult{})
+	tpc.CommitPrepared(ctx, logStats, "aa")
+	if got := db.GetQueryCalledNum("commit") - commits; got != 1 {
+		t.Errorf("commits: %d, want 1", got)
+	}
+
+	// If the redo log can't be deleted, the transaction
+	// stays prepared, so the commit can be retried.
+	prepareTestTransaction(db, tpc, "bb")
+	db.AddRejectedQuery("delete from _vt.redo_state where dtid = 'bb'")
+	func() {
+		defer handleAndVerifyTabletError(t, "CommitPrepared should fail", ErrFail)
+		tpc.CommitPrepared(ctx, logStats, "bb")
+	}()
+	conn := tpc.qe.txPool.FetchPrepared("bb")
+	if conn == nil {
+		t.Fatalf("FetchPrepared(bb): nil, want the prepared transaction")
+	}
+	tpc.qe.txPool.LocalRollback(ctx, conn)
+
+	// A transaction whose redo log could not be replayed was lost:
+	// its commit fails, and the failed redo log stays.
+	db.AddQuery("select state from _vt.redo_state where dtid = 'cc'", twoPCRows([]interface{}{RedoStateFailed}))
+	func() {
+		defer handleAndVerifyTabletError(t, "CommitPrepared should fail", ErrFail)
+		tpc.CommitPrepared(ctx, logStats, "cc")
+	}()
+	if n := db.GetQueryCalledNum("delete from _vt.redo_state where dtid = 'cc'"); n != 0 {
+		t.Errorf("the failed redo log was deleted %d times, want 0", n)
+	}
+}
+
+func TestTwoPCReplayOnPromotion(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	addTwoPCQueries(db)
+	setReadOnly(db, "1")
+	_, sqlQuery := newTestQueryExecutor("set autocommit = 1", context.Background(), enableTwoPC)
+	defer sqlQuery.disallowQueries()
+	tpc := sqlQuery.qe.twoPC
+	ctx := context.Background()
+	logStats := newSqlQueryStats("TestTwoPCReplayOnPromotion", ctx)
+
+	db.AddQuery("select dtid from _vt.redo_state where state = 1", twoPCRows([]interface{}{"aa"}, []interface{}{"bb"}))
+	db.AddQuery("select dtid, statement from _vt.redo_statement order by dtid, id", twoPCRows(
+		[]interface{}{"aa", "update test_table set name = 2 where pk in (1)"},
+		[]interface{}{"bb", "update test_table set name = 3 where pk in (2)"},
+	))
+	db.AddQuery("update test_table set name = 2 where pk in (1)", &mproto.QueryResult{})
+	db.AddQuery("update test_table set name = 3 where pk in (2)", &mproto.QueryResult{})
+	db.AddQuery("select state from _vt.redo_state where dtid = 'aa'", twoPCRows([]interface{}{RedoStatePrepared}))
+	db.AddQuery("delete from _vt.redo_state where dtid = 'aa'", &mproto.QueryResult{})
+	db.AddQuery("delete from _vt.redo_statement where dtid = 'aa'", &mproto.QueryResult{})
+
+	// A replica doesn't replay the redo logs.
+	tpc.watchdog()
+	if conn := tpc.qe.txPool.FetchPrepared("bb"); conn != nil {
+		t.Errorf("FetchPrepared(bb): %v, want nil", conn)
+	}
+	func() {
+		defer handleAndVerifyTabletError(t, "CommitPrepared should fail", ErrFail)
+		tpc.CommitPrepared(ctx, logStats, "aa")
+	}()
+
+	// Once promoted, a commit replays them.
+	setReadOnly(db, "0")
+	commits := db.GetQueryCalledNum("commit")
+	tpc.CommitPrepared(ctx, logStats, "aa")
+	if got := db.GetQueryCalledNum("commit") - commits; got != 1 {
+		t.Errorf("commits: %d, want 1", got)
+	}
+	conn := tpc.qe.txPool.FetchPrepared("bb")
+	if conn == nil {
+		t.Fatalf("FetchPrepared(bb): nil, want the prepared transaction")
+	}
+	tpc.qe.txPool.LocalRollback(ctx, conn)
+}
+
+func TestTwoPCWatchdogReplay(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	addTwoPCQueries(db)
+	setReadOnly(db, "1")
+	_, sqlQuery := newTestQueryExecutor("set autocommit = 1", context.Background(), enableTwoPC)
+	defer sqlQuery.disallowQueries()
+	tpc := sqlQuery.qe.twoPC
+
+	// The watchdog replays the redo logs once the replica is promoted.
+	setReadOnly(db, "0")
+	db.AddQuery("select dtid from _vt.redo_state where state = 1", twoPCRows([]interface{}{"aa"}))
+	db.AddQuery("select dtid, statement from _vt.redo_statement order by dtid, id", twoPCRows(
+		[]interface{}{"aa", "update test_table set name = 2 where pk in (1)"},
+	))
+	db.AddQuery("update test_table set name = 2 where pk in (1)", &mproto.QueryResult{})
+	tpc.watchdog()
+	conn := tpc.qe.txPool.FetchPrepared("aa")
+	if conn == nil {
+		t.Fatalf("FetchPrepared(aa): nil, want the prepared transaction")
+	}
+	tpc.qe.txPool.LocalRollback(context.Background(), conn)
+}
+
+func TestTwoPCRollbackPrepared(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	tpc, sqlQuery := newTestTwoPC(db)
+	defer sqlQuery.disallowQueries()
+	ctx := context.Background()
+
+	prepareTestTransaction(db, tpc, "aa")
+	db.AddQuery("delete from _vt.redo_state where dtid = 'aa'", &mproto.QueryResult{})
+	db.AddQuery("delete from _vt.redo_statement where dtid = 'aa'", &mproto.QueryResult{})
+	rollbacks := db.GetQueryCalledNum("rollback")
+	tpc.RollbackPrepared(ctx, "aa", 0)
+	checkCalled(t, db,
+		"delete from _vt.redo_state where dtid = 'aa'",
+		"delete from _vt.redo_statement where dtid = 'aa'",
+	)
+	if got := db.GetQueryCalledNum("rollback") - rollbacks; got != 1 {
+		t.Errorf("rollbacks: %d, want 1", got)
+	}
+	if conn := tpc.qe.txPool.FetchPrepared("aa"); conn != nil {
+		t.Errorf("FetchPrepared(aa): %v, want nil", conn)
+	}
+
+	// A transaction that was not prepared yet is rolled back.
+	db.AddQuery("delete from _vt.redo_state where dtid = 'bb'", &mproto.QueryResult{})
+	db.AddQuery("delete from _vt.redo_statement where dtid = 'bb'", &mproto.QueryResult{})
+	transactionID := tpc.qe.txPool.Begin(ctx)
+	tpc.RollbackPrepared(ctx, "bb", transactionID)
+	if got := db.GetQueryCalledNum("rollback") - rollbacks; got != 2 {
+		t.Errorf("rollbacks: %d, want 2", got)
+	}
+	func() {
+		defer handleAndVerifyTabletError(t, "the transaction should be rolled back", ErrNotInTx)
+		tpc.qe.txPool.Get(transactionID)
+	}()
+}
+
+func TestTwoPCPrepareFromRedo(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	tpc, sqlQuery := newTestTwoPC(db)
+	defer sqlQuery.disallowQueries()
+	ctx := context.Background()
+
+	db.AddQuery("select dtid from _vt.redo_state where state = 1", twoPCRows([]interface{}{"aa"}, []interface{}{"bb"}))
+	db.AddQuery("select dtid, statement from _vt.redo_statement order by dtid, id", twoPCRows(
+		[]interface{}{"aa", "update test_table set name = 2 where pk in (1)"},
+		[]interface{}{"aa", "update test_table set name = 3 where pk in (2)"},
+		[]interface{}{"bb", "update test_table set name = 4 where pk in (3)"},
+	))
+	db.AddQuery("update test_table set name = 2 where pk in (1)", &mproto.QueryResult{})
+	db.AddQuery("update test_table set name = 3 where pk in (2)", &mproto.QueryResult{})
+	db.AddRejectedQuery("update test_table set name = 4 where pk in (3)")
+	db.AddQuery("update _vt.redo_state set state = 0 where dtid = 'bb'", &mproto.QueryResult{})
+	tpc.prepareFromRedo(ctx)
+
+	conn := tpc.qe.txPool.FetchPrepared("aa")
+	if conn == nil {
+		t.Fatalf("FetchPrepared(aa): nil, want the prepared transaction")
+	}
+	want := []string{
+		"update test_table set name = 2 where pk in (1)",
+		"update test_table set name = 3 where pk in (2)",
+	}
+	if !reflect.DeepEqual(conn.redoLog, want) {
+		t.Errorf("redoLog: %v, want %v", conn.redoLog, want)
+	}
+	tpc.qe.txPool.LocalRollback(ctx, conn)
+
+	// The redo log that can't be replayed is marked as failed.
+	if conn := tpc.qe.txPool.FetchPrepared("bb"); conn != nil {
+		t.Errorf("FetchPrepared(bb): %v, want nil", conn)
+	}
+	checkCalled(t, db, "update _vt.redo_state set state = 0 where dtid = 'bb'")
+}
+
+func TestTwoPCSetRollback(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	tpc, sqlQuery := newTestTwoPC(db)
+	defer sqlQuery.disallowQueries()
+	ctx := context.Background()
+
+	// The metadata manager's transaction is rolled back.
+	db.AddQuery("update _vt.dt_state set state = 3 where dtid = 'aa' and state = 1", oneRowAffected)
+	transactionID := tpc.qe.txPool.Begin(ctx)
+	rollbacks := db.GetQueryCalledNum("rollback")
+	tpc.SetRollback(ctx, "aa", transactionID)
+	if got := db.GetQueryCalledNum("rollback") - rollbacks; got != 1 {
+		t.Errorf("rollbacks: %d, want 1", got)
+	}
+	checkCalled(t, db, "update _vt.dt_state set state = 3 where dtid = 'aa' and state = 1")
+
+	// A transaction that's already in the ROLLBACK state is fine.
+	db.AddQuery("update _vt.dt_state set state = 3 where dtid = 'bb' and state = 1", &mproto.QueryResult{})
+	db.AddQuery("select dtid, state, time_created from _vt.dt_state where dtid = 'bb'", twoPCRows([]interface{}{"bb", 3, 1000}))
+	db.AddQuery("select keyspace, shard from _vt.dt_participant where dtid = 'bb' order by id", &mproto.QueryResult{})
+	tpc.SetRollback(ctx, "bb", 0)
+
+	// A transaction that's in the COMMIT state can't be rolled back.
+	db.AddQuery("update _vt.dt_state set state = 3 where dtid = 'cc' and state = 1", &mproto.QueryResult{})
+	db.AddQuery("select dtid, state, time_created from _vt.dt_state where dtid = 'cc'", twoPCRows([]interface{}{"cc", 2, 1000}))
+	db.AddQuery("select keyspace, shard from _vt.dt_participant where dtid = 'cc' order by id", &mproto.QueryResult{})
+	defer handleAndVerifyTabletError(t, "SetRollback should fail", ErrFail)
+	tpc.SetRollback(ctx, "cc", 0)
+}
+
+func TestTwoPCResolveAbandoned(t *testing.T) {
+	db := setUpQueryExecutorTest()
+	tpc, sqlQuery := newTestTwoPC(db)
+	defer sqlQuery.disallowQueries()
+
+	type resolution struct {
+		participant proto.TxParticipant
+		dtid        string
+		commit      bool
+	}
+	var resolved []resolution
+	defer func(resolver func(context.Context, proto.TxParticipant, string, bool) error) {
+		TxParticipantResolver = resolver
+	}(TxParticipantResolver)
+	TxParticipantResolver = func(ctx context.Context, participant proto.TxParticipant, dtid string, commit bool) error {
+		resolved = append(resolved, resolution{participant, dtid, commit})
+		return nil
+	}
+
+	cutoff := twoPCTestTime.Add(-tpc.abandonAge).UnixNano()
+	db.AddQuery(fmt.Sprintf("select dtid from _vt.dt_state where time_created < %d", cutoff), twoPCRows([]interface{}{"aa"}, []interface{}{"bb"}))
+	// aa was still being prepared: it's rolled back.
+	db.AddQuery("select dtid, state, time_created from _vt.dt_state where dtid = 'aa'", twoPCRows([]interface{}{"aa", 1, 1000}))
+	db.AddQuery("select keyspace, shard from _vt.dt_participant where dtid = 'aa' order by id", twoPCRows([]interface{}{"ks", "-80"}, []interface{}{"ks", "80-"}))
+	db.AddQuery("update _vt.dt_state set state = 3 where dtid = 'aa' and state = 1", oneRowAffected)
+	db.AddQuery("delete from _vt.dt_state where dtid = 'aa'", &mproto.QueryResult{})
+	db.AddQuery("delete from _vt.dt_participant where dtid = 'aa'", &mproto.QueryResult{})
+	// bb was committing: it's committed.
+	db.AddQuery("select dtid, state, time_created from _vt.dt_state where dtid = 'bb'", twoPCRows([]interface{}{"bb", 2, 1000}))
+	db.AddQuery("select keyspace, shard from _vt.dt_participant where dtid = 'bb' order by id", twoPCRows([]interface{}{"ks", "-80"}))
+	db.AddQuery("delete from _vt.dt_state where dtid = 'bb'", &mproto.QueryResult{})
+	db.AddQuery("delete from _vt.dt_participant where dtid = 'bb'", &mproto.QueryResult{})
+	tpc.resolveAbandoned()
+
+	want := []resolution{
+		{proto.TxParticipant{Keyspace: "ks", Shard: "-80"}, "aa", false},
+		{proto.TxParticipant{Keyspace: "ks", Shard: "80-"}, "aa", false},
+		{proto.TxParticipant{Keyspace: "ks", Shard: "-80"}, "bb", true},
+	}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Errorf("resolved: %+v, want %+v", resolved, want)
+	}
+	checkCalled(t, db,
+		"update _vt.dt_state set state = 3 where dtid = 'aa' and state = 1",
+		"delete from _vt.dt_state where dtid = 'aa'",
+		"delete from _vt.dt_participant where dtid = 'aa'",
+		"delete from _vt.dt_state where dtid = 'bb'",
+		"delete from _vt.dt_participant where dtid = 'bb'",
+	)
+}
@@ -53,6 +53,10 @@ type TxPool struct {
 	ticks             *timer.Timer
 	txStats           *stats.Timings
 	queryServiceStats *QueryServiceStats
+	// prepared contains the transactions that were prepared
+	// for a two-phase commit, indexed by their dtid.
+	preparedMu sync.Mutex
+	prepared   map[string]*TxConnection
 	// Tracking culprits that cause tx pool full errors.
 	logMu   sync.Mutex
 	lastLog time.Time
@@ -83,6 +87,7 @@ func NewTxPool(
 		ticks:             timer.NewTimer(timeout / 10),
 		txStats:           stats.NewTimings(txStatsName),
 		queryServiceStats: qStats,
+		prepared:          make(map[string]*TxConnection),
 	}
 	// Careful: pool also exports name+"xxx" vars,
 	// but we know it doesn't export Timeout.
@@ -111,6 +116,18 @@ func (axp *TxPool) Close() {
 		conn.Close()
 		conn.discard(TxClose)
 	}
+	// Prepared transactions are rolled back by closing their
+	// connections. Their redo logs are preserved, and they
+	// will be prepared again when the pool is reopened.
+	axp.preparedMu.Lock()
+	prepared := axp.prepared
+	axp.prepared = make(map[string]*TxConnection)
+	axp.preparedMu.Unlock()
+	for _, conn := range prepared {
+		log.Warningf("closing prepared transaction for shutdown: %s", conn.Format(nil))
+		conn.Close()
+		conn.discard(TxClose)
+	}
 	axp.pool.Close()
 }
 
@@ -139,6 +156,15 @@ func (axp *TxPool) Begin(ctx context.Context) int64 {
 		poolCtx, cancel = context.WithDeadline(ctx, deadline.Add(-10*time.Millisecond))
 		defer cancel()
 	}
+	conn := axp.beginConn(ctx, poolCtx)
+	transactionID := axp.lastID.Add(1)
+	axp.activePool.Register(transactionID, newTxConnection(conn, transactionID, axp))
+	return transactionID
+}
+
+// beginConn gets a connection from the pool within poolCtx,
+// and begins a transaction on it.
+func (axp *TxPool) beginConn(ctx, poolCtx context.Context) *DBConn {
 	conn, err := axp.pool.Get(poolCtx)
 	if err != nil {
 		switch err {
@@ -154,9 +180,7 @@ func (axp *TxPool) Begin(ctx context.Context) int64 {
 		conn.Recycle()
 		panic(NewTabletErrorSql(ErrFail, err))
 	}
-	transactionID := axp.lastID.Add(1)
-	axp.activePool.Register(transactionID, newTxConnection(conn, transactionID, axp))
-	return transactionID
+	return conn
 }
 
 // SafeCommit commits the specified transaction. Unlike other functions, it
@@ -166,6 +190,13 @@ func (axp *TxPool) SafeCommit(ctx context.Context, transactionID int64) (invalid
 	defer handleError(&err, nil, axp.queryServiceStats)
 
 	conn := axp.Get(transactionID)
+	return axp.LocalCommit(ctx, conn)
+}
+
+// LocalCommit commits the transaction of conn, which must have been
+// obtained through Get or FetchPrepared. Like SafeCommit, it returns an
+// error on failure, and the connection becomes free.
+func (axp *TxPool) LocalCommit(ctx context.Context, conn *TxConnection) (invalidList map[string]DirtyKeys, err error) {
 	defer conn.discard(TxCommit)
 	// Assign this upfront to make sure we always return the invalidList.
 	invalidList = conn.dirtyTables
@@ -180,6 +211,12 @@ func (axp *TxPool) SafeCommit(ctx context.Context, transactionID int64) (invalid
 // Rollback rolls back the specified transaction.
 func (axp *TxPool) Rollback(ctx context.Context, transactionID int64) {
 	conn := axp.Get(transactionID)
+	axp.LocalRollback(ctx, conn)
+}
+
+// LocalRollback rolls back the transaction of conn, which must have
+// been obtained through Get or FetchPrepared.
+func (axp *TxPool) LocalRollback(ctx context.Context, conn *TxConnection) {
 	defer conn.discard(TxRollback)
 	axp.txStats.Add("Aborted", time.Now().Sub(conn.StartTime))
 	if _, err := conn.Exec(ctx, "rollback", 1, false); err != nil {
@@ -198,6 +235,31 @@ func (axp *TxPool) Get(transactionID int64) (conn *TxConnection) {
 	return v.(*TxConnection)
 }
 
+// Prepare moves the transaction out of the active pool, and keeps it
+// under dtid until it's fetched by FetchPrepared. Prepared transactions
+// are not subject to the transaction timeout. conn must have been
+// obtained through Get, and must not be recycled afterwards.
+func (axp *TxPool) Prepare(conn *TxConnection, dtid string) {
+	axp.preparedMu.Lock()
+	defer axp.preparedMu.Unlock()
+	if _, ok := axp.prepared[dtid]; ok {
+		panic(NewTabletError(ErrFail, "duplicate dtid: %s", dtid))
+	}
+	axp.activePool.Unregister(conn.TransactionID)
+	axp.prepared[dtid] = conn
+}
+
+// FetchPrepared removes the prepared transaction for dtid and returns
+// its connection. It returns nil if there's no such transaction.
+// The caller must commit or roll back the connection.
+func (axp *TxPool) FetchPrepared(dtid string) *TxConnection {
+	axp.preparedMu.Lock()
+	defer axp.preparedMu.Unlock()
+	conn := axp.prepared[dtid]
+	delete(axp.prepared, dtid)
+	return conn
+}
+
 // LogActive causes all existing transactions to be logged when they complete.
 // The logging is throttled to no more than once every txLogInterval.
 func (axp *TxPool) LogActive() {
@@ -248,8 +310,12 @@ type TxConnection struct {
 	EndTime       time.Time
 	dirtyTables   map[string]DirtyKeys
 	Queries       []string
-	Conclusion    string
-	LogToFile     sync2.AtomicInt32
+	redoLog       []string
+	// serializedRows holds the done functions of the rows the
+	// transaction waited for in the TxSerializer, by row key.
+	serializedRows map[string]func()
+	Conclusion     string
+	LogToFile      sync2.AtomicInt32
 }
 
 func newTxConnection(conn *DBConn, transactionID int64, pool *TxPool) *TxConnection {
@@ -288,25 +354,83 @@ func (txc *TxConnection) Exec(ctx context.Context, query string, maxrows int, wa
 }
 
 // Recycle returns the connection to the pool. The transaction remains
-// active.
+// active, unless it lost its MySQL connection.
 func (txc *TxConnection) Recycle() {
-	if txc.IsClosed() {
+	if txc.DBConn == nil || txc.IsClosed() {
 		txc.discard(TxClose)
 	} else {
 		txc.pool.activePool.Put(txc.TransactionID)
 	}
 }
 
+// releaseConn rolls back the transaction of txc, which must not have
+// executed any statement, and returns its MySQL connection to the
+// pool. The transaction remains active: reacquireConn begins it again
+// on a new connection. Nothing is lost, since MySQL starts the
+// transaction with its first statement.
+func (txc *TxConnection) releaseConn(ctx context.Context) error {
+	if _, err := txc.DBConn.ExecOnce(ctx, "rollback", 1, false); err != nil {
+		txc.Close()
+		return NewTabletErrorSql(ErrFail, err)
+	}
+	txc.DBConn.Recycle()
+	txc.DBConn = nil
+	return nil
+}
+
+// reacquireConn begins the transaction of txc again, after releaseConn.
+// If it fails, the transaction ends when txc is recycled.
+func (txc *TxConnection) reacquireConn(ctx context.Context) {
+	txc.DBConn = txc.pool.beginConn(ctx, ctx)
+}
+
 // RecordQuery records the query against this transaction.
 func (txc *TxConnection) RecordQuery(query string) {
 	txc.Queries = append(txc.Queries, query)
 }
 
+// RecordRedo records a statement that changed data in this transaction.
+// If the transaction gets prepared, these statements make up its redo log.
+func (txc *TxConnection) RecordRedo(statement string) {
+	txc.redoLog = append(txc.redoLog, statement)
+}
+
+// redoConn is a TxConnection that records the
+// statements it successfully executes with RecordRedo.
+type redoConn struct {
+	*TxConnection
+}
+
+// Exec executes the statement, and records it if it succeeds.
+func (rc redoConn) Exec(ctx context.Context, query string, maxrows int, wantfields bool) (*proto.QueryResult, error) {
+	r, err := rc.TxConnection.Exec(ctx, query, maxrows, wantfields)
+	if err != nil {
+		return nil, err
+	}
+	rc.RecordRedo(query)
+	return r, nil
+}
+
+// readConn returns the connection to use for the reads of a DML.
+// They don't change data, so they're not recorded in the redo log.
+func readConn(conn poolConn) poolConn {
+	if rc, ok := conn.(redoConn); ok {
+		return rc.TxConnection
+	}
+	return conn
+}
+
 func (txc *TxConnection) discard(conclusion string) {
 	txc.Conclusion = conclusion
 	txc.EndTime = time.Now()
 	txc.pool.activePool.Unregister(txc.TransactionID)
-	txc.DBConn.Recycle()
+	for _, done := range txc.serializedRows {
+		done()
+	}
+	txc.serializedRows = nil
+	if txc.DBConn != nil {
+		txc.DBConn.Recycle()
+	}
 	// Ensure PoolConnection won't be accessed after Recycle.
 	txc.DBConn = nil
 	if txc.LogToFile.Get() != 0 {
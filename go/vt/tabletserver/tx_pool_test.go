@@ -269,6 +269,51 @@ func TestTxPoolExecFailDueToConnFail(t *testing.T) {
 	}
 }
 
+func TestTxPoolPrepare(t *testing.T) {
+	sql := "update test_table set name = 'a' where pk = 1"
+	db := fakesqldb.Register()
+	db.AddQuery("begin", &proto.QueryResult{})
+	db.AddQuery(sql, &proto.QueryResult{})
+	db.AddQuery("commit", &proto.QueryResult{})
+
+	txPool := newTxPool(false)
+	appParams := sqldb.ConnParams{}
+	dbaParams := sqldb.ConnParams{}
+	txPool.Open(&appParams, &dbaParams)
+	defer txPool.Close()
+	ctx := context.Background()
+	transactionID := txPool.Begin(ctx)
+	txConn := txPool.Get(transactionID)
+	if _, err := (redoConn{txConn}).Exec(ctx, sql, 1, false); err != nil {
+		t.Fatalf("got error: %v", err)
+	}
+	if len(txConn.redoLog) != 1 || txConn.redoLog[0] != sql {
+		t.Errorf("redoLog: %v, want [%s]", txConn.redoLog, sql)
+	}
+	txPool.Prepare(txConn, "aa")
+
+	// A prepared transaction is not in the active pool anymore.
+	func() {
+		defer handleAndVerifyTabletError(t, "txpool.Get should fail", ErrNotInTx)
+		txPool.Get(transactionID)
+	}()
+	func() {
+		defer handleAndVerifyTabletError(t, "txpool.Prepare should fail", ErrFail)
+		txPool.Prepare(txConn, "aa")
+	}()
+
+	got := txPool.FetchPrepared("aa")
+	if got != txConn {
+		t.Errorf("FetchPrepared: %v, want %v", got, txConn)
+	}
+	if got := txPool.FetchPrepared("aa"); got != nil {
+		t.Errorf("FetchPrepared: %v, want nil", got)
+	}
+	if _, err := txPool.LocalCommit(ctx, txConn); err != nil {
+		t.Error(err)
+	}
+}
+
 func newTxPool(enablePublishStats bool) *TxPool {
 	randID := rand.Int63()
 	poolName := fmt.Sprintf("TestTransactionPool-%d", randID)
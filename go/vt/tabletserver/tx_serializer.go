@@ -0,0 +1,221 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/youtube/vitess/go/acl"
+	"github.com/youtube/vitess/go/stats"
+	"golang.org/x/net/context"
+)
+
+// TxSerializer serializes the transactions that update the same row.
+// Without it, the transactions of a hot row all hold a tx pool
+// connection while they wait for the MySQL row lock, and exhaust
+// the pool for the unrelated traffic.
+// At most concurrentTransactions transactions update a row at a
+// time. At most maxQueueSize transactions wait for or update a
+// row: the next ones are rejected, and can be retried later.
+// A transaction that would wait for a row held by a transaction
+// that waits for one of its own rows fails right away, instead of
+// deadlocking until the transaction timeout.
+type TxSerializer struct {
+	maxQueueSize           int
+	concurrentTransactions int
+
+	// mu protects queues and waiting.
+	mu     sync.Mutex
+	queues map[string]*txQueue
+	// waiting has the row key each waiting transaction waits for.
+	waiting map[int64]string
+
+	// waits and rejects are counted per table.
+	waits   *stats.Counters
+	rejects *stats.Counters
+}
+
+// txQueue holds the transactions of a row.
+type txQueue struct {
+	// size is the number of transactions waiting for or updating the row.
+	size int
+	// holders are the transactions updating the row.
+	holders map[int64]bool
+	// released is closed when a holder releases the row.
+	released chan struct{}
+}
+
+// NewTxSerializer creates a new TxSerializer.
+func NewTxSerializer(maxQueueSize, concurrentTransactions int, statsPrefix string, enablePublishStats bool) *TxSerializer {
+	waitsName := ""
+	rejectsName := ""
+	if enablePublishStats {
+		waitsName = statsPrefix + "HotRowWaits"
+		rejectsName = statsPrefix + "HotRowRejects"
+	}
+	if concurrentTransactions < 1 {
+		concurrentTransactions = 1
+	}
+	return &TxSerializer{
+		maxQueueSize:           maxQueueSize,
+		concurrentTransactions: concurrentTransactions,
+		queues:                 make(map[string]*txQueue),
+		waiting:                make(map[int64]string),
+		waits:                  stats.NewCounters(waitsName),
+		rejects:                stats.NewCounters(rejectsName),
+	}
+}
+
+// Wait blocks until the transaction may update the row of table
+// identified by key. It returns done, which must be called once the
+// transaction ends. It fails if the queue of the row is full, if
+// waiting would deadlock, or if ctx is done before the row is
+// available. beforeWait, if not nil, is called once before the
+// transaction starts waiting: Wait fails if it does.
+func (ts *TxSerializer) Wait(ctx context.Context, transactionID int64, table, key string, beforeWait func() error) (done func(), err error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	q, ok := ts.queues[key]
+	if !ok {
+		q = &txQueue{
+			holders:  make(map[int64]bool),
+			released: make(chan struct{}),
+		}
+		ts.queues[key] = q
+	}
+	if q.size >= ts.maxQueueSize {
+		ts.rejects.Add(table, 1)
+		return nil, NewTabletError(ErrTxPoolFull, "hot row protection: too many transactions (%d) are waiting for the same row: %s", ts.maxQueueSize, key)
+	}
+	q.size++
+
+	waited := false
+	for len(q.holders) >= ts.concurrentTransactions {
+		if ts.deadlocks(transactionID, key) {
+			ts.release(key, q)
+			return nil, NewTabletError(ErrFail, "hot row protection: deadlock found waiting for row: %s, try restarting transaction", key)
+		}
+		released := q.released
+		ts.waiting[transactionID] = key
+		ts.mu.Unlock()
+		if !waited {
+			waited = true
+			ts.waits.Add(table, 1)
+			if beforeWait != nil {
+				err = beforeWait()
+			}
+		}
+		if err == nil {
+			select {
+			case <-released:
+			case <-ctx.Done():
+			}
+		}
+		ts.mu.Lock()
+		delete(ts.waiting, transactionID)
+		if err != nil {
+			ts.release(key, q)
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			ts.release(key, q)
+			return nil, NewTabletError(ErrFail, "hot row protection: timed out waiting for row: %s", key)
+		}
+	}
+	q.holders[transactionID] = true
+	return func() {
+		ts.mu.Lock()
+		defer ts.mu.Unlock()
+		delete(q.holders, transactionID)
+		close(q.released)
+		q.released = make(chan struct{})
+		ts.release(key, q)
+	}, nil
+}
+
+// deadlocks returns true if the transaction would deadlock by waiting
+// for the row identified by key: one of the holders of the row waits,
+// directly or not, for a row the transaction holds. ts.mu must be held.
+func (ts *TxSerializer) deadlocks(transactionID int64, key string) bool {
+	visited := map[string]bool{key: true}
+	keys := []string{key}
+	for len(keys) > 0 {
+		q := ts.queues[keys[0]]
+		keys = keys[1:]
+		for holder := range q.holders {
+			if holder == transactionID {
+				return true
+			}
+			if next, ok := ts.waiting[holder]; ok && !visited[next] {
+				visited[next] = true
+				keys = append(keys, next)
+			}
+		}
+	}
+	return false
+}
+
+// release removes a transaction from the queue of a row.
+// ts.mu must be held.
+func (ts *TxSerializer) release(key string, q *txQueue) {
+	q.size--
+	if q.size == 0 {
+		delete(ts.queues, key)
+	}
+}
+
+// queueSizes returns the size of the queue of every row in use.
+func (ts *TxSerializer) queueSizes() map[string]int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	sizes := make(map[string]int, len(ts.queues))
+	for key, q := range ts.queues {
+		sizes[key] = q.size
+	}
+	return sizes
+}
+
+// ServeHTTP lists the rows in use, with their number of transactions,
+// the largest queues first.
+func (ts *TxSerializer) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	if err := acl.CheckAccessHTTP(request, acl.DEBUGGING); err != nil {
+		acl.SendError(response, err)
+		return
+	}
+	sizes := ts.queueSizes()
+	response.Header().Set("Content-Type", "text/plain")
+	if len(sizes) == 0 {
+		response.Write([]byte("empty\n"))
+		return
+	}
+	keys := make([]string, 0, len(sizes))
+	for key := range sizes {
+		keys = append(keys, key)
+	}
+	sort.Sort(byQueueSize{keys, sizes})
+	response.Write([]byte(fmt.Sprintf("Length: %d\n", len(keys))))
+	for _, key := range keys {
+		response.Write([]byte(fmt.Sprintf("%v: %s\n", sizes[key], key)))
+	}
+}
+
+// byQueueSize sorts rows by decreasing queue size, then by key.
+type byQueueSize struct {
+	keys  []string
+	sizes map[string]int
+}
+
+func (b byQueueSize) Len() int      { return len(b.keys) }
+func (b byQueueSize) Swap(i, j int) { b.keys[i], b.keys[j] = b.keys[j], b.keys[i] }
+func (b byQueueSize) Less(i, j int) bool {
+	si, sj := b.sizes[b.keys[i]], b.sizes[b.keys[j]]
+	if si != sj {
+		return si > sj
+	}
+	return b.keys[i] < b.keys[j]
+}
@@ -0,0 +1,210 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tabletserver
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestTxSerializer(t *testing.T) {
+	ts := NewTxSerializer(2, 1, "", false)
+	ctx := context.Background()
+
+	done1, err := ts.Wait(ctx, 1, "t1", "t1.1", nil)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	// another row is not blocked
+	done2, err := ts.Wait(ctx, 1, "t1", "t1.2", nil)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	// the second transaction of the row waits for the first one
+	waited := make(chan func())
+	go func() {
+		done, err := ts.Wait(ctx, 2, "t1", "t1.1", nil)
+		if err != nil {
+			t.Errorf("Wait: %v", err)
+		}
+		waited <- done
+	}()
+	for ts.waits.Counts()["t1"] != 1 {
+		time.Sleep(1 * time.Millisecond)
+	}
+	want := map[string]int{"t1.1": 2, "t1.2": 1}
+	if got := ts.queueSizes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("queueSizes: %v, want %v", got, want)
+	}
+
+	// the queue of the row is full
+	_, err = ts.Wait(ctx, 3, "t1", "t1.1", nil)
+	wantErr := "tx_pool_full: hot row protection: too many transactions (2) are waiting for the same row: t1.1"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("Wait: %v, want %v", err, wantErr)
+	}
+	if got := ts.rejects.Counts()["t1"]; got != 1 {
+		t.Errorf("rejects: %v, want 1", got)
+	}
+
+	done1()
+	done3 := <-waited
+	done3()
+	done2()
+	if got := ts.queueSizes(); len(got) != 0 {
+		t.Errorf("queueSizes: %v, want empty", got)
+	}
+}
+
+func TestTxSerializerTimeout(t *testing.T) {
+	ts := NewTxSerializer(2, 1, "", false)
+	done, err := ts.Wait(context.Background(), 1, "t1", "t1.1", nil)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = ts.Wait(ctx, 2, "t1", "t1.1", nil)
+	wantErr := "error: hot row protection: timed out waiting for row: t1.1"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("Wait: %v, want %v", err, wantErr)
+	}
+	// the transaction that timed out left the queue
+	want := map[string]int{"t1.1": 1}
+	if got := ts.queueSizes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("queueSizes: %v, want %v", got, want)
+	}
+}
+
+func TestTxSerializerDeadlock(t *testing.T) {
+	ts := NewTxSerializer(2, 1, "", false)
+	ctx := context.Background()
+
+	doneA, err := ts.Wait(ctx, 1, "t1", "t1.a", nil)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	doneB, err := ts.Wait(ctx, 2, "t1", "t1.b", nil)
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	// transaction 1 waits for the row of transaction 2
+	waited := make(chan func())
+	go func() {
+		done, err := ts.Wait(ctx, 1, "t1", "t1.b", nil)
+		if err != nil {
+			t.Errorf("Wait: %v", err)
+		}
+		waited <- done
+	}()
+	for ts.waits.Counts()["t1"] != 1 {
+		time.Sleep(1 * time.Millisecond)
+	}
+
+	// transaction 2 would wait for the row of transaction 1
+	_, err = ts.Wait(ctx, 2, "t1", "t1.a", func() error {
+		t.Errorf("beforeWait was called for a deadlocked transaction")
+		return nil
+	})
+	wantErr := "error: hot row protection: deadlock found waiting for row: t1.a, try restarting transaction"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("Wait: %v, want %v", err, wantErr)
+	}
+	want := map[string]int{"t1.a": 1, "t1.b": 2}
+	if got := ts.queueSizes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("queueSizes: %v, want %v", got, want)
+	}
+
+	// transaction 2 rolls back
+	doneB()
+	done := <-waited
+	done()
+	doneA()
+	if got := ts.queueSizes(); len(got) != 0 {
+		t.Errorf("queueSizes: %v, want empty", got)
+	}
+}
+
+func TestTxSerializerBeforeWait(t *testing.T) {
+	ts := NewTxSerializer(2, 1, "", false)
+	ctx := context.Background()
+	done, err := ts.Wait(ctx, 1, "t1", "t1.1", func() error {
+		t.Errorf("beforeWait was called for an available row")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	defer done()
+
+	_, err = ts.Wait(ctx, 2, "t1", "t1.1", func() error {
+		return errors.New("release failed")
+	})
+	if err == nil || err.Error() != "release failed" {
+		t.Errorf("Wait: %v, want release failed", err)
+	}
+	// the transaction left the queue
+	want := map[string]int{"t1.1": 1}
+	if got := ts.queueSizes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("queueSizes: %v, want %v", got, want)
+	}
+}
+
+func TestTxSerializerConcurrentTransactions(t *testing.T) {
+	ts := NewTxSerializer(3, 2, "", false)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		done, err := ts.Wait(ctx, int64(i), "t1", "t1.1", nil)
+		if err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+		defer done()
+	}
+	if _, err := ts.Wait(ctx, 2, "t1", "t1.1", nil); err == nil {
+		t.Errorf("Wait: nil, want timeout")
+	}
+}
+
+func TestTxSerializerServeHTTP(t *testing.T) {
+	ts := NewTxSerializer(3, 2, "", false)
+	request, _ := http.NewRequest("GET", "/debug/hotrows", nil)
+
+	response := httptest.NewRecorder()
+	ts.ServeHTTP(response, request)
+	if got, want := response.Body.String(), "empty\n"; got != want {
+		t.Errorf("ServeHTTP: %q, want %q", got, want)
+	}
+
+	ctx := context.Background()
+	for i, key := range []string{"t1.1", "t1.2", "t1.2"} {
+		done, err := ts.Wait(ctx, int64(i), "t1", key, nil)
+		if err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+		defer done()
+	}
+	response = httptest.NewRecorder()
+	ts.ServeHTTP(response, request)
+	want := []string{
+		"Length: 2",
+		"2: t1.2",
+		"1: t1.1",
+	}
+	if got := strings.Split(strings.TrimSpace(response.Body.String()), "\n"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ServeHTTP: %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package topo
+
+import (
+	pb "github.com/youtube/vitess/go/vt/proto/topodata"
+)
+
+// This file contains the conversions between the topo types
+// and their proto3 versions.
+
+var tabletTypeToProto3 = map[TabletType]pb.TabletType{
+	"":                  pb.TabletType_UNKNOWN,
+	TYPE_IDLE:           pb.TabletType_IDLE,
+	TYPE_MASTER:         pb.TabletType_MASTER,
+	TYPE_REPLICA:        pb.TabletType_REPLICA,
+	TYPE_RDONLY:         pb.TabletType_RDONLY,
+	TYPE_BATCH:          pb.TabletType_BATCH,
+	TYPE_SPARE:          pb.TabletType_SPARE,
+	TYPE_EXPERIMENTAL:   pb.TabletType_EXPERIMENTAL,
+	TYPE_SCHEMA_UPGRADE: pb.TabletType_SCHEMA_UPGRADE,
+	TYPE_BACKUP:         pb.TabletType_BACKUP,
+	TYPE_RESTORE:        pb.TabletType_RESTORE,
+	TYPE_WORKER:         pb.TabletType_WORKER,
+	TYPE_SCRAP:          pb.TabletType_SCRAP,
+}
+
+// TYPE_BATCH is an alias of TYPE_RDONLY in proto3,
+// so it comes back as TYPE_RDONLY.
+var proto3ToTabletType = map[pb.TabletType]TabletType{
+	pb.TabletType_UNKNOWN:        "",
+	pb.TabletType_IDLE:           TYPE_IDLE,
+	pb.TabletType_MASTER:         TYPE_MASTER,
+	pb.TabletType_REPLICA:        TYPE_REPLICA,
+	pb.TabletType_RDONLY:         TYPE_RDONLY,
+	pb.TabletType_SPARE:          TYPE_SPARE,
+	pb.TabletType_EXPERIMENTAL:   TYPE_EXPERIMENTAL,
+	pb.TabletType_SCHEMA_UPGRADE: TYPE_SCHEMA_UPGRADE,
+	pb.TabletType_BACKUP:         TYPE_BACKUP,
+	pb.TabletType_RESTORE:        TYPE_RESTORE,
+	pb.TabletType_WORKER:         TYPE_WORKER,
+	pb.TabletType_SCRAP:          TYPE_SCRAP,
+}
+
+// TabletTypeToProto3 converts a TabletType to the proto3 version.
+// Unknown types are converted to TabletType_UNKNOWN.
+func TabletTypeToProto3(tabletType TabletType) pb.TabletType {
+	return tabletTypeToProto3[tabletType]
+}
+
+// Proto3ToTabletType converts a proto3 TabletType to the
+// internal version. Unknown types are converted to "".
+func Proto3ToTabletType(tabletType pb.TabletType) TabletType {
+	return proto3ToTabletType[tabletType]
+}
@@ -0,0 +1,254 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// avgScaleIncrement is the number of decimal places that an avg
+// adds to the scale of its argument. It matches the MySQL default
+// for div_precision_increment.
+const avgScaleIncrement = 4
+
+// aggregateResult combines the rows of qr, which contains the partial
+// aggregates returned by multiple shards, into one row per group. If the
+// plan has no ORDER BY, the groups are sorted by the GROUP BY columns,
+// like MySQL does. Group keys and the values of min and max are
+// compared byte-wise, so they are rejected if checkComparable fails.
+func aggregateResult(qr *mproto.QueryResult, plan *planbuilder.Plan) error {
+	if len(qr.Fields) != 0 {
+		for _, col := range plan.GroupBy {
+			if err := checkComparable(qr.Fields[col]); err != nil {
+				return err
+			}
+		}
+		for _, aggr := range plan.Aggregates {
+			if aggr.Opcode != planbuilder.AggregateMin && aggr.Opcode != planbuilder.AggregateMax {
+				continue
+			}
+			if err := checkComparable(qr.Fields[aggr.Col]); err != nil {
+				return err
+			}
+		}
+	}
+	var keys []string
+	groups := make(map[string][]sqltypes.Value)
+	buf := bytes.NewBuffer(nil)
+	for _, row := range qr.Rows {
+		buf.Reset()
+		for _, col := range plan.GroupBy {
+			raw := row[col].Raw()
+			if row[col].IsNull() {
+				binary.Write(buf, binary.BigEndian, int32(-1))
+			} else {
+				binary.Write(buf, binary.BigEndian, int32(len(raw)))
+			}
+			buf.Write(raw)
+		}
+		key := buf.String()
+		current, ok := groups[key]
+		if !ok {
+			current = make([]sqltypes.Value, len(row))
+			copy(current, row)
+			groups[key] = current
+			keys = append(keys, key)
+			continue
+		}
+		for _, aggr := range plan.Aggregates {
+			if err := combineAggregate(qr.Fields, aggr, current, row); err != nil {
+				return err
+			}
+		}
+	}
+	rows := make([][]sqltypes.Value, 0, len(keys))
+	for _, key := range keys {
+		rows = append(rows, groups[key])
+	}
+	fields := qr.Fields
+	for _, aggr := range plan.Aggregates {
+		if aggr.Opcode != planbuilder.AggregateAvg {
+			continue
+		}
+		if fields != nil {
+			fields = computeAvgField(fields, aggr)
+		}
+		for _, row := range rows {
+			avg, err := divideValues(row[aggr.Col], row[aggr.CountCol])
+			if err != nil {
+				return err
+			}
+			row[aggr.Col] = avg
+		}
+	}
+	if plan.ResultColumns != 0 {
+		if fields != nil {
+			fields = fields[:plan.ResultColumns]
+		}
+		for i, row := range rows {
+			rows[i] = row[:plan.ResultColumns]
+		}
+	}
+	qr.Fields = fields
+	qr.Rows = rows
+	qr.RowsAffected = uint64(len(rows))
+	if len(plan.OrderBy) != 0 || len(plan.GroupBy) == 0 || len(qr.Fields) == 0 {
+		return nil
+	}
+	cols := make([]orderByColumn, 0, len(plan.GroupBy))
+	for _, col := range plan.GroupBy {
+		cols = append(cols, orderByColumn{field: qr.Fields[col], col: col})
+	}
+	sorter := &rowSorter{rows: qr.Rows, cols: cols}
+	sort.Stable(sorter)
+	return sorter.err
+}
+
+// computeAvgField returns a copy of fields with the field of the
+// avg column renamed and retyped to match what MySQL would return.
+func computeAvgField(fields []mproto.Field, aggr planbuilder.AggregateParams) []mproto.Field {
+	newFields := make([]mproto.Field, len(fields))
+	copy(newFields, fields)
+	field := &newFields[aggr.Col]
+	if aggr.Alias != "" {
+		field.Name = aggr.Alias
+	}
+	if field.Type != mproto.VT_FLOAT && field.Type != mproto.VT_DOUBLE {
+		field.Type = mproto.VT_NEWDECIMAL
+	}
+	return newFields
+}
+
+// combineAggregate folds the partial aggregate of row
+// into current, which holds the aggregate of the group.
+func combineAggregate(fields []mproto.Field, aggr planbuilder.AggregateParams, current, row []sqltypes.Value) error {
+	var err error
+	switch aggr.Opcode {
+	case planbuilder.AggregateCount, planbuilder.AggregateSum:
+		current[aggr.Col], err = addValues(current[aggr.Col], row[aggr.Col])
+	case planbuilder.AggregateAvg:
+		current[aggr.Col], err = addValues(current[aggr.Col], row[aggr.Col])
+		if err != nil {
+			return err
+		}
+		current[aggr.CountCol], err = addValues(current[aggr.CountCol], row[aggr.CountCol])
+	case planbuilder.AggregateMin, planbuilder.AggregateMax:
+		if row[aggr.Col].IsNull() {
+			return nil
+		}
+		if current[aggr.Col].IsNull() {
+			current[aggr.Col] = row[aggr.Col]
+			return nil
+		}
+		cmp, err := compareValues(fields[aggr.Col], current[aggr.Col], row[aggr.Col])
+		if err != nil {
+			return err
+		}
+		if (aggr.Opcode == planbuilder.AggregateMin && cmp > 0) || (aggr.Opcode == planbuilder.AggregateMax && cmp < 0) {
+			current[aggr.Col] = row[aggr.Col]
+		}
+	default:
+		return fmt.Errorf("unsupported aggregate: %s", aggr.Opcode)
+	}
+	return err
+}
+
+// addValues adds two numeric values. NULL values are ignored.
+// Integers are added as int64, and decimals are added exactly.
+func addValues(v1, v2 sqltypes.Value) (sqltypes.Value, error) {
+	if v1.IsNull() {
+		return v2, nil
+	}
+	if v2.IsNull() {
+		return v1, nil
+	}
+	s1, s2 := v1.String(), v2.String()
+	if i1, err := strconv.ParseInt(s1, 10, 64); err == nil {
+		if i2, err := strconv.ParseInt(s2, 10, 64); err == nil {
+			sum := i1 + i2
+			// Fall through to big arithmetic on overflow.
+			if (sum > i1) == (i2 > 0) {
+				return sqltypes.MakeNumeric(strconv.AppendInt(nil, sum, 10)), nil
+			}
+		}
+	}
+	r1, r2, err := parseRats(s1, s2)
+	if err != nil {
+		return sqltypes.Value{}, err
+	}
+	sum := new(big.Rat).Add(r1, r2)
+	if isFloat(s1) || isFloat(s2) {
+		f, _ := sum.Float64()
+		return sqltypes.MakeFractional(strconv.AppendFloat(nil, f, 'g', -1, 64)), nil
+	}
+	scale := decimalScale(s1)
+	if s := decimalScale(s2); s > scale {
+		scale = s
+	}
+	if scale == 0 {
+		return sqltypes.MakeNumeric([]byte(sum.FloatString(0))), nil
+	}
+	return sqltypes.MakeFractional([]byte(sum.FloatString(scale))), nil
+}
+
+// divideValues computes the avg of a sum and a count. It returns
+// NULL if the sum is NULL or the count is 0.
+func divideValues(sum, count sqltypes.Value) (sqltypes.Value, error) {
+	if sum.IsNull() || count.IsNull() {
+		return sqltypes.Value{}, nil
+	}
+	s1, s2 := sum.String(), count.String()
+	r1, r2, err := parseRats(s1, s2)
+	if err != nil {
+		return sqltypes.Value{}, err
+	}
+	if r2.Sign() == 0 {
+		return sqltypes.Value{}, nil
+	}
+	avg := new(big.Rat).Quo(r1, r2)
+	if isFloat(s1) {
+		f, _ := avg.Float64()
+		return sqltypes.MakeFractional(strconv.AppendFloat(nil, f, 'g', -1, 64)), nil
+	}
+	return sqltypes.MakeFractional([]byte(avg.FloatString(decimalScale(s1) + avgScaleIncrement))), nil
+}
+
+func parseRats(s1, s2 string) (*big.Rat, *big.Rat, error) {
+	r1, ok := new(big.Rat).SetString(s1)
+	if !ok {
+		return nil, nil, fmt.Errorf("could not parse value %s as a number", s1)
+	}
+	r2, ok := new(big.Rat).SetString(s2)
+	if !ok {
+		return nil, nil, fmt.Errorf("could not parse value %s as a number", s2)
+	}
+	return r1, r2, nil
+}
+
+// isFloat returns true if the number is in exponent notation,
+// which MySQL uses only for approximate values.
+func isFloat(s string) bool {
+	return strings.ContainsAny(s, "eE")
+}
+
+// decimalScale returns the number of digits after the decimal point.
+func decimalScale(s string) int {
+	if i := strings.IndexByte(s, '.'); i != -1 {
+		return len(s) - i - 1
+	}
+	return 0
+}
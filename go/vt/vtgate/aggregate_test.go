@@ -0,0 +1,71 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"testing"
+
+	"github.com/youtube/vitess/go/sqltypes"
+)
+
+func TestAddValues(t *testing.T) {
+	testcases := []struct {
+		v1, v2 sqltypes.Value
+		want   string
+	}{{
+		v1:   sqltypes.MakeNumeric([]byte("1")),
+		v2:   sqltypes.MakeNumeric([]byte("2")),
+		want: "3",
+	}, {
+		v1:   sqltypes.Value{},
+		v2:   sqltypes.MakeNumeric([]byte("2")),
+		want: "2",
+	}, {
+		v1:   sqltypes.MakeNumeric([]byte("9223372036854775807")),
+		v2:   sqltypes.MakeNumeric([]byte("1")),
+		want: "9223372036854775808",
+	}, {
+		v1:   sqltypes.MakeFractional([]byte("1.25")),
+		v2:   sqltypes.MakeFractional([]byte("2.1")),
+		want: "3.35",
+	}, {
+		v1:   sqltypes.MakeFractional([]byte("1e2")),
+		v2:   sqltypes.MakeFractional([]byte("1.5")),
+		want: "101.5",
+	}}
+	for _, tcase := range testcases {
+		got, err := addValues(tcase.v1, tcase.v2)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if got.String() != tcase.want {
+			t.Errorf("addValues(%v, %v): %s, want %s", tcase.v1, tcase.v2, got.String(), tcase.want)
+		}
+	}
+
+	_, err := addValues(sqltypes.MakeString([]byte("a")), sqltypes.MakeNumeric([]byte("1")))
+	want := "could not parse value a as a number"
+	if err == nil || err.Error() != want {
+		t.Errorf("addValues: %v, want %s", err, want)
+	}
+}
+
+func TestDivideValues(t *testing.T) {
+	got, err := divideValues(sqltypes.MakeFractional([]byte("10.5")), sqltypes.MakeNumeric([]byte("4")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2.62500"; got.String() != want {
+		t.Errorf("divideValues: %s, want %s", got.String(), want)
+	}
+	got, err = divideValues(sqltypes.MakeNumeric([]byte("10")), sqltypes.MakeNumeric([]byte("0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsNull() {
+		t.Errorf("divideValues: %v, want NULL", got)
+	}
+}
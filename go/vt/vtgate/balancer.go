@@ -13,14 +13,24 @@ import (
 	"time"
 
 	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/tabletmanager/tmclient"
 	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
 )
 
-var resetDownConnDelay = flag.Duration("reset-down-conn-delay", 10*time.Minute, "delay to reset a marked down tabletconn")
+var (
+	resetDownConnDelay = flag.Duration("reset-down-conn-delay", 10*time.Minute, "delay to reset a marked down tabletconn")
+	maxReplicationLag  = flag.Duration("balancer_max_replication_lag", 0, "if set, vtgate streams the health of the replica and rdonly tablets, and only sends queries to the ones that are serving with a replication lag under this value, least lagged first. If they are all lagging, the least lagged ones are used. 0 disables the health streams.")
+)
 
 // GetEndPointsFunc defines the callback to topo server.
 type GetEndPointsFunc func() (*topo.EndPoints, error)
 
+// HealthStreamFunc opens the health stream of an endpoint,
+// as tmclient.TabletManagerClient.HealthStream does for a tablet.
+type HealthStreamFunc func(ctx context.Context, endPoint topo.EndPoint) (<-chan *actionnode.HealthStreamReply, tmclient.ErrFunc, error)
+
 // Balancer is a simple round-robin load balancer.
 // It allows you to temporarily mark down nodes that
 // are non-functional.
@@ -31,12 +41,27 @@ type Balancer struct {
 	getEndPoints       GetEndPointsFunc
 	retryDelay         time.Duration
 	resetDownConnDelay time.Duration
+
+	// healthStream is set if the balancer streams the health
+	// of the endpoints. See EnableHealthStreams.
+	healthStream      HealthStreamFunc
+	maxReplicationLag time.Duration
+	// onUnusable is called when the health stream of an endpoint
+	// reports it as unhealthy or lagging. See OnUnusable.
+	onUnusable func(uid uint32)
 }
 
 type addressStatus struct {
 	endPoint  topo.EndPoint
 	timeRetry time.Time
 	balancer  *Balancer
+
+	// The following fields are only used with health streams.
+	// healthKnown is false until the endpoint reports its health.
+	cancelHealthStream context.CancelFunc
+	healthKnown        bool
+	healthError        string
+	replicationDelay   time.Duration
 }
 
 // NewBalancer creates a Balancer. getAddresses is the function
@@ -52,6 +77,38 @@ func NewBalancer(getEndPoints GetEndPointsFunc, retryDelay time.Duration) *Balan
 	return blc
 }
 
+// EnableHealthStreams makes the balancer stream the health of its
+// endpoints with healthStream. Get then only returns the serving
+// endpoints with a replication lag under maxReplicationLag, least
+// lagged first. If there are none, it returns all of them: the ones
+// that didn't report their health yet, then the lagging ones, least
+// lagged first, then the unhealthy ones. It must be called before Get.
+func (blc *Balancer) EnableHealthStreams(healthStream HealthStreamFunc, maxReplicationLag time.Duration) {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	blc.healthStream = healthStream
+	blc.maxReplicationLag = maxReplicationLag
+}
+
+// OnUnusable sets the function called when the health stream of an
+// endpoint reports it as unhealthy or lagging, so the users of the
+// endpoint can reconnect to a better one.
+func (blc *Balancer) OnUnusable(onUnusable func(uid uint32)) {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	blc.onUnusable = onUnusable
+}
+
+// Close stops the health streams, if any.
+func (blc *Balancer) Close() {
+	blc.mu.Lock()
+	defer blc.mu.Unlock()
+	blc.healthStream = nil
+	for _, addrNode := range blc.addressNodes {
+		addrNode.stopHealthStream()
+	}
+}
+
 // Get returns a single endpoint that was not recently marked down.
 // If it finds an address that was down for longer than retryDelay,
 // it refreshes the list of addresses and returns the next available
@@ -76,18 +133,42 @@ func (blc *Balancer) Get() (endPoints []topo.EndPoint, err error) {
 
 	// Return all endpoints without markdown and timeRetry < now(),
 	// so endpoints just marked down (within retryDelay) are ignored.
-	validEndPoints := make([]topo.EndPoint, 0, 1)
+	validNodes := make([]*addressStatus, 0, 1)
 	for _, addrNode := range blc.addressNodes {
 		if addrNode.timeRetry.IsZero() || addrNode.timeRetry.Before(time.Now()) {
-			validEndPoints = append(validEndPoints, addrNode.endPoint)
+			validNodes = append(validNodes, addrNode)
 			continue
 		}
 		break
 	}
+	if blc.healthStream != nil {
+		validNodes = blc.preferLeastLagged(validNodes)
+	}
 
+	validEndPoints := make([]topo.EndPoint, 0, len(validNodes))
+	for _, addrNode := range validNodes {
+		validEndPoints = append(validEndPoints, addrNode.endPoint)
+	}
 	return validEndPoints, nil
 }
 
+// preferLeastLagged sorts addressNodes by health and replication lag,
+// and returns the healthy ones under maxReplicationLag. If there
+// are none, it returns them all. The sort is stable, so the
+// endpoints with the same lag stay shuffled.
+func (blc *Balancer) preferLeastLagged(addressNodes []*addressStatus) []*addressStatus {
+	sort.Stable(byReplicationLag{addressNodes, blc.maxReplicationLag})
+	for i, addrNode := range addressNodes {
+		if !addrNode.usable(blc.maxReplicationLag) {
+			if i == 0 {
+				return addressNodes
+			}
+			return addressNodes[:i]
+		}
+	}
+	return addressNodes
+}
+
 // MarkDown marks the specified address down. Such addresses
 // will not be used by Balancer for the duration of retryDelay.
 func (blc *Balancer) MarkDown(uid uint32, reason string) {
@@ -112,6 +193,9 @@ func (blc *Balancer) refresh() error {
 					endPoint: endPoint,
 					balancer: blc,
 				}
+				if blc.healthStream != nil {
+					addrNode.startHealthStream(blc.healthStream)
+				}
 				blc.addressNodes = append(blc.addressNodes, addrNode)
 			} else {
 				blc.addressNodes[index].endPoint = endPoint
@@ -122,6 +206,7 @@ func (blc *Balancer) refresh() error {
 	i := 0
 	for i < len(blc.addressNodes) {
 		if index := findAddress(endPoints, blc.addressNodes[i].endPoint.Uid); index == -1 {
+			blc.addressNodes[i].stopHealthStream()
 			blc.addressNodes = delAddrNode(blc.addressNodes, i)
 			continue
 		}
@@ -137,6 +222,117 @@ func (blc *Balancer) refresh() error {
 	return nil
 }
 
+// startHealthStream starts streaming the health of the endpoint.
+// The balancer lock must be held.
+func (addrNode *addressStatus) startHealthStream(healthStream HealthStreamFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	addrNode.cancelHealthStream = cancel
+	go addrNode.streamHealth(ctx, addrNode.endPoint, healthStream)
+}
+
+// stopHealthStream stops the health stream of the endpoint, if any.
+// The balancer lock must be held.
+func (addrNode *addressStatus) stopHealthStream() {
+	if addrNode.cancelHealthStream != nil {
+		addrNode.cancelHealthStream()
+		addrNode.cancelHealthStream = nil
+	}
+}
+
+// streamHealth keeps the health of the endpoint up to date until
+// ctx is done. While the stream is broken, the endpoint is unhealthy,
+// and the stream is reopened every retryDelay.
+func (addrNode *addressStatus) streamHealth(ctx context.Context, endPoint topo.EndPoint, healthStream HealthStreamFunc) {
+	for {
+		stream, errFunc, err := healthStream(ctx, endPoint)
+		if err == nil {
+			for hsr := range stream {
+				addrNode.setHealth(hsr)
+			}
+			err = errFunc()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = fmt.Errorf("health stream closed")
+		}
+		log.Warningf("Health stream of %v at %+v failed: %v", endPoint.Uid, endPoint, err)
+		addrNode.setHealth(&actionnode.HealthStreamReply{HealthError: err.Error()})
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(addrNode.balancer.retryDelay):
+		}
+	}
+}
+
+// setHealth records the health reported by the tablet. A tablet
+// that is not in the serving graph anymore is unhealthy. If the
+// endpoint becomes unhealthy or lagging, onUnusable is called.
+func (addrNode *addressStatus) setHealth(hsr *actionnode.HealthStreamReply) {
+	blc := addrNode.balancer
+	blc.mu.Lock()
+	wasUnusable := addrNode.healthKnown && !addrNode.usable(blc.maxReplicationLag)
+	addrNode.healthKnown = true
+	addrNode.healthError = hsr.HealthError
+	if addrNode.healthError == "" && hsr.Tablet != nil && !topo.IsInServingGraph(hsr.Tablet.Type) {
+		addrNode.healthError = fmt.Sprintf("tablet type %v is not serving", hsr.Tablet.Type)
+	}
+	addrNode.replicationDelay = hsr.ReplicationDelay
+	onUnusable := blc.onUnusable
+	becameUnusable := !wasUnusable && !addrNode.usable(blc.maxReplicationLag)
+	blc.mu.Unlock()
+
+	if becameUnusable && onUnusable != nil {
+		onUnusable(addrNode.endPoint.Uid)
+	}
+}
+
+// usable returns true if the endpoint reported that
+// it's healthy with a replication lag under maxLag.
+func (addrNode *addressStatus) usable(maxLag time.Duration) bool {
+	return addrNode.healthKnown && addrNode.healthError == "" && addrNode.replicationDelay <= maxLag
+}
+
+// healthRank orders the endpoints by health: the usable ones,
+// then the ones that didn't report their health yet, then the
+// lagging ones, then the unhealthy ones.
+func (addrNode *addressStatus) healthRank(maxLag time.Duration) int {
+	switch {
+	case addrNode.usable(maxLag):
+		return 0
+	case !addrNode.healthKnown:
+		return 1
+	case addrNode.healthError == "":
+		return 2
+	}
+	return 3
+}
+
+// byReplicationLag sorts the endpoints by healthRank,
+// then by increasing replication lag.
+type byReplicationLag struct {
+	nodes  []*addressStatus
+	maxLag time.Duration
+}
+
+func (bl byReplicationLag) Len() int {
+	return len(bl.nodes)
+}
+
+func (bl byReplicationLag) Swap(i, j int) {
+	bl.nodes[i], bl.nodes[j] = bl.nodes[j], bl.nodes[i]
+}
+
+func (bl byReplicationLag) Less(i, j int) bool {
+	if rankI, rankJ := bl.nodes[i].healthRank(bl.maxLag), bl.nodes[j].healthRank(bl.maxLag); rankI != rankJ {
+		return rankI < rankJ
+	}
+	return bl.nodes[i].replicationDelay < bl.nodes[j].replicationDelay
+}
+
 // AddressList is the slice of addressStatus.
 type AddressList []*addressStatus
 
@@ -6,10 +6,15 @@ package vtgate
 
 import (
 	"fmt"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/tabletmanager/tmclient"
 	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
 )
 
 var (
@@ -246,3 +251,145 @@ func TestRefresh(t *testing.T) {
 		t.Errorf("want 12, got %v", portNew)
 	}
 }
+
+// fakeHealthStreams implements HealthStreamFunc with one
+// channel per endpoint uid.
+type fakeHealthStreams struct {
+	mu      sync.Mutex
+	streams map[uint32]chan *actionnode.HealthStreamReply
+	closed  map[uint32]bool
+}
+
+func newFakeHealthStreams() *fakeHealthStreams {
+	return &fakeHealthStreams{
+		streams: make(map[uint32]chan *actionnode.HealthStreamReply),
+		closed:  make(map[uint32]bool),
+	}
+}
+
+func (fhs *fakeHealthStreams) stream(uid uint32) chan *actionnode.HealthStreamReply {
+	fhs.mu.Lock()
+	defer fhs.mu.Unlock()
+	c, ok := fhs.streams[uid]
+	if !ok {
+		c = make(chan *actionnode.HealthStreamReply)
+		fhs.streams[uid] = c
+	}
+	return c
+}
+
+func (fhs *fakeHealthStreams) healthStream(ctx context.Context, endPoint topo.EndPoint) (<-chan *actionnode.HealthStreamReply, tmclient.ErrFunc, error) {
+	in := fhs.stream(endPoint.Uid)
+	out := make(chan *actionnode.HealthStreamReply)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				fhs.mu.Lock()
+				fhs.closed[endPoint.Uid] = true
+				fhs.mu.Unlock()
+				return
+			case hsr := <-in:
+				out <- hsr
+			}
+		}
+	}()
+	return out, func() error { return nil }, nil
+}
+
+func (fhs *fakeHealthStreams) send(uid uint32, hsr *actionnode.HealthStreamReply) {
+	fhs.stream(uid) <- hsr
+}
+
+func waitForUids(t *testing.T, b *Balancer, want []uint32) {
+	var got []uint32
+	for i := 0; i < 100; i++ {
+		endPoints, err := b.Get()
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		got = nil
+		for _, endPoint := range endPoints {
+			got = append(got, endPoint.Uid)
+		}
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Get() returned uids %v, want %v", got, want)
+}
+
+func TestHealthStreams(t *testing.T) {
+	fhs := newFakeHealthStreams()
+	b := NewBalancer(endPoints3, RetryDelay)
+	b.EnableHealthStreams(fhs.healthStream, 10*time.Second)
+
+	// Without health data, all the endpoints are used.
+	endPoints, _ := b.Get()
+	if len(endPoints) != 3 {
+		t.Errorf("want 3 endpoints, got %v", endPoints)
+	}
+
+	// Lagging and unhealthy endpoints are skipped,
+	// the least lagged ones come first.
+	fhs.send(0, &actionnode.HealthStreamReply{ReplicationDelay: time.Hour})
+	fhs.send(1, &actionnode.HealthStreamReply{ReplicationDelay: 2 * time.Second})
+	fhs.send(2, &actionnode.HealthStreamReply{ReplicationDelay: time.Second})
+	waitForUids(t, b, []uint32{2, 1})
+	fhs.send(2, &actionnode.HealthStreamReply{HealthError: "replication is not running"})
+	waitForUids(t, b, []uint32{1})
+	fhs.send(1, &actionnode.HealthStreamReply{Tablet: &topo.Tablet{Type: topo.TYPE_SPARE}})
+	// If all the endpoints are lagging or unhealthy,
+	// they are all used, healthy and least lagged first.
+	waitForUids(t, b, []uint32{0, 1, 2})
+
+	// Close stops the health streams.
+	b.Close()
+	for i := 0; i < 100; i++ {
+		fhs.mu.Lock()
+		closed := len(fhs.closed)
+		fhs.mu.Unlock()
+		if closed == 3 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("health streams were not stopped: %v", fhs.closed)
+}
+
+func TestHealthStreamsUnusable(t *testing.T) {
+	fhs := newFakeHealthStreams()
+	b := NewBalancer(endPoints3, RetryDelay)
+	defer b.Close()
+	b.EnableHealthStreams(fhs.healthStream, 10*time.Second)
+	unusable := make(chan uint32, 10)
+	b.OnUnusable(func(uid uint32) {
+		unusable <- uid
+	})
+	b.Get()
+
+	// The endpoints that didn't report their health yet
+	// are not used once another one is known to be usable.
+	fhs.send(0, &actionnode.HealthStreamReply{ReplicationDelay: time.Second})
+	waitForUids(t, b, []uint32{0})
+
+	// OnUnusable is called when the endpoint starts lagging,
+	// but not again while it's still lagging.
+	fhs.send(0, &actionnode.HealthStreamReply{ReplicationDelay: time.Hour})
+	select {
+	case uid := <-unusable:
+		if uid != 0 {
+			t.Errorf("OnUnusable called for %v, want 0", uid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("OnUnusable was not called")
+	}
+	fhs.send(0, &actionnode.HealthStreamReply{ReplicationDelay: 2 * time.Hour})
+	fhs.send(0, &actionnode.HealthStreamReply{ReplicationDelay: time.Second})
+	waitForUids(t, b, []uint32{0})
+	if len(unusable) != 0 {
+		t.Errorf("OnUnusable called again for %v", <-unusable)
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// explainFields are the fields of the result of an explain.
+var explainFields = []mproto.Field{
+	{Name: "plan_id", Type: mproto.VT_VAR_STRING},
+	{Name: "reason", Type: mproto.VT_VAR_STRING},
+	{Name: "keyspace", Type: mproto.VT_VAR_STRING},
+	{Name: "vindex", Type: mproto.VT_VAR_STRING},
+	{Name: "shard", Type: mproto.VT_VAR_STRING},
+	{Name: "query", Type: mproto.VT_VAR_STRING},
+	{Name: "bind_vars", Type: mproto.VT_VAR_STRING},
+}
+
+// explainKeywords are the keywords that make VTGate explain
+// the routing of a query instead of executing it.
+var explainKeywords = []string{"explain", "vexplain"}
+
+// explainTarget returns the query to explain if sql
+// is an explain statement. The keyword can be followed
+// by any whitespace.
+func explainTarget(sql string) (string, bool) {
+	sql = strings.TrimSpace(sql)
+	end := strings.IndexFunc(sql, unicode.IsSpace)
+	if end == -1 {
+		return "", false
+	}
+	for _, keyword := range explainKeywords {
+		if strings.EqualFold(sql[:end], keyword) {
+			return strings.TrimSpace(sql[end:]), true
+		}
+	}
+	return "", false
+}
+
+// explain returns the routing decision of VTGate for a query
+// without executing it. The result has one row for every shard
+// that the query would be sent to. Vindexes are used to resolve
+// the shards, but no vindex entries are created or deleted.
+func (rtr *Router) explain(vcursor *requestContext, sql string) (*mproto.QueryResult, error) {
+	query := &proto.Query{
+		Sql:              sql,
+		BindVariables:    vcursor.query.BindVariables,
+		TabletType:       vcursor.query.TabletType,
+		Session:          vcursor.query.Session,
+		NotInTransaction: vcursor.query.NotInTransaction,
+	}
+	plan := rtr.planner.GetPlan(sql)
+	rows, err := rtr.explainPlan(newRequestContext(vcursor.ctx, query, rtr), plan)
+	if err != nil {
+		return nil, fmt.Errorf("explain: %v", err)
+	}
+	return &mproto.QueryResult{
+		Fields:       explainFields,
+		RowsAffected: uint64(len(rows)),
+		Rows:         rows,
+	}, nil
+}
+
+func (rtr *Router) explainPlan(vcursor *requestContext, plan *planbuilder.Plan) ([][]sqltypes.Value, error) {
+	switch plan.ID {
+	case planbuilder.NoPlan:
+		return [][]sqltypes.Value{explainRow(plan, "", "", "", nil)}, nil
+	case planbuilder.SelectJoin:
+		rows, err := rtr.explainPlan(vcursor, plan.Join.Left)
+		if err != nil {
+			return nil, err
+		}
+		// The shards of the Right plan depend on the rows
+		// returned by the Left plan, so they're not resolved.
+		right := plan.Join.Right
+		return append(rows, explainRow(right, right.Table.Keyspace.Name, "", right.Rewritten, nil)), nil
+	case planbuilder.UpdateEqual, planbuilder.DeleteEqual:
+		keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
+		if err != nil {
+			return nil, err
+		}
+		ks, shard, ksid, err := rtr.resolveSingleShard(vcursor, keys[0], plan)
+		if err != nil {
+			return nil, err
+		}
+		if ksid == key.MinKey {
+			return nil, nil
+		}
+		return [][]sqltypes.Value{explainRow(plan, ks, shard, plan.Rewritten+fmt.Sprintf(dmlPostfix, ksid), vcursor.query.BindVariables)}, nil
+	case planbuilder.InsertSharded:
+		return rtr.explainInsert(vcursor, plan)
+	}
+	params, err := rtr.routeParams(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := rtr.resolveLimit(vcursor, plan, params); err != nil {
+		return nil, err
+	}
+	shards := make([]string, 0, len(params.shardVars))
+	for shard := range params.shardVars {
+		shards = append(shards, shard)
+	}
+	sort.Strings(shards)
+	rows := make([][]sqltypes.Value, 0, len(shards))
+	for _, shard := range shards {
+		rows = append(rows, explainRow(plan, params.ks, shard, params.query, params.shardVars[shard]))
+	}
+	return rows, nil
+}
+
+// explainInsert explains an InsertSharded plan. Only the primary vindex
+// values are mapped, because the other vindexes could create entries.
+// If a primary vindex value must be generated, the shard is left empty.
+// Values are not fetched from sequences for the same reason.
+func (rtr *Router) explainInsert(vcursor *requestContext, plan *planbuilder.Plan) ([][]sqltypes.Value, error) {
+	bindVars := vcursor.query.BindVariables
+	if plan.Generate != nil {
+		vals, err := rtr.resolveKeys(plan.Generate.Values, bindVars)
+		if err != nil {
+			return nil, err
+		}
+		bindVars = make(map[string]interface{}, len(vcursor.query.BindVariables)+len(vals))
+		for k, v := range vcursor.query.BindVariables {
+			bindVars[k] = v
+		}
+		for i, val := range vals {
+			bindVars[planbuilder.GenerateVarName(i)] = val
+		}
+	}
+	rows := [][]interface{}{plan.Values.([]interface{})}
+	if plan.Mid != nil {
+		rows = rows[:0]
+		for _, row := range plan.Values.([]interface{}) {
+			rows = append(rows, row.([]interface{}))
+		}
+	}
+	ks, _, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType)
+	if err != nil {
+		return nil, err
+	}
+	var shards []string
+	shardRows := make(map[string][]int)
+	for i, row := range rows {
+		keys, err := rtr.resolveKeys(row[:1], bindVars)
+		if err != nil {
+			return nil, err
+		}
+		shard := ""
+		if keys[0] != nil {
+			ksids, err := plan.Table.ColVindexes[0].Vindex.(planbuilder.Unique).Map(vcursor, keys)
+			if err != nil {
+				return nil, err
+			}
+			if ksids[0] == key.MinKey {
+				return nil, fmt.Errorf("could not map %v to a keyspace id", keys[0])
+			}
+			if shard, err = getShardForKeyspaceId(allShards, ksids[0]); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := shardRows[shard]; !ok {
+			shards = append(shards, shard)
+		}
+		shardRows[shard] = append(shardRows[shard], i)
+	}
+	out := make([][]sqltypes.Value, 0, len(shards))
+	for _, shard := range shards {
+		query := plan.Rewritten
+		if plan.Mid != nil {
+			mids := make([]string, 0, len(shardRows[shard]))
+			for _, i := range shardRows[shard] {
+				mids = append(mids, plan.Mid[i])
+			}
+			query = plan.Prefix + strings.Join(mids, ", ") + plan.Suffix
+		}
+		out = append(out, explainRow(plan, ks, shard, query, bindVars))
+	}
+	return out, nil
+}
+
+func explainRow(plan *planbuilder.Plan, ks, shard, query string, bindVars map[string]interface{}) []sqltypes.Value {
+	vindex := ""
+	if plan.ColVindex != nil {
+		vindex = plan.ColVindex.Name
+	}
+	bv := ""
+	if len(bindVars) != 0 {
+		if b, err := json.Marshal(bindVars); err == nil {
+			bv = string(b)
+		} else {
+			bv = err.Error()
+		}
+	}
+	return []sqltypes.Value{
+		sqltypes.MakeString([]byte(plan.ID.String())),
+		sqltypes.MakeString([]byte(plan.Reason)),
+		sqltypes.MakeString([]byte(ks)),
+		sqltypes.MakeString([]byte(vindex)),
+		sqltypes.MakeString([]byte(shard)),
+		sqltypes.MakeString([]byte(query)),
+		sqltypes.MakeString([]byte(bv)),
+	}
+}
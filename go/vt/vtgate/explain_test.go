@@ -0,0 +1,141 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+func TestExplainTarget(t *testing.T) {
+	testcases := []struct {
+		in, out string
+		ok      bool
+	}{
+		{"explain select * from user", "select * from user", true},
+		{"  EXPLAIN  select 1 from dual", "select 1 from dual", true},
+		{"vexplain select 1 from dual", "select 1 from dual", true},
+		{"explain\tselect 1 from dual", "select 1 from dual", true},
+		{"EXPLAIN\n  select 1\nfrom dual", "select 1\nfrom dual", true},
+		{"explain", "", false},
+		{"select explain from user", "", false},
+		{"explainselect", "", false},
+	}
+	for _, tc := range testcases {
+		out, ok := explainTarget(tc.in)
+		if out != tc.out || ok != tc.ok {
+			t.Errorf("explainTarget(%q): %q, %v, want %q, %v", tc.in, out, ok, tc.out, tc.ok)
+		}
+	}
+}
+
+func explainResultRows(rows [][]sqltypes.Value) [][]string {
+	var out [][]string
+	for _, row := range rows {
+		var srow []string
+		for _, v := range row {
+			srow = append(srow, v.String())
+		}
+		out = append(out, srow)
+	}
+	return out
+}
+
+func TestExplain(t *testing.T) {
+	router, sbc1, sbc2, _ := createRouterEnv()
+
+	testcases := []struct {
+		sql  string
+		bv   map[string]interface{}
+		want [][]string
+	}{{
+		sql: "explain select * from user where id = 1",
+		want: [][]string{
+			{"SelectEqual", "", "TestRouter", "user_index", "-20", "select * from user where id = 1", ""},
+		},
+	}, {
+		sql: "explain select * from user where id in (1, 3)",
+		want: [][]string{
+			{"SelectIN", "", "TestRouter", "user_index", "-20", "select * from user where id in ::_vals", `{"_vals":[1]}`},
+			{"SelectIN", "", "TestRouter", "user_index", "40-60", "select * from user where id in ::_vals", `{"_vals":[3]}`},
+		},
+	}, {
+		sql: "explain select * from user where id = :id",
+		bv:  map[string]interface{}{"id": 3},
+		want: [][]string{
+			{"SelectEqual", "", "TestRouter", "user_index", "40-60", "select * from user where id = :id", `{"id":3}`},
+		},
+	}, {
+		sql: "explain update user set a = 1 where id = 1",
+		want: [][]string{
+			{"UpdateEqual", "", "TestRouter", "user_index", "-20", "update user set a = 1 where id = 1 /* _routing keyspace_id:166b40b44aba4bd6 */", ""},
+		},
+	}, {
+		sql: "explain insert into user(id, name) values (1, 'a'), (3, 'b')",
+		want: [][]string{
+			{"InsertSharded", "", "TestRouter", "", "-20", "insert into user(id, name) values (:_id_0, :_name_0)", ""},
+			{"InsertSharded", "", "TestRouter", "", "40-60", "insert into user(id, name) values (:_id_1, :_name_1)", ""},
+		},
+	}, {
+		sql: "explain insert into autoinc_table(v) values (2)",
+		want: [][]string{
+			{"InsertSharded", "", "TestRouter", "", "", "insert into autoinc_table(v, id) values (2, :_id)", `{"__seq0":null}`},
+		},
+	}, {
+		sql: "explain select * from music_user_map",
+		want: [][]string{
+			{"SelectUnsharded", "", "TestUnsharded", "", "0", "select * from music_user_map", ""},
+		},
+	}, {
+		sql: "explain select * from nosuchtable",
+		want: [][]string{
+			{"NoPlan", "table nosuchtable not found", "", "", "", "", ""},
+		},
+	}}
+	for _, tc := range testcases {
+		result, err := routerExec(router, tc.sql, tc.bv)
+		if err != nil {
+			t.Errorf("routerExec(%s): %v", tc.sql, err)
+			continue
+		}
+		if len(result.Fields) != 7 || result.Fields[0].Name != "plan_id" {
+			t.Errorf("routerExec(%s) fields: %+v, want explain fields", tc.sql, result.Fields)
+		}
+		if got := explainResultRows(result.Rows); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("routerExec(%s):\n%q, want\n%q", tc.sql, got, tc.want)
+		}
+	}
+	if sbc1.Queries != nil || sbc2.Queries != nil {
+		t.Errorf("explain sent queries to shards: %+v, %+v", sbc1.Queries, sbc2.Queries)
+	}
+}
+
+func TestStreamExplain(t *testing.T) {
+	router, sbc1, sbc2, _ := createRouterEnv()
+
+	result, err := routerStream(router, &proto.Query{
+		Sql:        "explain\nselect * from user where id = 1",
+		TabletType: topo.TYPE_MASTER,
+	})
+	if err != nil {
+		t.Fatalf("routerStream: %v", err)
+	}
+	if len(result.Fields) != 7 || result.Fields[0].Name != "plan_id" {
+		t.Errorf("routerStream fields: %+v, want explain fields", result.Fields)
+	}
+	want := [][]string{
+		{"SelectEqual", "", "TestRouter", "user_index", "-20", "select * from user where id = 1", ""},
+	}
+	if got := explainResultRows(result.Rows); !reflect.DeepEqual(got, want) {
+		t.Errorf("routerStream:\n%q, want\n%q", got, want)
+	}
+	if sbc1.Queries != nil || sbc2.Queries != nil {
+		t.Errorf("explain sent queries to shards: %+v, %+v", sbc1.Queries, sbc2.Queries)
+	}
+}
@@ -228,18 +228,19 @@ func (conn *FakeVTGateConn) StreamExecuteKeyspaceIds(ctx context.Context, query
 }
 
 // Begin please see vtgateconn.Impl.Begin
-func (conn *FakeVTGateConn) Begin(ctx context.Context) (interface{}, error) {
+func (conn *FakeVTGateConn) Begin(ctx context.Context, session interface{}, transactionMode string) (interface{}, error) {
 	return &proto.Session{
-		InTransaction: true,
+		InTransaction:   true,
+		TransactionMode: transactionMode,
 	}, nil
 }
 
 // Commit please see vtgateconn.Impl.Commit
-func (conn *FakeVTGateConn) Commit(ctx context.Context, session interface{}) error {
+func (conn *FakeVTGateConn) Commit(ctx context.Context, session interface{}) (interface{}, error) {
 	if session == nil {
-		return errors.New("commit: not in transaction")
+		return nil, errors.New("commit: not in transaction")
 	}
-	return nil
+	return &proto.Session{}, nil
 }
 
 // Rollback please see vtgateconn.Impl.Rollback
@@ -247,6 +248,11 @@ func (conn *FakeVTGateConn) Rollback(ctx context.Context, session interface{}) e
 	return nil
 }
 
+// ReadAfterWriteSession please see vtgateconn.Impl.ReadAfterWriteSession
+func (conn *FakeVTGateConn) ReadAfterWriteSession() interface{} {
+	return &proto.Session{ReadAfterWrite: true}
+}
+
 // SplitQuery please see vtgateconn.Impl.SplitQuery
 func (conn *FakeVTGateConn) SplitQuery(ctx context.Context, keyspace string, query tproto.BoundQuery, splitCount int) ([]proto.SplitQueryPart, error) {
 	response, ok := conn.splitQueryMap[getSplitQueryKey(keyspace, &query, splitCount)]
@@ -272,17 +272,26 @@ func sendStreamResults(c *rpcplus.Call, sr chan *proto.QueryResult) (<-chan *mpr
 	return srout, func() error { return c.Error }
 }
 
-func (conn *vtgateConn) Begin(ctx context.Context) (interface{}, error) {
-	session := &proto.Session{}
-	if err := conn.rpcConn.Call(ctx, "VTGate.Begin", &rpc.Unused{}, session); err != nil {
+func (conn *vtgateConn) Begin(ctx context.Context, session interface{}, transactionMode string) (interface{}, error) {
+	inSession := &proto.Session{}
+	if session != nil {
+		*inSession = *session.(*proto.Session)
+	}
+	inSession.TransactionMode = transactionMode
+	outSession := &proto.Session{}
+	if err := conn.rpcConn.Call(ctx, "VTGate.Begin", inSession, outSession); err != nil {
 		return nil, err
 	}
-	return session, nil
+	return outSession, nil
 }
 
-func (conn *vtgateConn) Commit(ctx context.Context, session interface{}) error {
+func (conn *vtgateConn) Commit(ctx context.Context, session interface{}) (interface{}, error) {
 	s := session.(*proto.Session)
-	return conn.rpcConn.Call(ctx, "VTGate.Commit", s, &rpc.Unused{})
+	outSession := &proto.Session{}
+	if err := conn.rpcConn.Call(ctx, "VTGate.Commit", s, outSession); err != nil {
+		return nil, err
+	}
+	return outSession, nil
 }
 
 func (conn *vtgateConn) Rollback(ctx context.Context, session interface{}) error {
@@ -290,6 +299,10 @@ func (conn *vtgateConn) Rollback(ctx context.Context, session interface{}) error
 	return conn.rpcConn.Call(ctx, "VTGate.Rollback", s, &rpc.Unused{})
 }
 
+func (conn *vtgateConn) ReadAfterWriteSession() interface{} {
+	return &proto.Session{ReadAfterWrite: true}
+}
+
 func (conn *vtgateConn) SplitQuery(ctx context.Context, keyspace string, query tproto.BoundQuery, splitCount int) ([]proto.SplitQueryPart, error) {
 	request := &proto.SplitQueryRequest{
 		Keyspace:   keyspace,
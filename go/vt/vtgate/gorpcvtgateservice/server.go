@@ -118,19 +118,27 @@ func (vtg *VTGate) StreamExecuteKeyspaceIds(ctx context.Context, query *proto.Ke
 }
 
 // Begin is the RPC version of vtgateservice.VTGateService method
-func (vtg *VTGate) Begin(ctx context.Context, noInput *rpc.Unused, outSession *proto.Session) (err error) {
+// The transaction mode and the read-after-write state of inSession
+// are carried over to outSession.
+func (vtg *VTGate) Begin(ctx context.Context, inSession *proto.Session, outSession *proto.Session) (err error) {
 	defer vtg.server.HandlePanic(&err)
 	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(*rpcTimeout))
 	defer cancel()
+	outSession.TransactionMode = inSession.TransactionMode
+	outSession.ReadAfterWrite = inSession.ReadAfterWrite
+	outSession.WritePositions = inSession.WritePositions
 	return vtg.server.Begin(ctx, outSession)
 }
 
 // Commit is the RPC version of vtgateservice.VTGateService method
-func (vtg *VTGate) Commit(ctx context.Context, inSession *proto.Session, noOutput *rpc.Unused) (err error) {
+// The returned session carries the write positions of the transaction.
+func (vtg *VTGate) Commit(ctx context.Context, inSession *proto.Session, outSession *proto.Session) (err error) {
 	defer vtg.server.HandlePanic(&err)
 	ctx, cancel := context.WithDeadline(ctx, time.Now().Add(*rpcTimeout))
 	defer cancel()
-	return vtg.server.Commit(ctx, inSession)
+	err = vtg.server.Commit(ctx, inSession)
+	*outSession = *inSession
+	return err
 }
 
 // Rollback is the RPC version of vtgateservice.VTGateService method
@@ -0,0 +1,384 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package grpcvtgateconn provides gRPC connectivity for VTGate.
+package grpcvtgateconn
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/key"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/vtgateconn"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	pbq "github.com/youtube/vitess/go/vt/proto/query"
+	pb "github.com/youtube/vitess/go/vt/proto/vtgate"
+	pbs "github.com/youtube/vitess/go/vt/proto/vtgateservice"
+	pbv "github.com/youtube/vitess/go/vt/proto/vtrpc"
+)
+
+func init() {
+	vtgateconn.RegisterDialer(vtgateconn.GRPCProtocol, dial)
+}
+
+// vtgateConn implements vtgateconn.Impl over gRPC. The sessions it
+// returns are the *pb.Session sent by vtgate, which it sends back as is.
+type vtgateConn struct {
+	cc *grpc.ClientConn
+	c  pbs.VitessClient
+}
+
+func dial(ctx context.Context, address string, timeout time.Duration) (vtgateconn.Impl, error) {
+	cc, err := grpc.Dial(address, grpc.WithTimeout(timeout))
+	if err != nil {
+		return nil, err
+	}
+	return &vtgateConn{
+		cc: cc,
+		c:  pbs.NewVitessClient(cc),
+	}, nil
+}
+
+// sessionToProto3 returns the session passed to an Impl method.
+func sessionToProto3(session interface{}) *pb.Session {
+	if session == nil {
+		return nil
+	}
+	return session.(*pb.Session)
+}
+
+// errorFromRPCError returns the application error sent by vtgate.
+func errorFromRPCError(rpcErr *pbv.RPCError) error {
+	if rpcErr == nil {
+		return nil
+	}
+	return errors.New(rpcErr.Message)
+}
+
+// proto3ToQueryResult returns the result sent by vtgate,
+// which is nil for a statement that failed.
+func proto3ToQueryResult(qr *pbq.QueryResult) *mproto.QueryResult {
+	if qr == nil {
+		return nil
+	}
+	return tproto.Proto3ToQueryResult(qr)
+}
+
+// returnedSession returns the session sent back by vtgate. It can
+// be nil, for instance if the session was not in a transaction.
+func returnedSession(session *pb.Session) interface{} {
+	if session == nil {
+		return nil
+	}
+	return session
+}
+
+func (conn *vtgateConn) Execute(ctx context.Context, query string, bindVars map[string]interface{}, tabletType topo.TabletType, notInTransaction bool, session interface{}) (*mproto.QueryResult, interface{}, error) {
+	request := &pb.ExecuteRequest{
+		Session:          sessionToProto3(session),
+		Query:            tproto.BoundQueryToProto3(query, bindVars),
+		TabletType:       topo.TabletTypeToProto3(tabletType),
+		NotInTransaction: notInTransaction,
+	}
+	response, err := conn.c.Execute(ctx, request)
+	if err != nil {
+		return nil, session, err
+	}
+	if response.Error != nil {
+		return nil, returnedSession(response.Session), errorFromRPCError(response.Error)
+	}
+	return proto3ToQueryResult(response.Result), returnedSession(response.Session), nil
+}
+
+func (conn *vtgateConn) ExecuteShard(ctx context.Context, query string, keyspace string, shards []string, bindVars map[string]interface{}, tabletType topo.TabletType, notInTransaction bool, session interface{}) (*mproto.QueryResult, interface{}, error) {
+	request := &pb.ExecuteShardsRequest{
+		Session:          sessionToProto3(session),
+		Query:            tproto.BoundQueryToProto3(query, bindVars),
+		Keyspace:         keyspace,
+		Shards:           shards,
+		TabletType:       topo.TabletTypeToProto3(tabletType),
+		NotInTransaction: notInTransaction,
+	}
+	response, err := conn.c.ExecuteShards(ctx, request)
+	if err != nil {
+		return nil, session, err
+	}
+	if response.Error != nil {
+		return nil, returnedSession(response.Session), errorFromRPCError(response.Error)
+	}
+	return proto3ToQueryResult(response.Result), returnedSession(response.Session), nil
+}
+
+func (conn *vtgateConn) ExecuteKeyspaceIds(ctx context.Context, query string, keyspace string, keyspaceIds []key.KeyspaceId, bindVars map[string]interface{}, tabletType topo.TabletType, notInTransaction bool, session interface{}) (*mproto.QueryResult, interface{}, error) {
+	request := &pb.ExecuteKeyspaceIdsRequest{
+		Session:          sessionToProto3(session),
+		Query:            tproto.BoundQueryToProto3(query, bindVars),
+		Keyspace:         keyspace,
+		KeyspaceIds:      proto.KeyspaceIdsToProto3(keyspaceIds),
+		TabletType:       topo.TabletTypeToProto3(tabletType),
+		NotInTransaction: notInTransaction,
+	}
+	response, err := conn.c.ExecuteKeyspaceIds(ctx, request)
+	if err != nil {
+		return nil, session, err
+	}
+	if response.Error != nil {
+		return nil, returnedSession(response.Session), errorFromRPCError(response.Error)
+	}
+	return proto3ToQueryResult(response.Result), returnedSession(response.Session), nil
+}
+
+func (conn *vtgateConn) ExecuteKeyRanges(ctx context.Context, query string, keyspace string, keyRanges []key.KeyRange, bindVars map[string]interface{}, tabletType topo.TabletType, notInTransaction bool, session interface{}) (*mproto.QueryResult, interface{}, error) {
+	request := &pb.ExecuteKeyRangesRequest{
+		Session:          sessionToProto3(session),
+		Query:            tproto.BoundQueryToProto3(query, bindVars),
+		Keyspace:         keyspace,
+		KeyRanges:        proto.KeyRangesToProto3(keyRanges),
+		TabletType:       topo.TabletTypeToProto3(tabletType),
+		NotInTransaction: notInTransaction,
+	}
+	response, err := conn.c.ExecuteKeyRanges(ctx, request)
+	if err != nil {
+		return nil, session, err
+	}
+	if response.Error != nil {
+		return nil, returnedSession(response.Session), errorFromRPCError(response.Error)
+	}
+	return proto3ToQueryResult(response.Result), returnedSession(response.Session), nil
+}
+
+func (conn *vtgateConn) ExecuteEntityIds(ctx context.Context, query string, keyspace string, entityColumnName string, entityKeyspaceIDs []proto.EntityId, bindVars map[string]interface{}, tabletType topo.TabletType, notInTransaction bool, session interface{}) (*mproto.QueryResult, interface{}, error) {
+	entityIds, err := proto.EntityIdsToProto3(entityKeyspaceIDs)
+	if err != nil {
+		return nil, session, err
+	}
+	request := &pb.ExecuteEntityIdsRequest{
+		Session:           sessionToProto3(session),
+		Query:             tproto.BoundQueryToProto3(query, bindVars),
+		Keyspace:          keyspace,
+		EntityColumnName:  entityColumnName,
+		EntityKeyspaceIds: entityIds,
+		TabletType:        topo.TabletTypeToProto3(tabletType),
+		NotInTransaction:  notInTransaction,
+	}
+	response, err := conn.c.ExecuteEntityIds(ctx, request)
+	if err != nil {
+		return nil, session, err
+	}
+	if response.Error != nil {
+		return nil, returnedSession(response.Session), errorFromRPCError(response.Error)
+	}
+	return proto3ToQueryResult(response.Result), returnedSession(response.Session), nil
+}
+
+func (conn *vtgateConn) ExecuteBatchShard(ctx context.Context, queries []tproto.BoundQuery, keyspace string, shards []string, tabletType topo.TabletType, notInTransaction bool, session interface{}) ([]mproto.QueryResult, interface{}, error) {
+	request := &pb.ExecuteBatchShardsRequest{
+		Session:          sessionToProto3(session),
+		Queries:          proto.BoundQueryListToProto3(queries),
+		Keyspace:         keyspace,
+		Shards:           shards,
+		TabletType:       topo.TabletTypeToProto3(tabletType),
+		NotInTransaction: notInTransaction,
+	}
+	response, err := conn.c.ExecuteBatchShards(ctx, request)
+	if err != nil {
+		return nil, session, err
+	}
+	if response.Error != nil {
+		return nil, returnedSession(response.Session), errorFromRPCError(response.Error)
+	}
+	return tproto.Proto3ToQueryResultList(response.Results).List, returnedSession(response.Session), nil
+}
+
+func (conn *vtgateConn) ExecuteBatchKeyspaceIds(ctx context.Context, queries []tproto.BoundQuery, keyspace string, keyspaceIds []key.KeyspaceId, tabletType topo.TabletType, notInTransaction bool, session interface{}) ([]mproto.QueryResult, interface{}, error) {
+	request := &pb.ExecuteBatchKeyspaceIdsRequest{
+		Session:          sessionToProto3(session),
+		Queries:          proto.BoundQueryListToProto3(queries),
+		Keyspace:         keyspace,
+		KeyspaceIds:      proto.KeyspaceIdsToProto3(keyspaceIds),
+		TabletType:       topo.TabletTypeToProto3(tabletType),
+		NotInTransaction: notInTransaction,
+	}
+	response, err := conn.c.ExecuteBatchKeyspaceIds(ctx, request)
+	if err != nil {
+		return nil, session, err
+	}
+	if response.Error != nil {
+		return nil, returnedSession(response.Session), errorFromRPCError(response.Error)
+	}
+	return tproto.Proto3ToQueryResultList(response.Results).List, returnedSession(response.Session), nil
+}
+
+// sendStreamResults reads the responses of a streaming RPC with recv,
+// and sends their results on the returned channel.
+func sendStreamResults(recv func() (*pbq.QueryResult, *pbv.RPCError, error)) (<-chan *mproto.QueryResult, vtgateconn.ErrFunc) {
+	sr := make(chan *mproto.QueryResult, 10)
+	var finalError error
+	go func() {
+		defer close(sr)
+		for {
+			result, rpcErr, err := recv()
+			if err != nil {
+				if err != io.EOF {
+					finalError = err
+				}
+				return
+			}
+			if rpcErr != nil {
+				finalError = errorFromRPCError(rpcErr)
+				return
+			}
+			if result != nil {
+				sr <- tproto.Proto3ToQueryResult(result)
+			}
+		}
+	}()
+	return sr, func() error { return finalError }
+}
+
+func (conn *vtgateConn) StreamExecute(ctx context.Context, query string, bindVars map[string]interface{}, tabletType topo.TabletType) (<-chan *mproto.QueryResult, vtgateconn.ErrFunc) {
+	request := &pb.StreamExecuteRequest{
+		Query:      tproto.BoundQueryToProto3(query, bindVars),
+		TabletType: topo.TabletTypeToProto3(tabletType),
+	}
+	stream, err := conn.c.StreamExecute(ctx, request)
+	if err != nil {
+		return nil, func() error { return err }
+	}
+	return sendStreamResults(func() (*pbq.QueryResult, *pbv.RPCError, error) {
+		response, err := stream.Recv()
+		if err != nil {
+			return nil, nil, err
+		}
+		return response.Result, response.Error, nil
+	})
+}
+
+func (conn *vtgateConn) StreamExecuteShard(ctx context.Context, query string, keyspace string, shards []string, bindVars map[string]interface{}, tabletType topo.TabletType) (<-chan *mproto.QueryResult, vtgateconn.ErrFunc) {
+	request := &pb.StreamExecuteShardsRequest{
+		Query:      tproto.BoundQueryToProto3(query, bindVars),
+		Keyspace:   keyspace,
+		Shards:     shards,
+		TabletType: topo.TabletTypeToProto3(tabletType),
+	}
+	stream, err := conn.c.StreamExecuteShards(ctx, request)
+	if err != nil {
+		return nil, func() error { return err }
+	}
+	return sendStreamResults(func() (*pbq.QueryResult, *pbv.RPCError, error) {
+		response, err := stream.Recv()
+		if err != nil {
+			return nil, nil, err
+		}
+		return response.Result, response.Error, nil
+	})
+}
+
+func (conn *vtgateConn) StreamExecuteKeyRanges(ctx context.Context, query string, keyspace string, keyRanges []key.KeyRange, bindVars map[string]interface{}, tabletType topo.TabletType) (<-chan *mproto.QueryResult, vtgateconn.ErrFunc) {
+	request := &pb.StreamExecuteKeyRangesRequest{
+		Query:      tproto.BoundQueryToProto3(query, bindVars),
+		Keyspace:   keyspace,
+		KeyRanges:  proto.KeyRangesToProto3(keyRanges),
+		TabletType: topo.TabletTypeToProto3(tabletType),
+	}
+	stream, err := conn.c.StreamExecuteKeyRanges(ctx, request)
+	if err != nil {
+		return nil, func() error { return err }
+	}
+	return sendStreamResults(func() (*pbq.QueryResult, *pbv.RPCError, error) {
+		response, err := stream.Recv()
+		if err != nil {
+			return nil, nil, err
+		}
+		return response.Result, response.Error, nil
+	})
+}
+
+func (conn *vtgateConn) StreamExecuteKeyspaceIds(ctx context.Context, query string, keyspace string, keyspaceIds []key.KeyspaceId, bindVars map[string]interface{}, tabletType topo.TabletType) (<-chan *mproto.QueryResult, vtgateconn.ErrFunc) {
+	request := &pb.StreamExecuteKeyspaceIdsRequest{
+		Query:       tproto.BoundQueryToProto3(query, bindVars),
+		Keyspace:    keyspace,
+		KeyspaceIds: proto.KeyspaceIdsToProto3(keyspaceIds),
+		TabletType:  topo.TabletTypeToProto3(tabletType),
+	}
+	stream, err := conn.c.StreamExecuteKeyspaceIds(ctx, request)
+	if err != nil {
+		return nil, func() error { return err }
+	}
+	return sendStreamResults(func() (*pbq.QueryResult, *pbv.RPCError, error) {
+		response, err := stream.Recv()
+		if err != nil {
+			return nil, nil, err
+		}
+		return response.Result, response.Error, nil
+	})
+}
+
+func (conn *vtgateConn) Begin(ctx context.Context, session interface{}, transactionMode string) (interface{}, error) {
+	inSession := &pb.Session{}
+	if session != nil {
+		*inSession = *sessionToProto3(session)
+	}
+	inSession.TransactionMode = transactionMode
+	response, err := conn.c.Begin(ctx, &pb.BeginRequest{
+		Session: inSession,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, errorFromRPCError(response.Error)
+	}
+	return returnedSession(response.Session), nil
+}
+
+func (conn *vtgateConn) Commit(ctx context.Context, session interface{}) (interface{}, error) {
+	response, err := conn.c.Commit(ctx, &pb.CommitRequest{
+		Session: sessionToProto3(session),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Error != nil {
+		return nil, errorFromRPCError(response.Error)
+	}
+	return returnedSession(response.Session), nil
+}
+
+func (conn *vtgateConn) Rollback(ctx context.Context, session interface{}) error {
+	response, err := conn.c.Rollback(ctx, &pb.RollbackRequest{
+		Session: sessionToProto3(session),
+	})
+	if err != nil {
+		return err
+	}
+	return errorFromRPCError(response.Error)
+}
+
+func (conn *vtgateConn) ReadAfterWriteSession() interface{} {
+	return &pb.Session{ReadAfterWrite: true}
+}
+
+func (conn *vtgateConn) SplitQuery(ctx context.Context, keyspace string, query tproto.BoundQuery, splitCount int) ([]proto.SplitQueryPart, error) {
+	response, err := conn.c.SplitQuery(ctx, &pb.SplitQueryRequest{
+		Keyspace:   keyspace,
+		Query:      tproto.BoundQueryToProto3(query.Sql, query.BindVariables),
+		SplitCount: int64(splitCount),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return proto.Proto3ToSplitQueryParts(response.Splits), nil
+}
+
+func (conn *vtgateConn) Close() {
+	conn.cc.Close()
+}
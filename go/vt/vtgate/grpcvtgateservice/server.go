@@ -0,0 +1,375 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package grpcvtgateservice provides the gRPC glue for vtgate
+package grpcvtgateservice
+
+import (
+	"github.com/youtube/vitess/go/vt/callinfo"
+	"github.com/youtube/vitess/go/vt/servenv"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/vtgateservice"
+	"golang.org/x/net/context"
+
+	pbq "github.com/youtube/vitess/go/vt/proto/query"
+	pb "github.com/youtube/vitess/go/vt/proto/vtgate"
+	pbs "github.com/youtube/vitess/go/vt/proto/vtgateservice"
+	pbv "github.com/youtube/vitess/go/vt/proto/vtrpc"
+)
+
+// VTGate is the public structure that is exported via gRPC.
+// It implements the vtgateservice.VitessServer interface.
+type VTGate struct {
+	server vtgateservice.VTGateService
+}
+
+// rpcErrorFromString returns the proto3 version of the
+// application error of a reply, or nil if there is none.
+func rpcErrorFromString(err string) *pbv.RPCError {
+	if err == "" {
+		return nil
+	}
+	return &pbv.RPCError{
+		Message: err,
+	}
+}
+
+// Execute is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) Execute(ctx context.Context, request *pb.ExecuteRequest) (response *pb.ExecuteResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	reply := new(proto.QueryResult)
+	if err := vtg.server.Execute(ctx, &proto.Query{
+		Sql:              string(request.Query.Sql),
+		BindVariables:    proto.Proto3ToBindVariables(request.Query.BindVariables),
+		TabletType:       topo.Proto3ToTabletType(request.TabletType),
+		Session:          proto.Proto3ToSession(request.Session),
+		NotInTransaction: request.NotInTransaction,
+	}, reply); err != nil {
+		return nil, err
+	}
+	return &pb.ExecuteResponse{
+		Error:   rpcErrorFromString(reply.Error),
+		Session: proto.SessionToProto3(reply.Session),
+		Result:  queryResultToProto3(reply),
+	}, nil
+}
+
+// ExecuteShards is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) ExecuteShards(ctx context.Context, request *pb.ExecuteShardsRequest) (response *pb.ExecuteShardsResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	reply := new(proto.QueryResult)
+	if err := vtg.server.ExecuteShard(ctx, &proto.QueryShard{
+		Sql:              string(request.Query.Sql),
+		BindVariables:    proto.Proto3ToBindVariables(request.Query.BindVariables),
+		Keyspace:         request.Keyspace,
+		Shards:           proto.Proto3ToShards(request.Shards),
+		TabletType:       topo.Proto3ToTabletType(request.TabletType),
+		Session:          proto.Proto3ToSession(request.Session),
+		NotInTransaction: request.NotInTransaction,
+	}, reply); err != nil {
+		return nil, err
+	}
+	return &pb.ExecuteShardsResponse{
+		Error:   rpcErrorFromString(reply.Error),
+		Session: proto.SessionToProto3(reply.Session),
+		Result:  queryResultToProto3(reply),
+	}, nil
+}
+
+// ExecuteKeyspaceIds is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) ExecuteKeyspaceIds(ctx context.Context, request *pb.ExecuteKeyspaceIdsRequest) (response *pb.ExecuteKeyspaceIdsResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	reply := new(proto.QueryResult)
+	if err := vtg.server.ExecuteKeyspaceIds(ctx, &proto.KeyspaceIdQuery{
+		Sql:              string(request.Query.Sql),
+		BindVariables:    proto.Proto3ToBindVariables(request.Query.BindVariables),
+		Keyspace:         request.Keyspace,
+		KeyspaceIds:      proto.Proto3ToKeyspaceIds(request.KeyspaceIds),
+		TabletType:       topo.Proto3ToTabletType(request.TabletType),
+		Session:          proto.Proto3ToSession(request.Session),
+		NotInTransaction: request.NotInTransaction,
+	}, reply); err != nil {
+		return nil, err
+	}
+	return &pb.ExecuteKeyspaceIdsResponse{
+		Error:   rpcErrorFromString(reply.Error),
+		Session: proto.SessionToProto3(reply.Session),
+		Result:  queryResultToProto3(reply),
+	}, nil
+}
+
+// ExecuteKeyRanges is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) ExecuteKeyRanges(ctx context.Context, request *pb.ExecuteKeyRangesRequest) (response *pb.ExecuteKeyRangesResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	reply := new(proto.QueryResult)
+	if err := vtg.server.ExecuteKeyRanges(ctx, &proto.KeyRangeQuery{
+		Sql:              string(request.Query.Sql),
+		BindVariables:    proto.Proto3ToBindVariables(request.Query.BindVariables),
+		Keyspace:         request.Keyspace,
+		KeyRanges:        proto.Proto3ToKeyRanges(request.KeyRanges),
+		TabletType:       topo.Proto3ToTabletType(request.TabletType),
+		Session:          proto.Proto3ToSession(request.Session),
+		NotInTransaction: request.NotInTransaction,
+	}, reply); err != nil {
+		return nil, err
+	}
+	return &pb.ExecuteKeyRangesResponse{
+		Error:   rpcErrorFromString(reply.Error),
+		Session: proto.SessionToProto3(reply.Session),
+		Result:  queryResultToProto3(reply),
+	}, nil
+}
+
+// ExecuteEntityIds is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) ExecuteEntityIds(ctx context.Context, request *pb.ExecuteEntityIdsRequest) (response *pb.ExecuteEntityIdsResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	reply := new(proto.QueryResult)
+	if err := vtg.server.ExecuteEntityIds(ctx, &proto.EntityIdsQuery{
+		Sql:               string(request.Query.Sql),
+		BindVariables:     proto.Proto3ToBindVariables(request.Query.BindVariables),
+		Keyspace:          request.Keyspace,
+		EntityColumnName:  request.EntityColumnName,
+		EntityKeyspaceIDs: proto.Proto3ToEntityIds(request.EntityKeyspaceIds),
+		TabletType:        topo.Proto3ToTabletType(request.TabletType),
+		Session:           proto.Proto3ToSession(request.Session),
+		NotInTransaction:  request.NotInTransaction,
+	}, reply); err != nil {
+		return nil, err
+	}
+	return &pb.ExecuteEntityIdsResponse{
+		Error:   rpcErrorFromString(reply.Error),
+		Session: proto.SessionToProto3(reply.Session),
+		Result:  queryResultToProto3(reply),
+	}, nil
+}
+
+// ExecuteBatchShards is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) ExecuteBatchShards(ctx context.Context, request *pb.ExecuteBatchShardsRequest) (response *pb.ExecuteBatchShardsResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	reply := new(proto.QueryResultList)
+	if err := vtg.server.ExecuteBatchShard(ctx, &proto.BatchQueryShard{
+		Queries:          proto.Proto3ToBoundQueryList(request.Queries),
+		Keyspace:         request.Keyspace,
+		Shards:           proto.Proto3ToShards(request.Shards),
+		TabletType:       topo.Proto3ToTabletType(request.TabletType),
+		Session:          proto.Proto3ToSession(request.Session),
+		NotInTransaction: request.NotInTransaction,
+	}, reply); err != nil {
+		return nil, err
+	}
+	return &pb.ExecuteBatchShardsResponse{
+		Error:   rpcErrorFromString(reply.Error),
+		Session: proto.SessionToProto3(reply.Session),
+		Results: tproto.QueryResultListToProto3(reply.List),
+	}, nil
+}
+
+// ExecuteBatchKeyspaceIds is the RPC version of
+// vtgateservice.VTGateService method
+func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, request *pb.ExecuteBatchKeyspaceIdsRequest) (response *pb.ExecuteBatchKeyspaceIdsResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	reply := new(proto.QueryResultList)
+	if err := vtg.server.ExecuteBatchKeyspaceIds(ctx, &proto.KeyspaceIdBatchQuery{
+		Queries:          proto.Proto3ToBoundQueryList(request.Queries),
+		Keyspace:         request.Keyspace,
+		KeyspaceIds:      proto.Proto3ToKeyspaceIds(request.KeyspaceIds),
+		TabletType:       topo.Proto3ToTabletType(request.TabletType),
+		Session:          proto.Proto3ToSession(request.Session),
+		NotInTransaction: request.NotInTransaction,
+	}, reply); err != nil {
+		return nil, err
+	}
+	return &pb.ExecuteBatchKeyspaceIdsResponse{
+		Error:   rpcErrorFromString(reply.Error),
+		Session: proto.SessionToProto3(reply.Session),
+		Results: tproto.QueryResultListToProto3(reply.List),
+	}, nil
+}
+
+// StreamExecute is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) StreamExecute(request *pb.StreamExecuteRequest, stream pbs.Vitess_StreamExecuteServer) (err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx := callinfo.GRPCCallInfo(stream.Context())
+	return vtg.server.StreamExecute(ctx, &proto.Query{
+		Sql:           string(request.Query.Sql),
+		BindVariables: proto.Proto3ToBindVariables(request.Query.BindVariables),
+		TabletType:    topo.Proto3ToTabletType(request.TabletType),
+	}, func(value *proto.QueryResult) error {
+		if value.Result == nil {
+			return nil
+		}
+		return stream.Send(&pb.StreamExecuteResponse{
+			Result: tproto.QueryResultToProto3(value.Result),
+		})
+	})
+}
+
+// StreamExecuteShards is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) StreamExecuteShards(request *pb.StreamExecuteShardsRequest, stream pbs.Vitess_StreamExecuteShardsServer) (err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx := callinfo.GRPCCallInfo(stream.Context())
+	return vtg.server.StreamExecuteShard(ctx, &proto.QueryShard{
+		Sql:           string(request.Query.Sql),
+		BindVariables: proto.Proto3ToBindVariables(request.Query.BindVariables),
+		Keyspace:      request.Keyspace,
+		Shards:        proto.Proto3ToShards(request.Shards),
+		TabletType:    topo.Proto3ToTabletType(request.TabletType),
+	}, func(value *proto.QueryResult) error {
+		if value.Result == nil {
+			return nil
+		}
+		return stream.Send(&pb.StreamExecuteShardsResponse{
+			Result: tproto.QueryResultToProto3(value.Result),
+		})
+	})
+}
+
+// StreamExecuteKeyspaceIds is the RPC version of
+// vtgateservice.VTGateService method
+func (vtg *VTGate) StreamExecuteKeyspaceIds(request *pb.StreamExecuteKeyspaceIdsRequest, stream pbs.Vitess_StreamExecuteKeyspaceIdsServer) (err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx := callinfo.GRPCCallInfo(stream.Context())
+	return vtg.server.StreamExecuteKeyspaceIds(ctx, &proto.KeyspaceIdQuery{
+		Sql:           string(request.Query.Sql),
+		BindVariables: proto.Proto3ToBindVariables(request.Query.BindVariables),
+		Keyspace:      request.Keyspace,
+		KeyspaceIds:   proto.Proto3ToKeyspaceIds(request.KeyspaceIds),
+		TabletType:    topo.Proto3ToTabletType(request.TabletType),
+	}, func(value *proto.QueryResult) error {
+		if value.Result == nil {
+			return nil
+		}
+		return stream.Send(&pb.StreamExecuteKeyspaceIdsResponse{
+			Result: tproto.QueryResultToProto3(value.Result),
+		})
+	})
+}
+
+// StreamExecuteKeyRanges is the RPC version of
+// vtgateservice.VTGateService method
+func (vtg *VTGate) StreamExecuteKeyRanges(request *pb.StreamExecuteKeyRangesRequest, stream pbs.Vitess_StreamExecuteKeyRangesServer) (err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx := callinfo.GRPCCallInfo(stream.Context())
+	return vtg.server.StreamExecuteKeyRanges(ctx, &proto.KeyRangeQuery{
+		Sql:           string(request.Query.Sql),
+		BindVariables: proto.Proto3ToBindVariables(request.Query.BindVariables),
+		Keyspace:      request.Keyspace,
+		KeyRanges:     proto.Proto3ToKeyRanges(request.KeyRanges),
+		TabletType:    topo.Proto3ToTabletType(request.TabletType),
+	}, func(value *proto.QueryResult) error {
+		if value.Result == nil {
+			return nil
+		}
+		return stream.Send(&pb.StreamExecuteKeyRangesResponse{
+			Result: tproto.QueryResultToProto3(value.Result),
+		})
+	})
+}
+
+// Begin is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) Begin(ctx context.Context, request *pb.BeginRequest) (response *pb.BeginResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	outSession := new(proto.Session)
+	if request.Session != nil {
+		// carry over the transaction mode and the
+		// read-after-write state of the caller
+		inSession := proto.Proto3ToSession(request.Session)
+		outSession.TransactionMode = inSession.TransactionMode
+		outSession.ReadAfterWrite = inSession.ReadAfterWrite
+		outSession.WritePositions = inSession.WritePositions
+	}
+	if err := vtg.server.Begin(ctx, outSession); err != nil {
+		return nil, err
+	}
+	return &pb.BeginResponse{
+		Session: proto.SessionToProto3(outSession),
+	}, nil
+}
+
+// Commit is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) Commit(ctx context.Context, request *pb.CommitRequest) (response *pb.CommitResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	inSession := proto.Proto3ToSession(request.Session)
+	if err := vtg.server.Commit(ctx, inSession); err != nil {
+		return nil, err
+	}
+	return &pb.CommitResponse{
+		Session: proto.SessionToProto3(inSession),
+	}, nil
+}
+
+// Rollback is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) Rollback(ctx context.Context, request *pb.RollbackRequest) (response *pb.RollbackResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	if err := vtg.server.Rollback(ctx, proto.Proto3ToSession(request.Session)); err != nil {
+		return nil, err
+	}
+	return &pb.RollbackResponse{}, nil
+}
+
+// SplitQuery is the RPC version of vtgateservice.VTGateService method
+func (vtg *VTGate) SplitQuery(ctx context.Context, request *pb.SplitQueryRequest) (response *pb.SplitQueryResponse, err error) {
+	defer vtg.server.HandlePanic(&err)
+	ctx = callinfo.GRPCCallInfo(ctx)
+
+	reply := new(proto.SplitQueryResult)
+	if err := vtg.server.SplitQuery(ctx, &proto.SplitQueryRequest{
+		Keyspace: request.Keyspace,
+		Query: tproto.BoundQuery{
+			Sql:           string(request.Query.Sql),
+			BindVariables: proto.Proto3ToBindVariables(request.Query.BindVariables),
+		},
+		SplitCount: int(request.SplitCount),
+	}, reply); err != nil {
+		return nil, err
+	}
+	return &pb.SplitQueryResponse{
+		Splits: proto.SplitQueryPartsToProto3(reply.Splits),
+	}, nil
+}
+
+// queryResultToProto3 returns the proto3 version of the result
+// of a reply, or nil if there is none.
+func queryResultToProto3(reply *proto.QueryResult) *pbq.QueryResult {
+	if reply.Result == nil {
+		return nil
+	}
+	return tproto.QueryResultToProto3(reply.Result)
+}
+
+// New returns a new VTGate service
+func New(vtGate vtgateservice.VTGateService) *VTGate {
+	return &VTGate{vtGate}
+}
+
+func init() {
+	vtgate.RegisterVTGates = append(vtgate.RegisterVTGates, func(vtGate vtgateservice.VTGateService) {
+		if servenv.GRPCCheckServiceMap("vtgateservice") {
+			pbs.RegisterVitessServer(servenv.GRPCServer, New(vtGate))
+		}
+	})
+}
@@ -0,0 +1,212 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"fmt"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+)
+
+// execSelectJoin executes a SelectJoin plan as a nested loop.
+// The Right plan is executed once for every row returned by
+// the Left plan.
+func (rtr *Router) execSelectJoin(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	join := plan.Join
+	lresult, err := rtr.execJoinSide(vcursor, join.Left, vcursor.query.BindVariables)
+	if err != nil {
+		return nil, err
+	}
+	rows, rfields, err := rtr.joinRows(vcursor, join, lresult.Fields, lresult.Rows)
+	if err != nil {
+		return nil, err
+	}
+	if rfields == nil {
+		if rfields, err = rtr.getJoinFields(vcursor, join); err != nil {
+			return nil, err
+		}
+	}
+	return &mproto.QueryResult{
+		Fields:       joinFields(join, lresult.Fields, rfields),
+		RowsAffected: uint64(len(rows)),
+		Rows:         rows,
+	}, nil
+}
+
+// streamSelectJoin streams the results of the Left plan of a join,
+// and executes the Right plan for every row received. The fields
+// are sent with the first joined rows.
+func (rtr *Router) streamSelectJoin(vcursor *requestContext, plan *planbuilder.Plan, sendReply func(*mproto.QueryResult) error) error {
+	join := plan.Join
+	var lfields, rfields []mproto.Field
+	fieldsSent := false
+	err := rtr.streamJoinSide(vcursor, join.Left, vcursor.query.BindVariables, func(lresult *mproto.QueryResult) error {
+		if lresult.Fields != nil {
+			lfields = lresult.Fields
+		}
+		rows, newfields, err := rtr.joinRows(vcursor, join, lfields, lresult.Rows)
+		if err != nil {
+			return err
+		}
+		if rfields == nil {
+			rfields = newfields
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		reply := &mproto.QueryResult{RowsAffected: uint64(len(rows)), Rows: rows}
+		if !fieldsSent {
+			if rfields == nil {
+				if rfields, err = rtr.getJoinFields(vcursor, join); err != nil {
+					return err
+				}
+			}
+			reply.Fields = joinFields(join, lfields, rfields)
+			fieldsSent = true
+		}
+		return sendReply(reply)
+	})
+	if err != nil || fieldsSent {
+		return err
+	}
+	if rfields == nil {
+		if rfields, err = rtr.getJoinFields(vcursor, join); err != nil {
+			return err
+		}
+	}
+	return sendReply(&mproto.QueryResult{Fields: joinFields(join, lfields, rfields)})
+}
+
+// joinRows executes the Right plan of a join for every row of the
+// Left result, and returns the joined rows. It also returns the
+// fields of the Right result, which are nil if Right was not executed.
+func (rtr *Router) joinRows(vcursor *requestContext, join *planbuilder.JoinParams, lfields []mproto.Field, lrows [][]sqltypes.Value) (rows [][]sqltypes.Value, rfields []mproto.Field, err error) {
+	for _, lrow := range lrows {
+		bv := make(map[string]interface{}, len(vcursor.query.BindVariables)+len(join.Vars))
+		for k, v := range vcursor.query.BindVariables {
+			bv[k] = v
+		}
+		for name, col := range join.Vars {
+			bv[name], err = mproto.Convert(lfields[col], lrow[col])
+			if err != nil {
+				return nil, nil, fmt.Errorf("joinRows: %v", err)
+			}
+		}
+		rresult := &mproto.QueryResult{}
+		// A NULL value cannot satisfy the equality that routes Right.
+		if !isNullRouting(join.Right, bv) {
+			rresult, err = rtr.execJoinSide(vcursor, join.Right, bv)
+			if err != nil {
+				return nil, nil, err
+			}
+			if rfields == nil {
+				rfields = rresult.Fields
+			}
+		}
+		for _, rrow := range rresult.Rows {
+			rows = append(rows, joinRow(join, lrow, rrow))
+		}
+		if join.IsLeft && len(rresult.Rows) == 0 {
+			rows = append(rows, joinRow(join, lrow, nil))
+		}
+	}
+	return rows, rfields, nil
+}
+
+// isNullRouting returns true if the plan is routed by the
+// value of a bind var that is NULL.
+func isNullRouting(plan *planbuilder.Plan, bindVars map[string]interface{}) bool {
+	if plan.ID != planbuilder.SelectEqual {
+		return false
+	}
+	name, ok := plan.Values.(string)
+	if !ok {
+		return false
+	}
+	val, ok := bindVars[name[1:]]
+	return ok && val == nil
+}
+
+// getJoinFields fetches the fields of the Right plan of a join
+// by sending its FieldQuery to the first shard of its keyspace.
+func (rtr *Router) getJoinFields(vcursor *requestContext, join *planbuilder.JoinParams) ([]mproto.Field, error) {
+	ks, _, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, join.Right.Table.Keyspace.Name, vcursor.query.TabletType)
+	if err != nil {
+		return nil, fmt.Errorf("getJoinFields: %v", err)
+	}
+	qr, err := rtr.scatterConn.Execute(
+		vcursor.ctx,
+		join.FieldQuery,
+		vcursor.query.BindVariables,
+		ks,
+		[]string{allShards[0].Name},
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction)
+	if err != nil {
+		return nil, fmt.Errorf("getJoinFields: %v", err)
+	}
+	return qr.Fields, nil
+}
+
+// execJoinSide executes one of the plans of a join
+// with the specified bind vars.
+func (rtr *Router) execJoinSide(vcursor *requestContext, plan *planbuilder.Plan, bindVars map[string]interface{}) (*mproto.QueryResult, error) {
+	return rtr.execRoute(newJoinContext(vcursor, plan, bindVars), plan)
+}
+
+// streamJoinSide streams the results of one of the
+// plans of a join with the specified bind vars.
+func (rtr *Router) streamJoinSide(vcursor *requestContext, plan *planbuilder.Plan, bindVars map[string]interface{}, sendReply func(*mproto.QueryResult) error) error {
+	return rtr.streamRoute(newJoinContext(vcursor, plan, bindVars), plan, sendReply)
+}
+
+// newJoinContext creates the requestContext for executing
+// one of the plans of a join within the original request.
+func newJoinContext(vcursor *requestContext, plan *planbuilder.Plan, bindVars map[string]interface{}) *requestContext {
+	query := &proto.Query{
+		Sql:              plan.Original,
+		BindVariables:    bindVars,
+		TabletType:       vcursor.query.TabletType,
+		Session:          vcursor.query.Session,
+		NotInTransaction: vcursor.query.NotInTransaction,
+	}
+	return newRequestContext(vcursor.ctx, query, vcursor.router)
+}
+
+// joinFields returns the result fields of a join.
+func joinFields(join *planbuilder.JoinParams, lfields, rfields []mproto.Field) []mproto.Field {
+	if lfields == nil || rfields == nil {
+		return nil
+	}
+	fields := make([]mproto.Field, len(join.Cols))
+	for i, col := range join.Cols {
+		if col < 0 {
+			fields[i] = lfields[-col-1]
+		} else {
+			fields[i] = rfields[col-1]
+		}
+	}
+	return fields
+}
+
+// joinRow builds a result row of a join from a row of each side.
+// If rrow is nil, the columns of the right side are NULL.
+func joinRow(join *planbuilder.JoinParams, lrow, rrow []sqltypes.Value) []sqltypes.Value {
+	row := make([]sqltypes.Value, len(join.Cols))
+	for i, col := range join.Cols {
+		if col < 0 {
+			row[i] = lrow[-col-1]
+		} else if rrow != nil {
+			row[i] = rrow[col-1]
+		}
+	}
+	return row
+}
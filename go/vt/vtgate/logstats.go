@@ -0,0 +1,260 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/streamlog"
+	"github.com/youtube/vitess/go/vt/callinfo"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+// QueryLogger is the stream logger of the vtgate requests.
+var QueryLogger = streamlog.New("VTGate", 50)
+
+// LogStats records the stats of a single vtgate request.
+// It's attached to the context of the request, so the
+// layers below VTGate can fill it in.
+type LogStats struct {
+	Method        string
+	SQL           string
+	BindVariables map[string]interface{}
+	TabletType    topo.TabletType
+	StartTime     time.Time
+	EndTime       time.Time
+	RowsReturned  int64
+	Error         error
+	context       context.Context
+
+	// The fields below are filled in while the request runs,
+	// possibly by more than one goroutine. mu protects them.
+	mu sync.Mutex
+	// PlanType is the V3 plan of the query, if any.
+	PlanType string
+	// PlanTime is the time spent getting the V3 plan.
+	PlanTime time.Duration
+	// VindexTime is the time spent in the vindex lookups.
+	VindexTime time.Duration
+	// ExecuteTime is the time spent executing queries on the tablets.
+	ExecuteTime time.Duration
+	// ShardQueries is the number of queries sent to the tablets.
+	ShardQueries int
+	keyspaces    map[string]map[string]bool
+}
+
+type logStatsKey int
+
+// newLogStats returns the LogStats of a request, and the
+// context that carries it.
+func newLogStats(ctx context.Context, method, sql string, bindVars map[string]interface{}, tabletType topo.TabletType) (*LogStats, context.Context) {
+	logStats := &LogStats{
+		Method:        method,
+		SQL:           sql,
+		BindVariables: bindVars,
+		TabletType:    tabletType,
+		StartTime:     time.Now(),
+		context:       ctx,
+	}
+	return logStats, context.WithValue(ctx, logStatsKey(0), logStats)
+}
+
+// logStatsFromContext returns the LogStats of the request, or nil.
+// The recording methods of LogStats accept a nil receiver.
+func logStatsFromContext(ctx context.Context) *LogStats {
+	logStats, _ := ctx.Value(logStatsKey(0)).(*LogStats)
+	return logStats
+}
+
+// withoutLogStats returns a context without LogStats. It's used for
+// the queries the request sends on its own, like vindex lookups,
+// whose time is already counted by the caller.
+func withoutLogStats(ctx context.Context) context.Context {
+	if logStatsFromContext(ctx) == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, logStatsKey(0), (*LogStats)(nil))
+}
+
+// batchSQL returns the queries of a batch as a single string.
+func batchSQL(queries []tproto.BoundQuery) string {
+	sqls := make([]string, len(queries))
+	for i, query := range queries {
+		sqls[i] = query.Sql
+	}
+	return strings.Join(sqls, "; ")
+}
+
+// Send finalizes a record and sends it.
+func (stats *LogStats) Send(err error) {
+	stats.EndTime = time.Now()
+	stats.Error = err
+	QueryLogger.Send(stats)
+}
+
+// recordPlan records the plan of the query and the time it took to get it.
+func (stats *LogStats) recordPlan(planType string, startTime time.Time) {
+	if stats == nil {
+		return
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.PlanType = planType
+	stats.PlanTime += time.Now().Sub(startTime)
+}
+
+// recordVindex records the time spent in a vindex lookup.
+func (stats *LogStats) recordVindex(startTime time.Time) {
+	if stats == nil {
+		return
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.VindexTime += time.Now().Sub(startTime)
+}
+
+// recordExecute records the shards a query was sent to,
+// and the time it took.
+func (stats *LogStats) recordExecute(keyspace string, shards []string, startTime time.Time) {
+	if stats == nil {
+		return
+	}
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.ExecuteTime += time.Now().Sub(startTime)
+	if stats.keyspaces == nil {
+		stats.keyspaces = make(map[string]map[string]bool)
+	}
+	if stats.keyspaces[keyspace] == nil {
+		stats.keyspaces[keyspace] = make(map[string]bool)
+	}
+	for shard := range unique(shards) {
+		stats.ShardQueries++
+		stats.keyspaces[keyspace][shard] = true
+	}
+}
+
+// TotalTime returns how long this request has been running.
+func (stats *LogStats) TotalTime() time.Duration {
+	return stats.EndTime.Sub(stats.StartTime)
+}
+
+// Target returns the keyspaces and shards the request was sent to,
+// as in "user/-80,80- lookup/0".
+func (stats *LogStats) Target() string {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	keyspaces := make([]string, 0, len(stats.keyspaces))
+	for keyspace := range stats.keyspaces {
+		keyspaces = append(keyspaces, keyspace)
+	}
+	sort.Strings(keyspaces)
+	targets := make([]string, 0, len(keyspaces))
+	for _, keyspace := range keyspaces {
+		shards := make([]string, 0, len(stats.keyspaces[keyspace]))
+		for shard := range stats.keyspaces[keyspace] {
+			shards = append(shards, shard)
+		}
+		sort.Strings(shards)
+		targets = append(targets, keyspace+"/"+strings.Join(shards, ","))
+	}
+	return strings.Join(targets, " ")
+}
+
+// FmtBindVariables returns the map of bind variables as JSON. For
+// values that are strings or byte slices it only reports their type
+// and length.
+func (stats *LogStats) FmtBindVariables(full bool) string {
+	var out map[string]interface{}
+	if full {
+		out = stats.BindVariables
+	} else {
+		out = make(map[string]interface{})
+		for k, v := range stats.BindVariables {
+			switch val := v.(type) {
+			case string:
+				out[k] = fmt.Sprintf("string %v", len(val))
+			case []byte:
+				out[k] = fmt.Sprintf("bytes %v", len(val))
+			default:
+				out[k] = v
+			}
+		}
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		log.Warningf("could not marshal %q", stats.BindVariables)
+		return ""
+	}
+	return string(b)
+}
+
+// ContextHTML returns the HTML version of the context that was used, or "".
+func (stats *LogStats) ContextHTML() template.HTML {
+	return callinfo.HTMLFromContext(stats.context)
+}
+
+// ErrorStr returns the error string or "".
+func (stats *LogStats) ErrorStr() string {
+	if stats.Error != nil {
+		return stats.Error.Error()
+	}
+	return ""
+}
+
+// RemoteAddrUsername returns some parts of CallInfo if set.
+func (stats *LogStats) RemoteAddrUsername() (string, string) {
+	ci, ok := callinfo.FromContext(stats.context)
+	if !ok {
+		return "", ""
+	}
+	return ci.RemoteAddr(), ci.Username()
+}
+
+// Format returns a tab separated list of logged fields.
+func (stats *LogStats) Format(params url.Values) string {
+	_, fullBindParams := params["full"]
+
+	remoteAddr, username := stats.RemoteAddrUsername()
+	return fmt.Sprintf(
+		"%v\t%v\t%v\t%v\t%v\t%.6f\t%.6f\t%.6f\t%.6f\t%v\t%q\t%v\t%v\t%v\t%v\t%v\t%q\t\n",
+		stats.Method,
+		remoteAddr,
+		username,
+		stats.StartTime.Format(time.StampMicro),
+		stats.EndTime.Format(time.StampMicro),
+		stats.TotalTime().Seconds(),
+		stats.PlanTime.Seconds(),
+		stats.VindexTime.Seconds(),
+		stats.ExecuteTime.Seconds(),
+		stats.PlanType,
+		stats.SQL,
+		stats.FmtBindVariables(fullBindParams),
+		stats.TabletType,
+		stats.Target(),
+		stats.ShardQueries,
+		stats.RowsReturned,
+		stats.ErrorStr(),
+	)
+}
+
+// formatLogStats is the formatter of the QueryLogger records.
+func formatLogStats(params url.Values, val interface{}) string {
+	stats, ok := val.(*LogStats)
+	if !ok {
+		return fmt.Sprintf("Error: unexpected value of type %T in %s!", val, QueryLogger.Name())
+	}
+	return stats.Format(params)
+}
@@ -0,0 +1,123 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestLogStatsContext(t *testing.T) {
+	if got := logStatsFromContext(context.Background()); got != nil {
+		t.Errorf("logStatsFromContext: %v, want nil", got)
+	}
+	// The recording methods accept a nil receiver.
+	logStatsFromContext(context.Background()).recordPlan("SelectEqual", time.Now())
+
+	logStats, ctx := newLogStats(context.Background(), "Execute", "select 1", nil, topo.TYPE_MASTER)
+	if got := logStatsFromContext(ctx); got != logStats {
+		t.Errorf("logStatsFromContext: %v, want %v", got, logStats)
+	}
+	if got := logStatsFromContext(withoutLogStats(ctx)); got != nil {
+		t.Errorf("logStatsFromContext(withoutLogStats): %v, want nil", got)
+	}
+}
+
+func TestLogStatsTarget(t *testing.T) {
+	logStats, _ := newLogStats(context.Background(), "Execute", "select 1", nil, topo.TYPE_MASTER)
+	logStats.recordExecute("ks2", []string{"0"}, time.Now())
+	logStats.recordExecute("ks1", []string{"80-", "-80", "80-"}, time.Now())
+	logStats.recordExecute("ks1", []string{"-80"}, time.Now())
+	want := "ks1/-80,80- ks2/0"
+	if got := logStats.Target(); got != want {
+		t.Errorf("Target: %s, want %s", got, want)
+	}
+	if logStats.ShardQueries != 4 {
+		t.Errorf("ShardQueries: %d, want 4", logStats.ShardQueries)
+	}
+}
+
+func TestLogStatsFormatBindVariables(t *testing.T) {
+	logStats, _ := newLogStats(context.Background(), "Execute", "select 1", nil, topo.TYPE_MASTER)
+	logStats.BindVariables = map[string]interface{}{
+		"key_1": "val_1",
+		"key_2": 789,
+		"key_3": []byte("val_3"),
+	}
+
+	formattedStr := logStats.FmtBindVariables(true)
+	if !strings.Contains(formattedStr, "val_1") || !strings.Contains(formattedStr, "789") {
+		t.Errorf("FmtBindVariables(true): %s, want the values", formattedStr)
+	}
+	formattedStr = logStats.FmtBindVariables(false)
+	want := `{"key_1":"string 5","key_2":789,"key_3":"bytes 5"}`
+	if formattedStr != want {
+		t.Errorf("FmtBindVariables(false): %s, want %s", formattedStr, want)
+	}
+}
+
+func TestLogStatsFormat(t *testing.T) {
+	logStats, _ := newLogStats(context.Background(), "Execute", "select name from user", map[string]interface{}{"id": 1}, topo.TYPE_REPLICA)
+	logStats.PlanType = "SelectScatter"
+	logStats.recordExecute("user", []string{"-80", "80-"}, time.Now())
+	logStats.RowsReturned = 3
+	logStats.Error = errTooManyInFlight
+
+	got := logStats.Format(url.Values{})
+	for _, want := range []string{
+		"Execute\t",
+		"\tSelectScatter\t\"select name from user\"\t{\"id\":1}\treplica\tuser/-80,80-\t2\t3\t",
+		errTooManyInFlight.Error(),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Format: %q, want it to contain %q", got, want)
+		}
+	}
+}
+
+func TestLogStatsRouter(t *testing.T) {
+	router, _, _, _ := createRouterEnv()
+
+	logStats, ctx := newLogStats(context.Background(), "Execute", "select id from user where id = 1", nil, topo.TYPE_MASTER)
+	_, err := router.Execute(ctx, &proto.Query{
+		Sql:        "select id from user where id = 1",
+		TabletType: topo.TYPE_MASTER,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logStats.PlanType != "SelectEqual" {
+		t.Errorf("PlanType: %s, want SelectEqual", logStats.PlanType)
+	}
+	if got, want := logStats.Target(), "TestRouter/-20"; got != want {
+		t.Errorf("Target: %s, want %s", got, want)
+	}
+
+	// The vindex queries are not part of the target, and don't
+	// change the plan type.
+	logStats, ctx = newLogStats(context.Background(), "Execute", "insert into user(id, v, name) values (1, 2, 'myname')", nil, topo.TYPE_MASTER)
+	_, err = router.Execute(ctx, &proto.Query{
+		Sql:        "insert into user(id, v, name) values (1, 2, 'myname')",
+		TabletType: topo.TYPE_MASTER,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logStats.PlanType != "InsertSharded" {
+		t.Errorf("PlanType: %s, want InsertSharded", logStats.PlanType)
+	}
+	if got, want := logStats.Target(), "TestRouter/-20"; got != want {
+		t.Errorf("Target: %s, want %s", got, want)
+	}
+	if logStats.VindexTime == 0 {
+		t.Errorf("VindexTime: 0, want > 0")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+var (
+	masterBufferWindow = flag.Duration("master_buffer_window", 0, "if set, the master queries of a shard are held in a buffer for up to this duration while a master failover is in progress, and then sent to the new master. 0 disables the buffering.")
+	masterBufferSize   = flag.Int("master_buffer_size", 10, "maximum number of master queries held in the buffer of a shard during a failover. The queries beyond that fail right away.")
+
+	masterBufferFailovers = stats.NewCounters("MasterBufferFailovers")
+	masterBufferRequests  = stats.NewCounters("MasterBufferRequests")
+	masterBufferFull      = stats.NewCounters("MasterBufferFull")
+)
+
+// masterBufferPollInterval is how often the endpoints
+// are checked for a new master during a failover.
+var masterBufferPollInterval = 100 * time.Millisecond
+
+// masterBuffer holds the master queries of a shard while a failover
+// is in progress. A failover starts when the master returns a
+// retryable error, and ends when the serving graph has a new master,
+// or after the buffer window.
+type masterBuffer struct {
+	name         string
+	window       time.Duration
+	size         int
+	pollInterval time.Duration
+	getEndPoints func() ([]topo.EndPoint, error)
+
+	mu sync.Mutex
+	// failoverDone is set while a failover is in progress,
+	// and closed when it ends.
+	failoverDone chan struct{}
+	failedUid    uint32
+	buffered     int
+	// timedOut is set if the last failover didn't find a new
+	// master. No new failover is started for the same master,
+	// so that a shard that stays without master doesn't delay
+	// all its queries.
+	timedOut    bool
+	timedOutUid uint32
+}
+
+// newMasterBuffer creates a masterBuffer for the shard name.
+// getEndPoints returns the current master endpoints.
+func newMasterBuffer(name string, window time.Duration, size int, getEndPoints func() ([]topo.EndPoint, error)) *masterBuffer {
+	return &masterBuffer{
+		name:         name,
+		window:       window,
+		size:         size,
+		pollInterval: masterBufferPollInterval,
+		getEndPoints: getEndPoints,
+	}
+}
+
+// startFailover records that the master failedUid cannot serve
+// queries anymore. The queries are then buffered until the endpoints
+// have a different master.
+func (mb *masterBuffer) startFailover(failedUid uint32) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	if mb.failoverDone != nil {
+		return
+	}
+	if mb.timedOut && mb.timedOutUid == failedUid {
+		return
+	}
+	log.Infof("Master %v of %v is not serving, buffering its queries for up to %v", failedUid, mb.name, mb.window)
+	masterBufferFailovers.Add(mb.name, 1)
+	mb.failoverDone = make(chan struct{})
+	mb.failedUid = failedUid
+	go mb.waitForNewMaster(failedUid)
+}
+
+// waitForNewMaster polls the endpoints until
+// there is a new master, or the window expires.
+func (mb *masterBuffer) waitForNewMaster(failedUid uint32) {
+	deadline := time.Now().Add(mb.window)
+	for time.Now().Before(deadline) {
+		time.Sleep(mb.pollInterval)
+		endPoints, err := mb.getEndPoints()
+		if err != nil {
+			continue
+		}
+		for _, endPoint := range endPoints {
+			if endPoint.Uid != failedUid {
+				log.Infof("Master %v of %v replaced by %v, sending the buffered queries", failedUid, mb.name, endPoint.Uid)
+				mb.endFailover(false)
+				return
+			}
+		}
+	}
+	log.Warningf("No new master for %v after %v, sending the buffered queries", mb.name, mb.window)
+	mb.endFailover(true)
+}
+
+func (mb *masterBuffer) endFailover(timedOut bool) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	close(mb.failoverDone)
+	mb.failoverDone = nil
+	mb.timedOut = timedOut
+	mb.timedOutUid = mb.failedUid
+}
+
+// wait blocks while a failover is in progress, until it ends or ctx
+// is done, in which case it returns ctx.Err(). If the buffer is full,
+// it returns an error right away.
+func (mb *masterBuffer) wait(ctx context.Context) error {
+	mb.mu.Lock()
+	done := mb.failoverDone
+	if done == nil {
+		mb.mu.Unlock()
+		return nil
+	}
+	if mb.buffered >= mb.size {
+		mb.mu.Unlock()
+		masterBufferFull.Add(mb.name, 1)
+		return fmt.Errorf("master failover in progress for %v, and the buffer is full", mb.name)
+	}
+	mb.buffered++
+	mb.mu.Unlock()
+	masterBufferRequests.Add(mb.name, 1)
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	mb.mu.Lock()
+	mb.buffered--
+	mb.mu.Unlock()
+	return err
+}
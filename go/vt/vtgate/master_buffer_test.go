@@ -0,0 +1,90 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+func masterEndPoints(uid uint32) func() ([]topo.EndPoint, error) {
+	return func() ([]topo.EndPoint, error) {
+		return []topo.EndPoint{{Uid: uid}}, nil
+	}
+}
+
+func TestMasterBufferTimeout(t *testing.T) {
+	defer func(d time.Duration) { masterBufferPollInterval = d }(masterBufferPollInterval)
+	masterBufferPollInterval = time.Millisecond
+	mb := newMasterBuffer("TestMasterBufferTimeout.0", 50*time.Millisecond, 10, masterEndPoints(1))
+
+	// No failover, no wait.
+	if err := mb.wait(context.Background()); err != nil {
+		t.Errorf("wait: %v", err)
+	}
+
+	// The master never changes, so the queries wait for the window.
+	mb.startFailover(1)
+	start := time.Now()
+	if err := mb.wait(context.Background()); err != nil {
+		t.Errorf("wait: %v", err)
+	}
+	if elapsed := time.Now().Sub(start); elapsed < 40*time.Millisecond {
+		t.Errorf("wait returned after %v, want at least the window", elapsed)
+	}
+
+	// The same master failing again doesn't start a new failover.
+	mb.startFailover(1)
+	mb.mu.Lock()
+	inFailover := mb.failoverDone != nil
+	mb.mu.Unlock()
+	if inFailover {
+		t.Errorf("failover started again for a master that timed out")
+	}
+
+	// But another one does.
+	mb.startFailover(2)
+	mb.mu.Lock()
+	inFailover = mb.failoverDone != nil
+	mb.mu.Unlock()
+	if !inFailover {
+		t.Errorf("failover not started for a new master")
+	}
+}
+
+func TestMasterBufferFull(t *testing.T) {
+	mb := newMasterBuffer("TestMasterBufferFull.0", 10*time.Second, 1, masterEndPoints(1))
+	mb.startFailover(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- mb.wait(ctx)
+	}()
+	for {
+		mb.mu.Lock()
+		buffered := mb.buffered
+		mb.mu.Unlock()
+		if buffered == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	want := "master failover in progress for TestMasterBufferFull.0, and the buffer is full"
+	if err := mb.wait(context.Background()); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("wait: %v, want %v", err, want)
+	}
+
+	// A canceled query leaves the buffer.
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("wait: %v, want %v", err, context.Canceled)
+	}
+}
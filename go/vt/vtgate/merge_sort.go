@@ -0,0 +1,373 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+// This is a V3 file. Do not intermix with V2.
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"sort"
+	"strings"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// mergeBatchSize is the max number of rows sent per reply
+// while merge-sorting streaming results.
+const mergeBatchSize = 100
+
+// orderByColumn is a planbuilder.OrderByParams resolved
+// against the fields of a result.
+type orderByColumn struct {
+	field mproto.Field
+	col   int
+	desc  bool
+}
+
+// resolveOrderBy finds the result columns that correspond
+// to the ORDER BY columns of the plan.
+func resolveOrderBy(fields []mproto.Field, orderBy []planbuilder.OrderByParams) ([]orderByColumn, error) {
+	cols := make([]orderByColumn, 0, len(orderBy))
+	for _, order := range orderBy {
+		col := -1
+		for i, field := range fields {
+			if strings.EqualFold(field.Name, order.Col) {
+				col = i
+				break
+			}
+		}
+		if col == -1 {
+			return nil, fmt.Errorf("order by column %s not found in result", order.Col)
+		}
+		if err := checkComparable(fields[col]); err != nil {
+			return nil, err
+		}
+		cols = append(cols, orderByColumn{
+			field: fields[col],
+			col:   col,
+			desc:  order.Desc,
+		})
+	}
+	return cols, nil
+}
+
+// compareRows compares two rows based on the ORDER BY columns.
+// It returns -1, 0 or 1 if r1 sorts before, same as, or after r2.
+func compareRows(r1, r2 []sqltypes.Value, cols []orderByColumn) (int, error) {
+	for _, c := range cols {
+		cmp, err := compareValues(c.field, r1[c.col], r2[c.col])
+		if err != nil {
+			return 0, err
+		}
+		if cmp == 0 {
+			continue
+		}
+		if c.desc {
+			cmp = -cmp
+		}
+		return cmp, nil
+	}
+	return 0, nil
+}
+
+// checkComparable returns an error if the values of field
+// cannot be compared by compareValues the way MySQL would:
+// text of a non-binary collation, and enums or sets, which
+// MySQL sorts by their index.
+func checkComparable(field mproto.Field) error {
+	if field.Type == mproto.VT_ENUM || field.Type == mproto.VT_SET || field.Flags&(mproto.VT_ENUM_FLAG|mproto.VT_SET_FLAG) != 0 {
+		return fmt.Errorf("cannot compare column %s in vtgate: enum and set columns are not supported", field.Name)
+	}
+	switch field.Type {
+	case mproto.VT_VARCHAR, mproto.VT_VAR_STRING, mproto.VT_STRING,
+		mproto.VT_TINY_BLOB, mproto.VT_MEDIUM_BLOB, mproto.VT_LONG_BLOB, mproto.VT_BLOB:
+		if field.Flags&mproto.VT_BINARY_FLAG == 0 {
+			return fmt.Errorf("cannot compare column %s in vtgate: its collation is not binary", field.Name)
+		}
+	}
+	return nil
+}
+
+// compareValues compares two values of the same field. NULL sorts
+// before all other values, like in MySQL. Decimals are compared
+// exactly. Other non-numeric values are compared byte-wise, which
+// matches binary collations only: see checkComparable.
+func compareValues(field mproto.Field, v1, v2 sqltypes.Value) (int, error) {
+	switch {
+	case v1.IsNull() && v2.IsNull():
+		return 0, nil
+	case v1.IsNull():
+		return -1, nil
+	case v2.IsNull():
+		return 1, nil
+	}
+	switch field.Type {
+	case mproto.VT_DECIMAL, mproto.VT_NEWDECIMAL:
+		r1, r2, err := parseRats(v1.String(), v2.String())
+		if err != nil {
+			return 0, err
+		}
+		return r1.Cmp(r2), nil
+	}
+	c1, err := mproto.Convert(field, v1)
+	if err != nil {
+		return 0, err
+	}
+	c2, err := mproto.Convert(field, v2)
+	if err != nil {
+		return 0, err
+	}
+	switch c1 := c1.(type) {
+	case int64:
+		c2 := c2.(int64)
+		switch {
+		case c1 < c2:
+			return -1, nil
+		case c1 > c2:
+			return 1, nil
+		}
+		return 0, nil
+	case uint64:
+		c2 := c2.(uint64)
+		switch {
+		case c1 < c2:
+			return -1, nil
+		case c1 > c2:
+			return 1, nil
+		}
+		return 0, nil
+	case float64:
+		return compareFloats(c1, c2.(float64)), nil
+	case []byte:
+		return bytes.Compare(c1, c2.([]byte)), nil
+	}
+	return 0, fmt.Errorf("unexpected type %T for field %s", c1, field.Name)
+}
+
+func compareFloats(f1, f2 float64) int {
+	switch {
+	case f1 < f2:
+		return -1
+	case f1 > f2:
+		return 1
+	}
+	return 0
+}
+
+// sortResult sorts the rows of a result that was built by
+// appending the sorted results of multiple shards.
+func sortResult(qr *mproto.QueryResult, orderBy []planbuilder.OrderByParams) error {
+	if len(orderBy) == 0 || len(qr.Rows) == 0 {
+		return nil
+	}
+	cols, err := resolveOrderBy(qr.Fields, orderBy)
+	if err != nil {
+		return err
+	}
+	sorter := &rowSorter{rows: qr.Rows, cols: cols}
+	sort.Stable(sorter)
+	return sorter.err
+}
+
+// rowSorter sorts rows by the ORDER BY columns.
+// The first error encountered is saved in err.
+type rowSorter struct {
+	rows [][]sqltypes.Value
+	cols []orderByColumn
+	err  error
+}
+
+func (rs *rowSorter) Len() int      { return len(rs.rows) }
+func (rs *rowSorter) Swap(i, j int) { rs.rows[i], rs.rows[j] = rs.rows[j], rs.rows[i] }
+func (rs *rowSorter) Less(i, j int) bool {
+	if rs.err != nil {
+		return false
+	}
+	cmp, err := compareRows(rs.rows[i], rs.rows[j], rs.cols)
+	if err != nil {
+		rs.err = err
+		return false
+	}
+	return cmp < 0
+}
+
+// limitResult applies the offset and rowcount to the rows of qr.
+// A negative rowcount means that there is no limit.
+func limitResult(qr *mproto.QueryResult, offset, rowcount int64) {
+	if offset == 0 && rowcount < 0 {
+		return
+	}
+	rows := qr.Rows
+	if offset >= int64(len(rows)) {
+		rows = nil
+	} else {
+		rows = rows[offset:]
+	}
+	if rowcount >= 0 && rowcount < int64(len(rows)) {
+		rows = rows[:rowcount]
+	}
+	qr.Rows = rows
+	qr.RowsAffected = uint64(len(rows))
+}
+
+// newLimitReply returns a sendReply function that skips the
+// first offset rows and drops all rows after rowcount rows
+// have been sent. A negative rowcount means that there is
+// no limit. The fields are always sent through.
+func newLimitReply(offset, rowcount int64, sendReply func(*mproto.QueryResult) error) func(*mproto.QueryResult) error {
+	if offset == 0 && rowcount < 0 {
+		return sendReply
+	}
+	return func(qr *mproto.QueryResult) error {
+		rows := qr.Rows
+		if offset > 0 {
+			if offset >= int64(len(rows)) {
+				offset -= int64(len(rows))
+				rows = nil
+			} else {
+				rows = rows[offset:]
+				offset = 0
+			}
+		}
+		if rowcount >= 0 {
+			if rowcount < int64(len(rows)) {
+				rows = rows[:rowcount]
+			}
+			rowcount -= int64(len(rows))
+		}
+		if len(rows) == 0 && len(qr.Fields) == 0 {
+			return nil
+		}
+		return sendReply(&mproto.QueryResult{
+			Fields:       qr.Fields,
+			RowsAffected: uint64(len(rows)),
+			Rows:         rows,
+		})
+	}
+}
+
+// mergeStream is the read state of one shard stream.
+type mergeStream struct {
+	results <-chan *mproto.QueryResult
+	rows    [][]sqltypes.Value
+}
+
+// next returns the next row of the stream, or nil
+// if the stream is exhausted. Any fields received are
+// saved into fields, if it's not set yet.
+func (ms *mergeStream) next(fields *[]mproto.Field) []sqltypes.Value {
+	for len(ms.rows) == 0 {
+		qr, ok := <-ms.results
+		if !ok {
+			return nil
+		}
+		if *fields == nil && len(qr.Fields) != 0 {
+			*fields = qr.Fields
+		}
+		ms.rows = qr.Rows
+	}
+	row := ms.rows[0]
+	ms.rows = ms.rows[1:]
+	return row
+}
+
+// mergeHead is the current row of a stream in mergeHeap.
+type mergeHead struct {
+	row    []sqltypes.Value
+	stream *mergeStream
+}
+
+// mergeHeap is a container/heap of stream heads ordered by
+// the ORDER BY columns. The first error encountered is saved
+// in err. Ties are broken by stream index to keep the output stable.
+type mergeHeap struct {
+	heads []mergeHead
+	index map[*mergeStream]int
+	cols  []orderByColumn
+	err   error
+}
+
+func (mh *mergeHeap) Len() int      { return len(mh.heads) }
+func (mh *mergeHeap) Swap(i, j int) { mh.heads[i], mh.heads[j] = mh.heads[j], mh.heads[i] }
+func (mh *mergeHeap) Less(i, j int) bool {
+	if mh.err != nil {
+		return false
+	}
+	cmp, err := compareRows(mh.heads[i].row, mh.heads[j].row, mh.cols)
+	if err != nil {
+		mh.err = err
+		return false
+	}
+	if cmp == 0 {
+		return mh.index[mh.heads[i].stream] < mh.index[mh.heads[j].stream]
+	}
+	return cmp < 0
+}
+func (mh *mergeHeap) Push(x interface{}) { mh.heads = append(mh.heads, x.(mergeHead)) }
+func (mh *mergeHeap) Pop() interface{} {
+	last := mh.heads[len(mh.heads)-1]
+	mh.heads = mh.heads[:len(mh.heads)-1]
+	return last
+}
+
+// newMergeFunc returns a function that merge-sorts shard streams
+// whose rows are individually sorted by orderBy, and sends the
+// merged rows through sendReply. The fields are sent only once.
+// The function can be passed to ScatterConn.StreamExecuteMultiMerge.
+func newMergeFunc(orderBy []planbuilder.OrderByParams, sendReply func(*mproto.QueryResult) error) func([]<-chan *mproto.QueryResult) error {
+	return func(results []<-chan *mproto.QueryResult) error {
+		var fields []mproto.Field
+		mh := &mergeHeap{index: make(map[*mergeStream]int, len(results))}
+		for i, r := range results {
+			ms := &mergeStream{results: r}
+			mh.index[ms] = i
+			if row := ms.next(&fields); row != nil {
+				mh.heads = append(mh.heads, mergeHead{row: row, stream: ms})
+			}
+		}
+		if fields != nil {
+			if err := sendReply(&mproto.QueryResult{Fields: fields}); err != nil {
+				return err
+			}
+		}
+		if len(mh.heads) == 0 {
+			return nil
+		}
+		cols, err := resolveOrderBy(fields, orderBy)
+		if err != nil {
+			return err
+		}
+		mh.cols = cols
+		heap.Init(mh)
+		batch := make([][]sqltypes.Value, 0, mergeBatchSize)
+		for mh.Len() != 0 {
+			head := mh.heads[0]
+			batch = append(batch, head.row)
+			if row := head.stream.next(&fields); row != nil {
+				mh.heads[0].row = row
+				heap.Fix(mh, 0)
+			} else {
+				heap.Pop(mh)
+			}
+			if mh.err != nil {
+				return mh.err
+			}
+			if len(batch) == mergeBatchSize {
+				if err := sendReply(&mproto.QueryResult{RowsAffected: uint64(len(batch)), Rows: batch}); err != nil {
+					return err
+				}
+				batch = make([][]sqltypes.Value, 0, mergeBatchSize)
+			}
+		}
+		if len(batch) != 0 {
+			return sendReply(&mproto.QueryResult{RowsAffected: uint64(len(batch)), Rows: batch})
+		}
+		return nil
+	}
+}
@@ -0,0 +1,244 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mysqlvtgateservice lets MySQL clients connect to vtgate
+// through the MySQL protocol. The queries are executed as V3
+// queries. The tablet type they're sent to is chosen with a
+// "keyspace@tablet_type" database name, as in "use `user@replica`".
+package mysqlvtgateservice
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+
+	log "github.com/golang/glog"
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/mysqlconn"
+	"github.com/youtube/vitess/go/vt/servenv"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/vtgateservice"
+	"golang.org/x/net/context"
+)
+
+var (
+	mysqlServerPort           = flag.Int("mysql_server_port", 0, "If set, also listen for MySQL protocol connections on this port.")
+	mysqlAuthServerImpl       = flag.String("mysql_auth_server_impl", "static", "Which auth server implementation to use for the MySQL protocol: static, with the users of -mysql_auth_server_static_file, or none to allow any user.")
+	mysqlAuthServerStaticFile = flag.String("mysql_auth_server_static_file", "", "JSON file with the users and passwords allowed to connect through the MySQL protocol, as in {\"user\": [\"password\"]}. Required by -mysql_auth_server_impl=static.")
+	mysqlQueryTimeout         = flag.Duration("mysql_server_query_timeout", 0, "timeout of the queries received through the MySQL protocol. 0 means no timeout.")
+)
+
+// servedTabletTypes are the tablet types that can be targeted.
+var servedTabletTypes = []topo.TabletType{topo.TYPE_MASTER, topo.TYPE_REPLICA, topo.TYPE_RDONLY}
+
+// mysqlSession is the state of a connection. It's the
+// ClientData of the mysqlconn.Conn.
+type mysqlSession struct {
+	tabletType topo.TabletType
+	session    *proto.Session
+}
+
+// vtgateHandler implements mysqlconn.Handler on top of vtgate.
+type vtgateHandler struct {
+	server vtgateservice.VTGateService
+}
+
+// newVTGateHandler creates a vtgateHandler for server.
+func newVTGateHandler(server vtgateservice.VTGateService) *vtgateHandler {
+	return &vtgateHandler{server: server}
+}
+
+// NewConnection is part of the mysqlconn.Handler interface.
+func (vh *vtgateHandler) NewConnection(c *mysqlconn.Conn) {
+	c.ClientData = &mysqlSession{
+		tabletType: topo.TYPE_MASTER,
+		session:    &proto.Session{},
+	}
+}
+
+// ConnectionClosed is part of the mysqlconn.Handler interface.
+// The transaction in progress, if any, is rolled back.
+func (vh *vtgateHandler) ConnectionClosed(c *mysqlconn.Conn) {
+	ms := c.ClientData.(*mysqlSession)
+	if !ms.session.InTransaction {
+		return
+	}
+	ctx, cancel := vh.newContext()
+	defer cancel()
+	if err := vh.rollback(ctx, ms); err != nil {
+		log.Warningf("mysqlvtgateservice: cannot roll back the transaction of connection %v: %v", c.ConnectionID, err)
+	}
+}
+
+// ComInitDB is part of the mysqlconn.Handler interface.
+func (vh *vtgateHandler) ComInitDB(c *mysqlconn.Conn, schemaName string) error {
+	ms := c.ClientData.(*mysqlSession)
+	tabletType, err := parseTarget(schemaName)
+	if err != nil {
+		return mysqlconn.NewSQLError(mysqlconn.ERBadDb, mysqlconn.SSBadDb, "%v", err)
+	}
+	if ms.session.InTransaction && tabletType != ms.tabletType {
+		return mysqlconn.NewSQLError(mysqlconn.ERBadDb, mysqlconn.SSBadDb, "cannot change the tablet type from %v to %v in a transaction", ms.tabletType, tabletType)
+	}
+	ms.tabletType = tabletType
+	return nil
+}
+
+// ComQuery is part of the mysqlconn.Handler interface.
+func (vh *vtgateHandler) ComQuery(c *mysqlconn.Conn, query string, callback func(*mproto.QueryResult) error) (err error) {
+	defer vh.server.HandlePanic(&err)
+	ms := c.ClientData.(*mysqlSession)
+	ctx, cancel := vh.newContext()
+	defer cancel()
+	defer func() {
+		if ms.session.InTransaction {
+			c.StatusFlags |= mysqlconn.ServerStatusInTrans
+		} else {
+			c.StatusFlags &^= mysqlconn.ServerStatusInTrans
+		}
+	}()
+
+	query = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(query), ";"))
+	lower := strings.ToLower(query)
+	switch {
+	case lower == "begin" || lower == "start transaction":
+		if ms.session.InTransaction {
+			return errors.New("already in a transaction")
+		}
+		if err := vh.server.Begin(ctx, ms.session); err != nil {
+			return err
+		}
+		return callback(&mproto.QueryResult{})
+	case lower == "commit":
+		if !ms.session.InTransaction {
+			return callback(&mproto.QueryResult{})
+		}
+		if err := vh.server.Commit(ctx, ms.session); err != nil {
+			return err
+		}
+		return callback(&mproto.QueryResult{})
+	case lower == "rollback":
+		if err := vh.rollback(ctx, ms); err != nil {
+			return err
+		}
+		return callback(&mproto.QueryResult{})
+	case strings.HasPrefix(lower, "use ") || strings.HasPrefix(lower, "use\t"):
+		schemaName := strings.Trim(strings.TrimSpace(query[4:]), "`")
+		if err := vh.ComInitDB(c, schemaName); err != nil {
+			return err
+		}
+		c.SchemaName = schemaName
+		return callback(&mproto.QueryResult{})
+	}
+
+	q := &proto.Query{
+		Sql:        query,
+		TabletType: ms.tabletType,
+	}
+	// Selects outside of transactions are streamed, so that
+	// large results don't have to be held in memory.
+	if !ms.session.InTransaction && strings.HasPrefix(lower, "select") {
+		return vh.server.StreamExecute(ctx, q, func(reply *proto.QueryResult) error {
+			if reply.Result == nil {
+				return nil
+			}
+			return callback(reply.Result)
+		})
+	}
+
+	q.Session = ms.session
+	reply := &proto.QueryResult{}
+	if err := vh.server.Execute(ctx, q, reply); err != nil {
+		return err
+	}
+	if reply.Session != nil {
+		ms.session = reply.Session
+	}
+	if reply.Error != "" {
+		return errors.New(reply.Error)
+	}
+	if reply.Result == nil {
+		return callback(&mproto.QueryResult{})
+	}
+	return callback(reply.Result)
+}
+
+func (vh *vtgateHandler) rollback(ctx context.Context, ms *mysqlSession) error {
+	if !ms.session.InTransaction {
+		return nil
+	}
+	return vh.server.Rollback(ctx, ms.session)
+}
+
+func (vh *vtgateHandler) newContext() (context.Context, context.CancelFunc) {
+	if *mysqlQueryTimeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), *mysqlQueryTimeout)
+}
+
+// parseTarget returns the tablet type of a "keyspace@tablet_type"
+// target. V3 queries find their keyspace in the VSchema, so only
+// the tablet type is used. It defaults to master.
+func parseTarget(target string) (topo.TabletType, error) {
+	i := strings.LastIndex(target, "@")
+	if i == -1 {
+		return topo.TYPE_MASTER, nil
+	}
+	tabletType := topo.TabletType(strings.ToLower(target[i+1:]))
+	if !topo.IsTypeInList(tabletType, servedTabletTypes) {
+		return "", fmt.Errorf("invalid tablet type in target %v: %v", target, tabletType)
+	}
+	return tabletType, nil
+}
+
+// newAuthServer returns the auth server of -mysql_auth_server_impl.
+// Allowing any user has to be asked for explicitly.
+func newAuthServer(impl, staticFile string) (mysqlconn.AuthServer, error) {
+	switch impl {
+	case "static":
+		if staticFile == "" {
+			return nil, errors.New("-mysql_auth_server_static_file is required, or -mysql_auth_server_impl=none to allow any user")
+		}
+		return mysqlconn.NewAuthServerStatic(staticFile)
+	case "none":
+		log.Warningf("mysqlvtgateservice: -mysql_auth_server_impl is none, any user can connect")
+		return mysqlconn.AuthServerNone{}, nil
+	}
+	return nil, fmt.Errorf("unknown -mysql_auth_server_impl %q, want static or none", impl)
+}
+
+// initMySQLProtocol starts the MySQL protocol listener
+// if -mysql_server_port is set.
+func initMySQLProtocol(server vtgateservice.VTGateService) {
+	if *mysqlServerPort == 0 {
+		return
+	}
+	authServer, err := newAuthServer(*mysqlAuthServerImpl, *mysqlAuthServerStaticFile)
+	if err != nil {
+		log.Fatalf("mysqlvtgateservice: %v", err)
+	}
+	listener, err := mysqlconn.NewListener("tcp", net.JoinHostPort("", fmt.Sprintf("%v", *mysqlServerPort)), authServer, newVTGateHandler(server))
+	if err != nil {
+		log.Fatalf("mysqlvtgateservice: cannot listen on port %v: %v", *mysqlServerPort, err)
+	}
+	log.Infof("Listening for MySQL protocol connections on port %v", *mysqlServerPort)
+	go listener.Accept()
+	servenv.OnTerm(listener.Close)
+}
+
+func init() {
+	vtgate.RegisterVTGates = append(vtgate.RegisterVTGates, func(vtGate vtgateservice.VTGateService) {
+		servenv.OnRun(func() {
+			initMySQLProtocol(vtGate)
+		})
+	})
+}
+
+// Make sure the handler implements the interface.
+var _ mysqlconn.Handler = (*vtgateHandler)(nil)
@@ -0,0 +1,225 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mysqlvtgateservice
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/mysqlconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/vtgateservice"
+	"golang.org/x/net/context"
+)
+
+// fakeVTGate records the calls of the handler. The methods
+// the handler doesn't use panic through the nil interface.
+type fakeVTGate struct {
+	vtgateservice.VTGateService
+	calls []string
+}
+
+func (f *fakeVTGate) Execute(ctx context.Context, query *proto.Query, reply *proto.QueryResult) error {
+	f.calls = append(f.calls, fmt.Sprintf("Execute %v %v %v", query.Sql, query.TabletType, query.Session.InTransaction))
+	if query.Sql == "fail" {
+		reply.Error = "execute failed"
+		return nil
+	}
+	reply.Result = &mproto.QueryResult{RowsAffected: 1}
+	reply.Session = query.Session
+	return nil
+}
+
+func (f *fakeVTGate) StreamExecute(ctx context.Context, query *proto.Query, sendReply func(*proto.QueryResult) error) error {
+	f.calls = append(f.calls, fmt.Sprintf("StreamExecute %v %v", query.Sql, query.TabletType))
+	if err := sendReply(&proto.QueryResult{Result: &mproto.QueryResult{Fields: []mproto.Field{{Name: "id"}}}}); err != nil {
+		return err
+	}
+	return sendReply(&proto.QueryResult{})
+}
+
+func (f *fakeVTGate) Begin(ctx context.Context, outSession *proto.Session) error {
+	f.calls = append(f.calls, "Begin")
+	outSession.InTransaction = true
+	return nil
+}
+
+func (f *fakeVTGate) Commit(ctx context.Context, inSession *proto.Session) error {
+	f.calls = append(f.calls, "Commit")
+	*inSession = proto.Session{}
+	return nil
+}
+
+func (f *fakeVTGate) Rollback(ctx context.Context, inSession *proto.Session) error {
+	f.calls = append(f.calls, "Rollback")
+	*inSession = proto.Session{}
+	return nil
+}
+
+func (f *fakeVTGate) HandlePanic(err *error) {
+	if x := recover(); x != nil {
+		*err = fmt.Errorf("uncaught panic: %v", x)
+	}
+}
+
+func newTestConn(vh *vtgateHandler) *mysqlconn.Conn {
+	c := &mysqlconn.Conn{}
+	vh.NewConnection(c)
+	return c
+}
+
+func TestParseTarget(t *testing.T) {
+	testcases := []struct {
+		in   string
+		want topo.TabletType
+		err  string
+	}{
+		{in: "", want: topo.TYPE_MASTER},
+		{in: "user", want: topo.TYPE_MASTER},
+		{in: "user@replica", want: topo.TYPE_REPLICA},
+		{in: "user@RDONLY", want: topo.TYPE_RDONLY},
+		{in: "@master", want: topo.TYPE_MASTER},
+		{in: "user@spare", err: "invalid tablet type in target user@spare: spare"},
+	}
+	for _, tcase := range testcases {
+		got, err := parseTarget(tcase.in)
+		if tcase.err != "" {
+			if err == nil || err.Error() != tcase.err {
+				t.Errorf("parseTarget(%q): %v, want %v", tcase.in, err, tcase.err)
+			}
+			continue
+		}
+		if err != nil || got != tcase.want {
+			t.Errorf("parseTarget(%q): %v, %v, want %v", tcase.in, got, err, tcase.want)
+		}
+	}
+}
+
+func TestNewAuthServer(t *testing.T) {
+	f, err := ioutil.TempFile("", "mysql_auth_server_static_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(`{"user1": ["password1"]}`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	authServer, err := newAuthServer("static", f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"user1": {"password1"}}
+	if got := authServer.(*mysqlconn.AuthServerStatic).Entries; !reflect.DeepEqual(got, want) {
+		t.Errorf("newAuthServer: %v, want %v", got, want)
+	}
+	if authServer, err = newAuthServer("none", ""); err != nil || authServer != (mysqlconn.AuthServerNone{}) {
+		t.Errorf("newAuthServer(none): %v, %v, want AuthServerNone", authServer, err)
+	}
+
+	// Without credentials, allowing any user must be explicit.
+	wantErr := "-mysql_auth_server_static_file is required, or -mysql_auth_server_impl=none to allow any user"
+	if _, err := newAuthServer("static", ""); err == nil || err.Error() != wantErr {
+		t.Errorf("newAuthServer(static): %v, want %s", err, wantErr)
+	}
+	wantErr = `unknown -mysql_auth_server_impl "ldap", want static or none`
+	if _, err := newAuthServer("ldap", ""); err == nil || err.Error() != wantErr {
+		t.Errorf("newAuthServer(ldap): %v, want %s", err, wantErr)
+	}
+}
+
+func TestComInitDB(t *testing.T) {
+	vh := newVTGateHandler(&fakeVTGate{})
+	c := newTestConn(vh)
+	if err := vh.ComInitDB(c, "user@replica"); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.ClientData.(*mysqlSession).tabletType; got != topo.TYPE_REPLICA {
+		t.Errorf("tabletType: %v, want %v", got, topo.TYPE_REPLICA)
+	}
+	err := vh.ComInitDB(c, "user@backup")
+	se, ok := err.(*mysqlconn.SQLError)
+	if !ok || se.Num != mysqlconn.ERBadDb {
+		t.Errorf("ComInitDB: %v, want ERBadDb", err)
+	}
+}
+
+func TestComQuery(t *testing.T) {
+	fake := &fakeVTGate{}
+	vh := newVTGateHandler(fake)
+	c := newTestConn(vh)
+	var results []*mproto.QueryResult
+	query := func(sql string) error {
+		return vh.ComQuery(c, sql, func(qr *mproto.QueryResult) error {
+			results = append(results, qr)
+			return nil
+		})
+	}
+
+	for _, sql := range []string{
+		"use `user@replica`",
+		"select id from user",
+		"use user",
+		"begin",
+		"insert into user values(1)",
+		"select id from user",
+		"commit;",
+	} {
+		if err := query(sql); err != nil {
+			t.Fatalf("%v: %v", sql, err)
+		}
+		if sql == "begin" && c.StatusFlags&mysqlconn.ServerStatusInTrans == 0 {
+			t.Errorf("StatusFlags: %v, want ServerStatusInTrans", c.StatusFlags)
+		}
+	}
+	if c.StatusFlags&mysqlconn.ServerStatusInTrans != 0 {
+		t.Errorf("StatusFlags: %v, want no ServerStatusInTrans", c.StatusFlags)
+	}
+	if c.SchemaName != "user" {
+		t.Errorf("SchemaName: %v, want user", c.SchemaName)
+	}
+	wantCalls := []string{
+		"StreamExecute select id from user replica",
+		"Begin",
+		"Execute insert into user values(1) master true",
+		"Execute select id from user master true",
+		"Commit",
+	}
+	if !reflect.DeepEqual(fake.calls, wantCalls) {
+		t.Errorf("calls:\n%v, want\n%v", fake.calls, wantCalls)
+	}
+	// Each statement sends one result: the empty stream reply is skipped.
+	if len(results) != 7 {
+		t.Errorf("len(results): %d, want 7", len(results))
+	}
+
+	if err := query("fail"); err == nil || err.Error() != "execute failed" {
+		t.Errorf("fail: %v, want execute failed", err)
+	}
+}
+
+func TestConnectionClosed(t *testing.T) {
+	fake := &fakeVTGate{}
+	vh := newVTGateHandler(fake)
+	c := newTestConn(vh)
+	vh.ConnectionClosed(c)
+	if len(fake.calls) != 0 {
+		t.Errorf("calls: %v, want none", fake.calls)
+	}
+	if err := vh.ComQuery(c, "begin", func(*mproto.QueryResult) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	vh.ConnectionClosed(c)
+	want := []string{"Begin", "Rollback"}
+	if !reflect.DeepEqual(fake.calls, want) {
+		t.Errorf("calls: %v, want %v", fake.calls, want)
+	}
+}
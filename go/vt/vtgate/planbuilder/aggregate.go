@@ -0,0 +1,148 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// combinableAggregates lists the aggregate functions whose partial
+// results from multiple shards can be combined by VTGate.
+var combinableAggregates = map[string]bool{
+	AggregateCount: true,
+	AggregateSum:   true,
+	AggregateMin:   true,
+	AggregateMax:   true,
+	AggregateAvg:   true,
+}
+
+// buildAggregateParams fills the Aggregates, GroupBy, OrderBy and Limit
+// fields of a multi-shard plan that has aggregates or a GROUP BY.
+// Every avg is rewritten as a sum, and a count is added at the end of
+// the select list so VTGate can compute the average. The ORDER BY
+// and LIMIT are removed from the shard query because they can only
+// be applied after the groups of all shards have been combined.
+func buildAggregateParams(sel *sqlparser.Select, plan *Plan) error {
+	resultColumns := len(sel.SelectExprs)
+	for i := 0; i < resultColumns; i++ {
+		node, ok := sel.SelectExprs[i].(*sqlparser.NonStarExpr)
+		if !ok {
+			return errors.New("cannot use * in an aggregate query")
+		}
+		fexpr, ok := node.Expr.(*sqlparser.FuncExpr)
+		if !ok || !fexpr.IsAggregate() {
+			if exprHasAggregates(node.Expr) {
+				return errors.New("complex aggregate expression")
+			}
+			continue
+		}
+		name := string(fexpr.Name)
+		if !combinableAggregates[name] {
+			return fmt.Errorf("unsupported aggregate function: %s", name)
+		}
+		if fexpr.Distinct {
+			return errors.New("distinct aggregates are not supported")
+		}
+		for _, expr := range fexpr.Exprs {
+			if expr, ok := expr.(*sqlparser.NonStarExpr); ok && exprHasAggregates(expr.Expr) {
+				return errors.New("complex aggregate expression")
+			}
+		}
+		plan.Aggregates = append(plan.Aggregates, AggregateParams{Opcode: name, Col: i})
+	}
+	for _, expr := range sel.GroupBy {
+		col, err := groupByColumn(expr, sel.SelectExprs[:resultColumns])
+		if err != nil {
+			return err
+		}
+		for _, aggr := range plan.Aggregates {
+			if aggr.Col == col {
+				return errors.New("cannot group by an aggregate")
+			}
+		}
+		plan.GroupBy = append(plan.GroupBy, col)
+	}
+	for _, order := range sel.OrderBy {
+		col, err := orderByColumn(order.Expr, sel.SelectExprs[:resultColumns])
+		if err != nil {
+			return err
+		}
+		if !hasResultColumn(sel.SelectExprs[:resultColumns], col) {
+			return fmt.Errorf("order by column %s is not in the select list", col)
+		}
+		plan.OrderBy = append(plan.OrderBy, OrderByParams{
+			Col:  col,
+			Desc: order.Direction == sqlparser.AST_DESC,
+		})
+	}
+	sel.OrderBy = nil
+	// The avgs are rewritten after the ORDER BY is resolved,
+	// because it can refer to them as they were written.
+	for i := range plan.Aggregates {
+		aggr := &plan.Aggregates[i]
+		if aggr.Opcode != AggregateAvg {
+			continue
+		}
+		node := sel.SelectExprs[aggr.Col].(*sqlparser.NonStarExpr)
+		fexpr := node.Expr.(*sqlparser.FuncExpr)
+		if node.As == nil {
+			aggr.Alias = sqlparser.String(fexpr)
+		}
+		aggr.CountCol = len(sel.SelectExprs)
+		sel.SelectExprs = append(sel.SelectExprs, &sqlparser.NonStarExpr{
+			Expr: &sqlparser.FuncExpr{Name: []byte(AggregateCount), Exprs: fexpr.Exprs},
+		})
+		node.Expr = &sqlparser.FuncExpr{Name: []byte(AggregateSum), Exprs: fexpr.Exprs}
+	}
+	if len(sel.SelectExprs) != resultColumns {
+		plan.ResultColumns = resultColumns
+	}
+	if sel.Limit != nil {
+		offset, rowcount, err := sel.Limit.Limits()
+		if err != nil {
+			return err
+		}
+		plan.Limit = &LimitParams{
+			Offset:   offset,
+			Rowcount: rowcount,
+		}
+		sel.Limit = nil
+	}
+	return nil
+}
+
+// groupByColumn returns the position in the select list of a
+// GROUP BY expression. The expression must be a column name or
+// alias that's in the select list, or a position.
+func groupByColumn(expr sqlparser.ValExpr, selectExprs sqlparser.SelectExprs) (int, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		name := string(expr.Name)
+		for i, node := range selectExprs {
+			node := node.(*sqlparser.NonStarExpr)
+			if node.As != nil {
+				if string(node.As) == name {
+					return i, nil
+				}
+				continue
+			}
+			if sqlparser.GetColName(node.Expr) == name {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("group by column %s is not in the select list", name)
+	case sqlparser.NumVal:
+		pos, err := strconv.Atoi(string(expr))
+		if err != nil || pos < 1 || pos > len(selectExprs) {
+			return 0, errors.New("invalid group by position")
+		}
+		return pos - 1, nil
+	}
+	return 0, errors.New("complex group by expression")
+}
@@ -30,20 +30,66 @@ func buildUpdatePlan(upd *sqlparser.Update, schema *Schema) *Plan {
 	switch plan.ID {
 	case SelectEqual:
 		plan.ID = UpdateEqual
-	case SelectIN, SelectScatter, SelectKeyrange:
+	case SelectIN:
+		plan.ID = UpdateIN
+		plan.Rewritten = generateQuery(upd)
+	case SelectScatter:
+		plan.ID = UpdateScatter
+	case SelectKeyrange:
 		plan.ID = NoPlan
 		plan.Reason = "update has multi-shard where clause"
 		return plan
 	default:
 		panic("unexpected")
 	}
-	if isIndexChanging(upd.Exprs, plan.Table.ColVindexes) {
+	if plan.ID != UpdateEqual && upd.Limit != nil {
 		plan.ID = NoPlan
-		plan.Reason = "index is changing"
+		plan.Reason = "multi-shard update cannot have a limit"
+		return plan
+	}
+	if isIndexChanging(upd.Exprs, plan.Table.ColVindexes) {
+		if plan.ID != UpdateEqual {
+			plan.ID = NoPlan
+			plan.Reason = "index is changing"
+			return plan
+		}
+		buildVindexChangePlan(upd, plan)
 	}
 	return plan
 }
 
+// buildVindexChangePlan completes an UpdateEqual plan that changes
+// vindex columns. VTGate moves such rows by deleting them and inserting
+// them again, which requires it to compute the new values of the rows.
+// So, every set clause must assign a value.
+func buildVindexChangePlan(upd *sqlparser.Update, plan *Plan) {
+	plan.SetValues = make(map[string]interface{}, len(upd.Exprs))
+	for _, assignment := range upd.Exprs {
+		switch assignment.Expr.(type) {
+		case sqlparser.ValArg, sqlparser.StrVal, sqlparser.NumVal, *sqlparser.NullVal:
+		default:
+			plan.ID = NoPlan
+			plan.Reason = "index is changing: only values can be set"
+			plan.SetValues = nil
+			return
+		}
+		val, err := asInterface(assignment.Expr)
+		if err != nil {
+			plan.ID = NoPlan
+			plan.Reason = fmt.Sprintf("could not convert val: %s: %v", sqlparser.String(assignment.Expr), err)
+			plan.SetValues = nil
+			return
+		}
+		plan.SetValues[string(assignment.Name.Name)] = val
+	}
+	buf := sqlparser.NewTrackedBuffer(nil)
+	buf.Myprintf("select * from %v%v%v%v for update", upd.Table, upd.Where, upd.OrderBy, upd.Limit)
+	plan.Subquery = buf.String()
+	buf = sqlparser.NewTrackedBuffer(nil)
+	buf.Myprintf("delete from %v%v%v%v", upd.Table, upd.Where, upd.OrderBy, upd.Limit)
+	plan.DeleteQuery = buf.String()
+}
+
 func isIndexChanging(setClauses sqlparser.UpdateExprs, colVindexes []*ColVindex) bool {
 	vindexCols := make([]string, len(colVindexes))
 	for i, index := range colVindexes {
@@ -76,8 +122,21 @@ func buildDeletePlan(del *sqlparser.Delete, schema *Schema) *Plan {
 	switch plan.ID {
 	case SelectEqual:
 		plan.ID = DeleteEqual
-		plan.Subquery = generateDeleteSubquery(del, plan.Table)
-	case SelectIN, SelectScatter, SelectKeyrange:
+		plan.Subquery = generateDeleteSubquery(del, plan.Table, false)
+	case SelectIN, SelectScatter:
+		if del.Limit != nil {
+			plan.ID = NoPlan
+			plan.Reason = "multi-shard delete cannot have a limit"
+			return plan
+		}
+		if plan.ID == SelectIN {
+			plan.ID = DeleteIN
+			plan.Rewritten = generateQuery(del)
+		} else {
+			plan.ID = DeleteScatter
+		}
+		plan.Subquery = generateDeleteSubquery(del, plan.Table, true)
+	case SelectKeyrange:
 		plan.ID = NoPlan
 		plan.Reason = "delete has multi-shard where clause"
 	default:
@@ -86,7 +145,10 @@ func buildDeletePlan(del *sqlparser.Delete, schema *Schema) *Plan {
 	return plan
 }
 
-func generateDeleteSubquery(del *sqlparser.Delete, table *Table) string {
+// generateDeleteSubquery generates the query that fetches the values
+// of the owned vindexes of the rows that will be deleted. If withPrimary
+// is set, the primary vindex column is added at the end.
+func generateDeleteSubquery(del *sqlparser.Delete, table *Table, withPrimary bool) string {
 	if len(table.Owned) == 0 {
 		return ""
 	}
@@ -98,6 +160,10 @@ func generateDeleteSubquery(del *sqlparser.Delete, table *Table) string {
 		buf.WriteString(cv.Col)
 		prefix = ", "
 	}
+	if withPrimary {
+		buf.WriteString(prefix)
+		buf.WriteString(table.ColVindexes[0].Col)
+	}
 	fmt.Fprintf(buf, " from %s", table.Name)
 	buf.WriteString(sqlparser.String(del.Where))
 	buf.WriteString(" for update")
@@ -40,7 +40,7 @@ func buildInsertPlan(ins *sqlparser.Insert, schema *Schema) *Plan {
 		panic("unexpected")
 	}
 	if len(values) != 1 {
-		plan.Reason = "multi-row inserts not supported"
+		buildMultiInsertPlan(ins, plan)
 		return plan
 	}
 	switch values[0].(type) {
@@ -53,6 +53,10 @@ func buildInsertPlan(ins *sqlparser.Insert, schema *Schema) *Plan {
 		plan.Reason = "column list doesn't match values"
 		return plan
 	}
+	if err := buildGeneratePlan(ins, plan); err != nil {
+		plan.Reason = err.Error()
+		return plan
+	}
 	colVindexes := schema.Tables[tablename].ColVindexes
 	plan.ID = InsertSharded
 	plan.Values = make([]interface{}, 0, len(colVindexes))
@@ -68,13 +72,7 @@ func buildInsertPlan(ins *sqlparser.Insert, schema *Schema) *Plan {
 }
 
 func buildIndexPlan(ins *sqlparser.Insert, tablename string, colVindex *ColVindex, plan *Plan) error {
-	pos := -1
-	for i, column := range ins.Columns {
-		if colVindex.Col == sqlparser.GetColName(column.(*sqlparser.NonStarExpr).Expr) {
-			pos = i
-			break
-		}
-	}
+	pos := findInsertColumn(ins, colVindex.Col)
 	if pos == -1 {
 		pos = len(ins.Columns)
 		ins.Columns = append(ins.Columns, &sqlparser.NonStarExpr{Expr: &sqlparser.ColName{Name: []byte(colVindex.Col)}})
@@ -89,3 +87,123 @@ func buildIndexPlan(ins *sqlparser.Insert, tablename string, colVindex *ColVinde
 	row[pos] = sqlparser.ValArg([]byte(fmt.Sprintf(":_%s", colVindex.Col)))
 	return nil
 }
+
+// buildMultiInsertPlan builds an InsertSharded plan for an insert of
+// multiple rows. Values contains one list of vindex values per row.
+// The vindex columns of each row are replaced by bind vars named by
+// InsertVarName. Since the rows can go to different shards, the query
+// is also split into a Prefix, the Mid of each row, and a Suffix.
+func buildMultiInsertPlan(ins *sqlparser.Insert, plan *Plan) {
+	rows := ins.Rows.(sqlparser.Values)
+	for _, row := range rows {
+		tuple, ok := row.(sqlparser.ValTuple)
+		if !ok {
+			plan.Reason = "subqueries not allowed"
+			return
+		}
+		if len(ins.Columns) != len(tuple) {
+			plan.Reason = "column list doesn't match values"
+			return
+		}
+	}
+	if err := buildGeneratePlan(ins, plan); err != nil {
+		plan.Reason = err.Error()
+		return
+	}
+	values := make([]interface{}, len(rows))
+	for i := range values {
+		values[i] = make([]interface{}, 0, len(plan.Table.ColVindexes))
+	}
+	for _, colVindex := range plan.Table.ColVindexes {
+		pos := findInsertColumn(ins, colVindex.Col)
+		if pos == -1 {
+			pos = len(ins.Columns)
+			ins.Columns = append(ins.Columns, &sqlparser.NonStarExpr{Expr: &sqlparser.ColName{Name: []byte(colVindex.Col)}})
+			for i, row := range rows {
+				rows[i] = append(row.(sqlparser.ValTuple), &sqlparser.NullVal{})
+			}
+		}
+		for i, row := range rows {
+			row := row.(sqlparser.ValTuple)
+			val, err := asInterface(row[pos])
+			if err != nil {
+				plan.Reason = fmt.Sprintf("could not convert val: %s, pos: %d: %v", sqlparser.String(row[pos]), pos, err)
+				return
+			}
+			values[i] = append(values[i].([]interface{}), val)
+			row[pos] = sqlparser.ValArg(":" + InsertVarName(colVindex.Col, i))
+		}
+	}
+	plan.ID = InsertSharded
+	plan.Values = values
+	plan.Rewritten = generateQuery(ins)
+
+	buf := sqlparser.NewTrackedBuffer(nil)
+	buf.Myprintf("insert %vinto %v%v values ", ins.Comments, ins.Table, ins.Columns)
+	plan.Prefix = buf.String()
+	plan.Mid = make([]string, 0, len(rows))
+	for _, row := range rows {
+		plan.Mid = append(plan.Mid, sqlparser.String(row))
+	}
+	plan.Suffix = sqlparser.String(ins.OnDup)
+}
+
+// buildGeneratePlan builds the GenerateParams if the table has an
+// auto-increment column. The column is added to the insert if it's
+// not there, and its value in every row is replaced by the bind var
+// named by GenerateVarName. This must be done before the vindex
+// values are computed, because the column can also be a vindex column.
+func buildGeneratePlan(ins *sqlparser.Insert, plan *Plan) error {
+	autoinc := plan.Table.Autoinc
+	if autoinc == nil {
+		return nil
+	}
+	rows := ins.Rows.(sqlparser.Values)
+	pos := findInsertColumn(ins, autoinc.Col)
+	if pos == -1 {
+		pos = len(ins.Columns)
+		ins.Columns = append(ins.Columns, &sqlparser.NonStarExpr{Expr: &sqlparser.ColName{Name: []byte(autoinc.Col)}})
+		for i, row := range rows {
+			rows[i] = append(row.(sqlparser.ValTuple), &sqlparser.NullVal{})
+		}
+	}
+	values := make([]interface{}, 0, len(rows))
+	for i, row := range rows {
+		row := row.(sqlparser.ValTuple)
+		val, err := asInterface(row[pos])
+		if err != nil {
+			return fmt.Errorf("could not convert val: %s, pos: %d: %v", sqlparser.String(row[pos]), pos, err)
+		}
+		values = append(values, val)
+		row[pos] = sqlparser.ValArg(":" + GenerateVarName(i))
+	}
+	plan.Generate = &GenerateParams{
+		Keyspace: autoinc.Sequence.Keyspace.Name,
+		Query:    fmt.Sprintf("select nextval(:n) from %s", autoinc.Sequence.Name),
+		Values:   values,
+	}
+	return nil
+}
+
+// GenerateVarName returns the name of the bind var that's used
+// for the value of the auto-increment column in a row of an insert.
+func GenerateVarName(row int) string {
+	return fmt.Sprintf("__seq%d", row)
+}
+
+// InsertVarName returns the name of the bind var that's used for
+// the value of a vindex column in a row of a multi-row insert.
+func InsertVarName(col string, row int) string {
+	return fmt.Sprintf("_%s_%d", col, row)
+}
+
+// findInsertColumn returns the position of the column
+// in the column list of the insert, or -1 if it's not there.
+func findInsertColumn(ins *sqlparser.Insert, col string) int {
+	for i, column := range ins.Columns {
+		if col == sqlparser.GetColName(column.(*sqlparser.NonStarExpr).Expr) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,523 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package planbuilder
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// joinTable is one of the tables of a join.
+type joinTable struct {
+	// alias is the name by which the columns
+	// of the table are qualified in the query.
+	alias string
+	expr  *sqlparser.AliasedTableExpr
+	table *Table
+}
+
+// joinSide is a bit set of the tables of a join
+// that are referenced by an expression.
+type joinSide int
+
+const (
+	sideLeft = joinSide(1 << iota)
+	sideRight
+	sideBoth = sideLeft | sideRight
+)
+
+// isJoin returns true if the FROM clause has more than one table.
+func isJoin(tableExprs sqlparser.TableExprs) bool {
+	if len(tableExprs) > 1 {
+		return true
+	}
+	_, ok := tableExprs[0].(*sqlparser.JoinTableExpr)
+	return ok
+}
+
+// buildJoinPlan builds a plan for a SELECT that joins two tables.
+// If the rows to be joined are guaranteed to be in the same shard,
+// the query is routed as a whole, like a single-table query.
+// Otherwise, it's split into one query per table, and VTGate joins
+// the results with a nested loop.
+func buildJoinPlan(sel *sqlparser.Select, schema *Schema) *Plan {
+	plan := &Plan{ID: NoPlan}
+	left, right, isLeft, on, err := analyzeJoin(sel.From, schema)
+	if err != nil {
+		plan.Reason = err.Error()
+		return plan
+	}
+	if hasSubquery(on) || (sel.Where != nil && hasSubquery(sel.Where.Expr)) {
+		plan.Reason = "has subquery"
+		return plan
+	}
+	conds := splitAndExpression(nil, on)
+	if !isLeft && sel.Where != nil {
+		conds = splitAndExpression(conds, sel.Where.Expr)
+	}
+	if isSameRoute(left, right, conds) {
+		plan.Table = left.table
+		if !left.table.Keyspace.Sharded {
+			plan.ID = SelectUnsharded
+			return plan
+		}
+		getJoinRouting(sel.Where, plan, left, right)
+		finishSelectPlan(sel, plan)
+		return plan
+	}
+	plan.Join, err = buildJoinParams(sel, left, right, isLeft, on, schema)
+	if err != nil {
+		plan.Reason = err.Error()
+		return plan
+	}
+	plan.ID = SelectJoin
+	return plan
+}
+
+// analyzeJoin returns the tables of a FROM clause that joins two
+// tables, and the ON condition of the join, if any.
+func analyzeJoin(tableExprs sqlparser.TableExprs, schema *Schema) (left, right *joinTable, isLeft bool, on sqlparser.BoolExpr, err error) {
+	var lexpr, rexpr sqlparser.TableExpr
+	switch len(tableExprs) {
+	case 1:
+		node := tableExprs[0].(*sqlparser.JoinTableExpr)
+		switch node.Join {
+		case sqlparser.AST_JOIN, sqlparser.AST_STRAIGHT_JOIN, sqlparser.AST_CROSS_JOIN:
+		case sqlparser.AST_LEFT_JOIN:
+			isLeft = true
+		default:
+			return nil, nil, false, nil, fmt.Errorf("unsupported join type: %s", node.Join)
+		}
+		lexpr, rexpr, on = node.LeftExpr, node.RightExpr, node.On
+	case 2:
+		lexpr, rexpr = tableExprs[0], tableExprs[1]
+	default:
+		return nil, nil, false, nil, errors.New("joins of more than two tables are not supported")
+	}
+	if left, err = newJoinTable(lexpr, schema); err != nil {
+		return nil, nil, false, nil, err
+	}
+	if right, err = newJoinTable(rexpr, schema); err != nil {
+		return nil, nil, false, nil, err
+	}
+	if left.alias == right.alias {
+		return nil, nil, false, nil, fmt.Errorf("not unique table/alias: %s", left.alias)
+	}
+	return left, right, isLeft, on, nil
+}
+
+func newJoinTable(tableExpr sqlparser.TableExpr, schema *Schema) (*joinTable, error) {
+	node, ok := tableExpr.(*sqlparser.AliasedTableExpr)
+	if !ok {
+		return nil, errors.New("joins of more than two tables are not supported")
+	}
+	tablename := sqlparser.GetTableName(node.Expr)
+	table, reason := schema.FindTable(tablename)
+	if reason != "" {
+		return nil, errors.New(reason)
+	}
+	alias := tablename
+	if node.As != nil {
+		alias = string(node.As)
+	}
+	return &joinTable{alias: alias, expr: node, table: table}, nil
+}
+
+// isSameRoute returns true if the rows of the two tables that satisfy
+// the join conditions are guaranteed to be in the same shard. This is
+// the case if both tables are in the same unsharded keyspace, or if a
+// condition equates columns of the two tables that use the same Unique
+// vindex.
+func isSameRoute(left, right *joinTable, conds []sqlparser.BoolExpr) bool {
+	if left.table.Keyspace.Name != right.table.Keyspace.Name {
+		return false
+	}
+	if !left.table.Keyspace.Sharded {
+		return true
+	}
+	for _, cond := range conds {
+		node, ok := cond.(*sqlparser.ComparisonExpr)
+		if !ok || node.Operator != sqlparser.AST_EQ {
+			continue
+		}
+		lcol, ok := node.Left.(*sqlparser.ColName)
+		if !ok {
+			continue
+		}
+		rcol, ok := node.Right.(*sqlparser.ColName)
+		if !ok {
+			continue
+		}
+		if string(lcol.Qualifier) == right.alias {
+			lcol, rcol = rcol, lcol
+		}
+		if string(lcol.Qualifier) != left.alias || string(rcol.Qualifier) != right.alias {
+			continue
+		}
+		lindex := findColVindex(left.table, string(lcol.Name))
+		rindex := findColVindex(right.table, string(rcol.Name))
+		if lindex != nil && rindex != nil && lindex.Name == rindex.Name && IsUnique(lindex.Vindex) {
+			return true
+		}
+	}
+	return false
+}
+
+func findColVindex(table *Table, col string) *ColVindex {
+	for _, index := range table.ColVindexes {
+		if index.Col == col {
+			return index
+		}
+	}
+	return nil
+}
+
+// getJoinRouting is like getWhereRouting, but it matches only
+// the conditions whose columns are qualified by the alias of
+// the table that owns the vindex.
+func getJoinRouting(where *sqlparser.Where, plan *Plan, tables ...*joinTable) {
+	if where == nil {
+		plan.ID = SelectScatter
+		return
+	}
+	values, err := getKeyrangeMatch(where)
+	if err != nil {
+		plan.ID = NoPlan
+		plan.Reason = err.Error()
+		return
+	}
+	if values != nil {
+		plan.ID = SelectKeyrange
+		plan.Values = values
+		return
+	}
+	conds := splitAndExpression(nil, where.Expr)
+	for _, jt := range tables {
+		for _, index := range jt.table.Ordered {
+			for _, cond := range conds {
+				if !isQualifiedBy(cond, jt.alias) {
+					continue
+				}
+				if planID, values := getMatch(cond, index.Col); planID != SelectScatter {
+					plan.ID = planID
+					plan.Table = jt.table
+					plan.ColVindex = index
+					plan.Values = values
+					return
+				}
+			}
+		}
+	}
+	plan.ID = SelectScatter
+}
+
+// isQualifiedBy returns true if all the columns of
+// the expression are qualified by the specified alias.
+func isQualifiedBy(node sqlparser.Expr, alias string) bool {
+	qualified := true
+	rewriteColNames(node, func(col *sqlparser.ColName) sqlparser.ValExpr {
+		if string(col.Qualifier) != alias {
+			qualified = false
+		}
+		return col
+	})
+	return qualified
+}
+
+// buildJoinParams splits a SELECT that joins two tables into one SELECT
+// per table. The columns of the left table that are referenced by the
+// right SELECT are replaced by bind vars, which VTGate sets for every
+// row returned by the left SELECT. All columns must be qualified so
+// that they can be assigned to their table.
+func buildJoinParams(sel *sqlparser.Select, left, right *joinTable, isLeft bool, on sqlparser.BoolExpr, schema *Schema) (*JoinParams, error) {
+	if sel.Distinct != "" || sel.GroupBy != nil || sel.Having != nil ||
+		sel.OrderBy != nil || sel.Limit != nil || hasAggregates(sel.SelectExprs) {
+		return nil, errors.New("cross-shard join has post-processing constructs")
+	}
+	join := &JoinParams{IsLeft: isLeft}
+	lsel := &sqlparser.Select{
+		Comments: sel.Comments,
+		From:     sqlparser.TableExprs{left.expr},
+		Lock:     sel.Lock,
+	}
+	rsel := &sqlparser.Select{
+		Comments: sel.Comments,
+		From:     sqlparser.TableExprs{right.expr},
+		Lock:     sel.Lock,
+	}
+	for _, node := range sel.SelectExprs {
+		node, ok := node.(*sqlparser.NonStarExpr)
+		if !ok {
+			return nil, errors.New("cannot use * in a cross-shard join")
+		}
+		if hasSubquery(node.Expr) {
+			return nil, errors.New("has subquery")
+		}
+		side, err := exprSide(node.Expr, left, right)
+		if err != nil {
+			return nil, err
+		}
+		switch side {
+		case sideBoth:
+			return nil, fmt.Errorf("select expression %s refers to both tables of a cross-shard join", sqlparser.String(node))
+		case sideRight:
+			rsel.SelectExprs = append(rsel.SelectExprs, node)
+			join.Cols = append(join.Cols, len(rsel.SelectExprs))
+		default:
+			lsel.SelectExprs = append(lsel.SelectExprs, node)
+			join.Cols = append(join.Cols, -len(lsel.SelectExprs))
+		}
+	}
+
+	var lconds, rconds []sqlparser.BoolExpr
+	if isLeft {
+		// The ON condition of a left join can only decide which
+		// rows of the right table match.
+		rconds = splitAndExpression(nil, on)
+		if sel.Where != nil {
+			for _, cond := range splitAndExpression(nil, sel.Where.Expr) {
+				side, err := exprSide(cond, left, right)
+				if err != nil {
+					return nil, err
+				}
+				if side&sideRight != 0 {
+					return nil, errors.New("where clause of a cross-shard left join cannot refer to the right table")
+				}
+				lconds = append(lconds, cond)
+			}
+		}
+	} else {
+		conds := splitAndExpression(nil, on)
+		if sel.Where != nil {
+			conds = splitAndExpression(conds, sel.Where.Expr)
+		}
+		for _, cond := range conds {
+			side, err := exprSide(cond, left, right)
+			if err != nil {
+				return nil, err
+			}
+			if side&sideRight != 0 {
+				rconds = append(rconds, cond)
+			} else {
+				lconds = append(lconds, cond)
+			}
+		}
+	}
+	for i, cond := range rconds {
+		rconds[i] = substituteJoinVars(cond, left, lsel, join)
+	}
+	lsel.Where = sqlparser.NewWhere(sqlparser.AST_WHERE, joinAndExpressions(lconds))
+	rsel.Where = sqlparser.NewWhere(sqlparser.AST_WHERE, joinAndExpressions(rconds))
+
+	// A SELECT needs at least one expression. The rows are still
+	// needed to know how many times the other side must be repeated.
+	if len(lsel.SelectExprs) == 0 {
+		lsel.SelectExprs = sqlparser.SelectExprs{&sqlparser.NonStarExpr{Expr: sqlparser.NumVal("1")}}
+	}
+	if len(rsel.SelectExprs) == 0 {
+		rsel.SelectExprs = sqlparser.SelectExprs{&sqlparser.NonStarExpr{Expr: sqlparser.NumVal("1")}}
+	}
+
+	fieldSel := *rsel
+	fieldSel.Where = sqlparser.NewWhere(sqlparser.AST_WHERE, &sqlparser.ComparisonExpr{
+		Left:     sqlparser.NumVal("1"),
+		Operator: sqlparser.AST_NE,
+		Right:    sqlparser.NumVal("1"),
+	})
+	join.FieldQuery = generateQuery(&fieldSel)
+
+	var err error
+	if join.Left, err = buildJoinSide(lsel, schema); err != nil {
+		return nil, err
+	}
+	if join.Right, err = buildJoinSide(rsel, schema); err != nil {
+		return nil, err
+	}
+	return join, nil
+}
+
+// buildJoinSide builds the plan for one table of a cross-shard join.
+func buildJoinSide(sel *sqlparser.Select, schema *Schema) (*Plan, error) {
+	// The query must be generated before building the
+	// plan because the routing analysis changes the AST.
+	original := generateQuery(sel)
+	plan := buildSelectPlan(sel, schema)
+	if plan.ID == NoPlan {
+		return nil, errors.New(plan.Reason)
+	}
+	plan.Original = original
+	return plan, nil
+}
+
+// exprSide returns the tables of the join that are referenced
+// by the columns of the expression.
+func exprSide(node sqlparser.Expr, left, right *joinTable) (side joinSide, err error) {
+	rewriteColNames(node, func(col *sqlparser.ColName) sqlparser.ValExpr {
+		switch {
+		case col.Qualifier == nil:
+			if err == nil {
+				err = fmt.Errorf("column %s must be qualified in a cross-shard join", sqlparser.String(col))
+			}
+		case string(col.Qualifier) == left.alias:
+			side |= sideLeft
+		case string(col.Qualifier) == right.alias:
+			side |= sideRight
+		default:
+			if err == nil {
+				err = fmt.Errorf("unknown table %s in column %s", col.Qualifier, sqlparser.String(col))
+			}
+		}
+		return col
+	})
+	return side, err
+}
+
+// substituteJoinVars replaces the columns of the left table in a
+// condition of the right SELECT with bind vars. Columns that are
+// not yet in the select list of the left SELECT are added to it.
+// It returns the new condition.
+func substituteJoinVars(cond sqlparser.BoolExpr, left *joinTable, lsel *sqlparser.Select, join *JoinParams) sqlparser.BoolExpr {
+	cond = rewriteBoolColNames(cond, func(col *sqlparser.ColName) sqlparser.ValExpr {
+		if string(col.Qualifier) != left.alias {
+			return col
+		}
+		name := left.alias + "_" + string(col.Name)
+		if _, ok := join.Vars[name]; !ok {
+			if join.Vars == nil {
+				join.Vars = make(map[string]int)
+			}
+			join.Vars[name] = selectColumn(lsel, col)
+		}
+		return sqlparser.ValArg(":" + name)
+	})
+	// Routing only matches conditions that have the column on the left.
+	if node, ok := cond.(*sqlparser.ComparisonExpr); ok && node.Operator == sqlparser.AST_EQ {
+		if _, ok := node.Left.(sqlparser.ValArg); ok {
+			node.Left, node.Right = node.Right, node.Left
+		}
+	}
+	return cond
+}
+
+// selectColumn returns the position of a column in the select list
+// of sel. The column is appended to the list if it's not there yet.
+func selectColumn(sel *sqlparser.Select, col *sqlparser.ColName) int {
+	for i, node := range sel.SelectExprs {
+		node, ok := node.(*sqlparser.NonStarExpr)
+		if !ok {
+			continue
+		}
+		if selcol, ok := node.Expr.(*sqlparser.ColName); ok && string(selcol.Qualifier) == string(col.Qualifier) && string(selcol.Name) == string(col.Name) {
+			return i
+		}
+	}
+	sel.SelectExprs = append(sel.SelectExprs, &sqlparser.NonStarExpr{Expr: col})
+	return len(sel.SelectExprs) - 1
+}
+
+// splitAndExpression breaks up the BoolExpr into AND-separated
+// conditions, and appends them to filters.
+func splitAndExpression(filters []sqlparser.BoolExpr, node sqlparser.BoolExpr) []sqlparser.BoolExpr {
+	if node == nil {
+		return filters
+	}
+	if node, ok := node.(*sqlparser.AndExpr); ok {
+		filters = splitAndExpression(filters, node.Left)
+		return splitAndExpression(filters, node.Right)
+	}
+	return append(filters, node)
+}
+
+// joinAndExpressions combines the conditions with AND.
+// It returns nil if there are no conditions.
+func joinAndExpressions(filters []sqlparser.BoolExpr) sqlparser.BoolExpr {
+	var result sqlparser.BoolExpr
+	for _, filter := range filters {
+		// An ON condition doesn't need parenthesis
+		// around an OR, but an AND expression does.
+		if _, ok := filter.(*sqlparser.OrExpr); ok {
+			filter = &sqlparser.ParenBoolExpr{Expr: filter}
+		}
+		if result == nil {
+			result = filter
+			continue
+		}
+		result = &sqlparser.AndExpr{Left: result, Right: filter}
+	}
+	return result
+}
+
+// rewriteColNames replaces every column name of the expression
+// with the value returned by fn, and returns the new expression.
+func rewriteColNames(node sqlparser.Expr, fn func(*sqlparser.ColName) sqlparser.ValExpr) sqlparser.Expr {
+	switch node := node.(type) {
+	case *sqlparser.AndExpr:
+		node.Left = rewriteBoolColNames(node.Left, fn)
+		node.Right = rewriteBoolColNames(node.Right, fn)
+	case *sqlparser.OrExpr:
+		node.Left = rewriteBoolColNames(node.Left, fn)
+		node.Right = rewriteBoolColNames(node.Right, fn)
+	case *sqlparser.NotExpr:
+		node.Expr = rewriteBoolColNames(node.Expr, fn)
+	case *sqlparser.ParenBoolExpr:
+		node.Expr = rewriteBoolColNames(node.Expr, fn)
+	case *sqlparser.ComparisonExpr:
+		node.Left = rewriteValColNames(node.Left, fn)
+		node.Right = rewriteValColNames(node.Right, fn)
+	case *sqlparser.RangeCond:
+		node.Left = rewriteValColNames(node.Left, fn)
+		node.From = rewriteValColNames(node.From, fn)
+		node.To = rewriteValColNames(node.To, fn)
+	case *sqlparser.NullCheck:
+		node.Expr = rewriteValColNames(node.Expr, fn)
+	case *sqlparser.ExistsExpr, *sqlparser.KeyrangeExpr:
+	case sqlparser.StrVal, sqlparser.NumVal, sqlparser.ValArg,
+		*sqlparser.NullVal, sqlparser.ListArg, *sqlparser.Subquery:
+	case *sqlparser.ColName:
+		return fn(node)
+	case sqlparser.ValTuple:
+		for i, val := range node {
+			node[i] = rewriteValColNames(val, fn)
+		}
+	case *sqlparser.BinaryExpr:
+		node.Left = rewriteColNames(node.Left, fn)
+		node.Right = rewriteColNames(node.Right, fn)
+	case *sqlparser.UnaryExpr:
+		node.Expr = rewriteColNames(node.Expr, fn)
+	case *sqlparser.FuncExpr:
+		for _, expr := range node.Exprs {
+			if expr, ok := expr.(*sqlparser.NonStarExpr); ok {
+				expr.Expr = rewriteColNames(expr.Expr, fn)
+			}
+		}
+	case *sqlparser.CaseExpr:
+		node.Expr = rewriteValColNames(node.Expr, fn)
+		for _, when := range node.Whens {
+			when.Cond = rewriteBoolColNames(when.Cond, fn)
+			when.Val = rewriteValColNames(when.Val, fn)
+		}
+		node.Else = rewriteValColNames(node.Else, fn)
+	case nil:
+	default:
+		panic("unexpected")
+	}
+	return node
+}
+
+func rewriteBoolColNames(node sqlparser.BoolExpr, fn func(*sqlparser.ColName) sqlparser.ValExpr) sqlparser.BoolExpr {
+	if node == nil {
+		return nil
+	}
+	return rewriteColNames(node, fn).(sqlparser.BoolExpr)
+}
+
+func rewriteValColNames(node sqlparser.ValExpr, fn func(*sqlparser.ColName) sqlparser.ValExpr) sqlparser.ValExpr {
+	if node == nil {
+		return nil
+	}
+	return rewriteColNames(node, fn).(sqlparser.ValExpr)
+}
@@ -22,10 +22,15 @@ const (
 	SelectIN
 	SelectKeyrange
 	SelectScatter
+	SelectJoin
 	UpdateUnsharded
 	UpdateEqual
+	UpdateIN
+	UpdateScatter
 	DeleteUnsharded
 	DeleteEqual
+	DeleteIN
+	DeleteScatter
 	InsertUnsharded
 	InsertSharded
 	NumPlans
@@ -39,10 +44,15 @@ var planName = [NumPlans]string{
 	"SelectIN",
 	"SelectKeyrange",
 	"SelectScatter",
+	"SelectJoin",
 	"UpdateUnsharded",
 	"UpdateEqual",
+	"UpdateIN",
+	"UpdateScatter",
 	"DeleteUnsharded",
 	"DeleteEqual",
+	"DeleteIN",
+	"DeleteScatter",
 	"InsertUnsharded",
 	"InsertSharded",
 }
@@ -59,13 +69,121 @@ type Plan struct {
 	// Rewritten is the rewritten query. This is empty for
 	// all Unsharded plans since the Original query is sufficient.
 	Rewritten string
-	// Subquery is used for DeleteUnsharded to fetch the column values
-	// for owned vindexes so they can be deleted.
+	// Subquery is used for DeleteEqual, DeleteIN and DeleteScatter
+	// to fetch the column values for owned vindexes so they can be
+	// deleted. For DeleteIN and DeleteScatter, the value of the
+	// primary vindex is also fetched as the last column, because
+	// it's needed to compute the keyspace id of each row. For an
+	// UpdateEqual that changes vindex columns, it fetches the full
+	// rows so they can be moved.
 	Subquery  string
 	ColVindex *ColVindex
 	// Values is a single or a list of values that are used
 	// for making routing decisions.
 	Values interface{}
+	// OrderBy is used by multi-shard SELECTs to merge-sort
+	// the results returned by the individual shards.
+	OrderBy []OrderByParams
+	// Limit is used by multi-shard SELECTs to apply the final
+	// offset and rowcount after the results have been merged.
+	Limit *LimitParams
+	// Aggregates is used by multi-shard SELECTs that have aggregate
+	// functions. The shards return partial aggregates, which are
+	// combined by VTGate.
+	Aggregates []AggregateParams
+	// GroupBy lists the result columns that are used for grouping
+	// the rows while combining Aggregates.
+	GroupBy []int
+	// ResultColumns is the number of columns to return to the
+	// client. Any extra columns of the shard results were added
+	// for computing Aggregates. It's 0 if no columns were added.
+	ResultColumns int
+	// Prefix, Mid and Suffix are used by multi-row InsertSharded
+	// plans. Mid contains the rewritten values of each row. The
+	// query for a shard is built by joining the Mid values of its
+	// rows, and placing them between Prefix and Suffix.
+	Prefix string
+	Mid    []string
+	Suffix string
+	// SetValues and DeleteQuery are used by UpdateEqual plans that
+	// change vindex columns. SetValues contains the new value of
+	// every column that's set. Since the rows may have to move to
+	// a different shard, DeleteQuery is used to delete them before
+	// they're inserted again with the new values.
+	SetValues   map[string]interface{}
+	DeleteQuery string
+	// Join is used by SelectJoin plans, which join the
+	// results of two plans with a nested loop.
+	Join *JoinParams
+	// Generate is used by InsertSharded plans for tables
+	// that have an auto-increment column.
+	Generate *GenerateParams
+}
+
+// OrderByParams specifies a column by which the results
+// of a multi-shard SELECT must be merged.
+type OrderByParams struct {
+	Col  string
+	Desc bool
+}
+
+// AggregateParams specifies how to combine a result column
+// that contains partial aggregates from multiple shards.
+type AggregateParams struct {
+	Opcode string
+	Col    int
+	// CountCol is the column that contains the count
+	// for an avg, which is computed as sum/count.
+	CountCol int `json:",omitempty"`
+	// Alias is the column name to return to the client,
+	// if it differs from the name returned by the shards.
+	Alias string `json:",omitempty"`
+}
+
+// The following constants define the Opcode values of AggregateParams.
+const (
+	AggregateCount = "count"
+	AggregateSum   = "sum"
+	AggregateMin   = "min"
+	AggregateMax   = "max"
+	AggregateAvg   = "avg"
+)
+
+// LimitParams specifies the LIMIT clause of a multi-shard
+// SELECT. Offset and Rowcount can be nil, an int64, or
+// a string containing a bind variable name.
+type LimitParams struct {
+	Offset, Rowcount interface{}
+}
+
+// JoinParams specifies how VTGate joins the results of two
+// plans. For every row returned by Left, Right is executed with
+// the bind vars listed in Vars set to the values of the row.
+type JoinParams struct {
+	IsLeft      bool
+	Left, Right *Plan
+	// Vars maps the bind var names used by Right
+	// to the columns of the Left result.
+	Vars map[string]int `json:",omitempty"`
+	// Cols specifies the result columns. A negative value -n
+	// refers to column n-1 of Left, and a positive value n
+	// refers to column n-1 of Right.
+	Cols []int
+	// FieldQuery is sent to one shard of Right to fetch
+	// the result fields if Left returns no rows.
+	FieldQuery string
+}
+
+// GenerateParams specifies how to generate the values of an
+// auto-increment column from a sequence. Values contains the
+// value of the column for every row of the insert. Rows that don't
+// supply a value get one from the sequence, which is fetched by
+// sending Query to the unsharded Keyspace. The values are then
+// passed as the bind vars named by GenerateVarName.
+type GenerateParams struct {
+	Keyspace string
+	Query    string
+	Values   []interface{}
 }
 
 // Size is defined so that Plan can be given to an LRUCache.
@@ -84,25 +202,49 @@ func (pln *Plan) MarshalJSON() ([]byte, error) {
 		col = pln.ColVindex.Col
 	}
 	marshalPlan := struct {
-		ID        PlanID
-		Reason    string
-		Table     string
-		Original  string
-		Rewritten string
-		Subquery  string
-		Vindex    string
-		Col       string
-		Values    interface{}
+		ID            PlanID
+		Reason        string
+		Table         string
+		Original      string
+		Rewritten     string
+		Subquery      string
+		Vindex        string
+		Col           string
+		Values        interface{}
+		OrderBy       []OrderByParams        `json:",omitempty"`
+		Limit         *LimitParams           `json:",omitempty"`
+		Aggregates    []AggregateParams      `json:",omitempty"`
+		GroupBy       []int                  `json:",omitempty"`
+		ResultColumns int                    `json:",omitempty"`
+		Prefix        string                 `json:",omitempty"`
+		Mid           []string               `json:",omitempty"`
+		Suffix        string                 `json:",omitempty"`
+		SetValues     map[string]interface{} `json:",omitempty"`
+		DeleteQuery   string                 `json:",omitempty"`
+		Join          *JoinParams            `json:",omitempty"`
+		Generate      *GenerateParams        `json:",omitempty"`
 	}{
-		ID:        pln.ID,
-		Reason:    pln.Reason,
-		Table:     tname,
-		Original:  pln.Original,
-		Rewritten: pln.Rewritten,
-		Subquery:  pln.Subquery,
-		Vindex:    vindexName,
-		Col:       col,
-		Values:    pln.Values,
+		ID:            pln.ID,
+		Reason:        pln.Reason,
+		Table:         tname,
+		Original:      pln.Original,
+		Rewritten:     pln.Rewritten,
+		Subquery:      pln.Subquery,
+		Vindex:        vindexName,
+		Col:           col,
+		Values:        pln.Values,
+		OrderBy:       pln.OrderBy,
+		Limit:         pln.Limit,
+		Aggregates:    pln.Aggregates,
+		GroupBy:       pln.GroupBy,
+		ResultColumns: pln.ResultColumns,
+		Prefix:        pln.Prefix,
+		Mid:           pln.Mid,
+		Suffix:        pln.Suffix,
+		SetValues:     pln.SetValues,
+		DeleteQuery:   pln.DeleteQuery,
+		Join:          pln.Join,
+		Generate:      pln.Generate,
 	}
 	return json.Marshal(marshalPlan)
 }
@@ -119,6 +261,12 @@ func (pln *Plan) IsMulti() bool {
 	return false
 }
 
+// IsAggregate returns true if VTGate has to combine the
+// groups returned by the shards of a multi-shard SELECT.
+func (pln *Plan) IsAggregate() bool {
+	return pln.Aggregates != nil || pln.GroupBy != nil
+}
+
 func (id PlanID) String() string {
 	if id < 0 || id >= NumPlans {
 		return ""
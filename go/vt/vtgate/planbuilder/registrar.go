@@ -17,8 +17,19 @@ import (
 // A VCursor is an interface that allows you to execute queries
 // in the current context and session of a VTGate request. Vindexes
 // can use this interface to execute lookup queries.
+// ExecutePre and ExecutePost execute the query in separate
+// transactions that are committed before and after the rest
+// of the session. Outside of a transaction, they're the same as
+// Execute. ExecuteKeyspaceId executes the query on the shard of
+// the keyspace that contains the keyspace id. ExecuteKeyspaceIds
+// executes the query once per shard of the keyspace ids, and returns
+// for each keyspace id the result of its shard.
 type VCursor interface {
 	Execute(query *tproto.BoundQuery) (*mproto.QueryResult, error)
+	ExecutePre(query *tproto.BoundQuery) (*mproto.QueryResult, error)
+	ExecutePost(query *tproto.BoundQuery) (*mproto.QueryResult, error)
+	ExecuteKeyspaceId(keyspace string, ksid key.KeyspaceId, query *tproto.BoundQuery) (*mproto.QueryResult, error)
+	ExecuteKeyspaceIds(keyspace string, ksids []key.KeyspaceId, query *tproto.BoundQuery) ([]*mproto.QueryResult, error)
 }
 
 // Vindex defines the interface required to register a vindex.
@@ -100,6 +111,14 @@ type Lookup interface {
 	Delete(VCursor, []interface{}, key.KeyspaceId) error
 }
 
+// A BatchLookup vindex is a Lookup that can create
+// the entries for multiple ids in one operation. This
+// is used for multi-row inserts.
+type BatchLookup interface {
+	Lookup
+	BatchCreate(VCursor, []interface{}, []key.KeyspaceId) error
+}
+
 // A LookupGenerator vindex is a Lookup that can
 // generate new ids.
 type LookupGenerator interface {
@@ -107,6 +126,13 @@ type LookupGenerator interface {
 	Generate(VCursor, key.KeyspaceId) (id int64, err error)
 }
 
+// An OwnerAware vindex needs to know the table that owns
+// it. BuildSchema calls SetOwner with the keyspace, table and
+// column of the owner. Such vindexes must have an owner.
+type OwnerAware interface {
+	SetOwner(keyspace, table, col string)
+}
+
 // A NewVindexFunc is a function that creates a Vindex based on the
 // properties specified in the input map. Every vindex must
 // register a NewVindexFunc under a unique vindexType.
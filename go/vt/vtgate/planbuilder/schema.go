@@ -24,6 +24,7 @@ type Table struct {
 	ColVindexes []*ColVindex
 	Ordered     []*ColVindex
 	Owned       []*ColVindex
+	Autoinc     *Autoinc
 }
 
 // Keyspace contains the keyspcae info for each Table.
@@ -41,9 +42,18 @@ type ColVindex struct {
 	Vindex Vindex
 }
 
+// Autoinc contains the auto-increment info for a table.
+// The values of Col are generated from the Sequence table,
+// which lives in an unsharded keyspace.
+type Autoinc struct {
+	Col      string
+	Sequence *Table
+}
+
 // BuildSchema builds a Schema from a SchemaFormal.
 func BuildSchema(source *SchemaFormal) (schema *Schema, err error) {
 	schema = &Schema{Tables: make(map[string]*Table)}
+	autoincs := make(map[*Table]*AutoincFormal)
 	for ksname, ks := range source.Keyspaces {
 		keyspace := &Keyspace{
 			Name:    ksname,
@@ -61,6 +71,9 @@ func BuildSchema(source *SchemaFormal) (schema *Schema, err error) {
 			default:
 				return nil, fmt.Errorf("vindex %s needs to be Unique or NonUnique", vname)
 			}
+			if _, ok := vindex.(OwnerAware); ok && vindexInfo.Owner == "" {
+				return nil, fmt.Errorf("vindex %s needs an owner", vname)
+			}
 			vindexes[vname] = vindex
 		}
 		for tname, cname := range ks.Tables {
@@ -112,12 +125,33 @@ func BuildSchema(source *SchemaFormal) (schema *Schema, err error) {
 				t.ColVindexes = append(t.ColVindexes, columnVindex)
 				if columnVindex.Owned {
 					t.Owned = append(t.Owned, columnVindex)
+					if owneraware, ok := columnVindex.Vindex.(OwnerAware); ok {
+						owneraware.SetOwner(ksname, tname, ind.Col)
+					}
 				}
 			}
 			t.Ordered = colVindexSorted(t.ColVindexes)
+			if class.Autoinc != nil {
+				autoincs[t] = class.Autoinc
+			}
 			schema.Tables[tname] = t
 		}
 	}
+	// Sequences are resolved last because they can
+	// be in a keyspace that was not yet loaded.
+	for t, autoinc := range autoincs {
+		seq, ok := schema.Tables[autoinc.Sequence]
+		if !ok {
+			return nil, fmt.Errorf("sequence %s not found for table %s", autoinc.Sequence, t.Name)
+		}
+		if seq.Keyspace.Sharded {
+			return nil, fmt.Errorf("sequence %s for table %s is not in an unsharded keyspace", autoinc.Sequence, t.Name)
+		}
+		t.Autoinc = &Autoinc{
+			Col:      autoinc.Col,
+			Sequence: seq,
+		}
+	}
 	return schema, nil
 }
 
@@ -177,6 +211,7 @@ type VindexFormal struct {
 // the source.
 type ClassFormal struct {
 	ColVindexes []ColVindexFormal
+	Autoinc     *AutoincFormal
 }
 
 // ColVindexFormal is the info for each indexed column
@@ -186,6 +221,14 @@ type ColVindexFormal struct {
 	Name string
 }
 
+// AutoincFormal is the auto-increment info for a table class
+// as loaded from the source. Sequence is the name of the table
+// that's used for generating the values of Col.
+type AutoincFormal struct {
+	Col      string
+	Sequence string
+}
+
 // LoadFile creates a new Schema from a JSON file.
 func LoadFile(filename string) (schema *Schema, err error) {
 	data, err := ioutil.ReadFile(filename)
@@ -69,11 +69,24 @@ func NewSTLU(params map[string]interface{}) (Vindex, error) {
 	return &stLU{Params: params}, nil
 }
 
+// stLO satisfies Lookup, Unique and OwnerAware.
+type stLO struct {
+	stLU
+	Owner []string
+}
+
+func (v *stLO) SetOwner(keyspace, table, col string) { v.Owner = []string{keyspace, table, col} }
+
+func NewSTLO(params map[string]interface{}) (Vindex, error) {
+	return &stLO{stLU: stLU{Params: params}}, nil
+}
+
 func init() {
 	Register("stfu", NewSTFU)
 	Register("stf", NewSTF)
 	Register("stln", NewSTLN)
 	Register("stlu", NewSTLU)
+	Register("stlo", NewSTLO)
 }
 
 func TestUnshardedSchema(t *testing.T) {
@@ -568,3 +581,153 @@ func TestBuildSchemaNonPrimaryLookupFail(t *testing.T) {
 		t.Errorf("BuildSchema: %v, want %v", err, want)
 	}
 }
+
+func TestShardedSchemaAutoinc(t *testing.T) {
+	good := SchemaFormal{
+		Keyspaces: map[string]KeyspaceFormal{
+			"sharded": {
+				Sharded: true,
+				Vindexes: map[string]VindexFormal{
+					"stfu1": {
+						Type: "stfu",
+					},
+				},
+				Classes: map[string]ClassFormal{
+					"t1": {
+						ColVindexes: []ColVindexFormal{
+							{
+								Col:  "c1",
+								Name: "stfu1",
+							},
+						},
+						Autoinc: &AutoincFormal{
+							Col:      "c1",
+							Sequence: "seq",
+						},
+					},
+				},
+				Tables: map[string]string{
+					"t1": "t1",
+				},
+			},
+			"unsharded": {
+				Tables: map[string]string{
+					"seq": "",
+				},
+			},
+		},
+	}
+	got, err := BuildSchema(&good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	autoinc := got.Tables["t1"].Autoinc
+	if autoinc == nil || autoinc.Col != "c1" || autoinc.Sequence != got.Tables["seq"] {
+		t.Errorf("BuildSchema: Autoinc: %+v, want c1 and seq", autoinc)
+	}
+}
+
+func TestBuildSchemaAutoincFail(t *testing.T) {
+	bad := SchemaFormal{
+		Keyspaces: map[string]KeyspaceFormal{
+			"sharded": {
+				Sharded: true,
+				Vindexes: map[string]VindexFormal{
+					"stfu1": {
+						Type: "stfu",
+					},
+				},
+				Classes: map[string]ClassFormal{
+					"t1": {
+						ColVindexes: []ColVindexFormal{
+							{
+								Col:  "c1",
+								Name: "stfu1",
+							},
+						},
+						Autoinc: &AutoincFormal{
+							Col:      "c1",
+							Sequence: "seq",
+						},
+					},
+				},
+				Tables: map[string]string{
+					"t1": "t1",
+				},
+			},
+		},
+	}
+	_, err := BuildSchema(&bad)
+	want := "sequence seq not found for table t1"
+	if err == nil || err.Error() != want {
+		t.Errorf("BuildSchema: %v, want %v", err, want)
+	}
+
+	bad.Keyspaces["sharded"].Classes["seq"] = ClassFormal{
+		ColVindexes: []ColVindexFormal{
+			{
+				Col:  "c1",
+				Name: "stfu1",
+			},
+		},
+	}
+	bad.Keyspaces["sharded"].Tables["seq"] = "seq"
+	_, err = BuildSchema(&bad)
+	want = "sequence seq for table t1 is not in an unsharded keyspace"
+	if err == nil || err.Error() != want {
+		t.Errorf("BuildSchema: %v, want %v", err, want)
+	}
+}
+
+func TestShardedSchemaOwnerAware(t *testing.T) {
+	good := SchemaFormal{
+		Keyspaces: map[string]KeyspaceFormal{
+			"sharded": {
+				Sharded: true,
+				Vindexes: map[string]VindexFormal{
+					"stfu1": {
+						Type: "stfu",
+					},
+					"stlo1": {
+						Type:  "stlo",
+						Owner: "t1",
+					},
+				},
+				Classes: map[string]ClassFormal{
+					"t1": {
+						ColVindexes: []ColVindexFormal{
+							{
+								Col:  "c1",
+								Name: "stfu1",
+							}, {
+								Col:  "c2",
+								Name: "stlo1",
+							},
+						},
+					},
+				},
+				Tables: map[string]string{
+					"t1": "t1",
+				},
+			},
+		},
+	}
+	got, err := BuildSchema(&good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owner := got.Tables["t1"].ColVindexes[1].Vindex.(*stLO).Owner
+	want := []string{"sharded", "t1", "c2"}
+	if !reflect.DeepEqual(owner, want) {
+		t.Errorf("SetOwner: %v, want %v", owner, want)
+	}
+
+	vindex := good.Keyspaces["sharded"].Vindexes["stlo1"]
+	vindex.Owner = ""
+	good.Keyspaces["sharded"].Vindexes["stlo1"] = vindex
+	_, err = BuildSchema(&good)
+	wantErr := "vindex stlo1 needs an owner"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("BuildSchema: %v, want %v", err, wantErr)
+	}
+}
@@ -4,9 +4,24 @@
 
 package planbuilder
 
-import "github.com/youtube/vitess/go/vt/sqlparser"
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/youtube/vitess/go/vt/sqlparser"
+)
+
+// LimitVarName is the bind var name used for the rewritten
+// LIMIT of multi-shard queries that have an offset. VTGate
+// sets it to the sum of the offset and rowcount.
+const LimitVarName = "_limit"
 
 func buildSelectPlan(sel *sqlparser.Select, schema *Schema) *Plan {
+	if isJoin(sel.From) {
+		return buildJoinPlan(sel, schema)
+	}
 	plan := &Plan{ID: NoPlan}
 	tablename, _ := analyzeFrom(sel.From)
 	plan.Table, plan.Reason = schema.FindTable(tablename)
@@ -19,16 +34,38 @@ func buildSelectPlan(sel *sqlparser.Select, schema *Schema) *Plan {
 	}
 
 	getWhereRouting(sel.Where, plan, false)
+	finishSelectPlan(sel, plan)
+	return plan
+}
+
+// finishSelectPlan fills the post-processing fields of a routed
+// SELECT plan, and generates the rewritten query.
+func finishSelectPlan(sel *sqlparser.Select, plan *Plan) {
 	if plan.IsMulti() {
 		if hasPostProcessing(sel) {
 			plan.ID = NoPlan
 			plan.Reason = "multi-shard query has post-processing constructs"
-			return plan
+			return
+		}
+		var err error
+		if hasAggregates(sel.SelectExprs) || sel.GroupBy != nil {
+			err = buildAggregateParams(sel, plan)
+		} else {
+			err = buildMergeParams(sel, plan)
+		}
+		if err != nil {
+			plan.ID = NoPlan
+			plan.Reason = err.Error()
+			plan.OrderBy = nil
+			plan.Limit = nil
+			plan.Aggregates = nil
+			plan.GroupBy = nil
+			plan.ResultColumns = 0
+			return
 		}
 	}
 	// The where clause might have changed.
 	plan.Rewritten = generateQuery(sel)
-	return plan
 }
 
 // TODO(sougou): Copied from tabletserver. Reuse.
@@ -111,6 +148,127 @@ func exprHasAggregates(node sqlparser.Expr) bool {
 	}
 }
 
+// hasPostProcessing returns true if the SELECT has constructs
+// that VTGate cannot compute by combining the shard results.
+// ORDER BY and LIMIT are handled by buildMergeParams. Aggregates
+// and GROUP BY are handled by buildAggregateParams.
 func hasPostProcessing(sel *sqlparser.Select) bool {
-	return hasAggregates(sel.SelectExprs) || sel.Distinct != "" || sel.GroupBy != nil || sel.Having != nil || sel.OrderBy != nil || sel.Limit != nil
+	return sel.Distinct != "" || sel.Having != nil
+}
+
+// buildMergeParams fills the OrderBy and Limit fields of a multi-shard
+// plan. If the LIMIT has an offset, it's rewritten so that every shard
+// returns offset+rowcount rows, and VTGate applies the offset after
+// merging the results.
+func buildMergeParams(sel *sqlparser.Select, plan *Plan) error {
+	for _, order := range sel.OrderBy {
+		col, err := orderByColumn(order.Expr, sel.SelectExprs)
+		if err != nil {
+			return err
+		}
+		if !hasResultColumn(sel.SelectExprs, col) {
+			return fmt.Errorf("order by column %s is not in the select list", col)
+		}
+		plan.OrderBy = append(plan.OrderBy, OrderByParams{
+			Col:  col,
+			Desc: order.Direction == sqlparser.AST_DESC,
+		})
+	}
+	if sel.Limit == nil {
+		return nil
+	}
+	offset, rowcount, err := sel.Limit.Limits()
+	if err != nil {
+		return err
+	}
+	plan.Limit = &LimitParams{
+		Offset:   offset,
+		Rowcount: rowcount,
+	}
+	if offset != nil {
+		sel.Limit = &sqlparser.Limit{Rowcount: sqlparser.ValArg(":" + LimitVarName)}
+	}
+	return nil
+}
+
+// orderByColumn returns the name of the result column that
+// the ORDER BY expression refers to. The expression must be
+// a column name, an aggregate of the select list, or a position
+// in the select list that refers to a named expression or an
+// aggregate.
+func orderByColumn(expr sqlparser.ValExpr, selectExprs sqlparser.SelectExprs) (string, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		return string(expr.Name), nil
+	case *sqlparser.FuncExpr:
+		if expr.IsAggregate() {
+			return aggregateColumn(expr, selectExprs)
+		}
+	case sqlparser.NumVal:
+		pos, err := strconv.Atoi(string(expr))
+		if err != nil || pos < 1 || pos > len(selectExprs) {
+			return "", errors.New("invalid order by position")
+		}
+		node, ok := selectExprs[pos-1].(*sqlparser.NonStarExpr)
+		if !ok {
+			return "", errors.New("order by position cannot refer to *")
+		}
+		if node.As != nil {
+			return string(node.As), nil
+		}
+		switch col := node.Expr.(type) {
+		case *sqlparser.ColName:
+			return string(col.Name), nil
+		case *sqlparser.FuncExpr:
+			if col.IsAggregate() {
+				return sqlparser.String(col), nil
+			}
+		}
+	}
+	return "", errors.New("complex order by expression")
+}
+
+// aggregateColumn returns the name of the result column of an
+// aggregate of the select list: its alias if it has one, or else
+// the aggregate itself, which is how MySQL names the column.
+func aggregateColumn(fexpr *sqlparser.FuncExpr, selectExprs sqlparser.SelectExprs) (string, error) {
+	name := sqlparser.String(fexpr)
+	for _, node := range selectExprs {
+		node, ok := node.(*sqlparser.NonStarExpr)
+		if !ok || sqlparser.String(node.Expr) != name {
+			continue
+		}
+		if node.As != nil {
+			return string(node.As), nil
+		}
+		return name, nil
+	}
+	return "", fmt.Errorf("order by column %s is not in the select list", name)
+}
+
+// hasResultColumn returns true if the select list can produce
+// a result column of the specified name. Like in MySQL, column
+// names are not case sensitive. A '*' is assumed to be able
+// to produce any column.
+func hasResultColumn(selectExprs sqlparser.SelectExprs, col string) bool {
+	for _, node := range selectExprs {
+		switch node := node.(type) {
+		case *sqlparser.StarExpr:
+			return true
+		case *sqlparser.NonStarExpr:
+			if node.As != nil {
+				if strings.EqualFold(string(node.As), col) {
+					return true
+				}
+				continue
+			}
+			if strings.EqualFold(sqlparser.GetColName(node.Expr), col) {
+				return true
+			}
+			if fexpr, ok := node.Expr.(*sqlparser.FuncExpr); ok && fexpr.IsAggregate() && strings.EqualFold(sqlparser.String(fexpr), col) {
+				return true
+			}
+		}
+	}
+	return false
 }
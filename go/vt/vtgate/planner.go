@@ -32,12 +32,16 @@ func NewPlanner(schema *planbuilder.Schema, cacheSize int) *Planner {
 		schema: schema,
 		plans:  cache.NewLRUCache(int64(cacheSize)),
 	}
-	// TODO(sougou): Uncomment after making Planner testable.
-	//http.Handle("/debug/query_plans", plr)
-	//http.Handle("/debug/schema", plr)
 	return plr
 }
 
+// HandleHTTP exports the query plans and the schema of the planner
+// under /debug. It can be called only once per process.
+func (plr *Planner) HandleHTTP() {
+	http.Handle("/debug/query_plans", plr)
+	http.Handle("/debug/schema", plr)
+}
+
 func (plr *Planner) GetPlan(sql string) *planbuilder.Plan {
 	if plr.schema == nil {
 		return noPlan
@@ -0,0 +1,39 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlannerQueryPlans(t *testing.T) {
+	plr := NewPlanner(routerSchema, 10)
+	plr.GetPlan("select * from user where id = 1")
+
+	req, _ := http.NewRequest("GET", "/debug/query_plans", nil)
+	resp := httptest.NewRecorder()
+	plr.ServeHTTP(resp, req)
+	body := resp.Body.String()
+	for _, want := range []string{
+		"Length: 1\n",
+		`"select * from user where id = 1"`,
+		`"ID": "SelectEqual"`,
+		`"Vindex": "user_index"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("query_plans: %s, want it to contain %s", body, want)
+		}
+	}
+
+	req, _ = http.NewRequest("GET", "/debug/unknown", nil)
+	resp = httptest.NewRecorder()
+	plr.ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("unknown page: %d, want %d", resp.Code, http.StatusNotFound)
+	}
+}
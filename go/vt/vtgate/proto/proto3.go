@@ -0,0 +1,338 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package proto
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/key"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+
+	pbq "github.com/youtube/vitess/go/vt/proto/query"
+	pbt "github.com/youtube/vitess/go/vt/proto/topodata"
+	pb "github.com/youtube/vitess/go/vt/proto/vtgate"
+)
+
+// This file contains the conversions between the vtgate types and
+// their proto3 versions. The proto3 decoding returns nil for empty
+// maps and lists, whereas the bson decoding returns empty ones.
+// The Proto3To functions return empty ones too, so that the
+// VTGateService sees the same requests from both RPC stacks.
+
+// SessionToProto3 converts a Session to the proto3 version.
+func SessionToProto3(session *Session) *pb.Session {
+	if session == nil {
+		return nil
+	}
+	return &pb.Session{
+		InTransaction:   session.InTransaction,
+		ShardSessions:   shardSessionsToProto3(session.ShardSessions),
+		PreSessions:     shardSessionsToProto3(session.PreSessions),
+		PostSessions:    shardSessionsToProto3(session.PostSessions),
+		TransactionMode: session.TransactionMode,
+		ReadAfterWrite:  session.ReadAfterWrite,
+		WritePositions:  writePositionsToProto3(session.WritePositions),
+	}
+}
+
+func shardSessionsToProto3(shardSessions []*ShardSession) []*pb.Session_ShardSession {
+	if len(shardSessions) == 0 {
+		return nil
+	}
+	result := make([]*pb.Session_ShardSession, len(shardSessions))
+	for i, ss := range shardSessions {
+		result[i] = &pb.Session_ShardSession{
+			Target: &pbq.Target{
+				Keyspace:   ss.Keyspace,
+				Shard:      ss.Shard,
+				TabletType: topo.TabletTypeToProto3(ss.TabletType),
+			},
+			TransactionId: ss.TransactionId,
+		}
+	}
+	return result
+}
+
+// Proto3ToSession converts a proto3 Session to the internal version.
+func Proto3ToSession(session *pb.Session) *Session {
+	if session == nil {
+		return nil
+	}
+	return &Session{
+		InTransaction:   session.InTransaction,
+		ShardSessions:   proto3ToShardSessions(session.ShardSessions),
+		PreSessions:     proto3ToShardSessions(session.PreSessions),
+		PostSessions:    proto3ToShardSessions(session.PostSessions),
+		TransactionMode: session.TransactionMode,
+		ReadAfterWrite:  session.ReadAfterWrite,
+		WritePositions:  proto3ToWritePositions(session.WritePositions),
+	}
+}
+
+func proto3ToShardSessions(shardSessions []*pb.Session_ShardSession) []*ShardSession {
+	result := make([]*ShardSession, len(shardSessions))
+	for i, ss := range shardSessions {
+		target := ss.GetTarget()
+		if target == nil {
+			target = &pbq.Target{}
+		}
+		result[i] = &ShardSession{
+			Keyspace:      target.Keyspace,
+			Shard:         target.Shard,
+			TabletType:    topo.Proto3ToTabletType(target.TabletType),
+			TransactionId: ss.TransactionId,
+		}
+	}
+	return result
+}
+
+func writePositionsToProto3(writePositions []*WritePosition) []*pb.Session_WritePosition {
+	if len(writePositions) == 0 {
+		return nil
+	}
+	result := make([]*pb.Session_WritePosition, len(writePositions))
+	for i, wp := range writePositions {
+		result[i] = &pb.Session_WritePosition{
+			Keyspace: wp.Keyspace,
+			Shard:    wp.Shard,
+			Position: wp.Position,
+		}
+	}
+	return result
+}
+
+func proto3ToWritePositions(writePositions []*pb.Session_WritePosition) []*WritePosition {
+	result := make([]*WritePosition, len(writePositions))
+	for i, wp := range writePositions {
+		result[i] = &WritePosition{
+			Keyspace: wp.Keyspace,
+			Shard:    wp.Shard,
+			Position: wp.Position,
+		}
+	}
+	return result
+}
+
+// Proto3ToBindVariables converts proto3 bind variables
+// to the internal version.
+func Proto3ToBindVariables(bindVars map[string]*pbq.BindVariable) map[string]interface{} {
+	result := tproto.Proto3ToBindVariables(bindVars)
+	if result == nil {
+		result = make(map[string]interface{})
+	}
+	return result
+}
+
+// BoundQueryListToProto3 converts a list of BoundQuery
+// to the proto3 version.
+func BoundQueryListToProto3(queries []tproto.BoundQuery) []*pbq.BoundQuery {
+	if len(queries) == 0 {
+		return nil
+	}
+	result := make([]*pbq.BoundQuery, len(queries))
+	for i, q := range queries {
+		result[i] = tproto.BoundQueryToProto3(q.Sql, q.BindVariables)
+	}
+	return result
+}
+
+// Proto3ToBoundQueryList converts a list of proto3 BoundQuery
+// to the internal version.
+func Proto3ToBoundQueryList(queries []*pbq.BoundQuery) []tproto.BoundQuery {
+	result := make([]tproto.BoundQuery, len(queries))
+	for i, q := range queries {
+		result[i] = tproto.BoundQuery{
+			Sql:           string(q.Sql),
+			BindVariables: Proto3ToBindVariables(q.BindVariables),
+		}
+	}
+	return result
+}
+
+// Proto3ToShards returns the list of shards of a proto3 request.
+func Proto3ToShards(shards []string) []string {
+	if shards == nil {
+		return []string{}
+	}
+	return shards
+}
+
+// KeyspaceIdsToProto3 converts a list of KeyspaceId
+// to the proto3 version.
+func KeyspaceIdsToProto3(keyspaceIds []key.KeyspaceId) [][]byte {
+	if len(keyspaceIds) == 0 {
+		return nil
+	}
+	result := make([][]byte, len(keyspaceIds))
+	for i, kid := range keyspaceIds {
+		result[i] = []byte(kid)
+	}
+	return result
+}
+
+// Proto3ToKeyspaceIds converts a list of proto3 keyspace ids
+// to the internal version.
+func Proto3ToKeyspaceIds(keyspaceIds [][]byte) []key.KeyspaceId {
+	result := make([]key.KeyspaceId, len(keyspaceIds))
+	for i, kid := range keyspaceIds {
+		result[i] = key.KeyspaceId(kid)
+	}
+	return result
+}
+
+// KeyRangesToProto3 converts a list of KeyRange
+// to the proto3 version.
+func KeyRangesToProto3(keyRanges []key.KeyRange) []*pbt.KeyRange {
+	if len(keyRanges) == 0 {
+		return nil
+	}
+	result := make([]*pbt.KeyRange, len(keyRanges))
+	for i, kr := range keyRanges {
+		result[i] = key.KeyRangeToProto3(kr)
+	}
+	return result
+}
+
+// Proto3ToKeyRanges converts a list of proto3 KeyRange
+// to the internal version.
+func Proto3ToKeyRanges(keyRanges []*pbt.KeyRange) []key.KeyRange {
+	result := make([]key.KeyRange, len(keyRanges))
+	for i, kr := range keyRanges {
+		result[i] = key.Proto3ToKeyRange(kr)
+	}
+	return result
+}
+
+// EntityIdsToProto3 converts a list of EntityId to the proto3 version.
+// It fails if an ExternalID has a type that proto3 can't represent.
+func EntityIdsToProto3(entityIds []EntityId) ([]*pb.ExecuteEntityIdsRequest_EntityId, error) {
+	if len(entityIds) == 0 {
+		return nil, nil
+	}
+	result := make([]*pb.ExecuteEntityIdsRequest_EntityId, len(entityIds))
+	for i, eid := range entityIds {
+		pbeid := &pb.ExecuteEntityIdsRequest_EntityId{
+			KeyspaceId: []byte(eid.KeyspaceID),
+		}
+		switch v := eid.ExternalID.(type) {
+		case nil:
+			pbeid.XidType = pb.ExecuteEntityIdsRequest_EntityId_TYPE_NULL
+		case []byte:
+			pbeid.XidType = pb.ExecuteEntityIdsRequest_EntityId_TYPE_BYTES
+			pbeid.XidBytes = v
+		case string:
+			pbeid.XidType = pb.ExecuteEntityIdsRequest_EntityId_TYPE_BYTES
+			pbeid.XidBytes = []byte(v)
+		case int:
+			pbeid.XidType = pb.ExecuteEntityIdsRequest_EntityId_TYPE_INT
+			pbeid.XidInt = int64(v)
+		case int32:
+			pbeid.XidType = pb.ExecuteEntityIdsRequest_EntityId_TYPE_INT
+			pbeid.XidInt = int64(v)
+		case int64:
+			pbeid.XidType = pb.ExecuteEntityIdsRequest_EntityId_TYPE_INT
+			pbeid.XidInt = v
+		case uint:
+			pbeid.XidType = pb.ExecuteEntityIdsRequest_EntityId_TYPE_UINT
+			pbeid.XidUint = uint64(v)
+		case uint32:
+			pbeid.XidType = pb.ExecuteEntityIdsRequest_EntityId_TYPE_UINT
+			pbeid.XidUint = uint64(v)
+		case uint64:
+			pbeid.XidType = pb.ExecuteEntityIdsRequest_EntityId_TYPE_UINT
+			pbeid.XidUint = v
+		case float64:
+			pbeid.XidType = pb.ExecuteEntityIdsRequest_EntityId_TYPE_FLOAT
+			pbeid.XidFloat = v
+		default:
+			return nil, fmt.Errorf("EntityIdsToProto3: unsupported entity id type %T", v)
+		}
+		result[i] = pbeid
+	}
+	return result, nil
+}
+
+// Proto3ToEntityIds converts a list of proto3 EntityId
+// to the internal version.
+func Proto3ToEntityIds(entityIds []*pb.ExecuteEntityIdsRequest_EntityId) []EntityId {
+	result := make([]EntityId, len(entityIds))
+	for i, eid := range entityIds {
+		result[i].KeyspaceID = key.KeyspaceId(eid.KeyspaceId)
+		switch eid.XidType {
+		case pb.ExecuteEntityIdsRequest_EntityId_TYPE_BYTES:
+			result[i].ExternalID = eid.XidBytes
+		case pb.ExecuteEntityIdsRequest_EntityId_TYPE_INT:
+			result[i].ExternalID = eid.XidInt
+		case pb.ExecuteEntityIdsRequest_EntityId_TYPE_UINT:
+			result[i].ExternalID = eid.XidUint
+		case pb.ExecuteEntityIdsRequest_EntityId_TYPE_FLOAT:
+			result[i].ExternalID = eid.XidFloat
+		}
+	}
+	return result
+}
+
+// SplitQueryPartsToProto3 converts a list of SplitQueryPart
+// to the proto3 version.
+func SplitQueryPartsToProto3(splits []SplitQueryPart) []*pb.SplitQueryResponse_Part {
+	if len(splits) == 0 {
+		return nil
+	}
+	result := make([]*pb.SplitQueryResponse_Part, len(splits))
+	for i, split := range splits {
+		part := &pb.SplitQueryResponse_Part{
+			Size: split.Size,
+		}
+		if split.Query != nil {
+			part.Query = tproto.BoundQueryToProto3(split.Query.Sql, split.Query.BindVariables)
+			part.KeyRangePart = &pb.SplitQueryResponse_KeyRangePart{
+				Keyspace:  split.Query.Keyspace,
+				KeyRanges: KeyRangesToProto3(split.Query.KeyRanges),
+			}
+		}
+		if split.QueryShard != nil {
+			part.Query = tproto.BoundQueryToProto3(split.QueryShard.Sql, split.QueryShard.BindVariables)
+			part.ShardPart = &pb.SplitQueryResponse_ShardPart{
+				Keyspace: split.QueryShard.Keyspace,
+				Shards:   split.QueryShard.Shards,
+			}
+		}
+		result[i] = part
+	}
+	return result
+}
+
+// Proto3ToSplitQueryParts converts a list of proto3 SplitQueryPart
+// to the internal version. The splits are always for rdonly tablets.
+func Proto3ToSplitQueryParts(splits []*pb.SplitQueryResponse_Part) []SplitQueryPart {
+	result := make([]SplitQueryPart, len(splits))
+	for i, part := range splits {
+		result[i].Size = part.Size
+		query := part.Query
+		if query == nil {
+			query = &pbq.BoundQuery{}
+		}
+		if part.KeyRangePart != nil {
+			result[i].Query = &KeyRangeQuery{
+				Sql:           string(query.Sql),
+				BindVariables: Proto3ToBindVariables(query.BindVariables),
+				Keyspace:      part.KeyRangePart.Keyspace,
+				KeyRanges:     Proto3ToKeyRanges(part.KeyRangePart.KeyRanges),
+				TabletType:    topo.TYPE_RDONLY,
+			}
+		}
+		if part.ShardPart != nil {
+			result[i].QueryShard = &QueryShard{
+				Sql:           string(query.Sql),
+				BindVariables: Proto3ToBindVariables(query.BindVariables),
+				Keyspace:      part.ShardPart.Keyspace,
+				Shards:        Proto3ToShards(part.ShardPart.Shards),
+				TabletType:    topo.TYPE_RDONLY,
+			}
+		}
+	}
+	return result
+}
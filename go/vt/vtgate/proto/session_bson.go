@@ -34,6 +34,50 @@ func (session *Session) MarshalBson(buf *bytes2.ChunkedWriter, key string) {
 		}
 		lenWriter.Close()
 	}
+	// []*ShardSession
+	{
+		bson.EncodePrefix(buf, bson.Array, "PreSessions")
+		lenWriter := bson.NewLenWriter(buf)
+		for _i, _v1 := range session.PreSessions {
+			// *ShardSession
+			if _v1 == nil {
+				bson.EncodePrefix(buf, bson.Null, bson.Itoa(_i))
+			} else {
+				(*_v1).MarshalBson(buf, bson.Itoa(_i))
+			}
+		}
+		lenWriter.Close()
+	}
+	// []*ShardSession
+	{
+		bson.EncodePrefix(buf, bson.Array, "PostSessions")
+		lenWriter := bson.NewLenWriter(buf)
+		for _i, _v1 := range session.PostSessions {
+			// *ShardSession
+			if _v1 == nil {
+				bson.EncodePrefix(buf, bson.Null, bson.Itoa(_i))
+			} else {
+				(*_v1).MarshalBson(buf, bson.Itoa(_i))
+			}
+		}
+		lenWriter.Close()
+	}
+	bson.EncodeString(buf, "TransactionMode", session.TransactionMode)
+	bson.EncodeBool(buf, "ReadAfterWrite", session.ReadAfterWrite)
+	// []*WritePosition
+	{
+		bson.EncodePrefix(buf, bson.Array, "WritePositions")
+		lenWriter := bson.NewLenWriter(buf)
+		for _i, _v1 := range session.WritePositions {
+			// *WritePosition
+			if _v1 == nil {
+				bson.EncodePrefix(buf, bson.Null, bson.Itoa(_i))
+			} else {
+				(*_v1).MarshalBson(buf, bson.Itoa(_i))
+			}
+		}
+		lenWriter.Close()
+	}
 
 	lenWriter.Close()
 }
@@ -73,6 +117,67 @@ func (session *Session) UnmarshalBson(buf *bytes.Buffer, kind byte) {
 					session.ShardSessions = append(session.ShardSessions, _v1)
 				}
 			}
+		case "PreSessions":
+			// []*ShardSession
+			if kind != bson.Null {
+				if kind != bson.Array {
+					panic(bson.NewBsonError("unexpected kind %v for session.PreSessions", kind))
+				}
+				bson.Next(buf, 4)
+				session.PreSessions = make([]*ShardSession, 0, 8)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					bson.SkipIndex(buf)
+					var _v1 *ShardSession
+					// *ShardSession
+					if kind != bson.Null {
+						_v1 = new(ShardSession)
+						(*_v1).UnmarshalBson(buf, kind)
+					}
+					session.PreSessions = append(session.PreSessions, _v1)
+				}
+			}
+		case "PostSessions":
+			// []*ShardSession
+			if kind != bson.Null {
+				if kind != bson.Array {
+					panic(bson.NewBsonError("unexpected kind %v for session.PostSessions", kind))
+				}
+				bson.Next(buf, 4)
+				session.PostSessions = make([]*ShardSession, 0, 8)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					bson.SkipIndex(buf)
+					var _v1 *ShardSession
+					// *ShardSession
+					if kind != bson.Null {
+						_v1 = new(ShardSession)
+						(*_v1).UnmarshalBson(buf, kind)
+					}
+					session.PostSessions = append(session.PostSessions, _v1)
+				}
+			}
+		case "TransactionMode":
+			session.TransactionMode = bson.DecodeString(buf, kind)
+		case "ReadAfterWrite":
+			session.ReadAfterWrite = bson.DecodeBool(buf, kind)
+		case "WritePositions":
+			// []*WritePosition
+			if kind != bson.Null {
+				if kind != bson.Array {
+					panic(bson.NewBsonError("unexpected kind %v for session.WritePositions", kind))
+				}
+				bson.Next(buf, 4)
+				session.WritePositions = make([]*WritePosition, 0, 8)
+				for kind := bson.NextByte(buf); kind != bson.EOO; kind = bson.NextByte(buf) {
+					bson.SkipIndex(buf)
+					var _v1 *WritePosition
+					// *WritePosition
+					if kind != bson.Null {
+						_v1 = new(WritePosition)
+						(*_v1).UnmarshalBson(buf, kind)
+					}
+					session.WritePositions = append(session.WritePositions, _v1)
+				}
+			}
 		default:
 			bson.Skip(buf, kind)
 		}
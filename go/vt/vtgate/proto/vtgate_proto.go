@@ -15,16 +15,43 @@ import (
 
 // Session represents the session state. It keeps track of
 // the shards on which transactions are in progress, along
-// with the corresponding tranaction ids.
+// with the corresponding tranaction ids. PreSessions and
+// PostSessions are separate transactions that are committed
+// before and after ShardSessions. They're used by vindexes that
+// need their entries to be committed in a specific order relative
+// to the rows that own them. TransactionMode restricts how many
+// ShardSessions the transaction can have, and how they're committed.
+// If it's empty, the default mode of the vtgate is used.
+// If ReadAfterWrite is set, the commits record the replication
+// position of the masters in WritePositions, and the replica and
+// rdonly reads wait until their tablet has replicated up to the
+// position of its shard. Both are kept across transactions.
 type Session struct {
-	InTransaction bool
-	ShardSessions []*ShardSession
-}
+	InTransaction   bool
+	ShardSessions   []*ShardSession
+	PreSessions     []*ShardSession
+	PostSessions    []*ShardSession
+	TransactionMode string
+	ReadAfterWrite  bool
+	WritePositions  []*WritePosition
+}
+
+const (
+	// TransactionModeSingle fails any statement that would
+	// extend the transaction to a second shard.
+	TransactionModeSingle = "single"
+	// TransactionModeMulti commits the shard sessions of a
+	// transaction one after the other.
+	TransactionModeMulti = "multi"
+	// TransactionModeTwoPC atomically commits the shard sessions
+	// of a transaction using a two-phase commit.
+	TransactionModeTwoPC = "twopc"
+)
 
 //go:generate bsongen -file $GOFILE -type Session -o session_bson.go
 
 func (session *Session) String() string {
-	return fmt.Sprintf("InTransaction: %v, ShardSession: %+v", session.InTransaction, session.ShardSessions)
+	return fmt.Sprintf("InTransaction: %v, ShardSession: %+v, PreSessions: %+v, PostSessions: %+v, TransactionMode: %v, ReadAfterWrite: %v, WritePositions: %+v", session.InTransaction, session.ShardSessions, session.PreSessions, session.PostSessions, session.TransactionMode, session.ReadAfterWrite, session.WritePositions)
 }
 
 // ShardSession represents the session state for a shard.
@@ -41,6 +68,20 @@ func (shardSession *ShardSession) String() string {
 	return fmt.Sprintf("Keyspace: %v, Shard: %v, TabletType: %v, TransactionId: %v", shardSession.Keyspace, shardSession.Shard, shardSession.TabletType, shardSession.TransactionId)
 }
 
+// WritePosition is the replication position of the master of
+// a shard after the last commit of a read-after-write session.
+type WritePosition struct {
+	Keyspace string
+	Shard    string
+	Position string
+}
+
+//go:generate bsongen -file $GOFILE -type WritePosition -o write_position_bson.go
+
+func (writePosition *WritePosition) String() string {
+	return fmt.Sprintf("Keyspace: %v, Shard: %v, Position: %v", writePosition.Keyspace, writePosition.Shard, writePosition.Position)
+}
+
 // Query represents a keyspace agnostic query request.
 type Query struct {
 	Sql              string
@@ -29,11 +29,19 @@ var commonSession = Session{
 		TabletType:    topo.TabletType("master"),
 		TransactionId: 2,
 	}},
+	PreSessions:    []*ShardSession{},
+	PostSessions:   []*ShardSession{},
+	WritePositions: []*WritePosition{},
 }
 
 type reflectSession struct {
-	InTransaction bool
-	ShardSessions []*ShardSession
+	InTransaction   bool
+	ShardSessions   []*ShardSession
+	PreSessions     []*ShardSession
+	PostSessions    []*ShardSession
+	TransactionMode string
+	ReadAfterWrite  bool
+	WritePositions  []*WritePosition
 }
 
 type extraSession struct {
@@ -56,6 +64,25 @@ func TestSession(t *testing.T) {
 			TabletType:    topo.TabletType("master"),
 			TransactionId: 2,
 		}},
+		PreSessions: []*ShardSession{{
+			Keyspace:      "c",
+			Shard:         "0",
+			TabletType:    topo.TabletType("master"),
+			TransactionId: 3,
+		}},
+		PostSessions: []*ShardSession{{
+			Keyspace:      "c",
+			Shard:         "0",
+			TabletType:    topo.TabletType("master"),
+			TransactionId: 4,
+		}},
+		TransactionMode: TransactionModeSingle,
+		ReadAfterWrite:  true,
+		WritePositions: []*WritePosition{{
+			Keyspace: "c",
+			Shard:    "0",
+			Position: "MySQL56/33333333-3333-3333-3333-333333333333:1-5",
+		}},
 	})
 	if err != nil {
 		t.Error(err)
@@ -63,6 +90,25 @@ func TestSession(t *testing.T) {
 	want := string(reflected)
 
 	custom := commonSession
+	custom.PreSessions = []*ShardSession{{
+		Keyspace:      "c",
+		Shard:         "0",
+		TabletType:    topo.TabletType("master"),
+		TransactionId: 3,
+	}}
+	custom.PostSessions = []*ShardSession{{
+		Keyspace:      "c",
+		Shard:         "0",
+		TabletType:    topo.TabletType("master"),
+		TransactionId: 4,
+	}}
+	custom.TransactionMode = TransactionModeSingle
+	custom.ReadAfterWrite = true
+	custom.WritePositions = []*WritePosition{{
+		Keyspace: "c",
+		Shard:    "0",
+		Position: "MySQL56/33333333-3333-3333-3333-333333333333:1-5",
+	}}
 	encoded, err := bson.Marshal(&custom)
 	if err != nil {
 		t.Error(err)
@@ -165,7 +211,7 @@ func TestQueryShard(t *testing.T) {
 func TestQueryResult(t *testing.T) {
 	// We can't do the reflection test because bson
 	// doesn't do it correctly for embedded fields.
-	want := "\x90\x01\x00\x00\x03Result\x00\x99\x00\x00\x00\x04Fields\x009\x00\x00\x00\x030\x001\x00\x00\x00\x05Name\x00\x04\x00\x00\x00\x00name\x12Type\x00\x01\x00\x00\x00\x00\x00\x00\x00\x12Flags\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00?RowsAffected\x00\x02\x00\x00\x00\x00\x00\x00\x00?InsertId\x00\x03\x00\x00\x00\x00\x00\x00\x00\x04Rows\x00 \x00\x00\x00\x040\x00\x18\x00\x00\x00\x050\x00\x01\x00\x00\x00\x001\x051\x00\x02\x00\x00\x00\x00aa\x00\x00\nErr\x00\x00\x03Session\x00\xd0\x00\x00\x00\bInTransaction\x00\x01\x04ShardSessions\x00\xac\x00\x00\x00\x030\x00Q\x00\x00\x00\x05Keyspace\x00\x01\x00\x00\x00\x00a\x05Shard\x00\x01\x00\x00\x00\x000\x05TabletType\x00\a\x00\x00\x00\x00replica\x12TransactionId\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x031\x00P\x00\x00\x00\x05Keyspace\x00\x01\x00\x00\x00\x00b\x05Shard\x00\x01\x00\x00\x00\x001\x05TabletType\x00\x06\x00\x00\x00\x00master\x12TransactionId\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x05Error\x00\x05\x00\x00\x00\x00error\x00"
+	want := "\xf1\x01\x00\x00\x03Result\x00\x99\x00\x00\x00\x04Fields\x009\x00\x00\x00\x030\x001\x00\x00\x00\x05Name\x00\x04\x00\x00\x00\x00name\x12Type\x00\x01\x00\x00\x00\x00\x00\x00\x00\x12Flags\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00?RowsAffected\x00\x02\x00\x00\x00\x00\x00\x00\x00?InsertId\x00\x03\x00\x00\x00\x00\x00\x00\x00\x04Rows\x00 \x00\x00\x00\x040\x00\x18\x00\x00\x00\x050\x00\x01\x00\x00\x00\x001\x051\x00\x02\x00\x00\x00\x00aa\x00\x00\nErr\x00\x00\x03Session\x001\x01\x00\x00\bInTransaction\x00\x01\x04ShardSessions\x00\xac\x00\x00\x00\x030\x00Q\x00\x00\x00\x05Keyspace\x00\x01\x00\x00\x00\x00a\x05Shard\x00\x01\x00\x00\x00\x000\x05TabletType\x00\a\x00\x00\x00\x00replica\x12TransactionId\x00\x01\x00\x00\x00\x00\x00\x00\x00\x00\x031\x00P\x00\x00\x00\x05Keyspace\x00\x01\x00\x00\x00\x00b\x05Shard\x00\x01\x00\x00\x00\x001\x05TabletType\x00\x06\x00\x00\x00\x00master\x12TransactionId\x00\x02\x00\x00\x00\x00\x00\x00\x00\x00\x00\x04PreSessions\x00\x05\x00\x00\x00\x00\x04PostSessions\x00\x05\x00\x00\x00\x00\x05TransactionMode\x00\x00\x00\x00\x00\x00\bReadAfterWrite\x00\x00\x04WritePositions\x00\x05\x00\x00\x00\x00\x00\x05Error\x00\x05\x00\x00\x00\x00error\x00"
 
 	custom := QueryResult{
 		Result: &mproto.QueryResult{
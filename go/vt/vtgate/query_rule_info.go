@@ -0,0 +1,196 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/youtube/vitess/go/cache"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/callinfo"
+	"github.com/youtube/vitess/go/vt/queryrules"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"golang.org/x/net/context"
+)
+
+// The query rules of VTGate are applied before a query is sent to
+// the tablets. Unlike the ones of vttablet, they can match on the
+// keyspace of the query, and only support the FAIL and THROTTLE
+// actions.
+var queryRuleService = &queryrules.Service{
+	PlanByName: func(name string) (int, bool) {
+		planID, ok := planbuilder.PlanByName(name)
+		return int(planID), ok
+	},
+	Actions: map[string]queryrules.Action{
+		"FAIL":     queryrules.QR_FAIL,
+		"THROTTLE": queryrules.QR_THROTTLE,
+	},
+	Keyspaces: true,
+}
+
+// filteredRulesCacheSize is the number of queries
+// for which QueryRuleInfo keeps the filtered rules.
+const filteredRulesCacheSize = 5000
+
+// QueryRuleSources keeps track of every registered query rule source.
+var QueryRuleSources = NewQueryRuleInfo()
+
+var queryRuleHits = stats.NewCounters("VtgateQueryRuleHits")
+
+// NewQueryRules creates a new QueryRules for VTGate.
+func NewQueryRules() *queryrules.QueryRules {
+	return queryrules.NewQueryRules(queryRuleService)
+}
+
+// QueryRuleInfo is the maintainer of QueryRules from multiple sources.
+// Like the plans of vttablet, the rules that apply to a query are
+// filtered once by plan, and kept until the rules change.
+type QueryRuleInfo struct {
+	*queryrules.Sources
+
+	// mu protects filtered, which is replaced when the rules change.
+	mu       sync.Mutex
+	filtered *cache.LRUCache
+}
+
+// NewQueryRuleInfo returns an empty QueryRuleInfo object for use
+func NewQueryRuleInfo() *QueryRuleInfo {
+	return &QueryRuleInfo{
+		Sources:  queryrules.NewSources(queryRuleService),
+		filtered: cache.NewLRUCache(filteredRulesCacheSize),
+	}
+}
+
+// RegisterQueryRuleSource registers a query rule source name with QueryRuleInfo
+func (qri *QueryRuleInfo) RegisterQueryRuleSource(ruleSource string) {
+	qri.mu.Lock()
+	defer qri.mu.Unlock()
+	qri.Sources.RegisterQueryRuleSource(ruleSource)
+	qri.filtered = cache.NewLRUCache(filteredRulesCacheSize)
+}
+
+// UnRegisterQueryRuleSource removes a registered query rule source name
+func (qri *QueryRuleInfo) UnRegisterQueryRuleSource(ruleSource string) {
+	qri.mu.Lock()
+	defer qri.mu.Unlock()
+	qri.Sources.UnRegisterQueryRuleSource(ruleSource)
+	qri.filtered = cache.NewLRUCache(filteredRulesCacheSize)
+}
+
+// SetRules takes an external QueryRules structure and overwrite one of the
+// internal QueryRules as designated by ruleSource parameter
+func (qri *QueryRuleInfo) SetRules(ruleSource string, newRules *queryrules.QueryRules) error {
+	qri.mu.Lock()
+	defer qri.mu.Unlock()
+	if err := qri.Sources.SetRules(ruleSource, newRules); err != nil {
+		return err
+	}
+	qri.filtered = cache.NewLRUCache(filteredRulesCacheSize)
+	return nil
+}
+
+// QueryRuleSink is the queryrules.Sink of vtgate: custom rule
+// sources push their rules to QueryRuleSources through it.
+var QueryRuleSink queryrules.Sink = queryRuleSink{}
+
+type queryRuleSink struct{}
+
+// NewQueryRules is part of the queryrules.Sink interface.
+func (queryRuleSink) NewQueryRules() *queryrules.QueryRules {
+	return NewQueryRules()
+}
+
+// RegisterQueryRuleSource is part of the queryrules.Sink interface.
+func (queryRuleSink) RegisterQueryRuleSource(ruleSource string) {
+	QueryRuleSources.RegisterQueryRuleSource(ruleSource)
+}
+
+// SetQueryRules is part of the queryrules.Sink interface.
+func (queryRuleSink) SetQueryRules(ruleSource string, qrs *queryrules.QueryRules) error {
+	return QueryRuleSources.SetRules(ruleSource, qrs)
+}
+
+// filteredRules are the rules that apply to a query.
+type filteredRules struct {
+	rules *queryrules.QueryRules
+}
+
+// Size is part of the cache.Value interface.
+func (*filteredRules) Size() int {
+	return 1
+}
+
+// getAction returns the action of the first rule that matches
+// the query, and the rule. The rules are evaluated outside of the
+// lock: only the ones filtered for the query are left, and their
+// sources are in the order of their names.
+func (qri *QueryRuleInfo) getAction(q *ruleQuery) (queryrules.Action, *queryrules.QueryRule) {
+	qri.mu.Lock()
+	filtered := qri.filtered
+	qri.mu.Unlock()
+
+	// A rule change replaces filtered after the rules: the
+	// rules filtered here are never older than filtered.
+	key := fmt.Sprintf("%d %s %s %s", q.planID, q.keyspace, q.tableName, q.sql)
+	var rules *queryrules.QueryRules
+	if v, ok := filtered.Get(key); ok {
+		rules = v.(*filteredRules).rules
+	} else {
+		rules = qri.Sources.FilterByPlan(q.sql, int(q.planID), q.keyspace, q.tableName)
+		filtered.Set(key, &filteredRules{rules})
+	}
+	act, qr, _ := rules.GetAction(q.ip, q.user, q.bindVars)
+	return act, qr
+}
+
+// ruleQuery is what the query rules match on.
+type ruleQuery struct {
+	sql       string
+	planID    planbuilder.PlanID
+	keyspace  string
+	tableName string
+	ip        string
+	user      string
+	bindVars  map[string]interface{}
+}
+
+type skipQueryRulesKey int
+
+// skipQueryRules returns a context for which the query rules
+// are not checked. It's used for the queries VTGate sends on
+// its own, like vindex lookups.
+func skipQueryRules(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipQueryRulesKey(0), true)
+}
+
+// checkQueryRules returns an error if a query rule forbids the query.
+// The queries of the non-V3 API have no plan or table name.
+func checkQueryRules(ctx context.Context, sql string, planID planbuilder.PlanID, keyspace, tableName string, bindVars map[string]interface{}) error {
+	if skip, _ := ctx.Value(skipQueryRulesKey(0)).(bool); skip {
+		return nil
+	}
+	q := &ruleQuery{
+		sql:       sql,
+		planID:    planID,
+		keyspace:  keyspace,
+		tableName: tableName,
+		bindVars:  bindVars,
+	}
+	if ci, ok := callinfo.FromContext(ctx); ok {
+		q.ip = ci.RemoteAddr()
+		q.user = ci.Username()
+	}
+	switch act, qr := QueryRuleSources.getAction(q); act {
+	case queryrules.QR_FAIL:
+		queryRuleHits.Add(qr.Name, 1)
+		return fmt.Errorf("query disallowed due to rule: %s", qr.Description)
+	case queryrules.QR_THROTTLE:
+		queryRuleHits.Add(qr.Name, 1)
+		return fmt.Errorf("query throttled to %d qps due to rule: %s", qr.MaxQPS(), qr.Description)
+	}
+	return nil
+}
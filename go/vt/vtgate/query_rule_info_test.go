@@ -0,0 +1,292 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/callinfo"
+	"github.com/youtube/vitess/go/vt/queryrules"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+func TestQueryRuleMatch(t *testing.T) {
+	qri := NewQueryRuleInfo()
+	qri.RegisterQueryRuleSource("TEST")
+	qr := queryrules.NewQueryRule("no scatter on user", "r1", queryrules.QR_FAIL)
+	qr.AddPlanCond(int(planbuilder.SelectScatter))
+	qr.AddKeyspaceCond("user")
+	qr.AddTableCond("user")
+	qr.SetQueryCond("select .*")
+	qr.SetIPCond("123.*")
+	qr.SetUserCond("batch.*")
+	qr.AddBindVarCond("id", true, false, queryrules.QR_NOOP, nil)
+	qrs := NewQueryRules()
+	qrs.Add(qr)
+	if err := qri.SetRules("TEST", qrs); err != nil {
+		t.Fatal(err)
+	}
+
+	match := ruleQuery{
+		sql:       "select * from user",
+		planID:    planbuilder.SelectScatter,
+		keyspace:  "user",
+		tableName: "user",
+		ip:        "123.0.0.1",
+		user:      "batchjob",
+	}
+	// The second time, the rules filtered for the query are reused.
+	for i := 0; i < 2; i++ {
+		if act, qrf := qri.getAction(&match); act != queryrules.QR_FAIL || qrf.Name != "r1" {
+			t.Errorf("getAction(%v): %v %v, want QR_FAIL r1", match, act, qrf)
+		}
+	}
+
+	testcases := []func(q *ruleQuery){
+		func(q *ruleQuery) { q.sql = "update user set a=1" },
+		func(q *ruleQuery) { q.planID = planbuilder.SelectEqual },
+		func(q *ruleQuery) { q.keyspace = "lookup" },
+		func(q *ruleQuery) { q.tableName = "music" },
+		func(q *ruleQuery) { q.ip = "124.0.0.1" },
+		func(q *ruleQuery) { q.user = "frontend" },
+		func(q *ruleQuery) { q.bindVars = map[string]interface{}{"id": 1} },
+	}
+	for i, change := range testcases {
+		q := match
+		change(&q)
+		if act, _ := qri.getAction(&q); act != queryrules.QR_CONTINUE {
+			t.Errorf("case %d: getAction(%v): %v, want QR_CONTINUE", i, q, act)
+		}
+	}
+
+	// The filtered rules are dropped when the rules change.
+	if err := qri.SetRules("TEST", NewQueryRules()); err != nil {
+		t.Fatal(err)
+	}
+	if act, _ := qri.getAction(&match); act != queryrules.QR_CONTINUE {
+		t.Errorf("getAction(%v) without rules: %v, want QR_CONTINUE", match, act)
+	}
+}
+
+func TestQueryRuleInfoSourceOrder(t *testing.T) {
+	qri := NewQueryRuleInfo()
+	sources := []string{"C", "A", "B"}
+	for _, source := range sources {
+		qri.RegisterQueryRuleSource(source)
+		qrs := NewQueryRules()
+		qrs.Add(queryrules.NewQueryRule("rule of "+source, source, queryrules.QR_FAIL))
+		if err := qri.SetRules(source, qrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first source by name wins, whatever the order of the map.
+	for i := 0; i < 10; i++ {
+		q := &ruleQuery{sql: fmt.Sprintf("select %d", i)}
+		if _, qr := qri.getAction(q); qr.Name != "A" {
+			t.Errorf("getAction(%v): %v, want the rule of A", q, qr.Name)
+		}
+	}
+	qri.UnRegisterQueryRuleSource("A")
+	if _, qr := qri.getAction(&ruleQuery{sql: "select 0"}); qr.Name != "B" {
+		t.Errorf("getAction without A: %v, want the rule of B", qr.Name)
+	}
+}
+
+func TestQueryRulesJSON(t *testing.T) {
+	qrs := NewQueryRules()
+	err := qrs.UnmarshalJSON([]byte(`[{
+		"Name": "r1",
+		"Description": "throttle scatter queries of batch jobs",
+		"Plans": ["SelectScatter", "NoPlan"],
+		"Keyspaces": ["user"],
+		"TableNames": ["user", "music"],
+		"User": "batch.*",
+		"RequestIP": "123.*",
+		"Query": "select .*",
+		"BindVarConds": [{
+			"Name": "uid",
+			"OnAbsent": false,
+			"Operator": "UGT",
+			"Value": "100",
+			"OnMismatch": false
+		}],
+		"Action": "THROTTLE",
+		"MaxQPS": 10
+	}]`))
+	if err != nil {
+		t.Fatalf("UnmarshalJSON: %v", err)
+	}
+	qr := qrs.Find("r1")
+	if qr == nil {
+		t.Fatalf("Find(r1): nil")
+	}
+	if qr.MaxQPS() != 10 {
+		t.Errorf("r1 MaxQPS: %v, want 10", qr.MaxQPS())
+	}
+	filtered := qrs.FilterByPlan("select * from user", int(planbuilder.NoPlan), "user", "music")
+	if filtered.Find("r1") == nil {
+		t.Errorf("r1 doesn't match a NoPlan query on user.music")
+	}
+	filtered = qrs.FilterByPlan("select * from user", int(planbuilder.SelectScatter), "lookup", "user")
+	if filtered.Find("r1") != nil {
+		t.Errorf("r1 matches a query on lookup")
+	}
+
+	invalid := []struct {
+		input string
+		err   string
+	}{
+		{`[{"Plans": ["SelectNothing"]}]`, "invalid plan name: SelectNothing"},
+		{`[{"Plans": ["PASS_SELECT"]}]`, "invalid plan name: PASS_SELECT"},
+		{`[{"Keyspaces": "user"}]`, "want list for Keyspaces"},
+		{`[{"Action": "FAIL_RETRY"}]`, "invalid Action FAIL_RETRY"},
+		{`[{"Action": "LIMIT", "MaxRows": 10}]`, "invalid Action LIMIT"},
+		{`[{"Action": "THROTTLE"}]`, "invalid MaxQPS 0"},
+		{`[{"Action": "THROTTLE", "MaxQPS": 1.5}]`, "want integer for MaxQPS"},
+		{`[{"MaxQPS": 10}]`, "MaxQPS is only valid for the THROTTLE action"},
+		{`[{"Table": "user"}]`, "unrecognized tag Table"},
+		{`[{"BindVarConds": [{"Name": "a", "OnAbsent": true, "Operator": "UEQ", "Value": "a", "OnMismatch": true}]}]`, "want uint64: a"},
+	}
+	for _, tcase := range invalid {
+		err := NewQueryRules().UnmarshalJSON([]byte(tcase.input))
+		if err == nil || !strings.Contains(err.Error(), tcase.err) {
+			t.Errorf("UnmarshalJSON(%s): %v, want %v", tcase.input, err, tcase.err)
+		}
+	}
+}
+
+// setTestQueryRules sets the rules of the test source, and returns
+// the function that removes it.
+func setTestQueryRules(t *testing.T, rules string) func() {
+	QueryRuleSources.RegisterQueryRuleSource("TEST")
+	qrs := NewQueryRules()
+	if err := qrs.UnmarshalJSON([]byte(rules)); err != nil {
+		t.Fatal(err)
+	}
+	if err := QueryRuleSources.SetRules("TEST", qrs); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		QueryRuleSources.UnRegisterQueryRuleSource("TEST")
+	}
+}
+
+func TestQueryRulesRouter(t *testing.T) {
+	defer setTestQueryRules(t, `[{
+		"Name": "r1",
+		"Description": "no scatter on user",
+		"Plans": ["SelectScatter"],
+		"Keyspaces": ["TestRouter"]
+	}, {
+		"Name": "r2",
+		"Description": "no lookup inserts",
+		"Plans": ["InsertUnsharded"]
+	}]`)()
+	router, sbc1, _, _ := createRouterEnv()
+
+	_, err := routerExec(router, "select * from user", nil)
+	want := "query disallowed due to rule: no scatter on user"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+	_, err = routerStream(router, &proto.Query{Sql: "select * from user", TabletType: topo.TYPE_MASTER})
+	if err == nil || err.Error() != want {
+		t.Errorf("routerStream: %v, want %v", err, want)
+	}
+	if execCount := sbc1.ExecCount.Get(); execCount != 0 {
+		t.Errorf("want 0, got %v", execCount)
+	}
+
+	_, err = routerExec(router, "select * from user where id = 1", nil)
+	if err != nil {
+		t.Errorf("routerExec: %v", err)
+	}
+
+	// The rules don't apply to the vindex queries.
+	_, err = routerExec(router, "insert into user(id, v, name) values (1, 2, 'myname')", nil)
+	if err != nil {
+		t.Errorf("routerExec: %v", err)
+	}
+	_, err = routerExec(router, "insert into user_idx(id) values (2)", nil)
+	want = "query disallowed due to rule: no lookup inserts"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+}
+
+func TestQueryRulesResolver(t *testing.T) {
+	defer setTestQueryRules(t, `[{
+		"Name": "r1",
+		"Description": "no batch jobs",
+		"Keyspaces": ["TestQueryRulesResolver"],
+		"User": "batch",
+		"Plans": ["NoPlan"]
+	}, {
+		"Name": "r2",
+		"Description": "throttle",
+		"BindVarConds": [{"Name": "throttle", "OnAbsent": false, "Operator": "NOOP"}],
+		"Action": "THROTTLE",
+		"MaxQPS": 1
+	}]`)()
+	s := createSandbox("TestQueryRulesResolver")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	res := NewResolver(new(sandboxTopo), "", "aa", 1*time.Millisecond, 0, 1*time.Second, 1*time.Second, 24*time.Hour)
+	execute := func(ctx context.Context, bindVars map[string]interface{}) error {
+		_, err := res.Execute(ctx, "select id from user", bindVars, "TestQueryRulesResolver", topo.TYPE_MASTER, nil, func(keyspace string) (string, []string, error) {
+			return keyspace, []string{"0"}, nil
+		}, false)
+		return err
+	}
+
+	batchCtx := callinfo.NewContext(context.Background(), &fakeCallInfo{username: "batch"})
+	want := "query disallowed due to rule: no batch jobs"
+	if err := execute(batchCtx, nil); err == nil || err.Error() != want {
+		t.Errorf("Execute: %v, want %v", err, want)
+	}
+	if err := execute(context.Background(), nil); err != nil {
+		t.Errorf("Execute: %v", err)
+	}
+
+	throttle := map[string]interface{}{"throttle": 1}
+	if err := execute(context.Background(), throttle); err != nil {
+		t.Errorf("Execute: %v", err)
+	}
+	want = "query throttled to 1 qps due to rule: throttle"
+	if err := execute(context.Background(), throttle); err == nil || err.Error() != want {
+		t.Errorf("Execute: %v, want %v", err, want)
+	}
+	if execCount := sbc.ExecCount.Get(); execCount != 2 {
+		t.Errorf("want 2, got %v", execCount)
+	}
+}
+
+type fakeCallInfo struct {
+	remoteAddr string
+	username   string
+}
+
+func (fci *fakeCallInfo) RemoteAddr() string {
+	return fci.remoteAddr
+}
+
+func (fci *fakeCallInfo) Username() string {
+	return fci.username
+}
+
+func (fci *fakeCallInfo) Text() string {
+	return ""
+}
+
+func (fci *fakeCallInfo) HTML() template.HTML {
+	return ""
+}
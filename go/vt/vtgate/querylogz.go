@@ -0,0 +1,124 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"text/template"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/acl"
+	"github.com/youtube/vitess/go/vt/logz"
+)
+
+var (
+	querylogzHeader = []byte(`
+		<tr>
+			<th>Method</th>
+			<th>Context</th>
+			<th>Start</th>
+			<th>End</th>
+			<th>Duration</th>
+			<th>Plan time</th>
+			<th>Vindex time</th>
+			<th>Execute time</th>
+			<th>Plan</th>
+			<th>SQL</th>
+			<th>Bind Variables</th>
+			<th>Tablet Type</th>
+			<th>Target</th>
+			<th>Shard Queries</th>
+			<th>Rows</th>
+			<th>Error</th>
+		</tr>
+	`)
+	querylogzFuncMap = template.FuncMap{
+		"stampMicro":   func(t time.Time) string { return t.Format(time.StampMicro) },
+		"cssWrappable": logz.Wrappable,
+	}
+	querylogzTmpl = template.Must(template.New("example").Funcs(querylogzFuncMap).Parse(`
+		<tr class="{{.ColorLevel}}">
+			<td>{{.Method}}</td>
+			<td>{{.ContextHTML}}</td>
+			<td>{{.StartTime | stampMicro}}</td>
+			<td>{{.EndTime | stampMicro}}</td>
+			<td>{{.TotalTime.Seconds}}</td>
+			<td>{{.PlanTime.Seconds}}</td>
+			<td>{{.VindexTime.Seconds}}</td>
+			<td>{{.ExecuteTime.Seconds}}</td>
+			<td>{{.PlanType}}</td>
+			<td>{{.SQL | cssWrappable}}</td>
+			<td>{{.FmtBindVariables false}}</td>
+			<td>{{.TabletType}}</td>
+			<td>{{.Target}}</td>
+			<td>{{.ShardQueries}}</td>
+			<td>{{.RowsReturned}}</td>
+			<td>{{.ErrorStr}}</td>
+		</tr>
+	`))
+)
+
+func init() {
+	http.HandleFunc("/querylogz", func(w http.ResponseWriter, r *http.Request) {
+		ch := QueryLogger.Subscribe("querylogz")
+		defer QueryLogger.Unsubscribe(ch)
+		querylogzHandler(ch, w, r)
+	})
+}
+
+// querylogzHandler serves a human readable snapshot of the
+// current query log.
+func querylogzHandler(ch chan interface{}, w http.ResponseWriter, r *http.Request) {
+	if err := acl.CheckAccessHTTP(r, acl.DEBUGGING); err != nil {
+		acl.SendError(w, err)
+		return
+	}
+	timeout, limit := logz.ParseTimeoutLimitParams(r)
+	logz.StartHTMLTable(w)
+	defer logz.EndHTMLTable(w)
+	w.Write(querylogzHeader)
+
+	tmr := time.NewTimer(timeout)
+	defer tmr.Stop()
+	for i := 0; i < limit; i++ {
+		select {
+		case out := <-ch:
+			select {
+			case <-tmr.C:
+				return
+			default:
+			}
+			stats, ok := out.(*LogStats)
+			if !ok {
+				err := fmt.Errorf("Unexpected value in %s: %#v (expecting value of type %T)", QueryLogger.Name(), out, &LogStats{})
+				io.WriteString(w, `<tr class="error">`)
+				io.WriteString(w, err.Error())
+				io.WriteString(w, "</tr>")
+				log.Error(err)
+				continue
+			}
+			var level string
+			if stats.TotalTime().Seconds() < 0.01 {
+				level = "low"
+			} else if stats.TotalTime().Seconds() < 0.1 {
+				level = "medium"
+			} else {
+				level = "high"
+			}
+			tmplData := struct {
+				*LogStats
+				ColorLevel string
+			}{stats, level}
+			if err := querylogzTmpl.Execute(w, tmplData); err != nil {
+				log.Errorf("querylogz: couldn't execute template: %v", err)
+			}
+		case <-tmr.C:
+			return
+		}
+	}
+}
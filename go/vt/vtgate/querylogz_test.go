@@ -0,0 +1,92 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/youtube/vitess/go/vt/topo"
+	"golang.org/x/net/context"
+)
+
+func TestQuerylogzHandlerInvalidLogStats(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/querylogz?timeout=10&limit=1", nil)
+	response := httptest.NewRecorder()
+	ch := make(chan interface{}, 1)
+	ch <- "test msg"
+	querylogzHandler(ch, response, req)
+	close(ch)
+	if !strings.Contains(response.Body.String(), "error") {
+		t.Fatalf("should show an error page for an non LogStats")
+	}
+}
+
+func TestQuerylogzHandler(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/querylogz?timeout=10&limit=1", nil)
+	logStats, _ := newLogStats(context.Background(), "Execute", "select name from user", map[string]interface{}{"name": "abc"}, topo.TYPE_REPLICA)
+	logStats.StartTime, _ = time.Parse("Jan 2 15:04:05", "Nov 29 13:33:09")
+	logStats.recordExecute("user", []string{"-80", "80-"}, time.Now())
+	logStats.PlanType = "SelectScatter"
+	logStats.PlanTime = 1 * time.Millisecond
+	logStats.VindexTime = 2 * time.Millisecond
+	logStats.ExecuteTime = 3 * time.Millisecond
+	logStats.RowsReturned = 1000
+
+	// fast query
+	pattern := []string{
+		`<tr class="low">`,
+		`<td>Execute</td>`,
+		`<td></td>`,
+		`<td>Nov 29 13:33:09.000000</td>`,
+		`<td>Nov 29 13:33:09.005000</td>`,
+		`<td>0.005</td>`,
+		`<td>0.001</td>`,
+		`<td>0.002</td>`,
+		`<td>0.003</td>`,
+		`<td>SelectScatter</td>`,
+		`<td>select name from user</td>`,
+		`<td>\{"name":"string 3"\}</td>`,
+		`<td>replica</td>`,
+		`<td>user/-80,80-</td>`,
+		`<td>2</td>`,
+		`<td>1000</td>`,
+		`<td></td>`,
+	}
+	logStats.EndTime = logStats.StartTime.Add(5 * time.Millisecond)
+	checkQuerylogzHasStats(t, pattern, logStats, req)
+
+	// medium query
+	pattern[0] = `<tr class="medium">`
+	pattern[4] = `<td>Nov 29 13:33:09.020000</td>`
+	pattern[5] = `<td>0.02</td>`
+	logStats.EndTime = logStats.StartTime.Add(20 * time.Millisecond)
+	checkQuerylogzHasStats(t, pattern, logStats, req)
+
+	// slow query
+	pattern[0] = `<tr class="high">`
+	pattern[4] = `<td>Nov 29 13:33:09.500000</td>`
+	pattern[5] = `<td>0.5</td>`
+	logStats.EndTime = logStats.StartTime.Add(500 * time.Millisecond)
+	checkQuerylogzHasStats(t, pattern, logStats, req)
+}
+
+func checkQuerylogzHasStats(t *testing.T, pattern []string, logStats *LogStats, req *http.Request) {
+	response := httptest.NewRecorder()
+	ch := make(chan interface{}, 1)
+	ch <- logStats
+	querylogzHandler(ch, response, req)
+	close(ch)
+	page, _ := ioutil.ReadAll(response.Body)
+	matcher := regexp.MustCompile(strings.Join(pattern, `\s*`))
+	if !matcher.Match(page) {
+		t.Fatalf("querylogz page does not contain stats: %v, pattern: %v, page: %s", logStats, pattern, string(page))
+	}
+}
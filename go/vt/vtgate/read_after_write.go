@@ -0,0 +1,98 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"flag"
+	"time"
+
+	log "github.com/golang/glog"
+	"github.com/youtube/vitess/go/stats"
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+var (
+	readAfterWriteTimeout = flag.Duration("read_after_write_timeout", 1*time.Second, "how long a replica or rdonly tablet waits to replicate the last commit of a read-after-write session before the read fails")
+
+	readAfterWriteErrors = stats.NewCounters("VtgateReadAfterWriteErrors")
+)
+
+// writePositionsKey is the context key of the positions
+// that the reads of a read-after-write session wait for.
+type writePositionsKey struct{}
+
+// withWritePositions returns a context that makes the reads of
+// session wait for its last commits, if it's a read-after-write
+// session and tabletType is not master.
+func withWritePositions(ctx context.Context, session *SafeSession, tabletType topo.TabletType) context.Context {
+	if tabletType == topo.TYPE_MASTER {
+		return ctx
+	}
+	positions := session.writePositions()
+	if len(positions) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, writePositionsKey{}, positions)
+}
+
+// waitForWritePosition makes conn wait until it has replicated
+// the last commit of the session on keyspace and shard, if ctx
+// has one.
+func waitForWritePosition(ctx context.Context, conn tabletconn.TabletConn, keyspace, shard string) error {
+	positions, _ := ctx.Value(writePositionsKey{}).(map[string]string)
+	position, ok := positions[keyspace+"/"+shard]
+	if !ok {
+		return nil
+	}
+	if err := conn.WaitForPosition(ctx, position, *readAfterWriteTimeout); err != nil {
+		readAfterWriteErrors.Add("WaitForPosition", 1)
+		return err
+	}
+	return nil
+}
+
+// recordWritePositions records in session the replication position
+// of the masters of shardSessions, after they were committed.
+func (stc *ScatterConn) recordWritePositions(ctx context.Context, session *SafeSession, shardSessions []*proto.ShardSession) {
+	if !session.readAfterWrite() {
+		return
+	}
+	for _, shardSession := range shardSessions {
+		if shardSession.TabletType != topo.TYPE_MASTER {
+			continue
+		}
+		recordWritePosition(ctx, session, stc.getConnection(ctx, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType))
+	}
+}
+
+// recordAutocommitPosition records in session the replication position
+// of the master of sdc, if one of queries was a DML autocommitted on it.
+func recordAutocommitPosition(ctx context.Context, session *SafeSession, sdc *ShardConn, transactionID int64, queries ...string) {
+	if transactionID != 0 || sdc.tabletType != topo.TYPE_MASTER || !session.readAfterWrite() {
+		return
+	}
+	for _, query := range queries {
+		if isDml(query) {
+			recordWritePosition(ctx, session, sdc)
+			return
+		}
+	}
+}
+
+// recordWritePosition records in session the replication position
+// of the master of sdc. If the position can't be read, the reads
+// keep waiting for the previous one.
+func recordWritePosition(ctx context.Context, session *SafeSession, sdc *ShardConn) {
+	position, err := sdc.MasterPosition(ctx)
+	if err != nil {
+		log.Warningf("cannot record the write position of %s/%s: %v", sdc.keyspace, sdc.shard, err)
+		readAfterWriteErrors.Add("MasterPosition", 1)
+		return
+	}
+	session.setWritePosition(sdc.keyspace, sdc.shard, position)
+}
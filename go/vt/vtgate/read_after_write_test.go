@@ -0,0 +1,141 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vtgate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
+	"golang.org/x/net/context"
+)
+
+// This file uses the sandbox_test framework.
+
+func TestReadAfterWrite(t *testing.T) {
+	s := createSandbox("TestReadAfterWrite")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true, ReadAfterWrite: true})
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestReadAfterWrite", []string{"0"}, topo.TYPE_MASTER, session, false); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if sbc.MasterPositionCount.Get() != 1 {
+		t.Errorf("want 1, got %v", sbc.MasterPositionCount.Get())
+	}
+	wantSession := proto.Session{
+		ReadAfterWrite: true,
+		WritePositions: []*proto.WritePosition{{
+			Keyspace: "TestReadAfterWrite",
+			Shard:    "0",
+			Position: sandboxPosition,
+		}},
+	}
+	if !reflect.DeepEqual(wantSession, *session.Session) {
+		t.Errorf("want\n%+v, got\n%+v", wantSession, *session.Session)
+	}
+
+	// a replica read waits for the commit
+	if _, err := stc.Execute(context.Background(), "query2", nil, "TestReadAfterWrite", []string{"0"}, topo.TYPE_REPLICA, session, false); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if want := []string{sandboxPosition}; !reflect.DeepEqual(sbc.WaitedPositions, want) {
+		t.Errorf("want %v, got %v", want, sbc.WaitedPositions)
+	}
+
+	// a master read doesn't wait
+	if _, err := stc.Execute(context.Background(), "query3", nil, "TestReadAfterWrite", []string{"0"}, topo.TYPE_MASTER, session, false); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if sbc.WaitForPositionCount.Get() != 1 {
+		t.Errorf("want 1, got %v", sbc.WaitForPositionCount.Get())
+	}
+
+	// the read fails if the replica can't catch up
+	sbc.mustFailServer = 1
+	if _, err := stc.Execute(context.Background(), "query4", nil, "TestReadAfterWrite", []string{"0"}, topo.TYPE_REPLICA, session, false); err == nil {
+		t.Errorf("want error, got nil")
+	}
+	if sbc.WaitForPositionCount.Get() != 2 {
+		t.Errorf("want 2, got %v", sbc.WaitForPositionCount.Get())
+	}
+}
+
+func TestReadAfterWriteAutocommit(t *testing.T) {
+	s := createSandbox("TestReadAfterWriteAutocommit")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	// an autocommitted select doesn't record a position
+	session := NewSafeSession(&proto.Session{ReadAfterWrite: true})
+	if _, err := stc.Execute(context.Background(), "select 1 from dual", nil, "TestReadAfterWriteAutocommit", []string{"0"}, topo.TYPE_MASTER, session, false); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if sbc.MasterPositionCount.Get() != 0 {
+		t.Errorf("want 0, got %v", sbc.MasterPositionCount.Get())
+	}
+
+	// an autocommitted DML does
+	queries := []tproto.BoundQuery{{Sql: "select 1 from dual"}, {Sql: "update a set b = 1"}}
+	if _, err := stc.ExecuteBatch(context.Background(), queries, "TestReadAfterWriteAutocommit", []string{"0"}, topo.TYPE_MASTER, session, false); err != nil {
+		t.Fatalf("ExecuteBatch: %v", err)
+	}
+	if sbc.MasterPositionCount.Get() != 1 {
+		t.Errorf("want 1, got %v", sbc.MasterPositionCount.Get())
+	}
+	wantSession := proto.Session{
+		ReadAfterWrite: true,
+		WritePositions: []*proto.WritePosition{{
+			Keyspace: "TestReadAfterWriteAutocommit",
+			Shard:    "0",
+			Position: sandboxPosition,
+		}},
+	}
+	if !reflect.DeepEqual(wantSession, *session.Session) {
+		t.Errorf("want\n%+v, got\n%+v", wantSession, *session.Session)
+	}
+
+	// a replica that can't catch up yet is retried
+	sbc.mustFailRetry = 1
+	if _, err := stc.Execute(context.Background(), "select 1 from dual", nil, "TestReadAfterWriteAutocommit", []string{"0"}, topo.TYPE_REPLICA, session, false); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if sbc.WaitForPositionCount.Get() != 2 {
+		t.Errorf("want 2, got %v", sbc.WaitForPositionCount.Get())
+	}
+}
+
+func TestReadAfterWriteDisabled(t *testing.T) {
+	s := createSandbox("TestReadAfterWriteDisabled")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true})
+	if _, err := stc.Execute(context.Background(), "query1", nil, "TestReadAfterWriteDisabled", []string{"0"}, topo.TYPE_MASTER, session, false); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if _, err := stc.Execute(context.Background(), "query2", nil, "TestReadAfterWriteDisabled", []string{"0"}, topo.TYPE_REPLICA, session, false); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if sbc.MasterPositionCount.Get() != 0 {
+		t.Errorf("want 0, got %v", sbc.MasterPositionCount.Get())
+	}
+	if sbc.WaitForPositionCount.Get() != 0 {
+		t.Errorf("want 0, got %v", sbc.WaitForPositionCount.Get())
+	}
+}
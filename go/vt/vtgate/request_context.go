@@ -5,7 +5,11 @@
 package vtgate
 
 import (
+	"fmt"
+	"time"
+
 	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/vt/key"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 	"golang.org/x/net/context"
@@ -25,12 +29,113 @@ func newRequestContext(ctx context.Context, query *proto.Query, router *Router)
 	}
 }
 
+// The requestContext methods are the VCursor of the vindexes. The
+// time spent in them is recorded as vindex time in the LogStats of the
+// request. The queries they send are not logged on their own, and the
+// query rules don't apply to them.
+
+// subContext returns the context of the queries sent by the vindexes.
+func (vc *requestContext) subContext() context.Context {
+	return skipQueryRules(withoutLogStats(vc.ctx))
+}
+
 func (vc *requestContext) Execute(boundQuery *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	defer logStatsFromContext(vc.ctx).recordVindex(time.Now())
 	q := &proto.Query{
 		Sql:           boundQuery.Sql,
 		BindVariables: boundQuery.BindVariables,
 		TabletType:    vc.query.TabletType,
 		Session:       vc.query.Session,
 	}
-	return vc.router.Execute(vc.ctx, q)
+	return vc.router.Execute(vc.subContext(), q)
+}
+
+func (vc *requestContext) ExecutePre(boundQuery *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return vc.executePhase(boundQuery, func(session *proto.Session) *[]*proto.ShardSession {
+		return &session.PreSessions
+	})
+}
+
+func (vc *requestContext) ExecutePost(boundQuery *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return vc.executePhase(boundQuery, func(session *proto.Session) *[]*proto.ShardSession {
+		return &session.PostSessions
+	})
+}
+
+// executePhase executes the query in the transactions returned by
+// phase instead of the ones of the session. This is done by using
+// them as the ShardSessions of a temporary session, and copying them
+// back once the query is executed. The separate transactions are
+// on other shards, so they're not allowed in single mode.
+func (vc *requestContext) executePhase(boundQuery *tproto.BoundQuery, phase func(*proto.Session) *[]*proto.ShardSession) (*mproto.QueryResult, error) {
+	safeSession := NewSafeSession(vc.query.Session)
+	if vc.query.NotInTransaction || !safeSession.InTransaction() {
+		return vc.Execute(boundQuery)
+	}
+	if safeSession.transactionMode() == proto.TransactionModeSingle {
+		return nil, fmt.Errorf("vindex transactions not allowed in single mode: %s", boundQuery.Sql)
+	}
+	shardSessions := phase(vc.query.Session)
+	session := &proto.Session{
+		InTransaction:   true,
+		ShardSessions:   *shardSessions,
+		TransactionMode: vc.query.Session.TransactionMode,
+	}
+	q := &proto.Query{
+		Sql:           boundQuery.Sql,
+		BindVariables: boundQuery.BindVariables,
+		TabletType:    vc.query.TabletType,
+		Session:       session,
+	}
+	defer logStatsFromContext(vc.ctx).recordVindex(time.Now())
+	result, err := vc.router.Execute(vc.subContext(), q)
+	*shardSessions = session.ShardSessions
+	return result, err
+}
+
+func (vc *requestContext) ExecuteKeyspaceId(keyspace string, ksid key.KeyspaceId, boundQuery *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	defer logStatsFromContext(vc.ctx).recordVindex(time.Now())
+	ks, shard, err := vc.router.getRouting(vc.ctx, keyspace, vc.query.TabletType, ksid)
+	if err != nil {
+		return nil, err
+	}
+	return vc.router.scatterConn.Execute(
+		vc.subContext(),
+		boundQuery.Sql,
+		boundQuery.BindVariables,
+		ks,
+		[]string{shard},
+		vc.query.TabletType,
+		NewSafeSession(vc.query.Session),
+		vc.query.NotInTransaction)
+}
+
+func (vc *requestContext) ExecuteKeyspaceIds(keyspace string, ksids []key.KeyspaceId, boundQuery *tproto.BoundQuery) ([]*mproto.QueryResult, error) {
+	defer logStatsFromContext(vc.ctx).recordVindex(time.Now())
+	results := make([]*mproto.QueryResult, len(ksids))
+	shardResults := make(map[string]*mproto.QueryResult)
+	for i, ksid := range ksids {
+		ks, shard, err := vc.router.getRouting(vc.ctx, keyspace, vc.query.TabletType, ksid)
+		if err != nil {
+			return nil, err
+		}
+		result, ok := shardResults[shard]
+		if !ok {
+			result, err = vc.router.scatterConn.Execute(
+				vc.subContext(),
+				boundQuery.Sql,
+				boundQuery.BindVariables,
+				ks,
+				[]string{shard},
+				vc.query.TabletType,
+				NewSafeSession(vc.query.Session),
+				vc.query.NotInTransaction)
+			if err != nil {
+				return nil, err
+			}
+			shardResults[shard] = result
+		}
+		results[i] = result
+	}
+	return results, nil
 }
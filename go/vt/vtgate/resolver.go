@@ -17,6 +17,7 @@ import (
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
 	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 	"golang.org/x/net/context"
 )
@@ -116,6 +117,9 @@ func (res *Resolver) Execute(
 	mapToShards func(string) (string, []string, error),
 	notInTransaction bool,
 ) (*mproto.QueryResult, error) {
+	if err := checkQueryRules(ctx, sql, planbuilder.NoPlan, keyspace, "", bindVars); err != nil {
+		return nil, err
+	}
 	keyspace, shards, err := mapToShards(keyspace)
 	if err != nil {
 		return nil, err
@@ -164,6 +168,9 @@ func (res *Resolver) ExecuteEntityIds(
 	ctx context.Context,
 	query *proto.EntityIdsQuery,
 ) (*mproto.QueryResult, error) {
+	if err := checkQueryRules(ctx, query.Sql, planbuilder.NoPlan, query.Keyspace, "", query.BindVariables); err != nil {
+		return nil, err
+	}
 	newKeyspace, shardIDMap, err := mapEntityIdsToShards(
 		ctx,
 		res.scatterConn.toposerv,
@@ -249,6 +256,11 @@ func (res *Resolver) ExecuteBatch(
 	mapToShards func(string) (string, []string, error),
 	notInTransaction bool,
 ) (*tproto.QueryResultList, error) {
+	for _, query := range queries {
+		if err := checkQueryRules(ctx, query.Sql, planbuilder.NoPlan, keyspace, "", query.BindVariables); err != nil {
+			return nil, err
+		}
+	}
 	keyspace, shards, err := mapToShards(keyspace)
 	if err != nil {
 		return nil, err
@@ -343,6 +355,9 @@ func (res *Resolver) StreamExecute(
 	sendReply func(*mproto.QueryResult) error,
 	notInTransaction bool,
 ) error {
+	if err := checkQueryRules(ctx, sql, planbuilder.NoPlan, keyspace, "", bindVars); err != nil {
+		return err
+	}
 	keyspace, shards, err := mapToShards(keyspace)
 	if err != nil {
 		return err
@@ -8,6 +8,9 @@ package vtgate
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/vt/key"
@@ -49,13 +52,19 @@ func newScatterParams(query, ks string, bv map[string]interface{}, shards []stri
 }
 
 // NewRouter creates a new Router.
+// If statsName is not empty, the pages of the planner are
+// exported under /debug.
 func NewRouter(serv SrvTopoServer, cell string, schema *planbuilder.Schema, statsName string, scatterConn *ScatterConn) *Router {
-	return &Router{
+	rtr := &Router{
 		serv:        serv,
 		cell:        cell,
 		planner:     NewPlanner(schema, 5000),
 		scatterConn: scatterConn,
 	}
+	if statsName != "" {
+		rtr.planner.HandleHTTP()
+	}
+	return rtr
 }
 
 // Execute routes a non-streaming query.
@@ -64,17 +73,73 @@ func (rtr *Router) Execute(ctx context.Context, query *proto.Query) (*mproto.Que
 		query.BindVariables = make(map[string]interface{})
 	}
 	vcursor := newRequestContext(ctx, query, rtr)
+	if sql, ok := explainTarget(query.Sql); ok {
+		return rtr.explain(vcursor, sql)
+	}
+	startTime := time.Now()
 	plan := rtr.planner.GetPlan(string(query.Sql))
+	logStatsFromContext(ctx).recordPlan(plan.ID.String(), startTime)
+	if err := rtr.checkQueryRules(ctx, query, plan); err != nil {
+		return nil, err
+	}
 
 	switch plan.ID {
 	case planbuilder.UpdateEqual:
 		return rtr.execUpdateEqual(vcursor, plan)
 	case planbuilder.DeleteEqual:
 		return rtr.execDeleteEqual(vcursor, plan)
+	case planbuilder.DeleteIN, planbuilder.DeleteScatter:
+		return rtr.execDeleteMulti(vcursor, plan)
 	case planbuilder.InsertSharded:
 		return rtr.execInsertSharded(vcursor, plan)
+	case planbuilder.SelectJoin:
+		return rtr.execSelectJoin(vcursor, plan)
+	}
+	return rtr.execRoute(vcursor, plan)
+}
+
+// checkQueryRules checks the query rules for a V3 query.
+func (rtr *Router) checkQueryRules(ctx context.Context, query *proto.Query, plan *planbuilder.Plan) error {
+	var keyspace, tableName string
+	if plan.Table != nil {
+		keyspace = plan.Table.Keyspace.Name
+		tableName = plan.Table.Name
+	}
+	return checkQueryRules(ctx, query.Sql, plan.ID, keyspace, tableName, query.BindVariables)
+}
+
+// execRoute executes a plan that sends a single query
+// to one or more shards.
+func (rtr *Router) execRoute(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	params, err := rtr.routeParams(vcursor, plan)
+	if err != nil {
+		return nil, err
+	}
+	offset, rowcount, err := rtr.resolveLimit(vcursor, plan, params)
+	if err != nil {
+		return nil, err
+	}
+	qr, err := rtr.scatterConn.ExecuteMulti(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := rtr.postProcess(qr, plan, offset, rowcount); err != nil {
+		return nil, err
 	}
+	return qr, nil
+}
 
+// routeParams computes the target shards and their bind vars
+// for a plan that sends a single query to one or more shards.
+func (rtr *Router) routeParams(vcursor *requestContext, plan *planbuilder.Plan) (*scatterParams, error) {
 	var err error
 	var params *scatterParams
 	switch plan.ID {
@@ -83,27 +148,19 @@ func (rtr *Router) Execute(ctx context.Context, query *proto.Query) (*mproto.Que
 		params, err = rtr.paramsUnsharded(vcursor, plan)
 	case planbuilder.SelectEqual:
 		params, err = rtr.paramsSelectEqual(vcursor, plan)
-	case planbuilder.SelectIN:
+	case planbuilder.SelectIN, planbuilder.UpdateIN, planbuilder.DeleteIN:
 		params, err = rtr.paramsSelectIN(vcursor, plan)
 	case planbuilder.SelectKeyrange:
 		params, err = rtr.paramsSelectKeyrange(vcursor, plan)
-	case planbuilder.SelectScatter:
+	case planbuilder.SelectScatter, planbuilder.UpdateScatter, planbuilder.DeleteScatter:
 		params, err = rtr.paramsSelectScatter(vcursor, plan)
 	default:
-		return nil, fmt.Errorf("cannot route query: %s: %s", query.Sql, plan.Reason)
+		return nil, fmt.Errorf("cannot route query: %s: %s", vcursor.query.Sql, plan.Reason)
 	}
 	if err != nil {
 		return nil, err
 	}
-	return rtr.scatterConn.ExecuteMulti(
-		ctx,
-		params.query,
-		params.ks,
-		params.shardVars,
-		query.TabletType,
-		NewSafeSession(vcursor.query.Session),
-		query.NotInTransaction,
-	)
+	return params, nil
 }
 
 // StreamExecute executes a streaming query.
@@ -112,8 +169,28 @@ func (rtr *Router) StreamExecute(ctx context.Context, query *proto.Query, sendRe
 		query.BindVariables = make(map[string]interface{})
 	}
 	vcursor := newRequestContext(ctx, query, rtr)
+	if sql, ok := explainTarget(query.Sql); ok {
+		qr, err := rtr.explain(vcursor, sql)
+		if err != nil {
+			return err
+		}
+		return sendReply(qr)
+	}
+	startTime := time.Now()
 	plan := rtr.planner.GetPlan(string(query.Sql))
+	logStatsFromContext(ctx).recordPlan(plan.ID.String(), startTime)
+	if err := rtr.checkQueryRules(ctx, query, plan); err != nil {
+		return err
+	}
+	if plan.ID == planbuilder.SelectJoin {
+		return rtr.streamSelectJoin(vcursor, plan, sendReply)
+	}
+	return rtr.streamRoute(vcursor, plan, sendReply)
+}
 
+// streamRoute streams the results of a plan that sends
+// a single query to one or more shards.
+func (rtr *Router) streamRoute(vcursor *requestContext, plan *planbuilder.Plan, sendReply func(*mproto.QueryResult) error) error {
 	var err error
 	var params *scatterParams
 	switch plan.ID {
@@ -128,20 +205,65 @@ func (rtr *Router) StreamExecute(ctx context.Context, query *proto.Query, sendRe
 	case planbuilder.SelectScatter:
 		params, err = rtr.paramsSelectScatter(vcursor, plan)
 	default:
-		return fmt.Errorf("query %q cannot be used for streaming", query.Sql)
+		return fmt.Errorf("query %q cannot be used for streaming", vcursor.query.Sql)
+	}
+	if err != nil {
+		return err
 	}
+	offset, rowcount, err := rtr.resolveLimit(vcursor, plan, params)
 	if err != nil {
 		return err
 	}
+	if plan.IsAggregate() {
+		// Aggregates can only be computed after all the results
+		// have been received.
+		qr := &mproto.QueryResult{}
+		err := rtr.scatterConn.StreamExecuteMulti(
+			vcursor.ctx,
+			params.query,
+			params.ks,
+			params.shardVars,
+			vcursor.query.TabletType,
+			NewSafeSession(vcursor.query.Session),
+			func(innerqr *mproto.QueryResult) error {
+				if qr.Fields == nil {
+					qr.Fields = innerqr.Fields
+				}
+				qr.Rows = append(qr.Rows, innerqr.Rows...)
+				return nil
+			},
+			vcursor.query.NotInTransaction,
+		)
+		if err != nil {
+			return err
+		}
+		if err := rtr.postProcess(qr, plan, offset, rowcount); err != nil {
+			return err
+		}
+		return sendReply(qr)
+	}
+	sendReply = newLimitReply(offset, rowcount, sendReply)
+	if len(plan.OrderBy) != 0 {
+		return rtr.scatterConn.StreamExecuteMultiMerge(
+			vcursor.ctx,
+			params.query,
+			params.ks,
+			params.shardVars,
+			vcursor.query.TabletType,
+			NewSafeSession(vcursor.query.Session),
+			newMergeFunc(plan.OrderBy, sendReply),
+			vcursor.query.NotInTransaction,
+		)
+	}
 	return rtr.scatterConn.StreamExecuteMulti(
-		ctx,
+		vcursor.ctx,
 		params.query,
 		params.ks,
 		params.shardVars,
-		query.TabletType,
+		vcursor.query.TabletType,
 		NewSafeSession(vcursor.query.Session),
 		sendReply,
-		query.NotInTransaction,
+		vcursor.query.NotInTransaction,
 	)
 }
 
@@ -231,6 +353,84 @@ func (rtr *Router) paramsSelectScatter(vcursor *requestContext, plan *planbuilde
 	return newScatterParams(plan.Rewritten, ks, vcursor.query.BindVariables, shards), nil
 }
 
+// postProcess combines the aggregates, and applies the ORDER BY
+// and LIMIT of a plan to the result of a multi-shard SELECT.
+func (rtr *Router) postProcess(qr *mproto.QueryResult, plan *planbuilder.Plan, offset, rowcount int64) error {
+	if plan.IsAggregate() {
+		if err := aggregateResult(qr, plan); err != nil {
+			return err
+		}
+	}
+	if err := sortResult(qr, plan.OrderBy); err != nil {
+		return err
+	}
+	limitResult(qr, offset, rowcount)
+	return nil
+}
+
+// resolveLimit returns the offset and rowcount that VTGate must apply
+// to the merged results of a multi-shard SELECT. A negative rowcount
+// means that there is no limit. If the plan has an offset, the bind var
+// of the rewritten LIMIT clause is added to the shard bind vars.
+func (rtr *Router) resolveLimit(vcursor *requestContext, plan *planbuilder.Plan, params *scatterParams) (offset, rowcount int64, err error) {
+	if plan.Limit == nil {
+		return 0, -1, nil
+	}
+	if plan.Limit.Offset != nil {
+		offset, err = resolveLimitValue(plan.Limit.Offset, vcursor.query.BindVariables)
+		if err != nil {
+			return 0, 0, fmt.Errorf("resolveLimit: %v", err)
+		}
+	}
+	rowcount, err = resolveLimitValue(plan.Limit.Rowcount, vcursor.query.BindVariables)
+	if err != nil {
+		return 0, 0, fmt.Errorf("resolveLimit: %v", err)
+	}
+	// Aggregate plans don't push the LIMIT down to the shards.
+	if plan.Limit.Offset != nil && !plan.IsAggregate() {
+		for shard, bv := range params.shardVars {
+			newbv := make(map[string]interface{}, len(bv)+1)
+			for k, v := range bv {
+				newbv[k] = v
+			}
+			newbv[planbuilder.LimitVarName] = offset + rowcount
+			params.shardVars[shard] = newbv
+		}
+	}
+	return offset, rowcount, nil
+}
+
+// resolveLimitValue converts a LIMIT value, which can be an
+// int64 or a bind var name, to an int64.
+func resolveLimitValue(val interface{}, bindVars map[string]interface{}) (int64, error) {
+	if name, ok := val.(string); ok {
+		v, ok := bindVars[name[1:]]
+		if !ok {
+			return 0, fmt.Errorf("could not find bind var %s", name)
+		}
+		val = v
+	}
+	var n int64
+	switch v := val.(type) {
+	case int:
+		n = int64(v)
+	case int32:
+		n = int64(v)
+	case int64:
+		n = v
+	case uint32:
+		n = int64(v)
+	case uint64:
+		n = int64(v)
+	default:
+		return 0, fmt.Errorf("unexpected type %T for limit value %v", val, val)
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("negative limit value: %d", n)
+	}
+	return n, nil
+}
+
 func (rtr *Router) execUpdateEqual(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
 	keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
 	if err != nil {
@@ -243,6 +443,9 @@ func (rtr *Router) execUpdateEqual(vcursor *requestContext, plan *planbuilder.Pl
 	if ksid == key.MinKey {
 		return &mproto.QueryResult{}, nil
 	}
+	if plan.SetValues != nil {
+		return rtr.execUpdateMove(vcursor, plan, ks, shard, ksid)
+	}
 	vcursor.query.BindVariables[ksidName] = string(ksid)
 	rewritten := plan.Rewritten + fmt.Sprintf(dmlPostfix, ksid)
 	return rtr.scatterConn.Execute(
@@ -256,6 +459,155 @@ func (rtr *Router) execUpdateEqual(vcursor *requestContext, plan *planbuilder.Pl
 		vcursor.query.NotInTransaction)
 }
 
+// execUpdateMove executes an UpdateEqual plan that changes vindex
+// columns. The rows are read and deleted from their shard along with
+// their vindex entries. They're then inserted again with the new values,
+// which may place them on a different shard. Since this requires multiple
+// statements, it's only allowed inside a transaction.
+func (rtr *Router) execUpdateMove(vcursor *requestContext, plan *planbuilder.Plan, ks, shard string, ksid key.KeyspaceId) (*mproto.QueryResult, error) {
+	session := vcursor.query.Session
+	if session == nil || !session.InTransaction || vcursor.query.NotInTransaction {
+		return nil, fmt.Errorf("execUpdateMove: changing a vindex column requires a transaction")
+	}
+	result, err := rtr.scatterConn.Execute(
+		vcursor.ctx,
+		plan.Subquery,
+		vcursor.query.BindVariables,
+		ks,
+		[]string{shard},
+		vcursor.query.TabletType,
+		NewSafeSession(session),
+		vcursor.query.NotInTransaction)
+	if err != nil {
+		return nil, fmt.Errorf("execUpdateMove: %v", err)
+	}
+	if len(result.Rows) == 0 {
+		return &mproto.QueryResult{}, nil
+	}
+	cols := make(map[string]int, len(result.Fields))
+	for i, field := range result.Fields {
+		cols[field.Name] = i
+	}
+	setValues := make(map[string]interface{}, len(plan.SetValues))
+	for col, val := range plan.SetValues {
+		if _, ok := cols[col]; !ok {
+			return nil, fmt.Errorf("execUpdateMove: column %s not found", col)
+		}
+		keys, err := rtr.resolveKeys([]interface{}{val}, vcursor.query.BindVariables)
+		if err != nil {
+			return nil, fmt.Errorf("execUpdateMove: %v", err)
+		}
+		setValues[col] = keys[0]
+	}
+	rows := make([][]interface{}, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		values := make([]interface{}, len(row))
+		for i, v := range row {
+			values[i], err = mproto.Convert(result.Fields[i], v)
+			if err != nil {
+				return nil, fmt.Errorf("execUpdateMove: %v", err)
+			}
+		}
+		rows = append(rows, values)
+	}
+
+	for _, colVindex := range plan.Table.Owned {
+		pos, ok := cols[colVindex.Col]
+		if !ok {
+			return nil, fmt.Errorf("execUpdateMove: column %s not found", colVindex.Col)
+		}
+		keys := make(map[interface{}]bool)
+		var ids []interface{}
+		for _, row := range rows {
+			k := row[pos]
+			if b, ok := k.([]byte); ok {
+				k = string(b)
+			}
+			if !keys[k] {
+				keys[k] = true
+				ids = append(ids, k)
+			}
+		}
+		switch vindex := colVindex.Vindex.(type) {
+		case planbuilder.Functional:
+			err = vindex.Delete(vcursor, ids, ksid)
+		case planbuilder.Lookup:
+			err = vindex.Delete(vcursor, ids, ksid)
+		default:
+			panic("unexpected")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("execUpdateMove: %v", err)
+		}
+	}
+	bv := make(map[string]interface{}, len(vcursor.query.BindVariables)+1)
+	for k, v := range vcursor.query.BindVariables {
+		bv[k] = v
+	}
+	bv[ksidName] = string(ksid)
+	qr, err := rtr.scatterConn.Execute(
+		vcursor.ctx,
+		plan.DeleteQuery+fmt.Sprintf(dmlPostfix, ksid),
+		bv,
+		ks,
+		[]string{shard},
+		vcursor.query.TabletType,
+		NewSafeSession(session),
+		vcursor.query.NotInTransaction)
+	if err != nil {
+		return nil, fmt.Errorf("execUpdateMove: %v", err)
+	}
+
+	names := make([]string, 0, len(result.Fields))
+	vars := make([]string, 0, len(result.Fields))
+	for _, field := range result.Fields {
+		names = append(names, field.Name)
+		vars = append(vars, ":_"+field.Name)
+	}
+	insert := fmt.Sprintf("insert into %s(%s) values (%s)", plan.Table.Name, strings.Join(names, ", "), strings.Join(vars, ", "))
+	colVindexes := plan.Table.ColVindexes
+	for _, row := range rows {
+		bv := make(map[string]interface{}, len(vcursor.query.BindVariables)+len(row)+1)
+		for k, v := range vcursor.query.BindVariables {
+			bv[k] = v
+		}
+		for i, field := range result.Fields {
+			if v, ok := setValues[field.Name]; ok {
+				bv["_"+field.Name] = v
+			} else {
+				bv["_"+field.Name] = row[i]
+			}
+		}
+		newKsid, _, err := rtr.handlePrimary(vcursor, bv["_"+colVindexes[0].Col], colVindexes[0], bv, "_"+colVindexes[0].Col)
+		if err != nil {
+			return nil, fmt.Errorf("execUpdateMove: %v", err)
+		}
+		for _, colVindex := range colVindexes[1:] {
+			if _, err := rtr.handleNonPrimary(vcursor, bv["_"+colVindex.Col], colVindex, bv, "_"+colVindex.Col, newKsid); err != nil {
+				return nil, fmt.Errorf("execUpdateMove: %v", err)
+			}
+		}
+		newKs, newShard, err := rtr.getRouting(vcursor.ctx, plan.Table.Keyspace.Name, vcursor.query.TabletType, newKsid)
+		if err != nil {
+			return nil, fmt.Errorf("execUpdateMove: %v", err)
+		}
+		bv[ksidName] = string(newKsid)
+		_, err = rtr.scatterConn.Execute(
+			vcursor.ctx,
+			insert+fmt.Sprintf(dmlPostfix, newKsid),
+			bv,
+			newKs,
+			[]string{newShard},
+			vcursor.query.TabletType,
+			NewSafeSession(session),
+			vcursor.query.NotInTransaction)
+		if err != nil {
+			return nil, fmt.Errorf("execUpdateMove: %v", err)
+		}
+	}
+	return &mproto.QueryResult{RowsAffected: qr.RowsAffected}, nil
+}
+
 func (rtr *Router) execDeleteEqual(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
 	keys, err := rtr.resolveKeys([]interface{}{plan.Values}, vcursor.query.BindVariables)
 	if err != nil {
@@ -287,13 +639,121 @@ func (rtr *Router) execDeleteEqual(vcursor *requestContext, plan *planbuilder.Pl
 		vcursor.query.NotInTransaction)
 }
 
+// execDeleteMulti executes a DeleteIN or DeleteScatter plan. The
+// entries of the owned vindexes are deleted before the rows,
+// using the values returned by the Subquery on each shard.
+func (rtr *Router) execDeleteMulti(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	params, err := rtr.routeParams(vcursor, plan)
+	if err != nil {
+		return nil, fmt.Errorf("execDeleteMulti: %v", err)
+	}
+	if len(params.shardVars) == 0 {
+		return &mproto.QueryResult{}, nil
+	}
+	if plan.Subquery != "" {
+		err = rtr.deleteMultiVindexEntries(vcursor, plan, params)
+		if err != nil {
+			return nil, fmt.Errorf("execDeleteMulti: %v", err)
+		}
+	}
+	return rtr.scatterConn.ExecuteMulti(
+		vcursor.ctx,
+		params.query,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction)
+}
+
 func (rtr *Router) execInsertSharded(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	insertid, err := rtr.handleGenerate(vcursor, plan.Generate)
+	if err != nil {
+		return nil, fmt.Errorf("execInsertSharded: %v", err)
+	}
+	var result *mproto.QueryResult
+	if plan.Mid != nil {
+		result, err = rtr.execInsertMulti(vcursor, plan)
+	} else {
+		result, err = rtr.execInsertSingle(vcursor, plan)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if insertid != 0 {
+		if result.InsertId != 0 {
+			return nil, fmt.Errorf("sequence and db generated a value each for insert")
+		}
+		result.InsertId = uint64(insertid)
+	}
+	return result, nil
+}
+
+// handleGenerate fetches the values of an auto-increment column from
+// its sequence, for the rows that don't supply one. The sequence
+// is asked for all the values at once. The value of every row is
+// then set in the bind vars. The first generated value is returned.
+func (rtr *Router) handleGenerate(vcursor *requestContext, gen *planbuilder.GenerateParams) (insertid int64, err error) {
+	if gen == nil {
+		return 0, nil
+	}
+	vals, err := rtr.resolveKeys(gen.Values, vcursor.query.BindVariables)
+	if err != nil {
+		return 0, err
+	}
+	count := int64(0)
+	for _, val := range vals {
+		if val == nil {
+			count++
+		}
+	}
+	if count != 0 {
+		// Sequences are always served by the master.
+		ks, _, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, gen.Keyspace, topo.TYPE_MASTER)
+		if err != nil {
+			return 0, err
+		}
+		if len(allShards) != 1 {
+			return 0, fmt.Errorf("unsharded keyspace %s has multiple shards", ks)
+		}
+		result, err := rtr.scatterConn.Execute(
+			vcursor.ctx,
+			gen.Query,
+			map[string]interface{}{"n": count},
+			ks,
+			[]string{allShards[0].Name},
+			topo.TYPE_MASTER,
+			NewSafeSession(nil),
+			false)
+		if err != nil {
+			return 0, err
+		}
+		if len(result.Rows) != 1 || len(result.Rows[0]) != 1 {
+			return 0, fmt.Errorf("unexpected result from sequence: %+v", result.Rows)
+		}
+		insertid, err = result.Rows[0][0].ParseInt64()
+		if err != nil {
+			return 0, err
+		}
+	}
+	next := insertid
+	for i, val := range vals {
+		if val == nil {
+			val = next
+			next++
+		}
+		vcursor.query.BindVariables[planbuilder.GenerateVarName(i)] = val
+	}
+	return insertid, nil
+}
+
+func (rtr *Router) execInsertSingle(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
 	input := plan.Values.([]interface{})
 	keys, err := rtr.resolveKeys(input, vcursor.query.BindVariables)
 	if err != nil {
 		return nil, fmt.Errorf("execInsertSharded: %v", err)
 	}
-	ksid, generated, err := rtr.handlePrimary(vcursor, keys[0], plan.Table.ColVindexes[0], vcursor.query.BindVariables)
+	ksid, generated, err := rtr.handlePrimary(vcursor, keys[0], plan.Table.ColVindexes[0], vcursor.query.BindVariables, "_"+plan.Table.ColVindexes[0].Col)
 	if err != nil {
 		return nil, fmt.Errorf("execInsertSharded: %v", err)
 	}
@@ -302,7 +762,7 @@ func (rtr *Router) execInsertSharded(vcursor *requestContext, plan *planbuilder.
 		return nil, fmt.Errorf("execInsertSharded: %v", err)
 	}
 	for i := 1; i < len(keys); i++ {
-		newgen, err := rtr.handleNonPrimary(vcursor, keys[i], plan.Table.ColVindexes[i], vcursor.query.BindVariables, ksid)
+		newgen, err := rtr.handleNonPrimary(vcursor, keys[i], plan.Table.ColVindexes[i], vcursor.query.BindVariables, "_"+plan.Table.ColVindexes[i].Col, ksid)
 		if err != nil {
 			return nil, err
 		}
@@ -336,6 +796,143 @@ func (rtr *Router) execInsertSharded(vcursor *requestContext, plan *planbuilder.
 	return result, nil
 }
 
+// execInsertMulti executes a multi-row InsertSharded plan. The vindex
+// values and keyspace id of every row are computed first, and the
+// entries of owned lookup vindexes are created in bulk if the vindex
+// supports it. The rows are then grouped by shard, and one insert is
+// sent to each shard.
+func (rtr *Router) execInsertMulti(vcursor *requestContext, plan *planbuilder.Plan) (*mproto.QueryResult, error) {
+	rows := plan.Values.([]interface{})
+	colVindexes := plan.Table.ColVindexes
+	keys := make([][]interface{}, len(rows))
+	ksids := make([]key.KeyspaceId, len(rows))
+	generated := make([]int64, len(rows))
+	rowVars := make([]map[string]interface{}, len(rows))
+	var err error
+	for i, row := range rows {
+		keys[i], err = rtr.resolveKeys(row.([]interface{}), vcursor.query.BindVariables)
+		if err != nil {
+			return nil, fmt.Errorf("execInsertMulti: %v", err)
+		}
+		rowVars[i] = make(map[string]interface{}, len(colVindexes))
+		ksids[i], generated[i], err = rtr.handlePrimary(vcursor, keys[i][0], colVindexes[0], rowVars[i], planbuilder.InsertVarName(colVindexes[0].Col, i))
+		if err != nil {
+			return nil, fmt.Errorf("execInsertMulti: %v", err)
+		}
+	}
+	for c := 1; c < len(colVindexes); c++ {
+		colVindex := colVindexes[c]
+		batched, err := rtr.batchCreate(vcursor, colVindex, c, keys, ksids, rowVars)
+		if err != nil {
+			return nil, fmt.Errorf("execInsertMulti: %v", err)
+		}
+		for i := range rows {
+			if batched[i] {
+				continue
+			}
+			newgen, err := rtr.handleNonPrimary(vcursor, keys[i][c], colVindex, rowVars[i], planbuilder.InsertVarName(colVindex.Col, i), ksids[i])
+			if err != nil {
+				return nil, err
+			}
+			if newgen != 0 {
+				if generated[i] != 0 {
+					return nil, fmt.Errorf("insert generated more than one value")
+				}
+				generated[i] = newgen
+			}
+		}
+	}
+
+	ks, _, allShards, err := getKeyspaceShards(vcursor.ctx, rtr.serv, rtr.cell, plan.Table.Keyspace.Name, vcursor.query.TabletType)
+	if err != nil {
+		return nil, fmt.Errorf("execInsertMulti: %v", err)
+	}
+	var shards []string
+	shardRows := make(map[string][]int)
+	for i, ksid := range ksids {
+		shard, err := getShardForKeyspaceId(allShards, ksid)
+		if err != nil {
+			return nil, fmt.Errorf("execInsertMulti: %v", err)
+		}
+		if _, ok := shardRows[shard]; !ok {
+			shards = append(shards, shard)
+		}
+		shardRows[shard] = append(shardRows[shard], i)
+	}
+	sqls := make(map[string]string, len(shards))
+	bindVars := make(map[string]map[string]interface{}, len(shards))
+	for _, shard := range shards {
+		mids := make([]string, 0, len(shardRows[shard]))
+		shardKsids := make([]string, 0, len(shardRows[shard]))
+		bv := make(map[string]interface{}, len(vcursor.query.BindVariables)+len(shardRows[shard])*len(colVindexes))
+		for k, v := range vcursor.query.BindVariables {
+			bv[k] = v
+		}
+		for _, i := range shardRows[shard] {
+			mids = append(mids, plan.Mid[i])
+			shardKsids = append(shardKsids, ksids[i].String())
+			for k, v := range rowVars[i] {
+				bv[k] = v
+			}
+		}
+		sqls[shard] = plan.Prefix + strings.Join(mids, ", ") + plan.Suffix + fmt.Sprintf(dmlPostfix, strings.Join(shardKsids, ","))
+		bindVars[shard] = bv
+	}
+	result, err := rtr.scatterConn.ExecuteEntityIds(
+		vcursor.ctx,
+		shards,
+		sqls,
+		bindVars,
+		ks,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction)
+	if err != nil {
+		return nil, fmt.Errorf("execInsertMulti: %v", err)
+	}
+	// Like MySQL, the insert id is the first generated value.
+	for _, gen := range generated {
+		if gen == 0 {
+			continue
+		}
+		if result.InsertId != 0 {
+			return nil, fmt.Errorf("vindex and db generated a value each for insert")
+		}
+		result.InsertId = uint64(gen)
+		break
+	}
+	return result, nil
+}
+
+// batchCreate creates the entries of an owned BatchLookup vindex for all
+// the rows that supply a value, and returns which rows were handled.
+// The other rows are left to handleNonPrimary.
+func (rtr *Router) batchCreate(vcursor *requestContext, colVindex *planbuilder.ColVindex, c int, keys [][]interface{}, ksids []key.KeyspaceId, rowVars []map[string]interface{}) (batched []bool, err error) {
+	batched = make([]bool, len(keys))
+	batch, ok := colVindex.Vindex.(planbuilder.BatchLookup)
+	if !ok || !colVindex.Owned {
+		return batched, nil
+	}
+	var ids []interface{}
+	var idKsids []key.KeyspaceId
+	for i := range keys {
+		if keys[i][c] == nil {
+			continue
+		}
+		ids = append(ids, keys[i][c])
+		idKsids = append(idKsids, ksids[i])
+		rowVars[i][planbuilder.InsertVarName(colVindex.Col, i)] = keys[i][c]
+		batched[i] = true
+	}
+	if len(ids) == 0 {
+		return batched, nil
+	}
+	if err := batch.BatchCreate(vcursor, ids, idKsids); err != nil {
+		return nil, err
+	}
+	return batched, nil
+}
+
 func (rtr *Router) resolveKeys(vals []interface{}, bindVars map[string]interface{}) (keys []interface{}, err error) {
 	keys = make([]interface{}, 0, len(vals))
 	for _, val := range vals {
@@ -468,7 +1065,87 @@ func (rtr *Router) deleteVindexEntries(vcursor *requestContext, plan *planbuilde
 	return nil
 }
 
-func (rtr *Router) handlePrimary(vcursor *requestContext, vindexKey interface{}, colVindex *planbuilder.ColVindex, bv map[string]interface{}) (ksid key.KeyspaceId, generated int64, err error) {
+// deleteMultiVindexEntries runs the Subquery of a multi-shard delete
+// on the target shards, and deletes the owned vindex entries of the
+// returned rows. The last column of the Subquery is the primary
+// vindex value, which is used to compute the keyspace id of each row.
+func (rtr *Router) deleteMultiVindexEntries(vcursor *requestContext, plan *planbuilder.Plan, params *scatterParams) error {
+	result, err := rtr.scatterConn.ExecuteMulti(
+		vcursor.ctx,
+		plan.Subquery,
+		params.ks,
+		params.shardVars,
+		vcursor.query.TabletType,
+		NewSafeSession(vcursor.query.Session),
+		vcursor.query.NotInTransaction)
+	if err != nil {
+		return err
+	}
+	if len(result.Rows) == 0 {
+		return nil
+	}
+	primary := len(plan.Table.Owned)
+	pkeys := make([]interface{}, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		k, err := mproto.Convert(result.Fields[primary], row[primary])
+		if err != nil {
+			return err
+		}
+		pkeys = append(pkeys, k)
+	}
+	rowKsids, err := plan.Table.ColVindexes[0].Vindex.(planbuilder.Unique).Map(vcursor, pkeys)
+	if err != nil {
+		return err
+	}
+	for i, colVindex := range plan.Table.Owned {
+		// The ids are grouped by keyspace id because
+		// the vindexes delete entries one keyspace id at a time.
+		var ksids []key.KeyspaceId
+		ksidKeys := make(map[key.KeyspaceId][]interface{})
+		seen := make(map[key.KeyspaceId]map[interface{}]bool)
+		for r, row := range result.Rows {
+			ksid := rowKsids[r]
+			if ksid == key.MinKey {
+				return fmt.Errorf("could not map %v to a keyspace id", pkeys[r])
+			}
+			k, err := mproto.Convert(result.Fields[i], row[i])
+			if err != nil {
+				return err
+			}
+			if b, ok := k.([]byte); ok {
+				k = string(b)
+			}
+			if seen[ksid] == nil {
+				ksids = append(ksids, ksid)
+				seen[ksid] = make(map[interface{}]bool)
+			}
+			if seen[ksid][k] {
+				continue
+			}
+			seen[ksid][k] = true
+			ksidKeys[ksid] = append(ksidKeys[ksid], k)
+		}
+		// Sort for a deterministic order of deletes.
+		sort.Sort(key.KeyspaceIdArray(ksids))
+		for _, ksid := range ksids {
+			switch vindex := colVindex.Vindex.(type) {
+			case planbuilder.Functional:
+				if err = vindex.Delete(vcursor, ksidKeys[ksid], ksid); err != nil {
+					return err
+				}
+			case planbuilder.Lookup:
+				if err = vindex.Delete(vcursor, ksidKeys[ksid], ksid); err != nil {
+					return err
+				}
+			default:
+				panic("unexpected")
+			}
+		}
+	}
+	return nil
+}
+
+func (rtr *Router) handlePrimary(vcursor *requestContext, vindexKey interface{}, colVindex *planbuilder.ColVindex, bv map[string]interface{}, bvName string) (ksid key.KeyspaceId, generated int64, err error) {
 	if colVindex.Owned {
 		if vindexKey == nil {
 			generator, ok := colVindex.Vindex.(planbuilder.FunctionalGenerator)
@@ -499,11 +1176,11 @@ func (rtr *Router) handlePrimary(vcursor *requestContext, vindexKey interface{},
 	if ksid == key.MinKey {
 		return "", 0, fmt.Errorf("could not map %v to a keyspace id", vindexKey)
 	}
-	bv["_"+colVindex.Col] = vindexKey
+	bv[bvName] = vindexKey
 	return ksid, generated, nil
 }
 
-func (rtr *Router) handleNonPrimary(vcursor *requestContext, vindexKey interface{}, colVindex *planbuilder.ColVindex, bv map[string]interface{}, ksid key.KeyspaceId) (generated int64, err error) {
+func (rtr *Router) handleNonPrimary(vcursor *requestContext, vindexKey interface{}, colVindex *planbuilder.ColVindex, bv map[string]interface{}, bvName string, ksid key.KeyspaceId) (generated int64, err error) {
 	if colVindex.Owned {
 		if vindexKey == nil {
 			generator, ok := colVindex.Vindex.(planbuilder.LookupGenerator)
@@ -544,7 +1221,7 @@ func (rtr *Router) handleNonPrimary(vcursor *requestContext, vindexKey interface
 			}
 		}
 	}
-	bv["_"+colVindex.Col] = vindexKey
+	bv[bvName] = vindexKey
 	return generated, nil
 }
 
@@ -8,11 +8,15 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/sqltypes"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/topo"
+	"github.com/youtube/vitess/go/vt/vtgate/proto"
 	_ "github.com/youtube/vitess/go/vt/vtgate/vindexes"
+	"golang.org/x/net/context"
 )
 
 func TestUpdateEqual(t *testing.T) {
@@ -115,6 +119,89 @@ func TestUpdateEqualFail(t *testing.T) {
 	s.ShardSpec = DefaultShardSpec
 }
 
+func TestUpdateEqualChangeVindex(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3, mproto.VT_ZEROVALUE_FLAG},
+			{"name", 253, mproto.VT_ZEROVALUE_FLAG},
+			{"v", 3, mproto.VT_ZEROVALUE_FLAG},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname")},
+			{sqltypes.Numeric("5")},
+		}},
+	}})
+	_, err := router.Execute(context.Background(), &proto.Query{
+		Sql:           "update user set id = 3, name = 'newname' where id = 1",
+		BindVariables: map[string]interface{}{},
+		TabletType:    topo.TYPE_MASTER,
+		Session:       &proto.Session{InTransaction: true},
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select * from user where id = 1 for update",
+		BindVariables: map[string]interface{}{},
+	}, {
+		Sql: "delete from user where id = 1 /* _routing keyspace_id:166b40b44aba4bd6 */",
+		BindVariables: map[string]interface{}{
+			"keyspace_id": "\x16k@\xb4J\xbaK\xd6",
+		},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "insert into user(id, name, v) values (:_id, :_name, :_v) /* _routing keyspace_id:4eb190c9a2fa169c */",
+		BindVariables: map[string]interface{}{
+			"keyspace_id": "N\xb1\x90ɢ\xfa\x16\x9c",
+			"_id":         int64(3),
+			"_name":       "newname",
+			"_v":          int64(5),
+		},
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "delete from user_idx where id in ::id",
+		BindVariables: map[string]interface{}{
+			"id": []interface{}{int64(1)},
+		},
+	}, {
+		Sql: "delete from name_user_map where name in ::name and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"user_id": int64(1),
+			"name":    []interface{}{"myname"},
+		},
+	}, {
+		Sql: "insert into user_idx(id) values(:id)",
+		BindVariables: map[string]interface{}{
+			"id": int64(3),
+		},
+	}, {
+		Sql: "insert into name_user_map(name, user_id) values(:name, :user_id)",
+		BindVariables: map[string]interface{}{
+			"name":    "newname",
+			"user_id": int64(3),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+
+	_, err = routerExec(router, "update user set id = 3 where id = 1", nil)
+	want := "execUpdateMove: changing a vindex column requires a transaction"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+}
+
 func TestDeleteEqual(t *testing.T) {
 	router, sbc, _, sbclookup := createRouterEnv()
 
@@ -288,6 +375,235 @@ func TestDeleteVindexFail(t *testing.T) {
 	}
 }
 
+func TestUpdateIN(t *testing.T) {
+	router, sbc1, sbc2, _ := createRouterEnv()
+
+	_, err := routerExec(router, "update user set a = 2 where id in (1, 3)", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "update user set a = 2 where id in ::_vals",
+		BindVariables: map[string]interface{}{
+			"_vals": []interface{}{int64(1)},
+		},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "update user set a = 2 where id in ::_vals",
+		BindVariables: map[string]interface{}{
+			"_vals": []interface{}{int64(3)},
+		},
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+}
+
+func TestUpdateScatter(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for _, shard := range shards {
+		sbc := &sandboxConn{}
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	result, err := routerExec(router, "update user set a = 2 where b = 1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "update user set a = 2 where b = 1",
+		BindVariables: map[string]interface{}{},
+	}}
+	for _, conn := range conns {
+		if !reflect.DeepEqual(conn.Queries, wantQueries) {
+			t.Errorf("conn.Queries = %#v, want %#v", conn.Queries, wantQueries)
+		}
+	}
+	if result.RowsAffected != 8 {
+		t.Errorf("result.RowsAffected: %d, want 8", result.RowsAffected)
+	}
+}
+
+func TestDeleteIN(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+
+	fields := []mproto.Field{
+		{"id", 3, mproto.VT_ZEROVALUE_FLAG},
+		{"name", 253, mproto.VT_ZEROVALUE_FLAG},
+		{"id", 3, mproto.VT_ZEROVALUE_FLAG},
+	}
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields:       fields,
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname")},
+			{sqltypes.Numeric("1")},
+		}},
+	}})
+	sbc2.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields:       fields,
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("3")},
+			{sqltypes.String("myname2")},
+			{sqltypes.Numeric("3")},
+		}},
+	}})
+	_, err := routerExec(router, "delete from user where id in (1, 3)", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select id, name, id from user where id in ::_vals for update",
+		BindVariables: map[string]interface{}{
+			"_vals": []interface{}{int64(1)},
+		},
+	}, {
+		Sql: "delete from user where id in ::_vals",
+		BindVariables: map[string]interface{}{
+			"_vals": []interface{}{int64(1)},
+		},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "select id, name, id from user where id in ::_vals for update",
+		BindVariables: map[string]interface{}{
+			"_vals": []interface{}{int64(3)},
+		},
+	}, {
+		Sql: "delete from user where id in ::_vals",
+		BindVariables: map[string]interface{}{
+			"_vals": []interface{}{int64(3)},
+		},
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "delete from user_idx where id in ::id",
+		BindVariables: map[string]interface{}{
+			"id": []interface{}{int64(1)},
+		},
+	}, {
+		Sql: "delete from user_idx where id in ::id",
+		BindVariables: map[string]interface{}{
+			"id": []interface{}{int64(3)},
+		},
+	}, {
+		Sql: "delete from name_user_map where name in ::name and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"user_id": int64(1),
+			"name":    []interface{}{"myname"},
+		},
+	}, {
+		Sql: "delete from name_user_map where name in ::name and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"user_id": int64(3),
+			"name":    []interface{}{"myname2"},
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+
+	sbc1.Queries = nil
+	sbc2.Queries = nil
+	sbclookup.Queries = nil
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+	_, err = routerExec(router, "delete from user where id in (1)", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if sbc2.Queries != nil {
+		t.Errorf("sbc2.Queries: %+v, want nil\n", sbc2.Queries)
+	}
+	if sbclookup.Queries != nil {
+		t.Errorf("sbclookup.Queries: %+v, want nil\n", sbclookup.Queries)
+	}
+}
+
+func TestDeleteScatter(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for _, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	l := createSandbox(KsTestUnsharded)
+	sbclookup := &sandboxConn{}
+	l.MapTestConn("0", sbclookup)
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	conns[0].setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"id", 3, mproto.VT_ZEROVALUE_FLAG},
+			{"name", 253, mproto.VT_ZEROVALUE_FLAG},
+			{"id", 3, mproto.VT_ZEROVALUE_FLAG},
+		},
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname")},
+			{sqltypes.Numeric("1")},
+		}, {
+			{sqltypes.Numeric("1")},
+			{sqltypes.String("myname")},
+			{sqltypes.Numeric("1")},
+		}},
+	}})
+	_, err := routerExec(router, "delete from user where b = 1", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select id, name, id from user where b = 1 for update",
+		BindVariables: map[string]interface{}{},
+	}, {
+		Sql:           "delete from user where b = 1",
+		BindVariables: map[string]interface{}{},
+	}}
+	for _, conn := range conns {
+		if !reflect.DeepEqual(conn.Queries, wantQueries) {
+			t.Errorf("conn.Queries = %#v, want %#v", conn.Queries, wantQueries)
+		}
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "delete from user_idx where id in ::id",
+		BindVariables: map[string]interface{}{
+			"id": []interface{}{int64(1)},
+		},
+	}, {
+		Sql: "delete from name_user_map where name in ::name and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"user_id": int64(1),
+			"name":    []interface{}{"myname"},
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+
+}
+
 func TestInsertSharded(t *testing.T) {
 	router, sbc1, sbc2, sbclookup := createRouterEnv()
 
@@ -362,6 +678,237 @@ func TestInsertSharded(t *testing.T) {
 	}
 }
 
+func TestInsertShardedMulti(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+
+	_, err := routerExec(router, "insert into user(id, v, name) values (1, 2, 'myname'), (3, 4, 'myname2'), (2, 5, 'myname3')", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "insert into user(id, v, name) values (:_id_0, 2, :_name_0), (:_id_2, 5, :_name_2) /* _routing keyspace_id:166b40b44aba4bd6,06e7ea22ce92708f */",
+		BindVariables: map[string]interface{}{
+			"_id_0":   int64(1),
+			"_name_0": "myname",
+			"_id_2":   int64(2),
+			"_name_2": "myname3",
+		},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "insert into user(id, v, name) values (:_id_1, 4, :_name_1) /* _routing keyspace_id:4eb190c9a2fa169c */",
+		BindVariables: map[string]interface{}{
+			"_id_1":   int64(3),
+			"_name_1": "myname2",
+		},
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "insert into user_idx(id) values(:id)",
+		BindVariables: map[string]interface{}{
+			"id": int64(1),
+		},
+	}, {
+		Sql: "insert into user_idx(id) values(:id)",
+		BindVariables: map[string]interface{}{
+			"id": int64(3),
+		},
+	}, {
+		Sql: "insert into user_idx(id) values(:id)",
+		BindVariables: map[string]interface{}{
+			"id": int64(2),
+		},
+	}, {
+		Sql: "insert into name_user_map(name, user_id) values (:from_0, :to_0), (:from_1, :to_1), (:from_2, :to_2)",
+		BindVariables: map[string]interface{}{
+			"from_0": "myname",
+			"to_0":   int64(1),
+			"from_1": "myname2",
+			"to_1":   int64(3),
+			"from_2": "myname3",
+			"to_2":   int64(2),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+}
+
+func TestInsertShardedMultiGenerator(t *testing.T) {
+	router, sbc, _, sbclookup := createRouterEnv()
+
+	sbclookup.setResults([]*mproto.QueryResult{
+		&mproto.QueryResult{RowsAffected: 1, InsertId: 1},
+		&mproto.QueryResult{RowsAffected: 1, InsertId: 2},
+	})
+	result, err := routerExec(router, "insert into user(v, name) values (2, 'myname'), (3, 'myname2')", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "insert into user(v, name, id) values (2, :_name_0, :_id_0), (3, :_name_1, :_id_1) /* _routing keyspace_id:166b40b44aba4bd6,06e7ea22ce92708f */",
+		BindVariables: map[string]interface{}{
+			"_id_0":   int64(1),
+			"_name_0": "myname",
+			"_id_1":   int64(2),
+			"_name_1": "myname2",
+		},
+	}}
+	if !reflect.DeepEqual(sbc.Queries, wantQueries) {
+		t.Errorf("sbc.Queries: %+v, want %+v\n", sbc.Queries, wantQueries)
+	}
+	if result.InsertId != 1 {
+		t.Errorf("result.InsertId: %d, want 1", result.InsertId)
+	}
+
+	_, err = routerExec(router, "insert into user(id, v, name) values (1, 2, 'myname'), (2, 3)", nil)
+	want := "cannot route query: insert into user(id, v, name) values (1, 2, 'myname'), (2, 3): column list doesn't match values"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %s", err, want)
+	}
+}
+
+func TestInsertSequence(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+
+	sbclookup.setResults([]*mproto.QueryResult{{
+		Fields: []mproto.Field{{"nextval", 8, mproto.VT_ZEROVALUE_FLAG}},
+		Rows:   [][]sqltypes.Value{{sqltypes.MakeNumeric([]byte("3"))}},
+	}})
+	result, err := routerExec(router, "insert into autoinc_table(v) values (2)", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select nextval(:n) from autoinc_seq",
+		BindVariables: map[string]interface{}{
+			"n": int64(1),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "insert into autoinc_table(v, id) values (2, :_id) /* _routing keyspace_id:4eb190c9a2fa169c */",
+		BindVariables: map[string]interface{}{
+			"keyspace_id": "N\xb1\x90ɢ\xfa\x16\x9c",
+			"__seq0":      int64(3),
+			"_id":         int64(3),
+		},
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+	if sbc1.Queries != nil {
+		t.Errorf("sbc1.Queries: %+v, want nil\n", sbc1.Queries)
+	}
+	if result.InsertId != 3 {
+		t.Errorf("result.InsertId: %d, want 3", result.InsertId)
+	}
+
+	// A supplied value doesn't use the sequence.
+	sbclookup.Queries = nil
+	sbc2.Queries = nil
+	result, err = routerExec(router, "insert into autoinc_table(id, v) values (1, 2)", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	if sbclookup.Queries != nil {
+		t.Errorf("sbclookup.Queries: %+v, want nil\n", sbclookup.Queries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "insert into autoinc_table(id, v) values (:_id, 2) /* _routing keyspace_id:166b40b44aba4bd6 */",
+		BindVariables: map[string]interface{}{
+			"keyspace_id": "\x16k@\xb4J\xbaK\xd6",
+			"__seq0":      int64(1),
+			"_id":         int64(1),
+		},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	if result.InsertId != 0 {
+		t.Errorf("result.InsertId: %d, want 0", result.InsertId)
+	}
+}
+
+func TestInsertSequenceMulti(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+
+	sbclookup.setResults([]*mproto.QueryResult{{
+		Fields: []mproto.Field{{"nextval", 8, mproto.VT_ZEROVALUE_FLAG}},
+		Rows:   [][]sqltypes.Value{{sqltypes.MakeNumeric([]byte("1"))}},
+	}})
+	result, err := routerExec(router, "insert into autoinc_table(id, v) values (null, 2), (3, 3), (null, 4)", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select nextval(:n) from autoinc_seq",
+		BindVariables: map[string]interface{}{
+			"n": int64(2),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	seqVars := map[string]interface{}{
+		"__seq0": int64(1),
+		"__seq1": int64(3),
+		"__seq2": int64(2),
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "insert into autoinc_table(id, v) values (:_id_0, 2), (:_id_2, 4) /* _routing keyspace_id:166b40b44aba4bd6,06e7ea22ce92708f */",
+		BindVariables: map[string]interface{}{
+			"_id_0": int64(1),
+			"_id_2": int64(2),
+		},
+	}}
+	for k, v := range seqVars {
+		wantQueries[0].BindVariables[k] = v
+	}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "insert into autoinc_table(id, v) values (:_id_1, 3) /* _routing keyspace_id:4eb190c9a2fa169c */",
+		BindVariables: map[string]interface{}{
+			"_id_1": int64(3),
+		},
+	}}
+	for k, v := range seqVars {
+		wantQueries[0].BindVariables[k] = v
+	}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+	if result.InsertId != 1 {
+		t.Errorf("result.InsertId: %d, want 1", result.InsertId)
+	}
+}
+
+func TestInsertSequenceFail(t *testing.T) {
+	router, _, _, sbclookup := createRouterEnv()
+
+	sbclookup.mustFailServer = 1
+	_, err := routerExec(router, "insert into autoinc_table(v) values (2)", nil)
+	want := "execInsertSharded: "
+	if err == nil || !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("routerExec: %v, want prefix %v", err, want)
+	}
+
+	sbclookup.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+	_, err = routerExec(router, "insert into autoinc_table(v) values (2)", nil)
+	want = "execInsertSharded: unexpected result from sequence: []"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+}
+
 func TestInsertGenerator(t *testing.T) {
 	router, sbc, _, sbclookup := createRouterEnv()
 
@@ -655,3 +1202,132 @@ func TestInsertFail(t *testing.T) {
 		t.Errorf("routerExec: %v, want prefix %v", err, want)
 	}
 }
+
+func TestConsistentLookupSessions(t *testing.T) {
+	router, sbc, _, sbclookup := createRouterEnv()
+
+	session := &proto.Session{InTransaction: true}
+	_, err := router.Execute(context.Background(), &proto.Query{
+		Sql:           "insert into cuser(id, name) values (1, 'a')",
+		BindVariables: map[string]interface{}{},
+		TabletType:    topo.TYPE_MASTER,
+		Session:       session,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "insert into cname_user_map(name, user_id) values(:name, :user_id)",
+		BindVariables: map[string]interface{}{
+			"name":    "a",
+			"user_id": int64(1),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	wantSession := &proto.Session{
+		InTransaction: true,
+		ShardSessions: []*proto.ShardSession{{
+			Keyspace:      "TestRouter",
+			Shard:         "-20",
+			TabletType:    topo.TYPE_MASTER,
+			TransactionId: 1,
+		}},
+		PreSessions: []*proto.ShardSession{{
+			Keyspace:      KsTestUnsharded,
+			Shard:         "0",
+			TabletType:    topo.TYPE_MASTER,
+			TransactionId: 1,
+		}},
+	}
+	if !reflect.DeepEqual(session, wantSession) {
+		t.Errorf("session: %+v, want %+v", session, wantSession)
+	}
+
+	sbclookup.Queries = nil
+	sbc.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{
+			{"name", 253, mproto.VT_ZEROVALUE_FLAG},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			{sqltypes.String("a")},
+		}},
+	}})
+	session = &proto.Session{InTransaction: true}
+	_, err = router.Execute(context.Background(), &proto.Query{
+		Sql:           "delete from cuser where id = 1",
+		BindVariables: map[string]interface{}{},
+		TabletType:    topo.TYPE_MASTER,
+		Session:       session,
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "delete from cname_user_map where name in ::name and user_id = :user_id",
+		BindVariables: map[string]interface{}{
+			"name":    []interface{}{"a"},
+			"user_id": int64(1),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	wantSession = &proto.Session{
+		InTransaction: true,
+		ShardSessions: []*proto.ShardSession{{
+			Keyspace:      "TestRouter",
+			Shard:         "-20",
+			TabletType:    topo.TYPE_MASTER,
+			TransactionId: 2,
+		}},
+		PostSessions: []*proto.ShardSession{{
+			Keyspace:      KsTestUnsharded,
+			Shard:         "0",
+			TabletType:    topo.TYPE_MASTER,
+			TransactionId: 2,
+		}},
+	}
+	if !reflect.DeepEqual(session, wantSession) {
+		t.Errorf("session: %+v, want %+v", session, wantSession)
+	}
+
+	// Outside of a transaction, the lookup queries
+	// are executed in the main session.
+	sbclookup.Queries = nil
+	_, err = routerExec(router, "insert into cuser(id, name) values (1, 'a')", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "insert into cname_user_map(name, user_id) values(:name, :user_id)",
+		BindVariables: map[string]interface{}{
+			"name":    "a",
+			"user_id": int64(1),
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	// In single mode, the lookup transactions are rejected.
+	sbclookup.Queries = nil
+	session = &proto.Session{InTransaction: true, TransactionMode: proto.TransactionModeSingle}
+	_, err = router.Execute(context.Background(), &proto.Query{
+		Sql:           "insert into cuser(id, name) values (1, 'a')",
+		BindVariables: map[string]interface{}{},
+		TabletType:    topo.TYPE_MASTER,
+		Session:       session,
+	})
+	want := "vindex transactions not allowed in single mode: insert into cname_user_map(name, user_id) values(:name, :user_id)"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("router.Execute: %v, want %s", err, want)
+	}
+	if sbclookup.Queries != nil {
+		t.Errorf("sbclookup.Queries: %+v, want none\n", sbclookup.Queries)
+	}
+	if session.PreSessions != nil {
+		t.Errorf("session.PreSessions: %+v, want none", session.PreSessions)
+	}
+}
@@ -70,6 +70,15 @@ var routerSchema = createTestSchema(`
           "Type": "hash",
           "Owner": "noauto_table"
         },
+        "cname_user_map": {
+          "Type": "consistent_lookup_unique",
+          "Owner": "cuser",
+          "Params": {
+            "Table": "cname_user_map",
+            "From": "name",
+            "To": "user_id"
+          }
+        },
         "keyspace_id": {
           "Type": "numeric"
         }
@@ -158,6 +167,30 @@ var routerSchema = createTestSchema(`
               "Name": "keyspace_id"
             }
           ]
+        },
+        "autoinc_table": {
+          "ColVindexes": [
+            {
+              "Col": "id",
+              "Name": "idx_noauto"
+            }
+          ],
+          "Autoinc": {
+            "Col": "id",
+            "Sequence": "autoinc_seq"
+          }
+        },
+        "cuser": {
+          "ColVindexes": [
+            {
+              "Col": "id",
+              "Name": "idx_noauto"
+            },
+            {
+              "Col": "name",
+              "Name": "cname_user_map"
+            }
+          ]
         }
       },
       "Tables": {
@@ -168,7 +201,9 @@ var routerSchema = createTestSchema(`
         "music_extra_reversed": "music_extra_reversed",
         "multi_autoinc_table": "multi_autoinc_table",
         "noauto_table": "noauto_table",
-        "ksid_table": "ksid_table"
+        "ksid_table": "ksid_table",
+        "autoinc_table": "autoinc_table",
+        "cuser": "cuser"
       }
     },
     "TestBadSharding": {
@@ -184,7 +219,9 @@ var routerSchema = createTestSchema(`
         "music_user_map": "",
         "name_user_map": "",
         "idx1": "",
-        "idx2": ""
+        "idx2": "",
+        "autoinc_seq": "",
+        "cname_user_map": ""
       }
     }
   }
@@ -5,6 +5,7 @@
 package vtgate
 
 import (
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
@@ -218,6 +219,55 @@ func TestSelectEqualNotFound(t *testing.T) {
 	}
 }
 
+func TestSelectEqualConsistentLookup(t *testing.T) {
+	router, sbc1, _, sbclookup := createRouterEnv()
+
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{
+		Fields: []mproto.Field{{"name", mproto.VT_VAR_STRING, 0}},
+		Rows:   [][]sqltypes.Value{{sqltypes.MakeString([]byte("foo"))}},
+	}})
+	_, err := routerExec(router, "select * from cuser where name = 'foo'", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select user_id from cname_user_map where name = :name",
+		BindVariables: map[string]interface{}{
+			"name": "foo",
+		},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql: "select name from cuser where name in ::name",
+		BindVariables: map[string]interface{}{
+			"name": []interface{}{"foo"},
+		},
+	}, {
+		Sql:           "select * from cuser where name = 'foo'",
+		BindVariables: map[string]interface{}{},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+
+	// The lookup entry is stale because the owner row doesn't exist.
+	sbc1.Queries = nil
+	sbc1.setResults([]*mproto.QueryResult{&mproto.QueryResult{}})
+	result, err := routerExec(router, "select * from cuser where name = 'foo'", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantResult := &mproto.QueryResult{}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+	if len(sbc1.Queries) != 1 {
+		t.Errorf("sbc1.Queries: %+v, want only the owner query\n", sbc1.Queries)
+	}
+}
+
 func TestStreamSelectEqual(t *testing.T) {
 	router, _, _, _ := createRouterEnv()
 
@@ -615,3 +665,525 @@ func TestSelectScatterFail(t *testing.T) {
 		t.Errorf("routerExec: %v, want %v", err, want)
 	}
 }
+
+func TestSelectScatterOrderBy(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for i, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{{
+			Fields: []mproto.Field{
+				{Name: "id", Type: mproto.VT_LONG},
+				{Name: "col", Type: mproto.VT_LONG},
+			},
+			RowsAffected: 2,
+			Rows: [][]sqltypes.Value{{
+				sqltypes.MakeNumeric([]byte("1")),
+				sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", i))),
+			}, {
+				sqltypes.MakeNumeric([]byte("1")),
+				sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", 10+i))),
+			}},
+		}})
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	result, err := routerExec(router, "select id, col from user order by col desc limit 3, 4", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql: "select id, col from user order by col desc limit :_limit",
+		BindVariables: map[string]interface{}{
+			"_limit": int64(7),
+		},
+	}}
+	for _, conn := range conns {
+		if !reflect.DeepEqual(conn.Queries, wantQueries) {
+			t.Errorf("conn.Queries = %#v, want %#v", conn.Queries, wantQueries)
+		}
+	}
+	var got []string
+	for _, row := range result.Rows {
+		got = append(got, row[1].String())
+	}
+	want := []string{"14", "13", "12", "11"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("result: %v, want %v", got, want)
+	}
+	if result.RowsAffected != 4 {
+		t.Errorf("RowsAffected: %d, want 4", result.RowsAffected)
+	}
+}
+
+func TestStreamSelectScatterOrderBy(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	for i, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{{
+			Fields: []mproto.Field{
+				{Name: "id", Type: mproto.VT_LONG},
+				{Name: "col", Type: mproto.VT_VAR_STRING, Flags: mproto.VT_BINARY_FLAG},
+			},
+			RowsAffected: 2,
+			Rows: [][]sqltypes.Value{{
+				sqltypes.MakeNumeric([]byte("1")),
+				sqltypes.MakeString([]byte(fmt.Sprintf("a%d", i))),
+			}, {
+				sqltypes.MakeNumeric([]byte("1")),
+				sqltypes.MakeString([]byte(fmt.Sprintf("b%d", i))),
+			}},
+		}})
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "select id, col from user order by col limit 6, 4",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := routerStream(router, &q)
+	if err != nil {
+		t.Error(err)
+	}
+	var got []string
+	for _, row := range result.Rows {
+		got = append(got, row[1].String())
+	}
+	want := []string{"a6", "a7", "b0", "b1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("result: %v, want %v", got, want)
+	}
+	if len(result.Fields) != 2 {
+		t.Errorf("result.Fields: %v, want 2 fields", result.Fields)
+	}
+}
+
+func TestSelectScatterOrderByFail(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	for _, shard := range shards {
+		s.MapTestConn(shard, &sandboxConn{})
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	_, err := routerExec(router, "select * from user order by col", nil)
+	want := "order by column col not found in result"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+
+	_, err = routerExec(router, "select * from user limit :a, 1", nil)
+	want = "resolveLimit: could not find bind var :a"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+}
+
+func TestSelectScatterOrderByTypes(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	var conns []*sandboxConn
+	for i, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{{
+			Fields: []mproto.Field{
+				{Name: "id", Type: mproto.VT_NEWDECIMAL},
+			},
+			RowsAffected: 1,
+			Rows: [][]sqltypes.Value{{
+				// The values are too close for a float64.
+				sqltypes.MakeFractional([]byte(fmt.Sprintf("12345678901234567890.%d", i))),
+			}},
+		}})
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	result, err := routerExec(router, "select id from user order by id desc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, row := range result.Rows {
+		got = append(got, row[0].String())
+	}
+	want := []string{
+		"12345678901234567890.7",
+		"12345678901234567890.6",
+		"12345678901234567890.5",
+		"12345678901234567890.4",
+		"12345678901234567890.3",
+		"12345678901234567890.2",
+		"12345678901234567890.1",
+		"12345678901234567890.0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("result: %v, want %v", got, want)
+	}
+
+	// Text of a non-binary collation can't be sorted by vtgate.
+	for _, sbc := range conns {
+		sbc.setResults([]*mproto.QueryResult{{
+			Fields: []mproto.Field{
+				{Name: "name", Type: mproto.VT_VAR_STRING},
+			},
+			RowsAffected: 1,
+			Rows: [][]sqltypes.Value{{
+				sqltypes.MakeString([]byte("a")),
+			}},
+		}})
+	}
+	_, err = routerExec(router, "select name from user order by name", nil)
+	wantErr := "cannot compare column name in vtgate: its collation is not binary"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("routerExec: %v, want %v", err, wantErr)
+	}
+}
+
+func TestSelectScatterAggregate(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	shardResult := func(i int, nameFlags int64) *mproto.QueryResult {
+		return &mproto.QueryResult{
+			Fields: []mproto.Field{
+				{Name: "name", Type: mproto.VT_VAR_STRING, Flags: nameFlags},
+				{Name: "count(*)", Type: mproto.VT_LONGLONG},
+				{Name: "sum(a)", Type: mproto.VT_NEWDECIMAL},
+				{Name: "max(a)", Type: mproto.VT_LONG},
+				{Name: "count(a)", Type: mproto.VT_LONGLONG},
+			},
+			RowsAffected: 2,
+			Rows: [][]sqltypes.Value{{
+				sqltypes.MakeString([]byte("b")),
+				sqltypes.MakeNumeric([]byte("2")),
+				sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", i))),
+				sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", i))),
+				sqltypes.MakeNumeric([]byte("2")),
+			}, {
+				sqltypes.MakeString([]byte("a")),
+				sqltypes.MakeNumeric([]byte("1")),
+				sqltypes.MakeNumeric([]byte("1")),
+				sqltypes.MakeNumeric([]byte("1")),
+				sqltypes.MakeNumeric([]byte("1")),
+			}},
+		}
+	}
+	var conns []*sandboxConn
+	for i, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{
+			shardResult(i, mproto.VT_BINARY_FLAG),
+			shardResult(i, mproto.VT_BINARY_FLAG),
+			shardResult(i, 0),
+		})
+		conns = append(conns, sbc)
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	result, err := routerExec(router, "select name, count(*), avg(a), max(a) from user group by name limit 5", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select name, count(*), sum(a), max(a), count(a) from user group by name",
+		BindVariables: map[string]interface{}{},
+	}}
+	for _, conn := range conns {
+		if !reflect.DeepEqual(conn.Queries, wantQueries) {
+			t.Errorf("conn.Queries = %#v, want %#v", conn.Queries, wantQueries)
+		}
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{Name: "name", Type: mproto.VT_VAR_STRING, Flags: mproto.VT_BINARY_FLAG},
+			{Name: "count(*)", Type: mproto.VT_LONGLONG},
+			{Name: "avg(a)", Type: mproto.VT_NEWDECIMAL},
+			{Name: "max(a)", Type: mproto.VT_LONG},
+		},
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeString([]byte("a")),
+			sqltypes.MakeNumeric([]byte("8")),
+			sqltypes.MakeFractional([]byte("1.0000")),
+			sqltypes.MakeNumeric([]byte("1")),
+		}, {
+			sqltypes.MakeString([]byte("b")),
+			sqltypes.MakeNumeric([]byte("16")),
+			sqltypes.MakeFractional([]byte("1.7500")),
+			sqltypes.MakeNumeric([]byte("7")),
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+
+	// The groups can be sorted by an aggregate.
+	result, err = routerExec(router, "select name, count(*), avg(a), max(a) from user group by name order by count(*) desc", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	wantResult.Rows[0], wantResult.Rows[1] = wantResult.Rows[1], wantResult.Rows[0]
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+
+	// Text of a non-binary collation can't be grouped by vtgate.
+	_, err = routerExec(router, "select name, count(*), avg(a), max(a) from user group by name", nil)
+	want := "cannot compare column name in vtgate: its collation is not binary"
+	if err == nil || err.Error() != want {
+		t.Errorf("routerExec: %v, want %v", err, want)
+	}
+}
+
+func TestStreamSelectScatterAggregate(t *testing.T) {
+	// Special setup: Don't use createRouterEnv.
+	s := createSandbox("TestRouter")
+	shards := []string{"-20", "20-40", "40-60", "60-80", "80-a0", "a0-c0", "c0-e0", "e0-"}
+	for i, shard := range shards {
+		sbc := &sandboxConn{}
+		sbc.setResults([]*mproto.QueryResult{{
+			Fields: []mproto.Field{
+				{Name: "c", Type: mproto.VT_LONGLONG},
+				{Name: "min(a)", Type: mproto.VT_LONG},
+			},
+			RowsAffected: 1,
+			Rows: [][]sqltypes.Value{{
+				sqltypes.MakeNumeric([]byte("3")),
+				sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", 10-i))),
+			}},
+		}})
+		s.MapTestConn(shard, sbc)
+	}
+	serv := new(sandboxTopo)
+	scatterConn := NewScatterConn(serv, "", "aa", 1*time.Second, 10, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	router := NewRouter(serv, "aa", routerSchema, "", scatterConn)
+
+	q := proto.Query{
+		Sql:        "select count(*) as c, min(a) from user",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := routerStream(router, &q)
+	if err != nil {
+		t.Error(err)
+	}
+	wantRows := [][]sqltypes.Value{{
+		sqltypes.MakeNumeric([]byte("24")),
+		sqltypes.MakeNumeric([]byte("3")),
+	}}
+	if !reflect.DeepEqual(result.Rows, wantRows) {
+		t.Errorf("result.Rows: %+v, want %+v", result.Rows, wantRows)
+	}
+}
+
+var joinLeftResult = &mproto.QueryResult{
+	Fields: []mproto.Field{
+		{Name: "id", Type: mproto.VT_LONGLONG},
+		{Name: "user_id", Type: mproto.VT_LONGLONG},
+	},
+	RowsAffected: 2,
+	Rows: [][]sqltypes.Value{{
+		sqltypes.MakeNumeric([]byte("1")),
+		sqltypes.MakeNumeric([]byte("1")),
+	}, {
+		sqltypes.MakeNumeric([]byte("2")),
+		sqltypes.MakeNumeric([]byte("3")),
+	}},
+}
+
+func joinRightResult(name string) *mproto.QueryResult {
+	return &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{Name: "name", Type: mproto.VT_VAR_STRING},
+		},
+		RowsAffected: 1,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeString([]byte(name)),
+		}},
+	}
+}
+
+func TestSelectJoin(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+	sbclookup.setResults([]*mproto.QueryResult{joinLeftResult})
+	sbc1.setResults([]*mproto.QueryResult{joinRightResult("a")})
+	sbc2.setResults([]*mproto.QueryResult{joinRightResult("b")})
+
+	result, err := routerExec(router, "select m.id, u.name from music_user_map as m join user as u on m.user_id = u.id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select m.id, m.user_id from music_user_map as m",
+		BindVariables: map[string]interface{}{},
+	}}
+	if !reflect.DeepEqual(sbclookup.Queries, wantQueries) {
+		t.Errorf("sbclookup.Queries: %+v, want %+v\n", sbclookup.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql:           "select u.name from user as u where u.id = :m_user_id",
+		BindVariables: map[string]interface{}{"m_user_id": int64(1)},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	wantQueries = []tproto.BoundQuery{{
+		Sql:           "select u.name from user as u where u.id = :m_user_id",
+		BindVariables: map[string]interface{}{"m_user_id": int64(3)},
+	}}
+	if !reflect.DeepEqual(sbc2.Queries, wantQueries) {
+		t.Errorf("sbc2.Queries: %+v, want %+v\n", sbc2.Queries, wantQueries)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{Name: "id", Type: mproto.VT_LONGLONG},
+			{Name: "name", Type: mproto.VT_VAR_STRING},
+		},
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeNumeric([]byte("1")),
+			sqltypes.MakeString([]byte("a")),
+		}, {
+			sqltypes.MakeNumeric([]byte("2")),
+			sqltypes.MakeString([]byte("b")),
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestSelectLeftJoin(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+	sbclookup.setResults([]*mproto.QueryResult{{
+		Fields:       joinLeftResult.Fields,
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeNumeric([]byte("1")),
+			sqltypes.MakeNumeric([]byte("1")),
+		}, {
+			sqltypes.MakeNumeric([]byte("2")),
+			{},
+		}},
+	}})
+	sbc1.setResults([]*mproto.QueryResult{{
+		Fields: joinRightResult("").Fields,
+	}})
+
+	result, err := routerExec(router, "select m.id, u.name from music_user_map as m left join user as u on m.user_id = u.id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The row with a NULL user_id must not be sent to any shard.
+	if len(sbc1.Queries) != 1 {
+		t.Errorf("sbc1.Queries: %+v, want 1 query", sbc1.Queries)
+	}
+	if sbc2.Queries != nil {
+		t.Errorf("sbc2.Queries: %+v, want nil", sbc2.Queries)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{Name: "id", Type: mproto.VT_LONGLONG},
+			{Name: "name", Type: mproto.VT_VAR_STRING},
+		},
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeNumeric([]byte("1")),
+			{},
+		}, {
+			sqltypes.MakeNumeric([]byte("2")),
+			{},
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestSelectJoinNoRows(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+	sbclookup.setResults([]*mproto.QueryResult{{
+		Fields: joinLeftResult.Fields,
+	}})
+	sbc1.setResults([]*mproto.QueryResult{{
+		Fields: joinRightResult("").Fields,
+	}})
+
+	result, err := routerExec(router, "select m.id, u.name from music_user_map as m join user as u on m.user_id = u.id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantQueries := []tproto.BoundQuery{{
+		Sql:           "select u.name from user as u where 1 != 1",
+		BindVariables: map[string]interface{}{},
+	}}
+	if !reflect.DeepEqual(sbc1.Queries, wantQueries) {
+		t.Errorf("sbc1.Queries: %+v, want %+v\n", sbc1.Queries, wantQueries)
+	}
+	if sbc2.Queries != nil {
+		t.Errorf("sbc2.Queries: %+v, want nil", sbc2.Queries)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{Name: "id", Type: mproto.VT_LONGLONG},
+			{Name: "name", Type: mproto.VT_VAR_STRING},
+		},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
+
+func TestStreamSelectJoin(t *testing.T) {
+	router, sbc1, sbc2, sbclookup := createRouterEnv()
+	sbclookup.setResults([]*mproto.QueryResult{joinLeftResult})
+	sbc1.setResults([]*mproto.QueryResult{joinRightResult("a")})
+	sbc2.setResults([]*mproto.QueryResult{joinRightResult("b")})
+
+	q := proto.Query{
+		Sql:        "select m.id, u.name from music_user_map as m join user as u on m.user_id = u.id",
+		TabletType: topo.TYPE_MASTER,
+	}
+	result, err := routerStream(router, &q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult := &mproto.QueryResult{
+		Fields: []mproto.Field{
+			{Name: "id", Type: mproto.VT_LONGLONG},
+			{Name: "name", Type: mproto.VT_VAR_STRING},
+		},
+		RowsAffected: 2,
+		Rows: [][]sqltypes.Value{{
+			sqltypes.MakeNumeric([]byte("1")),
+			sqltypes.MakeString([]byte("a")),
+		}, {
+			sqltypes.MakeNumeric([]byte("2")),
+			sqltypes.MakeString([]byte("b")),
+		}},
+	}
+	if !reflect.DeepEqual(result, wantResult) {
+		t.Errorf("result: %+v, want %+v", result, wantResult)
+	}
+}
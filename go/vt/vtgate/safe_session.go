@@ -5,8 +5,10 @@
 package vtgate
 
 import (
+	"fmt"
 	"sync"
 
+	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
 	"github.com/youtube/vitess/go/vt/topo"
 	"github.com/youtube/vitess/go/vt/vtgate/proto"
 )
@@ -43,15 +45,133 @@ func (session *SafeSession) Find(keyspace, shard string, tabletType topo.TabletT
 	return 0
 }
 
-func (session *SafeSession) Append(shardSession *proto.ShardSession) {
+// Append adds shardSession to the session. It fails if the
+// session is in single mode and already has a ShardSession.
+func (session *SafeSession) Append(shardSession *proto.ShardSession) error {
 	session.mu.Lock()
 	defer session.mu.Unlock()
+	if err := session.checkAppendLocked(shardSession.Keyspace, shardSession.Shard); err != nil {
+		return err
+	}
 	session.ShardSessions = append(session.ShardSessions, shardSession)
+	return nil
+}
+
+// checkAppend returns the error Append would fail with
+// if a ShardSession was added for keyspace and shard.
+func (session *SafeSession) checkAppend(keyspace, shard string) error {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.checkAppendLocked(keyspace, shard)
+}
+
+func (session *SafeSession) checkAppendLocked(keyspace, shard string) error {
+	if session.transactionModeLocked() != proto.TransactionModeSingle || len(session.ShardSessions) == 0 {
+		return nil
+	}
+	existing := session.ShardSessions[0]
+	return fmt.Errorf("multi-shard transaction not allowed in single mode: %s/%s is already in the transaction, cannot add %s/%s", existing.Keyspace, existing.Shard, keyspace, shard)
+}
+
+// transactionMode returns the transaction mode of the session,
+// or the default one if it doesn't specify any.
+func (session *SafeSession) transactionMode() string {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.transactionModeLocked()
 }
 
+// checkTransactionMode returns an error if mode is not one of the
+// transaction modes, or if it is twopc and the tablet protocol doesn't
+// support it. An empty mode stands for the default one.
+func checkTransactionMode(mode string) error {
+	switch mode {
+	case "", proto.TransactionModeSingle, proto.TransactionModeMulti:
+		return nil
+	case proto.TransactionModeTwoPC:
+		return tabletconn.CheckTwoPC()
+	}
+	return fmt.Errorf("unknown transaction mode: %q, want single, multi or twopc", mode)
+}
+
+func (session *SafeSession) transactionModeLocked() string {
+	if session.Session.TransactionMode == "" {
+		return *transactionMode
+	}
+	return session.Session.TransactionMode
+}
+
+// readAfterWrite returns true if the session is a read-after-write one.
+func (session *SafeSession) readAfterWrite() bool {
+	if session == nil || session.Session == nil {
+		return false
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.Session.ReadAfterWrite
+}
+
+// writePositions returns the write positions of a read-after-write
+// session, keyed by keyspace/shard.
+func (session *SafeSession) writePositions() map[string]string {
+	if session == nil || session.Session == nil {
+		return nil
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.Session.ReadAfterWrite || len(session.WritePositions) == 0 {
+		return nil
+	}
+	positions := make(map[string]string, len(session.WritePositions))
+	for _, wp := range session.WritePositions {
+		positions[wp.Keyspace+"/"+wp.Shard] = wp.Position
+	}
+	return positions
+}
+
+// setWritePosition records the position of the master of
+// keyspace and shard after a commit.
+func (session *SafeSession) setWritePosition(keyspace, shard, position string) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	for _, wp := range session.WritePositions {
+		if wp.Keyspace == keyspace && wp.Shard == shard {
+			wp.Position = position
+			return
+		}
+	}
+	session.WritePositions = append(session.WritePositions, &proto.WritePosition{
+		Keyspace: keyspace,
+		Shard:    shard,
+		Position: position,
+	})
+}
+
+// Reset ends the transaction. The transaction mode and the
+// read-after-write state are preserved.
 func (session *SafeSession) Reset() {
 	session.mu.Lock()
 	defer session.mu.Unlock()
 	session.Session.InTransaction = false
 	session.ShardSessions = nil
+	session.PreSessions = nil
+	session.PostSessions = nil
+}
+
+// commitOrder returns the shard sessions in the order in which
+// they must be committed: PreSessions, ShardSessions, PostSessions.
+func (session *SafeSession) commitOrder() []*proto.ShardSession {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	shardSessions := make([]*proto.ShardSession, 0, len(session.PreSessions)+len(session.ShardSessions)+len(session.PostSessions))
+	shardSessions = append(shardSessions, session.PreSessions...)
+	shardSessions = append(shardSessions, session.ShardSessions...)
+	return append(shardSessions, session.PostSessions...)
+}
+
+// commitPhases returns the PreSessions, ShardSessions and PostSessions.
+func (session *SafeSession) commitPhases() (preSessions, shardSessions, postSessions []*proto.ShardSession) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.PreSessions, session.ShardSessions, session.PostSessions
 }
@@ -331,6 +331,26 @@ type sandboxConn struct {
 	RollbackCount sync2.AtomicInt64
 	CloseCount    sync2.AtomicInt64
 
+	// These Count vars report how often the two-phase
+	// commit functions were called.
+	PrepareCount             sync2.AtomicInt64
+	CommitPreparedCount      sync2.AtomicInt64
+	RollbackPreparedCount    sync2.AtomicInt64
+	CreateTransactionCount   sync2.AtomicInt64
+	StartCommitCount         sync2.AtomicInt64
+	SetRollbackCount         sync2.AtomicInt64
+	ConcludeTransactionCount sync2.AtomicInt64
+	ReadTransactionCount     sync2.AtomicInt64
+
+	// These Count vars report how often the read-after-write
+	// functions were called.
+	MasterPositionCount  sync2.AtomicInt64
+	WaitForPositionCount sync2.AtomicInt64
+
+	// WaitedPositions stores the positions WaitForPosition
+	// was called with.
+	WaitedPositions []string
+
 	// Queries stores the requests received.
 	Queries []tproto.BoundQuery
 
@@ -484,6 +504,66 @@ var sandboxSQRowCount = int64(10)
 
 // Fake SplitQuery creates splits from the original query by appending the
 // split index as a comment to the SQL. RowCount is always sandboxSQRowCount
+func (sbc *sandboxConn) Prepare(ctx context.Context, transactionID int64, dtid string) error {
+	sbc.PrepareCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) CommitPrepared(ctx context.Context, dtid string) error {
+	sbc.CommitPreparedCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) RollbackPrepared(ctx context.Context, dtid string, originalID int64) error {
+	sbc.RollbackPreparedCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) CreateTransaction(ctx context.Context, dtid string, participants []tproto.TxParticipant) error {
+	sbc.CreateTransactionCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) StartCommit(ctx context.Context, transactionID int64, dtid string) error {
+	sbc.StartCommitCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) SetRollback(ctx context.Context, dtid string, transactionID int64) error {
+	sbc.SetRollbackCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) ConcludeTransaction(ctx context.Context, dtid string) error {
+	sbc.ConcludeTransactionCount.Add(1)
+	return sbc.getError()
+}
+
+func (sbc *sandboxConn) ReadTransaction(ctx context.Context, dtid string) (*tproto.TransactionMetadata, error) {
+	sbc.ReadTransactionCount.Add(1)
+	if err := sbc.getError(); err != nil {
+		return nil, err
+	}
+	return &tproto.TransactionMetadata{}, nil
+}
+
+// sandboxPosition is the replication position returned by MasterPosition.
+const sandboxPosition = "MariaDB/0-1-123"
+
+func (sbc *sandboxConn) MasterPosition(ctx context.Context) (string, error) {
+	sbc.MasterPositionCount.Add(1)
+	if err := sbc.getError(); err != nil {
+		return "", err
+	}
+	return sandboxPosition, nil
+}
+
+func (sbc *sandboxConn) WaitForPosition(ctx context.Context, position string, timeout time.Duration) error {
+	sbc.WaitForPositionCount.Add(1)
+	sbc.WaitedPositions = append(sbc.WaitedPositions, position)
+	return sbc.getError()
+}
+
 func (sbc *sandboxConn) SplitQuery(ctx context.Context, query tproto.BoundQuery, splitCount int) ([]tproto.QuerySplit, error) {
 	splits := []tproto.QuerySplit{}
 	for i := 0; i < splitCount; i++ {
@@ -5,11 +5,13 @@
 package vtgate
 
 import (
+	"flag"
 	"fmt"
 	"strings"
 	"sync"
 	"time"
 
+	log "github.com/golang/glog"
 	mproto "github.com/youtube/vitess/go/mysql/proto"
 	"github.com/youtube/vitess/go/stats"
 	"github.com/youtube/vitess/go/sync2"
@@ -24,6 +26,8 @@ import (
 
 var idGen sync2.AtomicInt64
 
+var transactionMode = flag.String("transaction_mode", proto.TransactionModeMulti, "default transaction mode for sessions that don't specify one: single, multi or twopc. twopc requires -twopc-enable on the vttablets")
+
 // ScatterConn is used for executing queries across
 // multiple ShardConn connections.
 type ScatterConn struct {
@@ -129,6 +133,7 @@ func (stc *ScatterConn) Execute(
 	session *SafeSession,
 	notInTransaction bool,
 ) (*mproto.QueryResult, error) {
+	context = withWritePositions(context, session, tabletType)
 	results, allErrors := stc.multiGo(
 		context,
 		"Execute",
@@ -142,6 +147,7 @@ func (stc *ScatterConn) Execute(
 			if err != nil {
 				return err
 			}
+			recordAutocommitPosition(context, session, sdc, transactionId, query)
 			sResults <- innerqr
 			return nil
 		})
@@ -169,6 +175,7 @@ func (stc *ScatterConn) ExecuteMulti(
 	session *SafeSession,
 	notInTransaction bool,
 ) (*mproto.QueryResult, error) {
+	context = withWritePositions(context, session, tabletType)
 	results, allErrors := stc.multiGo(
 		context,
 		"Execute",
@@ -182,6 +189,7 @@ func (stc *ScatterConn) ExecuteMulti(
 			if err != nil {
 				return err
 			}
+			recordAutocommitPosition(context, session, sdc, transactionId, query)
 			sResults <- innerqr
 			return nil
 		})
@@ -208,6 +216,7 @@ func (stc *ScatterConn) ExecuteEntityIds(
 	session *SafeSession,
 	notInTransaction bool,
 ) (*mproto.QueryResult, error) {
+	context = withWritePositions(context, session, tabletType)
 	results, allErrors := stc.multiGo(
 		context,
 		"ExecuteEntityIds",
@@ -224,6 +233,7 @@ func (stc *ScatterConn) ExecuteEntityIds(
 			if err != nil {
 				return err
 			}
+			recordAutocommitPosition(context, session, sdc, transactionId, sql)
 			sResults <- innerqr
 			return nil
 		})
@@ -249,6 +259,7 @@ func (stc *ScatterConn) ExecuteBatch(
 	session *SafeSession,
 	notInTransaction bool,
 ) (qrs *tproto.QueryResultList, err error) {
+	context = withWritePositions(context, session, tabletType)
 	results, allErrors := stc.multiGo(
 		context,
 		"ExecuteBatch",
@@ -262,6 +273,11 @@ func (stc *ScatterConn) ExecuteBatch(
 			if err != nil {
 				return err
 			}
+			sqls := make([]string, len(queries))
+			for i, query := range queries {
+				sqls[i] = query.Sql
+			}
+			recordAutocommitPosition(context, session, sdc, transactionId, sqls...)
 			sResults <- innerqrs
 			return nil
 		})
@@ -292,6 +308,7 @@ func (stc *ScatterConn) StreamExecute(
 	sendReply func(reply *mproto.QueryResult) error,
 	notInTransaction bool,
 ) error {
+	context = withWritePositions(context, session, tabletType)
 	results, allErrors := stc.multiGo(
 		context,
 		"StreamExecute",
@@ -345,6 +362,7 @@ func (stc *ScatterConn) StreamExecuteMulti(
 	sendReply func(reply *mproto.QueryResult) error,
 	notInTransaction bool,
 ) error {
+	context = withWritePositions(context, session, tabletType)
 	results, allErrors := stc.multiGo(
 		context,
 		"StreamExecute",
@@ -385,6 +403,87 @@ func (stc *ScatterConn) StreamExecuteMulti(
 	return allErrors.AggrError(stc.aggregateErrors)
 }
 
+// StreamExecuteMultiMerge is like StreamExecuteMulti, but the results
+// of each shard are delivered through a separate channel to the merge
+// function, which is responsible for sending the replies. If merge returns
+// before all the channels are exhausted, the remaining results are drained.
+func (stc *ScatterConn) StreamExecuteMultiMerge(
+	context context.Context,
+	query string,
+	keyspace string,
+	shardVars map[string]map[string]interface{},
+	tabletType topo.TabletType,
+	session *SafeSession,
+	merge func(results []<-chan *mproto.QueryResult) error,
+	notInTransaction bool,
+) error {
+	context = withWritePositions(context, session, tabletType)
+	shards := getShards(shardVars)
+	done := make(chan struct{})
+	streams := make(map[string]*shardStream, len(shards))
+	mergeInput := make([]<-chan *mproto.QueryResult, 0, len(shards))
+	for shard := range unique(shards) {
+		ss := &shardStream{results: make(chan *mproto.QueryResult, 10)}
+		streams[shard] = ss
+		mergeInput = append(mergeInput, ss.results)
+	}
+	results, allErrors := stc.multiGo(
+		context,
+		"StreamExecute",
+		keyspace,
+		shards,
+		tabletType,
+		session,
+		notInTransaction,
+		func(sdc *ShardConn, transactionId int64, sResults chan<- interface{}) error {
+			ss := streams[sdc.shard]
+			defer ss.close()
+			sr, errFunc := sdc.StreamExecute(context, query, shardVars[sdc.shard], transactionId)
+			if sr != nil {
+				for qr := range sr {
+					select {
+					case ss.results <- qr:
+					case <-done:
+						// The merge has finished. Keep pumping.
+					}
+				}
+			}
+			return errFunc()
+		})
+	go func() {
+		// Shards that failed before executing the action
+		// must also be closed for the merge to finish.
+		for _ = range results {
+		}
+		for _, ss := range streams {
+			ss.close()
+		}
+	}()
+	mergeErr := merge(mergeInput)
+	close(done)
+	for _, ss := range streams {
+		for _ = range ss.results {
+		}
+	}
+	if mergeErr != nil {
+		allErrors.RecordError(mergeErr)
+	}
+	return allErrors.AggrError(stc.aggregateErrors)
+}
+
+// shardStream is the result channel of one shard
+// for StreamExecuteMultiMerge. It can be closed more than once.
+type shardStream struct {
+	results chan *mproto.QueryResult
+	once    sync.Once
+}
+
+func (ss *shardStream) close() {
+	ss.once.Do(func() {
+		close(ss.results)
+	})
+}
+
 // Commit commits the current transaction. There are no retries on this operation.
 func (stc *ScatterConn) Commit(context context.Context, session *SafeSession) (err error) {
 	if session == nil {
@@ -393,8 +492,39 @@ func (stc *ScatterConn) Commit(context context.Context, session *SafeSession) (e
 	if !session.InTransaction() {
 		return fmt.Errorf("cannot commit: not in transaction")
 	}
+	preSessions, shardSessions, postSessions := session.commitPhases()
+	committed := session.commitOrder()
+	if err = checkTransactionMode(session.transactionMode()); err != nil {
+		// Rather than commit in a mode the caller didn't ask
+		// for, the transaction is rolled back.
+		stc.rollbackSessions(context, committed)
+		err = fmt.Errorf("cannot commit: %v", err)
+	} else if session.transactionMode() != proto.TransactionModeTwoPC || len(shardSessions) < 2 {
+		err = stc.commitSequence(context, committed)
+	} else {
+		if err = stc.commitSequence(context, preSessions); err != nil {
+			stc.rollbackSessions(context, shardSessions)
+		} else {
+			err = stc.commit2PC(context, shardSessions)
+		}
+		if err != nil {
+			stc.rollbackSessions(context, postSessions)
+		} else {
+			err = stc.commitSequence(context, postSessions)
+		}
+	}
+	if err == nil {
+		stc.recordWritePositions(context, session, committed)
+	}
+	session.Reset()
+	return err
+}
+
+// commitSequence commits the shard sessions one after the other.
+// Once a commit fails, the remaining ones are rolled back.
+func (stc *ScatterConn) commitSequence(context context.Context, shardSessions []*proto.ShardSession) (err error) {
 	committing := true
-	for _, shardSession := range session.ShardSessions {
+	for _, shardSession := range shardSessions {
 		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
 		if !committing {
 			sdc.Rollback(context, shardSession.TransactionId)
@@ -404,19 +534,84 @@ func (stc *ScatterConn) Commit(context context.Context, session *SafeSession) (e
 			committing = false
 		}
 	}
-	session.Reset()
 	return err
 }
 
+// commit2PC atomically commits the shard sessions using a two-phase
+// commit. The first shard session is the metadata manager: it stores
+// the state of the distributed transaction, and its own transaction
+// is committed along with the decision to commit. The other ones are
+// the participants. Once the decision is recorded, a failure is left
+// to the resolver of the metadata manager's vttablet.
+func (stc *ScatterConn) commit2PC(context context.Context, shardSessions []*proto.ShardSession) error {
+	mmShard, rmShards := shardSessions[0], shardSessions[1:]
+	dtid := fmt.Sprintf("%s:%s:%d", mmShard.Keyspace, mmShard.Shard, mmShard.TransactionId)
+	participants := make([]tproto.TxParticipant, 0, len(rmShards))
+	for _, shardSession := range rmShards {
+		participants = append(participants, tproto.TxParticipant{
+			Keyspace: shardSession.Keyspace,
+			Shard:    shardSession.Shard,
+		})
+	}
+	mm := stc.getConnection(context, mmShard.Keyspace, mmShard.Shard, mmShard.TabletType)
+	if err := mm.CreateTransaction(context, dtid, participants); err != nil {
+		stc.rollbackSessions(context, shardSessions)
+		return err
+	}
+
+	var err error
+	for _, shardSession := range rmShards {
+		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if err = sdc.Prepare(context, shardSession.TransactionId, dtid); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = mm.StartCommit(context, mmShard.TransactionId, dtid)
+	}
+	if err != nil {
+		if rerr := mm.SetRollback(context, dtid, mmShard.TransactionId); rerr != nil {
+			return fmt.Errorf("distributed transaction %s could not be rolled back: %v, original error: %v", dtid, rerr, err)
+		}
+		resolved := true
+		for _, shardSession := range rmShards {
+			sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+			if rerr := sdc.RollbackPrepared(context, dtid, shardSession.TransactionId); rerr != nil {
+				log.Warningf("distributed transaction %s: rollback of %s/%s failed, leaving it to the resolver: %v", dtid, shardSession.Keyspace, shardSession.Shard, rerr)
+				resolved = false
+			}
+		}
+		if resolved {
+			mm.ConcludeTransaction(context, dtid)
+		}
+		return err
+	}
+
+	for _, shardSession := range rmShards {
+		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		if cerr := sdc.CommitPrepared(context, dtid); cerr != nil {
+			log.Warningf("distributed transaction %s: commit of %s/%s failed, leaving it to the resolver: %v", dtid, shardSession.Keyspace, shardSession.Shard, cerr)
+			return nil
+		}
+	}
+	mm.ConcludeTransaction(context, dtid)
+	return nil
+}
+
+// rollbackSessions rolls back the shard sessions. Errors are ignored.
+func (stc *ScatterConn) rollbackSessions(context context.Context, shardSessions []*proto.ShardSession) {
+	for _, shardSession := range shardSessions {
+		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
+		sdc.Rollback(context, shardSession.TransactionId)
+	}
+}
+
 // Rollback rolls back the current transaction. There are no retries on this operation.
 func (stc *ScatterConn) Rollback(context context.Context, session *SafeSession) (err error) {
 	if session == nil {
 		return nil
 	}
-	for _, shardSession := range session.ShardSessions {
-		sdc := stc.getConnection(context, shardSession.Keyspace, shardSession.Shard, shardSession.TabletType)
-		sdc.Rollback(context, shardSession.TransactionId)
-	}
+	stc.rollbackSessions(context, session.commitOrder())
 	session.Reset()
 	return nil
 }
@@ -586,6 +781,8 @@ func (stc *ScatterConn) multiGo(
 ) (rResults <-chan interface{}, allErrors *concurrency.AllErrorRecorder) {
 	allErrors = new(concurrency.AllErrorRecorder)
 	results := make(chan interface{}, len(shards))
+	logStats := logStatsFromContext(context)
+	multiStartTime := time.Now()
 	var wg sync.WaitGroup
 	for shard := range unique(shards) {
 		wg.Add(1)
@@ -616,6 +813,7 @@ func (stc *ScatterConn) multiGo(
 	}
 	go func() {
 		wg.Wait()
+		logStats.recordExecute(keyspace, shards, multiStartTime)
 		// If we want to rollback, we have to do it before closing results
 		// so that the session is updated to be not InTransaction.
 		if allErrors.HasErrors() {
@@ -670,16 +868,27 @@ func (stc *ScatterConn) updateSession(
 	if notInTransaction {
 		return 0, nil
 	}
+	// Fail early instead of starting a transaction
+	// that Append would reject.
+	if err := session.checkAppend(keyspace, shard); err != nil {
+		return 0, err
+	}
 	transactionID, err = sdc.Begin(context)
 	if err != nil {
 		return 0, err
 	}
-	session.Append(&proto.ShardSession{
+	// Concurrent shards of the same statement can all pass the
+	// check above. Only the first one is allowed in single mode.
+	err = session.Append(&proto.ShardSession{
 		Keyspace:      keyspace,
 		TabletType:    tabletType,
 		Shard:         shard,
 		TransactionId: transactionID,
 	})
+	if err != nil {
+		sdc.Rollback(context, transactionID)
+		return 0, err
+	}
 	return transactionID, nil
 }
 
@@ -5,6 +5,7 @@
 package vtgate
 
 import (
+	"flag"
 	"fmt"
 	"reflect"
 	"testing"
@@ -290,6 +291,284 @@ func TestScatterConnCommitSuccess(t *testing.T) {
 	}
 }
 
+func TestScatterConnCommitOrder(t *testing.T) {
+	s := createSandbox("TestScatterConnCommitOrder")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	sbc2 := &sandboxConn{}
+	s.MapTestConn("2", sbc2)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	newSession := func() *SafeSession {
+		return NewSafeSession(&proto.Session{
+			InTransaction: true,
+			PreSessions: []*proto.ShardSession{{
+				Keyspace:      "TestScatterConnCommitOrder",
+				Shard:         "0",
+				TransactionId: 1,
+			}},
+			ShardSessions: []*proto.ShardSession{{
+				Keyspace:      "TestScatterConnCommitOrder",
+				Shard:         "1",
+				TransactionId: 1,
+			}},
+			PostSessions: []*proto.ShardSession{{
+				Keyspace:      "TestScatterConnCommitOrder",
+				Shard:         "2",
+				TransactionId: 1,
+			}},
+		})
+	}
+
+	// A failed pre-commit rolls back everything else.
+	sbc0.mustFailServer = 1
+	session := newSession()
+	if err := stc.Commit(context.Background(), session); err == nil {
+		t.Errorf("want error, got nil")
+	}
+	if !reflect.DeepEqual(proto.Session{}, *session.Session) {
+		t.Errorf("want empty session, got\n%+v", *session.Session)
+	}
+	if sbc0.CommitCount != 1 || sbc1.RollbackCount != 1 || sbc2.RollbackCount != 1 {
+		t.Errorf("counts: %d, %d, %d, want 1, 1, 1", sbc0.CommitCount, sbc1.RollbackCount, sbc2.RollbackCount)
+	}
+
+	// A failed commit rolls back the post-commit.
+	sbc1.mustFailServer = 1
+	if err := stc.Commit(context.Background(), newSession()); err == nil {
+		t.Errorf("want error, got nil")
+	}
+	if sbc0.CommitCount != 2 || sbc1.CommitCount != 1 || sbc2.RollbackCount != 2 {
+		t.Errorf("counts: %d, %d, %d, want 2, 1, 2", sbc0.CommitCount, sbc1.CommitCount, sbc2.RollbackCount)
+	}
+
+	if err := stc.Commit(context.Background(), newSession()); err != nil {
+		t.Error(err)
+	}
+	if sbc0.CommitCount != 3 || sbc1.CommitCount != 2 || sbc2.CommitCount != 1 {
+		t.Errorf("counts: %d, %d, %d, want 3, 2, 1", sbc0.CommitCount, sbc1.CommitCount, sbc2.CommitCount)
+	}
+
+	if err := stc.Rollback(context.Background(), newSession()); err != nil {
+		t.Error(err)
+	}
+	if sbc0.RollbackCount != 1 || sbc1.RollbackCount != 2 || sbc2.RollbackCount != 3 {
+		t.Errorf("counts: %d, %d, %d, want 1, 2, 3", sbc0.RollbackCount, sbc1.RollbackCount, sbc2.RollbackCount)
+	}
+}
+
+func TestScatterConnCommit2PC(t *testing.T) {
+	s := createSandbox("TestScatterConnCommit2PC")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+	*transactionMode = proto.TransactionModeTwoPC
+	defer func() { *transactionMode = proto.TransactionModeMulti }()
+
+	newSession := func() *SafeSession {
+		return NewSafeSession(&proto.Session{
+			InTransaction: true,
+			ShardSessions: []*proto.ShardSession{{
+				Keyspace:      "TestScatterConnCommit2PC",
+				Shard:         "0",
+				TransactionId: 1,
+			}, {
+				Keyspace:      "TestScatterConnCommit2PC",
+				Shard:         "1",
+				TransactionId: 1,
+			}},
+		})
+	}
+	counts := func() []int64 {
+		return []int64{
+			sbc0.CreateTransactionCount.Get(),
+			sbc1.PrepareCount.Get(),
+			sbc0.StartCommitCount.Get(),
+			sbc1.CommitPreparedCount.Get(),
+			sbc0.SetRollbackCount.Get(),
+			sbc1.RollbackPreparedCount.Get(),
+			sbc0.ConcludeTransactionCount.Get(),
+		}
+	}
+
+	session := newSession()
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(proto.Session{}, *session.Session) {
+		t.Errorf("want empty session, got\n%+v", *session.Session)
+	}
+	want := []int64{1, 1, 1, 1, 0, 0, 1}
+	if got := counts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("counts: %v, want %v", got, want)
+	}
+	if sbc0.CommitCount != 0 || sbc1.CommitCount != 0 {
+		t.Errorf("commit counts: %d, %d, want 0, 0", sbc0.CommitCount, sbc1.CommitCount)
+	}
+
+	// A failed prepare rolls back the distributed transaction.
+	sbc1.mustFailServer = 1
+	if err := stc.Commit(context.Background(), newSession()); err == nil {
+		t.Errorf("want error, got nil")
+	}
+	want = []int64{2, 2, 1, 1, 1, 1, 2}
+	if got := counts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("counts: %v, want %v", got, want)
+	}
+
+	// A failed CommitPrepared is left to the resolver.
+	sbc1.onConnUse = func(sbc *sandboxConn) {
+		if sbc.CommitPreparedCount.Get() == 2 {
+			sbc.mustFailServer = 1
+		}
+	}
+	if err := stc.Commit(context.Background(), newSession()); err != nil {
+		t.Error(err)
+	}
+	sbc1.onConnUse = nil
+	want = []int64{3, 3, 2, 2, 1, 1, 2}
+	if got := counts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("counts: %v, want %v", got, want)
+	}
+
+	// A failed CreateTransaction rolls back all the shard sessions.
+	sbc0.mustFailServer = 1
+	if err := stc.Commit(context.Background(), newSession()); err == nil {
+		t.Errorf("want error, got nil")
+	}
+	if sbc0.RollbackCount != 1 || sbc1.RollbackCount != 1 {
+		t.Errorf("rollback counts: %d, %d, want 1, 1", sbc0.RollbackCount, sbc1.RollbackCount)
+	}
+	if got := sbc1.PrepareCount.Get(); got != 3 {
+		t.Errorf("PrepareCount: %d, want 3", got)
+	}
+}
+
+func TestScatterConnSingleMode(t *testing.T) {
+	s := createSandbox("TestScatterConnSingleMode")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true, TransactionMode: proto.TransactionModeSingle})
+	_, err := stc.Execute(context.Background(), "query1", nil, "TestScatterConnSingleMode", []string{"0"}, "", session, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Executing again on the same shard is allowed.
+	_, err = stc.Execute(context.Background(), "query1", nil, "TestScatterConnSingleMode", []string{"0"}, "", session, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stc.Execute(context.Background(), "query1", nil, "TestScatterConnSingleMode", []string{"1"}, "", session, false)
+	want := "multi-shard transaction not allowed in single mode: TestScatterConnSingleMode/0 is already in the transaction, cannot add TestScatterConnSingleMode/1"
+	if err == nil || err.Error() != want {
+		t.Errorf("want %s, got %v", want, err)
+	}
+	if sbc1.BeginCount != 0 {
+		t.Errorf("want 0, got %d", sbc1.BeginCount)
+	}
+	if len(session.ShardSessions) != 1 {
+		t.Errorf("want 1 shard session, got %+v", session.ShardSessions)
+	}
+	// The transaction is not rolled back, and the mode survives the commit.
+	if err := stc.Commit(context.Background(), session); err != nil {
+		t.Error(err)
+	}
+	if sbc0.CommitCount != 1 {
+		t.Errorf("want 1, got %d", sbc0.CommitCount)
+	}
+	if session.TransactionMode != proto.TransactionModeSingle {
+		t.Errorf("TransactionMode: %s, want %s", session.TransactionMode, proto.TransactionModeSingle)
+	}
+
+	// Append rejects a second shard session.
+	session = NewSafeSession(&proto.Session{InTransaction: true, TransactionMode: proto.TransactionModeSingle})
+	if err := session.Append(&proto.ShardSession{Keyspace: "ks", Shard: "0"}); err != nil {
+		t.Error(err)
+	}
+	if err := session.Append(&proto.ShardSession{Keyspace: "ks", Shard: "1"}); err == nil {
+		t.Errorf("want error, got nil")
+	}
+
+	// The default mode of the vtgate applies to sessions that don't have one.
+	*transactionMode = proto.TransactionModeSingle
+	defer func() { *transactionMode = proto.TransactionModeMulti }()
+	session = NewSafeSession(&proto.Session{InTransaction: true})
+	_, err = stc.Execute(context.Background(), "query1", nil, "TestScatterConnSingleMode", []string{"0", "1"}, "", session, false)
+	if err == nil {
+		t.Errorf("want error, got nil")
+	}
+	if len(session.ShardSessions) != 1 {
+		t.Errorf("want 1 shard session, got %+v", session.ShardSessions)
+	}
+}
+
+func TestScatterConnUnknownMode(t *testing.T) {
+	s := createSandbox("TestScatterConnUnknownMode")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true, TransactionMode: "twophase"})
+	_, err := stc.Execute(context.Background(), "query1", nil, "TestScatterConnUnknownMode", []string{"0", "1"}, "", session, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The transaction is rolled back instead of being committed in multi mode.
+	err = stc.Commit(context.Background(), session)
+	want := `cannot commit: unknown transaction mode: "twophase", want single, multi or twopc`
+	if err == nil || err.Error() != want {
+		t.Errorf("want %s, got %v", want, err)
+	}
+	if sbc0.CommitCount != 0 || sbc1.CommitCount != 0 {
+		t.Errorf("commit counts: %d, %d, want 0, 0", sbc0.CommitCount, sbc1.CommitCount)
+	}
+	if sbc0.RollbackCount != 1 || sbc1.RollbackCount != 1 {
+		t.Errorf("rollback counts: %d, %d, want 1, 1", sbc0.RollbackCount, sbc1.RollbackCount)
+	}
+	if session.InTransaction() {
+		t.Errorf("want the session out of the transaction")
+	}
+}
+
+func TestScatterConnTwoPCUnsupported(t *testing.T) {
+	s := createSandbox("TestScatterConnTwoPCUnsupported")
+	sbc0 := &sandboxConn{}
+	s.MapTestConn("0", sbc0)
+	sbc1 := &sandboxConn{}
+	s.MapTestConn("1", sbc1)
+	stc := NewScatterConn(new(sandboxTopo), "", "aa", 1*time.Millisecond, 3, 2*time.Millisecond, 1*time.Millisecond, 24*time.Hour)
+
+	session := NewSafeSession(&proto.Session{InTransaction: true, TransactionMode: proto.TransactionModeTwoPC})
+	_, err := stc.Execute(context.Background(), "query1", nil, "TestScatterConnTwoPCUnsupported", []string{"0", "1"}, "", session, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tabletconn.RegisterNoTwoPC("notwopc")
+	flag.Set("tablet_protocol", "notwopc")
+	defer flag.Set("tablet_protocol", "sandbox")
+	err = stc.Commit(context.Background(), session)
+	want := "cannot commit: tablet protocol notwopc doesn't support two-phase commit"
+	if err == nil || err.Error() != want {
+		t.Errorf("want %s, got %v", want, err)
+	}
+	if sbc0.CommitCount != 0 || sbc1.CommitCount != 0 {
+		t.Errorf("commit counts: %d, %d, want 0, 0", sbc0.CommitCount, sbc1.CommitCount)
+	}
+	if sbc0.RollbackCount != 1 || sbc1.RollbackCount != 1 {
+		t.Errorf("rollback counts: %d, %d, want 1, 1", sbc0.RollbackCount, sbc1.RollbackCount)
+	}
+}
+
 func TestScatterConnRollback(t *testing.T) {
 	s := createSandbox("TestScatterConnRollback")
 	sbc0 := &sandboxConn{}
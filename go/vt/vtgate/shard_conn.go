@@ -5,7 +5,9 @@
 package vtgate
 
 import (
+	"flag"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,19 +16,33 @@ import (
 	"github.com/youtube/vitess/go/sync2"
 	"github.com/youtube/vitess/go/timer"
 	"github.com/youtube/vitess/go/vt/concurrency"
+	"github.com/youtube/vitess/go/vt/tabletmanager/actionnode"
+	"github.com/youtube/vitess/go/vt/tabletmanager/tmclient"
 	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
 	"github.com/youtube/vitess/go/vt/tabletserver/tabletconn"
 	"github.com/youtube/vitess/go/vt/topo"
 	"golang.org/x/net/context"
 )
 
-var danglingTabletConn = stats.NewInt("DanglingTabletConn")
+var (
+	cellPreference   = flag.String("cell_preference", "", "comma separated list of other cells, in order of preference, to send the replica and rdonly queries to when the local cell has no available endpoints")
+	fallbackConnLife = flag.Duration("cell_fallback_conn_life", time.Minute, "how long a connection to another cell of -cell_preference is used before vtgate tries the local cell again")
+
+	danglingTabletConn = stats.NewInt("DanglingTabletConn")
+	crossCellQueries   = stats.NewMultiCounters("CrossCellQueries", []string{"Keyspace", "ShardName", "DbType", "Cell"})
+
+	// healthStreamClient is the tablet manager client of all the
+	// health streams, created by the first one.
+	healthStreamClient     tmclient.TabletManagerClient
+	healthStreamClientOnce sync.Once
+)
 
 // ShardConn represents a load balanced connection to a group
 // of vttablets that belong to the same shard. ShardConn can
 // be concurrently used across goroutines. Such requests are
 // interleaved on the same underlying connection.
 type ShardConn struct {
+	cell               string
 	keyspace           string
 	shard              string
 	tabletType         topo.TabletType
@@ -35,34 +51,69 @@ type ShardConn struct {
 	connTimeoutTotal   time.Duration
 	connTimeoutPerConn time.Duration
 	connLife           time.Duration
+	fallbackConnLife   time.Duration
 	balancer           *Balancer
+	fallbacks          []*cellBalancer
+	buffer             *masterBuffer
 	consolidator       *sync2.Consolidator
 	ticker             *timer.RandTicker
 
 	connectTimings *stats.MultiTimings
 
 	// conn needs a mutex because it can change during the lifetime of ShardConn.
-	mu   sync.Mutex
-	conn tabletconn.TabletConn
+	// connBalancer and connCell are the Balancer and the cell of conn,
+	// connTime is when it was created.
+	mu           sync.Mutex
+	conn         tabletconn.TabletConn
+	connBalancer *Balancer
+	connCell     string
+	connTime     time.Time
+}
+
+// cellBalancer is the Balancer of the endpoints of another cell.
+type cellBalancer struct {
+	cell     string
+	balancer *Balancer
 }
 
 // NewShardConn creates a new ShardConn. It creates a Balancer using
 // serv, cell, keyspace, tabletType and retryDelay. retryCount is the max
 // number of retries before a ShardConn returns an error on an operation.
+// The replica and rdonly ShardConns also create a Balancer for each cell
+// of -cell_preference. They're used when the local cell has no available
+// endpoints, until the cross-cell connection expires after
+// -cell_fallback_conn_life.
 func NewShardConn(ctx context.Context, serv SrvTopoServer, cell, keyspace, shard string, tabletType topo.TabletType, retryDelay time.Duration, retryCount int, connTimeoutTotal, connTimeoutPerConn, connLife time.Duration, tabletConnectTimings *stats.MultiTimings) *ShardConn {
-	getAddresses := func() (*topo.EndPoints, error) {
-		endpoints, _, err := serv.GetEndPoints(ctx, cell, keyspace, shard, tabletType)
-		if err != nil {
-			return nil, fmt.Errorf("endpoints fetch error: %v", err)
+	newBalancer := func(cell string) *Balancer {
+		getAddresses := func() (*topo.EndPoints, error) {
+			endpoints, _, err := serv.GetEndPoints(ctx, cell, keyspace, shard, tabletType)
+			if err != nil {
+				return nil, fmt.Errorf("endpoints fetch error: %v", err)
+			}
+			return endpoints, nil
+		}
+		blc := NewBalancer(getAddresses, retryDelay)
+		if tabletType != topo.TYPE_MASTER && *maxReplicationLag > 0 {
+			blc.EnableHealthStreams(tabletManagerHealthStream(cell), *maxReplicationLag)
+		}
+		return blc
+	}
+	blc := newBalancer(cell)
+	var fallbacks []*cellBalancer
+	if tabletType != topo.TYPE_MASTER {
+		for _, fallbackCell := range fallbackCells(cell) {
+			fallbacks = append(fallbacks, &cellBalancer{
+				cell:     fallbackCell,
+				balancer: newBalancer(fallbackCell),
+			})
 		}
-		return endpoints, nil
 	}
-	blc := NewBalancer(getAddresses, retryDelay)
 	var ticker *timer.RandTicker
 	if tabletType != topo.TYPE_MASTER {
 		ticker = timer.NewRandTicker(connLife, connLife/2)
 	}
 	sdc := &ShardConn{
+		cell:               cell,
 		keyspace:           keyspace,
 		shard:              shard,
 		tabletType:         tabletType,
@@ -71,11 +122,22 @@ func NewShardConn(ctx context.Context, serv SrvTopoServer, cell, keyspace, shard
 		connTimeoutTotal:   connTimeoutTotal,
 		connTimeoutPerConn: connTimeoutPerConn,
 		connLife:           connLife,
+		fallbackConnLife:   *fallbackConnLife,
 		balancer:           blc,
+		fallbacks:          fallbacks,
 		ticker:             ticker,
 		consolidator:       sync2.NewConsolidator(),
 		connectTimings:     tabletConnectTimings,
 	}
+	if tabletType == topo.TYPE_MASTER && *masterBufferWindow > 0 {
+		sdc.buffer = newMasterBuffer(keyspace+"."+shard, *masterBufferWindow, *masterBufferSize, blc.Get)
+	}
+	for _, b := range append([]*Balancer{blc}, balancers(fallbacks)...) {
+		b := b
+		b.OnUnusable(func(uid uint32) {
+			sdc.closeUnusable(b, uid)
+		})
+	}
 	if ticker != nil {
 		go func() {
 			for range ticker.C {
@@ -86,6 +148,45 @@ func NewShardConn(ctx context.Context, serv SrvTopoServer, cell, keyspace, shard
 	return sdc
 }
 
+// fallbackCells returns the cells of -cell_preference, without cell.
+func fallbackCells(cell string) []string {
+	var cells []string
+	for _, c := range strings.Split(*cellPreference, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" || c == cell {
+			continue
+		}
+		cells = append(cells, c)
+	}
+	return cells
+}
+
+// balancers returns the Balancers of cellBalancers.
+func balancers(cellBalancers []*cellBalancer) []*Balancer {
+	blcs := make([]*Balancer, 0, len(cellBalancers))
+	for _, cb := range cellBalancers {
+		blcs = append(blcs, cb.balancer)
+	}
+	return blcs
+}
+
+// tabletManagerHealthStream returns a HealthStreamFunc that
+// streams the health of the endpoints of cell from their
+// tablet manager.
+func tabletManagerHealthStream(cell string) HealthStreamFunc {
+	return func(ctx context.Context, endPoint topo.EndPoint) (<-chan *actionnode.HealthStreamReply, tmclient.ErrFunc, error) {
+		healthStreamClientOnce.Do(func() {
+			healthStreamClient = tmclient.NewTabletManagerClient()
+		})
+		tablet := &topo.Tablet{
+			Alias:    topo.TabletAlias{Cell: cell, Uid: endPoint.Uid},
+			Hostname: endPoint.Host,
+			Portmap:  endPoint.NamedPortMap,
+		}
+		return healthStreamClient.HealthStream(ctx, topo.NewTabletInfo(tablet, 0))
+	}
+}
+
 // ShardConnError is the shard conn specific error.
 type ShardConnError struct {
 	Code            int
@@ -116,6 +217,9 @@ func (sdc *ShardConn) Dial(ctx context.Context) error {
 // the middle of a transaction.
 func (sdc *ShardConn) Execute(ctx context.Context, query string, bindVars map[string]interface{}, transactionID int64) (qr *mproto.QueryResult, err error) {
 	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		if err := waitForWritePosition(ctx, conn, sdc.keyspace, sdc.shard); err != nil {
+			return err
+		}
 		var innerErr error
 		qr, innerErr = conn.Execute(ctx, query, bindVars, transactionID)
 		return innerErr
@@ -126,6 +230,9 @@ func (sdc *ShardConn) Execute(ctx context.Context, query string, bindVars map[st
 // ExecuteBatch executes a group of queries. The retry rules are the same as Execute.
 func (sdc *ShardConn) ExecuteBatch(ctx context.Context, queries []tproto.BoundQuery, transactionID int64) (qrs *tproto.QueryResultList, err error) {
 	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		if err := waitForWritePosition(ctx, conn, sdc.keyspace, sdc.shard); err != nil {
+			return err
+		}
 		var innerErr error
 		qrs, innerErr = conn.ExecuteBatch(ctx, queries, transactionID)
 		return innerErr
@@ -139,6 +246,9 @@ func (sdc *ShardConn) StreamExecute(ctx context.Context, query string, bindVars
 	var erFunc tabletconn.ErrFunc
 	var results <-chan *mproto.QueryResult
 	err := sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		if err := waitForWritePosition(ctx, conn, sdc.keyspace, sdc.shard); err != nil {
+			return err
+		}
 		var err error
 		results, erFunc, err = conn.StreamExecute(ctx, query, bindVars, transactionID)
 		usedConn = conn
@@ -175,6 +285,72 @@ func (sdc *ShardConn) Rollback(ctx context.Context, transactionID int64) (err er
 	}, transactionID, false)
 }
 
+// MasterPosition returns the replication position of the master.
+// The retry rules are the same as Execute.
+func (sdc *ShardConn) MasterPosition(ctx context.Context) (position string, err error) {
+	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		var innerErr error
+		position, innerErr = conn.MasterPosition(ctx)
+		return innerErr
+	}, 0, false)
+	return position, err
+}
+
+// Prepare prepares the transaction for a two-phase commit.
+// The retry rules are the same as Execute.
+func (sdc *ShardConn) Prepare(ctx context.Context, transactionID int64, dtid string) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.Prepare(ctx, transactionID, dtid)
+	}, transactionID, false)
+}
+
+// CommitPrepared commits a prepared transaction. It's idempotent,
+// and can therefore be retried.
+func (sdc *ShardConn) CommitPrepared(ctx context.Context, dtid string) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.CommitPrepared(ctx, dtid)
+	}, 0, false)
+}
+
+// RollbackPrepared rolls back a prepared transaction, or the
+// original one if it was not prepared yet. It's idempotent.
+func (sdc *ShardConn) RollbackPrepared(ctx context.Context, dtid string, originalID int64) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.RollbackPrepared(ctx, dtid, originalID)
+	}, 0, false)
+}
+
+// CreateTransaction records the metadata of a distributed transaction.
+func (sdc *ShardConn) CreateTransaction(ctx context.Context, dtid string, participants []tproto.TxParticipant) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.CreateTransaction(ctx, dtid, participants)
+	}, 0, false)
+}
+
+// StartCommit atomically commits the metadata shard's transaction
+// along with the decision to commit the distributed transaction.
+func (sdc *ShardConn) StartCommit(ctx context.Context, transactionID int64, dtid string) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.StartCommit(ctx, transactionID, dtid)
+	}, transactionID, false)
+}
+
+// SetRollback records the decision to roll back the distributed
+// transaction, and rolls back the metadata shard's transaction.
+func (sdc *ShardConn) SetRollback(ctx context.Context, dtid string, transactionID int64) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.SetRollback(ctx, dtid, transactionID)
+	}, 0, false)
+}
+
+// ConcludeTransaction deletes the metadata of a resolved
+// distributed transaction.
+func (sdc *ShardConn) ConcludeTransaction(ctx context.Context, dtid string) (err error) {
+	return sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
+		return conn.ConcludeTransaction(ctx, dtid)
+	}, 0, false)
+}
+
 // SplitQuery splits a query into sub queries. The retry rules are the same as Execute.
 func (sdc *ShardConn) SplitQuery(ctx context.Context, query tproto.BoundQuery, splitCount int) (queries []tproto.QuerySplit, err error) {
 	err = sdc.withRetry(ctx, func(conn tabletconn.TabletConn) error {
@@ -190,6 +366,10 @@ func (sdc *ShardConn) Close() {
 	if sdc.ticker != nil {
 		sdc.ticker.Stop()
 	}
+	sdc.balancer.Close()
+	for _, fallback := range sdc.fallbacks {
+		fallback.balancer.Close()
+	}
 	sdc.closeCurrent()
 }
 
@@ -199,6 +379,12 @@ func (sdc *ShardConn) closeCurrent() {
 	if sdc.conn == nil {
 		return
 	}
+	sdc.closeCurrentLocked()
+}
+
+// closeCurrentLocked closes the current connection in the
+// background. sdc.mu must be held and sdc.conn set.
+func (sdc *ShardConn) closeCurrentLocked() {
 	go func(conn tabletconn.TabletConn) {
 		danglingTabletConn.Add(1)
 		conn.Close()
@@ -207,6 +393,18 @@ func (sdc *ShardConn) closeCurrent() {
 	sdc.conn = nil
 }
 
+// closeUnusable closes the current connection if it's to the
+// endpoint uid of blc, which was reported as unhealthy or lagging.
+// The next query connects to the best endpoint available.
+func (sdc *ShardConn) closeUnusable(blc *Balancer, uid uint32) {
+	sdc.mu.Lock()
+	defer sdc.mu.Unlock()
+	if sdc.conn == nil || sdc.connBalancer != blc || sdc.conn.EndPoint().Uid != uid {
+		return
+	}
+	sdc.closeCurrentLocked()
+}
+
 // withRetry sets up the connection and executes the action. If there are connection errors,
 // it retries retryCount times before failing. It does not retry if the connection is in
 // the middle of a transaction. While returning the error check if it maybe a result of
@@ -220,6 +418,13 @@ func (sdc *ShardConn) withRetry(ctx context.Context, action func(conn tabletconn
 	inTransaction := (transactionID != 0)
 	// execute the action at least once even without retrying
 	for i := 0; i < sdc.retryCount+1; i++ {
+		// Queries outside of transactions wait in the buffer
+		// during a master failover.
+		if sdc.buffer != nil && !inTransaction {
+			if err = sdc.buffer.wait(ctx); err != nil {
+				break
+			}
+		}
 		conn, endPoint, isTimeout, err = sdc.getConn(ctx)
 		if err != nil {
 			if isTimeout || i == sdc.retryCount {
@@ -228,6 +433,7 @@ func (sdc *ShardConn) withRetry(ctx context.Context, action func(conn tabletconn
 			time.Sleep(sdc.retryDelay)
 			continue
 		}
+		sdc.recordCrossCellQuery(conn)
 		err = action(conn)
 		if sdc.canRetry(ctx, err, transactionID, conn, isStreaming) {
 			continue
@@ -247,8 +453,13 @@ type connectResult struct {
 // If no connection is available,
 // it creates a new connection if no connection is being created.
 // Otherwise it waits for the connection to be created.
+// A connection to another cell is only reused for fallbackConnLife,
+// so the queries go back to the local cell once it has endpoints.
 func (sdc *ShardConn) getConn(ctx context.Context) (conn tabletconn.TabletConn, endPoint topo.EndPoint, isTimeout bool, err error) {
 	sdc.mu.Lock()
+	if sdc.conn != nil && sdc.connCell != sdc.cell && time.Now().Sub(sdc.connTime) >= sdc.fallbackConnLife {
+		sdc.closeCurrentLocked()
+	}
 	if sdc.conn != nil {
 		conn = sdc.conn
 		endPoint = conn.EndPoint()
@@ -277,7 +488,7 @@ func (sdc *ShardConn) getConn(ctx context.Context) (conn tabletconn.TabletConn,
 func (sdc *ShardConn) getNewConn(ctx context.Context) (conn tabletconn.TabletConn, endPoint topo.EndPoint, isTimeout bool, err error) {
 	startTime := time.Now()
 
-	endPoints, err := sdc.balancer.Get()
+	endPoints, blc, cell, err := sdc.getEndPoints()
 	if err != nil {
 		// Error when getting endpoint
 		return nil, topo.EndPoint{}, false, err
@@ -301,10 +512,13 @@ func (sdc *ShardConn) getNewConn(ctx context.Context) (conn tabletconn.TabletCon
 			sdc.mu.Lock()
 			defer sdc.mu.Unlock()
 			sdc.conn = conn
+			sdc.connBalancer = blc
+			sdc.connCell = cell
+			sdc.connTime = time.Now()
 			return conn, endPoint, false, nil
 		}
 		// Markdown the endpoint if it failed to connect
-		sdc.balancer.MarkDown(endPoint.Uid, err.Error())
+		blc.MarkDown(endPoint.Uid, err.Error())
 		allErrors.RecordError(fmt.Errorf("%v %+v", err, endPoint))
 		if time.Now().Sub(startTime) >= sdc.connTimeoutTotal {
 			err = fmt.Errorf("timeout when connecting to %+v", endPoint)
@@ -315,6 +529,35 @@ func (sdc *ShardConn) getNewConn(ctx context.Context) (conn tabletconn.TabletCon
 	return nil, topo.EndPoint{}, false, allErrors.Error()
 }
 
+// getEndPoints returns the available endpoints of the local cell.
+// If there are none, it returns the ones of the first fallback cell
+// that has some. It also returns the Balancer and the cell they
+// come from.
+func (sdc *ShardConn) getEndPoints() (endPoints []topo.EndPoint, blc *Balancer, cell string, err error) {
+	endPoints, err = sdc.balancer.Get()
+	if err == nil && len(endPoints) > 0 {
+		return endPoints, sdc.balancer, sdc.cell, nil
+	}
+	for _, fallback := range sdc.fallbacks {
+		fallbackEndPoints, fallbackErr := fallback.balancer.Get()
+		if fallbackErr == nil && len(fallbackEndPoints) > 0 {
+			return fallbackEndPoints, fallback.balancer, fallback.cell, nil
+		}
+	}
+	return endPoints, sdc.balancer, sdc.cell, err
+}
+
+// recordCrossCellQuery counts the queries sent to another cell.
+func (sdc *ShardConn) recordCrossCellQuery(conn tabletconn.TabletConn) {
+	sdc.mu.Lock()
+	cell := sdc.connCell
+	crossCell := conn == sdc.conn && cell != sdc.cell
+	sdc.mu.Unlock()
+	if crossCell {
+		crossCellQueries.Add([]string{sdc.keyspace, sdc.shard, string(sdc.tabletType), cell}, 1)
+	}
+}
+
 // getConnTimeoutPerConn determines the appropriate timeout per connection.
 func (sdc *ShardConn) getConnTimeoutPerConn(endPointCount int) time.Duration {
 	if endPointCount <= 1 {
@@ -354,6 +597,9 @@ func (sdc *ShardConn) canRetry(ctx context.Context, err error, transactionID int
 		case tabletconn.ERR_RETRY:
 			// Retry on RETRY and FATAL if not in a transaction.
 			inTransaction := (transactionID != 0)
+			if sdc.buffer != nil {
+				sdc.buffer.startFailover(conn.EndPoint().Uid)
+			}
 			sdc.markDown(conn, err.Error())
 			return !inTransaction
 		default:
@@ -377,14 +623,8 @@ func (sdc *ShardConn) markDown(conn tabletconn.TabletConn, reason string) {
 	if conn != sdc.conn {
 		return
 	}
-	sdc.balancer.MarkDown(conn.EndPoint().Uid, reason)
-
-	go func(conn tabletconn.TabletConn) {
-		danglingTabletConn.Add(1)
-		conn.Close()
-		danglingTabletConn.Add(-1)
-	}(sdc.conn)
-	sdc.conn = nil
+	sdc.connBalancer.MarkDown(conn.EndPoint().Uid, reason)
+	sdc.closeCurrentLocked()
 }
 
 // WrapError returns ShardConnError which preserves the original error code if possible,
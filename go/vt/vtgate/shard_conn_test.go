@@ -770,6 +770,30 @@ func TestReplicaShardConnLife(t *testing.T) {
 	sdc.Close()
 }
 
+func TestShardConnCloseUnusable(t *testing.T) {
+	s := createSandbox("TestShardConnCloseUnusable")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	sdc := NewShardConn(context.Background(), new(sandboxTopo), "aa", "TestShardConnCloseUnusable", "0", topo.TYPE_REPLICA, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	defer sdc.Close()
+	sdc.Execute(context.Background(), "query", nil, 0)
+	uid := sdc.conn.EndPoint().Uid
+
+	// Another endpoint is unusable: the connection stays.
+	sdc.closeUnusable(sdc.balancer, uid+1)
+	sdc.Execute(context.Background(), "query", nil, 0)
+	if s.DialCounter != 1 {
+		t.Errorf("DialCounter: %d, want 1", s.DialCounter)
+	}
+
+	// The endpoint of the connection is unusable: it reconnects.
+	sdc.closeUnusable(sdc.balancer, uid)
+	sdc.Execute(context.Background(), "query", nil, 0)
+	if s.DialCounter != 2 {
+		t.Errorf("DialCounter: %d, want 2", s.DialCounter)
+	}
+}
+
 func TestMasterShardConnLife(t *testing.T) {
 	// Do not auto-reconnect for master
 	retryDelay := 10 * time.Millisecond
@@ -789,3 +813,119 @@ func TestMasterShardConnLife(t *testing.T) {
 	}
 	sdc.Close()
 }
+
+func TestShardConnMasterBuffer(t *testing.T) {
+	defer func(w, d time.Duration) {
+		*masterBufferWindow = w
+		masterBufferPollInterval = d
+	}(*masterBufferWindow, masterBufferPollInterval)
+	*masterBufferWindow = 10 * time.Second
+	masterBufferPollInterval = time.Millisecond
+
+	s := createSandbox("TestShardConnMasterBuffer")
+	oldMaster := &sandboxConn{mustFailRetry: 1000}
+	s.MapTestConn("0", oldMaster)
+
+	// The new master shows up in the serving graph later.
+	newMaster := &sandboxConn{}
+	start := time.Now()
+	topoServ := &sandboxTopo{callbackGetEndPoints: func(st *sandboxTopo) {
+		if time.Now().Sub(start) < 50*time.Millisecond || s.TestConns["0"][1] != nil {
+			return
+		}
+		delete(s.TestConns["0"], 0)
+		newMaster.setEndPoint(topo.EndPoint{Uid: 1, Host: "0", NamedPortMap: map[string]int{"vt": 1}})
+		s.TestConns["0"][1] = newMaster
+	}}
+	sdc := NewShardConn(context.Background(), topoServ, "aa", "TestShardConnMasterBuffer", "0", topo.TYPE_MASTER, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+		t.Errorf("Execute: %v", err)
+	}
+	if execCount := oldMaster.ExecCount.Get(); execCount != 1 {
+		t.Errorf("want 1 query on the old master, got %v", execCount)
+	}
+	if execCount := newMaster.ExecCount.Get(); execCount != 1 {
+		t.Errorf("want 1 query on the new master, got %v", execCount)
+	}
+}
+
+// cellSandboxTopo only has endpoints in some cells.
+type cellSandboxTopo struct {
+	sandboxTopo
+	cells map[string]bool
+}
+
+func (st *cellSandboxTopo) GetEndPoints(ctx context.Context, cell, keyspace, shard string, tabletType topo.TabletType) (*topo.EndPoints, int64, error) {
+	if !st.cells[cell] {
+		return nil, -1, fmt.Errorf("no endpoints in cell %v", cell)
+	}
+	return st.sandboxTopo.GetEndPoints(ctx, cell, keyspace, shard, tabletType)
+}
+
+func TestShardConnCellFallback(t *testing.T) {
+	defer func(cells string, life time.Duration) {
+		*cellPreference = cells
+		*fallbackConnLife = life
+	}(*cellPreference, *fallbackConnLife)
+	*cellPreference = "bb,aa,cc"
+	*fallbackConnLife = 50 * time.Millisecond
+
+	s := createSandbox("TestShardConnCellFallback")
+	sbc := &sandboxConn{}
+	s.MapTestConn("0", sbc)
+	serv := &cellSandboxTopo{cells: map[string]bool{"cc": true}}
+	counter := "TestShardConnCellFallback.0.replica.cc"
+	before := crossCellQueries.Counts()[counter]
+
+	// Replica queries are sent to the first fallback cell with endpoints.
+	sdc := NewShardConn(context.Background(), serv, "aa", "TestShardConnCellFallback", "0", topo.TYPE_REPLICA, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+		t.Errorf("Execute: %v", err)
+	}
+	if execCount := sbc.ExecCount.Get(); execCount != 1 {
+		t.Errorf("want 1 query, got %v", execCount)
+	}
+	if got := crossCellQueries.Counts()[counter] - before; got != 1 {
+		t.Errorf("want 1 cross-cell query, got %v", got)
+	}
+
+	// Master queries never leave the local cell.
+	sdc = NewShardConn(context.Background(), serv, "aa", "TestShardConnCellFallback", "0", topo.TYPE_MASTER, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	want := "no endpoints in cell aa"
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("Execute: %v, want %v", err, want)
+	}
+
+	// The local cell is preferred.
+	serv.cells["aa"] = true
+	sdc = NewShardConn(context.Background(), serv, "aa", "TestShardConnCellFallback", "0", topo.TYPE_REPLICA, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+		t.Errorf("Execute: %v", err)
+	}
+	if got := crossCellQueries.Counts()[counter] - before; got != 1 {
+		t.Errorf("want 1 cross-cell query, got %v", got)
+	}
+
+	// The queries go back to the local cell once
+	// the cross-cell connection expires.
+	serv.cells["aa"] = false
+	sdc = NewShardConn(context.Background(), serv, "aa", "TestShardConnCellFallback", "0", topo.TYPE_REPLICA, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, 24*time.Hour, connectTimings)
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+		t.Errorf("Execute: %v", err)
+	}
+	serv.cells["aa"] = true
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+		t.Errorf("Execute: %v", err)
+	}
+	if got := crossCellQueries.Counts()[counter] - before; got != 3 {
+		t.Errorf("want 3 cross-cell queries, got %v", got)
+	}
+	time.Sleep(2 * *fallbackConnLife)
+	if _, err := sdc.Execute(context.Background(), "query", nil, 0); err != nil {
+		t.Errorf("Execute: %v", err)
+	}
+	if got := crossCellQueries.Counts()[counter] - before; got != 3 {
+		t.Errorf("want 3 cross-cell queries, got %v", got)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// Binary defines a vindex that uses the id as the KeyspaceId.
+// It's meant for ids that are already opaque keyspace ids.
+// It's Unique, Reversible and Functional.
+type Binary struct{}
+
+// NewBinary creates a Binary vindex.
+func NewBinary(_ map[string]interface{}) (planbuilder.Vindex, error) {
+	return Binary{}, nil
+}
+
+// Cost returns the cost of this vindex as 0.
+func (Binary) Cost() int {
+	return 0
+}
+
+// Verify returns true if id and ksid match.
+func (Binary) Verify(_ planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) (bool, error) {
+	data, err := getBytes(id)
+	if err != nil {
+		return false, fmt.Errorf("Binary.Verify: %v", err)
+	}
+	return key.KeyspaceId(data) == ksid, nil
+}
+
+// Map returns the corresponding KeyspaceId values for the given ids.
+func (Binary) Map(_ planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
+	out := make([]key.KeyspaceId, 0, len(ids))
+	for _, id := range ids {
+		data, err := getBytes(id)
+		if err != nil {
+			return nil, fmt.Errorf("Binary.Map: %v", err)
+		}
+		out = append(out, key.KeyspaceId(data))
+	}
+	return out, nil
+}
+
+// ReverseMap returns the associated id for the ksid.
+func (Binary) ReverseMap(_ planbuilder.VCursor, ksid key.KeyspaceId) (interface{}, error) {
+	return []byte(ksid), nil
+}
+
+// Create is a no-op because the vindex has no table.
+func (Binary) Create(_ planbuilder.VCursor, _ interface{}) error {
+	return nil
+}
+
+// Delete is a no-op because the vindex has no table.
+func (Binary) Delete(_ planbuilder.VCursor, _ []interface{}, _ key.KeyspaceId) error {
+	return nil
+}
+
+func getBytes(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, fmt.Errorf("unexpected type for %v: %T", v, v)
+}
+
+func init() {
+	planbuilder.Register("binary", NewBinary)
+}
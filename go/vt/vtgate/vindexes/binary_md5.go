@@ -0,0 +1,68 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"crypto/md5"
+	"fmt"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// BinaryMD5 defines a vindex that hashes a binary id to a
+// KeyspaceId by using md5. It's Unique and Functional.
+type BinaryMD5 struct{}
+
+// NewBinaryMD5 creates a BinaryMD5 vindex.
+func NewBinaryMD5(_ map[string]interface{}) (planbuilder.Vindex, error) {
+	return BinaryMD5{}, nil
+}
+
+// Cost returns the cost of this vindex as 1.
+func (BinaryMD5) Cost() int {
+	return 1
+}
+
+// Verify returns true if id maps to ksid.
+func (BinaryMD5) Verify(_ planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) (bool, error) {
+	data, err := getBytes(id)
+	if err != nil {
+		return false, fmt.Errorf("BinaryMD5.Verify: %v", err)
+	}
+	return binHash(data) == ksid, nil
+}
+
+// Map returns the corresponding KeyspaceId values for the given ids.
+func (BinaryMD5) Map(_ planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
+	out := make([]key.KeyspaceId, 0, len(ids))
+	for _, id := range ids {
+		data, err := getBytes(id)
+		if err != nil {
+			return nil, fmt.Errorf("BinaryMD5.Map: %v", err)
+		}
+		out = append(out, binHash(data))
+	}
+	return out, nil
+}
+
+// Create is a no-op because the vindex has no table.
+func (BinaryMD5) Create(_ planbuilder.VCursor, _ interface{}) error {
+	return nil
+}
+
+// Delete is a no-op because the vindex has no table.
+func (BinaryMD5) Delete(_ planbuilder.VCursor, _ []interface{}, _ key.KeyspaceId) error {
+	return nil
+}
+
+func binHash(source []byte) key.KeyspaceId {
+	sum := md5.Sum(source)
+	return key.KeyspaceId(sum[:])
+}
+
+func init() {
+	planbuilder.Register("binary_md5", NewBinaryMD5)
+}
@@ -0,0 +1,66 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var binVindex planbuilder.Vindex
+
+func init() {
+	binVindex, _ = planbuilder.CreateVindex("binary_md5", nil)
+}
+
+func TestBinaryMD5Cost(t *testing.T) {
+	if binVindex.Cost() != 1 {
+		t.Errorf("Cost(): %d, want 1", binVindex.Cost())
+	}
+}
+
+func TestBinaryMD5Map(t *testing.T) {
+	got, err := binVindex.(planbuilder.Unique).Map(nil, []interface{}{[]byte("abc"), "abc"})
+	if err != nil {
+		t.Error(err)
+	}
+	want := []key.KeyspaceId{
+		"\x90\x01P\x98<\xd2O\xb0\xd6\x96?}(\xe1\u007fr",
+		"\x90\x01P\x98<\xd2O\xb0\xd6\x96?}(\xe1\u007fr",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %#v", got, want)
+	}
+}
+
+func TestBinaryMD5MapBadData(t *testing.T) {
+	_, err := binVindex.(planbuilder.Unique).Map(nil, []interface{}{1.1})
+	want := `BinaryMD5.Map: unexpected type for 1.1: float64`
+	if err == nil || err.Error() != want {
+		t.Errorf("binaryMD5.Map: %v, want %v", err, want)
+	}
+}
+
+func TestBinaryMD5Verify(t *testing.T) {
+	success, err := binVindex.Verify(nil, []byte("abc"), "\x90\x01P\x98<\xd2O\xb0\xd6\x96?}(\xe1\u007fr")
+	if err != nil {
+		t.Error(err)
+	}
+	if !success {
+		t.Errorf("Verify(): %+v, want true", success)
+	}
+}
+
+func TestBinaryMD5Create(t *testing.T) {
+	if _, ok := binVindex.(planbuilder.Functional); !ok {
+		t.Errorf("binaryMD5.(planbuilder.Functional): false, want true")
+	}
+	if _, ok := binVindex.(planbuilder.Reversible); ok {
+		t.Errorf("binaryMD5.(planbuilder.Reversible): true, want false")
+	}
+}
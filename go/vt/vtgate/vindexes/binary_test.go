@@ -0,0 +1,70 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+var binOnly planbuilder.Vindex
+
+func init() {
+	binOnly, _ = planbuilder.CreateVindex("binary", nil)
+}
+
+func TestBinaryCost(t *testing.T) {
+	if binOnly.Cost() != 0 {
+		t.Errorf("Cost(): %d, want 0", binOnly.Cost())
+	}
+}
+
+func TestBinaryMap(t *testing.T) {
+	got, err := binOnly.(planbuilder.Unique).Map(nil, []interface{}{[]byte("\x00\x01"), "abc"})
+	if err != nil {
+		t.Error(err)
+	}
+	want := []key.KeyspaceId{"\x00\x01", "abc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %+v", got, want)
+	}
+}
+
+func TestBinaryMapBadData(t *testing.T) {
+	_, err := binOnly.(planbuilder.Unique).Map(nil, []interface{}{1})
+	want := `Binary.Map: unexpected type for 1: int`
+	if err == nil || err.Error() != want {
+		t.Errorf("binary.Map: %v, want %v", err, want)
+	}
+}
+
+func TestBinaryVerify(t *testing.T) {
+	success, err := binOnly.Verify(nil, []byte("\x00\x01"), "\x00\x01")
+	if err != nil {
+		t.Error(err)
+	}
+	if !success {
+		t.Errorf("Verify(): %+v, want true", success)
+	}
+}
+
+func TestBinaryReverseMap(t *testing.T) {
+	got, err := binOnly.(planbuilder.Reversible).ReverseMap(nil, "\x00\x01")
+	if err != nil {
+		t.Error(err)
+	}
+	if want := []byte("\x00\x01"); !reflect.DeepEqual(got, want) {
+		t.Errorf("ReverseMap(): %#v, want %#v", got, want)
+	}
+}
+
+func TestBinaryCreate(t *testing.T) {
+	if _, ok := binOnly.(planbuilder.Functional); !ok {
+		t.Errorf("binary.(planbuilder.Functional): false, want true")
+	}
+}
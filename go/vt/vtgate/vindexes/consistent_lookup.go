@@ -0,0 +1,348 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"fmt"
+	"strings"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+func init() {
+	planbuilder.Register("consistent_lookup", NewConsistentLookup)
+	planbuilder.Register("consistent_lookup_unique", NewConsistentLookupUnique)
+}
+
+// errDupKey is the MySQL error returned when a
+// row violates a unique key.
+const errDupKey = "errno 1062"
+
+//====================================================================
+
+// ConsistentLookup defines a vindex that uses a lookup table.
+// The table is expected to define the (id, ksid) columns as unique.
+// It's NonUnique and a Lookup. Unlike LookupHash, the entries
+// are created in a transaction that's committed before the one of
+// the owner table, and deleted in one that's committed after it.
+// So, a failed commit can leave extra entries, but never missing
+// ones. Map ignores the extra entries by checking that the owner
+// table has a row for the id at the keyspace id.
+type ConsistentLookup struct {
+	clkp clookup
+}
+
+// NewConsistentLookup creates a ConsistentLookup vindex.
+func NewConsistentLookup(m map[string]interface{}) (planbuilder.Vindex, error) {
+	cl := &ConsistentLookup{}
+	cl.clkp.Init(m)
+	return cl, nil
+}
+
+// Cost returns the cost of this vindex as 20.
+func (vind *ConsistentLookup) Cost() int {
+	return 20
+}
+
+// Map returns the corresponding KeyspaceId values for the given ids.
+func (vind *ConsistentLookup) Map(vcursor planbuilder.VCursor, ids []interface{}) ([][]key.KeyspaceId, error) {
+	return vind.clkp.Map2(vcursor, ids)
+}
+
+// Verify returns true if id maps to ksid.
+func (vind *ConsistentLookup) Verify(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) (bool, error) {
+	return vind.clkp.Verify(vcursor, id, ksid)
+}
+
+// Create reserves the id by inserting it into the vindex table
+// before the owner row is committed. An entry that already exists
+// for the same id and ksid is left as is.
+func (vind *ConsistentLookup) Create(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) error {
+	return vind.clkp.Create(vcursor, id, ksid, false)
+}
+
+// Delete deletes the entry from the vindex table
+// after the owner row is committed.
+func (vind *ConsistentLookup) Delete(vcursor planbuilder.VCursor, ids []interface{}, ksid key.KeyspaceId) error {
+	return vind.clkp.Delete(vcursor, ids, ksid)
+}
+
+// SetOwner sets the table that owns the vindex.
+func (vind *ConsistentLookup) SetOwner(keyspace, table, col string) {
+	vind.clkp.SetOwner(keyspace, table, col)
+}
+
+//====================================================================
+
+// ConsistentLookupUnique defines a vindex that uses a lookup table.
+// The table is expected to define the id column as unique. It's
+// Unique and a Lookup. It behaves like ConsistentLookup, except
+// that Create takes over an existing entry for the id if the owner
+// table doesn't have a row for it.
+type ConsistentLookupUnique struct {
+	clkp clookup
+}
+
+// NewConsistentLookupUnique creates a ConsistentLookupUnique vindex.
+func NewConsistentLookupUnique(m map[string]interface{}) (planbuilder.Vindex, error) {
+	clu := &ConsistentLookupUnique{}
+	clu.clkp.Init(m)
+	return clu, nil
+}
+
+// Cost returns the cost of this vindex as 10.
+func (vind *ConsistentLookupUnique) Cost() int {
+	return 10
+}
+
+// Map returns the corresponding KeyspaceId values for the given ids.
+func (vind *ConsistentLookupUnique) Map(vcursor planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
+	return vind.clkp.Map1(vcursor, ids)
+}
+
+// Verify returns true if id maps to ksid.
+func (vind *ConsistentLookupUnique) Verify(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) (bool, error) {
+	return vind.clkp.Verify(vcursor, id, ksid)
+}
+
+// Create reserves the id by inserting it into the vindex table
+// before the owner row is committed. If there's already an entry
+// for the id, it's taken over unless the owner row still exists.
+func (vind *ConsistentLookupUnique) Create(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) error {
+	return vind.clkp.Create(vcursor, id, ksid, true)
+}
+
+// Delete deletes the entry from the vindex table
+// after the owner row is committed.
+func (vind *ConsistentLookupUnique) Delete(vcursor planbuilder.VCursor, ids []interface{}, ksid key.KeyspaceId) error {
+	return vind.clkp.Delete(vcursor, ids, ksid)
+}
+
+// SetOwner sets the table that owns the vindex.
+func (vind *ConsistentLookupUnique) SetOwner(keyspace, table, col string) {
+	vind.clkp.SetOwner(keyspace, table, col)
+}
+
+//====================================================================
+
+// clookup implements the functions for the consistent Lookup vindexes.
+// The lookup queries are the same as the ones of lookup. The owner
+// queries are sent directly to the shard of the keyspace id.
+type clookup struct {
+	lookup
+	Keyspace, Owner, OwnerCol string
+	ownerIn, ownerLock, upd   string
+}
+
+// SetOwner builds the owner queries.
+func (clkp *clookup) SetOwner(keyspace, table, col string) {
+	clkp.Keyspace = keyspace
+	clkp.Owner = table
+	clkp.OwnerCol = col
+	clkp.ownerIn = fmt.Sprintf("select %s from %s where %s in ::%s", col, table, col, col)
+	clkp.ownerLock = fmt.Sprintf("select %s from %s where %s = :%s limit 1 for update", col, table, col, col)
+	clkp.upd = fmt.Sprintf("update %s set %s = :%s where %s = :%s", clkp.Table, clkp.To, clkp.To, clkp.From, clkp.From)
+}
+
+// Map1 is for a unique vindex.
+func (clkp *clookup) Map1(vcursor planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
+	candidates, err := clkp.candidates(vcursor, ids)
+	if err != nil {
+		return nil, fmt.Errorf("consistent_lookup.Map: %v", err)
+	}
+	out := make([]key.KeyspaceId, 0, len(ids))
+	for i, ksids := range candidates {
+		if len(ksids) > 1 {
+			return nil, fmt.Errorf("consistent_lookup.Map: unexpected multiple results from vindex %s: %v", clkp.Table, ids[i])
+		}
+		if len(ksids) == 0 {
+			out = append(out, "")
+			continue
+		}
+		out = append(out, ksids[0])
+	}
+	return out, nil
+}
+
+// Map2 is for a non-unique vindex.
+func (clkp *clookup) Map2(vcursor planbuilder.VCursor, ids []interface{}) ([][]key.KeyspaceId, error) {
+	candidates, err := clkp.candidates(vcursor, ids)
+	if err != nil {
+		return nil, fmt.Errorf("consistent_lookup.Map: %v", err)
+	}
+	return candidates, nil
+}
+
+// candidates returns, for each id, the keyspace ids of its lookup
+// entries that have a matching row in the owner table. The owner
+// rows of all the ids are fetched with one query per shard.
+func (clkp *clookup) candidates(vcursor planbuilder.VCursor, ids []interface{}) ([][]key.KeyspaceId, error) {
+	if clkp.Owner == "" {
+		return nil, fmt.Errorf("vindex %s has no owner", clkp.Table)
+	}
+	entries := make([][]key.KeyspaceId, 0, len(ids))
+	var allKsids []key.KeyspaceId
+	for _, id := range ids {
+		result, err := vcursor.Execute(&tproto.BoundQuery{
+			Sql: clkp.sel,
+			BindVariables: map[string]interface{}{
+				clkp.From: id,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		var ksids []key.KeyspaceId
+		for _, row := range result.Rows {
+			inum, err := mproto.Convert(result.Fields[0], row[0])
+			if err != nil {
+				return nil, err
+			}
+			num, err := getNumber(inum)
+			if err != nil {
+				return nil, err
+			}
+			ksids = append(ksids, vhash(num))
+		}
+		entries = append(entries, ksids)
+		allKsids = append(allKsids, ksids...)
+	}
+	out := make([][]key.KeyspaceId, len(ids))
+	if len(allKsids) == 0 {
+		return out, nil
+	}
+	results, err := vcursor.ExecuteKeyspaceIds(clkp.Keyspace, allKsids, &tproto.BoundQuery{
+		Sql: clkp.ownerIn,
+		BindVariables: map[string]interface{}{
+			clkp.OwnerCol: ids,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	next := 0
+	for i, ksids := range entries {
+		idVal, err := sqltypes.BuildValue(ids[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, ksid := range ksids {
+			if hasValue(results[next], idVal) {
+				out[i] = append(out[i], ksid)
+			}
+			next++
+		}
+	}
+	return out, nil
+}
+
+// hasValue returns true if the first column of
+// one of the rows of result is val.
+func hasValue(result *mproto.QueryResult, val sqltypes.Value) bool {
+	for _, row := range result.Rows {
+		if row[0].String() == val.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// ownerExists returns true if the owner table has
+// a row for id in the shard of ksid.
+func (clkp *clookup) ownerExists(vcursor planbuilder.VCursor, sql string, id interface{}, ksid key.KeyspaceId) (bool, error) {
+	result, err := vcursor.ExecuteKeyspaceId(clkp.Keyspace, ksid, &tproto.BoundQuery{
+		Sql: sql,
+		BindVariables: map[string]interface{}{
+			clkp.OwnerCol: id,
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return len(result.Rows) != 0, nil
+}
+
+// Create inserts the entry in the pre-commit transaction. If the
+// insert fails with a duplicate key, the entry is either already
+// there or, for a unique vindex, belongs to another keyspace id.
+// In the second case, the entry is taken over if the owner table
+// doesn't have a row for the id at that keyspace id.
+func (clkp *clookup) Create(vcursor planbuilder.VCursor, id interface{}, ksid key.KeyspaceId, unique bool) error {
+	val, err := vunhash(ksid)
+	if err != nil {
+		return fmt.Errorf("consistent_lookup.Create: %v", err)
+	}
+	bindVars := map[string]interface{}{
+		clkp.From: id,
+		clkp.To:   val,
+	}
+	_, err = vcursor.ExecutePre(&tproto.BoundQuery{Sql: clkp.ins, BindVariables: bindVars})
+	if err == nil {
+		return nil
+	}
+	if !strings.Contains(err.Error(), errDupKey) {
+		return fmt.Errorf("consistent_lookup.Create: %v", err)
+	}
+	if !unique {
+		return nil
+	}
+	result, err := vcursor.ExecutePre(&tproto.BoundQuery{
+		Sql: clkp.sel + " for update",
+		BindVariables: map[string]interface{}{
+			clkp.From: id,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("consistent_lookup.Create: %v", err)
+	}
+	if len(result.Rows) != 1 {
+		return fmt.Errorf("consistent_lookup.Create: unexpected number of rows from vindex %s for %v: %d", clkp.Table, id, len(result.Rows))
+	}
+	inum, err := mproto.Convert(result.Fields[0], result.Rows[0][0])
+	if err != nil {
+		return fmt.Errorf("consistent_lookup.Create: %v", err)
+	}
+	num, err := getNumber(inum)
+	if err != nil {
+		return fmt.Errorf("consistent_lookup.Create: %v", err)
+	}
+	if num == val {
+		return nil
+	}
+	exists, err := clkp.ownerExists(vcursor, clkp.ownerLock, id, vhash(num))
+	if err != nil {
+		return fmt.Errorf("consistent_lookup.Create: %v", err)
+	}
+	if exists {
+		return fmt.Errorf("consistent_lookup.Create: duplicate entry %v for vindex %s", id, clkp.Table)
+	}
+	if _, err := vcursor.ExecutePre(&tproto.BoundQuery{Sql: clkp.upd, BindVariables: bindVars}); err != nil {
+		return fmt.Errorf("consistent_lookup.Create: %v", err)
+	}
+	return nil
+}
+
+// Delete deletes the entries in the post-commit transaction.
+func (clkp *clookup) Delete(vcursor planbuilder.VCursor, ids []interface{}, ksid key.KeyspaceId) error {
+	val, err := vunhash(ksid)
+	if err != nil {
+		return fmt.Errorf("consistent_lookup.Delete: %v", err)
+	}
+	bq := &tproto.BoundQuery{
+		Sql: clkp.del,
+		BindVariables: map[string]interface{}{
+			clkp.From: ids,
+			clkp.To:   val,
+		},
+	}
+	if _, err := vcursor.ExecutePost(bq); err != nil {
+		return fmt.Errorf("consistent_lookup.Delete: %v", err)
+	}
+	return nil
+}
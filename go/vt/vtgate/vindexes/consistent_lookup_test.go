@@ -0,0 +1,318 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	mproto "github.com/youtube/vitess/go/mysql/proto"
+	"github.com/youtube/vitess/go/sqltypes"
+	"github.com/youtube/vitess/go/vt/key"
+	tproto "github.com/youtube/vitess/go/vt/tabletserver/proto"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// clvcursor logs the queries along with the function that
+// executed them, and returns the results in order.
+type clvcursor struct {
+	log     []string
+	results []*mproto.QueryResult
+	errs    []error
+}
+
+func (vc *clvcursor) next(name string, query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	vc.log = append(vc.log, fmt.Sprintf("%s: %s %v", name, query.Sql, query.BindVariables))
+	return vc.pop()
+}
+
+func (vc *clvcursor) pop() (*mproto.QueryResult, error) {
+	var result *mproto.QueryResult
+	var err error
+	if len(vc.results) != 0 {
+		result, vc.results = vc.results[0], vc.results[1:]
+	}
+	if len(vc.errs) != 0 {
+		err, vc.errs = vc.errs[0], vc.errs[1:]
+	}
+	if result == nil {
+		result = &mproto.QueryResult{}
+	}
+	return result, err
+}
+
+func (vc *clvcursor) Execute(query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return vc.next("Execute", query)
+}
+
+func (vc *clvcursor) ExecutePre(query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return vc.next("ExecutePre", query)
+}
+
+func (vc *clvcursor) ExecutePost(query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return vc.next("ExecutePost", query)
+}
+
+func (vc *clvcursor) ExecuteKeyspaceId(keyspace string, ksid key.KeyspaceId, query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return vc.next(fmt.Sprintf("ExecuteKeyspaceId(%s, %x)", keyspace, string(ksid)), query)
+}
+
+// ExecuteKeyspaceIds returns the next result for each keyspace id,
+// as if each of them was in a different shard.
+func (vc *clvcursor) ExecuteKeyspaceIds(keyspace string, ksids []key.KeyspaceId, query *tproto.BoundQuery) ([]*mproto.QueryResult, error) {
+	hex := make([]string, 0, len(ksids))
+	for _, ksid := range ksids {
+		hex = append(hex, fmt.Sprintf("%x", string(ksid)))
+	}
+	result, err := vc.next(fmt.Sprintf("ExecuteKeyspaceIds(%s, %v)", keyspace, hex), query)
+	if err != nil {
+		return nil, err
+	}
+	results := []*mproto.QueryResult{result}
+	for len(results) < len(ksids) {
+		result, err := vc.pop()
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func numResult(vals ...int64) *mproto.QueryResult {
+	result := &mproto.QueryResult{
+		Fields: []mproto.Field{{
+			Type: mproto.VT_LONGLONG,
+		}},
+		RowsAffected: uint64(len(vals)),
+	}
+	for _, val := range vals {
+		result.Rows = append(result.Rows, []sqltypes.Value{
+			sqltypes.MakeNumeric([]byte(fmt.Sprintf("%d", val))),
+		})
+	}
+	return result
+}
+
+func createConsistentLookup(t *testing.T, name string) planbuilder.Vindex {
+	cl, err := planbuilder.CreateVindex(name, map[string]interface{}{"Table": "t", "From": "fromc", "To": "toc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl.(planbuilder.OwnerAware).SetOwner("ks", "owner", "col")
+	return cl
+}
+
+func TestConsistentLookupCost(t *testing.T) {
+	cl := createConsistentLookup(t, "consistent_lookup")
+	if cl.Cost() != 20 {
+		t.Errorf("Cost(): %d, want 20", cl.Cost())
+	}
+	clu := createConsistentLookup(t, "consistent_lookup_unique")
+	if clu.Cost() != 10 {
+		t.Errorf("Cost(): %d, want 10", clu.Cost())
+	}
+}
+
+func TestConsistentLookupMap(t *testing.T) {
+	cl := createConsistentLookup(t, "consistent_lookup")
+	vc := &clvcursor{
+		results: []*mproto.QueryResult{
+			numResult(1, 2),
+			numResult(1),
+			nil,
+		},
+	}
+	got, err := cl.(planbuilder.NonUnique).Map(vc, []interface{}{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]key.KeyspaceId{{
+		"\x16k@\xb4J\xbaK\xd6",
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %#v", got, want)
+	}
+	wantLog := []string{
+		"Execute: select toc from t where fromc = :fromc map[fromc:1]",
+		"ExecuteKeyspaceIds(ks, [166b40b44aba4bd6 06e7ea22ce92708f]): select col from owner where col in ::col map[col:[1]]",
+	}
+	if !reflect.DeepEqual(vc.log, wantLog) {
+		t.Errorf("log:\n%s, want\n%s", strings.Join(vc.log, "\n"), strings.Join(wantLog, "\n"))
+	}
+
+	vc = &clvcursor{errs: []error{errors.New("execute failed")}}
+	_, err = cl.(planbuilder.NonUnique).Map(vc, []interface{}{1})
+	wantErr := "consistent_lookup.Map: execute failed"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("Map(): %v, want %s", err, wantErr)
+	}
+}
+
+func TestConsistentLookupUniqueMap(t *testing.T) {
+	clu := createConsistentLookup(t, "consistent_lookup_unique")
+	vc := &clvcursor{
+		results: []*mproto.QueryResult{
+			numResult(1),
+			numResult(2),
+			nil,
+			numResult(2),
+		},
+	}
+	got, err := clu.(planbuilder.Unique).Map(vc, []interface{}{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []key.KeyspaceId{"", "\x06\xe7\xea\"Βp\x8f"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %#v", got, want)
+	}
+	wantLog := []string{
+		"Execute: select toc from t where fromc = :fromc map[fromc:1]",
+		"Execute: select toc from t where fromc = :fromc map[fromc:2]",
+		"ExecuteKeyspaceIds(ks, [166b40b44aba4bd6 06e7ea22ce92708f]): select col from owner where col in ::col map[col:[1 2]]",
+	}
+	if !reflect.DeepEqual(vc.log, wantLog) {
+		t.Errorf("log:\n%s, want\n%s", strings.Join(vc.log, "\n"), strings.Join(wantLog, "\n"))
+	}
+
+	vc = &clvcursor{
+		results: []*mproto.QueryResult{
+			numResult(1, 2),
+			numResult(1),
+			numResult(1),
+		},
+	}
+	_, err = clu.(planbuilder.Unique).Map(vc, []interface{}{1})
+	wantErr := "consistent_lookup.Map: unexpected multiple results from vindex t: 1"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("Map(): %v, want %s", err, wantErr)
+	}
+}
+
+func TestConsistentLookupNoOwner(t *testing.T) {
+	cl, err := planbuilder.CreateVindex("consistent_lookup", map[string]interface{}{"Table": "t", "From": "fromc", "To": "toc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cl.(planbuilder.NonUnique).Map(&clvcursor{}, []interface{}{1})
+	want := "consistent_lookup.Map: vindex t has no owner"
+	if err == nil || err.Error() != want {
+		t.Errorf("Map(): %v, want %s", err, want)
+	}
+}
+
+func TestConsistentLookupVerify(t *testing.T) {
+	cl := createConsistentLookup(t, "consistent_lookup")
+	vc := &clvcursor{results: []*mproto.QueryResult{numResult(1)}}
+	success, err := cl.Verify(vc, 1, "\x16k@\xb4J\xbaK\xd6")
+	if err != nil {
+		t.Error(err)
+	}
+	if !success {
+		t.Errorf("Verify(): %+v, want true", success)
+	}
+}
+
+func TestConsistentLookupCreate(t *testing.T) {
+	cl := createConsistentLookup(t, "consistent_lookup")
+	vc := &clvcursor{}
+	if err := cl.(planbuilder.Lookup).Create(vc, 1, "\x16k@\xb4J\xbaK\xd6"); err != nil {
+		t.Error(err)
+	}
+	wantLog := []string{
+		"ExecutePre: insert into t(fromc, toc) values(:fromc, :toc) map[fromc:1 toc:1]",
+	}
+	if !reflect.DeepEqual(vc.log, wantLog) {
+		t.Errorf("log:\n%s, want\n%s", strings.Join(vc.log, "\n"), strings.Join(wantLog, "\n"))
+	}
+
+	// A duplicate entry for the same keyspace id is ignored.
+	vc = &clvcursor{errs: []error{errors.New("errno 1062: duplicate entry")}}
+	if err := cl.(planbuilder.Lookup).Create(vc, 1, "\x16k@\xb4J\xbaK\xd6"); err != nil {
+		t.Error(err)
+	}
+
+	vc = &clvcursor{errs: []error{errors.New("execute failed")}}
+	err := cl.(planbuilder.Lookup).Create(vc, 1, "\x16k@\xb4J\xbaK\xd6")
+	want := "consistent_lookup.Create: execute failed"
+	if err == nil || err.Error() != want {
+		t.Errorf("Create(): %v, want %s", err, want)
+	}
+}
+
+func TestConsistentLookupUniqueCreate(t *testing.T) {
+	clu := createConsistentLookup(t, "consistent_lookup_unique")
+
+	// The existing entry is stale: it's taken over.
+	vc := &clvcursor{
+		results: []*mproto.QueryResult{
+			nil,
+			numResult(2),
+			nil,
+			nil,
+		},
+		errs: []error{errors.New("errno 1062: duplicate entry")},
+	}
+	if err := clu.(planbuilder.Lookup).Create(vc, 1, "\x16k@\xb4J\xbaK\xd6"); err != nil {
+		t.Error(err)
+	}
+	wantLog := []string{
+		"ExecutePre: insert into t(fromc, toc) values(:fromc, :toc) map[fromc:1 toc:1]",
+		"ExecutePre: select toc from t where fromc = :fromc for update map[fromc:1]",
+		"ExecuteKeyspaceId(ks, 06e7ea22ce92708f): select col from owner where col = :col limit 1 for update map[col:1]",
+		"ExecutePre: update t set toc = :toc where fromc = :fromc map[fromc:1 toc:1]",
+	}
+	if !reflect.DeepEqual(vc.log, wantLog) {
+		t.Errorf("log:\n%s, want\n%s", strings.Join(vc.log, "\n"), strings.Join(wantLog, "\n"))
+	}
+
+	// The existing entry is for the same keyspace id.
+	vc = &clvcursor{
+		results: []*mproto.QueryResult{
+			nil,
+			numResult(1),
+		},
+		errs: []error{errors.New("errno 1062: duplicate entry")},
+	}
+	if err := clu.(planbuilder.Lookup).Create(vc, 1, "\x16k@\xb4J\xbaK\xd6"); err != nil {
+		t.Error(err)
+	}
+	if len(vc.log) != 2 {
+		t.Errorf("log:\n%s, want 2 queries", strings.Join(vc.log, "\n"))
+	}
+
+	// The owner row of the existing entry still exists.
+	vc = &clvcursor{
+		results: []*mproto.QueryResult{
+			nil,
+			numResult(2),
+			numResult(1),
+		},
+		errs: []error{errors.New("errno 1062: duplicate entry")},
+	}
+	err := clu.(planbuilder.Lookup).Create(vc, 1, "\x16k@\xb4J\xbaK\xd6")
+	want := "consistent_lookup.Create: duplicate entry 1 for vindex t"
+	if err == nil || err.Error() != want {
+		t.Errorf("Create(): %v, want %s", err, want)
+	}
+}
+
+func TestConsistentLookupDelete(t *testing.T) {
+	cl := createConsistentLookup(t, "consistent_lookup")
+	vc := &clvcursor{}
+	if err := cl.(planbuilder.Lookup).Delete(vc, []interface{}{1}, "\x16k@\xb4J\xbaK\xd6"); err != nil {
+		t.Error(err)
+	}
+	wantLog := []string{
+		"ExecutePost: delete from t where fromc in ::fromc and toc = :toc map[fromc:[1] toc:1]",
+	}
+	if !reflect.DeepEqual(vc.log, wantLog) {
+		t.Errorf("log:\n%s, want\n%s", strings.Join(vc.log, "\n"), strings.Join(wantLog, "\n"))
+	}
+}
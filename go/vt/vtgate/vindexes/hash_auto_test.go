@@ -168,6 +168,22 @@ func (vc *vcursor) Execute(query *tproto.BoundQuery) (*mproto.QueryResult, error
 	panic("unexpected")
 }
 
+func (vc *vcursor) ExecutePre(query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return vc.Execute(query)
+}
+
+func (vc *vcursor) ExecutePost(query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return vc.Execute(query)
+}
+
+func (vc *vcursor) ExecuteKeyspaceId(keyspace string, ksid key.KeyspaceId, query *tproto.BoundQuery) (*mproto.QueryResult, error) {
+	return vc.Execute(query)
+}
+
+func (vc *vcursor) ExecuteKeyspaceIds(keyspace string, ksids []key.KeyspaceId, query *tproto.BoundQuery) ([]*mproto.QueryResult, error) {
+	panic("unexpected")
+}
+
 func TestHashAutoCreate(t *testing.T) {
 	vc := &vcursor{}
 	err := hashAuto.(planbuilder.Functional).Create(vc, 1)
@@ -5,6 +5,7 @@
 package vindexes
 
 import (
+	"bytes"
 	"fmt"
 
 	mproto "github.com/youtube/vitess/go/mysql/proto"
@@ -56,6 +57,11 @@ func (vind *LookupHash) Create(vcursor planbuilder.VCursor, id interface{}, ksid
 	return vind.lkp.Create(vcursor, id, ksid)
 }
 
+// BatchCreate reserves the ids by inserting them into the vindex table.
+func (vind *LookupHash) BatchCreate(vcursor planbuilder.VCursor, ids []interface{}, ksids []key.KeyspaceId) error {
+	return vind.lkp.BatchCreate(vcursor, ids, ksids)
+}
+
 // Delete deletes the entry from the vindex table.
 func (vind *LookupHash) Delete(vcursor planbuilder.VCursor, ids []interface{}, ksid key.KeyspaceId) error {
 	return vind.lkp.Delete(vcursor, ids, ksid)
@@ -98,6 +104,11 @@ func (vind *LookupHashAuto) Create(vcursor planbuilder.VCursor, id interface{},
 	return vind.lkp.Create(vcursor, id, ksid)
 }
 
+// BatchCreate reserves the ids by inserting them into the vindex table.
+func (vind *LookupHashAuto) BatchCreate(vcursor planbuilder.VCursor, ids []interface{}, ksids []key.KeyspaceId) error {
+	return vind.lkp.BatchCreate(vcursor, ids, ksids)
+}
+
 // Generate reserves the id by inserting it into the vindex table.
 func (vind *LookupHashAuto) Generate(vcursor planbuilder.VCursor, ksid key.KeyspaceId) (id int64, err error) {
 	return vind.lkp.Generate(vcursor, ksid)
@@ -144,6 +155,11 @@ func (vind *LookupHashUnique) Create(vcursor planbuilder.VCursor, id interface{}
 	return vind.lkp.Create(vcursor, id, ksid)
 }
 
+// BatchCreate reserves the ids by inserting them into the vindex table.
+func (vind *LookupHashUnique) BatchCreate(vcursor planbuilder.VCursor, ids []interface{}, ksids []key.KeyspaceId) error {
+	return vind.lkp.BatchCreate(vcursor, ids, ksids)
+}
+
 // Delete deletes the entry from the vindex table.
 func (vind *LookupHashUnique) Delete(vcursor planbuilder.VCursor, ids []interface{}, ksid key.KeyspaceId) error {
 	return vind.lkp.Delete(vcursor, ids, ksid)
@@ -186,6 +202,11 @@ func (vind *LookupHashUniqueAuto) Create(vcursor planbuilder.VCursor, id interfa
 	return vind.lkp.Create(vcursor, id, ksid)
 }
 
+// BatchCreate reserves the ids by inserting them into the vindex table.
+func (vind *LookupHashUniqueAuto) BatchCreate(vcursor planbuilder.VCursor, ids []interface{}, ksids []key.KeyspaceId) error {
+	return vind.lkp.BatchCreate(vcursor, ids, ksids)
+}
+
 // Generate reserves the id by inserting it into the vindex table.
 func (vind *LookupHashUniqueAuto) Generate(vcursor planbuilder.VCursor, ksid key.KeyspaceId) (id int64, err error) {
 	return vind.lkp.Generate(vcursor, ksid)
@@ -329,6 +350,39 @@ func (lkp *lookup) Create(vcursor planbuilder.VCursor, id interface{}, ksid key.
 	return nil
 }
 
+// BatchCreate creates the associations between ids and ksids by
+// inserting all the rows in the vindex table with one statement.
+func (lkp *lookup) BatchCreate(vcursor planbuilder.VCursor, ids []interface{}, ksids []key.KeyspaceId) error {
+	if len(ids) != len(ksids) {
+		return fmt.Errorf("lookup.BatchCreate: got %d ids for %d keyspace ids", len(ids), len(ksids))
+	}
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "insert into %s(%s, %s) values", lkp.Table, lkp.From, lkp.To)
+	bindVars := make(map[string]interface{}, 2*len(ids))
+	for i, id := range ids {
+		val, err := vunhash(ksids[i])
+		if err != nil {
+			return fmt.Errorf("lookup.BatchCreate: %v", err)
+		}
+		if i != 0 {
+			buf.WriteString(",")
+		}
+		fromVar := fmt.Sprintf("from_%d", i)
+		toVar := fmt.Sprintf("to_%d", i)
+		fmt.Fprintf(buf, " (:%s, :%s)", fromVar, toVar)
+		bindVars[fromVar] = id
+		bindVars[toVar] = val
+	}
+	bq := &tproto.BoundQuery{
+		Sql:           buf.String(),
+		BindVariables: bindVars,
+	}
+	if _, err := vcursor.Execute(bq); err != nil {
+		return fmt.Errorf("lookup.BatchCreate: %v", err)
+	}
+	return nil
+}
+
 // Generate generates an id and associates the ksid to the new id.
 func (lkp *lookup) Generate(vcursor planbuilder.VCursor, ksid key.KeyspaceId) (id int64, err error) {
 	val, err := vunhash(ksid)
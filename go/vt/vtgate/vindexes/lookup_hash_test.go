@@ -76,6 +76,54 @@ func TestLookupHashCreate(t *testing.T) {
 	}
 }
 
+func TestLookupHashBatchCreate(t *testing.T) {
+	vc := &vcursor{}
+	err := lhm.(planbuilder.BatchLookup).BatchCreate(vc, []interface{}{1, 2}, []key.KeyspaceId{"\x16k@\xb4J\xbaK\xd6", "\x06\xe7\xea\"Βp\x8f"})
+	if err != nil {
+		t.Error(err)
+	}
+	wantQuery := &tproto.BoundQuery{
+		Sql: "insert into t(fromc, toc) values (:from_0, :to_0), (:from_1, :to_1)",
+		BindVariables: map[string]interface{}{
+			"from_0": 1,
+			"to_0":   int64(1),
+			"from_1": 2,
+			"to_1":   int64(2),
+		},
+	}
+	if !reflect.DeepEqual(vc.query, wantQuery) {
+		t.Errorf("vc.query = %#v, want %#v", vc.query, wantQuery)
+	}
+
+	err = lhm.(planbuilder.BatchLookup).BatchCreate(vc, []interface{}{1, 2}, []key.KeyspaceId{"\x16k@\xb4J\xbaK\xd6"})
+	want := "lookup.BatchCreate: got 2 ids for 1 keyspace ids"
+	if err == nil || err.Error() != want {
+		t.Errorf("BatchCreate(): %v, want %s", err, want)
+	}
+}
+
+func TestLookupHashBatchCreateColumnNames(t *testing.T) {
+	// The bind vars of column col in row 10 and of column col1
+	// in row 0 must not collide.
+	h, err := planbuilder.CreateVindex("lookup_hash", map[string]interface{}{"Table": "t", "From": "col", "To": "col1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]interface{}, 11)
+	ksids := make([]key.KeyspaceId, 11)
+	for i := range ids {
+		ids[i] = i + 1
+		ksids[i] = "\x16k@\xb4J\xbaK\xd6"
+	}
+	vc := &vcursor{}
+	if err := h.(planbuilder.BatchLookup).BatchCreate(vc, ids, ksids); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(vc.query.BindVariables), 22; got != want {
+		t.Errorf("len(BindVariables) = %d, want %d", got, want)
+	}
+}
+
 func TestLookupHashGenerate(t *testing.T) {
 	_, ok := lhm.(planbuilder.LookupGenerator)
 	if ok {
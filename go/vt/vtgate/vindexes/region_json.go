@@ -0,0 +1,116 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+// RegionJSON defines a vindex that pins rows to a region. The id
+// is a string of the form "<region>:<number>". The KeyspaceId is the
+// prefix byte of the region followed by the 3DES hash of the number.
+// Shards can therefore be assigned to geographic regions by keyspace
+// id ranges. The prefix bytes of the regions are read from the JSON
+// file specified by the RegionMap param. It's Unique and Functional.
+type RegionJSON struct {
+	RegionMap string
+	regions   map[string]byte
+}
+
+// NewRegionJSON creates a RegionJSON vindex.
+func NewRegionJSON(m map[string]interface{}) (planbuilder.Vindex, error) {
+	rmap, _ := m["RegionMap"].(string)
+	if rmap == "" {
+		return nil, fmt.Errorf("NewRegionJSON: RegionMap param is required")
+	}
+	data, err := ioutil.ReadFile(rmap)
+	if err != nil {
+		return nil, fmt.Errorf("NewRegionJSON: %v", err)
+	}
+	var values map[string]int
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("NewRegionJSON: %s: %v", rmap, err)
+	}
+	regions := make(map[string]byte, len(values))
+	for region, v := range values {
+		if v < 0 || v > 255 {
+			return nil, fmt.Errorf("NewRegionJSON: %s: prefix %d for region %s is out of range", rmap, v, region)
+		}
+		regions[region] = byte(v)
+	}
+	return &RegionJSON{
+		RegionMap: rmap,
+		regions:   regions,
+	}, nil
+}
+
+// Cost returns the cost of this vindex as 1.
+func (vind *RegionJSON) Cost() int {
+	return 1
+}
+
+// Verify returns true if id maps to ksid.
+func (vind *RegionJSON) Verify(_ planbuilder.VCursor, id interface{}, ksid key.KeyspaceId) (bool, error) {
+	k, err := vind.regionHash(id)
+	if err != nil {
+		return false, fmt.Errorf("RegionJSON.Verify: %v", err)
+	}
+	return k == ksid, nil
+}
+
+// Map returns the corresponding KeyspaceId values for the given ids.
+func (vind *RegionJSON) Map(_ planbuilder.VCursor, ids []interface{}) ([]key.KeyspaceId, error) {
+	out := make([]key.KeyspaceId, 0, len(ids))
+	for _, id := range ids {
+		k, err := vind.regionHash(id)
+		if err != nil {
+			return nil, fmt.Errorf("RegionJSON.Map: %v", err)
+		}
+		out = append(out, k)
+	}
+	return out, nil
+}
+
+// Create is a no-op because the vindex has no table.
+func (vind *RegionJSON) Create(_ planbuilder.VCursor, _ interface{}) error {
+	return nil
+}
+
+// Delete is a no-op because the vindex has no table.
+func (vind *RegionJSON) Delete(_ planbuilder.VCursor, _ []interface{}, _ key.KeyspaceId) error {
+	return nil
+}
+
+func (vind *RegionJSON) regionHash(id interface{}) (key.KeyspaceId, error) {
+	data, err := getBytes(id)
+	if err != nil {
+		return "", err
+	}
+	sid := string(data)
+	i := strings.LastIndex(sid, ":")
+	if i < 0 {
+		return "", fmt.Errorf("invalid region id: %s", sid)
+	}
+	prefix, ok := vind.regions[sid[:i]]
+	if !ok {
+		return "", fmt.Errorf("unknown region: %s", sid[:i])
+	}
+	num, err := strconv.ParseInt(sid[i+1:], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid region id: %s", sid)
+	}
+	return key.KeyspaceId(append([]byte{prefix}, vhash(num)...)), nil
+}
+
+func init() {
+	planbuilder.Register("region_json", NewRegionJSON)
+}
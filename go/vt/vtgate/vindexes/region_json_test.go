@@ -0,0 +1,102 @@
+// Copyright 2015, Google Inc. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package vindexes
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/youtube/vitess/go/vt/key"
+	"github.com/youtube/vitess/go/vt/vtgate/planbuilder"
+)
+
+func createRegionJSON(t *testing.T, content string) (planbuilder.Vindex, error) {
+	f, err := ioutil.TempFile("", "region_json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	return planbuilder.CreateVindex("region_json", map[string]interface{}{"RegionMap": f.Name()})
+}
+
+func TestRegionJSONMap(t *testing.T) {
+	rj, err := createRegionJSON(t, `{"us": 1, "eu": 128}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rj.Cost() != 1 {
+		t.Errorf("Cost(): %d, want 1", rj.Cost())
+	}
+	got, err := rj.(planbuilder.Unique).Map(nil, []interface{}{"us:1", []byte("eu:1")})
+	if err != nil {
+		t.Error(err)
+	}
+	want := []key.KeyspaceId{
+		"\x01\x16k@\xb4J\xbaK\xd6",
+		"\x80\x16k@\xb4J\xbaK\xd6",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Map(): %#v, want %#v", got, want)
+	}
+	success, err := rj.Verify(nil, "eu:1", "\x80\x16k@\xb4J\xbaK\xd6")
+	if err != nil {
+		t.Error(err)
+	}
+	if !success {
+		t.Errorf("Verify(): %+v, want true", success)
+	}
+	if _, ok := rj.(planbuilder.Functional); !ok {
+		t.Errorf("regionJSON.(planbuilder.Functional): false, want true")
+	}
+}
+
+func TestRegionJSONMapBadData(t *testing.T) {
+	rj, err := createRegionJSON(t, `{"us": 1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testcases := []struct {
+		in   interface{}
+		want string
+	}{
+		{1, "RegionJSON.Map: unexpected type for 1: int"},
+		{"us", "RegionJSON.Map: invalid region id: us"},
+		{"eu:1", "RegionJSON.Map: unknown region: eu"},
+		{"us:a", "RegionJSON.Map: invalid region id: us:a"},
+	}
+	for _, tc := range testcases {
+		_, err := rj.(planbuilder.Unique).Map(nil, []interface{}{tc.in})
+		if err == nil || err.Error() != tc.want {
+			t.Errorf("Map(%v): %v, want %v", tc.in, err, tc.want)
+		}
+	}
+}
+
+func TestNewRegionJSONErrors(t *testing.T) {
+	_, err := planbuilder.CreateVindex("region_json", nil)
+	want := "NewRegionJSON: RegionMap param is required"
+	if err == nil || err.Error() != want {
+		t.Errorf("CreateVindex: %v, want %v", err, want)
+	}
+
+	_, err = createRegionJSON(t, `{"us": 256}`)
+	want = "prefix 256 for region us is out of range"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("CreateVindex: %v, want %v", err, want)
+	}
+
+	_, err = createRegionJSON(t, `{"us"`)
+	want = "unexpected end of JSON input"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("CreateVindex: %v, want %v", err, want)
+	}
+}
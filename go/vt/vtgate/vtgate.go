@@ -8,6 +8,7 @@ package vtgate
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"math"
 	"strings"
@@ -35,6 +36,8 @@ const errDupKey = "errno 1062"
 const errTxPoolFull = "tx_pool_full"
 
 var (
+	queryLogHandler = flag.String("query-log-stream-handler", "/debug/querylog", "URL handler for streaming queries log")
+
 	rpcVTGate *VTGate
 
 	qpsByOperation *stats.Rates
@@ -89,6 +92,12 @@ func Init(serv SrvTopoServer, schema *planbuilder.Schema, cell string, retryDela
 	if rpcVTGate != nil {
 		log.Fatalf("VTGate already initialized")
 	}
+	if *transactionMode == "" {
+		log.Fatalf("-transaction_mode cannot be empty")
+	}
+	if err := checkTransactionMode(*transactionMode); err != nil {
+		log.Fatalf("invalid -transaction_mode: %v", err)
+	}
 	rpcVTGate = &VTGate{
 		resolver:     NewResolver(serv, "VttabletCall", cell, retryDelay, retryCount, connTimeoutTotal, connTimeoutPerConn, connLife),
 		timings:      stats.NewMultiTimings("VtgateApi", []string{"Operation", "Keyspace", "DbType"}),
@@ -123,6 +132,8 @@ func Init(serv SrvTopoServer, schema *planbuilder.Schema, cell string, retryDela
 	errorsByKeyspace = stats.NewRates("ErrorsByKeyspace", stats.CounterForDimension(normalErrors, "Keyspace"), 15, 1*time.Minute)
 	errorsByDbType = stats.NewRates("ErrorsByDbType", stats.CounterForDimension(normalErrors, "DbType"), 15, 1*time.Minute)
 
+	QueryLogger.ServeLogs(*queryLogHandler, formatLogStats)
+
 	for _, f := range RegisterVTGates {
 		f(rpcVTGate)
 	}
@@ -156,13 +167,16 @@ func (vtg *VTGate) Execute(ctx context.Context, query *proto.Query, reply *proto
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "Execute", query.Sql, query.BindVariables, query.TabletType)
 	qr, err := vtg.router.Execute(ctx, query)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		logStats.RowsReturned = int64(len(qr.Rows))
 	} else {
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecute)
 	}
+	logStats.Send(err)
 	reply.Session = query.Session
 	return nil
 }
@@ -179,6 +193,7 @@ func (vtg *VTGate) ExecuteShard(ctx context.Context, query *proto.QueryShard, re
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "ExecuteShard", query.Sql, query.BindVariables, query.TabletType)
 	qr, err := vtg.resolver.Execute(
 		ctx,
 		query.Sql,
@@ -194,9 +209,11 @@ func (vtg *VTGate) ExecuteShard(ctx context.Context, query *proto.QueryShard, re
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		logStats.RowsReturned = int64(len(qr.Rows))
 	} else {
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecuteShard)
 	}
+	logStats.Send(err)
 	reply.Session = query.Session
 	return nil
 }
@@ -213,13 +230,16 @@ func (vtg *VTGate) ExecuteKeyspaceIds(ctx context.Context, query *proto.Keyspace
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "ExecuteKeyspaceIds", query.Sql, query.BindVariables, query.TabletType)
 	qr, err := vtg.resolver.ExecuteKeyspaceIds(ctx, query)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		logStats.RowsReturned = int64(len(qr.Rows))
 	} else {
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecuteKeyspaceIds)
 	}
+	logStats.Send(err)
 	reply.Session = query.Session
 	return nil
 }
@@ -236,13 +256,16 @@ func (vtg *VTGate) ExecuteKeyRanges(ctx context.Context, query *proto.KeyRangeQu
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "ExecuteKeyRanges", query.Sql, query.BindVariables, query.TabletType)
 	qr, err := vtg.resolver.ExecuteKeyRanges(ctx, query)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		logStats.RowsReturned = int64(len(qr.Rows))
 	} else {
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecuteKeyRanges)
 	}
+	logStats.Send(err)
 	reply.Session = query.Session
 	return nil
 }
@@ -259,13 +282,16 @@ func (vtg *VTGate) ExecuteEntityIds(ctx context.Context, query *proto.EntityIdsQ
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "ExecuteEntityIds", query.Sql, query.BindVariables, query.TabletType)
 	qr, err := vtg.resolver.ExecuteEntityIds(ctx, query)
 	if err == nil {
 		reply.Result = qr
 		vtg.rowsReturned.Add(statsKey, int64(len(qr.Rows)))
+		logStats.RowsReturned = int64(len(qr.Rows))
 	} else {
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecuteEntityIds)
 	}
+	logStats.Send(err)
 	reply.Session = query.Session
 	return nil
 }
@@ -282,6 +308,7 @@ func (vtg *VTGate) ExecuteBatchShard(ctx context.Context, batchQuery *proto.Batc
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "ExecuteBatchShard", batchSQL(batchQuery.Queries), nil, batchQuery.TabletType)
 	qrs, err := vtg.resolver.ExecuteBatch(
 		ctx,
 		batchQuery.Queries,
@@ -300,9 +327,11 @@ func (vtg *VTGate) ExecuteBatchShard(ctx context.Context, batchQuery *proto.Batc
 			rowCount += int64(len(qr.Rows))
 		}
 		vtg.rowsReturned.Add(statsKey, rowCount)
+		logStats.RowsReturned = rowCount
 	} else {
 		reply.Error = handleExecuteError(err, statsKey, batchQuery, vtg.logExecuteBatchShard)
 	}
+	logStats.Send(err)
 	reply.Session = batchQuery.Session
 	return nil
 }
@@ -319,6 +348,7 @@ func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, query *proto.Key
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "ExecuteBatchKeyspaceIds", batchSQL(query.Queries), nil, query.TabletType)
 	qrs, err := vtg.resolver.ExecuteBatchKeyspaceIds(
 		ctx,
 		query)
@@ -329,9 +359,11 @@ func (vtg *VTGate) ExecuteBatchKeyspaceIds(ctx context.Context, query *proto.Key
 			rowCount += int64(len(qr.Rows))
 		}
 		vtg.rowsReturned.Add(statsKey, rowCount)
+		logStats.RowsReturned = rowCount
 	} else {
 		reply.Error = handleExecuteError(err, statsKey, query, vtg.logExecuteBatchKeyspaceIds)
 	}
+	logStats.Send(err)
 	reply.Session = query.Session
 	return nil
 }
@@ -348,6 +380,7 @@ func (vtg *VTGate) StreamExecute(ctx context.Context, query *proto.Query, sendRe
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "StreamExecute", query.Sql, query.BindVariables, query.TabletType)
 	var rowCount int64
 	err := vtg.router.StreamExecute(
 		ctx,
@@ -361,6 +394,8 @@ func (vtg *VTGate) StreamExecute(ctx context.Context, query *proto.Query, sendRe
 			return sendReply(reply)
 		})
 	vtg.rowsReturned.Add(statsKey, rowCount)
+	logStats.RowsReturned = rowCount
+	logStats.Send(err)
 
 	if err != nil {
 		normalErrors.Add(statsKey, 1)
@@ -390,6 +425,7 @@ func (vtg *VTGate) StreamExecuteKeyspaceIds(ctx context.Context, query *proto.Ke
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "StreamExecuteKeyspaceIds", query.Sql, query.BindVariables, query.TabletType)
 	var rowCount int64
 	err := vtg.resolver.StreamExecuteKeyspaceIds(
 		ctx,
@@ -403,6 +439,8 @@ func (vtg *VTGate) StreamExecuteKeyspaceIds(ctx context.Context, query *proto.Ke
 			return sendReply(reply)
 		})
 	vtg.rowsReturned.Add(statsKey, rowCount)
+	logStats.RowsReturned = rowCount
+	logStats.Send(err)
 
 	if err != nil {
 		normalErrors.Add(statsKey, 1)
@@ -432,6 +470,7 @@ func (vtg *VTGate) StreamExecuteKeyRanges(ctx context.Context, query *proto.KeyR
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "StreamExecuteKeyRanges", query.Sql, query.BindVariables, query.TabletType)
 	var rowCount int64
 	err := vtg.resolver.StreamExecuteKeyRanges(
 		ctx,
@@ -445,6 +484,8 @@ func (vtg *VTGate) StreamExecuteKeyRanges(ctx context.Context, query *proto.KeyR
 			return sendReply(reply)
 		})
 	vtg.rowsReturned.Add(statsKey, rowCount)
+	logStats.RowsReturned = rowCount
+	logStats.Send(err)
 
 	if err != nil {
 		normalErrors.Add(statsKey, 1)
@@ -469,6 +510,7 @@ func (vtg *VTGate) StreamExecuteShard(ctx context.Context, query *proto.QuerySha
 		return errTooManyInFlight
 	}
 
+	logStats, ctx := newLogStats(ctx, "StreamExecuteShard", query.Sql, query.BindVariables, query.TabletType)
 	var rowCount int64
 	err := vtg.resolver.StreamExecute(
 		ctx,
@@ -490,6 +532,8 @@ func (vtg *VTGate) StreamExecuteShard(ctx context.Context, query *proto.QuerySha
 		},
 		query.NotInTransaction)
 	vtg.rowsReturned.Add(statsKey, rowCount)
+	logStats.RowsReturned = rowCount
+	logStats.Send(err)
 
 	if err != nil {
 		normalErrors.Add(statsKey, 1)
@@ -503,7 +547,11 @@ func (vtg *VTGate) StreamExecuteShard(ctx context.Context, query *proto.QuerySha
 }
 
 // Begin begins a transaction. It has to be concluded by a Commit or Rollback.
+// The transaction mode of outSession, if any, must be a known one.
 func (vtg *VTGate) Begin(ctx context.Context, outSession *proto.Session) error {
+	if err := checkTransactionMode(outSession.TransactionMode); err != nil {
+		return formatError(err)
+	}
 	outSession.InTransaction = true
 	return nil
 }
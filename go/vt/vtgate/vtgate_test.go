@@ -7,6 +7,7 @@ package vtgate
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -679,6 +680,27 @@ func TestVTGateStreamExecuteShard(t *testing.T) {
 	}
 }
 
+func TestVTGateBeginTransactionMode(t *testing.T) {
+	session := &proto.Session{TransactionMode: proto.TransactionModeTwoPC}
+	if err := rpcVTGate.Begin(context.Background(), session); err != nil {
+		t.Fatal(err)
+	}
+	want := &proto.Session{InTransaction: true, TransactionMode: proto.TransactionModeTwoPC}
+	if !reflect.DeepEqual(session, want) {
+		t.Errorf("want \n%+v, got \n%+v", want, session)
+	}
+
+	session = &proto.Session{TransactionMode: "twophase"}
+	err := rpcVTGate.Begin(context.Background(), session)
+	wantErr := `unknown transaction mode: "twophase", want single, multi or twopc`
+	if err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Errorf("want %s, got %v", wantErr, err)
+	}
+	if session.InTransaction {
+		t.Errorf("want the session out of the transaction")
+	}
+}
+
 func TestVTGateSplitQuery(t *testing.T) {
 	keyspace := "TestVTGateSplitQuery"
 	keyranges, _ := key.ParseShardingSpec(DefaultShardSpec)
@@ -7,6 +7,7 @@ package vtgateconn
 import (
 	"flag"
 	"fmt"
+	"sync"
 	"time"
 
 	log "github.com/golang/glog"
@@ -21,6 +22,8 @@ import (
 const (
 	// GoRPCProtocol is a vtgate protocol based on go rpc
 	GoRPCProtocol = "gorpc"
+	// GRPCProtocol is a vtgate protocol based on gRPC
+	GRPCProtocol = "grpc"
 )
 
 var (
@@ -47,48 +50,98 @@ func (e OperationalError) Error() string { return string(e) }
 // It can be used concurrently across goroutines.
 type VTGateConn struct {
 	impl Impl
+
+	// mu protects session and transactionMode.
+	mu sync.Mutex
+	// session is the read-after-write session of the connection,
+	// or nil if EnableReadAfterWrite was not called.
+	session interface{}
+	// transactionMode is the mode of the transactions of the
+	// connection, or empty for the default mode of vtgate.
+	transactionMode string
+}
+
+// SetTransactionMode sets the mode of the transactions the connection
+// begins from now on: single, multi or twopc. An empty mode stands for
+// the default mode of vtgate. vtgate rejects an unknown mode.
+func (conn *VTGateConn) SetTransactionMode(mode string) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	conn.transactionMode = mode
+}
+
+// EnableReadAfterWrite makes the reads of the connection see the
+// writes of the transactions it committed: a read on a replica or
+// rdonly tablet waits until the tablet has replicated the last
+// transaction committed on its shard. Only non-streaming reads
+// outside of a transaction wait. The transactions of the connection
+// that run concurrently don't wait for each other.
+func (conn *VTGateConn) EnableReadAfterWrite() {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.session == nil {
+		conn.session = conn.impl.ReadAfterWriteSession()
+	}
+}
+
+// readAfterWriteSession returns the read-after-write session
+// of the connection, or nil if it doesn't have one.
+func (conn *VTGateConn) readAfterWriteSession() interface{} {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return conn.session
+}
+
+// setReadAfterWriteSession replaces the read-after-write session
+// of the connection, if it has one.
+func (conn *VTGateConn) setReadAfterWriteSession(session interface{}) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.session != nil && session != nil {
+		conn.session = session
+	}
 }
 
 // Execute executes a non-streaming query on vtgate.
 // This is using v3 API.
 func (conn *VTGateConn) Execute(ctx context.Context, query string, bindVars map[string]interface{}, tabletType topo.TabletType) (*mproto.QueryResult, error) {
-	res, _, err := conn.impl.Execute(ctx, query, bindVars, tabletType, false, nil)
+	res, _, err := conn.impl.Execute(ctx, query, bindVars, tabletType, false, conn.readAfterWriteSession())
 	return res, err
 }
 
 // ExecuteShard executes a non-streaming query for multiple shards on vtgate.
 func (conn *VTGateConn) ExecuteShard(ctx context.Context, query string, keyspace string, shards []string, bindVars map[string]interface{}, tabletType topo.TabletType) (*mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteShard(ctx, query, keyspace, shards, bindVars, tabletType, false, nil)
+	res, _, err := conn.impl.ExecuteShard(ctx, query, keyspace, shards, bindVars, tabletType, false, conn.readAfterWriteSession())
 	return res, err
 }
 
 // ExecuteKeyspaceIds executes a non-streaming query for multiple keyspace_ids.
 func (conn *VTGateConn) ExecuteKeyspaceIds(ctx context.Context, query string, keyspace string, keyspaceIds []key.KeyspaceId, bindVars map[string]interface{}, tabletType topo.TabletType) (*mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteKeyspaceIds(ctx, query, keyspace, keyspaceIds, bindVars, tabletType, false, nil)
+	res, _, err := conn.impl.ExecuteKeyspaceIds(ctx, query, keyspace, keyspaceIds, bindVars, tabletType, false, conn.readAfterWriteSession())
 	return res, err
 }
 
 // ExecuteKeyRanges executes a non-streaming query on a key range.
 func (conn *VTGateConn) ExecuteKeyRanges(ctx context.Context, query string, keyspace string, keyRanges []key.KeyRange, bindVars map[string]interface{}, tabletType topo.TabletType) (*mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteKeyRanges(ctx, query, keyspace, keyRanges, bindVars, tabletType, false, nil)
+	res, _, err := conn.impl.ExecuteKeyRanges(ctx, query, keyspace, keyRanges, bindVars, tabletType, false, conn.readAfterWriteSession())
 	return res, err
 }
 
 // ExecuteEntityIds executes a non-streaming query for multiple entities.
 func (conn *VTGateConn) ExecuteEntityIds(ctx context.Context, query string, keyspace string, entityColumnName string, entityKeyspaceIDs []proto.EntityId, bindVars map[string]interface{}, tabletType topo.TabletType) (*mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteEntityIds(ctx, query, keyspace, entityColumnName, entityKeyspaceIDs, bindVars, tabletType, false, nil)
+	res, _, err := conn.impl.ExecuteEntityIds(ctx, query, keyspace, entityColumnName, entityKeyspaceIDs, bindVars, tabletType, false, conn.readAfterWriteSession())
 	return res, err
 }
 
 // ExecuteBatchShard executes a set of non-streaming queries for multiple shards.
 func (conn *VTGateConn) ExecuteBatchShard(ctx context.Context, queries []tproto.BoundQuery, keyspace string, shards []string, tabletType topo.TabletType) ([]mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteBatchShard(ctx, queries, keyspace, shards, tabletType, false, nil)
+	res, _, err := conn.impl.ExecuteBatchShard(ctx, queries, keyspace, shards, tabletType, false, conn.readAfterWriteSession())
 	return res, err
 }
 
 // ExecuteBatchKeyspaceIds executes a set of non-streaming queries for multiple keyspace ids.
 func (conn *VTGateConn) ExecuteBatchKeyspaceIds(ctx context.Context, queries []tproto.BoundQuery, keyspace string, keyspaceIds []key.KeyspaceId, tabletType topo.TabletType) ([]mproto.QueryResult, error) {
-	res, _, err := conn.impl.ExecuteBatchKeyspaceIds(ctx, queries, keyspace, keyspaceIds, tabletType, false, nil)
+	res, _, err := conn.impl.ExecuteBatchKeyspaceIds(ctx, queries, keyspace, keyspaceIds, tabletType, false, conn.readAfterWriteSession())
 	return res, err
 }
 
@@ -125,12 +178,16 @@ func (conn *VTGateConn) StreamExecuteKeyspaceIds(ctx context.Context, query stri
 
 // Begin starts a transaction and returns a VTGateTX.
 func (conn *VTGateConn) Begin(ctx context.Context) (*VTGateTx, error) {
-	session, err := conn.impl.Begin(ctx)
+	conn.mu.Lock()
+	inSession, transactionMode := conn.session, conn.transactionMode
+	conn.mu.Unlock()
+	session, err := conn.impl.Begin(ctx, inSession, transactionMode)
 	if err != nil {
 		return nil, err
 	}
 
 	return &VTGateTx{
+		conn:    conn,
 		impl:    conn.impl,
 		session: session,
 	}, nil
@@ -151,6 +208,7 @@ func (conn *VTGateConn) SplitQuery(ctx context.Context, keyspace string, query t
 // VTGateTx defines an ongoing transaction.
 // It should not be concurrently used across goroutines.
 type VTGateTx struct {
+	conn    *VTGateConn
 	impl    Impl
 	session interface{}
 }
@@ -230,8 +288,11 @@ func (tx *VTGateTx) Commit(ctx context.Context) error {
 	if tx.session == nil {
 		return fmt.Errorf("commit: not in transaction")
 	}
-	err := tx.impl.Commit(ctx, tx.session)
+	session, err := tx.impl.Commit(ctx, tx.session)
 	tx.session = nil
+	if err == nil {
+		tx.conn.setReadAfterWriteSession(session)
+	}
 	return err
 }
 
@@ -289,10 +350,14 @@ type Impl interface {
 	StreamExecuteKeyspaceIds(ctx context.Context, query string, keyspace string, keyspaceIds []key.KeyspaceId, bindVars map[string]interface{}, tabletType topo.TabletType) (<-chan *mproto.QueryResult, ErrFunc)
 
 	// Begin starts a transaction and returns a VTGateTX.
-	Begin(ctx context.Context) (interface{}, error)
+	// The read-after-write state of session, if not nil,
+	// is carried over to the transaction, which runs in
+	// transactionMode, or the default mode if it's empty.
+	Begin(ctx context.Context, session interface{}, transactionMode string) (interface{}, error)
 
-	// Commit commits the current transaction.
-	Commit(ctx context.Context, session interface{}) error
+	// Commit commits the current transaction. It returns the
+	// session without the transaction, with its write positions.
+	Commit(ctx context.Context, session interface{}) (interface{}, error)
 
 	// Rollback rolls back the current transaction.
 	Rollback(ctx context.Context, session interface{}) error
@@ -301,6 +366,10 @@ type Impl interface {
 	// appending primary key range clauses to the original query
 	SplitQuery(ctx context.Context, keyspace string, query tproto.BoundQuery, splitCount int) ([]proto.SplitQueryPart, error)
 
+	// ReadAfterWriteSession returns a new session, outside of any
+	// transaction, whose reads see the writes it committed.
+	ReadAfterWriteSession() interface{}
+
 	// Close must be called for releasing resources.
 	Close()
 }
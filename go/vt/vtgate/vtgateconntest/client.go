@@ -295,6 +295,17 @@ func (f *fakeVTGateService) Begin(ctx context.Context, outSession *proto.Session
 	if f.panics {
 		panic(fmt.Errorf("test forced panic"))
 	}
+	if outSession.ReadAfterWrite {
+		*outSession = *readAfterWriteSession1
+		return nil
+	}
+	if outSession.TransactionMode != "" {
+		if outSession.TransactionMode != transactionModeSession1.TransactionMode {
+			return errors.New("begin: transaction mode mismatch")
+		}
+		*outSession = *transactionModeSession1
+		return nil
+	}
 	*outSession = *session1
 	return nil
 }
@@ -304,6 +315,20 @@ func (f *fakeVTGateService) Commit(ctx context.Context, inSession *proto.Session
 	if f.panics {
 		panic(fmt.Errorf("test forced panic"))
 	}
+	if inSession.TransactionMode != "" {
+		if !reflect.DeepEqual(inSession, transactionModeSession1) {
+			return errors.New("commit: session mismatch")
+		}
+		*inSession = proto.Session{TransactionMode: inSession.TransactionMode}
+		return nil
+	}
+	if inSession.ReadAfterWrite {
+		if !reflect.DeepEqual(inSession, readAfterWriteSession1) {
+			return errors.New("commit: session mismatch")
+		}
+		*inSession = *readAfterWriteSession2
+		return nil
+	}
 	if !reflect.DeepEqual(inSession, session2) {
 		return errors.New("commit: session mismatch")
 	}
@@ -370,6 +395,8 @@ func TestSuite(t *testing.T, impl vtgateconn.Impl, fakeServer vtgateservice.VTGa
 	testTxPassNotInTransaction(t, conn)
 	testTxFail(t, conn)
 	testSplitQuery(t, conn)
+	testTransactionMode(t, conn)
+	testReadAfterWrite(t, conn)
 
 	// force a panic at every call, then test that works
 	fakeServer.(*fakeVTGateService).panics = true
@@ -993,6 +1020,42 @@ func testBeginPanic(t *testing.T, conn *vtgateconn.VTGateConn) {
 	expectPanic(t, err)
 }
 
+func testTransactionMode(t *testing.T, conn *vtgateconn.VTGateConn) {
+	ctx := context.Background()
+	conn.SetTransactionMode(proto.TransactionModeTwoPC)
+	defer conn.SetTransactionMode("")
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the fake server only accepts the commit of a twopc session
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testReadAfterWrite(t *testing.T, conn *vtgateconn.VTGateConn) {
+	ctx := context.Background()
+	conn.EnableReadAfterWrite()
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the read carries the write positions of the commit
+	execCase := execMap["readAfterWriteRequest"]
+	qr, err := conn.Execute(ctx, execCase.execQuery.Sql, execCase.execQuery.BindVariables, execCase.execQuery.TabletType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(qr, execCase.reply.Result) {
+		t.Errorf("Unexpected result from Execute: got %+v want %+v", qr, execCase.reply.Result)
+	}
+}
+
 func testTxFail(t *testing.T, conn *vtgateconn.VTGateConn) {
 	ctx := context.Background()
 	tx, err := conn.Begin(ctx)
@@ -1289,6 +1352,19 @@ var execMap = map[string]struct {
 			Error:   "app error",
 		},
 	},
+	"readAfterWriteRequest": {
+		execQuery: &proto.Query{
+			Sql:           "readAfterWriteRequest",
+			BindVariables: map[string]interface{}{},
+			TabletType:    topo.TYPE_REPLICA,
+			Session:       readAfterWriteSession2,
+		},
+		reply: &proto.QueryResult{
+			Result:  &result1,
+			Session: readAfterWriteSession2,
+			Error:   "",
+		},
+	},
 	"txRequest": {
 		execQuery: &proto.Query{
 			Sql:           "txRequest",
@@ -1512,8 +1588,11 @@ var result1 = mproto.QueryResult{
 }
 
 var session1 = &proto.Session{
-	InTransaction: true,
-	ShardSessions: []*proto.ShardSession{},
+	InTransaction:  true,
+	ShardSessions:  []*proto.ShardSession{},
+	PreSessions:    []*proto.ShardSession{},
+	PostSessions:   []*proto.ShardSession{},
+	WritePositions: []*proto.WritePosition{},
 }
 
 var session2 = &proto.Session{
@@ -1526,6 +1605,41 @@ var session2 = &proto.Session{
 			TransactionId: 1,
 		},
 	},
+	PreSessions:    []*proto.ShardSession{},
+	PostSessions:   []*proto.ShardSession{},
+	WritePositions: []*proto.WritePosition{},
+}
+
+var transactionModeSession1 = &proto.Session{
+	InTransaction:   true,
+	ShardSessions:   []*proto.ShardSession{},
+	PreSessions:     []*proto.ShardSession{},
+	PostSessions:    []*proto.ShardSession{},
+	TransactionMode: proto.TransactionModeTwoPC,
+	WritePositions:  []*proto.WritePosition{},
+}
+
+var readAfterWriteSession1 = &proto.Session{
+	InTransaction:  true,
+	ShardSessions:  []*proto.ShardSession{},
+	PreSessions:    []*proto.ShardSession{},
+	PostSessions:   []*proto.ShardSession{},
+	ReadAfterWrite: true,
+	WritePositions: []*proto.WritePosition{},
+}
+
+var readAfterWriteSession2 = &proto.Session{
+	ShardSessions:  []*proto.ShardSession{},
+	PreSessions:    []*proto.ShardSession{},
+	PostSessions:   []*proto.ShardSession{},
+	ReadAfterWrite: true,
+	WritePositions: []*proto.WritePosition{
+		&proto.WritePosition{
+			Keyspace: "ks",
+			Shard:    "1",
+			Position: "MySQL56/33333333-3333-3333-3333-333333333333:1-123",
+		},
+	},
 }
 
 var splitQueryRequest = &proto.SplitQueryRequest{